@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/kubermatic/kube-machine/pkg/agent"
+)
+
+// kube-machine-agent runs on the node and fetches its bootstrap, i.e. the
+// kubeconfig, kubelet configuration and certificates, from the cluster or the
+// kube-machine API, so nothing has to be pushed to the node.
+func main() {
+	configPath := flag.String("config", agent.DefaultConfigPath, "Configuration of the agent")
+	stateDir := flag.String("state-dir", agent.DefaultStateDir, "Directory of the state of the agent")
+	interval := flag.Duration("interval", 30*time.Second, "Interval of polling for a new bootstrap")
+	once := flag.Bool("once", false, "Exit after the first bootstrap was applied")
+	flag.Parse()
+
+	config, err := agent.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client, err := agent.NewClient(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The kube-machine API exchanges the one-time token for a session token,
+	// which fetches the later generations, e.g. of a reprovisioning.
+	if config.Mode == agent.ModeAPI {
+		if err := client.UseTokenFile(agent.TokenPath(*stateDir)); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for {
+		applied, err := agent.Run(client, *stateDir)
+		if err != nil {
+			log.Print(err)
+		}
+		if applied && *once {
+			log.Printf("Bootstrap of %s applied", config.Machine)
+			return
+		}
+		time.Sleep(*interval)
+	}
+}
@@ -9,7 +9,6 @@ import (
 
 	"github.com/codegangsta/cli"
 	"github.com/docker/machine/commands"
-	"github.com/docker/machine/commands/mcndirs"
 	"github.com/docker/machine/drivers/amazonec2"
 	"github.com/docker/machine/drivers/azure"
 	"github.com/docker/machine/drivers/digitalocean"
@@ -29,6 +28,9 @@ import (
 	"github.com/docker/machine/libmachine/drivers/plugin/localbinary"
 	"github.com/docker/machine/libmachine/log"
 	"github.com/docker/machine/version"
+
+	"github.com/kubermatic/kube-machine/pkg/drivers/libvirt"
+	"github.com/kubermatic/kube-machine/pkg/logging"
 )
 
 var AppHelpTemplate = `Usage: {{.Name}} {{if .Flags}}[OPTIONS] {{end}}COMMAND [arg...]
@@ -62,11 +64,15 @@ Options:
    {{.}}{{end}}{{ end }}
 `
 
+// debugOutput is set if debug output was enabled by setDebugOutputLevel.
+var debugOutput bool
+
 func setDebugOutputLevel() {
 	// TODO: I'm not really a fan of this method and really would rather
 	// use -v / --verbose TBQH
 	for _, f := range os.Args {
 		if f == "-D" || f == "--debug" || f == "-debug" {
+			debugOutput = true
 			log.SetDebug(true)
 		}
 	}
@@ -78,6 +84,7 @@ func setDebugOutputLevel() {
 			fmt.Fprintf(os.Stderr, "Error parsing boolean value from MACHINE_DEBUG: %s\n", err)
 			os.Exit(1)
 		}
+		debugOutput = showDebug
 		log.SetDebug(showDebug)
 	}
 }
@@ -103,67 +110,13 @@ func main() {
 	app.CommandNotFound = cmdNotFound
 	app.Usage = "Create and manage Kubernetes nodes."
 	app.Version = version.FullVersion()
+	app.Before = func(c *cli.Context) error {
+		return logging.Configure(c.GlobalString("log-format"), debugOutput)
+	}
 
 	log.Debug("Kube Machine Version: ", app.Version)
 
-	app.Flags = []cli.Flag{
-		cli.BoolFlag{
-			Name:  "debug, D",
-			Usage: "Enable debug mode",
-		},
-		cli.StringFlag{
-			EnvVar: "MACHINE_STORAGE_PATH",
-			Name:   "storage-path, s",
-			Value:  mcndirs.GetBaseDir(),
-			Usage:  "Configures storage path",
-		},
-		cli.StringFlag{
-			EnvVar: "MACHINE_TLS_CA_CERT",
-			Name:   "tls-ca-cert",
-			Usage:  "CA to verify remotes against",
-			Value:  "",
-		},
-		cli.StringFlag{
-			EnvVar: "MACHINE_TLS_CA_KEY",
-			Name:   "tls-ca-key",
-			Usage:  "Private key to generate certificates",
-			Value:  "",
-		},
-		cli.StringFlag{
-			EnvVar: "MACHINE_TLS_CLIENT_CERT",
-			Name:   "tls-client-cert",
-			Usage:  "Client cert to use for TLS",
-			Value:  "",
-		},
-		cli.StringFlag{
-			EnvVar: "MACHINE_TLS_CLIENT_KEY",
-			Name:   "tls-client-key",
-			Usage:  "Private key used in client TLS auth",
-			Value:  "",
-		},
-		cli.StringFlag{
-			EnvVar: "MACHINE_GITHUB_API_TOKEN",
-			Name:   "github-api-token",
-			Usage:  "Token to use for requests to the Github API",
-			Value:  "",
-		},
-		cli.BoolFlag{
-			EnvVar: "MACHINE_NATIVE_SSH",
-			Name:   "native-ssh",
-			Usage:  "Use the native (Go-based) SSH implementation.",
-		},
-		cli.StringFlag{
-			EnvVar: "MACHINE_BUGSNAG_API_TOKEN",
-			Name:   "bugsnag-api-token",
-			Usage:  "BugSnag API token for crash reporting",
-			Value:  "",
-		},
-		cli.StringFlag{
-			Name: "kubeconfig",
-			Usage: "The Kubernetes client config file to create nodes",
-			Value: "",
-		},
-	}
+	app.Flags = commands.GlobalFlags
 
 	if err := app.Run(os.Args); err != nil {
 		log.Error(err)
@@ -186,6 +139,8 @@ func runDriver(driverName string) {
 		plugin.RegisterDriver(google.NewDriver("", ""))
 	case "hyperv":
 		plugin.RegisterDriver(hyperv.NewDriver("", ""))
+	case "libvirt":
+		plugin.RegisterDriver(libvirt.NewDriver("", ""))
 	case "none":
 		plugin.RegisterDriver(none.NewDriver("", ""))
 	case "openstack":
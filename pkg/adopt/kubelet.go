@@ -0,0 +1,80 @@
+package adopt
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Runner runs commands on a machine, it is implemented by *host.Host.
+type Runner interface {
+	RunSSHCommand(command string) (string, error)
+}
+
+// Kubelet is the configuration of a kubelet not set up by kube-machine,
+// inferred from its command line.
+type Kubelet struct {
+	Version string `json:"version,omitempty"`
+	// NodeName is the name the kubelet registers the node with.
+	NodeName   string            `json:"nodeName"`
+	Kubeconfig string            `json:"kubeconfig,omitempty"`
+	Flags      map[string]string `json:"flags"`
+}
+
+var errNoKubelet = errors.New("No kubelet is running on the machine")
+
+// The process is named kubelet or runs the kubelet of hyperkube.
+const kubeletCmdline = `pid=$(pgrep -o -x kubelet || pgrep -o -f 'hyperkube kubelet'); test -n "$pid" && sudo cat /proc/$pid/cmdline | tr '\0' '\n'`
+
+// Inspect infers the configuration of the kubelet running on the machine.
+func Inspect(r Runner) (*Kubelet, error) {
+	out, err := r.RunSSHCommand(kubeletCmdline)
+	if err != nil || strings.TrimSpace(out) == "" {
+		return nil, errNoKubelet
+	}
+	args := strings.Split(strings.TrimSpace(out), "\n")
+	k := ParseCommandLine(args)
+
+	if k.NodeName == "" {
+		hostname, err := r.RunSSHCommand("hostname")
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get the hostname: %v", err)
+		}
+		k.NodeName = strings.ToLower(strings.TrimSpace(hostname))
+	}
+
+	versionCmd := "sudo " + args[0] + " --version"
+	if path.Base(args[0]) == "hyperkube" {
+		versionCmd = "sudo " + args[0] + " kubelet --version"
+	}
+	if out, err := r.RunSSHCommand(versionCmd); err == nil {
+		k.Version = strings.TrimPrefix(strings.TrimSpace(out), "Kubernetes ")
+	}
+	return k, nil
+}
+
+// ParseCommandLine returns the configuration given by the arguments of the
+// kubelet, the first one is the binary.
+func ParseCommandLine(args []string) *Kubelet {
+	k := &Kubelet{Flags: map[string]string{}}
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name := strings.TrimLeft(arg, "-")
+		value := "true"
+		if parts := strings.SplitN(name, "=", 2); len(parts) == 2 {
+			name, value = parts[0], parts[1]
+		} else if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+			value = args[i+1]
+			i++
+		}
+		k.Flags[name] = value
+	}
+
+	k.NodeName = strings.ToLower(k.Flags["hostname-override"])
+	k.Kubeconfig = k.Flags["kubeconfig"]
+	return k
+}
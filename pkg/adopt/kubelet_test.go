@@ -0,0 +1,61 @@
+package adopt
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCommandLine(t *testing.T) {
+	k := ParseCommandLine([]string{
+		"/usr/local/bin/hyperkube", "kubelet",
+		"--kubeconfig=/var/lib/kubelet/kubeconfig",
+		"--require-kubeconfig",
+		"--hostname-override", "Worker-1",
+		"-v", "2",
+	})
+
+	expected := &Kubelet{
+		NodeName:   "worker-1",
+		Kubeconfig: "/var/lib/kubelet/kubeconfig",
+		Flags: map[string]string{
+			"kubeconfig":         "/var/lib/kubelet/kubeconfig",
+			"require-kubeconfig": "true",
+			"hostname-override":  "Worker-1",
+			"v":                  "2",
+		},
+	}
+	if !reflect.DeepEqual(k, expected) {
+		t.Errorf("expected %+v, got %+v", expected, k)
+	}
+}
+
+type fakeRunner map[string]string
+
+func (r fakeRunner) RunSSHCommand(command string) (string, error) {
+	for prefix, out := range r {
+		if strings.HasPrefix(command, prefix) {
+			return out, nil
+		}
+	}
+	return "", errors.New("command failed")
+}
+
+func TestInspect(t *testing.T) {
+	k, err := Inspect(fakeRunner{
+		"pid=":                            "/opt/bin/kubelet\n--kubeconfig=/etc/kubernetes/kubelet.conf\n",
+		"hostname":                        "node-1\n",
+		"sudo /opt/bin/kubelet --version": "Kubernetes v1.5.3\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.NodeName != "node-1" || k.Version != "v1.5.3" || k.Kubeconfig != "/etc/kubernetes/kubelet.conf" {
+		t.Errorf("unexpected kubelet %+v", k)
+	}
+
+	if _, err := Inspect(fakeRunner{}); err != errNoKubelet {
+		t.Errorf("expected %v, got %v", errNoKubelet, err)
+	}
+}
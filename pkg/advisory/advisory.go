@@ -0,0 +1,263 @@
+package advisory
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+const (
+	// DataFile is the name of the data file in the storage path.
+	DataFile = "advisories.yaml"
+
+	KindEOL = "eol"
+	KindCVE = "cve"
+
+	// ComponentOS matches CVEs against the OS release of the node,
+	// ComponentKubelet against its kubelet version.
+	ComponentOS      = "os"
+	ComponentKubelet = "kubelet"
+)
+
+// Data is the offline end-of-life and CVE data the machines are checked
+// against, it is refreshed from a URL.
+type Data struct {
+	Updated time.Time `json:"updated,omitempty"`
+	// OS are the end-of-life dates of OS releases.
+	OS []Release `json:"os,omitempty"`
+	// Kubelet are the end-of-life dates of Kubernetes minor versions.
+	Kubelet []Release `json:"kubelet,omitempty"`
+	CVEs    []CVE     `json:"cves,omitempty"`
+}
+
+// Release is a release of the OS, e.g. ubuntu 16.04, or a minor version of
+// Kubernetes, e.g. 1.6, and its end of life.
+type Release struct {
+	ID      string    `json:"id,omitempty"`
+	Version string    `json:"version"`
+	EOL     time.Time `json:"eol"`
+}
+
+// CVE is a known vulnerability of a component.
+type CVE struct {
+	ID        string `json:"id"`
+	Severity  string `json:"severity,omitempty"`
+	Summary   string `json:"summary,omitempty"`
+	Component string `json:"component"`
+	// Releases are the affected OS releases as "<id> <version>".
+	Releases []string `json:"releases,omitempty"`
+	// Fixed are the first fixed kubelet versions of the minor versions, a
+	// version is affected if its minor version has a later fix or is older
+	// than all of them.
+	Fixed []string `json:"fixed,omitempty"`
+}
+
+// Load reads the data of a YAML or JSON file.
+func Load(path string) (*Data, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	d := &Data{}
+	if err := yaml.Unmarshal(data, d); err != nil {
+		return nil, fmt.Errorf("Failed to parse advisory data %s: %v", path, err)
+	}
+	for _, cve := range d.CVEs {
+		if cve.Component != ComponentOS && cve.Component != ComponentKubelet {
+			return nil, fmt.Errorf("Invalid advisory data %s: %s has unknown component %q", path, cve.ID, cve.Component)
+		}
+	}
+	return d, nil
+}
+
+// Refresh downloads the data from the URL and replaces the data file with it
+// once it is valid.
+func Refresh(url, path string) (*Data, error) {
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to download advisory data: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to download advisory data: %s responded with %s", url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to download advisory data: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return nil, err
+	}
+	d, err := Load(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return d, os.Rename(tmp, path)
+}
+
+// Node is what is checked of a machine, as reported by its node.
+type Node struct {
+	Name string
+	// OSImage is e.g. "Ubuntu 16.04.2 LTS".
+	OSImage        string
+	KubeletVersion string
+}
+
+// Finding is an advisory affecting a machine.
+type Finding struct {
+	Kind      string `json:"kind"`
+	Component string `json:"component"`
+	// ID is the CVE or the release which reached its end of life.
+	ID     string `json:"id"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func (f Finding) String() string {
+	if f.Kind == KindEOL {
+		return fmt.Sprintf("%s %s end of life", f.Component, f.ID)
+	}
+	return f.ID
+}
+
+// Report is the result of checking a machine.
+type Report struct {
+	Machine        string    `json:"machine"`
+	OSImage        string    `json:"osImage"`
+	KubeletVersion string    `json:"kubeletVersion"`
+	Findings       []Finding `json:"findings,omitempty"`
+}
+
+// NeedsReplacement returns whether the machine should be replaced with one of
+// a supported OS release and kubelet version.
+func (r Report) NeedsReplacement() bool {
+	return len(r.Findings) > 0
+}
+
+// Check returns the advisories affecting the node at the time.
+func (d *Data) Check(node Node, now time.Time) Report {
+	r := Report{Machine: node.Name, OSImage: node.OSImage, KubeletVersion: node.KubeletVersion}
+
+	for _, release := range d.OS {
+		if matchesOS(node.OSImage, release.ID+" "+release.Version) && !release.EOL.IsZero() && now.After(release.EOL) {
+			r.Findings = append(r.Findings, Finding{
+				Kind: KindEOL, Component: ComponentOS, ID: release.ID + " " + release.Version,
+				Detail: "end of life since " + release.EOL.Format("2006-01-02"),
+			})
+		}
+	}
+	for _, release := range d.Kubelet {
+		if sameMinor(node.KubeletVersion, release.Version) && !release.EOL.IsZero() && now.After(release.EOL) {
+			r.Findings = append(r.Findings, Finding{
+				Kind: KindEOL, Component: ComponentKubelet, ID: release.Version,
+				Detail: "end of life since " + release.EOL.Format("2006-01-02"),
+			})
+		}
+	}
+	for _, cve := range d.CVEs {
+		if cve.affects(node) {
+			r.Findings = append(r.Findings, Finding{Kind: KindCVE, Component: cve.Component, ID: cve.ID, Detail: cve.Summary})
+		}
+	}
+	return r
+}
+
+func (c CVE) affects(node Node) bool {
+	if c.Component == ComponentOS {
+		for _, release := range c.Releases {
+			if matchesOS(node.OSImage, release) {
+				return true
+			}
+		}
+		return false
+	}
+
+	version := parseVersion(node.KubeletVersion)
+	if version == nil {
+		return false
+	}
+	olderThanAll := true
+	for _, fixed := range c.Fixed {
+		f := parseVersion(fixed)
+		if f == nil {
+			continue
+		}
+		if compare(version, f) >= 0 {
+			olderThanAll = false
+		}
+		if len(f) > 1 && version[0] == f[0] && version[1] == f[1] {
+			return compare(version, f) < 0
+		}
+	}
+	return olderThanAll && len(c.Fixed) > 0
+}
+
+// matchesOS returns whether the OS image is of the release "<id> <version>",
+// e.g. "Ubuntu 16.04.2 LTS" is of "ubuntu 16.04".
+func matchesOS(osImage, release string) bool {
+	image := strings.Fields(strings.ToLower(osImage))
+	r := strings.Fields(strings.ToLower(release))
+	if len(r) != 2 {
+		return false
+	}
+	for i := 0; i+1 < len(image); i++ {
+		if image[i] == r[0] && (image[i+1] == r[1] || strings.HasPrefix(image[i+1], r[1]+".")) {
+			return true
+		}
+	}
+	return false
+}
+
+var versionPattern = regexp.MustCompile(`^v?(\d+(\.\d+)*)`)
+
+// parseVersion returns the numeric parts of a version like v1.6.4+coreos.0.
+func parseVersion(s string) []int {
+	m := versionPattern.FindStringSubmatch(s)
+	if m == nil {
+		return nil
+	}
+	var parts []int
+	for _, p := range strings.Split(m[1], ".") {
+		n, _ := strconv.Atoi(p)
+		parts = append(parts, n)
+	}
+	return parts
+}
+
+func compare(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func sameMinor(version, minor string) bool {
+	v, m := parseVersion(version), parseVersion(minor)
+	return len(v) >= 2 && len(m) >= 2 && v[0] == m[0] && v[1] == m[1]
+}
@@ -0,0 +1,107 @@
+package advisory
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testData = `updated: 2018-01-01T00:00:00Z
+os:
+- id: ubuntu
+  version: "14.04"
+  eol: 2019-04-30T00:00:00Z
+- id: ubuntu
+  version: "16.04"
+  eol: 2021-04-30T00:00:00Z
+kubelet:
+- version: "1.6"
+  eol: 2017-12-15T00:00:00Z
+cves:
+- id: CVE-2017-1002101
+  component: kubelet
+  summary: subpath volume mounts can access files outside of the volume
+  fixed: ["1.7.14", "1.8.9", "1.9.4"]
+- id: CVE-2017-5754
+  component: os
+  releases: ["ubuntu 14.04"]
+`
+
+func TestCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "advisory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, DataFile)
+	if err := ioutil.WriteFile(path, []byte(testData), 0600); err != nil {
+		t.Fatal(err)
+	}
+	d, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	cve := Finding{Kind: KindCVE, Component: ComponentKubelet, ID: "CVE-2017-1002101", Detail: "subpath volume mounts can access files outside of the volume"}
+	for _, test := range []struct {
+		node     Node
+		expected []Finding
+	}{
+		{Node{OSImage: "Ubuntu 16.04.3 LTS", KubeletVersion: "v1.9.4"}, nil},
+		{Node{OSImage: "Ubuntu 16.04.3 LTS", KubeletVersion: "v1.8.7"}, []Finding{cve}},
+		{Node{OSImage: "Ubuntu 16.04.3 LTS", KubeletVersion: "v1.10.0"}, nil},
+		{Node{OSImage: "Ubuntu 16.04.3 LTS", KubeletVersion: "v1.6.4+coreos.0"}, []Finding{
+			{Kind: KindEOL, Component: ComponentKubelet, ID: "1.6", Detail: "end of life since 2017-12-15"},
+			cve,
+		}},
+		{Node{OSImage: "Ubuntu 14.04.5 LTS", KubeletVersion: "v1.9.6"}, []Finding{
+			{Kind: KindEOL, Component: ComponentOS, ID: "ubuntu 14.04", Detail: "end of life since 2019-04-30"},
+			{Kind: KindCVE, Component: ComponentOS, ID: "CVE-2017-5754"},
+		}},
+	} {
+		r := d.Check(test.node, now)
+		if !reflect.DeepEqual(r.Findings, test.expected) {
+			t.Errorf("%+v: expected %+v, got %+v", test.node, test.expected, r.Findings)
+		}
+		if r.NeedsReplacement() != (len(test.expected) > 0) {
+			t.Errorf("%+v: unexpected replacement %v", test.node, r.NeedsReplacement())
+		}
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	dir, err := ioutil.TempDir("", "advisory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	body := testData
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(dir, DataFile)
+	d, err := Refresh(server.URL, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.CVEs) != 2 {
+		t.Errorf("Expected 2 CVEs, got %+v", d.CVEs)
+	}
+
+	body = "cves:\n- id: CVE-1\n  component: kernel\n"
+	if _, err := Refresh(server.URL, path); err == nil {
+		t.Error("Expected invalid data to be refused")
+	}
+	if d, err := Load(path); err != nil || len(d.CVEs) != 2 {
+		t.Errorf("Expected the previous data to be kept, got %+v, %v", d, err)
+	}
+}
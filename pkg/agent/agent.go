@@ -0,0 +1,331 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The agent only depends on the standard library, so the binary stays small
+// enough to be embedded in images or downloaded by user-data.
+
+const (
+	// ModeCluster reads the bootstrap secret of the node with the token of
+	// its service account.
+	ModeCluster = "cluster"
+	// ModeAPI fetches the bootstrap from the kube-machine API with a one-time
+	// token, which the API exchanges for a session token on first use.
+	ModeAPI = "api"
+
+	DefaultConfigPath = "/etc/kube-machine/agent/config.json"
+	DefaultStateDir   = "/var/lib/kube-machine/agent"
+
+	// Namespace holds the bootstrap secrets and the accounts of the agents.
+	Namespace = "kube-system"
+	// ScriptKey is the key of the bootstrap script in the secret.
+	ScriptKey = "bootstrap.sh"
+
+	// GenerationAnnotationKey is set by kube-machine on every change of the
+	// script, the agent runs each generation once.
+	GenerationAnnotationKey = "kube-machine.kubermatic.io/generation"
+	// AppliedAnnotationKey is the generation the agent ran successfully.
+	AppliedAnnotationKey = "kube-machine.kubermatic.io/applied-generation"
+	// ErrorAnnotationKey is "<generation>: <output>" of a failed run.
+	ErrorAnnotationKey = "kube-machine.kubermatic.io/agent-error"
+
+	// BootstrapPath is the prefix of the bootstrap endpoints of the
+	// kube-machine API.
+	BootstrapPath = "/v1/bootstrap/"
+	// TokenHeader carries the session token the kube-machine API issues in
+	// exchange for the one-time token.
+	TokenHeader = "X-Kube-Machine-Agent-Token"
+
+	requestTimeout = 30 * time.Second
+	outputLines    = 5
+)
+
+// SecretName returns the name of the bootstrap secret of the node.
+func SecretName(node string) string {
+	return "kube-machine-bootstrap-" + node
+}
+
+// Config is the configuration of the agent of a node.
+type Config struct {
+	Mode string `json:"mode"`
+	// Server is the API server of the cluster or the kube-machine API.
+	Server string `json:"server"`
+	// CA is the PEM bundle verifying the server, the system roots are used
+	// without one.
+	CA      string `json:"ca,omitempty"`
+	Token   string `json:"token"`
+	Machine string `json:"machine"`
+}
+
+// LoadConfig reads the configuration of the agent.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %v", path, err)
+	}
+	if config.Mode != ModeCluster && config.Mode != ModeAPI {
+		return nil, fmt.Errorf("Unknown mode %q in %s", config.Mode, path)
+	}
+	if config.Server == "" || config.Token == "" || config.Machine == "" {
+		return nil, fmt.Errorf("The server, token and machine are required in %s", path)
+	}
+	return config, nil
+}
+
+// Bootstrap is a generation of the bootstrap script of a node.
+type Bootstrap struct {
+	Generation string `json:"generation"`
+	Script     []byte `json:"script"`
+}
+
+// Status is the result of a run of the bootstrap script.
+type Status struct {
+	Generation string `json:"generation"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Client fetches the bootstrap of the node and reports its result.
+type Client struct {
+	config    *Config
+	http      *http.Client
+	tokenPath string
+}
+
+// NewClient returns the client of the configuration.
+func NewClient(config *Config) (*Client, error) {
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if config.CA != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(config.CA)) {
+			return nil, fmt.Errorf("No certificates in the CA of the agent")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &Client{config: config, http: &http.Client{Transport: transport, Timeout: requestTimeout}}, nil
+}
+
+// TokenPath returns the path of the session token of the agent in its state
+// directory.
+func TokenPath(stateDir string) string {
+	return filepath.Join(stateDir, "token")
+}
+
+// UseTokenFile reads the session token of the agent from the file if the
+// kube-machine API issued one before, and writes the session token to it
+// once it is issued. The one-time token of the configuration is invalid
+// after its first use.
+func (c *Client) UseTokenFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err == nil && len(bytes.TrimSpace(data)) > 0 {
+		c.config.Token = string(bytes.TrimSpace(data))
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	c.tokenPath = path
+	return nil
+}
+
+// exchangeToken replaces the one-time token by the session token of the
+// response.
+func (c *Client) exchangeToken(token string) error {
+	if c.tokenPath != "" {
+		if err := os.MkdirAll(filepath.Dir(c.tokenPath), 0700); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(c.tokenPath, []byte(token+"\n"), 0600); err != nil {
+			return fmt.Errorf("Failed to save the session token: %v", err)
+		}
+	}
+	c.config.Token = token
+	return nil
+}
+
+func (c *Client) url() string {
+	server := strings.TrimSuffix(c.config.Server, "/")
+	if c.config.Mode == ModeCluster {
+		return fmt.Sprintf("%s/api/v1/namespaces/%s/secrets/%s", server, Namespace, SecretName(c.config.Machine))
+	}
+	return server + BootstrapPath + url.QueryEscape(c.config.Machine)
+}
+
+func (c *Client) do(method, u, contentType string, body []byte) ([]byte, int, error) {
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.config.Token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if token := resp.Header.Get(TokenHeader); token != "" && c.config.Mode == ModeAPI {
+		if err := c.exchangeToken(token); err != nil {
+			return nil, resp.StatusCode, err
+		}
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, resp.StatusCode, fmt.Errorf("%s %s responded with %s: %s", method, u, resp.Status, bytes.TrimSpace(data))
+	}
+	return data, resp.StatusCode, nil
+}
+
+// Fetch returns the current bootstrap of the node, or nil if none was
+// published yet.
+func (c *Client) Fetch() (*Bootstrap, error) {
+	data, status, err := c.do("GET", c.url(), "", nil)
+	if status == http.StatusNotFound || status == http.StatusNoContent {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.config.Mode == ModeAPI {
+		b := &Bootstrap{}
+		if err := json.Unmarshal(data, b); err != nil {
+			return nil, fmt.Errorf("Failed to parse the bootstrap: %v", err)
+		}
+		return b, nil
+	}
+	var secret struct {
+		Metadata struct {
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+		Data map[string][]byte `json:"data"`
+	}
+	if err := json.Unmarshal(data, &secret); err != nil {
+		return nil, fmt.Errorf("Failed to parse the bootstrap secret: %v", err)
+	}
+	generation := secret.Metadata.Annotations[GenerationAnnotationKey]
+	if generation == "" {
+		return nil, nil
+	}
+	return &Bootstrap{Generation: generation, Script: secret.Data[ScriptKey]}, nil
+}
+
+// Report reports the result of a run of the bootstrap.
+func (c *Client) Report(status Status) error {
+	if c.config.Mode == ModeAPI {
+		data, err := json.Marshal(status)
+		if err != nil {
+			return err
+		}
+		_, _, err = c.do("POST", c.url()+"/status", "application/json", data)
+		return err
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": Annotations(status)},
+	})
+	if err != nil {
+		return err
+	}
+	_, _, err = c.do("PATCH", c.url(), "application/merge-patch+json", data)
+	return err
+}
+
+// Annotations returns the merge patch of the annotations of the bootstrap
+// secret reporting the status, a nil value removes the annotation.
+func Annotations(status Status) map[string]interface{} {
+	if status.Error != "" {
+		return map[string]interface{}{ErrorAnnotationKey: status.Generation + ": " + status.Error}
+	}
+	return map[string]interface{}{AppliedAnnotationKey: status.Generation, ErrorAnnotationKey: nil}
+}
+
+// runScript runs the bootstrap script, its output is written to the log.
+var runScript = func(path, logPath string) error {
+	out, err := os.Create(logPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	cmd := exec.Command("/bin/sh", "-e", path)
+	cmd.Stdout, cmd.Stderr = out, out
+	return cmd.Run()
+}
+
+// Applied returns whether a bootstrap was applied on the node.
+func Applied(stateDir string) bool {
+	_, err := os.Stat(filepath.Join(stateDir, "applied-generation"))
+	return err == nil
+}
+
+// Run runs the current bootstrap of the node unless its generation already
+// ran, and reports the result. It returns whether the bootstrap of the node
+// is applied.
+func Run(c *Client, stateDir string) (bool, error) {
+	b, err := c.Fetch()
+	if err != nil || b == nil {
+		return false, err
+	}
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return false, err
+	}
+	generationPath := filepath.Join(stateDir, "generation")
+	appliedPath := filepath.Join(stateDir, "applied-generation")
+	if last, err := ioutil.ReadFile(generationPath); err == nil && strings.TrimSpace(string(last)) == b.Generation {
+		applied, _ := ioutil.ReadFile(appliedPath)
+		return strings.TrimSpace(string(applied)) == b.Generation, nil
+	}
+	// The generation is recorded first, so a script rebooting the node is not
+	// run again.
+	if err := ioutil.WriteFile(generationPath, []byte(b.Generation+"\n"), 0600); err != nil {
+		return false, err
+	}
+	scriptPath := filepath.Join(stateDir, ScriptKey)
+	if err := ioutil.WriteFile(scriptPath, b.Script, 0700); err != nil {
+		return false, err
+	}
+
+	logPath := filepath.Join(stateDir, "bootstrap.log")
+	status := Status{Generation: b.Generation}
+	runErr := runScript(scriptPath, logPath)
+	if runErr != nil {
+		status.Error = fmt.Sprintf("%v: %s", runErr, tail(logPath))
+	}
+	if err := c.Report(status); err != nil {
+		return false, fmt.Errorf("Failed to report the bootstrap of generation %s: %v", b.Generation, err)
+	}
+	if runErr != nil {
+		return false, fmt.Errorf("Bootstrap of generation %s failed: %s", b.Generation, status.Error)
+	}
+	return true, ioutil.WriteFile(appliedPath, []byte(b.Generation+"\n"), 0600)
+}
+
+func tail(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) > outputLines {
+		lines = lines[len(lines)-outputLines:]
+	}
+	return strings.Join(lines, " ")
+}
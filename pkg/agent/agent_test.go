@@ -0,0 +1,177 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunCluster(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var patches []map[string]map[string]map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t" || r.URL.Path != "/api/v1/namespaces/kube-system/secrets/kube-machine-bootstrap-node-1" {
+			http.Error(w, "unexpected", http.StatusForbidden)
+			return
+		}
+		if r.Method == "PATCH" {
+			var patch map[string]map[string]map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&patch)
+			patches = append(patches, patch)
+			return
+		}
+		w.Write([]byte(`{"metadata": {"annotations": {"kube-machine.kubermatic.io/generation": "1"}}, "data": {"bootstrap.sh": "ZWNobyBvaw=="}}`))
+	}))
+	defer server.Close()
+
+	var scripts []string
+	defer func(f func(string, string) error) { runScript = f }(runScript)
+	runScript = func(path, logPath string) error {
+		script, _ := ioutil.ReadFile(path)
+		scripts = append(scripts, string(script))
+		return nil
+	}
+
+	client, err := NewClient(&Config{Mode: ModeCluster, Server: server.URL, Token: "t", Machine: "node-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		applied, err := Run(client, dir)
+		if !applied || err != nil {
+			t.Fatalf("expected the bootstrap to be applied, got %v, %v", applied, err)
+		}
+	}
+	if !reflect.DeepEqual(scripts, []string{"echo ok"}) {
+		t.Errorf("expected the script to run once, got %q", scripts)
+	}
+	expected := []map[string]map[string]map[string]interface{}{
+		{"metadata": {"annotations": {AppliedAnnotationKey: "1", ErrorAnnotationKey: nil}}},
+	}
+	if !reflect.DeepEqual(patches, expected) {
+		t.Errorf("expected %v, got %v", expected, patches)
+	}
+	if !Applied(dir) {
+		t.Error("expected the bootstrap to be recorded as applied")
+	}
+}
+
+func TestRunAPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	published := false
+	var statuses []Status
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get("Authorization") != "Bearer once":
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		case r.Method == "GET" && r.URL.Path == "/v1/bootstrap/node-1" && !published:
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == "GET" && r.URL.Path == "/v1/bootstrap/node-1":
+			json.NewEncoder(w).Encode(Bootstrap{Generation: "2", Script: []byte("exit 1")})
+		case r.Method == "POST" && r.URL.Path == "/v1/bootstrap/node-1/status":
+			var status Status
+			json.NewDecoder(r.Body).Decode(&status)
+			statuses = append(statuses, status)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "unexpected", http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	defer func(f func(string, string) error) { runScript = f }(runScript)
+	runScript = func(path, logPath string) error {
+		ioutil.WriteFile(logPath, []byte("line 1\nline 2\n"), 0600)
+		return errors.New("exit status 1")
+	}
+
+	client, err := NewClient(&Config{Mode: ModeAPI, Server: server.URL + "/", Token: "once", Machine: "node-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied, err := Run(client, dir); applied || err != nil {
+		t.Fatalf("expected nothing to run before the bootstrap is published, got %v, %v", applied, err)
+	}
+	published = true
+	if applied, err := Run(client, dir); applied || err == nil {
+		t.Fatalf("expected the bootstrap to fail, got %v, %v", applied, err)
+	}
+	if applied, err := Run(client, dir); applied || err != nil {
+		t.Fatalf("expected a failed generation not to run again, got %v, %v", applied, err)
+	}
+	expected := []Status{{Generation: "2", Error: "exit status 1: line 1 line 2"}}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("expected %+v, got %+v", expected, statuses)
+	}
+	if Applied(dir) {
+		t.Error("expected the failed bootstrap not to be recorded as applied")
+	}
+}
+
+func TestTokenExchange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var tokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		tokens = append(tokens, token)
+		switch token {
+		case "once":
+			w.Header().Set(TokenHeader, "session")
+			w.WriteHeader(http.StatusNoContent)
+		case "session":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&Config{Mode: ModeAPI, Server: server.URL, Token: "once", Machine: "node-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.UseTokenFile(TokenPath(dir)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := Run(client, dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A restarted agent continues with the saved session token.
+	restarted, err := NewClient(&Config{Mode: ModeAPI, Server: server.URL, Token: "once", Machine: "node-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.UseTokenFile(TokenPath(dir)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Run(restarted, dir); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"once", "session", "session"}; !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("expected the tokens %v, got %v", expected, tokens)
+	}
+}
@@ -0,0 +1,185 @@
+// Package apilog logs the cloud API calls of the drivers and the SSH
+// commands run on the machines with their secrets redacted, so driver bugs
+// can be reproduced from the log attached to an issue.
+//
+// With KUBE_MACHINE_API_LOG set to a file, the calls are appended to it.
+// The driver plugins inherit the environment of kube-machine, so all calls
+// of an operation end up in its file.
+package apilog
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubermatic/kube-machine/pkg/cassette"
+)
+
+// EnvFile names the file the calls are logged to.
+const EnvFile = "KUBE_MACHINE_API_LOG"
+
+// MaxBody limits the logged bodies and command outputs.
+const MaxBody = 64 * 1024
+
+var (
+	pemBlock = regexp.MustCompile(`(?s)-----BEGIN [A-Z ]+-----.*?-----END [A-Z ]+-----`)
+	// secretValue matches flags, variables and YAML or JSON fields with
+	// secret names, e.g. --token=abc, PASSWORD='x' or "secret": "y".
+	secretValue = regexp.MustCompile(`(?i)((?:token|secret|password|passwd|credential|private.?key|api.?key)[\w.-]*"?)(\s*[=:]\s*|\s+)("[^"]*"|'[^']*'|[^\s'",}]+)`)
+	bearer      = regexp.MustCompile(`(?i)(bearer|basic)\s+[\w.~+/=-]+`)
+	// bootstrapToken matches the tokens nodes join the cluster with.
+	bootstrapToken = regexp.MustCompile(`\b[a-z0-9]{6}\.[a-z0-9]{16}\b`)
+	// base64Blob matches the encoded files of remote.WriteFileCommand,
+	// e.g. kubeconfigs and certificates.
+	base64Blob = regexp.MustCompile(`[A-Za-z0-9+/]{64,}={0,2}`)
+	hex        = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+)
+
+// Enabled returns whether calls are logged.
+func Enabled() bool {
+	return os.Getenv(EnvFile) != ""
+}
+
+// FileName returns the name of the log of an operation.
+func FileName(operation string, start time.Time) string {
+	return fmt.Sprintf("%s-%s-%d.log", operation, start.UTC().Format("20060102T150405Z"), os.Getpid())
+}
+
+// Redact removes the secrets from free text like commands, their output
+// and bodies which aren't forms or JSON: PEM blocks, secret values,
+// authorization, bootstrap tokens and base64 encoded data, e.g. the files
+// written to the machines and user data.
+func Redact(s string) string {
+	s = pemBlock.ReplaceAllString(s, cassette.Redacted)
+	s = base64Blob.ReplaceAllStringFunc(s, func(blob string) string {
+		// Checksums stay, they help reproducing downloads.
+		if hex.MatchString(blob) {
+			return blob
+		}
+		return fmt.Sprintf("%s(%d bytes)", cassette.Redacted, len(strings.TrimRight(blob, "="))*3/4)
+	})
+	s = secretValue.ReplaceAllString(s, "${1}${2}"+cassette.Redacted)
+	s = bearer.ReplaceAllString(s, "${1} "+cassette.Redacted)
+	return bootstrapToken.ReplaceAllString(s, cassette.Redacted)
+}
+
+// Transport returns the base transport logging the calls of the machine if
+// logging is enabled. The literal secrets, e.g. the credentials of the
+// driver, are redacted additionally to the ones cassettes redact.
+func Transport(machine string, base http.RoundTripper, secrets ...string) http.RoundTripper {
+	if !Enabled() {
+		return base
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &logger{machine: machine, base: base, redactor: cassette.NewRedactor(secrets...)}
+}
+
+type logger struct {
+	machine  string
+	base     http.RoundTripper
+	redactor cassette.Redactor
+}
+
+// RoundTrip sends the request and logs it with the response.
+func (l *logger) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	var entry bytes.Buffer
+	fmt.Fprintf(&entry, "> %s %s\n", req.Method, l.redactor.URL(req.URL))
+	writeHeaders(&entry, "> ", l.redactor.Headers(req.Header, nil))
+	writeBody(&entry, "> ", Redact(l.redactor.Body(req.Header.Get("Content-Type"), body)))
+
+	start := time.Now()
+	resp, err := l.base.RoundTrip(req)
+	elapsed := time.Since(start)
+	if err != nil {
+		fmt.Fprintf(&entry, "< error after %s: %s\n", elapsed, l.redactor.String(err.Error()))
+		write("api", l.machine, start, entry.Bytes())
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	fmt.Fprintf(&entry, "< %s (%s)\n", resp.Status, elapsed)
+	writeHeaders(&entry, "< ", l.redactor.Headers(resp.Header, nil))
+	writeBody(&entry, "< ", Redact(l.redactor.Body(resp.Header.Get("Content-Type"), respBody)))
+	write("api", l.machine, start, entry.Bytes())
+	return resp, nil
+}
+
+// SSH logs a command run on the host with its output.
+func SSH(host, command, output string, err error, start time.Time) {
+	if !Enabled() {
+		return
+	}
+	var entry bytes.Buffer
+	fmt.Fprintf(&entry, "$ %s\n", Redact(command))
+	writeBody(&entry, "", Redact(output))
+	if err != nil {
+		fmt.Fprintf(&entry, "exit after %s: %s\n", time.Since(start), err)
+	} else {
+		fmt.Fprintf(&entry, "exit after %s: 0\n", time.Since(start))
+	}
+	write("ssh", host, start, entry.Bytes())
+}
+
+func writeHeaders(buf *bytes.Buffer, prefix string, headers map[string][]string) {
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		fmt.Fprintf(buf, "%s%s: %s\n", prefix, k, strings.Join(headers[k], ", "))
+	}
+}
+
+func writeBody(buf *bytes.Buffer, prefix, body string) {
+	if body == "" {
+		return
+	}
+	if len(body) > MaxBody {
+		body = fmt.Sprintf("%s\n(%d bytes truncated)", body[:MaxBody], len(body)-MaxBody)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		buf.WriteString(prefix + line + "\n")
+	}
+}
+
+// write appends the entry to the log with a single write, so the entries
+// of kube-machine and of the driver plugins don't interleave.
+func write(kind, target string, start time.Time, entry []byte) {
+	path := os.Getenv(EnvFile)
+	if path == "" {
+		return
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s %s %s pid %d\n", start.UTC().Format(time.RFC3339Nano), kind, target, os.Getpid())
+	buf.Write(entry)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.Write(buf.Bytes())
+}
@@ -0,0 +1,148 @@
+package artifacts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// Artifact is a file the provisioner installs on the nodes.
+type Artifact struct {
+	URL string
+	// Path is the destination on the node.
+	Path string
+	// SHA256 is the hex encoded sum the artifact is verified against, the
+	// checksums file may pin it too. Artifacts without sum aren't verified.
+	SHA256 string
+}
+
+// Cache downloads artifacts once and keeps them in a directory, so they can
+// be pushed to the machines instead of every machine downloading them. The
+// artifact URLs contain their version, so cached files are never refreshed.
+type Cache struct {
+	Dir string
+
+	client *http.Client
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func NewCache(dir string) *Cache {
+	return &Cache{
+		Dir:    dir,
+		client: &http.Client{Timeout: 10 * time.Minute},
+		locks:  map[string]*sync.Mutex{},
+	}
+}
+
+// Open returns the cached artifact, it is downloaded from the mirrors or its
+// origin if it is not cached yet. Concurrent callers wait for a running
+// download. Downloads and cached files not matching the pinned sum of the
+// artifact are rejected.
+func (c *Cache) Open(a Artifact, mirrors []string) (*os.File, error) {
+	lock := c.lock(a.URL)
+	lock.Lock()
+	defer lock.Unlock()
+
+	file := c.path(a.URL)
+	f, err := os.Open(file)
+	if err == nil {
+		log.Debugf("Using cached %s", a.URL)
+		return verified(a, f)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if a.Checksum() == "" {
+		log.Warnf("%s has no pinned checksum, it is not verified", a.URL)
+	}
+	for _, url := range a.URLs(mirrors) {
+		if err = c.download(a, url, file); err == nil {
+			return os.Open(file)
+		}
+		log.Warnf("Failed to download %s: %v", url, err)
+	}
+	return nil, fmt.Errorf("Failed to download %s: %v", path.Base(a.URL), err)
+}
+
+// verified returns the cached file rewound if it has the pinned sum of the
+// artifact.
+func verified(a Artifact, f *os.File) (*os.File, error) {
+	err := a.verify(f)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Failed to verify the cached %s: %v", path.Base(a.URL), err)
+	}
+	return f, nil
+}
+
+func (c *Cache) lock(url string) *sync.Mutex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.locks[url]
+	if !ok {
+		l = &sync.Mutex{}
+		c.locks[url] = l
+	}
+	return l
+}
+
+func (c *Cache) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:8])+"-"+path.Base(url))
+}
+
+// download writes the artifact to a temporary file first, so interrupted
+// or corrupted downloads are not mistaken for cached artifacts.
+func (c *Cache) download(a Artifact, url, file string) error {
+	log.Infof("Downloading %s to the artifact cache...", url)
+	resp, err := c.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(c.Dir, ".download-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := a.verify(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
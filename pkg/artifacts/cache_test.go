@@ -0,0 +1,121 @@
+package artifacts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCacheOpen(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("binary " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := NewCache(dir)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f, err := c.Open(Artifact{URL: server.URL + "/kubelet"}, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer f.Close()
+			data, err := ioutil.ReadAll(f)
+			if err != nil || string(data) != "binary /kubelet" {
+				t.Errorf("unexpected content %q, %v", data, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if requests != 1 {
+		t.Errorf("expected a single download, got %d", requests)
+	}
+
+	if _, err := c.Open(Artifact{URL: server.URL + "/missing"}, nil); err == nil {
+		t.Error("expected an error for a missing artifact")
+	}
+
+	// The origin is down, the mirror serves the artifact under its path.
+	f, err := c.Open(Artifact{URL: "http://127.0.0.1:1/release/socat"}, []string{server.URL + "/mirror"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil || string(data) != "binary /mirror/release/socat" {
+		t.Errorf("unexpected content %q, %v", data, err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("expected only the kubelet and socat in the cache, got %d files", len(files))
+	}
+}
+
+func TestCacheOpenVerifies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("binary"))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := NewCache(dir)
+
+	sum := sha256.Sum256([]byte("binary"))
+	a := Artifact{URL: server.URL + "/kubelet", SHA256: hex.EncodeToString(sum[:])}
+	f, err := c.Open(a, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil || string(data) != "binary" {
+		t.Errorf("unexpected content %q, %v", data, err)
+	}
+
+	// The cached file is verified as well.
+	if err := ioutil.WriteFile(c.path(a.URL), []byte("tampered"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Open(a, nil); err == nil {
+		t.Error("expected an error for a tampered cached artifact")
+	}
+
+	other := Artifact{URL: server.URL + "/socat", SHA256: strings.Repeat("0", 64)}
+	if _, err := c.Open(other, nil); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("expected a checksum mismatch, got %v", err)
+	}
+	if _, err := os.Stat(c.path(other.URL)); !os.IsNotExist(err) {
+		t.Errorf("expected the mismatching download not to be cached, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package artifacts
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+var (
+	mu sync.RWMutex
+	// pinned holds the SHA-256 sums of the checksums file by artifact URL.
+	pinned = map[string]string{}
+)
+
+// ConfigureChecksums pins the SHA-256 sums of the artifacts listed in the
+// checksums file. Its lines are in the format of sha256sum, the sum followed
+// by the URL of the artifact, e.g.
+// "<sha256>  https://storage.googleapis.com/kubernetes-release/release/v1.5.3/bin/linux/amd64/kubelet".
+func ConfigureChecksums(checksumsFile string) error {
+	sums := map[string]string{}
+	if checksumsFile != "" {
+		data, err := ioutil.ReadFile(checksumsFile)
+		if err != nil {
+			return err
+		}
+		if sums, err = parseChecksums(data); err != nil {
+			return fmt.Errorf("Failed to parse checksums file %s: %v", checksumsFile, err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	pinned = sums
+	return nil
+}
+
+func parseChecksums(data []byte) (map[string]string, error) {
+	sums := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 || !validSum(fields[0]) {
+			return nil, fmt.Errorf("line %d: expected a hex encoded SHA-256 and the URL of the artifact", line)
+		}
+		sums[fields[1]] = strings.ToLower(fields[0])
+	}
+	return sums, scanner.Err()
+}
+
+func validSum(sum string) bool {
+	b, err := hex.DecodeString(sum)
+	return err == nil && len(b) == sha256.Size
+}
+
+// Checksum returns the pinned SHA-256 sum of the artifact, the one of the
+// checksums file overrides the one of the artifact. It is empty if the
+// artifact isn't pinned.
+func (a Artifact) Checksum() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	if sum, ok := pinned[a.URL]; ok {
+		return sum
+	}
+	return strings.ToLower(a.SHA256)
+}
+
+// verify checks that the content of r has the pinned sum of the artifact.
+func (a Artifact) verify(r io.Reader) error {
+	sum := a.Checksum()
+	if sum == "" {
+		return nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != sum {
+		return fmt.Errorf("checksum mismatch, expected sha256 %s, got %s", sum, actual)
+	}
+	return nil
+}
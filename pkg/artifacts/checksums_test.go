@@ -0,0 +1,48 @@
+package artifacts
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigureChecksums(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checksums")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer ConfigureChecksums("")
+
+	kubelet := Artifact{URL: "https://example.com/kubelet", SHA256: strings.Repeat("a", 64)}
+	socat := Artifact{URL: "https://example.com/socat"}
+	path := filepath.Join(dir, "checksums")
+	data := "# node binaries\n" + strings.Repeat("B", 64) + "  https://example.com/kubelet\n\n" + strings.Repeat("c", 64) + " https://example.com/socat\n"
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ConfigureChecksums(path); err != nil {
+		t.Fatal(err)
+	}
+	if sum := kubelet.Checksum(); sum != strings.Repeat("b", 64) {
+		t.Errorf("expected the sum of the checksums file, got %q", sum)
+	}
+	if sum := socat.Checksum(); sum != strings.Repeat("c", 64) {
+		t.Errorf("expected the pinned sum, got %q", sum)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("abc  https://example.com/kubelet\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ConfigureChecksums(path); err == nil {
+		t.Error("expected an error for an invalid sum")
+	}
+	if err := ConfigureChecksums(""); err != nil {
+		t.Fatal(err)
+	}
+	if sum := socat.Checksum(); sum != "" {
+		t.Errorf("expected no sum without checksums file, got %q", sum)
+	}
+}
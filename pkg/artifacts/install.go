@@ -0,0 +1,71 @@
+package artifacts
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/kubermatic/kube-machine/pkg/remote"
+)
+
+// ValidateMirror checks that the mirror is an HTTP(S) URL without query.
+func ValidateMirror(mirror string) error {
+	u, err := url.Parse(mirror)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("Invalid mirror %q, expected an http or https URL", mirror)
+	}
+	return nil
+}
+
+// MirrorURL returns the URL of the artifact on the mirror, mirrors serve the
+// artifacts under the paths of their origin, e.g.
+// https://mirror.example.com/kubernetes-release/release/v1.5.3/bin/linux/amd64/kubelet.
+func MirrorURL(mirror, origin string) (string, error) {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(mirror, "/") + u.Path, nil
+}
+
+// URLs returns the URLs the artifact is downloaded from in order, the mirrors
+// before its origin.
+func (a Artifact) URLs(mirrors []string) []string {
+	var urls []string
+	for _, m := range mirrors {
+		if u, err := MirrorURL(m, a.URL); err == nil {
+			urls = append(urls, u)
+		}
+	}
+	return append(urls, a.URL)
+}
+
+// InstallScript returns a shell script downloading the artifacts missing on
+// the node, trying their URLs in order. Installed artifacts are kept, so the
+// node doesn't depend on the sources once it is provisioned. Downloads are
+// written to a temporary file first, so interrupted ones are not mistaken
+// for installed artifacts, and are only installed if they have the pinned
+// sum of the artifact.
+func InstallScript(artifacts []Artifact, mirrors []string) string {
+	var cmds []string
+	for _, a := range artifacts {
+		dst := remote.Quote(a.Path)
+		tmp := remote.Quote(a.Path + ".download")
+		var urls []string
+		for _, u := range a.URLs(mirrors) {
+			urls = append(urls, remote.Quote(u))
+		}
+		verify := ""
+		if sum := a.Checksum(); sum != "" {
+			verify = fmt.Sprintf("echo %s | sha256sum -c - >/dev/null && ", remote.Quote(sum+"  "+a.Path+".download"))
+		}
+		cmds = append(cmds, fmt.Sprintf(
+			"mkdir -p %s && { test -x %s || { for url in %s; do curl -fsSL --retry 3 -o %s \"$url\" && %schmod +x %s && mv %s %s && break; done; test -x %s; }; }",
+			remote.Quote(path.Dir(a.Path)), dst, strings.Join(urls, " "), tmp, verify, tmp, tmp, dst, dst))
+	}
+	return strings.Join(cmds, " && ")
+}
@@ -0,0 +1,97 @@
+package artifacts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestURLs(t *testing.T) {
+	a := Artifact{URL: "https://storage.googleapis.com/kubernetes-release/release/v1.5.3/bin/linux/amd64/kubelet"}
+	urls := a.URLs([]string{"https://mirror.example.com/", "http://10.0.0.1:8080/k8s"})
+	expected := []string{
+		"https://mirror.example.com/kubernetes-release/release/v1.5.3/bin/linux/amd64/kubelet",
+		"http://10.0.0.1:8080/k8s/kubernetes-release/release/v1.5.3/bin/linux/amd64/kubelet",
+		a.URL,
+	}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Errorf("expected %v, got %v", expected, urls)
+	}
+}
+
+func TestValidateMirror(t *testing.T) {
+	if err := ValidateMirror("https://mirror.example.com/k8s"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	for _, m := range []string{"", "mirror.example.com", "ftp://mirror.example.com", "https://mirror.example.com/?token=1"} {
+		if err := ValidateMirror(m); err == nil {
+			t.Errorf("%q: expected an error", m)
+		}
+	}
+}
+
+func TestInstallScript(t *testing.T) {
+	if _, err := exec.LookPath("curl"); err != nil {
+		t.Skip("curl is not installed")
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/mirror/release/kubelet" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("#!/bin/sh\n"))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	kubelet := filepath.Join(dir, "bin", "kubelet")
+	a := Artifact{URL: server.URL + "/release/kubelet", Path: kubelet}
+
+	// The first mirror is down, the second one serves the artifact.
+	script := InstallScript([]Artifact{a}, []string{"http://127.0.0.1:1", server.URL + "/mirror"})
+	if out, err := exec.Command("/bin/sh", "-c", script).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if info, err := os.Stat(kubelet); err != nil || info.Mode()&0100 == 0 {
+		t.Fatalf("expected an executable kubelet, got %v, %v", info, err)
+	}
+
+	// Installed artifacts are kept without any source.
+	server.Close()
+	if out, err := exec.Command("/bin/sh", "-c", script).CombinedOutput(); err != nil {
+		t.Errorf("%v: %s", err, out)
+	}
+
+	// Downloads not matching the pinned sum aren't installed.
+	server = httptest.NewServer(server.Config.Handler)
+	defer server.Close()
+	pinned := Artifact{URL: server.URL + "/mirror/release/kubelet", Path: filepath.Join(dir, "bin", "pinned"), SHA256: strings.Repeat("0", 64)}
+	if err := exec.Command("/bin/sh", "-c", InstallScript([]Artifact{pinned}, nil)).Run(); err == nil {
+		t.Error("expected an error for a checksum mismatch")
+	}
+	if _, err := os.Stat(pinned.Path); !os.IsNotExist(err) {
+		t.Errorf("expected the mismatching download not to be installed, got %v", err)
+	}
+	sum := sha256.Sum256([]byte("#!/bin/sh\n"))
+	pinned.SHA256 = hex.EncodeToString(sum[:])
+	if out, err := exec.Command("/bin/sh", "-c", InstallScript([]Artifact{pinned}, nil)).CombinedOutput(); err != nil {
+		t.Errorf("%v: %s", err, out)
+	}
+
+	missing := InstallScript([]Artifact{{URL: server.URL + "/release/socat", Path: filepath.Join(dir, "bin", "socat")}}, nil)
+	if err := exec.Command("/bin/sh", "-c", missing).Run(); err == nil {
+		t.Error("expected an error if no source serves the artifact")
+	}
+}
@@ -0,0 +1,186 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+
+	redacted = "<redacted>"
+)
+
+// Entry is one record of the audit log.
+type Entry struct {
+	Time            time.Time         `json:"time"`
+	User            string            `json:"user"`
+	Machine         string            `json:"machine"`
+	Operation       string            `json:"operation"`
+	Parameters      map[string]string `json:"parameters,omitempty"`
+	Result          string            `json:"result"`
+	Error           string            `json:"error,omitempty"`
+	DurationSeconds float64           `json:"durationSeconds"`
+}
+
+// Sink persists audit entries.
+type Sink interface {
+	Write(e Entry) error
+}
+
+var (
+	mu    sync.Mutex
+	sinks []Sink
+)
+
+// SetSinks replaces the sinks the entries are written to.
+func SetSinks(s ...Sink) {
+	mu.Lock()
+	defer mu.Unlock()
+	sinks = s
+}
+
+// Configure creates the sinks of the specs, which are either "file:<path>",
+// "webhook:<url>" or "events[:<namespace>]". The events sink uses the
+// cluster of the kubeconfig.
+func Configure(specs []string, kubeconfig string) error {
+	var configured []Sink
+	for _, spec := range specs {
+		kind, arg := spec, ""
+		if i := strings.Index(spec, ":"); i >= 0 {
+			kind, arg = spec[:i], spec[i+1:]
+		}
+
+		switch {
+		case kind == "file" && arg != "":
+			configured = append(configured, &FileSink{Path: arg})
+		case kind == "webhook" && arg != "":
+			configured = append(configured, &WebhookSink{URL: arg})
+		case kind == "events":
+			sink, err := NewEventSink(kubeconfig, arg)
+			if err != nil {
+				return err
+			}
+			configured = append(configured, sink)
+		default:
+			return fmt.Errorf("Invalid audit sink %q, expected file:<path>, webhook:<url> or events[:<namespace>]", spec)
+		}
+	}
+
+	SetSinks(configured...)
+	return nil
+}
+
+// Record writes an entry for the operation on the machine started at start
+// to all sinks. Failing sinks are logged, they never fail the operation.
+func Record(machine, operation string, params map[string]interface{}, start time.Time, err error) {
+	mu.Lock()
+	s := sinks
+	mu.Unlock()
+	if len(s) == 0 {
+		return
+	}
+
+	e := Entry{
+		Time:            start.UTC(),
+		User:            CurrentUser(),
+		Machine:         machine,
+		Operation:       operation,
+		Parameters:      Redact(params),
+		Result:          ResultSuccess,
+		DurationSeconds: time.Since(start).Seconds(),
+	}
+	if err != nil {
+		e.Result = ResultError
+		e.Error = err.Error()
+	}
+
+	for _, sink := range s {
+		if writeErr := sink.Write(e); writeErr != nil {
+			log.Warnf("Failed to write audit entry for %s of %s: %v", operation, machine, writeErr)
+		}
+	}
+}
+
+// CurrentUser returns the operator running kube-machine as user@host.
+func CurrentUser() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		return name + "@" + hostname
+	}
+	return name
+}
+
+var secretKeys = []string{"token", "secret", "password", "passwd", "credential", "access-key", "api-key", "private-key"}
+
+// IsSecret reports whether the parameter holds a secret like a token or a
+// private key.
+func IsSecret(key string, value string) bool {
+	key = strings.ToLower(key)
+	for _, s := range secretKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return strings.Contains(value, "PRIVATE KEY-----")
+}
+
+// Redact formats the parameters as strings and replaces the values of
+// secrets. Unset parameters are left out.
+func Redact(params map[string]interface{}) map[string]string {
+	if len(params) == 0 {
+		return nil
+	}
+
+	result := map[string]string{}
+	for k, v := range params {
+		var value string
+		switch v := v.(type) {
+		case nil:
+			continue
+		case []string:
+			value = strings.Join(v, ",")
+		default:
+			value = fmt.Sprint(v)
+		}
+		if value == "" {
+			continue
+		}
+		if IsSecret(k, value) {
+			value = redacted
+		}
+		result[k] = value
+	}
+	return result
+}
+
+// Digest returns the SHA-256 digest of a parameter which may contain secrets
+// in no known place, e.g. a command line. Entries of the same value can be
+// matched without recording it.
+func Digest(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// ParameterNames returns the sorted names of the parameters.
+func ParameterNames(params map[string]string) []string {
+	var names []string
+	for k := range params {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
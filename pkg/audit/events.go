@@ -0,0 +1,77 @@
+package audit
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kubermatic/kube-machine/pkg/nodestore"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	kcorev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// DefaultEventNamespace is the namespace of the events, unless the sink
+	// configures another one.
+	DefaultEventNamespace = "kube-system"
+
+	eventComponent = "kube-machine"
+)
+
+// EventSink records the entries as Kubernetes events of the machine's node.
+type EventSink struct {
+	Client    kubernetes.Interface
+	Namespace string
+}
+
+// NewEventSink creates a sink recording the events in the namespace of the
+// cluster of the kubeconfig, DefaultEventNamespace if it is empty.
+func NewEventSink(kubeconfig, namespace string) (*EventSink, error) {
+	client, err := nodestore.NewClient(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create client for the audit events: %v", err)
+	}
+	if namespace == "" {
+		namespace = DefaultEventNamespace
+	}
+	return &EventSink{Client: client, Namespace: namespace}, nil
+}
+
+func (s *EventSink) Write(e Entry) error {
+	var params []string
+	for _, name := range ParameterNames(e.Parameters) {
+		params = append(params, name+"="+e.Parameters[name])
+	}
+	message := fmt.Sprintf("%s of machine %s by %s: %s after %.1fs", e.Operation, e.Machine, e.User, e.Result, e.DurationSeconds)
+	if e.Error != "" {
+		message += ": " + e.Error
+	}
+	if len(params) > 0 {
+		message += " (" + strings.Join(params, " ") + ")"
+	}
+
+	eventType := kcorev1.EventTypeNormal
+	if e.Result == ResultError {
+		eventType = kcorev1.EventTypeWarning
+	}
+
+	t := metav1.NewTime(e.Time)
+	_, err := s.Client.CoreV1().Events(s.Namespace).Create(&kcorev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: e.Machine + ".",
+			Namespace:    s.Namespace,
+		},
+		InvolvedObject: kcorev1.ObjectReference{
+			Kind: "Node",
+			Name: e.Machine,
+		},
+		Reason:         "Machine" + strings.Title(e.Operation),
+		Message:        message,
+		Type:           eventType,
+		Source:         kcorev1.EventSource{Component: eventComponent},
+		FirstTimestamp: t,
+		LastTimestamp:  t,
+		Count:          1,
+	})
+	return err
+}
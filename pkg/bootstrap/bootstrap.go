@@ -0,0 +1,223 @@
+package bootstrap
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+const (
+	FormatCloudInit = "cloud-init"
+	FormatIgnition  = "ignition"
+	FormatScript    = "script"
+
+	systemdUnitDir = "/etc/systemd/system"
+	ignitionVer    = "2.1.0"
+
+	// The commands are run by a oneshot unit on Ignition based systems.
+	scriptPath  = "/opt/kube-machine/bootstrap.sh"
+	scriptUnit  = "kube-machine-bootstrap.service"
+	unitOneshot = `[Unit]
+Description=Kube Machine node bootstrap
+Wants=network-online.target
+After=network-online.target
+ConditionPathExists=!/opt/kube-machine/bootstrap.done
+
+[Service]
+Type=oneshot
+ExecStart=/bin/sh -e ` + scriptPath + `
+ExecStartPost=/usr/bin/touch /opt/kube-machine/bootstrap.done
+
+[Install]
+WantedBy=multi-user.target
+`
+)
+
+// File is a file written to the node.
+type File struct {
+	Path    string
+	Mode    os.FileMode
+	Content []byte
+}
+
+// Unit is a systemd unit installed on the node.
+type Unit struct {
+	Name    string
+	Content string
+	Enable  bool
+}
+
+// Config is everything set up on a node to make it join the cluster. It can
+// be rendered as user-data to bootstrap nodes without kube-machine.
+type Config struct {
+	Files []File
+	Units []Unit
+	// Commands are run once in order after the files are written.
+	Commands []string
+}
+
+// Render returns the config as user-data of the format.
+func (c *Config) Render(format string) ([]byte, error) {
+	switch format {
+	case FormatCloudInit:
+		return c.CloudInit()
+	case FormatIgnition:
+		return c.Ignition()
+	case FormatScript:
+		return c.Script()
+	}
+	return nil, fmt.Errorf("Unknown user-data format %q, expected %s, %s or %s", format, FormatCloudInit, FormatIgnition, FormatScript)
+}
+
+// Script renders the config as a shell script, which is run by the node
+// agent of machines provisioned through the cluster.
+func (c *Config) Script() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("#!/bin/sh\nset -e\n")
+	write := func(p string, mode os.FileMode, content []byte) {
+		fmt.Fprintf(&b, "mkdir -p %s\n", path.Dir(p))
+		fmt.Fprintf(&b, "base64 -d > %s <<'EOF'\n%s\nEOF\n", p, base64.StdEncoding.EncodeToString(content))
+		fmt.Fprintf(&b, "chmod %#o %s\n", mode.Perm(), p)
+	}
+	for _, f := range c.Files {
+		write(f.Path, f.Mode, f.Content)
+	}
+	var enable []string
+	for _, u := range c.Units {
+		write(path.Join(systemdUnitDir, u.Name), 0644, []byte(u.Content))
+		if u.Enable {
+			enable = append(enable, u.Name)
+		}
+	}
+	for _, command := range c.Commands {
+		fmt.Fprintln(&b, command)
+	}
+	if len(c.Units) > 0 {
+		fmt.Fprintln(&b, "systemctl daemon-reload")
+	}
+	if len(enable) > 0 {
+		fmt.Fprintln(&b, "systemctl enable --now "+strings.Join(enable, " "))
+	}
+	return b.Bytes(), nil
+}
+
+type cloudConfig struct {
+	WriteFiles []cloudConfigFile `json:"write_files,omitempty"`
+	RunCmd     []string          `json:"runcmd,omitempty"`
+}
+
+type cloudConfigFile struct {
+	Path        string `json:"path"`
+	Permissions string `json:"permissions"`
+	Encoding    string `json:"encoding"`
+	Content     string `json:"content"`
+}
+
+// CloudInit renders the config as a #cloud-config document.
+func (c *Config) CloudInit() ([]byte, error) {
+	config := cloudConfig{}
+	for _, f := range c.Files {
+		config.WriteFiles = append(config.WriteFiles, cloudConfigFile{
+			Path:        f.Path,
+			Permissions: fmt.Sprintf("%#o", f.Mode.Perm()),
+			Encoding:    "b64",
+			Content:     base64.StdEncoding.EncodeToString(f.Content),
+		})
+	}
+
+	var enable []string
+	for _, u := range c.Units {
+		config.WriteFiles = append(config.WriteFiles, cloudConfigFile{
+			Path:        path.Join(systemdUnitDir, u.Name),
+			Permissions: "0644",
+			Encoding:    "b64",
+			Content:     base64.StdEncoding.EncodeToString([]byte(u.Content)),
+		})
+		if u.Enable {
+			enable = append(enable, u.Name)
+		}
+	}
+
+	config.RunCmd = append(config.RunCmd, c.Commands...)
+	if len(c.Units) > 0 {
+		config.RunCmd = append(config.RunCmd, "systemctl daemon-reload")
+	}
+	if len(enable) > 0 {
+		config.RunCmd = append(config.RunCmd, "systemctl enable --now "+strings.Join(enable, " "))
+	}
+
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("#cloud-config\n"), data...), nil
+}
+
+type ignitionConfig struct {
+	Ignition struct {
+		Version string `json:"version"`
+	} `json:"ignition"`
+	Storage struct {
+		Files []ignitionFile `json:"files,omitempty"`
+	} `json:"storage"`
+	Systemd struct {
+		Units []ignitionUnit `json:"units,omitempty"`
+	} `json:"systemd"`
+}
+
+type ignitionFile struct {
+	Filesystem string `json:"filesystem"`
+	Path       string `json:"path"`
+	Mode       int    `json:"mode"`
+	Contents   struct {
+		Source string `json:"source"`
+	} `json:"contents"`
+}
+
+type ignitionUnit struct {
+	Name     string `json:"name"`
+	Enabled  bool   `json:"enabled,omitempty"`
+	Contents string `json:"contents"`
+}
+
+// Ignition renders the config as an Ignition config. The commands are run
+// by a oneshot unit.
+func (c *Config) Ignition() ([]byte, error) {
+	files := append([]File{}, c.Files...)
+	units := append([]Unit{}, c.Units...)
+	if len(c.Commands) > 0 {
+		files = append(files, File{
+			Path:    scriptPath,
+			Mode:    0700,
+			Content: []byte(strings.Join(c.Commands, "\n") + "\n"),
+		})
+		units = append(units, Unit{Name: scriptUnit, Content: unitOneshot, Enable: true})
+	}
+
+	config := ignitionConfig{}
+	config.Ignition.Version = ignitionVer
+	for _, f := range files {
+		file := ignitionFile{
+			Filesystem: "root",
+			Path:       f.Path,
+			Mode:       int(f.Mode.Perm()),
+		}
+		file.Contents.Source = "data:;base64," + base64.StdEncoding.EncodeToString(f.Content)
+		config.Storage.Files = append(config.Storage.Files, file)
+	}
+	for _, u := range units {
+		config.Systemd.Units = append(config.Systemd.Units, ignitionUnit{
+			Name:     u.Name,
+			Enabled:  u.Enable,
+			Contents: u.Content,
+		})
+	}
+
+	return json.MarshalIndent(config, "", "  ")
+}
@@ -0,0 +1,99 @@
+package bootstrap
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+)
+
+var testConfig = &Config{
+	Files: []File{{Path: "/etc/kubeconfig", Mode: 0600, Content: []byte("apiVersion: v1\n")}},
+	Units: []Unit{{Name: "kubelet.service", Content: "[Unit]\n", Enable: true}},
+	Commands: []string{
+		"curl -sSL https://get.docker.com | sh",
+	},
+}
+
+func TestCloudInit(t *testing.T) {
+	data, err := testConfig.Render(FormatCloudInit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "#cloud-config\n") {
+		t.Fatalf("Expected a cloud-config document, got:\n%s", data)
+	}
+
+	config := cloudConfig{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.WriteFiles) != 2 {
+		t.Fatalf("Expected 2 files, got %+v", config.WriteFiles)
+	}
+	kubeconfig, unit := config.WriteFiles[0], config.WriteFiles[1]
+	if kubeconfig.Path != "/etc/kubeconfig" || kubeconfig.Permissions != "0600" || kubeconfig.Content != base64.StdEncoding.EncodeToString([]byte("apiVersion: v1\n")) {
+		t.Errorf("Unexpected file %+v", kubeconfig)
+	}
+	if unit.Path != "/etc/systemd/system/kubelet.service" {
+		t.Errorf("Unexpected unit file %+v", unit)
+	}
+
+	expected := []string{
+		"curl -sSL https://get.docker.com | sh",
+		"systemctl daemon-reload",
+		"systemctl enable --now kubelet.service",
+	}
+	if !reflect.DeepEqual(config.RunCmd, expected) {
+		t.Errorf("Expected commands %q, got %q", expected, config.RunCmd)
+	}
+}
+
+func TestIgnition(t *testing.T) {
+	data, err := testConfig.Render(FormatIgnition)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := ignitionConfig{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Ignition.Version != ignitionVer {
+		t.Errorf("Unexpected version %q", config.Ignition.Version)
+	}
+	if len(config.Storage.Files) != 2 || config.Storage.Files[0].Mode != 0600 || config.Storage.Files[1].Path != scriptPath {
+		t.Errorf("Unexpected files %+v", config.Storage.Files)
+	}
+	if len(config.Systemd.Units) != 2 || !config.Systemd.Units[0].Enabled || config.Systemd.Units[1].Name != scriptUnit {
+		t.Errorf("Unexpected units %+v", config.Systemd.Units)
+	}
+	if len(testConfig.Files) != 1 {
+		t.Error("Rendering must not modify the config")
+	}
+}
+
+func TestScript(t *testing.T) {
+	data, err := testConfig.Render(FormatScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"base64 -d > /etc/kubeconfig <<'EOF'\n" + base64.StdEncoding.EncodeToString([]byte("apiVersion: v1\n")) + "\nEOF\nchmod 0600 /etc/kubeconfig\n",
+		"mkdir -p /etc/systemd/system\nbase64 -d > /etc/systemd/system/kubelet.service",
+		"curl -sSL https://get.docker.com | sh\nsystemctl daemon-reload\nsystemctl enable --now kubelet.service\n",
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("Expected %q in the script:\n%s", expected, data)
+		}
+	}
+}
+
+func TestRenderUnknownFormat(t *testing.T) {
+	if _, err := testConfig.Render("kickstart"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,114 @@
+package canary
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Rollout applies a change to a few canary machines first and only to the
+// rest of the machines once the canaries passed their verification and the
+// check.
+type Rollout struct {
+	// Canaries is the number of machines changed first.
+	Canaries int
+	// Apply changes the machines.
+	Apply func(names []string) error
+	// Verify verifies a changed machine.
+	Verify func(name string) error
+	// Check is an additional check of the changed canaries, it is optional.
+	Check func(canaries []string) error
+	// Soak is the time waited between changing the canaries and checking
+	// them.
+	Soak time.Duration
+
+	sleep func(time.Duration)
+}
+
+// Split returns the first n names as canaries and the rest.
+func Split(names []string, n int) (canaries, rest []string) {
+	if n > len(names) {
+		n = len(names)
+	}
+	return names[:n], names[n:]
+}
+
+// Run changes the canaries among the machines, verifies and checks them and
+// changes the rest of the machines then. The rest is left alone if any step
+// of the canaries fails.
+func (r Rollout) Run(names []string) error {
+	canaries, rest := Split(names, r.Canaries)
+	if len(canaries) == 0 {
+		return nil
+	}
+	if err := r.Apply(canaries); err != nil {
+		return fmt.Errorf("Canaries %s failed: %v", strings.Join(canaries, ", "), err)
+	}
+
+	if r.Soak > 0 {
+		sleep := r.sleep
+		if sleep == nil {
+			sleep = time.Sleep
+		}
+		sleep(r.Soak)
+	}
+	var failed []string
+	for _, name := range canaries {
+		if err := r.Verify(name); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("Canaries failed their verification:\n  %s", strings.Join(failed, "\n  "))
+	}
+	if r.Check != nil {
+		if err := r.Check(canaries); err != nil {
+			return fmt.Errorf("Canaries %s failed the check: %v", strings.Join(canaries, ", "), err)
+		}
+	}
+
+	if len(rest) == 0 {
+		return nil
+	}
+	return r.Apply(rest)
+}
+
+// Webhook checks the canaries with an HTTP endpoint, e.g. one evaluating a
+// Prometheus query. The canaries pass if it returns a 2xx status.
+type Webhook struct {
+	URL       string
+	Operation string
+	Client    *http.Client
+}
+
+type webhookRequest struct {
+	Operation string   `json:"operation"`
+	Machines  []string `json:"machines"`
+}
+
+// Check posts the operation and the canaries as JSON to the endpoint.
+func (w Webhook) Check(canaries []string) error {
+	data, err := json.Marshal(webhookRequest{Operation: w.Operation, Machines: canaries})
+	if err != nil {
+		return err
+	}
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: time.Minute}
+	}
+	resp, err := client.Post(w.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(&io.LimitedReader{R: resp.Body, N: 1024})
+		return fmt.Errorf("Canary check returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package canary
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRollout(t *testing.T) {
+	names := []string{"node-1", "node-2", "node-3", "node-4"}
+	tests := []struct {
+		verifyErr, checkErr error
+		applied             [][]string
+		err                 string
+	}{
+		{applied: [][]string{{"node-1", "node-2"}, {"node-3", "node-4"}}},
+		{verifyErr: errors.New("kubelet not running"), applied: [][]string{{"node-1", "node-2"}}, err: "verification"},
+		{checkErr: errors.New("error rate 5%"), applied: [][]string{{"node-1", "node-2"}}, err: "error rate 5%"},
+	}
+
+	for _, test := range tests {
+		var applied [][]string
+		var slept time.Duration
+		r := Rollout{
+			Canaries: 2,
+			Apply: func(names []string) error {
+				applied = append(applied, names)
+				return nil
+			},
+			Verify: func(name string) error { return test.verifyErr },
+			Check:  func(canaries []string) error { return test.checkErr },
+			Soak:   time.Minute,
+			sleep:  func(d time.Duration) { slept += d },
+		}
+		err := r.Run(names)
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("expected error %q, got %v", test.err, err)
+		}
+		if !reflect.DeepEqual(applied, test.applied) {
+			t.Errorf("expected %v to be applied, got %v", test.applied, applied)
+		}
+		if slept != time.Minute {
+			t.Errorf("expected to soak for a minute, slept %s", slept)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	canaries, rest := Split([]string{"node-1"}, 3)
+	if !reflect.DeepEqual(canaries, []string{"node-1"}) || len(rest) != 0 {
+		t.Errorf("unexpected split %v %v", canaries, rest)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var received webhookRequest
+	healthy := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		if !healthy {
+			http.Error(w, "error rate above 1%", http.StatusPreconditionFailed)
+		}
+	}))
+	defer server.Close()
+
+	w := Webhook{URL: server.URL, Operation: "upgrade"}
+	if err := w.Check([]string{"node-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if received.Operation != "upgrade" || !reflect.DeepEqual(received.Machines, []string{"node-1"}) {
+		t.Errorf("unexpected request %+v", received)
+	}
+
+	healthy = false
+	if err := w.Check([]string{"node-1"}); err == nil || !strings.Contains(err.Error(), "error rate above 1%") {
+		t.Errorf("expected the check to fail, got %v", err)
+	}
+}
@@ -0,0 +1,427 @@
+// Package cassette records the cloud API calls of drivers and replays them,
+// so create and delete flows are tested without cloud credentials.
+//
+// Drivers wrap their HTTP transport with Transport. With KUBE_MACHINE_CASSETTE
+// set to a directory, the calls of each machine are recorded to or replayed
+// from <directory>/<machine>.yaml, as KUBE_MACHINE_CASSETTE_MODE is record or
+// replay. The driver plugins inherit the environment of kube-machine.
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/ghodss/yaml"
+)
+
+const (
+	EnvDir  = "KUBE_MACHINE_CASSETTE"
+	EnvMode = "KUBE_MACHINE_CASSETTE_MODE"
+
+	ModeRecord = "record"
+	ModeReplay = "replay"
+
+	// Redacted replaces the secrets in the cassettes.
+	Redacted = "REDACTED"
+)
+
+var (
+	// secretKey matches the names of query, form and JSON values which are
+	// not recorded. User data carries the bootstrap tokens of the nodes.
+	secretKey = regexp.MustCompile(`(?i)(signature|token|secret|password|credential|private|user.?data|startup.?script|ssh.?keys)`)
+
+	// recordedRequestHeaders are recorded, the others carry credentials
+	// or change on every request.
+	recordedRequestHeaders = []string{"Content-Type"}
+)
+
+// Request is a recorded request.
+type Request struct {
+	Method  string              `json:"method"`
+	URL     string              `json:"url"`
+	Headers map[string][]string `json:"headers,omitempty"`
+	Body    string              `json:"body,omitempty"`
+}
+
+// Response is a recorded response.
+type Response struct {
+	Status  int                 `json:"status"`
+	Headers map[string][]string `json:"headers,omitempty"`
+	Body    string              `json:"body,omitempty"`
+}
+
+// Interaction is a request and its response.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Cassette are the interactions of a machine in order.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Load reads a cassette.
+func Load(path string) (*Cassette, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cassette{}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("Failed to parse cassette %s: %v", path, err)
+	}
+	return c, nil
+}
+
+// Save writes the cassette, replacing the file atomically.
+func (c *Cassette) Save(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Recorder is a transport recording the requests to a cassette or
+// replaying the responses of a cassette.
+type Recorder struct {
+	mode     string
+	path     string
+	base     http.RoundTripper
+	redactor Redactor
+	tape     *tape
+}
+
+// tape is a loaded cassette and which of its interactions were replayed,
+// shared by the recorders of the cassette.
+type tape struct {
+	mu       sync.Mutex
+	cassette *Cassette
+	used     []bool
+}
+
+var (
+	tapesMu sync.Mutex
+	tapes   = map[string]*tape{}
+)
+
+// New returns a recorder of the cassette at the path. Recording sends the
+// requests with the base transport and appends them to the cassette, the
+// literal secrets are redacted additionally to the secret values and
+// headers.
+func New(path, mode string, base http.RoundTripper, secrets ...string) (*Recorder, error) {
+	t, err := loadTape(path, mode)
+	if err != nil {
+		return nil, err
+	}
+	return newRecorder(path, mode, base, t, secrets), nil
+}
+
+func newRecorder(path, mode string, base http.RoundTripper, t *tape, secrets []string) *Recorder {
+	return &Recorder{mode: mode, path: path, base: base, tape: t, redactor: NewRedactor(secrets...)}
+}
+
+func loadTape(path, mode string) (*tape, error) {
+	if mode != ModeRecord && mode != ModeReplay {
+		return nil, fmt.Errorf("Unknown cassette mode %q, expected %s or %s", mode, ModeRecord, ModeReplay)
+	}
+	c, err := Load(path)
+	switch {
+	case err == nil:
+	case os.IsNotExist(err) && mode == ModeRecord:
+		c = &Cassette{}
+	case os.IsNotExist(err):
+		return nil, fmt.Errorf("No cassette %s to replay, record it with %s=%s", path, EnvMode, ModeRecord)
+	default:
+		return nil, err
+	}
+	return &tape{cassette: c, used: make([]bool, len(c.Interactions))}, nil
+}
+
+// RoundTrip records or replays the request.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	recorded := Request{
+		Method:  req.Method,
+		URL:     r.redactor.URL(req.URL),
+		Headers: r.redactor.Headers(req.Header, recordedRequestHeaders),
+		Body:    r.redactor.Body(req.Header.Get("Content-Type"), body),
+	}
+
+	if r.mode == ModeReplay {
+		i, err := r.replay(recorded)
+		if err != nil {
+			return nil, err
+		}
+		return i.Response.toHTTP(req), nil
+	}
+
+	resp, err := r.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	t := r.tape
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cassette.Interactions = append(t.cassette.Interactions, Interaction{
+		Request: recorded,
+		Response: Response{
+			Status:  resp.StatusCode,
+			Headers: r.redactor.Headers(resp.Header, nil, "Content-Length", "Content-Encoding"),
+			Body:    r.redactor.Body(resp.Header.Get("Content-Type"), respBody),
+		},
+	})
+	t.used = append(t.used, true)
+	// The cassette is saved after every request as the driver plugins
+	// are stopped without notice.
+	if err := t.cassette.Save(r.path); err != nil {
+		return nil, fmt.Errorf("Failed to save cassette %s: %v", r.path, err)
+	}
+	return resp, nil
+}
+
+// replay returns the first unused interaction of the request. Requests
+// whose body changes between runs, e.g. as it contains generated names,
+// are replayed in the order they were recorded for the method and URL.
+func (r *Recorder) replay(req Request) (Interaction, error) {
+	t := r.tape
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	match := -1
+	for i, recorded := range t.cassette.Interactions {
+		if t.used[i] || recorded.Request.Method != req.Method || recorded.Request.URL != req.URL {
+			continue
+		}
+		if recorded.Request.Body == req.Body {
+			match = i
+			break
+		}
+		if match == -1 {
+			match = i
+		}
+	}
+	if match == -1 {
+		return Interaction{}, fmt.Errorf("No interaction for %s %s left in cassette %s", req.Method, req.URL, r.path)
+	}
+	t.used[match] = true
+	return t.cassette.Interactions[match], nil
+}
+
+func (resp Response) toHTTP(req *http.Request) *http.Response {
+	header := http.Header{}
+	for k, v := range resp.Headers {
+		header[k] = v
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", resp.Status, http.StatusText(resp.Status)),
+		StatusCode:    resp.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(resp.Body)),
+		ContentLength: int64(len(resp.Body)),
+		Request:       req,
+	}
+}
+
+// Redactor removes the secrets from the recorded calls: the secret values
+// of queries, forms and JSON bodies, the credential headers and the literal
+// secrets.
+type Redactor struct {
+	secrets []string
+}
+
+// NewRedactor returns a redactor of the literal secrets, empty ones are
+// ignored.
+func NewRedactor(secrets ...string) Redactor {
+	r := Redactor{}
+	for _, s := range secrets {
+		if s != "" {
+			r.secrets = append(r.secrets, s)
+		}
+	}
+	return r
+}
+
+// String replaces the literal secrets in s.
+func (r Redactor) String(s string) string {
+	for _, secret := range r.secrets {
+		s = strings.Replace(s, secret, Redacted, -1)
+	}
+	return s
+}
+
+// URL returns the URL without user info and secret query values.
+func (r Redactor) URL(u *url.URL) string {
+	scrubbed := *u
+	scrubbed.User = nil
+	scrubbed.RawQuery = scrubValues(u.Query()).Encode()
+	return r.String(scrubbed.String())
+}
+
+// Headers returns the headers to record, only the allowed ones if given.
+// Cookies and authorization are never recorded.
+func (r Redactor) Headers(h http.Header, allowed []string, skipped ...string) map[string][]string {
+	headers := map[string][]string{}
+	for k, v := range h {
+		if (allowed != nil && !containsFold(allowed, k)) || containsFold(skipped, k) {
+			continue
+		}
+		if secretKey.MatchString(k) || strings.EqualFold(k, "Authorization") || strings.Contains(strings.ToLower(k), "cookie") {
+			continue
+		}
+		values := make([]string, len(v))
+		for i := range v {
+			values[i] = r.String(v[i])
+		}
+		headers[k] = values
+	}
+	if len(headers) == 0 {
+		return nil
+	}
+	return headers
+}
+
+// Body redacts the secret values of form and JSON bodies and the literal
+// secrets of all bodies.
+func (r Redactor) Body(contentType string, body []byte) string {
+	switch {
+	case len(body) == 0:
+		return ""
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		if values, err := url.ParseQuery(string(body)); err == nil {
+			return r.String(scrubValues(values).Encode())
+		}
+	case strings.Contains(contentType, "json"):
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err == nil {
+			if scrubbed, err := json.Marshal(scrubJSON(v)); err == nil {
+				return r.String(string(scrubbed))
+			}
+		}
+	}
+	return r.String(string(body))
+}
+
+func scrubValues(values url.Values) url.Values {
+	scrubbed := url.Values{}
+	for k, v := range values {
+		if secretKey.MatchString(k) {
+			v = []string{Redacted}
+		}
+		scrubbed[k] = v
+	}
+	return scrubbed
+}
+
+// scrubJSON redacts the secret fields of objects and the values of
+// key/value items with secret keys, e.g. of the GCE metadata.
+func scrubJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		key, isItem := v["key"].(string)
+		for k, value := range v {
+			if secretKey.MatchString(k) || (isItem && k == "value" && secretKey.MatchString(key)) {
+				v[k] = Redacted
+				continue
+			}
+			v[k] = scrubJSON(value)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = scrubJSON(v[i])
+		}
+	}
+	return v
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Replaying returns whether the drivers replay cassettes, they don't need
+// credentials then.
+func Replaying() bool {
+	return os.Getenv(EnvDir) != "" && mode() == ModeReplay
+}
+
+func mode() string {
+	if m := os.Getenv(EnvMode); m != "" {
+		return m
+	}
+	return ModeReplay
+}
+
+// Transport returns the transport of the cloud API calls of the machine,
+// the base transport unless a cassette directory is set. The transports of
+// a machine share its cassette, so drivers may create a client per call.
+// The literal secrets, e.g. the credentials of the driver, are redacted.
+func Transport(machine string, base http.RoundTripper, secrets ...string) http.RoundTripper {
+	dir := os.Getenv(EnvDir)
+	if dir == "" {
+		return base
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	path := filepath.Join(dir, machine+".yaml")
+	tapesMu.Lock()
+	defer tapesMu.Unlock()
+	t, ok := tapes[path]
+	if !ok {
+		var err error
+		if t, err = loadTape(path, mode()); err != nil {
+			return failing{err}
+		}
+		tapes[path] = t
+	}
+	return newRecorder(path, mode(), base, t, secrets)
+}
+
+// failing fails all requests, so a cassette which can't be loaded fails
+// the driver instead of calling the cloud.
+type failing struct {
+	err error
+}
+
+func (f failing) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, f.err
+}
@@ -0,0 +1,147 @@
+package cassette
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "node-1.yaml")
+
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=abc")
+		switch r.Form.Get("Action") {
+		case "RunInstances":
+			w.Write([]byte(`{"instanceId":"i-1","password":"hunter2"}`))
+		case "DescribeInstances":
+			w.Write([]byte(`{"call":` + strconv.Itoa(calls) + `,"state":"running"}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	post := func(client *http.Client, values url.Values) (string, error) {
+		req, _ := http.NewRequest("POST", server.URL+"/?X-Amz-Signature=abc&Version=2016-11-15", strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20170301")
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+	run := url.Values{"Action": {"RunInstances"}, "UserData": {"bootstrap-token"}, "KeyName": {"AKIDEXAMPLE"}}
+	describe := url.Values{"Action": {"DescribeInstances"}}
+
+	recorder, err := New(path, ModeRecord, http.DefaultTransport, "AKIDEXAMPLE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: recorder}
+	var recorded []string
+	for _, values := range []url.Values{run, describe, describe} {
+		body, err := post(client, values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorded = append(recorded, body)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"AKIDEXAMPLE", "bootstrap-token", "hunter2", "X-Amz-Signature=abc", "session=abc", "AWS4-HMAC-SHA256"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("Expected %s to be scrubbed from the cassette:\n%s", secret, data)
+		}
+	}
+
+	server.Close()
+	replayer, err := New(path, ModeReplay, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = &http.Client{Transport: replayer}
+	// The user data differs from the recording, the requests are matched
+	// in order.
+	run.Set("UserData", "another-token")
+	for i, values := range []url.Values{run, describe, describe} {
+		body, err := post(client, values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := strings.Replace(recorded[i], "hunter2", Redacted, -1)
+		if body != expected {
+			t.Errorf("Request %d: expected %s, got %s", i, expected, body)
+		}
+	}
+	if _, err := post(client, describe); err == nil {
+		t.Error("Expected an error once the cassette is used up")
+	}
+}
+
+func TestTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv(EnvDir)
+	defer os.Unsetenv(EnvMode)
+
+	if rt := Transport("node-1", http.DefaultTransport); rt != http.DefaultTransport {
+		t.Errorf("Expected the base transport without cassette, got %T", rt)
+	}
+
+	os.Setenv(EnvDir, dir)
+	if !Replaying() {
+		t.Error("Expected cassettes to be replayed by default")
+	}
+	if _, err := Transport("node-1", nil).RoundTrip(&http.Request{Method: "GET", URL: &url.URL{Scheme: "https", Host: "ec2.amazonaws.com"}}); err == nil {
+		t.Error("Expected replaying a missing cassette to fail")
+	}
+
+	os.Setenv(EnvMode, ModeRecord)
+	if Replaying() {
+		t.Error("Expected cassettes to be recorded")
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	// Drivers create a client per call, their requests are recorded to
+	// the same cassette.
+	for i := 0; i < 2; i++ {
+		client := &http.Client{Transport: Transport("node-2", nil)}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	c, err := Load(filepath.Join(dir, "node-2.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Interactions) != 2 {
+		t.Errorf("Expected 2 interactions, got %d", len(c.Interactions))
+	}
+}
@@ -0,0 +1,110 @@
+package cgroups
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	DriverSystemd  = "systemd"
+	DriverCgroupfs = "cgroupfs"
+
+	// DetectCommand prints the file system type of the cgroup mount,
+	// cgroup2fs on the unified hierarchy of cgroup v2.
+	DetectCommand = "stat -fc %T /sys/fs/cgroup/"
+
+	// OpenRCMountCommand mounts the cgroup hierarchy on OpenRC nodes, e.g.
+	// Alpine, which only mount it if the cgroups service is enabled.
+	OpenRCMountCommand = "sudo rc-update add cgroups boot >/dev/null && { mountpoint -q /sys/fs/cgroup || sudo rc-service cgroups start; }"
+
+	// MemoryControllerCommand prints whether the memory controller is
+	// enabled, kernels for small boards disable it unless booted with
+	// cgroup_enable=memory.
+	MemoryControllerCommand = "awk '$1 == \"memory\" { print $4 }' /proc/cgroups"
+
+	engineOpt = "native.cgroupdriver="
+)
+
+var Drivers = []string{DriverSystemd, DriverCgroupfs}
+
+// ValidateDriver checks that the driver is supported.
+func ValidateDriver(driver string) error {
+	for _, d := range Drivers {
+		if d == driver {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unsupported cgroup driver %q, expected one of %s", driver, strings.Join(Drivers, ", "))
+}
+
+// ParseVersion returns the cgroup version of the output of DetectCommand.
+func ParseVersion(out string) (int, error) {
+	switch strings.TrimSpace(out) {
+	case "cgroup2fs":
+		return 2, nil
+	case "tmpfs":
+		return 1, nil
+	}
+	return 0, fmt.Errorf("Unknown cgroup file system %q", strings.TrimSpace(out))
+}
+
+// Validate refuses drivers which don't work with the cgroup version. On
+// cgroup v2 systemd manages the hierarchy, cgroupfs would make the kubelet
+// and the runtime fight over it.
+func Validate(driver string, version int) error {
+	if err := ValidateDriver(driver); err != nil {
+		return err
+	}
+	if version == 2 && driver != DriverSystemd {
+		return fmt.Errorf("The node runs cgroup v2 which requires the %s cgroup driver, not %s", DriverSystemd, driver)
+	}
+	return nil
+}
+
+// ValidateWithoutSystemd refuses the systemd driver on nodes with another
+// init system. Without systemd nothing else manages the hierarchy, so
+// cgroupfs works on both cgroup versions.
+func ValidateWithoutSystemd(driver, initSystem string) error {
+	if err := ValidateDriver(driver); err != nil {
+		return err
+	}
+	if driver == DriverSystemd {
+		return fmt.Errorf("The node runs %s, the %s cgroup driver requires systemd", initSystem, DriverSystemd)
+	}
+	return nil
+}
+
+// EngineOpt returns the engine exec option selecting the driver.
+func EngineOpt(driver string) string {
+	return engineOpt + driver
+}
+
+// EngineDriver returns the driver set by the engine flags, empty if none
+// is set.
+func EngineDriver(flags []string) string {
+	for _, f := range flags {
+		f = strings.TrimLeft(f, "-")
+		if !strings.HasPrefix(f, "exec-opt") {
+			continue
+		}
+		opt := strings.TrimLeft(strings.TrimPrefix(f, "exec-opt"), " =")
+		if strings.HasPrefix(opt, engineOpt) {
+			return strings.TrimPrefix(opt, engineOpt)
+		}
+	}
+	return ""
+}
+
+// EngineFlags returns the engine flags with the exec option selecting the
+// driver, so the runtime and the kubelet agree on it. A driver set in the
+// flags already needs to match.
+func EngineFlags(flags []string, driver string) ([]string, error) {
+	switch d := EngineDriver(flags); d {
+	case "":
+		return append(append([]string{}, flags...), "exec-opt "+EngineOpt(driver)), nil
+	case driver:
+		return flags, nil
+	default:
+		return nil, fmt.Errorf("The engine options set the cgroup driver %s, the kubelet uses %s", d, driver)
+	}
+}
@@ -0,0 +1,60 @@
+package cgroups
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	for out, expected := range map[string]int{"cgroup2fs\n": 2, "tmpfs\n": 1} {
+		if v, err := ParseVersion(out); err != nil || v != expected {
+			t.Errorf("%q: expected %d, got %d, %v", out, expected, v, err)
+		}
+	}
+	if _, err := ParseVersion("ext4"); err == nil {
+		t.Error("expected an error for an unknown file system")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate(DriverSystemd, 2); err != nil {
+		t.Error(err)
+	}
+	if err := Validate(DriverCgroupfs, 1); err != nil {
+		t.Error(err)
+	}
+	if err := Validate(DriverCgroupfs, 2); err == nil {
+		t.Error("expected cgroupfs on cgroup v2 to be refused")
+	}
+	if err := Validate("lxc", 1); err == nil {
+		t.Error("expected an unknown driver to be refused")
+	}
+}
+
+func TestEngineFlags(t *testing.T) {
+	flags, err := EngineFlags([]string{"log-level=debug"}, DriverSystemd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"log-level=debug", "exec-opt native.cgroupdriver=systemd"}; !reflect.DeepEqual(flags, expected) {
+		t.Errorf("expected %v, got %v", expected, flags)
+	}
+
+	flags, err = EngineFlags([]string{"exec-opt=native.cgroupdriver=cgroupfs"}, DriverCgroupfs)
+	if err != nil || len(flags) != 1 {
+		t.Errorf("expected the matching driver to be kept, got %v, %v", flags, err)
+	}
+
+	if _, err := EngineFlags([]string{"exec-opt native.cgroupdriver=cgroupfs"}, DriverSystemd); err == nil {
+		t.Error("expected mismatched drivers to be refused")
+	}
+}
+
+func TestValidateWithoutSystemd(t *testing.T) {
+	if err := ValidateWithoutSystemd(DriverCgroupfs, "openrc"); err != nil {
+		t.Error(err)
+	}
+	if err := ValidateWithoutSystemd(DriverSystemd, "openrc"); err == nil {
+		t.Error("expected the systemd driver to be refused without systemd")
+	}
+}
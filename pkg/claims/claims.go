@@ -0,0 +1,179 @@
+package claims
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Claim requests machines of a shared pool for a team. The controller
+// assigns free machines of the pool to the claim, the pool grows if it has
+// too few, and the nodes get the labels and taints of the claim for tenant
+// isolation until the claim releases them.
+type Claim struct {
+	Name string `json:"name"`
+	// Team the machines are charged to, the name of the claim if empty.
+	Team   string            `json:"team,omitempty"`
+	Pool   string            `json:"pool"`
+	Count  int               `json:"count"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// Taints are key=value:effect, e.g. team=a:NoSchedule.
+	Taints []string `json:"taints,omitempty"`
+}
+
+// Owner returns the team the machines of the claim are charged to.
+func (c Claim) Owner() string {
+	if c.Team != "" {
+		return c.Team
+	}
+	return c.Name
+}
+
+// Assignment records the claim owning a machine and the labels and taints
+// applied to its node, which are removed again when the claim releases it.
+type Assignment struct {
+	Claim  string            `json:"claim"`
+	Team   string            `json:"team,omitempty"`
+	Since  time.Time         `json:"since"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Taints []string          `json:"taints,omitempty"`
+}
+
+// The effects of taints.
+var effects = []string{"NoSchedule", "PreferNoSchedule", "NoExecute"}
+
+// ParseTaint splits a taint of the form key[=value]:effect.
+func ParseTaint(taint string) (key, value, effect string, err error) {
+	i := strings.LastIndex(taint, ":")
+	if i < 0 {
+		return "", "", "", fmt.Errorf("invalid taint %q, expected key=value:effect", taint)
+	}
+	key, effect = taint[:i], taint[i+1:]
+	if j := strings.Index(key, "="); j >= 0 {
+		key, value = key[:j], key[j+1:]
+	}
+	if key == "" {
+		return "", "", "", fmt.Errorf("invalid taint %q, the key is empty", taint)
+	}
+	for _, e := range effects {
+		if e == effect {
+			return key, value, effect, nil
+		}
+	}
+	return "", "", "", fmt.Errorf("invalid taint %q, expected one of the effects %s", taint, strings.Join(effects, ", "))
+}
+
+// Validate checks that the claims have unique names, a pool, a positive
+// count and valid labels and taints.
+func Validate(claims []Claim) error {
+	names := map[string]bool{}
+	for i, c := range claims {
+		if c.Name == "" {
+			return fmt.Errorf("Claim %d has no name", i)
+		}
+		if names[c.Name] {
+			return fmt.Errorf("Claim %s is specified more than once", c.Name)
+		}
+		names[c.Name] = true
+		if c.Pool == "" {
+			return fmt.Errorf("Claim %s has no pool", c.Name)
+		}
+		if c.Count <= 0 {
+			return fmt.Errorf("Claim %s needs a positive count", c.Name)
+		}
+		for k := range c.Labels {
+			if k == "" {
+				return fmt.Errorf("Claim %s has a label without key", c.Name)
+			}
+		}
+		for _, t := range c.Taints {
+			if _, _, _, err := ParseTaint(t); err != nil {
+				return fmt.Errorf("Claim %s: %v", c.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Demand returns the number of machines claimed of each pool.
+func Demand(claims []Claim) map[string]int {
+	demand := map[string]int{}
+	for _, c := range claims {
+		demand[c.Pool] += c.Count
+	}
+	return demand
+}
+
+// Machine is a machine of a pool the claims are assigned from.
+type Machine struct {
+	Name string
+	Pool string
+	// Claim owns the machine, it is empty for free machines.
+	Claim string
+	// Pinned machines keep their claim and aren't assigned, e.g. paused
+	// machines.
+	Pinned bool
+}
+
+// Allocation is the desired ownership of the machines.
+type Allocation struct {
+	// Owners maps the owned machines to their claims.
+	Owners map[string]string
+	// Missing is the number of machines each claim lacks.
+	Missing map[string]int
+}
+
+// Allocate assigns the machines to the claims. Machines keep their claim as
+// long as it exists and needs them, claims exceeding their count release
+// the machines with the highest names first. Free machines are assigned in
+// the order of the claims and of their names.
+func Allocate(claims []Claim, machines []Machine) Allocation {
+	byName := map[string]Claim{}
+	for _, c := range claims {
+		byName[c.Name] = c
+	}
+	sorted := append([]Machine{}, machines...)
+	sort.Sort(byMachineName(sorted))
+
+	a := Allocation{Owners: map[string]string{}, Missing: map[string]int{}}
+	assigned := map[string]int{}
+	for _, m := range sorted {
+		if m.Pinned && m.Claim != "" {
+			a.Owners[m.Name] = m.Claim
+			assigned[m.Claim]++
+		}
+	}
+	for _, m := range sorted {
+		if m.Pinned || m.Claim == "" {
+			continue
+		}
+		c, ok := byName[m.Claim]
+		if ok && c.Pool == m.Pool && assigned[c.Name] < c.Count {
+			a.Owners[m.Name] = c.Name
+			assigned[c.Name]++
+		}
+	}
+	for _, c := range claims {
+		for _, m := range sorted {
+			if assigned[c.Name] >= c.Count {
+				break
+			}
+			if _, owned := a.Owners[m.Name]; owned || m.Pinned || m.Pool != c.Pool {
+				continue
+			}
+			a.Owners[m.Name] = c.Name
+			assigned[c.Name]++
+		}
+		if missing := c.Count - assigned[c.Name]; missing > 0 {
+			a.Missing[c.Name] = missing
+		}
+	}
+	return a
+}
+
+type byMachineName []Machine
+
+func (m byMachineName) Len() int           { return len(m) }
+func (m byMachineName) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m byMachineName) Less(i, j int) bool { return m[i].Name < m[j].Name }
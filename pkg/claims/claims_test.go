@@ -0,0 +1,75 @@
+package claims
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAllocate(t *testing.T) {
+	claims := []Claim{
+		{Name: "team-a", Pool: "shared", Count: 2},
+		{Name: "team-b", Pool: "shared", Count: 2},
+		{Name: "team-c", Pool: "gpu", Count: 1},
+	}
+	machines := []Machine{
+		{Name: "shared-4", Pool: "shared", Claim: "team-a"},
+		{Name: "shared-3", Pool: "shared", Claim: "team-a"},
+		{Name: "shared-2", Pool: "shared", Claim: "team-a"},
+		{Name: "shared-1", Pool: "shared"},
+		{Name: "shared-5", Pool: "shared", Claim: "removed"},
+		{Name: "shared-6", Pool: "shared", Pinned: true},
+		{Name: "gpu-1", Pool: "gpu", Claim: "team-b"},
+	}
+
+	a := Allocate(claims, machines)
+	// team-a releases its highest machine, team-b gets the free ones and
+	// team-c gets the gpu machine team-b doesn't own as it's in another pool.
+	expected := map[string]string{
+		"shared-2": "team-a",
+		"shared-3": "team-a",
+		"shared-1": "team-b",
+		"shared-4": "team-b",
+		"gpu-1":    "team-c",
+	}
+	if !reflect.DeepEqual(a.Owners, expected) {
+		t.Errorf("Expected owners %v, got %v", expected, a.Owners)
+	}
+	if len(a.Missing) != 0 {
+		t.Errorf("Expected no missing machines, got %v", a.Missing)
+	}
+
+	a = Allocate(claims, []Machine{{Name: "shared-1", Pool: "shared", Claim: "team-b", Pinned: true}, {Name: "shared-2", Pool: "shared"}})
+	if !reflect.DeepEqual(a.Owners, map[string]string{"shared-1": "team-b", "shared-2": "team-a"}) {
+		t.Errorf("Expected pinned machines to keep their claim, got %v", a.Owners)
+	}
+	if !reflect.DeepEqual(a.Missing, map[string]int{"team-a": 1, "team-b": 1, "team-c": 1}) {
+		t.Errorf("Unexpected missing machines %v", a.Missing)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := []Claim{{Name: "team-a", Pool: "shared", Count: 1, Labels: map[string]string{"team": "a"}, Taints: []string{"team=a:NoSchedule", "dedicated:NoExecute"}}}
+	if err := Validate(valid); err != nil {
+		t.Errorf("Expected valid claims, got %v", err)
+	}
+	for _, claims := range [][]Claim{
+		{{Pool: "shared", Count: 1}},
+		{{Name: "team-a", Count: 1}},
+		{{Name: "team-a", Pool: "shared"}},
+		{{Name: "team-a", Pool: "shared", Count: 1}, {Name: "team-a", Pool: "shared", Count: 1}},
+		{{Name: "team-a", Pool: "shared", Count: 1, Taints: []string{"team=a"}}},
+		{{Name: "team-a", Pool: "shared", Count: 1, Taints: []string{"team=a:Never"}}},
+	} {
+		if err := Validate(claims); err == nil {
+			t.Errorf("Expected %+v to be invalid", claims)
+		}
+	}
+
+	key, value, effect, err := ParseTaint("team=a:NoSchedule")
+	if err != nil || key != "team" || value != "a" || effect != "NoSchedule" {
+		t.Errorf("Unexpected taint %s=%s:%s, %v", key, value, effect, err)
+	}
+	if !reflect.DeepEqual(Demand(valid), map[string]int{"shared": 1}) {
+		t.Errorf("Unexpected demand %v", Demand(valid))
+	}
+}
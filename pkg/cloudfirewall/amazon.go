@@ -0,0 +1,255 @@
+package cloudfirewall
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/kubermatic/kube-machine/pkg/firewall"
+)
+
+// The security group can only be deleted once the instances using it are
+// terminated, which takes a while after they were removed.
+var (
+	removeTimeout  = 5 * time.Minute
+	removeInterval = 10 * time.Second
+)
+
+type amazonDriver struct {
+	AccessKey, SecretKey, SessionToken string
+	Region, InstanceId, VpcId          string
+	Endpoint                           string
+	DisableSSL                         bool
+}
+
+func amazonClient(rawDriver []byte) (*amazonDriver, *ec2.EC2, error) {
+	d := &amazonDriver{}
+	if err := json.Unmarshal(rawDriver, d); err != nil {
+		return nil, nil, err
+	}
+	config := aws.NewConfig().WithRegion(d.Region)
+	if d.AccessKey != "" {
+		config = config.WithCredentials(credentials.NewStaticCredentials(d.AccessKey, d.SecretKey, d.SessionToken))
+	}
+	if d.Endpoint != "" {
+		config = config.WithEndpoint(d.Endpoint).WithDisableSSL(d.DisableSSL)
+	}
+	return d, ec2.New(session.New(config)), nil
+}
+
+// amazonGroup returns the security group of the cluster in the VPC, it is
+// nil if there is none.
+func amazonGroup(client *ec2.EC2, cluster, vpcID string) (*ec2.SecurityGroup, error) {
+	out, err := client.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("group-name"), Values: []*string{aws.String(Name(cluster))}},
+			{Name: aws.String("vpc-id"), Values: []*string{aws.String(vpcID)}},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to look up security group %s: %v", Name(cluster), err)
+	}
+	if len(out.SecurityGroups) == 0 {
+		return nil, nil
+	}
+	return out.SecurityGroups[0], nil
+}
+
+// amazonCreateGroup creates the security group of the cluster in the VPC. A
+// parallel attachment may have created it first, then it is read again.
+func amazonCreateGroup(client *ec2.EC2, cluster, vpcID string) (*ec2.SecurityGroup, error) {
+	created, err := client.CreateSecurityGroup(&ec2.CreateSecurityGroupInput{
+		GroupName:   aws.String(Name(cluster)),
+		Description: aws.String(fmt.Sprintf("Machines of cluster %s managed by kube-machine", cluster)),
+		VpcId:       aws.String(vpcID),
+	})
+	if amazonErrorCode(err, "InvalidGroup.Duplicate") {
+		group, err := amazonGroup(client, cluster, vpcID)
+		if err == nil && group == nil {
+			err = fmt.Errorf("Failed to look up security group %s: it exists but was not found", Name(cluster))
+		}
+		return group, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create security group %s: %v", Name(cluster), err)
+	}
+	groupID := aws.StringValue(created.GroupId)
+	if _, err := client.CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{aws.String(groupID)},
+		Tags:      []*ec2.Tag{{Key: aws.String(TagKey), Value: aws.String(cluster)}},
+	}); err != nil {
+		return nil, fmt.Errorf("Failed to tag security group %s: %v", groupID, err)
+	}
+	return &ec2.SecurityGroup{GroupId: created.GroupId}, nil
+}
+
+func amazonAttach(rawDriver []byte, cluster string, rules Rules) error {
+	d, client, err := amazonClient(rawDriver)
+	if err != nil {
+		return err
+	}
+	out, err := client.DescribeInstances(&ec2.DescribeInstancesInput{InstanceIds: []*string{aws.String(d.InstanceId)}})
+	if err != nil {
+		return fmt.Errorf("Failed to describe instance %s: %v", d.InstanceId, err)
+	}
+	if len(out.Reservations) == 0 || len(out.Reservations[0].Instances) == 0 {
+		return fmt.Errorf("Instance %s does not exist", d.InstanceId)
+	}
+	instance := out.Reservations[0].Instances[0]
+	vpcID := aws.StringValue(instance.VpcId)
+
+	group, err := amazonGroup(client, cluster, vpcID)
+	if err == nil && group == nil {
+		group, err = amazonCreateGroup(client, cluster, vpcID)
+	}
+	if err != nil {
+		return err
+	}
+	groupID := aws.StringValue(group.GroupId)
+
+	// The rules follow changes of the rules of kube-machine, stale ones are
+	// revoked.
+	authorize, revoke := amazonDiff(group.IpPermissions, amazonPermissions(groupID, rules))
+	for _, permission := range revoke {
+		_, err := client.RevokeSecurityGroupIngress(&ec2.RevokeSecurityGroupIngressInput{
+			GroupId:       aws.String(groupID),
+			IpPermissions: []*ec2.IpPermission{permission},
+		})
+		if err != nil && !amazonErrorCode(err, "InvalidPermission.NotFound") {
+			return fmt.Errorf("Failed to revoke ingress of security group %s: %v", groupID, err)
+		}
+	}
+	for _, permission := range authorize {
+		_, err := client.AuthorizeSecurityGroupIngress(&ec2.AuthorizeSecurityGroupIngressInput{
+			GroupId:       aws.String(groupID),
+			IpPermissions: []*ec2.IpPermission{permission},
+		})
+		if err != nil && !amazonErrorCode(err, "InvalidPermission.Duplicate") {
+			return fmt.Errorf("Failed to authorize ingress of security group %s: %v", groupID, err)
+		}
+	}
+
+	groups := []*string{}
+	for _, g := range instance.SecurityGroups {
+		if aws.StringValue(g.GroupId) == groupID {
+			return nil
+		}
+		groups = append(groups, g.GroupId)
+	}
+	if _, err := client.ModifyInstanceAttribute(&ec2.ModifyInstanceAttributeInput{
+		InstanceId: aws.String(d.InstanceId),
+		Groups:     append(groups, aws.String(groupID)),
+	}); err != nil {
+		return fmt.Errorf("Failed to add security group %s to instance %s: %v", groupID, d.InstanceId, err)
+	}
+	return nil
+}
+
+func amazonPermissions(groupID string, rules Rules) []*ec2.IpPermission {
+	var permissions []*ec2.IpPermission
+	permission := func(p firewall.Port) *ec2.IpPermission {
+		return &ec2.IpPermission{
+			IpProtocol: aws.String(p.Protocol),
+			FromPort:   aws.Int64(int64(p.From)),
+			ToPort:     aws.Int64(int64(p.To)),
+		}
+	}
+	for _, p := range rules.Public {
+		perm := permission(p)
+		perm.IpRanges = []*ec2.IpRange{{CidrIp: aws.String("0.0.0.0/0")}}
+		permissions = append(permissions, perm)
+	}
+	for _, p := range rules.ControlPlane {
+		perm := permission(p)
+		perm.IpRanges = []*ec2.IpRange{{CidrIp: aws.String(rules.ControlPlaneCIDR)}}
+		permissions = append(permissions, perm)
+	}
+	for _, p := range rules.Nodes {
+		perm := permission(p)
+		perm.UserIdGroupPairs = []*ec2.UserIdGroupPair{{GroupId: aws.String(groupID)}}
+		permissions = append(permissions, perm)
+	}
+	return permissions
+}
+
+// amazonDiff returns the desired permissions missing in the current ones of
+// the security group and the current ones which are not desired. Each
+// returned permission has a single source.
+func amazonDiff(current, desired []*ec2.IpPermission) (authorize, revoke []*ec2.IpPermission) {
+	have := map[string]bool{}
+	for _, p := range amazonSplit(current) {
+		have[amazonKey(p)] = true
+	}
+	want := map[string]bool{}
+	for _, p := range amazonSplit(desired) {
+		key := amazonKey(p)
+		want[key] = true
+		if !have[key] {
+			authorize = append(authorize, p)
+		}
+	}
+	for _, p := range amazonSplit(current) {
+		if !want[amazonKey(p)] {
+			revoke = append(revoke, p)
+		}
+	}
+	return authorize, revoke
+}
+
+// amazonSplit returns a permission for each source of the permissions.
+func amazonSplit(permissions []*ec2.IpPermission) []*ec2.IpPermission {
+	var split []*ec2.IpPermission
+	for _, p := range permissions {
+		for _, r := range p.IpRanges {
+			split = append(split, &ec2.IpPermission{IpProtocol: p.IpProtocol, FromPort: p.FromPort, ToPort: p.ToPort, IpRanges: []*ec2.IpRange{{CidrIp: r.CidrIp}}})
+		}
+		for _, g := range p.UserIdGroupPairs {
+			split = append(split, &ec2.IpPermission{IpProtocol: p.IpProtocol, FromPort: p.FromPort, ToPort: p.ToPort, UserIdGroupPairs: []*ec2.UserIdGroupPair{{GroupId: g.GroupId}}})
+		}
+	}
+	return split
+}
+
+func amazonKey(p *ec2.IpPermission) string {
+	source := ""
+	if len(p.IpRanges) > 0 {
+		source = aws.StringValue(p.IpRanges[0].CidrIp)
+	} else if len(p.UserIdGroupPairs) > 0 {
+		source = aws.StringValue(p.UserIdGroupPairs[0].GroupId)
+	}
+	return fmt.Sprintf("%s/%d-%d/%s", aws.StringValue(p.IpProtocol), aws.Int64Value(p.FromPort), aws.Int64Value(p.ToPort), source)
+}
+
+func amazonRemove(rawDriver []byte, cluster string) error {
+	d, client, err := amazonClient(rawDriver)
+	if err != nil {
+		return err
+	}
+	group, err := amazonGroup(client, cluster, d.VpcId)
+	if err != nil || group == nil {
+		return err
+	}
+	groupID := aws.StringValue(group.GroupId)
+
+	deadline := time.Now().Add(removeTimeout)
+	for {
+		_, err := client.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{GroupId: aws.String(groupID)})
+		if err == nil {
+			return nil
+		}
+		if !amazonErrorCode(err, "DependencyViolation") || time.Now().After(deadline) {
+			return fmt.Errorf("Failed to delete security group %s: %v", groupID, err)
+		}
+		time.Sleep(removeInterval)
+	}
+}
+
+func amazonErrorCode(err error, code string) bool {
+	e, ok := err.(awserr.Error)
+	return ok && e.Code() == code
+}
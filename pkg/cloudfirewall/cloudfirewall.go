@@ -0,0 +1,110 @@
+package cloudfirewall
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+
+	"github.com/kubermatic/kube-machine/pkg/firewall"
+)
+
+// TagKey is the tag marking the firewall and the machines of a cluster at
+// the cloud provider.
+const TagKey = "kube-machine-cluster"
+
+// Rules are the ingress rules of the firewall of a cluster. All egress is
+// allowed, the nodes reach the API server and the registries.
+type Rules struct {
+	// Public ports are open to everyone, SSH and the engine are needed by
+	// kube-machine.
+	Public []firewall.Port
+	// ControlPlane ports are open to the ControlPlaneCIDR.
+	ControlPlane     []firewall.Port
+	ControlPlaneCIDR string
+	// Nodes ports are open between the machines of the cluster.
+	Nodes []firewall.Port
+}
+
+// ClusterRules returns the rules of the machines of a cluster: SSH, the
+// engine and the node ports are public, the kubelet accepts the control
+// plane and the ports of the CNI accept the other machines.
+func ClusterRules(sshPort, enginePort int, controlPlaneCIDR, cni string) (Rules, error) {
+	if _, _, err := net.ParseCIDR(controlPlaneCIDR); err != nil {
+		return Rules{}, fmt.Errorf("Invalid control plane CIDR %q", controlPlaneCIDR)
+	}
+	cniPorts, err := firewall.CNIPorts(cni)
+	if err != nil {
+		return Rules{}, err
+	}
+	public := []firewall.Port{
+		{From: sshPort, To: sshPort, Protocol: "tcp"},
+		{From: enginePort, To: enginePort, Protocol: "tcp"},
+	}
+	return Rules{
+		Public:           append(public, firewall.NodePorts...),
+		ControlPlane:     []firewall.Port{{From: firewall.KubeletPort, To: firewall.KubeletPort, Protocol: "tcp"}},
+		ControlPlaneCIDR: controlPlaneCIDR,
+		Nodes:            cniPorts,
+	}, nil
+}
+
+var clusterName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,38}[a-z0-9])?$`)
+
+// ValidateCluster checks that the cluster name can be part of the names and
+// tags of all providers.
+func ValidateCluster(cluster string) error {
+	if !clusterName.MatchString(cluster) {
+		return fmt.Errorf("Invalid cluster name %q: at most 40 lowercase alphanumeric characters or '-'", cluster)
+	}
+	return nil
+}
+
+// Name returns the name of the firewall of the cluster.
+func Name(cluster string) string {
+	return "kube-machine-" + cluster
+}
+
+// provider manages the firewalls given the stored configuration of the
+// driver of a machine.
+type provider struct {
+	// attach creates or updates the firewall of the cluster and attaches
+	// the machine to it.
+	attach func(rawDriver []byte, cluster string, rules Rules) error
+	// remove deletes the firewall of the cluster, it is a no-op if it
+	// doesn't exist.
+	remove func(rawDriver []byte, cluster string) error
+}
+
+// providers holds the drivers supporting cloud firewalls. The cloud APIs are
+// called directly, as the plugin RPC shim only forwards the methods of
+// drivers.Driver.
+var providers = map[string]provider{
+	"amazonec2":    {attach: amazonAttach, remove: amazonRemove},
+	"digitalocean": {attach: digitaloceanAttach, remove: digitaloceanRemove},
+}
+
+// Supported returns whether the firewalls of the driver can be managed.
+func Supported(driver string) bool {
+	_, ok := providers[driver]
+	return ok
+}
+
+// Attach creates the firewall of the cluster or updates its rules, and
+// attaches the machine to it.
+func Attach(driver string, rawDriver []byte, cluster string, rules Rules) error {
+	p, ok := providers[driver]
+	if !ok {
+		return fmt.Errorf("%s driver does not support cloud firewalls", driver)
+	}
+	return p.attach(rawDriver, cluster, rules)
+}
+
+// Remove deletes the firewall of the cluster, e.g. once its last machine
+// was removed.
+func Remove(driver string, rawDriver []byte, cluster string) error {
+	p, ok := providers[driver]
+	if !ok {
+		return fmt.Errorf("%s driver does not support cloud firewalls", driver)
+	}
+	return p.remove(rawDriver, cluster)
+}
@@ -0,0 +1,110 @@
+package cloudfirewall
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/kubermatic/kube-machine/pkg/firewall"
+)
+
+func TestClusterRules(t *testing.T) {
+	rules, err := ClusterRules(22, 2376, "10.0.0.0/24", "flannel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Rules{
+		Public:           append([]firewall.Port{{From: 22, To: 22, Protocol: "tcp"}, {From: 2376, To: 2376, Protocol: "tcp"}}, firewall.NodePorts...),
+		ControlPlane:     []firewall.Port{{From: 10250, To: 10250, Protocol: "tcp"}},
+		ControlPlaneCIDR: "10.0.0.0/24",
+		Nodes:            []firewall.Port{{From: 8472, To: 8472, Protocol: "udp"}},
+	}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("expected %+v, got %+v", expected, rules)
+	}
+
+	if _, err := ClusterRules(22, 2376, "10.0.0.1", ""); err == nil {
+		t.Error("expected an error for an invalid CIDR")
+	}
+	if _, err := ClusterRules(22, 2376, "10.0.0.0/24", "unknown"); err == nil {
+		t.Error("expected an error for an unknown CNI")
+	}
+}
+
+func TestValidateCluster(t *testing.T) {
+	for _, name := range []string{"prod", "eu-west-1"} {
+		if err := ValidateCluster(name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "Prod", "prod-", "prod_1", "a-very-long-cluster-name-exceeding-forty-characters"} {
+		if err := ValidateCluster(name); err == nil {
+			t.Errorf("%q: expected an error", name)
+		}
+	}
+}
+
+func TestDigitaloceanFirewall(t *testing.T) {
+	rules, err := ClusterRules(22, 2376, "10.0.0.0/24", "flannel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := digitaloceanFirewallOf("prod", rules)
+	if f.Name != "kube-machine-prod" || !reflect.DeepEqual(f.Tags, []string{"kube-machine-cluster:prod"}) {
+		t.Errorf("unexpected firewall %+v", f)
+	}
+	expected := []digitaloceanRule{
+		{Protocol: "tcp", Ports: "22", Sources: &digitaloceanEndpoint{Addresses: everywhere}},
+		{Protocol: "tcp", Ports: "2376", Sources: &digitaloceanEndpoint{Addresses: everywhere}},
+		{Protocol: "tcp", Ports: "30000-32767", Sources: &digitaloceanEndpoint{Addresses: everywhere}},
+		{Protocol: "udp", Ports: "30000-32767", Sources: &digitaloceanEndpoint{Addresses: everywhere}},
+		{Protocol: "tcp", Ports: "10250", Sources: &digitaloceanEndpoint{Addresses: []string{"10.0.0.0/24"}}},
+		{Protocol: "udp", Ports: "8472", Sources: &digitaloceanEndpoint{Tags: []string{"kube-machine-cluster:prod"}}},
+	}
+	if !reflect.DeepEqual(f.InboundRules, expected) {
+		t.Errorf("expected %+v, got %+v", expected, f.InboundRules)
+	}
+	if len(f.OutboundRules) != 3 {
+		t.Errorf("expected all egress, got %+v", f.OutboundRules)
+	}
+}
+
+func TestDigitaloceanOldest(t *testing.T) {
+	firewalls := []digitaloceanFirewall{
+		{ID: "b", Name: "kube-machine-prod", CreatedAt: "2017-06-01T10:00:01Z"},
+		{ID: "c", Name: "kube-machine-staging", CreatedAt: "2017-06-01T09:00:00Z"},
+		{ID: "a", Name: "kube-machine-prod", CreatedAt: "2017-06-01T10:00:00Z"},
+	}
+	if ids := digitaloceanOldest(firewalls, "prod"); !reflect.DeepEqual(ids, []string{"a", "b"}) {
+		t.Errorf("expected the firewalls of the cluster oldest first, got %v", ids)
+	}
+}
+
+func TestAmazonDiff(t *testing.T) {
+	permission := func(protocol string, port int64, source string) *ec2.IpPermission {
+		p := &ec2.IpPermission{IpProtocol: aws.String(protocol), FromPort: aws.Int64(port), ToPort: aws.Int64(port)}
+		if strings.HasPrefix(source, "sg-") {
+			p.UserIdGroupPairs = []*ec2.UserIdGroupPair{{GroupId: aws.String(source)}}
+		} else {
+			p.IpRanges = []*ec2.IpRange{{CidrIp: aws.String(source)}}
+		}
+		return p
+	}
+	current := []*ec2.IpPermission{
+		{IpProtocol: aws.String("tcp"), FromPort: aws.Int64(22), ToPort: aws.Int64(22), IpRanges: []*ec2.IpRange{{CidrIp: aws.String("0.0.0.0/0")}, {CidrIp: aws.String("10.1.0.0/16")}}},
+		permission("udp", 8472, "sg-1"),
+	}
+	desired := []*ec2.IpPermission{
+		permission("tcp", 22, "0.0.0.0/0"),
+		permission("udp", 4789, "sg-1"),
+	}
+	authorize, revoke := amazonDiff(current, desired)
+	if !reflect.DeepEqual(authorize, []*ec2.IpPermission{permission("udp", 4789, "sg-1")}) {
+		t.Errorf("unexpected permissions to authorize %v", authorize)
+	}
+	if !reflect.DeepEqual(revoke, []*ec2.IpPermission{permission("tcp", 22, "10.1.0.0/16"), permission("udp", 8472, "sg-1")}) {
+		t.Errorf("unexpected permissions to revoke %v", revoke)
+	}
+}
@@ -0,0 +1,206 @@
+package cloudfirewall
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/digitalocean/godo"
+	"github.com/kubermatic/kube-machine/pkg/firewall"
+	"golang.org/x/oauth2"
+)
+
+// The firewalls API isn't part of the godo version of the drivers, it is
+// called with the requests of the godo client.
+
+type digitaloceanFirewall struct {
+	ID            string             `json:"id,omitempty"`
+	CreatedAt     string             `json:"created_at,omitempty"`
+	Name          string             `json:"name"`
+	InboundRules  []digitaloceanRule `json:"inbound_rules"`
+	OutboundRules []digitaloceanRule `json:"outbound_rules"`
+	Tags          []string           `json:"tags"`
+}
+
+type digitaloceanRule struct {
+	Protocol     string                `json:"protocol"`
+	Ports        string                `json:"ports,omitempty"`
+	Sources      *digitaloceanEndpoint `json:"sources,omitempty"`
+	Destinations *digitaloceanEndpoint `json:"destinations,omitempty"`
+}
+
+type digitaloceanEndpoint struct {
+	Addresses []string `json:"addresses,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+var everywhere = []string{"0.0.0.0/0", "::/0"}
+
+// digitaloceanTag returns the tag of the droplets of the cluster, the
+// firewall applies to them.
+func digitaloceanTag(cluster string) string {
+	return TagKey + ":" + cluster
+}
+
+func digitaloceanClient(rawDriver []byte) (*godo.Client, int, error) {
+	var d struct {
+		AccessToken string
+		DropletID   int
+	}
+	if err := json.Unmarshal(rawDriver, &d); err != nil {
+		return nil, 0, err
+	}
+	return godo.NewClient(oauth2.NewClient(oauth2.NoContext, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: d.AccessToken}))), d.DropletID, nil
+}
+
+func digitaloceanFirewallOf(cluster string, rules Rules) *digitaloceanFirewall {
+	f := &digitaloceanFirewall{Name: Name(cluster), Tags: []string{digitaloceanTag(cluster)}}
+	inbound := func(ports []firewall.Port, sources *digitaloceanEndpoint) {
+		for _, p := range ports {
+			f.InboundRules = append(f.InboundRules, digitaloceanRule{Protocol: p.Protocol, Ports: digitaloceanPorts(p), Sources: sources})
+		}
+	}
+	inbound(rules.Public, &digitaloceanEndpoint{Addresses: everywhere})
+	inbound(rules.ControlPlane, &digitaloceanEndpoint{Addresses: []string{rules.ControlPlaneCIDR}})
+	inbound(rules.Nodes, &digitaloceanEndpoint{Tags: []string{digitaloceanTag(cluster)}})
+	for _, protocol := range []string{"tcp", "udp"} {
+		f.OutboundRules = append(f.OutboundRules, digitaloceanRule{Protocol: protocol, Ports: "all", Destinations: &digitaloceanEndpoint{Addresses: everywhere}})
+	}
+	f.OutboundRules = append(f.OutboundRules, digitaloceanRule{Protocol: "icmp", Destinations: &digitaloceanEndpoint{Addresses: everywhere}})
+	return f
+}
+
+func digitaloceanPorts(p firewall.Port) string {
+	if p.From == p.To {
+		return strconv.Itoa(p.From)
+	}
+	return fmt.Sprintf("%d-%d", p.From, p.To)
+}
+
+// digitaloceanFind returns the IDs of the firewalls of the cluster, the
+// oldest first.
+func digitaloceanFind(client *godo.Client, cluster string) ([]string, error) {
+	req, err := client.NewRequest(http.MethodGet, "v2/firewalls?per_page=200", nil)
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Firewalls []digitaloceanFirewall `json:"firewalls"`
+	}
+	if _, err := client.Do(req, &list); err != nil {
+		return nil, fmt.Errorf("Failed to list firewalls: %v", err)
+	}
+	return digitaloceanOldest(list.Firewalls, cluster), nil
+}
+
+// digitaloceanOldest returns the IDs of the firewalls of the cluster, the
+// oldest first. Parallel attachments may have created it more than once.
+func digitaloceanOldest(firewalls []digitaloceanFirewall, cluster string) []string {
+	var keys []string
+	ids := map[string]string{}
+	for _, f := range firewalls {
+		if f.Name == Name(cluster) {
+			key := f.CreatedAt + " " + f.ID
+			keys = append(keys, key)
+			ids[key] = f.ID
+		}
+	}
+	sort.Strings(keys)
+	var oldest []string
+	for _, key := range keys {
+		oldest = append(oldest, ids[key])
+	}
+	return oldest
+}
+
+func digitaloceanDelete(client *godo.Client, id string) error {
+	req, err := client.NewRequest(http.MethodDelete, "v2/firewalls/"+id, nil)
+	if err != nil {
+		return err
+	}
+	if resp, err := client.Do(req, nil); err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return fmt.Errorf("Failed to delete firewall %s: %v", id, err)
+	}
+	return nil
+}
+
+func digitaloceanAttach(rawDriver []byte, cluster string, rules Rules) error {
+	client, dropletID, err := digitaloceanClient(rawDriver)
+	if err != nil {
+		return err
+	}
+
+	tag := digitaloceanTag(cluster)
+	if _, _, err := client.Tags.Create(&godo.TagCreateRequest{Name: tag}); err != nil && !exists(err) {
+		return fmt.Errorf("Failed to create tag %s: %v", tag, err)
+	}
+	resources := []godo.Resource{{ID: strconv.Itoa(dropletID), Type: godo.DropletResourceType}}
+	if _, err := client.Tags.TagResources(tag, &godo.TagResourcesRequest{Resources: resources}); err != nil {
+		return fmt.Errorf("Failed to tag droplet %d with %s: %v", dropletID, tag, err)
+	}
+
+	ids, err := digitaloceanFind(client, cluster)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		req, err := client.NewRequest(http.MethodPost, "v2/firewalls", digitaloceanFirewallOf(cluster, rules))
+		if err != nil {
+			return err
+		}
+		if _, err := client.Do(req, nil); err != nil {
+			return fmt.Errorf("Failed to create firewall %s: %v", Name(cluster), err)
+		}
+		if ids, err = digitaloceanFind(client, cluster); err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return fmt.Errorf("Failed to find firewall %s after creating it", Name(cluster))
+		}
+	}
+	// A parallel attachment may have created the firewall as well, the
+	// oldest one is kept.
+	for _, id := range ids[1:] {
+		if err := digitaloceanDelete(client, id); err != nil {
+			return err
+		}
+	}
+	// The rules are replaced on every attachment, so they follow changes
+	// of the rules of kube-machine and stale ones are removed.
+	req, err := client.NewRequest(http.MethodPut, "v2/firewalls/"+ids[0], digitaloceanFirewallOf(cluster, rules))
+	if err != nil {
+		return err
+	}
+	if _, err := client.Do(req, nil); err != nil {
+		return fmt.Errorf("Failed to save firewall %s: %v", Name(cluster), err)
+	}
+	return nil
+}
+
+func digitaloceanRemove(rawDriver []byte, cluster string) error {
+	client, _, err := digitaloceanClient(rawDriver)
+	if err != nil {
+		return err
+	}
+	ids, err := digitaloceanFind(client, cluster)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	for _, id := range ids {
+		if err := digitaloceanDelete(client, id); err != nil {
+			return err
+		}
+	}
+	if _, err := client.Tags.Delete(digitaloceanTag(cluster)); err != nil {
+		return fmt.Errorf("Failed to delete tag %s: %v", digitaloceanTag(cluster), err)
+	}
+	return nil
+}
+
+// exists returns whether the creation of a tag failed as it exists.
+func exists(err error) bool {
+	e, ok := err.(*godo.ErrorResponse)
+	return ok && e.Response != nil && e.Response.StatusCode == http.StatusUnprocessableEntity
+}
@@ -0,0 +1,161 @@
+package cloudtags
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/digitalocean/godo"
+	"github.com/kubermatic/kube-machine/pkg/resourcetags"
+	"golang.org/x/oauth2"
+)
+
+// syncer updates the tags of a machine given the stored configuration of
+// its driver, previous are the tags it set before.
+type syncer func(rawDriver []byte, previous, desired map[string]string) error
+
+// syncers holds the drivers supporting tags. The cloud APIs are called
+// directly, as the plugin RPC shim only forwards the methods of
+// drivers.Driver.
+var syncers = map[string]syncer{
+	"amazonec2":    amazonTags,
+	"digitalocean": digitaloceanTags,
+}
+
+// Supported returns whether machines of the driver can be tagged.
+func Supported(driver string) bool {
+	_, ok := syncers[driver]
+	return ok
+}
+
+// Sync sets the desired tags of the machine and removes the previous tags
+// which are no longer desired. Other tags of the machine are kept.
+func Sync(driver string, rawDriver []byte, previous, desired map[string]string) error {
+	sync, ok := syncers[driver]
+	if !ok {
+		return fmt.Errorf("%s driver does not support tags", driver)
+	}
+	return sync(rawDriver, previous, desired)
+}
+
+func amazonTags(rawDriver []byte, previous, desired map[string]string) error {
+	var d struct {
+		AccessKey, SecretKey, SessionToken string
+		Region, InstanceId, Endpoint       string
+		DisableSSL                         bool
+	}
+	if err := json.Unmarshal(rawDriver, &d); err != nil {
+		return err
+	}
+
+	config := aws.NewConfig().WithRegion(d.Region)
+	if d.AccessKey != "" {
+		config = config.WithCredentials(credentials.NewStaticCredentials(d.AccessKey, d.SecretKey, d.SessionToken))
+	}
+	if d.Endpoint != "" {
+		config = config.WithEndpoint(d.Endpoint).WithDisableSSL(d.DisableSSL)
+	}
+	client := ec2.New(session.New(config))
+	resources, err := amazonResources(client, d.InstanceId)
+	if err != nil {
+		return err
+	}
+
+	var removed []*ec2.Tag
+	for k := range previous {
+		if _, ok := desired[k]; !ok {
+			removed = append(removed, &ec2.Tag{Key: aws.String(k)})
+		}
+	}
+	if len(removed) > 0 {
+		if _, err := client.DeleteTags(&ec2.DeleteTagsInput{
+			Resources: resources,
+			Tags:      removed,
+		}); err != nil {
+			return fmt.Errorf("Failed to remove tags of instance %s: %v", d.InstanceId, err)
+		}
+	}
+
+	var tags []*ec2.Tag
+	for k, v := range desired {
+		tags = append(tags, &ec2.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	if len(tags) > 0 {
+		if _, err := client.CreateTags(&ec2.CreateTagsInput{
+			Resources: resources,
+			Tags:      tags,
+		}); err != nil {
+			return fmt.Errorf("Failed to tag instance %s: %v", d.InstanceId, err)
+		}
+	}
+	return nil
+}
+
+// amazonResources returns the instance and its volumes, which show up in
+// the bill on their own.
+func amazonResources(client *ec2.EC2, instanceID string) ([]*string, error) {
+	out, err := client.DescribeInstances(&ec2.DescribeInstancesInput{InstanceIds: []*string{aws.String(instanceID)}})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get the volumes of instance %s: %v", instanceID, err)
+	}
+	resources := []*string{aws.String(instanceID)}
+	for _, r := range out.Reservations {
+		for _, i := range r.Instances {
+			for _, b := range i.BlockDeviceMappings {
+				if b.Ebs != nil && b.Ebs.VolumeId != nil {
+					resources = append(resources, b.Ebs.VolumeId)
+				}
+			}
+		}
+	}
+	return resources, nil
+}
+
+// digitaloceanTag returns the tag of the metadata, DigitalOcean tags are
+// plain names.
+func digitaloceanTag(key, value string) string {
+	return resourcetags.DigitalOcean(key, value)
+}
+
+func digitaloceanTags(rawDriver []byte, previous, desired map[string]string) error {
+	var d struct {
+		AccessToken string
+		DropletID   int
+	}
+	if err := json.Unmarshal(rawDriver, &d); err != nil {
+		return err
+	}
+	client := godo.NewClient(oauth2.NewClient(oauth2.NoContext, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: d.AccessToken})))
+	resources := []godo.Resource{{ID: strconv.Itoa(d.DropletID), Type: godo.DropletResourceType}}
+
+	for k, v := range previous {
+		if dv, ok := desired[k]; ok && dv == v {
+			continue
+		}
+		tag := digitaloceanTag(k, v)
+		if _, err := client.Tags.UntagResources(tag, &godo.UntagResourcesRequest{Resources: resources}); err != nil {
+			return fmt.Errorf("Failed to remove tag %s of droplet %d: %v", tag, d.DropletID, err)
+		}
+	}
+	for k, v := range desired {
+		tag := digitaloceanTag(k, v)
+		if _, _, err := client.Tags.Create(&godo.TagCreateRequest{Name: tag}); err != nil && !exists(err) {
+			return fmt.Errorf("Failed to create tag %s: %v", tag, err)
+		}
+		if _, err := client.Tags.TagResources(tag, &godo.TagResourcesRequest{Resources: resources}); err != nil {
+			return fmt.Errorf("Failed to tag droplet %d with %s: %v", d.DropletID, tag, err)
+		}
+	}
+	return nil
+}
+
+// exists returns whether the creation of a tag failed as it exists.
+func exists(err error) bool {
+	e, ok := err.(*godo.ErrorResponse)
+	return ok && e.Response != nil && e.Response.StatusCode == http.StatusUnprocessableEntity
+}
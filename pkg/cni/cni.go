@@ -0,0 +1,149 @@
+package cni
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/kubermatic/kube-machine/pkg/remote"
+)
+
+const (
+	// ModulesPath loads the kernel modules of the profile at boot.
+	ModulesPath = "/etc/modules-load.d/kube-machine-cni.conf"
+	// SysctlPath sets the sysctls of the profile at boot.
+	SysctlPath = "/etc/sysctl.d/90-kube-machine-cni.conf"
+)
+
+// Sysctl is a kernel parameter and its value.
+type Sysctl struct {
+	Key   string
+	Value string
+}
+
+// File is an additional configuration file of a profile.
+type File struct {
+	Path    string
+	Content string
+}
+
+// Profile prepares nodes for a network plugin. The ports it needs are opened
+// by the firewall of the machine.
+type Profile struct {
+	Name         string
+	Modules      []string
+	Sysctls      []Sysctl
+	KubeletFlags []string
+	// ExtraFiles are written in addition to the modules and sysctls.
+	ExtraFiles []File
+}
+
+var profiles = map[string]Profile{
+	"calico": {
+		Name:    "calico",
+		Modules: []string{"ip_tables", "ip_set", "ipip", "xt_set", "xt_mark"},
+		Sysctls: []Sysctl{
+			{"net.ipv4.ip_forward", "1"},
+			// Felix refuses to start with loose reverse path filtering.
+			{"net.ipv4.conf.all.rp_filter", "1"},
+		},
+	},
+	"cilium": {
+		Name:    "cilium",
+		Modules: []string{"vxlan", "xt_socket", "xt_TPROXY", "sch_ingress", "cls_bpf"},
+		Sysctls: []Sysctl{
+			{"net.ipv4.ip_forward", "1"},
+			{"net.ipv4.conf.all.rp_filter", "0"},
+			{"net.ipv4.conf.default.rp_filter", "0"},
+		},
+		// Cilium masquerades the pod traffic itself.
+		KubeletFlags: []string{"--non-masquerade-cidr=0.0.0.0/0"},
+		ExtraFiles: []File{{
+			// systemd-networkd must not remove the routes and rules
+			// Cilium installs.
+			Path:    "/etc/systemd/networkd.conf.d/kube-machine-cilium.conf",
+			Content: "[Network]\nManageForeignRoutes=no\nManageForeignRoutingPolicyRules=no\n",
+		}},
+	},
+	"flannel": {
+		Name:    "flannel",
+		Modules: []string{"br_netfilter", "vxlan"},
+		Sysctls: []Sysctl{
+			{"net.ipv4.ip_forward", "1"},
+			{"net.bridge.bridge-nf-call-iptables", "1"},
+			{"net.bridge.bridge-nf-call-ip6tables", "1"},
+		},
+	},
+}
+
+// Names returns the names of the profiles.
+func Names() []string {
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate checks that the profile is known, empty selects no profile.
+func Validate(name string) error {
+	_, err := Get(name)
+	return err
+}
+
+// Get returns the profile of the name, the empty profile if the name is
+// empty.
+func Get(name string) (Profile, error) {
+	if name == "" {
+		return Profile{}, nil
+	}
+	p, ok := profiles[name]
+	if !ok {
+		return p, fmt.Errorf("Unknown CNI profile %q, expected one of %s", name, strings.Join(Names(), ", "))
+	}
+	return p, nil
+}
+
+// Files returns the files the profile writes to the node.
+func (p Profile) Files() []File {
+	if p.Name == "" {
+		return nil
+	}
+	var modules, sysctls bytes.Buffer
+	for _, m := range p.Modules {
+		fmt.Fprintln(&modules, m)
+	}
+	for _, s := range p.Sysctls {
+		fmt.Fprintf(&sysctls, "%s = %s\n", s.Key, s.Value)
+	}
+	return append([]File{
+		{Path: ModulesPath, Content: modules.String()},
+		{Path: SysctlPath, Content: sysctls.String()},
+	}, p.ExtraFiles...)
+}
+
+// ApplyCommand loads the modules and sets the sysctls of the profile once
+// its files are written.
+func (p Profile) ApplyCommand() string {
+	if p.Name == "" {
+		return "true"
+	}
+	return fmt.Sprintf("modprobe -a %s && sysctl -q -p %s", strings.Join(p.Modules, " "), SysctlPath)
+}
+
+// Script returns the shell script run as root which writes the files of the
+// profile and applies them.
+func (p Profile) Script() (string, error) {
+	var cmds []string
+	for _, f := range p.Files() {
+		write, err := remote.WriteFileCommand(f.Path, []byte(f.Content), 0644)
+		if err != nil {
+			return "", err
+		}
+		cmds = append(cmds, "mkdir -p "+remote.Quote(path.Dir(f.Path)), write)
+	}
+	return strings.Join(append(cmds, p.ApplyCommand()), " && "), nil
+}
@@ -0,0 +1,64 @@
+package cni
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	for _, name := range []string{"calico", "cilium", "flannel"} {
+		p, err := Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Name != name || len(p.Modules) == 0 || len(p.Sysctls) == 0 {
+			t.Errorf("%s: incomplete profile %+v", name, p)
+		}
+	}
+	if p, err := Get(""); err != nil || p.Name != "" {
+		t.Errorf("expected the empty profile, got %+v, %v", p, err)
+	}
+	if err := Validate("weave"); err == nil {
+		t.Error("expected an error for an unknown profile")
+	}
+}
+
+func TestFiles(t *testing.T) {
+	p, _ := Get("flannel")
+	files := p.Files()
+	if len(files) != 2 {
+		t.Fatalf("expected the modules and sysctls, got %+v", files)
+	}
+	if files[0].Path != ModulesPath || files[0].Content != "br_netfilter\nvxlan\n" {
+		t.Errorf("unexpected modules %+v", files[0])
+	}
+	if !strings.Contains(files[1].Content, "net.bridge.bridge-nf-call-iptables = 1\n") {
+		t.Errorf("unexpected sysctls %+v", files[1])
+	}
+
+	cilium, _ := Get("cilium")
+	if files := cilium.Files(); len(files) != 3 || !strings.Contains(files[2].Content, "ManageForeignRoutes=no") {
+		t.Errorf("expected the networkd exclusions, got %+v", files)
+	}
+
+	if files := (Profile{}).Files(); len(files) != 0 {
+		t.Errorf("expected no files without profile, got %+v", files)
+	}
+}
+
+func TestScript(t *testing.T) {
+	p, _ := Get("calico")
+	script, err := p.Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"mkdir -p '/etc/sysctl.d'", "modprobe -a ip_tables ip_set ipip xt_set xt_mark", "sysctl -q -p " + SysctlPath} {
+		if !strings.Contains(script, s) {
+			t.Errorf("expected %q in %s", s, script)
+		}
+	}
+
+	if script, err := (Profile{}).Script(); err != nil || script != "true" {
+		t.Errorf("expected no changes without profile, got %q, %v", script, err)
+	}
+}
@@ -0,0 +1,156 @@
+// Package configbackup snapshots the files kube-machine is about to change
+// on a node before a mutating operation, so the previous configuration can
+// be restored. Only the files of the operation are copied into a backup
+// named after the time and the operation, files which didn't exist are
+// recorded so the restore removes them again.
+package configbackup
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/kubermatic/kube-machine/pkg/remote"
+)
+
+const (
+	// Root holds the backups on the node.
+	Root = "/var/lib/kube-machine/config-backups"
+	// Keep is the number of backups kept on the node, older ones are
+	// removed by the next backup.
+	Keep = 10
+
+	timeFormat = "20060102T150405Z"
+)
+
+var namePattern = regexp.MustCompile(`^([0-9]{8}T[0-9]{6}Z)-([a-z0-9-]+)$`)
+
+// Backup is a backup on the node.
+type Backup struct {
+	Name      string
+	Time      time.Time
+	Operation string
+}
+
+// Name returns the name of the backup taken before the operation.
+func Name(operation string, t time.Time) string {
+	return t.UTC().Format(timeFormat) + "-" + operation
+}
+
+// Parse returns the backup of the name.
+func Parse(name string) (Backup, error) {
+	m := namePattern.FindStringSubmatch(name)
+	if m == nil {
+		return Backup{}, fmt.Errorf("Invalid backup name %q, expected e.g. %s", name, Name("provision", time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)))
+	}
+	t, err := time.Parse(timeFormat, m[1])
+	if err != nil {
+		return Backup{}, fmt.Errorf("Invalid backup name %q: %v", name, err)
+	}
+	return Backup{Name: name, Time: t, Operation: m[2]}, nil
+}
+
+// ListCommand lists the backups on the node.
+const ListCommand = "ls -1 " + Root + " 2>/dev/null || true"
+
+// ParseList returns the backups of the output of ListCommand, the newest
+// first.
+func ParseList(out string) []Backup {
+	var backups []Backup
+	for _, line := range strings.Split(out, "\n") {
+		if b, err := Parse(strings.TrimSpace(line)); err == nil {
+			backups = append([]Backup{b}, backups...)
+		}
+	}
+	return backups
+}
+
+func validate(paths []string) error {
+	for _, p := range paths {
+		if err := remote.ValidatePath(p); err != nil {
+			return err
+		}
+		if p == "/" || strings.ContainsAny(p, "\n") {
+			return fmt.Errorf("Path %q can't be backed up", p)
+		}
+	}
+	return nil
+}
+
+// SnapshotScript returns the script taking the backup of the files before
+// the operation and removing the backups beyond Keep. It runs as root.
+func SnapshotScript(name string, paths []string) (string, error) {
+	if _, err := Parse(name); err != nil {
+		return "", err
+	}
+	script, err := BackupScript(Root+"/"+name, paths)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s && cd %s && ls -1 | sort -r | tail -n +%d | xargs rm -rf", script, Root, Keep+1), nil
+}
+
+// BackupScript returns the script backing up the files into the directory,
+// replacing what it held. It runs as root.
+func BackupScript(dir string, paths []string) (string, error) {
+	if err := validate(append([]string{dir}, paths...)); err != nil {
+		return "", err
+	}
+	files := remote.Quote(dir + "/files")
+	cmds := []string{
+		"rm -rf " + remote.Quote(dir),
+		"mkdir -p " + remote.Quote(dir+"/root"),
+		": > " + files,
+	}
+	for _, p := range paths {
+		backup := dir + "/root" + p
+		cmds = append(cmds, fmt.Sprintf("echo %[1]s >> %[4]s && if [ -e %[1]s ]; then mkdir -p %[2]s && cp -a %[1]s %[3]s; fi",
+			remote.Quote(p), remote.Quote(backup[:strings.LastIndex(backup, "/")]), remote.Quote(backup), files))
+	}
+	return strings.Join(cmds, " && "), nil
+}
+
+// RestoreScript returns the script restoring the files of the backup in the
+// directory, files which didn't exist are removed. It fails if there is no
+// backup and runs as root.
+func RestoreScript(dir string) (string, error) {
+	if err := validate([]string{dir}); err != nil {
+		return "", err
+	}
+	d := remote.Quote(dir)
+	return fmt.Sprintf(`test -f %[1]s/files && while IFS= read -r f; do rm -rf "$f" && if [ -e %[1]s/root"$f" ]; then mkdir -p "$(dirname "$f")" && cp -a %[1]s/root"$f" "$f"; fi || exit 1; done < %[1]s/files`, d), nil
+}
+
+// RestoreNamedScript returns the script restoring the named backup.
+func RestoreNamedScript(name string) (string, error) {
+	if _, err := Parse(name); err != nil {
+		return "", err
+	}
+	return RestoreScript(Root + "/" + name)
+}
+
+// PruneScript returns the script removing the files from the backups below
+// Root and the backups in the directories, e.g. credentials which were
+// replaced. Restoring a pruned backup leaves the current files alone. It
+// runs as root.
+func PruneScript(paths []string, dirs ...string) (string, error) {
+	return pruneScript(Root, paths, dirs)
+}
+
+func pruneScript(root string, paths, dirs []string) (string, error) {
+	if err := validate(append(append([]string{root}, dirs...), paths...)); err != nil {
+		return "", err
+	}
+	backups := []string{remote.Quote(root) + "/*"}
+	for _, d := range dirs {
+		backups = append(backups, remote.Quote(d))
+	}
+	var removes, patterns []string
+	for _, p := range paths {
+		removes = append(removes, `"$d/root"`+remote.Quote(p))
+		patterns = append(patterns, "-e "+remote.Quote(p))
+	}
+	return fmt.Sprintf(`for d in %s; do if [ -f "$d/files" ]; then rm -rf %s && { grep -vxF %s "$d/files" || true; } > "$d/files.new" && mv -f "$d/files.new" "$d/files" || exit 1; fi; done`,
+		strings.Join(backups, " "), strings.Join(removes, " "), strings.Join(patterns, " ")), nil
+}
@@ -0,0 +1,156 @@
+package configbackup
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func run(t *testing.T, script string) {
+	if out, err := exec.Command("sh", "-c", script).CombinedOutput(); err != nil {
+		t.Fatalf("%s failed (error: %v): %s", script, err, out)
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configbackup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	backup := filepath.Join(dir, "backup")
+	binary := filepath.Join(dir, "kubelet")
+	unit := filepath.Join(dir, "system", "kubelet.service")
+	certs := filepath.Join(dir, "pki")
+	script := filepath.Join(dir, "init.d", "kubelet")
+	paths := []string{binary, unit, certs, script}
+	if err := ioutil.WriteFile(binary, []byte("v1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Dir(unit), 0755)
+	if err := ioutil.WriteFile(unit, []byte("unit v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(certs, 0755)
+	if err := ioutil.WriteFile(filepath.Join(certs, "kubelet.crt"), []byte("crt v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := BackupScript(backup, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(t, s)
+
+	// The operation replaces the files and adds a script and a certificate.
+	ioutil.WriteFile(binary, []byte("v2"), 0755)
+	ioutil.WriteFile(unit, []byte("unit v2"), 0644)
+	ioutil.WriteFile(filepath.Join(certs, "kubelet.key"), []byte("key v2"), 0600)
+	os.MkdirAll(filepath.Dir(script), 0755)
+	ioutil.WriteFile(script, []byte("script"), 0755)
+
+	s, err = RestoreScript(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(t, s)
+	for p, expected := range map[string]string{binary: "v1", unit: "unit v1", filepath.Join(certs, "kubelet.crt"): "crt v1"} {
+		if data, err := ioutil.ReadFile(p); err != nil || string(data) != expected {
+			t.Errorf("Expected %q in %s, got %q, %v", expected, p, data, err)
+		}
+	}
+	if info, err := os.Stat(binary); err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("Expected the mode of the binary to be restored, got %v, %v", info, err)
+	}
+	for _, p := range []string{script, filepath.Join(certs, "kubelet.key")} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("Expected the added %s to be removed, got %v", p, err)
+		}
+	}
+
+	os.RemoveAll(backup)
+	if err := exec.Command("sh", "-c", s).Run(); err == nil {
+		t.Error("Expected the restore to fail without backup")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configbackup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "backups")
+	backup := filepath.Join(root, Name("provision", time.Now()))
+	upgrade := filepath.Join(dir, "upgrade-backup")
+	kubeconfig := filepath.Join(dir, "kubeconfig")
+	unit := filepath.Join(dir, "kubelet.service")
+	ioutil.WriteFile(kubeconfig, []byte("token v1"), 0600)
+	ioutil.WriteFile(unit, []byte("unit v1"), 0644)
+	for _, d := range []string{backup, upgrade} {
+		s, err := BackupScript(d, []string{kubeconfig, unit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		run(t, s)
+	}
+
+	s, err := pruneScript(root, []string{kubeconfig}, []string{upgrade, filepath.Join(dir, "missing")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(t, s)
+
+	// The kubeconfig was rotated, restoring the backups keeps it.
+	ioutil.WriteFile(kubeconfig, []byte("token v2"), 0600)
+	ioutil.WriteFile(unit, []byte("unit v2"), 0644)
+	for _, d := range []string{backup, upgrade} {
+		if _, err := os.Stat(filepath.Join(d, "root", kubeconfig)); !os.IsNotExist(err) {
+			t.Errorf("Expected the kubeconfig to be pruned from %s, got %v", d, err)
+		}
+		s, err := RestoreScript(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		run(t, s)
+		for p, expected := range map[string]string{kubeconfig: "token v2", unit: "unit v1"} {
+			if data, err := ioutil.ReadFile(p); err != nil || string(data) != expected {
+				t.Errorf("Expected %q in %s after restoring %s, got %q, %v", expected, p, d, data, err)
+			}
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, paths := range [][]string{{"relative/kubelet"}, {"/etc/../kubelet"}, {"/"}, {"/etc/kube\nlet"}} {
+		if _, err := BackupScript("/tmp/backup", paths); err == nil {
+			t.Errorf("Expected an error for %q", paths)
+		}
+	}
+	if _, err := RestoreNamedScript("../etc"); err == nil {
+		t.Error("Expected an error for an invalid backup name")
+	}
+	if _, err := SnapshotScript("latest", []string{"/etc/kubeconfig"}); err == nil {
+		t.Error("Expected an error for an invalid backup name")
+	}
+}
+
+func TestParseList(t *testing.T) {
+	name := Name("rotate-api-server", time.Date(2026, 10, 16, 8, 0, 0, 0, time.FixedZone("CEST", 2*3600)))
+	if name != "20261016T060000Z-rotate-api-server" {
+		t.Errorf("Unexpected name %q", name)
+	}
+	backups := ParseList(strings.Join([]string{"20261015T100000Z-provision", name, "lost+found", ""}, "\n"))
+	expected := []Backup{
+		{Name: name, Time: time.Date(2026, 10, 16, 6, 0, 0, 0, time.UTC), Operation: "rotate-api-server"},
+		{Name: "20261015T100000Z-provision", Time: time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC), Operation: "provision"},
+	}
+	if !reflect.DeepEqual(backups, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, backups)
+	}
+}
@@ -0,0 +1,308 @@
+package cost
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ghodss/yaml"
+)
+
+// Prices maps drivers to the estimated monthly price in USD of their
+// machine sizes. The sizes are keyed by "[<region>/]<size>[:spot]", prices
+// with region take precedence over the ones without, which apply to all
+// regions. Spot prices, of preemptible machines on google, are marked with
+// the spot suffix, spot machines without spot price are priced with the
+// on-demand price.
+type Prices map[string]map[string]float64
+
+// AnySize is the size of the price of any machine of a driver whose size has
+// no price of its own. Drivers which don't select a size, like the local
+// hypervisors, are priced with it.
+const AnySize = "*"
+
+// spotSuffix marks the spot prices of sizes.
+const spotSuffix = ":spot"
+
+// size describes how a driver selects the size, region and spot pricing of
+// a machine. The options are create flags, the fields are the fields of the
+// stored driver configuration.
+type size struct {
+	option      string
+	field       string
+	defaultSize string
+
+	regionOption  string
+	regionField   string
+	defaultRegion string
+	// zoned drivers configure a zone, it is priced by its region.
+	zoned bool
+
+	spotOption string
+	spotField  string
+}
+
+var sizes = map[string]size{
+	"amazonec2": {
+		option: "amazonec2-instance-type", field: "InstanceType", defaultSize: "t2.micro",
+		regionOption: "amazonec2-region", regionField: "Region", defaultRegion: "us-east-1",
+		spotOption: "amazonec2-request-spot-instance", spotField: "RequestSpotInstance",
+	},
+	"azure": {
+		option: "azure-size", field: "Size", defaultSize: "Standard_A2",
+		regionOption: "azure-location", regionField: "Location", defaultRegion: "westus",
+	},
+	"digitalocean": {
+		option: "digitalocean-size", field: "Size", defaultSize: "512mb",
+		regionOption: "digitalocean-region", regionField: "Region", defaultRegion: "nyc3",
+	},
+	"exoscale": {
+		option: "exoscale-instance-profile", field: "InstanceProfile", defaultSize: "small",
+		regionOption: "exoscale-availability-zone", regionField: "AvailabilityZone", defaultRegion: "ch-dk-2",
+	},
+	"google": {
+		option: "google-machine-type", field: "MachineType", defaultSize: "n1-standard-1",
+		regionOption: "google-zone", regionField: "Zone", defaultRegion: "us-central1-a", zoned: true,
+		spotOption: "google-preemptible", spotField: "Preemptible",
+	},
+	"openstack": {
+		option: "openstack-flavor-name", field: "FlavorName",
+		regionOption: "openstack-region", regionField: "Region",
+	},
+	"rackspace": {
+		option: "rackspace-flavor-id", field: "FlavorId", defaultSize: "general1-1",
+		regionOption: "rackspace-region", regionField: "Region", defaultRegion: "IAD",
+	},
+}
+
+// region returns the region a machine in the region or zone of the driver
+// is priced by.
+func (s size) region(value string) string {
+	if s.zoned {
+		if i := strings.LastIndex(value, "-"); i > 0 {
+			return value[:i]
+		}
+	}
+	return value
+}
+
+var (
+	mu      sync.RWMutex
+	current = DefaultPrices
+	source  = defaultSource
+)
+
+// defaultSource describes the source of DefaultPrices.
+var defaultSource = "list prices of " + DefaultPricesDate
+
+// Configure adds the prices of the YAML or JSON pricing file to the
+// default prices, overriding them for the same driver and size. The pricing
+// file may price any driver, also those without default prices. Its source
+// and date keys describe where its prices are from and when they were
+// taken, the date defaults to the modification time of the file.
+func Configure(pricingFile string) error {
+	prices := Prices{}
+	for driver, p := range DefaultPrices {
+		prices[driver] = map[string]float64{}
+		for s, price := range p {
+			prices[driver][s] = price
+		}
+	}
+
+	description := defaultSource
+	if pricingFile != "" {
+		info, err := os.Stat(pricingFile)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(pricingFile)
+		if err != nil {
+			return err
+		}
+		file := struct {
+			Source string `json:"source"`
+			Date   string `json:"date"`
+		}{Date: info.ModTime().Format("2006-01-02")}
+		entries := map[string]json.RawMessage{}
+		if err := yaml.Unmarshal(data, &entries); err != nil {
+			return fmt.Errorf("Failed to parse pricing file %s: %v", pricingFile, err)
+		}
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return fmt.Errorf("Failed to parse pricing file %s: %v", pricingFile, err)
+		}
+		delete(entries, "source")
+		delete(entries, "date")
+		for driver, entry := range entries {
+			p := map[string]float64{}
+			if err := json.Unmarshal(entry, &p); err != nil {
+				return fmt.Errorf("Failed to parse the prices of %s in pricing file %s: %v", driver, pricingFile, err)
+			}
+			if prices[driver] == nil {
+				prices[driver] = map[string]float64{}
+			}
+			for s, price := range p {
+				prices[driver][s] = price
+			}
+		}
+
+		description = fmt.Sprintf("pricing file %s of %s", pricingFile, file.Date)
+		if file.Source != "" {
+			description = fmt.Sprintf("%s (pricing file %s of %s)", file.Source, pricingFile, file.Date)
+		}
+		description += " over " + defaultSource
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	current = prices
+	source = description
+	return nil
+}
+
+// Source describes where the prices of the estimates are from and their
+// date.
+func Source() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return source
+}
+
+// Estimate is the estimated monthly cost of a machine.
+type Estimate struct {
+	Driver string
+	Size   string
+	Region string
+	Spot   bool
+	// Known is false if there is neither a price for the size nor for any
+	// size of the driver, the cost is reported as unknown then.
+	Known   bool
+	Monthly float64
+}
+
+func (e Estimate) String() string {
+	if !e.Known {
+		return "unknown"
+	}
+	return fmt.Sprintf("$%.2f", e.Monthly)
+}
+
+// ForOptions estimates the cost of a machine created with the given create
+// flags.
+func ForOptions(driver string, options map[string]interface{}) Estimate {
+	s := sizes[driver]
+	machineSize, region := s.defaultSize, s.defaultRegion
+	if v, ok := options[s.option]; ok && s.option != "" {
+		machineSize = fmt.Sprint(v)
+	}
+	if v, ok := options[s.regionOption]; ok && s.regionOption != "" {
+		region = fmt.Sprint(v)
+	}
+	spot := false
+	if v, ok := options[s.spotOption]; ok && s.spotOption != "" {
+		spot = fmt.Sprint(v) == "true"
+	}
+	return estimate(driver, machineSize, s.region(region), spot)
+}
+
+// ForDriverConfig estimates the cost of an existing machine from the JSON
+// configuration of its driver.
+func ForDriverConfig(driver string, rawDriver []byte) Estimate {
+	config := map[string]interface{}{}
+	if err := json.Unmarshal(rawDriver, &config); err != nil {
+		return Estimate{Driver: driver}
+	}
+	s := sizes[driver]
+	machineSize, _ := config[s.field].(string)
+	region, _ := config[s.regionField].(string)
+	if region == "" {
+		region = s.defaultRegion
+	}
+	spot, _ := config[s.spotField].(bool)
+	return estimate(driver, machineSize, s.region(region), spot)
+}
+
+func estimate(driver, machineSize, region string, spot bool) Estimate {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	e := Estimate{Driver: driver, Size: machineSize, Region: region, Spot: spot}
+	for _, key := range priceKeys(machineSize, region, spot) {
+		if price, ok := current[driver][key]; ok {
+			e.Known, e.Monthly = true, price
+			break
+		}
+	}
+	return e
+}
+
+// priceKeys returns the keys of the prices of a machine, in the order they
+// take precedence.
+func priceKeys(machineSize, region string, spot bool) []string {
+	var keys []string
+	for _, s := range []string{machineSize, AnySize} {
+		sized := []string{s}
+		if region != "" {
+			sized = []string{region + "/" + s, s}
+		}
+		if spot {
+			for _, k := range sized {
+				keys = append(keys, k+spotSuffix)
+			}
+		}
+		keys = append(keys, sized...)
+	}
+	return keys
+}
+
+// Pool returns the pool a machine is attributed to, which is the value of
+// its engine label pool. It is empty for machines without pool label.
+func Pool(labels []string) string {
+	for _, l := range labels {
+		if strings.HasPrefix(l, "pool=") {
+			return strings.TrimPrefix(l, "pool=")
+		}
+	}
+	return ""
+}
+
+// Total sums the estimates by pool. Unknown reports the number of machines
+// without price, which are missing in the totals.
+type Total struct {
+	Pool     string
+	Machines int
+	Unknown  int
+	Monthly  float64
+}
+
+// Totals returns the totals of the pools of the estimates, sorted by pool.
+func Totals(pools []string, estimates []Estimate) []Total {
+	byPool := map[string]*Total{}
+	for i, e := range estimates {
+		t, ok := byPool[pools[i]]
+		if !ok {
+			t = &Total{Pool: pools[i]}
+			byPool[pools[i]] = t
+		}
+		t.Machines++
+		if !e.Known {
+			t.Unknown++
+		}
+		t.Monthly += e.Monthly
+	}
+
+	names := []string{}
+	for pool := range byPool {
+		names = append(names, pool)
+	}
+	sort.Strings(names)
+
+	totals := []Total{}
+	for _, pool := range names {
+		totals = append(totals, *byPool[pool])
+	}
+	return totals
+}
@@ -0,0 +1,131 @@
+package cost
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEstimate(t *testing.T) {
+	if err := Configure(""); err != nil {
+		t.Fatal(err)
+	}
+
+	e := ForOptions("digitalocean", map[string]interface{}{"digitalocean-size": "2gb"})
+	if !e.Known || e.Monthly != 20 || e.String() != "$20.00" {
+		t.Errorf("Unexpected estimate %+v", e)
+	}
+	if e := ForOptions("digitalocean", nil); e.Size != "512mb" || e.Monthly != 5 {
+		t.Errorf("Expected the default size, got %+v", e)
+	}
+	if e := ForOptions("virtualbox", nil); e.Known || e.String() != "unknown" {
+		t.Errorf("Expected an unknown estimate, got %+v", e)
+	}
+
+	e = ForDriverConfig("google", []byte(`{"MachineName":"node-1","MachineType":"n1-standard-2"}`))
+	if !e.Known || e.Size != "n1-standard-2" || e.Region != "us-central1" {
+		t.Errorf("Unexpected estimate %+v", e)
+	}
+	e = ForDriverConfig("google", []byte(`{"MachineName":"node-1","MachineType":"n1-standard-2","Zone":"europe-west1-b"}`))
+	if e.Known || e.Region != "europe-west1" {
+		t.Errorf("Expected the region without list price to be unknown, got %+v", e)
+	}
+	if e := ForOptions("amazonec2", map[string]interface{}{"amazonec2-instance-type": "m4.large", "amazonec2-region": "eu-west-1"}); e.Known {
+		t.Errorf("Expected the region without list price to be unknown, got %+v", e)
+	}
+	if e := ForOptions("digitalocean", map[string]interface{}{"digitalocean-region": "fra1"}); !e.Known || e.Monthly != 5 {
+		t.Errorf("Expected the price of all regions, got %+v", e)
+	}
+	if Source() != "list prices of "+DefaultPricesDate {
+		t.Errorf("Unexpected source %q", Source())
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer Configure("")
+
+	path := filepath.Join(dir, "pricing.yaml")
+	data := `source: negotiated rates
+date: "2026-09-01"
+digitalocean:
+  2gb: 15
+openstack:
+  m1.large: 42.5
+exoscale:
+  "*": 30
+libvirt:
+  "*": 12
+amazonec2:
+  eu-west-1/m4.large: 85
+  eu-west-1/m4.large:spot: 30
+  m4.large:spot: 25
+`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Configure(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := ForOptions("digitalocean", map[string]interface{}{"digitalocean-size": "2gb"}); e.Monthly != 15 {
+		t.Errorf("Expected the price of the pricing file, got %+v", e)
+	}
+	if e := ForOptions("openstack", map[string]interface{}{"openstack-flavor-name": "m1.large"}); e.Monthly != 42.5 {
+		t.Errorf("Expected the price of the pricing file, got %+v", e)
+	}
+	if e := ForOptions("openstack", map[string]interface{}{"openstack-flavor-name": "m1.small"}); e.Known {
+		t.Errorf("Expected the flavor without price to be unknown, got %+v", e)
+	}
+	if e := ForOptions("exoscale", map[string]interface{}{"exoscale-instance-profile": "large"}); e.Monthly != 30 || e.Size != "large" {
+		t.Errorf("Expected the price of any exoscale size, got %+v", e)
+	}
+	if e := ForDriverConfig("libvirt", []byte(`{"MachineName":"node-1"}`)); !e.Known || e.Monthly != 12 {
+		t.Errorf("Expected the price of any libvirt machine, got %+v", e)
+	}
+	if e := ForOptions("amazonec2", map[string]interface{}{"amazonec2-instance-type": "m4.large", "amazonec2-region": "eu-west-1"}); e.Monthly != 85 {
+		t.Errorf("Expected the price of the region, got %+v", e)
+	}
+	if e := ForOptions("amazonec2", map[string]interface{}{"amazonec2-instance-type": "m4.large", "amazonec2-region": "eu-west-1", "amazonec2-request-spot-instance": true}); !e.Spot || e.Monthly != 30 {
+		t.Errorf("Expected the spot price of the region, got %+v", e)
+	}
+	if e := ForDriverConfig("amazonec2", []byte(`{"InstanceType":"m4.large","Region":"us-east-1","RequestSpotInstance":true}`)); e.Monthly != 25 {
+		t.Errorf("Expected the spot price of all regions, got %+v", e)
+	}
+	if e := ForDriverConfig("amazonec2", []byte(`{"InstanceType":"t2.micro","Region":"us-east-1","RequestSpotInstance":true}`)); e.Monthly != 8.47 {
+		t.Errorf("Expected the on-demand price without spot price, got %+v", e)
+	}
+	if expected := "negotiated rates (pricing file " + path + " of 2026-09-01) over list prices of " + DefaultPricesDate; Source() != expected {
+		t.Errorf("Expected source %q, got %q", expected, Source())
+	}
+	if DefaultPrices["digitalocean"]["2gb"] != 20 {
+		t.Error("The pricing file changed the default prices")
+	}
+}
+
+func TestTotals(t *testing.T) {
+	totals := Totals(
+		[]string{"workers", "", "workers"},
+		[]Estimate{
+			{Known: true, Monthly: 20},
+			{Known: true, Monthly: 5},
+			{},
+		},
+	)
+	expected := []Total{
+		{Pool: "", Machines: 1, Monthly: 5},
+		{Pool: "workers", Machines: 2, Unknown: 1, Monthly: 20},
+	}
+	if !reflect.DeepEqual(totals, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, totals)
+	}
+	if pool := Pool([]string{"zone=a", "pool=workers"}); pool != "workers" {
+		t.Errorf("Unexpected pool %q", pool)
+	}
+}
@@ -0,0 +1,61 @@
+package cost
+
+// DefaultPricesDate is the date of the list prices of DefaultPrices.
+const DefaultPricesDate = "2017-03"
+
+// DefaultPrices are on-demand list prices of the default regions of the
+// drivers as of DefaultPricesDate, based on 730 hours a month. Digital Ocean
+// prices all regions the same. Use a pricing file for other regions, spot
+// prices, negotiated discounts or the other drivers, like exoscale, openstack
+// and the local hypervisors. The cost of machines without price is unknown.
+var DefaultPrices = Prices{
+	"amazonec2": {
+		"us-east-1/t2.nano":    4.23,
+		"us-east-1/t2.micro":   8.47,
+		"us-east-1/t2.small":   16.94,
+		"us-east-1/t2.medium":  33.87,
+		"us-east-1/t2.large":   67.74,
+		"us-east-1/t2.xlarge":  135.49,
+		"us-east-1/m4.large":   78.84,
+		"us-east-1/m4.xlarge":  157.68,
+		"us-east-1/m4.2xlarge": 315.36,
+		"us-east-1/c4.large":   72.27,
+		"us-east-1/c4.xlarge":  145.27,
+		"us-east-1/c4.2xlarge": 290.54,
+		"us-east-1/r4.large":   97.09,
+		"us-east-1/r4.xlarge":  194.18,
+	},
+	"azure": {
+		"westus/Standard_A1":     43.80,
+		"westus/Standard_A2":     87.60,
+		"westus/Standard_A3":     175.20,
+		"westus/Standard_D1_v2":  53.29,
+		"westus/Standard_D2_v2":  106.58,
+		"westus/Standard_D3_v2":  213.89,
+		"westus/Standard_DS1_v2": 53.29,
+		"westus/Standard_DS2_v2": 106.58,
+	},
+	"digitalocean": {
+		"512mb": 5,
+		"1gb":   10,
+		"2gb":   20,
+		"4gb":   40,
+		"8gb":   80,
+		"16gb":  160,
+		"32gb":  320,
+		"48gb":  480,
+		"64gb":  640,
+	},
+	"google": {
+		"us-central1/f1-micro":      5.55,
+		"us-central1/g1-small":      18.98,
+		"us-central1/n1-standard-1": 34.68,
+		"us-central1/n1-standard-2": 69.35,
+		"us-central1/n1-standard-4": 138.70,
+		"us-central1/n1-standard-8": 277.40,
+		"us-central1/n1-highmem-2":  86.43,
+		"us-central1/n1-highmem-4":  172.86,
+		"us-central1/n1-highcpu-2":  51.83,
+		"us-central1/n1-highcpu-4":  103.66,
+	},
+}
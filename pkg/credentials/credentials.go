@@ -0,0 +1,127 @@
+package credentials
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// The profiles define where the kubelet kubeconfig with the node credentials
+// is kept on the node.
+const (
+	// ProfileDisk stores the kubeconfig in plain text on the root disk.
+	ProfileDisk = "disk"
+	// ProfileTmpfs keeps the kubeconfig in memory only, the controller
+	// ships it again after a reboot.
+	ProfileTmpfs = "tmpfs"
+	// ProfileEncrypted stores the kubeconfig encrypted with systemd-creds,
+	// bound to the TPM of the node if available. systemd decrypts it into a
+	// private in-memory directory of the kubelet at boot.
+	ProfileEncrypted = "encrypted"
+)
+
+const (
+	// DiskKubeconfigPath is the default path of the disk profile, the
+	// machines may keep their kubeconfig elsewhere, see nodepaths.
+	DiskKubeconfigPath  = "/etc/kubeconfig"
+	TmpfsKubeconfigPath = "/run/kube-machine/kubeconfig"
+	EncryptedPath       = "/var/lib/kube-machine/kubeconfig.cred"
+	// StagingPath is the in-memory location the kubeconfig is uploaded to
+	// before being encrypted.
+	StagingPath = "/run/kube-machine/kubeconfig.new"
+	// ProfilePath records the profile of the node for reconfigurations.
+	ProfilePath = "/etc/kube-machine/credential-profile"
+	// HostKeyPath is the key systemd-creds encrypts with, it must not be
+	// shared through images.
+	HostKeyPath = "/var/lib/systemd/credential.secret"
+
+	credentialName = "kubeconfig"
+)
+
+var Profiles = []string{ProfileDisk, ProfileTmpfs, ProfileEncrypted}
+
+// Validate checks that the profile is known, empty is the disk profile.
+func Validate(profile string) error {
+	if profile == "" {
+		return nil
+	}
+	for _, p := range Profiles {
+		if p == profile {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown credential profile %q, expected one of %s", profile, strings.Join(Profiles, ", "))
+}
+
+// UploadPath returns where the kubeconfig is uploaded to, diskPath is the
+// kubeconfig path of the machine.
+func UploadPath(profile, diskPath string) string {
+	switch profile {
+	case ProfileTmpfs:
+		return TmpfsKubeconfigPath
+	case ProfileEncrypted:
+		return StagingPath
+	}
+	return diskPath
+}
+
+// KubeletKubeconfigPath returns the kubeconfig flag of the kubelet.
+func KubeletKubeconfigPath(profile, diskPath string) string {
+	switch profile {
+	case ProfileTmpfs:
+		return TmpfsKubeconfigPath
+	case ProfileEncrypted:
+		return "${CREDENTIALS_DIRECTORY}/" + credentialName
+	}
+	return diskPath
+}
+
+// UnitDirectives returns the service directives of the kubelet unit.
+func UnitDirectives(profile string) []string {
+	if profile == ProfileEncrypted {
+		return []string{fmt.Sprintf("LoadCredentialEncrypted=%s:%s", credentialName, EncryptedPath)}
+	}
+	return nil
+}
+
+// PrepareCommand creates the directories of the uploaded kubeconfig.
+func PrepareCommand(profile, diskPath string) string {
+	return fmt.Sprintf("sudo mkdir -p %s %s", path.Dir(UploadPath(profile, diskPath)), path.Dir(ProfilePath))
+}
+
+// InstallCommand moves the uploaded kubeconfig into place, removes the
+// kubeconfigs of other profiles and records the profile.
+func InstallCommand(profile, diskPath string) string {
+	if profile == "" {
+		profile = ProfileDisk
+	}
+	var cmds []string
+	switch profile {
+	case ProfileDisk:
+		cmds = append(cmds, fmt.Sprintf("sudo rm -f %s %s", TmpfsKubeconfigPath, EncryptedPath))
+	case ProfileTmpfs:
+		cmds = append(cmds, fmt.Sprintf("sudo rm -f %s %s", diskPath, EncryptedPath))
+	case ProfileEncrypted:
+		cmds = append(cmds,
+			"sudo mkdir -p "+path.Dir(EncryptedPath),
+			fmt.Sprintf("sudo systemd-creds encrypt --name=%s --with-key=auto %s %s", credentialName, StagingPath, EncryptedPath),
+			fmt.Sprintf("sudo rm -f %s %s %s", StagingPath, diskPath, TmpfsKubeconfigPath),
+		)
+	}
+	cmds = append(cmds, fmt.Sprintf("echo %s | sudo tee %s >/dev/null", profile, ProfilePath))
+	return strings.Join(cmds, " && ")
+}
+
+// ReadProfileCommand prints the recorded profile of the node, nothing for
+// nodes provisioned before profiles existed.
+var ReadProfileCommand = fmt.Sprintf("cat %s 2>/dev/null || true", ProfilePath)
+
+// PresentCommand fails if the kubeconfig of the tmpfs profile is gone.
+var PresentCommand = fmt.Sprintf("sudo test -s %s", TmpfsKubeconfigPath)
+
+// RemoveCommand removes the credentials and the profile of the node, e.g.
+// before it is snapshotted into an image.
+func RemoveCommand(diskPath string) string {
+	return fmt.Sprintf("sudo rm -f %s %s %s %s %s %s",
+		diskPath, TmpfsKubeconfigPath, StagingPath, EncryptedPath, ProfilePath, HostKeyPath)
+}
@@ -0,0 +1,51 @@
+package credentials
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	for _, profile := range []string{"", ProfileDisk, ProfileTmpfs, ProfileEncrypted} {
+		if err := Validate(profile); err != nil {
+			t.Errorf("%q: unexpected error %v", profile, err)
+		}
+	}
+	if err := Validate("vault"); err == nil {
+		t.Error("expected an error for an unknown profile")
+	}
+}
+
+func TestInstallCommand(t *testing.T) {
+	cmd := InstallCommand(ProfileEncrypted, DiskKubeconfigPath)
+	for _, s := range []string{"systemd-creds encrypt --name=kubeconfig --with-key=auto " + StagingPath + " " + EncryptedPath, "rm -f " + StagingPath + " " + DiskKubeconfigPath, "echo encrypted"} {
+		if !strings.Contains(cmd, s) {
+			t.Errorf("expected %q in %q", s, cmd)
+		}
+	}
+
+	if cmd := InstallCommand("", DiskKubeconfigPath); !strings.Contains(cmd, "echo disk") || strings.Contains(cmd, "rm -f "+DiskKubeconfigPath) {
+		t.Errorf("unexpected disk install command %q", cmd)
+	}
+}
+
+func TestKubeletKubeconfigPath(t *testing.T) {
+	if p := KubeletKubeconfigPath("", DiskKubeconfigPath); p != DiskKubeconfigPath {
+		t.Errorf("expected %s, got %s", DiskKubeconfigPath, p)
+	}
+	if p := KubeletKubeconfigPath(ProfileEncrypted, DiskKubeconfigPath); p != "${CREDENTIALS_DIRECTORY}/kubeconfig" {
+		t.Errorf("unexpected encrypted kubeconfig path %s", p)
+	}
+	if d := UnitDirectives(ProfileEncrypted); len(d) != 1 || d[0] != "LoadCredentialEncrypted=kubeconfig:"+EncryptedPath {
+		t.Errorf("unexpected directives %v", d)
+	}
+}
+
+func TestUploadPath(t *testing.T) {
+	if p := UploadPath(ProfileDisk, "/etc/kubernetes/kubelet.conf"); p != "/etc/kubernetes/kubelet.conf" {
+		t.Errorf("expected the kubeconfig path of the machine, got %s", p)
+	}
+	if cmd := PrepareCommand("", "/etc/kubernetes/kubelet.conf"); !strings.HasPrefix(cmd, "sudo mkdir -p /etc/kubernetes ") {
+		t.Errorf("unexpected prepare command %q", cmd)
+	}
+}
@@ -0,0 +1,141 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a cron expression with the fields minute, hour, day of month,
+// month and day of week. Fields are *, numbers, ranges like 1-5 and lists
+// like 1,3,5, each optionally with a step like */15. Like in cron a time
+// matches if the day of month or the day of week matches when both are
+// restricted.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	domAll, dowAll                bool
+}
+
+type bounds struct {
+	name     string
+	min, max int
+}
+
+var fields = []bounds{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// Parse parses a cron expression with five fields.
+func Parse(expr string) (*Schedule, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("Invalid schedule %q, expected the 5 fields minute, hour, day of month, month and day of week", expr)
+	}
+
+	bits := make([]uint64, len(fields))
+	for i, part := range parts {
+		var err error
+		if bits[i], err = parseField(part, fields[i]); err != nil {
+			return nil, fmt.Errorf("Invalid schedule %q: %v", expr, err)
+		}
+	}
+
+	s := &Schedule{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		domAll: parts[2] == "*",
+		dowAll: parts[4] == "*",
+	}
+	// Sunday is 0 and 7.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+func parseField(field string, b bounds) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, step := item, 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			rng = item[:i]
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %s %q", b.name, item)
+			}
+		}
+
+		from, to := b.min, b.max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid %s %q", b.name, item)
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid %s %q", b.name, item)
+				}
+			} else if step > 1 {
+				to = b.max
+			}
+		}
+		if from < b.min || to > b.max || from > to {
+			return 0, fmt.Errorf("%s %q is out of range %d-%d", b.name, item, b.min, b.max)
+		}
+
+		for v := from; v <= to; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// Matches returns whether the schedule fires in the minute of t.
+func (s *Schedule) Matches(t time.Time) bool {
+	return s.dayMatches(t) && has(s.hour, t.Hour()) && has(s.minute, t.Minute())
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	if !has(s.month, int(t.Month())) {
+		return false
+	}
+	dom, dow := has(s.dom, t.Day()), has(s.dow, int(t.Weekday()))
+	if s.domAll || s.dowAll {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Prev returns the last time the schedule fired at or before t, looking back
+// no further than limit. ok is false if it didn't fire in that period.
+func (s *Schedule) Prev(t time.Time, limit time.Duration) (prev time.Time, ok bool) {
+	end := t.Add(-limit)
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location())
+	for !t.Before(end) {
+		switch {
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()).Add(-time.Minute)
+		case !has(s.hour, t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location()).Add(-time.Minute)
+		case !has(s.minute, t.Minute()):
+			t = t.Add(-time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func has(bits uint64, v int) bool {
+	return bits&(1<<uint(v)) != 0
+}
@@ -0,0 +1,79 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Expected an error for %q", expr)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		expr    string
+		time    string
+		matches bool
+	}{
+		{"* * * * *", "2017-03-06T10:42:00Z", true},
+		{"0 8 * * 1-5", "2017-03-06T08:00:00Z", true},
+		{"0 8 * * 1-5", "2017-03-05T08:00:00Z", false},
+		{"0 8 * * 1-5", "2017-03-06T08:01:00Z", false},
+		{"*/15 * * * *", "2017-03-06T10:45:00Z", true},
+		{"*/15 * * * *", "2017-03-06T10:44:00Z", false},
+		{"0 0 1 * 7", "2017-03-05T00:00:00Z", true},
+		{"0 0 1 * 7", "2017-03-01T00:00:00Z", true},
+		{"0 0 1 * 7", "2017-03-02T00:00:00Z", false},
+		{"30 20 * 1,3 *", "2017-03-02T20:30:00Z", true},
+		{"30 20 * 1,3 *", "2017-02-02T20:30:00Z", false},
+	}
+
+	for _, test := range tests {
+		s, err := Parse(test.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		at, _ := time.Parse(time.RFC3339, test.time)
+		if s.Matches(at) != test.matches {
+			t.Errorf("Expected %q matching %s to be %v", test.expr, test.time, test.matches)
+		}
+	}
+}
+
+func TestPrev(t *testing.T) {
+	s, err := Parse("0 20 * * 1-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sunday noon, the last evening was Friday.
+	at, _ := time.Parse(time.RFC3339, "2017-03-05T12:00:00Z")
+	prev, ok := s.Prev(at, 7*24*time.Hour)
+	if !ok || prev.Format(time.RFC3339) != "2017-03-03T20:00:00Z" {
+		t.Errorf("Unexpected previous time %s", prev)
+	}
+
+	prev, ok = s.Prev(prev, 0)
+	if !ok || prev.Format(time.RFC3339) != "2017-03-03T20:00:00Z" {
+		t.Errorf("Expected the time itself to match, got %s", prev)
+	}
+
+	if _, ok := s.Prev(at, 24*time.Hour); ok {
+		t.Error("Expected no match within a day")
+	}
+}
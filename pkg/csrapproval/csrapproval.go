@@ -0,0 +1,175 @@
+// Package csrapproval decides which certificate requests of kubelets are
+// approved automatically. Kubelets registering with a bootstrap token request
+// their client certificate, and with server TLS bootstrapping their serving
+// certificate, through the certificates API. Only requests of nodes which
+// are machines of the store are approved, serving certificates only for the
+// addresses of the machine, everything else is left to the admins.
+package csrapproval
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	NodeUserPrefix     = "system:node:"
+	NodesGroup         = "system:nodes"
+	BootstrappersGroup = "system:bootstrappers"
+
+	UsageDigitalSignature = "digital signature"
+	UsageKeyEncipherment  = "key encipherment"
+	UsageClientAuth       = "client auth"
+	UsageServerAuth       = "server auth"
+
+	// Reason is the reason of the approval condition.
+	Reason = "KubeMachineAutoApprove"
+)
+
+// Request is a pending certificate signing request.
+type Request struct {
+	Name string
+	// Username and Groups are the requestor.
+	Username string
+	Groups   []string
+	Usages   []string
+	// CSR is the PEM encoded certificate request.
+	CSR []byte
+}
+
+// Machine is a machine of the store and the addresses its serving
+// certificate may be issued for.
+type Machine struct {
+	Name      string
+	Addresses []string
+}
+
+// Lookup returns the machine of a node, false if there is none.
+type Lookup func(node string) (Machine, bool)
+
+// Decision tells whether a request is approved and why.
+type Decision struct {
+	Approve bool
+	// Node is the node the certificate is requested for.
+	Node   string
+	Reason string
+}
+
+// Decide checks the request. Requests which are not approved are not
+// denied, the admins may still approve them.
+func Decide(r Request, lookup Lookup) Decision {
+	csr, err := parse(r.CSR)
+	if err != nil {
+		return Decision{Reason: err.Error()}
+	}
+	if !strings.HasPrefix(csr.Subject.CommonName, NodeUserPrefix) {
+		return Decision{Reason: fmt.Sprintf("%q is not the identity of a node", csr.Subject.CommonName)}
+	}
+	node := strings.TrimPrefix(csr.Subject.CommonName, NodeUserPrefix)
+	d := Decision{Node: node}
+	if len(csr.Subject.Organization) != 1 || csr.Subject.Organization[0] != NodesGroup {
+		d.Reason = fmt.Sprintf("The organization has to be %s", NodesGroup)
+		return d
+	}
+	if len(csr.EmailAddresses) > 0 {
+		d.Reason = "Email addresses are not allowed"
+		return d
+	}
+	machine, ok := lookup(node)
+	if !ok {
+		d.Reason = fmt.Sprintf("%s is not a machine of kube-machine", node)
+		return d
+	}
+
+	switch {
+	case usages(r.Usages, UsageClientAuth):
+		d.Reason = checkClient(r, csr, node)
+	case usages(r.Usages, UsageServerAuth):
+		d.Reason = checkServing(r, csr, machine)
+	default:
+		d.Reason = fmt.Sprintf("The usages %s are neither the ones of a kubelet client nor of a serving certificate", strings.Join(r.Usages, ", "))
+	}
+	d.Approve = d.Reason == ""
+	if d.Approve {
+		d.Reason = fmt.Sprintf("%s is a machine of kube-machine", node)
+	}
+	return d
+}
+
+// checkClient allows the bootstrap token of a new node and the renewal by
+// the node itself.
+func checkClient(r Request, csr *x509.CertificateRequest, node string) string {
+	if len(csr.DNSNames) > 0 || len(csr.IPAddresses) > 0 {
+		return "A client certificate has no subject alternative names"
+	}
+	if r.Username == NodeUserPrefix+node || contains(r.Groups, BootstrappersGroup) {
+		return ""
+	}
+	return fmt.Sprintf("%s is neither the node nor a bootstrap token", r.Username)
+}
+
+// checkServing only allows the node itself to request a certificate for the
+// name and addresses of its machine.
+func checkServing(r Request, csr *x509.CertificateRequest, machine Machine) string {
+	if r.Username != NodeUserPrefix+machine.Name || !contains(r.Groups, NodesGroup) {
+		return fmt.Sprintf("Only the node %s may request its serving certificate, not %s", machine.Name, r.Username)
+	}
+	if len(csr.DNSNames) == 0 && len(csr.IPAddresses) == 0 {
+		return "A serving certificate needs subject alternative names"
+	}
+	for _, name := range csr.DNSNames {
+		if name != machine.Name && !contains(machine.Addresses, name) {
+			return fmt.Sprintf("%s is not a name of the machine", name)
+		}
+	}
+	for _, ip := range csr.IPAddresses {
+		if !hasIP(machine.Addresses, ip) {
+			return fmt.Sprintf("%s is not an address of the machine", ip)
+		}
+	}
+	return ""
+}
+
+// usages checks that the usages are the ones of a kubelet certificate with
+// the extended usage.
+func usages(requested []string, extended string) bool {
+	allowed := []string{UsageDigitalSignature, UsageKeyEncipherment, extended}
+	for _, u := range requested {
+		if !contains(allowed, u) {
+			return false
+		}
+	}
+	return contains(requested, extended)
+}
+
+func parse(data []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, fmt.Errorf("Expected a PEM encoded certificate request")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return csr, csr.CheckSignature()
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func hasIP(addresses []string, ip net.IP) bool {
+	for _, a := range addresses {
+		if parsed := net.ParseIP(a); parsed != nil && parsed.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package csrapproval
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net"
+	"testing"
+)
+
+func request(t *testing.T, cn string, org []string, dns []string, ips ...string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.CertificateRequest{Subject: pkix.Name{CommonName: cn, Organization: org}, DNSNames: dns}
+	for _, ip := range ips {
+		template.IPAddresses = append(template.IPAddresses, net.ParseIP(ip))
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func lookup(node string) (Machine, bool) {
+	if node != "worker-1" {
+		return Machine{}, false
+	}
+	return Machine{Name: node, Addresses: []string{"10.0.0.5", "worker-1.example.com"}}, true
+}
+
+func TestDecide(t *testing.T) {
+	nodes := []string{NodesGroup}
+	client := []string{UsageDigitalSignature, UsageKeyEncipherment, UsageClientAuth}
+	serving := []string{UsageDigitalSignature, UsageKeyEncipherment, UsageServerAuth}
+	bootstrapper := []string{BootstrappersGroup, "system:authenticated"}
+	node := []string{NodesGroup, "system:authenticated"}
+
+	for _, test := range []struct {
+		name    string
+		request Request
+		approve bool
+	}{
+		{"bootstrap client", Request{Username: "system:bootstrap:abcdef", Groups: bootstrapper, Usages: client, CSR: request(t, "system:node:worker-1", nodes, nil)}, true},
+		{"client renewal", Request{Username: "system:node:worker-1", Groups: node, Usages: client, CSR: request(t, "system:node:worker-1", nodes, nil)}, true},
+		{"unknown machine", Request{Username: "system:bootstrap:abcdef", Groups: bootstrapper, Usages: client, CSR: request(t, "system:node:rogue", nodes, nil)}, false},
+		{"other user", Request{Username: "alice", Groups: []string{"system:authenticated"}, Usages: client, CSR: request(t, "system:node:worker-1", nodes, nil)}, false},
+		{"renewal of another node", Request{Username: "system:node:worker-2", Groups: node, Usages: client, CSR: request(t, "system:node:worker-1", nodes, nil)}, false},
+		{"wrong organization", Request{Username: "system:bootstrap:abcdef", Groups: bootstrapper, Usages: client, CSR: request(t, "system:node:worker-1", []string{"system:masters"}, nil)}, false},
+		{"not a node", Request{Username: "system:bootstrap:abcdef", Groups: bootstrapper, Usages: client, CSR: request(t, "admin", nodes, nil)}, false},
+		{"client with names", Request{Username: "system:bootstrap:abcdef", Groups: bootstrapper, Usages: client, CSR: request(t, "system:node:worker-1", nodes, []string{"worker-1"})}, false},
+		{"extra usage", Request{Username: "system:node:worker-1", Groups: node, Usages: append(client, "cert sign"), CSR: request(t, "system:node:worker-1", nodes, nil)}, false},
+		{"serving", Request{Username: "system:node:worker-1", Groups: node, Usages: serving, CSR: request(t, "system:node:worker-1", nodes, []string{"worker-1", "worker-1.example.com"}, "10.0.0.5")}, true},
+		{"serving foreign IP", Request{Username: "system:node:worker-1", Groups: node, Usages: serving, CSR: request(t, "system:node:worker-1", nodes, []string{"worker-1"}, "10.0.0.6")}, false},
+		{"serving foreign name", Request{Username: "system:node:worker-1", Groups: node, Usages: serving, CSR: request(t, "system:node:worker-1", nodes, []string{"api.example.com"})}, false},
+		{"serving by bootstrap token", Request{Username: "system:bootstrap:abcdef", Groups: bootstrapper, Usages: serving, CSR: request(t, "system:node:worker-1", nodes, nil, "10.0.0.5")}, false},
+		{"serving without names", Request{Username: "system:node:worker-1", Groups: node, Usages: serving, CSR: request(t, "system:node:worker-1", nodes, nil)}, false},
+		{"invalid request", Request{Username: "system:node:worker-1", Groups: node, Usages: client, CSR: []byte("garbage")}, false},
+	} {
+		d := Decide(test.request, lookup)
+		if d.Approve != test.approve {
+			t.Errorf("%s: expected approve %v, got %+v", test.name, test.approve, d)
+		}
+		if d.Reason == "" {
+			t.Errorf("%s: expected a reason", test.name)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package deadline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Error is returned for operations aborted because their context ended.
+type Error struct {
+	Op  string
+	Err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s aborted: %v", e.Op, e.Err)
+}
+
+// IsAborted reports whether the error is an aborted operation.
+func IsAborted(err error) bool {
+	_, ok := err.(*Error)
+	return ok
+}
+
+// WithTimeout returns a context ending with the parent or after the timeout,
+// without a timeout if it isn't positive. A nil parent is the background
+// context.
+func WithTimeout(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if parent == nil {
+		parent = context.Background()
+	}
+	if timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, timeout)
+}
+
+// Run runs f until it returns or the context ends. Operations which can't
+// be interrupted, like commands over SSH, keep running in the background
+// when aborted, their result is dropped.
+func Run(ctx context.Context, op string, f func() error) error {
+	if ctx == nil {
+		return f()
+	}
+	if err := ctx.Err(); err != nil {
+		return &Error{Op: op, Err: err}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return &Error{Op: op, Err: ctx.Err()}
+	}
+}
+
+// RunTimeout runs f like Run, limited to the timeout if it is positive.
+func RunTimeout(ctx context.Context, timeout time.Duration, op string, f func() error) error {
+	if ctx == nil && timeout <= 0 {
+		return f()
+	}
+	ctx, cancel := WithTimeout(ctx, timeout)
+	defer cancel()
+	return Run(ctx, op, f)
+}
+
+// Transport returns a round tripper sending every request with the context,
+// each limited to the timeout until its response body is closed.
+func Transport(ctx context.Context, timeout time.Duration, rt http.RoundTripper) http.RoundTripper {
+	return &transport{ctx: ctx, timeout: timeout, rt: rt}
+}
+
+type transport struct {
+	ctx     context.Context
+	timeout time.Duration
+	rt      http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := WithTimeout(t.ctx, t.timeout)
+	resp, err := t.rt.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		if ctx.Err() != nil {
+			return nil, &Error{Op: req.Method + " " + req.URL.Path, Err: ctx.Err()}
+		}
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody releases the context of a request once its body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,84 @@
+package deadline
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	failed := errors.New("failed")
+	if err := Run(context.Background(), "op", func() error { return failed }); err != failed {
+		t.Errorf("expected the error of the operation, got %v", err)
+	}
+	if err := Run(nil, "op", func() error { return nil }); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	called := false
+	err := Run(ctx, "op", func() error {
+		called = true
+		return nil
+	})
+	if !IsAborted(err) || called {
+		t.Errorf("expected a canceled context to abort before the operation, got %v", err)
+	}
+
+	block := make(chan struct{})
+	defer close(block)
+	err = RunTimeout(context.Background(), 10*time.Millisecond, "Provisioning step kubelet", func() error {
+		<-block
+		return nil
+	})
+	if !IsAborted(err) || err.Error() != "Provisioning step kubelet aborted: context deadline exceeded" {
+		t.Errorf("expected the operation to time out, got %v", err)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-block:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: Transport(context.Background(), 50*time.Millisecond, http.DefaultTransport)}
+	resp, err := client.Get(server.URL + "/fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "ok" {
+		t.Errorf("expected ok, got %q, %v", body, err)
+	}
+
+	start := time.Now()
+	if _, err := client.Get(server.URL + "/slow"); err == nil {
+		t.Error("expected the slow request to time out")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("the request was aborted after %v", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client = &http.Client{Transport: Transport(ctx, 0, http.DefaultTransport)}
+	if _, err := client.Get(server.URL + "/fast"); err == nil {
+		t.Error("expected a canceled context to abort the request")
+	}
+}
@@ -0,0 +1,96 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/kubermatic/kube-machine/pkg/initsystem"
+)
+
+// DefaultOwner owns deployed files if the owner isn't set.
+const DefaultOwner = "root:root"
+
+var ownerPattern = regexp.MustCompile(`^[a-z_][a-z0-9_-]*(:[a-z_][a-z0-9_-]*)?$`)
+
+// Uploader streams data to the standard input of a command on a machine,
+// it is implemented by the SSH clients.
+type Uploader interface {
+	Upload(command string, data io.Reader) (string, error)
+}
+
+// File is a file deployed to a machine.
+type File struct {
+	Path string
+	Mode os.FileMode
+	// Owner is user[:group], DefaultOwner if empty.
+	Owner string
+}
+
+// Validate checks that the path is absolute and the owner well-formed.
+func (f File) Validate() error {
+	if !path.IsAbs(f.Path) || path.Clean(f.Path) == "/" {
+		return fmt.Errorf("Invalid path %q, expected an absolute path of a file", f.Path)
+	}
+	if f.Owner != "" && !ownerPattern.MatchString(f.Owner) {
+		return fmt.Errorf("Invalid owner %q, expected user[:group]", f.Owner)
+	}
+	return nil
+}
+
+// Command returns the command writing its standard input to the file. The
+// data is written to a temporary file next to it which is renamed once its
+// ownership and mode are set, readers never see a partial file.
+func (f File) Command() string {
+	owner := f.Owner
+	if owner == "" {
+		owner = DefaultOwner
+	}
+	script := `mkdir -p "$(dirname "$1")" && tmp=$(mktemp "$1.XXXXXX") && ` +
+		`{ cat > "$tmp" && chown "$2" "$tmp" && chmod "$3" "$tmp" && mv -f "$tmp" "$1" || { rm -f "$tmp"; exit 1; }; }`
+	return fmt.Sprintf("sudo sh -c %s sh %s %s %04o", quote(script), quote(path.Clean(f.Path)), owner, f.Mode.Perm())
+}
+
+// DeployFile writes the data to the file on the machine atomically.
+func DeployFile(u Uploader, f File, data io.Reader) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	out, err := u.Upload(f.Command(), data)
+	if err != nil {
+		return fmt.Errorf("Failed to deploy %s (error: %v): %v", f.Path, err, out)
+	}
+	return nil
+}
+
+var unitPattern = regexp.MustCompile(`^[A-Za-z0-9:_.@-]+$`)
+
+// ValidateUnit checks the name of a unit or service.
+func ValidateUnit(unit string) error {
+	if !unitPattern.MatchString(unit) {
+		return errors.New("Invalid unit name " + quote(unit))
+	}
+	return nil
+}
+
+// RestartCommand returns the command restarting the unit with the init
+// system, the .service suffix of systemd units is dropped for the service
+// scripts of other init systems.
+func RestartCommand(service initsystem.InitSystem, unit string) (string, error) {
+	if err := ValidateUnit(unit); err != nil {
+		return "", err
+	}
+	if service.Name() != initsystem.Systemd {
+		unit = strings.TrimSuffix(unit, ".service")
+	}
+	return service.RestartCommand(unit), nil
+}
+
+// quote quotes s for a POSIX shell.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,85 @@
+package deploy
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kubermatic/kube-machine/pkg/initsystem"
+)
+
+// shellUploader runs the commands locally without sudo.
+type shellUploader struct{}
+
+func (shellUploader) Upload(command string, data io.Reader) (string, error) {
+	cmd := exec.Command("sh", "-c", strings.TrimPrefix(command, "sudo "))
+	cmd.Stdin = data
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func TestDeployFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if os.Getuid() != 0 {
+		t.Skip("chown requires root")
+	}
+
+	p := filepath.Join(dir, "etc", "it's", "auth.json")
+	f := File{Path: p, Mode: 0640}
+	if err := DeployFile(shellUploader{}, f, strings.NewReader("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "secret" {
+		t.Errorf("unexpected content %q", data)
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("expected mode 0640, got %o", info.Mode().Perm())
+	}
+	files, _ := ioutil.ReadDir(filepath.Dir(p))
+	if len(files) != 1 {
+		t.Errorf("expected no temporary files, got %d files", len(files))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, f := range []File{{Path: "relative"}, {Path: "/"}, {Path: "/etc/x", Owner: "root;rm"}} {
+		if err := f.Validate(); err == nil {
+			t.Errorf("%+v: expected an error", f)
+		}
+	}
+	if err := (File{Path: "/etc/docker/daemon.json", Owner: "root:docker"}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRestartCommand(t *testing.T) {
+	systemd, _ := initsystem.Get(initsystem.Systemd)
+	if cmd, err := RestartCommand(systemd, "docker.service"); err != nil || cmd != "sudo systemctl daemon-reload && sudo systemctl restart docker.service" {
+		t.Errorf("unexpected command %q, %v", cmd, err)
+	}
+	openrc, _ := initsystem.Get(initsystem.OpenRC)
+	if cmd, err := RestartCommand(openrc, "docker.service"); err != nil || cmd != "sudo rc-service docker restart" {
+		t.Errorf("unexpected command %q, %v", cmd, err)
+	}
+	if _, err := RestartCommand(systemd, "docker; reboot"); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,66 @@
+package disruption
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	kcorev1 "k8s.io/client-go/pkg/api/v1"
+	policy "k8s.io/client-go/pkg/apis/policy/v1beta1"
+)
+
+// Blocking returns the pods on the node which can't be evicted without
+// violating their pod disruption budget, formatted as
+// <namespace>/<pod> (<budget>).
+func Blocking(client kubernetes.Interface, nodeName string) ([]string, error) {
+	pods, err := client.CoreV1().Pods(metav1.NamespaceAll).List(metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list pods of node %s: %v", nodeName, err)
+	}
+
+	namespaces := map[string]bool{}
+	for _, pod := range pods.Items {
+		namespaces[pod.Namespace] = true
+	}
+	var budgets []policy.PodDisruptionBudget
+	for namespace := range namespaces {
+		list, err := client.PolicyV1beta1().PodDisruptionBudgets(namespace).List(metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list pod disruption budgets in namespace %s: %v", namespace, err)
+		}
+		budgets = append(budgets, list.Items...)
+	}
+
+	return blocking(pods.Items, budgets)
+}
+
+// blocking returns the pods covered by budgets which allow fewer disruptions
+// than the number of their pods on the node.
+func blocking(pods []kcorev1.Pod, budgets []policy.PodDisruptionBudget) ([]string, error) {
+	var blocked []string
+	for _, budget := range budgets {
+		selector, err := metav1.LabelSelectorAsSelector(budget.Spec.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid selector of pod disruption budget %s/%s: %v", budget.Namespace, budget.Name, err)
+		}
+
+		var covered []string
+		for _, pod := range pods {
+			if pod.Namespace != budget.Namespace || selector.Empty() || !selector.Matches(labels.Set(pod.Labels)) {
+				continue
+			}
+			if pod.Status.Phase == kcorev1.PodSucceeded || pod.Status.Phase == kcorev1.PodFailed {
+				continue
+			}
+			covered = append(covered, fmt.Sprintf("%s/%s (%s)", pod.Namespace, pod.Name, budget.Name))
+		}
+		if len(covered) > int(budget.Status.PodDisruptionsAllowed) {
+			blocked = append(blocked, covered...)
+		}
+	}
+	return blocked, nil
+}
@@ -0,0 +1,53 @@
+package disruption
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kcorev1 "k8s.io/client-go/pkg/api/v1"
+	policy "k8s.io/client-go/pkg/apis/policy/v1beta1"
+)
+
+func testPod(name string, phase kcorev1.PodPhase) kcorev1.Pod {
+	return kcorev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Labels: map[string]string{"app": "db"}},
+		Status:     kcorev1.PodStatus{Phase: phase},
+	}
+}
+
+func testBudget(allowed int32) policy.PodDisruptionBudget {
+	return policy.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"},
+		Spec: policy.PodDisruptionBudgetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+		},
+		Status: policy.PodDisruptionBudgetStatus{PodDisruptionsAllowed: allowed},
+	}
+}
+
+func TestBlocking(t *testing.T) {
+	pods := []kcorev1.Pod{
+		testPod("db-0", kcorev1.PodRunning),
+		testPod("db-1", kcorev1.PodRunning),
+		testPod("db-job", kcorev1.PodSucceeded),
+	}
+
+	tests := []struct {
+		allowed int32
+		blocked []string
+	}{
+		{0, []string{"default/db-0 (db)", "default/db-1 (db)"}},
+		{1, []string{"default/db-0 (db)", "default/db-1 (db)"}},
+		{2, nil},
+	}
+	for _, test := range tests {
+		blocked, err := blocking(pods, []policy.PodDisruptionBudget{testBudget(test.allowed)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(blocked, test.blocked) {
+			t.Errorf("Expected %v blocked with %d allowed disruptions, got %v", test.blocked, test.allowed, blocked)
+		}
+	}
+}
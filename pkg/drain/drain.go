@@ -0,0 +1,229 @@
+package drain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	kcorev1 "k8s.io/client-go/pkg/api/v1"
+	policy "k8s.io/client-go/pkg/apis/policy/v1beta1"
+)
+
+const (
+	mirrorPodAnnotation = "kubernetes.io/config.mirror"
+
+	pollInterval = 5 * time.Second
+)
+
+// SetUnschedulable cordons or uncordons the node.
+func SetUnschedulable(client kubernetes.Interface, nodeName string, unschedulable bool) error {
+	node, err := client.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("Failed to get node %s: %v", nodeName, err)
+	}
+	if node.Spec.Unschedulable == unschedulable {
+		return nil
+	}
+	node.Spec.Unschedulable = unschedulable
+	if _, err := client.CoreV1().Nodes().Update(node); err != nil {
+		return fmt.Errorf("Failed to update node %s: %v", nodeName, err)
+	}
+	return nil
+}
+
+// SetTaint adds the taint to the node or removes it, taints are identified
+// by their key and effect.
+func SetTaint(client kubernetes.Interface, nodeName string, taint kcorev1.Taint, present bool) error {
+	node, err := client.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("Failed to get node %s: %v", nodeName, err)
+	}
+	taints, changed := WithTaint(node.Spec.Taints, taint, present)
+	if !changed {
+		return nil
+	}
+	node.Spec.Taints = taints
+	if _, err := client.CoreV1().Nodes().Update(node); err != nil {
+		return fmt.Errorf("Failed to update node %s: %v", nodeName, err)
+	}
+	return nil
+}
+
+// WithTaint returns the taints with the taint added or removed and whether
+// they changed.
+func WithTaint(taints []kcorev1.Taint, taint kcorev1.Taint, present bool) ([]kcorev1.Taint, bool) {
+	var result []kcorev1.Taint
+	found := false
+	for _, t := range taints {
+		if t.Key == taint.Key && t.Effect == taint.Effect {
+			found = true
+			if !present {
+				continue
+			}
+		}
+		result = append(result, t)
+	}
+	if present && !found {
+		result = append(result, taint)
+	}
+	return result, found != present
+}
+
+// Drain evicts the pods of the node and waits until they are gone. Pods of
+// daemon sets and mirror pods stay on the node. Evictions rejected because
+// of pod disruption budgets are retried until the timeout.
+func Drain(client kubernetes.Interface, nodeName string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	return poll(deadline, func() (bool, error) {
+		pods, err := client.CoreV1().Pods(metav1.NamespaceAll).List(metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+		})
+		if err != nil {
+			return false, fmt.Errorf("Failed to list pods of node %s: %v", nodeName, err)
+		}
+
+		var remaining []string
+		for _, pod := range Evictable(pods.Items) {
+			remaining = append(remaining, pod.Namespace+"/"+pod.Name)
+			if pod.DeletionTimestamp != nil {
+				continue
+			}
+			err := client.CoreV1().Pods(pod.Namespace).Evict(&policy.Eviction{
+				ObjectMeta: metav1.ObjectMeta{Namespace: pod.Namespace, Name: pod.Name},
+			})
+			if err != nil && !kerrors.IsNotFound(err) {
+				log.Debugf("Eviction of pod %s/%s failed, retrying: %v", pod.Namespace, pod.Name, err)
+			}
+		}
+		if len(remaining) > 0 {
+			log.Infof("Waiting for %d pods to leave node %s: %s", len(remaining), nodeName, strings.Join(remaining, ", "))
+			return false, nil
+		}
+		return true, nil
+	})
+}
+
+// Evictable returns the pods a drain evicts.
+func Evictable(pods []kcorev1.Pod) []kcorev1.Pod {
+	var evictable []kcorev1.Pod
+	for _, pod := range pods {
+		if _, ok := pod.Annotations[mirrorPodAnnotation]; ok {
+			continue
+		}
+		if pod.Status.Phase == kcorev1.PodSucceeded || pod.Status.Phase == kcorev1.PodFailed {
+			continue
+		}
+		daemon := false
+		for _, owner := range pod.OwnerReferences {
+			if owner.Kind == "DaemonSet" {
+				daemon = true
+			}
+		}
+		if !daemon {
+			evictable = append(evictable, pod)
+		}
+	}
+	return evictable
+}
+
+// BootID returns the boot ID the kubelet of the node reported last.
+func BootID(client kubernetes.Interface, nodeName string) (string, error) {
+	node, err := client.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("Failed to get node %s: %v", nodeName, err)
+	}
+	return node.Status.NodeInfo.BootID, nil
+}
+
+// WaitForReboot waits until the node reports a boot ID other than the given
+// one and is ready.
+func WaitForReboot(client kubernetes.Interface, nodeName, bootID string, timeout time.Duration) error {
+	err := poll(time.Now().Add(timeout), func() (bool, error) {
+		node, err := client.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		return node.Status.NodeInfo.BootID != bootID && Ready(node), nil
+	})
+	if err != nil {
+		return fmt.Errorf("Node %s did not rejoin the cluster: %v", nodeName, err)
+	}
+	return nil
+}
+
+// Heartbeat returns the last heartbeat of the condition Ready of the node,
+// which the kubelet takes by the clock of the node.
+func Heartbeat(client kubernetes.Interface, nodeName string) (time.Time, error) {
+	node, err := client.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Failed to get node %s: %v", nodeName, err)
+	}
+	for _, c := range node.Status.Conditions {
+		if c.Type == kcorev1.NodeReady {
+			return c.LastHeartbeatTime.Time, nil
+		}
+	}
+	return time.Time{}, nil
+}
+
+// WaitForHeartbeat waits until the node reports ready with a heartbeat
+// after the given one, e.g. the last one when its kubelet was restarted.
+// Both heartbeats are taken by the clock of the node, so unlike the local
+// time they aren't off by the clock skew to the node.
+func WaitForHeartbeat(client kubernetes.Interface, nodeName string, heartbeat time.Time, timeout time.Duration) error {
+	err := poll(time.Now().Add(timeout), func() (bool, error) {
+		node, err := client.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		return ReadyAfter(node, heartbeat), nil
+	})
+	if err != nil {
+		return fmt.Errorf("Node %s did not report ready again: %v", nodeName, err)
+	}
+	return nil
+}
+
+// ReadyAfter returns whether the node reports the condition Ready with a
+// heartbeat after the given one of its kubelet.
+func ReadyAfter(node *kcorev1.Node, heartbeat time.Time) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == kcorev1.NodeReady {
+			return c.Status == kcorev1.ConditionTrue && c.LastHeartbeatTime.Time.After(heartbeat)
+		}
+	}
+	return false
+}
+
+// Ready returns whether the node reports the condition Ready.
+func Ready(node *kcorev1.Node) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == kcorev1.NodeReady {
+			return c.Status == kcorev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func poll(deadline time.Time, f func() (bool, error)) error {
+	for {
+		done, err := f()
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("timed out")
+		}
+		time.Sleep(pollInterval)
+	}
+}
@@ -0,0 +1,67 @@
+package drain
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kcorev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+func TestEvictable(t *testing.T) {
+	pods := []kcorev1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "web"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "mirror", Annotations: map[string]string{mirrorPodAnnotation: "abc"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "daemon", OwnerReferences: []metav1.OwnerReference{{Kind: "DaemonSet", Name: "fluentd"}}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "job"}, Status: kcorev1.PodStatus{Phase: kcorev1.PodSucceeded}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "replica", OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web"}}}},
+	}
+
+	evictable := Evictable(pods)
+	if len(evictable) != 2 || evictable[0].Name != "web" || evictable[1].Name != "replica" {
+		t.Errorf("Unexpected evictable pods %v", evictable)
+	}
+}
+
+func TestWithTaint(t *testing.T) {
+	taint := kcorev1.Taint{Key: "kube-machine/maintenance", Effect: kcorev1.TaintEffectNoSchedule}
+	other := kcorev1.Taint{Key: "dedicated", Value: "gpu", Effect: kcorev1.TaintEffectNoSchedule}
+
+	taints, changed := WithTaint([]kcorev1.Taint{other}, taint, true)
+	if !changed || len(taints) != 2 {
+		t.Errorf("Expected the taint to be added, got %v", taints)
+	}
+	if _, changed := WithTaint(taints, taint, true); changed {
+		t.Error("Expected adding an existing taint not to change the taints")
+	}
+	taints, changed = WithTaint(taints, taint, false)
+	if !changed || len(taints) != 1 || taints[0].Key != "dedicated" {
+		t.Errorf("Expected the taint to be removed, got %v", taints)
+	}
+}
+
+func TestReady(t *testing.T) {
+	node := &kcorev1.Node{}
+	if Ready(node) {
+		t.Error("Expected node without conditions not to be ready")
+	}
+	node.Status.Conditions = []kcorev1.NodeCondition{{Type: kcorev1.NodeReady, Status: kcorev1.ConditionTrue}}
+	if !Ready(node) {
+		t.Error("Expected node to be ready")
+	}
+}
+
+func TestReadyAfter(t *testing.T) {
+	heartbeat := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	node := &kcorev1.Node{}
+	node.Status.Conditions = []kcorev1.NodeCondition{{
+		Type: kcorev1.NodeReady, Status: kcorev1.ConditionTrue, LastHeartbeatTime: metav1.NewTime(heartbeat),
+	}}
+	if ReadyAfter(node, heartbeat) {
+		t.Error("Expected the heartbeat of the previous kubelet not to count")
+	}
+	node.Status.Conditions[0].LastHeartbeatTime = metav1.NewTime(heartbeat.Add(10 * time.Second))
+	if !ReadyAfter(node, heartbeat) {
+		t.Error("Expected node to be ready after the heartbeat")
+	}
+}
@@ -0,0 +1,51 @@
+package drift
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// The states of a machine compared to the current templates.
+const (
+	// StateInSync is the state of machines provisioned with the artifacts
+	// provisioning renders now.
+	StateInSync = "in sync"
+	// StateDrifted is the state of machines provisioned with other
+	// artifacts, e.g. before an upgrade of kube-machine or a change of the
+	// templates they use.
+	StateDrifted = "drifted"
+	// StateUnknown is the state of machines without recorded hash.
+	StateUnknown = "unknown"
+)
+
+// Hash returns the hash of the rendered artifacts by name. It doesn't
+// depend on the order of the artifacts.
+func Hash(artifacts map[string][]byte) string {
+	var names []string
+	for name := range artifacts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		// The lengths keep the boundaries between the artifacts apart.
+		fmt.Fprintf(h, "%d:%s%d:", len(name), name, len(artifacts[name]))
+		h.Write(artifacts[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// State compares the hash recorded when a machine was provisioned with the
+// hash of the artifacts it would be provisioned with now.
+func State(recorded, desired string) string {
+	switch {
+	case recorded == "" || desired == "":
+		return StateUnknown
+	case recorded != desired:
+		return StateDrifted
+	}
+	return StateInSync
+}
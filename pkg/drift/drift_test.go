@@ -0,0 +1,33 @@
+package drift
+
+import "testing"
+
+func TestHash(t *testing.T) {
+	a := Hash(map[string][]byte{"kubelet.service": []byte("unit"), "journald": []byte("SystemMaxUse=1G")})
+	b := Hash(map[string][]byte{"journald": []byte("SystemMaxUse=1G"), "kubelet.service": []byte("unit")})
+	if a != b {
+		t.Error("expected the hash not to depend on the order")
+	}
+	if a == Hash(map[string][]byte{"kubelet.service": []byte("unit"), "journald": []byte("SystemMaxUse=2G")}) {
+		t.Error("expected a changed artifact to change the hash")
+	}
+	if Hash(map[string][]byte{"ab": []byte("c")}) == Hash(map[string][]byte{"a": []byte("bc")}) {
+		t.Error("expected the boundaries of the artifacts to change the hash")
+	}
+}
+
+func TestState(t *testing.T) {
+	tests := []struct {
+		recorded, desired, state string
+	}{
+		{"abc", "abc", StateInSync},
+		{"abc", "def", StateDrifted},
+		{"", "def", StateUnknown},
+		{"abc", "", StateUnknown},
+	}
+	for _, test := range tests {
+		if state := State(test.recorded, test.desired); state != test.state {
+			t.Errorf("%q %q: expected %s, got %s", test.recorded, test.desired, test.state, state)
+		}
+	}
+}
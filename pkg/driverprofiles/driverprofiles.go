@@ -0,0 +1,218 @@
+package driverprofiles
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/ghodss/yaml"
+)
+
+// File holds the named credential sets of the drivers, e.g. of two
+// DigitalOcean teams or several AWS accounts.
+type File struct {
+	Profiles map[string]Profile `json:"profiles"`
+	// Defaults names the profile of each driver used by machines without
+	// one.
+	Defaults map[string]string `json:"defaults,omitempty"`
+	// Pools names the profile of the machines of each pool, it overrides
+	// the default of the driver.
+	Pools map[string]string `json:"pools,omitempty"`
+}
+
+// Profile sets create flags of a driver. The values are references to the
+// credentials, which are resolved when a machine is created:
+//
+//	env:NAME            the environment variable NAME
+//	file:PATH           the content of the file
+//	vault:PATH#FIELD    the field of a secret in Vault at $VAULT_ADDR,
+//	                    read with $VAULT_TOKEN
+//	exec:COMMAND        the output of the shell command, e.g. of the CLI
+//	                    of a secret manager
+//
+// Other values are used as they are, so they shouldn't hold secrets.
+type Profile struct {
+	Driver  string            `json:"driver"`
+	Options map[string]string `json:"options"`
+}
+
+// Load reads the profiles of a YAML or JSON file.
+func Load(path string) (*File, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{}
+	if err := yaml.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("Failed to parse driver profiles %s: %v", path, err)
+	}
+	for name, p := range f.Profiles {
+		if p.Driver == "" {
+			return nil, fmt.Errorf("Driver profile %s has no driver", name)
+		}
+	}
+	for _, names := range []map[string]string{f.Defaults, f.Pools} {
+		for _, name := range names {
+			if _, ok := f.Profiles[name]; !ok {
+				return nil, fmt.Errorf("Unknown driver profile %s", name)
+			}
+		}
+	}
+	return f, nil
+}
+
+// Select returns the name of the profile of a machine: the one given to the
+// machine, the one of its pool or the default of the driver. It is empty if
+// there is none.
+func (f *File) Select(driver, profile, pool string) string {
+	if profile != "" {
+		return profile
+	}
+	if name, ok := f.Pools[pool]; ok && pool != "" && f.Profiles[name].Driver == driver {
+		return name
+	}
+	return f.Defaults[driver]
+}
+
+// ResolveFlags sets the driver create flag values of the profile, flags set
+// explicitly keep their values.
+func (f *File) ResolveFlags(driver, profile string, values map[string]interface{}, explicit func(name string) bool) error {
+	p, ok := f.Profiles[profile]
+	if !ok {
+		return fmt.Errorf("Unknown driver profile %s", profile)
+	}
+	if p.Driver != driver {
+		return fmt.Errorf("Driver profile %s is for the %s driver, not %s", profile, p.Driver, driver)
+	}
+
+	var names []string
+	for name := range p.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		current, ok := values[name]
+		if !ok {
+			return fmt.Errorf("Driver profile %s sets %s, which is no flag of the %s driver", profile, name, driver)
+		}
+		if explicit(name) {
+			log.Debugf("Keeping --%s over driver profile %s", name, profile)
+			continue
+		}
+		resolved, err := Resolve(p.Options[name])
+		if err != nil {
+			return fmt.Errorf("Failed to resolve %s of driver profile %s: %v", name, profile, err)
+		}
+		if values[name], err = convert(resolved, current); err != nil {
+			return fmt.Errorf("Invalid %s of driver profile %s: %v", name, profile, err)
+		}
+	}
+	return nil
+}
+
+// Resolve returns the value a reference refers to.
+func Resolve(ref string) (string, error) {
+	i := strings.Index(ref, ":")
+	if i < 0 {
+		return ref, nil
+	}
+	kind, arg := ref[:i], ref[i+1:]
+	switch kind {
+	case "env":
+		value, ok := os.LookupEnv(arg)
+		if !ok {
+			return "", fmt.Errorf("Environment variable %s is not set", arg)
+		}
+		return value, nil
+	case "file":
+		data, err := ioutil.ReadFile(arg)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	case "vault":
+		return vaultSecret(arg)
+	case "exec":
+		out, err := exec.Command("/bin/sh", "-c", arg).Output()
+		if err != nil {
+			return "", fmt.Errorf("Failed to run %q: %v", arg, err)
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+	return ref, nil
+}
+
+// vaultSecret reads the field of a secret of the KV secrets engine, path
+// and field are separated by #.
+func vaultSecret(ref string) (string, error) {
+	i := strings.LastIndex(ref, "#")
+	if i < 0 {
+		return "", fmt.Errorf("Invalid Vault reference %q, expected PATH#FIELD", ref)
+	}
+	path, field := strings.Trim(ref[:i], "/"), ref[i+1:]
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", fmt.Errorf("VAULT_ADDR is not set")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(addr, "/")+"/v1/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Vault responded with %s for %s", resp.Status, path)
+	}
+
+	// Version 2 of the KV secrets engine nests the secret in data.data.
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("Failed to decode the secret %s: %v", path, err)
+	}
+	data := secret.Data
+	if nested, ok := data["data"]; ok {
+		if _, isField := data[field]; !isField {
+			data = nil
+			if err := json.Unmarshal(nested, &data); err != nil {
+				return "", fmt.Errorf("Failed to decode the secret %s: %v", path, err)
+			}
+		}
+	}
+	raw, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("Secret %s has no field %s", path, field)
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		// Numbers and booleans are used in their JSON form.
+		return string(raw), nil
+	}
+	return value, nil
+}
+
+// convert converts the value to the type of the current flag value.
+func convert(value string, current interface{}) (interface{}, error) {
+	switch current.(type) {
+	case int:
+		return strconv.Atoi(value)
+	case bool:
+		return strconv.ParseBool(value)
+	case []string:
+		return strings.Split(value, ","), nil
+	}
+	return value, nil
+}
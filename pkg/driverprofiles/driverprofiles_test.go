@@ -0,0 +1,135 @@
+package driverprofiles
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const profiles = `
+profiles:
+  do-team-a:
+    driver: digitalocean
+    options:
+      digitalocean-access-token: env:DO_TEAM_A_TOKEN
+  do-team-b:
+    driver: digitalocean
+    options:
+      digitalocean-access-token: exec:echo team-b
+      digitalocean-size: 4gb
+  aws-prod:
+    driver: amazonec2
+    options:
+      amazonec2-access-key: file:%s
+defaults:
+  digitalocean: do-team-a
+pools:
+  batch: do-team-b
+`
+
+func TestSelectAndResolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "driverprofiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyPath := filepath.Join(dir, "access-key")
+	if err := ioutil.WriteFile(keyPath, []byte("AKIA123\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "profiles.yaml")
+	if err := ioutil.WriteFile(path, []byte(fmt.Sprintf(profiles, keyPath)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p := f.Select("digitalocean", "", ""); p != "do-team-a" {
+		t.Errorf("expected the default profile, got %q", p)
+	}
+	if p := f.Select("digitalocean", "", "batch"); p != "do-team-b" {
+		t.Errorf("expected the profile of the pool, got %q", p)
+	}
+	if p := f.Select("digitalocean", "do-team-a", "batch"); p != "do-team-a" {
+		t.Errorf("expected the profile of the machine, got %q", p)
+	}
+	if p := f.Select("amazonec2", "", "batch"); p != "" {
+		t.Errorf("expected no profile, got %q", p)
+	}
+
+	os.Setenv("DO_TEAM_A_TOKEN", "team-a")
+	defer os.Unsetenv("DO_TEAM_A_TOKEN")
+	values := map[string]interface{}{"digitalocean-access-token": "", "digitalocean-size": "512mb"}
+	if err := f.ResolveFlags("digitalocean", "do-team-a", values, func(string) bool { return false }); err != nil {
+		t.Fatal(err)
+	}
+	if values["digitalocean-access-token"] != "team-a" {
+		t.Errorf("unexpected values %v", values)
+	}
+
+	values = map[string]interface{}{"digitalocean-access-token": "", "digitalocean-size": "8gb"}
+	explicit := func(name string) bool { return name == "digitalocean-size" }
+	if err := f.ResolveFlags("digitalocean", "do-team-b", values, explicit); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(values, map[string]interface{}{"digitalocean-access-token": "team-b", "digitalocean-size": "8gb"}) {
+		t.Errorf("unexpected values %v", values)
+	}
+
+	values = map[string]interface{}{"amazonec2-access-key": ""}
+	if err := f.ResolveFlags("amazonec2", "aws-prod", values, explicit); err != nil || values["amazonec2-access-key"] != "AKIA123" {
+		t.Errorf("unexpected values %v: %v", values, err)
+	}
+
+	if err := f.ResolveFlags("amazonec2", "do-team-a", values, explicit); err == nil {
+		t.Error("expected an error for the profile of another driver")
+	}
+	if err := f.ResolveFlags("digitalocean", "do-team-b", map[string]interface{}{}, explicit); err == nil {
+		t.Error("expected an error for an unknown flag")
+	}
+}
+
+func TestResolveVault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/aws":
+			w.Write([]byte(`{"data": {"data": {"secret_key": "v2"}, "metadata": {}}}`))
+		case "/v1/kv/aws":
+			w.Write([]byte(`{"data": {"secret_key": "v1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	os.Setenv("VAULT_ADDR", server.URL)
+	os.Setenv("VAULT_TOKEN", "s.token")
+	defer os.Unsetenv("VAULT_ADDR")
+	defer os.Unsetenv("VAULT_TOKEN")
+
+	for ref, expected := range map[string]string{"vault:secret/data/aws#secret_key": "v2", "vault:kv/aws#secret_key": "v1", "us-east-1": "us-east-1"} {
+		value, err := Resolve(ref)
+		if err != nil {
+			t.Errorf("%s: %v", ref, err)
+			continue
+		}
+		if value != expected {
+			t.Errorf("%s: expected %q, got %q", ref, expected, value)
+		}
+	}
+	for _, ref := range []string{"vault:kv/aws#access_key", "vault:kv/missing#secret_key", "vault:kv/aws", "env:KUBE_MACHINE_UNSET"} {
+		if _, err := Resolve(ref); err == nil {
+			t.Errorf("%s: expected an error", ref)
+		}
+	}
+}
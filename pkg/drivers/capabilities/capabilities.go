@@ -0,0 +1,103 @@
+package capabilities
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/docker/machine/libmachine/drivers"
+)
+
+// Capabilities describes the optional operations a driver supports, so
+// callers can pick a strategy up front instead of failing at runtime.
+type Capabilities struct {
+	// UserData is set if the driver can pass user-data (cloud-init) to the
+	// machine at creation time.
+	UserData bool
+	// StopStart is set if the machine can be stopped, started and killed.
+	StopStart bool
+	// Resize is set if the machine size can be changed after creation.
+	Resize bool
+	// Spot is set if the driver can create spot/preemptible machines.
+	Spot bool
+}
+
+// Reporter is implemented by drivers which report their own capabilities.
+type Reporter interface {
+	Capabilities() Capabilities
+}
+
+// ErrNotReported is returned over the plugin RPC shim by drivers which don't
+// implement Reporter.
+var ErrNotReported = errors.New("Driver does not report its capabilities")
+
+// known holds the capabilities of the drivers which do not implement
+// Reporter.
+var known = map[string]Capabilities{
+	"amazonec2":       {UserData: true, StopStart: true, Spot: true},
+	"azure":           {UserData: true, StopStart: true},
+	"digitalocean":    {UserData: true, StopStart: true},
+	"exoscale":        {UserData: true, StopStart: true},
+	"generic":         {},
+	"google":          {UserData: true, StopStart: true, Spot: true},
+	"hyperv":          {StopStart: true},
+	"libvirt":         {UserData: true, StopStart: true},
+	"none":            {},
+	"openstack":       {UserData: true, StopStart: true},
+	"rackspace":       {StopStart: true},
+	"softlayer":       {StopStart: true},
+	"virtualbox":      {StopStart: true},
+	"vmwarefusion":    {StopStart: true},
+	"vmwarevcloudair": {StopStart: true},
+	"vmwarevsphere":   {StopStart: true},
+}
+
+// userDataFlags are the create flags of the drivers taking a user-data
+// file.
+var userDataFlags = map[string]string{
+	"amazonec2":    "amazonec2-userdata",
+	"digitalocean": "digitalocean-userdata",
+	"exoscale":     "exoscale-userdata",
+	"google":       "google-userdata",
+	"openstack":    "openstack-user-data-file",
+}
+
+// UserDataFlag returns the create flag of the driver taking a user-data
+// file, it is empty if there is none.
+func UserDataFlag(driver string) string {
+	return userDataFlags[driver]
+}
+
+// For returns the capabilities of the driver. Unknown drivers are assumed to
+// support nothing optional.
+func For(d drivers.Driver) Capabilities {
+	if r, ok := d.(Reporter); ok {
+		return r.Capabilities()
+	}
+	return Known(d.DriverName())
+}
+
+// Known returns the capabilities of the named driver which doesn't implement
+// Reporter.
+func Known(driver string) Capabilities {
+	return known[driver]
+}
+
+// ErrNotSupported is returned when an operation is not supported by the
+// driver of a machine.
+type ErrNotSupported struct {
+	Driver    string
+	Operation string
+}
+
+func (e ErrNotSupported) Error() string {
+	return fmt.Sprintf("%s driver does not support %s", e.Driver, e.Operation)
+}
+
+// Check returns ErrNotSupported if the driver lacks the capability selected
+// by the given function.
+func Check(d drivers.Driver, operation string, supported func(Capabilities) bool) error {
+	if !supported(For(d)) {
+		return ErrNotSupported{Driver: d.DriverName(), Operation: operation}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package capabilities
+
+import (
+	"testing"
+
+	"github.com/docker/machine/drivers/fakedriver"
+	"github.com/docker/machine/libmachine/drivers"
+)
+
+type namedDriver struct {
+	*fakedriver.Driver
+	name string
+}
+
+func (d *namedDriver) DriverName() string {
+	return d.name
+}
+
+type reportingDriver struct {
+	*fakedriver.Driver
+}
+
+func (d *reportingDriver) Capabilities() Capabilities {
+	return Capabilities{Resize: true}
+}
+
+func TestFor(t *testing.T) {
+	var tests = []struct {
+		description string
+		driver      drivers.Driver
+		expected    Capabilities
+	}{
+		{"known driver", &namedDriver{&fakedriver.Driver{}, "google"}, Capabilities{UserData: true, StopStart: true, Spot: true}},
+		{"unknown driver", &namedDriver{&fakedriver.Driver{}, "foo"}, Capabilities{}},
+		{"reporting driver", &reportingDriver{&fakedriver.Driver{}}, Capabilities{Resize: true}},
+	}
+
+	for _, test := range tests {
+		if c := For(test.driver); c != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.description, test.expected, c)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	d := &namedDriver{&fakedriver.Driver{}, "generic"}
+
+	err := Check(d, "stop", func(c Capabilities) bool { return c.StopStart })
+	if err == nil {
+		t.Fatal("Expected generic driver not to support stop")
+	}
+	if err.Error() != "generic driver does not support stop" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	d.name = "virtualbox"
+	if err := Check(d, "stop", func(c Capabilities) bool { return c.StopStart }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserDataFlag(t *testing.T) {
+	for driver, c := range known {
+		if flag := UserDataFlag(driver); flag != "" && !c.UserData {
+			t.Errorf("%s has user-data flag %s without the user-data capability", driver, flag)
+		}
+	}
+	if flag := UserDataFlag("openstack"); flag != "openstack-user-data-file" {
+		t.Errorf("Unexpected openstack user-data flag %q", flag)
+	}
+}
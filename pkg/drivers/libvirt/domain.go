@@ -0,0 +1,217 @@
+package libvirt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"mime/multipart"
+	"net"
+	"net/textproto"
+	"strings"
+	"text/template"
+
+	"github.com/docker/machine/libmachine/state"
+)
+
+var domainTemplate = template.Must(template.New("domain").Funcs(template.FuncMap{
+	"xml": escapeXML,
+}).Parse(`<domain type='kvm'>
+  <name>{{ xml .Name }}</name>
+  <memory unit='MiB'>{{ .Memory }}</memory>
+  <vcpu>{{ .CPUCount }}</vcpu>
+  <os>
+    <type arch='x86_64'>hvm</type>
+    <boot dev='hd'/>
+  </os>
+  <features>
+    <acpi/>
+    <apic/>
+  </features>
+  <cpu mode='host-passthrough'/>
+  <devices>
+    <disk type='file' device='disk'>
+      <driver name='qemu' type='qcow2'/>
+      <source file='{{ xml .Disk }}'/>
+      <target dev='vda' bus='virtio'/>
+    </disk>
+    <disk type='file' device='cdrom'>
+      <driver name='qemu' type='raw'/>
+      <source file='{{ xml .Seed }}'/>
+      <target dev='hdc' bus='ide'/>
+      <readonly/>
+    </disk>
+{{- range .Volumes }}
+    <disk type='file' device='disk'>
+      <driver name='qemu' type='qcow2'/>
+      <source file='{{ xml .Path }}'/>
+      <target dev='{{ .Target }}' bus='virtio'/>
+    </disk>
+{{- end }}
+{{- if .Bridge }}
+    <interface type='bridge'>
+      <source bridge='{{ xml .Bridge }}'/>
+      <model type='virtio'/>
+    </interface>
+{{- else }}
+    <interface type='network'>
+      <source network='{{ xml .Network }}'/>
+      <model type='virtio'/>
+    </interface>
+{{- end }}
+    <serial type='pty'>
+      <log file='{{ xml .Console }}' append='on'/>
+      <target port='0'/>
+    </serial>
+    <console type='pty'>
+      <target type='serial' port='0'/>
+    </console>
+    <rng model='virtio'>
+      <backend model='random'>/dev/urandom</backend>
+    </rng>
+  </devices>
+</domain>
+`))
+
+var userDataTemplate = template.Must(template.New("user-data").Parse(`#cloud-config
+hostname: {{ .Hostname }}
+users:
+  - name: {{ .User }}
+    sudo: ALL=(ALL) NOPASSWD:ALL
+    shell: /bin/bash
+    ssh_authorized_keys:
+      - {{ .PublicKey }}
+`))
+
+func escapeXML(s string) (string, error) {
+	var buf bytes.Buffer
+	if err := xml.EscapeText(&buf, []byte(s)); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+type volumeDisk struct {
+	Path, Target string
+}
+
+func renderDomain(d *Driver, disk, seed string) ([]byte, error) {
+	var vols []volumeDisk
+	for _, v := range d.Volumes {
+		vols = append(vols, volumeDisk{
+			Path:   d.ResolveStorePath(v.Name),
+			Target: strings.TrimPrefix(v.Device, "/dev/"),
+		})
+	}
+
+	ctx := struct {
+		Name, Disk, Seed, Console, Network, Bridge string
+		Memory, CPUCount                           int
+		Volumes                                    []volumeDisk
+	}{
+		Name:     d.MachineName,
+		Disk:     disk,
+		Seed:     seed,
+		Console:  d.ResolveStorePath(consoleFile),
+		Network:  d.Network,
+		Bridge:   d.Bridge,
+		Memory:   d.Memory,
+		CPUCount: d.CPUCount,
+		Volumes:  vols,
+	}
+	var buf bytes.Buffer
+	if err := domainTemplate.Execute(&buf, ctx); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renderUserData returns the cloud-config creating the SSH user. If the user
+// supplied additional user-data, both are combined into a multi-part MIME
+// document which cloud-init processes part by part.
+func renderUserData(hostname, user, publicKey string, extra []byte) ([]byte, error) {
+	var cloudConfig bytes.Buffer
+	if err := userDataTemplate.Execute(&cloudConfig, struct {
+		Hostname, User, PublicKey string
+	}{hostname, user, publicKey}); err != nil {
+		return nil, err
+	}
+	if len(extra) == 0 {
+		return cloudConfig.Bytes(), nil
+	}
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	parts := []struct {
+		contentType string
+		data        []byte
+	}{
+		{"text/cloud-config", cloudConfig.Bytes()},
+		{userDataContentType(extra), extra},
+	}
+	for _, p := range parts {
+		pw, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {p.contentType + `; charset="utf-8"`}})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := pw.Write(p.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	header := fmt.Sprintf("Content-Type: multipart/mixed; boundary=%q\nMIME-Version: 1.0\n\n", w.Boundary())
+	return append([]byte(header), body.Bytes()...), nil
+}
+
+func userDataContentType(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("#!")):
+		return "text/x-shellscript"
+	case bytes.HasPrefix(data, []byte("#cloud-boothook")):
+		return "text/cloud-boothook"
+	default:
+		return "text/cloud-config"
+	}
+}
+
+func renderMetaData(hostname string) []byte {
+	return []byte(fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", hostname, hostname))
+}
+
+// parseDomIfAddrs returns the IPv4 and IPv6 addresses listed in the output of
+// `virsh domifaddr`, addresses are printed in CIDR notation.
+func parseDomIfAddrs(out string) []string {
+	var addrs []string
+	s := bufio.NewScanner(strings.NewReader(out))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 4 || (fields[2] != "ipv4" && fields[2] != "ipv6") {
+			continue
+		}
+		ip, _, err := net.ParseCIDR(fields[3])
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, ip.String())
+	}
+	return addrs
+}
+
+func domainState(out string) state.State {
+	switch strings.TrimSpace(out) {
+	case "running", "idle":
+		return state.Running
+	case "paused", "pmsuspended":
+		return state.Paused
+	case "in shutdown":
+		return state.Stopping
+	case "shut off":
+		return state.Stopped
+	case "crashed":
+		return state.Error
+	}
+	return state.None
+}
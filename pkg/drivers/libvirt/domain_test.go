@@ -0,0 +1,119 @@
+package libvirt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/state"
+
+	"github.com/kubermatic/kube-machine/pkg/volumes"
+)
+
+func TestRenderDomain(t *testing.T) {
+	d := NewDriver("node-1", "/tmp/store").(*Driver)
+	d.Bridge = "br0"
+
+	xml, err := renderDomain(d, "/tmp/store/disk.qcow2", "/tmp/store/seed.iso")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"<name>node-1</name>",
+		"<memory unit='MiB'>2048</memory>",
+		"<source file='/tmp/store/disk.qcow2'/>",
+		"<source bridge='br0'/>",
+		"<log file='/tmp/store/machines/node-1/console.log' append='on'/>",
+	} {
+		if !strings.Contains(string(xml), expected) {
+			t.Errorf("Expected domain XML to contain %q:\n%s", expected, xml)
+		}
+	}
+	if strings.Contains(string(xml), "<source network=") {
+		t.Errorf("Expected bridged domain not to use a libvirt network:\n%s", xml)
+	}
+}
+
+func TestRenderDomainVolumes(t *testing.T) {
+	d := NewDriver("node-1", "/tmp/store").(*Driver)
+	d.Volumes = []volumes.Volume{{Name: "volume-0.qcow2", Device: "/dev/vdb"}}
+
+	xml, err := renderDomain(d, "/tmp/store/disk.qcow2", "/tmp/store/seed.iso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"<source file='/tmp/store/machines/node-1/volume-0.qcow2'/>",
+		"<target dev='vdb' bus='virtio'/>",
+	} {
+		if !strings.Contains(string(xml), expected) {
+			t.Errorf("Expected domain XML to contain %q:\n%s", expected, xml)
+		}
+	}
+}
+
+func TestRenderDomainEscapesValues(t *testing.T) {
+	d := &Driver{BaseDriver: &drivers.BaseDriver{MachineName: "a'<b>"}}
+
+	xml, err := renderDomain(d, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(xml), "<name>a&#39;&lt;b&gt;</name>") {
+		t.Fatalf("Expected machine name to be escaped:\n%s", xml)
+	}
+}
+
+func TestRenderUserData(t *testing.T) {
+	data, err := renderUserData("node-1", "ubuntu", "ssh-rsa AAAA", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "#cloud-config\n") {
+		t.Fatalf("Expected plain cloud-config, got:\n%s", data)
+	}
+
+	data, err = renderUserData("node-1", "ubuntu", "ssh-rsa AAAA", []byte("#!/bin/sh\necho hi\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"multipart/mixed", "text/cloud-config", "text/x-shellscript", "echo hi"} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("Expected multi-part user-data to contain %q:\n%s", expected, data)
+		}
+	}
+}
+
+func TestParseDomIfAddr(t *testing.T) {
+	out := ` Name       MAC address          Protocol     Address
+-------------------------------------------------------------------------------
+ vnet0      52:54:00:2d:8c:5e    ipv6         fe80::5054:ff:fe2d:8c5e/64
+ vnet0      52:54:00:2d:8c:5e    ipv4         192.168.122.23/24
+`
+	addrs := parseDomIfAddrs(out)
+	if strings.Join(addrs, " ") != "fe80::5054:ff:fe2d:8c5e 192.168.122.23" {
+		t.Fatalf("Expected the IPv6 and IPv4 addresses, got %q", addrs)
+	}
+	if addrs := parseDomIfAddrs(""); len(addrs) != 0 {
+		t.Fatalf("Expected no IP address, got %q", addrs)
+	}
+}
+
+func TestDomainState(t *testing.T) {
+	var tests = []struct {
+		out      string
+		expected state.State
+	}{
+		{"running\n\n", state.Running},
+		{"shut off\n", state.Stopped},
+		{"paused\n", state.Paused},
+		{"unknown\n", state.None},
+	}
+
+	for _, test := range tests {
+		if s := domainState(test.out); s != test.expected {
+			t.Errorf("domainState(%q) = %v, expected %v", test.out, s, test.expected)
+		}
+	}
+}
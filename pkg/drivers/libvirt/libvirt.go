@@ -0,0 +1,440 @@
+package libvirt
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/engine"
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/mcnflag"
+	"github.com/docker/machine/libmachine/mcnutils"
+	"github.com/docker/machine/libmachine/ssh"
+	"github.com/docker/machine/libmachine/state"
+
+	"github.com/kubermatic/kube-machine/pkg/drivers/capabilities"
+	"github.com/kubermatic/kube-machine/pkg/ipfamily"
+	"github.com/kubermatic/kube-machine/pkg/volumes"
+)
+
+const (
+	driverName = "libvirt"
+
+	defaultURI      = "qemu:///system"
+	defaultMemory   = 2048
+	defaultCPUCount = 2
+	defaultDiskSize = 20
+	defaultNetwork  = "default"
+	defaultSSHUser  = "ubuntu"
+
+	diskFile   = "disk.qcow2"
+	seedFile   = "seed.iso"
+	domainFile = "domain.xml"
+	// consoleFile receives the output of the serial console of the domain.
+	consoleFile = "console.log"
+
+	// maxVolumes is the number of volumes the devices vdb to vdz are left
+	// for, vda is the root disk.
+	maxVolumes = 'z' - 'b' + 1
+)
+
+var (
+	requiredBinaries = []string{"virsh", "qemu-img", "genisoimage"}
+
+	errNoBaseImage = errors.New("libvirt driver requires the --libvirt-image option")
+)
+
+// Driver creates machines as libvirt/KVM domains booted from a qcow2 base
+// image and bootstrapped through a cloud-init seed ISO.
+type Driver struct {
+	*drivers.BaseDriver
+	EnginePort int
+
+	URI       string
+	BaseImage string
+	Memory    int
+	CPUCount  int
+	DiskSize  int
+	Network   string
+	Bridge    string
+	UserData  string
+	Volumes   []volumes.Volume
+	// IPFamily selects the address the machine is reached at.
+	IPFamily string
+}
+
+func NewDriver(hostName, storePath string) drivers.Driver {
+	return &Driver{
+		EnginePort: engine.DefaultPort,
+		URI:        defaultURI,
+		Memory:     defaultMemory,
+		CPUCount:   defaultCPUCount,
+		DiskSize:   defaultDiskSize,
+		Network:    defaultNetwork,
+		BaseDriver: &drivers.BaseDriver{
+			MachineName: hostName,
+			StorePath:   storePath,
+			SSHUser:     defaultSSHUser,
+		},
+	}
+}
+
+func (d *Driver) GetCreateFlags() []mcnflag.Flag {
+	return []mcnflag.Flag{
+		mcnflag.StringFlag{
+			Name:   "libvirt-uri",
+			Usage:  "libvirt connection URI",
+			Value:  defaultURI,
+			EnvVar: "LIBVIRT_DEFAULT_URI",
+		},
+		mcnflag.StringFlag{
+			Name:   "libvirt-image",
+			Usage:  "Path to the qcow2 cloud image used as backing file of the machine disk",
+			EnvVar: "LIBVIRT_IMAGE",
+		},
+		mcnflag.IntFlag{
+			Name:   "libvirt-memory",
+			Usage:  "Memory of the machine in MB",
+			Value:  defaultMemory,
+			EnvVar: "LIBVIRT_MEMORY",
+		},
+		mcnflag.IntFlag{
+			Name:   "libvirt-cpu-count",
+			Usage:  "Number of virtual CPUs of the machine",
+			Value:  defaultCPUCount,
+			EnvVar: "LIBVIRT_CPU_COUNT",
+		},
+		mcnflag.IntFlag{
+			Name:   "libvirt-disk-size",
+			Usage:  "Size of the machine disk in GB",
+			Value:  defaultDiskSize,
+			EnvVar: "LIBVIRT_DISK_SIZE",
+		},
+		mcnflag.StringFlag{
+			Name:   "libvirt-network",
+			Usage:  "libvirt (NAT) network to attach the machine to",
+			Value:  defaultNetwork,
+			EnvVar: "LIBVIRT_NETWORK",
+		},
+		mcnflag.StringFlag{
+			Name:   "libvirt-bridge",
+			Usage:  "Host bridge to attach the machine to instead of a libvirt network",
+			EnvVar: "LIBVIRT_BRIDGE",
+		},
+		mcnflag.StringFlag{
+			Name:   "libvirt-ssh-user",
+			Usage:  "SSH user configured by cloud-init",
+			Value:  defaultSSHUser,
+			EnvVar: "LIBVIRT_SSH_USER",
+		},
+		mcnflag.StringFlag{
+			Name:   "libvirt-userdata",
+			Usage:  "Path to a cloud-config file merged into the generated user-data",
+			EnvVar: "LIBVIRT_USERDATA",
+		},
+		mcnflag.StringFlag{
+			Name:   "libvirt-ip-family",
+			Usage:  "Family of the address the machine is reached at: ipv4, ipv6 or dual (IPv4 if the machine has one)",
+			Value:  ipfamily.IPv4,
+			EnvVar: "LIBVIRT_IP_FAMILY",
+		},
+		mcnflag.StringSliceFlag{
+			Name:  "libvirt-volume",
+			Usage: "Additional disk mounted on the machine, e.g. size=50,mount=/var/lib/containerd[,fs=xfs]",
+		},
+	}
+}
+
+func (d *Driver) DriverName() string {
+	return driverName
+}
+
+func (d *Driver) Capabilities() capabilities.Capabilities {
+	return capabilities.Capabilities{UserData: true, StopStart: true}
+}
+
+func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
+	d.URI = flags.String("libvirt-uri")
+	d.BaseImage = flags.String("libvirt-image")
+	d.Memory = flags.Int("libvirt-memory")
+	d.CPUCount = flags.Int("libvirt-cpu-count")
+	d.DiskSize = flags.Int("libvirt-disk-size")
+	d.Network = flags.String("libvirt-network")
+	d.Bridge = flags.String("libvirt-bridge")
+	d.SSHUser = flags.String("libvirt-ssh-user")
+	d.UserData = flags.String("libvirt-userdata")
+	d.IPFamily = flags.String("libvirt-ip-family")
+	d.SSHPort = drivers.DefaultSSHPort
+	d.SetSwarmConfigFromFlags(flags)
+
+	if d.BaseImage == "" {
+		return errNoBaseImage
+	}
+	if err := ipfamily.Validate(d.IPFamily); err != nil {
+		return err
+	}
+
+	vols, err := volumes.ParseAll(flags.StringSlice("libvirt-volume"))
+	if err != nil {
+		return err
+	}
+	if len(vols) > maxVolumes {
+		return fmt.Errorf("libvirt machines have at most %d volumes, got %d", maxVolumes, len(vols))
+	}
+	for i := range vols {
+		// The root disk is vda, volumes follow in the order they were given.
+		vols[i].Name = fmt.Sprintf("volume-%d.qcow2", i)
+		vols[i].Device = fmt.Sprintf("/dev/vd%c", 'b'+i)
+	}
+	d.Volumes = vols
+	return nil
+}
+
+// PreCreateCheck reports every missing binary and unreadable file at once.
+func (d *Driver) PreCreateCheck() error {
+	multierr := mcnutils.MultiError{}
+	for _, bin := range requiredBinaries {
+		if _, err := exec.LookPath(bin); err != nil {
+			multierr.Errs = append(multierr.Errs, fmt.Errorf("libvirt driver requires %q in PATH: %v", bin, err))
+		}
+	}
+
+	if _, err := os.Stat(d.BaseImage); err != nil {
+		multierr.Errs = append(multierr.Errs, fmt.Errorf("Base image %s could not be read: %v", d.BaseImage, err))
+	}
+	if d.UserData != "" {
+		if _, err := os.Stat(d.UserData); err != nil {
+			multierr.Errs = append(multierr.Errs, fmt.Errorf("User-data %s could not be read: %v", d.UserData, err))
+		}
+	}
+	if len(multierr.Errs) > 0 {
+		return multierr
+	}
+
+	if _, err := d.virsh("dominfo", d.MachineName); err == nil {
+		return fmt.Errorf("libvirt domain %q already exists", d.MachineName)
+	}
+	return nil
+}
+
+func (d *Driver) Create() error {
+	log.Info("Generating SSH key...")
+	if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
+		return err
+	}
+	publicKey, err := ioutil.ReadFile(d.GetSSHKeyPath() + ".pub")
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Creating disk image from %s...", d.BaseImage)
+	disk := d.ResolveStorePath(diskFile)
+	if out, err := exec.Command("qemu-img", "create", "-f", "qcow2", "-F", "qcow2", "-b", d.BaseImage,
+		disk, fmt.Sprintf("%dG", d.DiskSize)).CombinedOutput(); err != nil {
+		return fmt.Errorf("Error creating disk image: %v: %s", err, out)
+	}
+	for _, v := range d.Volumes {
+		log.Infof("Creating %dG volume for %s...", v.SizeGB, v.MountPath)
+		if out, err := exec.Command("qemu-img", "create", "-f", "qcow2", d.ResolveStorePath(v.Name),
+			fmt.Sprintf("%dG", v.SizeGB)).CombinedOutput(); err != nil {
+			return fmt.Errorf("Error creating volume: %v: %s", err, out)
+		}
+	}
+
+	log.Info("Creating cloud-init seed ISO...")
+	var extra []byte
+	if d.UserData != "" {
+		if extra, err = ioutil.ReadFile(d.UserData); err != nil {
+			return err
+		}
+	}
+	if err := d.createSeedISO(strings.TrimSpace(string(publicKey)), extra); err != nil {
+		return err
+	}
+
+	log.Info("Defining libvirt domain...")
+	xml, err := renderDomain(d, disk, d.ResolveStorePath(seedFile))
+	if err != nil {
+		return err
+	}
+	domainPath := d.ResolveStorePath(domainFile)
+	if err := ioutil.WriteFile(domainPath, xml, 0600); err != nil {
+		return err
+	}
+	if _, err := d.virsh("define", domainPath); err != nil {
+		return err
+	}
+
+	if err := d.Start(); err != nil {
+		return err
+	}
+
+	log.Info("Waiting for the machine to get an IP address...")
+	return mcnutils.WaitForSpecific(func() bool {
+		ip, err := d.GetIP()
+		return err == nil && ip != ""
+	}, 60, 2*time.Second)
+}
+
+func (d *Driver) createSeedISO(publicKey string, extraUserData []byte) error {
+	dir, err := ioutil.TempDir("", "kube-machine-seed-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	userData, err := renderUserData(d.MachineName, d.SSHUser, publicKey, extraUserData)
+	if err != nil {
+		return err
+	}
+	files := map[string][]byte{
+		"user-data": userData,
+		"meta-data": renderMetaData(d.MachineName),
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			return err
+		}
+	}
+
+	out, err := exec.Command("genisoimage", "-output", d.ResolveStorePath(seedFile),
+		"-volid", "cidata", "-joliet", "-rock",
+		filepath.Join(dir, "user-data"), filepath.Join(dir, "meta-data")).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Error creating seed ISO: %v: %s", err, out)
+	}
+	return nil
+}
+
+func (d *Driver) GetSSHHostname() (string, error) {
+	return d.GetIP()
+}
+
+func (d *Driver) GetIP() (string, error) {
+	// Machines attached to a bridge don't get a lease from libvirt's dnsmasq,
+	// their address can only be found in the host ARP table.
+	source := "lease"
+	if d.Bridge != "" {
+		source = "arp"
+	}
+	out, err := d.virsh("domifaddr", d.MachineName, "--source", source)
+	if err != nil {
+		return "", err
+	}
+	addrs := parseDomIfAddrs(out)
+	if len(addrs) == 0 {
+		return "", errors.New("IP address is not yet assigned")
+	}
+	return ipfamily.Select(addrs, d.IPFamily)
+}
+
+func (d *Driver) GetURL() (string, error) {
+	if err := drivers.MustBeRunning(d); err != nil {
+		return "", err
+	}
+
+	ip, err := d.GetIP()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, strconv.Itoa(d.EnginePort))), nil
+}
+
+func (d *Driver) GetState() (state.State, error) {
+	out, err := d.virsh("domstate", d.MachineName)
+	if err != nil {
+		return state.Error, err
+	}
+	return domainState(out), nil
+}
+
+// GetBootLog returns the serial console output the domain logged to the
+// machine directory.
+func (d *Driver) GetBootLog() (string, error) {
+	data, err := ioutil.ReadFile(d.ResolveStorePath(consoleFile))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (d *Driver) Start() error {
+	_, err := d.virsh("start", d.MachineName)
+	return err
+}
+
+func (d *Driver) Stop() error {
+	if _, err := d.virsh("shutdown", d.MachineName); err != nil {
+		return err
+	}
+	return mcnutils.WaitForSpecific(drivers.MachineInState(d, state.Stopped), 60, 2*time.Second)
+}
+
+func (d *Driver) Restart() error {
+	_, err := d.virsh("reboot", d.MachineName)
+	return err
+}
+
+func (d *Driver) Kill() error {
+	_, err := d.virsh("destroy", d.MachineName)
+	return err
+}
+
+// Remove deletes the domain and its volumes. Its disk and seed ISO live in the
+// machine directory which is removed together with the machine.
+func (d *Driver) Remove() error {
+	if err := d.removeDomain(); err != nil {
+		return err
+	}
+
+	// The volumes are only deleted once no domain uses them anymore.
+	for _, v := range d.Volumes {
+		if err := os.Remove(d.ResolveStorePath(v.Name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Driver) removeDomain() error {
+	s, err := d.GetState()
+	if err != nil {
+		if !domainNotFound(err) {
+			return err
+		}
+		log.Debugf("libvirt domain %q does not exist: %v", d.MachineName, err)
+		return nil
+	}
+	if s == state.Running {
+		if err := d.Kill(); err != nil {
+			return err
+		}
+	}
+	_, err = d.virsh("undefine", d.MachineName)
+	return err
+}
+
+// domainNotFound returns true if virsh failed because the domain isn't
+// defined, unlike failing to connect to libvirt.
+func domainNotFound(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "failed to get domain") || strings.Contains(msg, "Domain not found")
+}
+
+func (d *Driver) virsh(args ...string) (string, error) {
+	args = append([]string{"--connect", d.URI}, args...)
+	log.Debugf("virsh %s", strings.Join(args, " "))
+	out, err := exec.Command("virsh", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("virsh %s failed: %v: %s", args[2], err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
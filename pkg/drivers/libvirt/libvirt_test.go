@@ -0,0 +1,134 @@
+package libvirt
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/kubermatic/kube-machine/pkg/volumes"
+)
+
+// fakeVirsh puts a virsh on the PATH which logs its commands, whether the
+// volume exists when it runs, and answers domstate with the state.
+func fakeVirsh(t *testing.T, dir, volume, domstate string) (string, func()) {
+	calls := filepath.Join(dir, "calls")
+	script := `#!/bin/sh
+shift 2
+if [ -e "` + volume + `" ]; then volume=with-volume; else volume=without-volume; fi
+echo "$1 $volume" >> "` + calls + `"
+if [ "$1" = domstate ]; then
+	` + domstate + `
+fi
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "virsh"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	return calls, func() { os.Setenv("PATH", path) }
+}
+
+func TestRemove(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		// domstate is the answer of virsh domstate.
+		domstate string
+		err      string
+		calls    string
+		// kept is set if the volume remains.
+		kept bool
+	}{
+		{
+			name:     "running domain",
+			domstate: "echo running",
+			calls:    "domstate with-volume\ndestroy with-volume\nundefine with-volume\n",
+		},
+		{
+			// destroy fails, so the domain still runs with the volume attached.
+			name: "remaining domain",
+			domstate: `echo running; exit 0
+elif [ "$1" = destroy ]; then
+	echo "domain is busy" >&2; exit 1`,
+			err:   "domain is busy",
+			calls: "domstate with-volume\ndestroy with-volume\n",
+			kept:  true,
+		},
+		{
+			name:     "virsh error",
+			domstate: `echo "error: failed to connect to the hypervisor" >&2; exit 1`,
+			err:      "failed to connect",
+			calls:    "domstate with-volume\n",
+			kept:     true,
+		},
+		{
+			name:     "no domain",
+			domstate: `echo "error: failed to get domain 'node-1'" >&2; exit 1`,
+			calls:    "domstate with-volume\n",
+		},
+	} {
+		dir, err := ioutil.TempDir("", "libvirt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		d := NewDriver("node-1", dir).(*Driver)
+		d.Volumes = []volumes.Volume{{Name: "volume-0.qcow2"}}
+		volume := d.ResolveStorePath("volume-0.qcow2")
+		if err := os.MkdirAll(filepath.Dir(volume), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(volume, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		calls, restore := fakeVirsh(t, dir, volume, test.domstate)
+
+		err = d.Remove()
+		restore()
+		if test.err == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: expected the error %q, got %v", test.name, test.err, err)
+		}
+		log, err := ioutil.ReadFile(calls)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(log) != test.calls {
+			t.Errorf("%s: expected the calls:\n%s\ngot:\n%s", test.name, test.calls, log)
+		}
+		if _, err := os.Stat(volume); test.kept && err != nil {
+			t.Errorf("%s: expected the volume to be kept, got %v", test.name, err)
+		} else if !test.kept && !os.IsNotExist(err) {
+			t.Errorf("%s: expected the volume to be deleted, got %v", test.name, err)
+		}
+	}
+}
+
+func TestSetConfigFromFlagsVolumes(t *testing.T) {
+	specs := []string{}
+	for i := 0; i <= maxVolumes; i++ {
+		specs = append(specs, fmt.Sprintf("size=1,mount=/mnt/volume-%d", i))
+	}
+	flags := func(d *Driver, count int) *drivers.CheckDriverOptions {
+		return &drivers.CheckDriverOptions{
+			FlagsValues: map[string]interface{}{"libvirt-image": "ubuntu.qcow2", "libvirt-volume": specs[:count]},
+			CreateFlags: d.GetCreateFlags(),
+		}
+	}
+
+	d := NewDriver("node-1", "/tmp/store").(*Driver)
+	if err := d.SetConfigFromFlags(flags(d, maxVolumes)); err != nil {
+		t.Fatal(err)
+	}
+	if last := d.Volumes[maxVolumes-1].Device; last != "/dev/vdz" {
+		t.Errorf("Expected the last volume at /dev/vdz, got %s", last)
+	}
+	if err := d.SetConfigFromFlags(flags(d, maxVolumes+1)); err == nil {
+		t.Errorf("Expected an error for %d volumes", maxVolumes+1)
+	}
+}
@@ -0,0 +1,210 @@
+package e2e
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// The tools the target cluster is created with.
+const (
+	ProviderKind = "kind"
+	ProviderK3d  = "k3d"
+)
+
+// The node images of the clusters are pinned to Kubernetes 1.21, the last
+// release serving certificates.k8s.io/v1beta1, which the kubelets of the
+// machines request their certificates with.
+const (
+	KindNodeImage = "kindest/node:v1.21.14"
+	K3sImage      = "rancher/k3s:v1.21.14-k3s1"
+)
+
+// PollInterval is how often the nodes are checked while waiting.
+var PollInterval = 5 * time.Second
+
+// Cluster is a local cluster the machines join.
+type Cluster struct {
+	Provider string
+	Name     string
+	// Kubeconfig reaches the API server at HostAddress, so it works on
+	// the machines as well as on the host running the tests.
+	Kubeconfig  string
+	HostAddress string
+	Port        int
+}
+
+var kindConfig = template.Must(template.New("kind").Parse(`kind: Cluster
+apiVersion: kind.x-k8s.io/v1alpha4
+networking:
+  apiServerAddress: "0.0.0.0"
+  apiServerPort: {{.Port}}
+kubeadmConfigPatches:
+- |
+  kind: ClusterConfiguration
+  apiServer:
+    certSANs: ["{{.HostAddress}}"]
+`))
+
+var serverLine = regexp.MustCompile(`(?m)^(\s*server:\s*)\S+$`)
+
+// CreateCluster creates a cluster with the provider, serving its API on all
+// addresses of the host at the port. The machines reach it at the host
+// address, e.g. the gateway of their network. The kubeconfig is written to
+// the directory.
+func CreateCluster(provider, name, hostAddress string, port int, dir string) (*Cluster, error) {
+	c := &Cluster{
+		Provider:    provider,
+		Name:        name,
+		Kubeconfig:  filepath.Join(dir, name+".kubeconfig"),
+		HostAddress: hostAddress,
+		Port:        port,
+	}
+
+	var kubeconfig string
+	switch provider {
+	case ProviderKind:
+		config := filepath.Join(dir, name+"-kind.yaml")
+		if err := ioutil.WriteFile(config, c.kindConfig(), 0600); err != nil {
+			return nil, err
+		}
+		if _, err := run("kind", "create", "cluster", "--name", name, "--image", KindNodeImage, "--config", config, "--wait", "5m"); err != nil {
+			return nil, err
+		}
+		out, err := run("kind", "get", "kubeconfig", "--name", name)
+		if err != nil {
+			return nil, err
+		}
+		kubeconfig = out
+	case ProviderK3d:
+		if _, err := run("k3d", "cluster", "create", name,
+			"--image", K3sImage,
+			"--api-port", "0.0.0.0:"+strconv.Itoa(port),
+			"--k3s-arg", "--tls-san="+hostAddress+"@server:0",
+			"--wait"); err != nil {
+			return nil, err
+		}
+		out, err := run("k3d", "kubeconfig", "get", name)
+		if err != nil {
+			return nil, err
+		}
+		kubeconfig = out
+	default:
+		return nil, fmt.Errorf("Unknown cluster provider %q, expected %s or %s", provider, ProviderKind, ProviderK3d)
+	}
+
+	if err := ioutil.WriteFile(c.Kubeconfig, []byte(c.rewriteServer(kubeconfig)), 0600); err != nil {
+		c.Delete()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Cluster) kindConfig() []byte {
+	var buf bytes.Buffer
+	kindConfig.Execute(&buf, c)
+	return buf.Bytes()
+}
+
+// rewriteServer points the kubeconfig to the API server at the host
+// address instead of the local one of the provider.
+func (c *Cluster) rewriteServer(kubeconfig string) string {
+	return serverLine.ReplaceAllString(kubeconfig, "${1}"+c.Server())
+}
+
+// Server is the URL of the API server.
+func (c *Cluster) Server() string {
+	return "https://" + c.HostAddress + ":" + strconv.Itoa(c.Port)
+}
+
+// Delete deletes the cluster.
+func (c *Cluster) Delete() error {
+	var err error
+	if c.Provider == ProviderK3d {
+		_, err = run("k3d", "cluster", "delete", c.Name)
+	} else {
+		_, err = run("kind", "delete", "cluster", "--name", c.Name)
+	}
+	return err
+}
+
+// Kubectl runs kubectl against the cluster.
+func (c *Cluster) Kubectl(args ...string) (string, error) {
+	return run("kubectl", append([]string{"--kubeconfig", c.Kubeconfig}, args...)...)
+}
+
+// NodeReady returns whether the node exists and is ready.
+func (c *Cluster) NodeReady(node string) (bool, error) {
+	out, err := c.Kubectl("get", "nodes", "--ignore-not-found", "-o", `jsonpath={range .items[?(@.metadata.name=="`+node+`")]}{.status.conditions[?(@.type=="Ready")].status}{end}`)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(out) == "True", nil
+}
+
+// NodeExists returns whether the node is registered.
+func (c *Cluster) NodeExists(node string) (bool, error) {
+	out, err := c.Kubectl("get", "node", node, "--ignore-not-found", "-o", "name")
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(out) != "", nil
+}
+
+// KubeletVersion returns the kubelet version the node reports.
+func (c *Cluster) KubeletVersion(node string) (string, error) {
+	out, err := c.Kubectl("get", "node", node, "-o", "jsonpath={.status.nodeInfo.kubeletVersion}")
+	return strings.TrimSpace(out), err
+}
+
+// WaitNodeReady waits until the node is ready.
+func (c *Cluster) WaitNodeReady(node string, timeout time.Duration) error {
+	return poll(timeout, fmt.Sprintf("node %s to be ready", node), func() (bool, error) {
+		return c.NodeReady(node)
+	})
+}
+
+// WaitNodeGone waits until the node is deregistered.
+func (c *Cluster) WaitNodeGone(node string, timeout time.Duration) error {
+	return poll(timeout, fmt.Sprintf("node %s to be removed", node), func() (bool, error) {
+		exists, err := c.NodeExists(node)
+		return !exists, err
+	})
+}
+
+// poll calls the condition until it holds or the timeout passed. Errors
+// of the condition are retried, the last one is returned on timeout.
+func poll(timeout time.Duration, what string, condition func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := condition()
+		if ok && err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("Timed out after %s waiting for %s: %v", timeout, what, err)
+			}
+			return fmt.Errorf("Timed out after %s waiting for %s", timeout, what)
+		}
+		time.Sleep(PollInterval)
+	}
+}
+
+func run(name string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), fmt.Errorf("Failed to run %s %s: %v\n%s", name, strings.Join(args, " "), err, stderr.String())
+	}
+	return stdout.String(), nil
+}
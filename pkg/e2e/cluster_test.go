@@ -0,0 +1,50 @@
+package e2e
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRewriteServer(t *testing.T) {
+	c := &Cluster{HostAddress: "192.168.122.1", Port: 6443}
+	kubeconfig := `apiVersion: v1
+clusters:
+- cluster:
+    certificate-authority-data: LS0t
+    server: https://0.0.0.0:6443
+  name: kind-e2e
+`
+	expected := strings.Replace(kubeconfig, "https://0.0.0.0:6443", "https://192.168.122.1:6443", 1)
+	if rewritten := c.rewriteServer(kubeconfig); rewritten != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, rewritten)
+	}
+
+	if config := string(c.kindConfig()); !strings.Contains(config, "apiServerPort: 6443") || !strings.Contains(config, `certSANs: ["192.168.122.1"]`) {
+		t.Errorf("Expected the port and the host address in the kind config, got\n%s", config)
+	}
+}
+
+func TestPoll(t *testing.T) {
+	defer func(interval time.Duration) { PollInterval = interval }(PollInterval)
+	PollInterval = time.Millisecond
+
+	calls := 0
+	if err := poll(time.Second, "the third call", func() (bool, error) {
+		calls++
+		if calls == 1 {
+			return false, errors.New("connection refused")
+		}
+		return calls == 3, nil
+	}); err != nil || calls != 3 {
+		t.Errorf("Expected to succeed on the third call, got %d calls and %v", calls, err)
+	}
+
+	err := poll(10*time.Millisecond, "node-1 to be ready", func() (bool, error) {
+		return false, errors.New("connection refused")
+	})
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("Expected a timeout with the last error, got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+// Package e2e runs kube-machine against a local kind or k3d cluster, so
+// drivers are tested through the whole lifecycle of their machines.
+package e2e
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// KubeMachine runs a kube-machine binary with its own store against the
+// cluster of the kubeconfig.
+type KubeMachine struct {
+	Binary      string
+	StoragePath string
+	Kubeconfig  string
+	// Env is added to the environment of kube-machine.
+	Env []string
+}
+
+// Run runs kube-machine with the arguments and returns its output.
+func (k *KubeMachine) Run(args ...string) (string, error) {
+	args = append([]string{"--storage-path", k.StoragePath, "--kubeconfig", k.Kubeconfig}, args...)
+	var out bytes.Buffer
+	cmd := exec.Command(k.Binary, args...)
+	cmd.Env = append(os.Environ(), k.Env...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return out.String(), fmt.Errorf("Failed to run kube-machine %s: %v\n%s", strings.Join(args, " "), err, out.String())
+	}
+	return out.String(), nil
+}
+
+// Machine is a machine the lifecycle is tested with.
+type Machine struct {
+	Name   string
+	Driver string
+	// Flags are the create flags of the driver, e.g. the image or the SSH
+	// host of the generic driver.
+	Flags []string
+	// Timeout limits each step of the lifecycle waiting for the node.
+	Timeout time.Duration
+}
+
+// Lifecycle creates the machine, waits for its node to be ready, upgrades
+// it, waits for the node again and removes the machine. The machine is
+// removed if a step fails.
+func Lifecycle(t *testing.T, c *Cluster, k *KubeMachine, m Machine) {
+	timeout := m.Timeout
+	if timeout == 0 {
+		timeout = 15 * time.Minute
+	}
+	removed := false
+	defer func() {
+		if !removed {
+			if _, err := k.Run("rm", "-f", "-y", m.Name); err != nil {
+				t.Logf("Cleaning up %s: %v", m.Name, err)
+			}
+		}
+	}()
+
+	step := func(name string, f func() error) {
+		t.Logf("%s: %s", m.Name, name)
+		start := time.Now()
+		if err := f(); err != nil {
+			t.Fatalf("%s: %s failed after %s: %v", m.Name, name, time.Since(start), err)
+		}
+	}
+
+	step("create", func() error {
+		args := append([]string{"create", "--driver", m.Driver}, m.Flags...)
+		_, err := k.Run(append(args, m.Name)...)
+		return err
+	})
+	step("wait for the node to be ready", func() error {
+		return c.WaitNodeReady(m.Name, timeout)
+	})
+	before, _ := c.KubeletVersion(m.Name)
+	step("upgrade", func() error {
+		_, err := k.Run("upgrade", m.Name)
+		return err
+	})
+	step("wait for the upgraded node to be ready", func() error {
+		return c.WaitNodeReady(m.Name, timeout)
+	})
+	after, _ := c.KubeletVersion(m.Name)
+	t.Logf("%s: kubelet %s upgraded to %s", m.Name, before, after)
+	step("remove", func() error {
+		_, err := k.Run("rm", "-y", m.Name)
+		removed = err == nil
+		return err
+	})
+	step("wait for the node to be removed", func() error {
+		return c.WaitNodeGone(m.Name, timeout)
+	})
+}
@@ -0,0 +1,281 @@
+package firewall
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kubermatic/kube-machine/pkg/initsystem"
+)
+
+const (
+	BackendUFW       = "ufw"
+	BackendFirewalld = "firewalld"
+	BackendNftables  = "nftables"
+
+	KubeletPort = 10250
+
+	// ProtocolIPIP is the protocol of the IP in IP tunnels of Calico, its
+	// rules accept the whole protocol, which has no ports.
+	ProtocolIPIP = "ipip"
+	// ipipProtocolNumber is the IP protocol number of IPIP, /etc/protocols
+	// names it ipencap.
+	ipipProtocolNumber = 4
+
+	// zone is the firewalld zone of the network interfaces of the node.
+	zone = "kube-machine"
+
+	// NftablesConfigPath is the ruleset loaded by the nftables service.
+	NftablesConfigPath = "/etc/nftables.conf"
+)
+
+// Backends are the supported firewalls, no firewall is configured if the
+// backend is empty.
+var Backends = []string{BackendUFW, BackendFirewalld, BackendNftables}
+
+// NodePorts is the default service node port range of the API server.
+var NodePorts = []Port{{From: 30000, To: 32767, Protocol: "tcp"}, {From: 30000, To: 32767, Protocol: "udp"}}
+
+var cniPorts = map[string][]Port{
+	"flannel": {{From: 8472, To: 8472, Protocol: "udp"}},
+	"canal":   {{From: 8472, To: 8472, Protocol: "udp"}},
+	"calico":  {{From: 179, To: 179, Protocol: "tcp"}, {From: 4789, To: 4789, Protocol: "udp"}},
+	"weave":   {{From: 6783, To: 6783, Protocol: "tcp"}, {From: 6783, To: 6784, Protocol: "udp"}},
+	"cilium":  {{From: 4240, To: 4240, Protocol: "tcp"}, {From: 8472, To: 8472, Protocol: "udp"}},
+}
+
+// cniProtocols are the protocols without ports the CNI tunnels between the
+// nodes. The cloud firewalls only filter ports, so they are only accepted by
+// the firewall of the node.
+var cniProtocols = map[string][]Port{
+	"calico": {{Protocol: ProtocolIPIP}},
+}
+
+// Port is a port range opened for a protocol.
+type Port struct {
+	From     int
+	To       int
+	Protocol string
+}
+
+func (p Port) String() string {
+	if p.Protocol == ProtocolIPIP {
+		return p.Protocol
+	}
+	if p.From == p.To {
+		return fmt.Sprintf("%d/%s", p.From, p.Protocol)
+	}
+	return fmt.Sprintf("%d-%d/%s", p.From, p.To, p.Protocol)
+}
+
+// ParsePort parses a port or port range with an optional protocol, e.g.
+// "8080", "53/udp" or "9000-9100/tcp". The protocol defaults to tcp.
+func ParsePort(s string) (Port, error) {
+	p := Port{Protocol: "tcp"}
+	ports := s
+	if i := strings.Index(s, "/"); i >= 0 {
+		ports, p.Protocol = s[:i], s[i+1:]
+	}
+	if p.Protocol != "tcp" && p.Protocol != "udp" {
+		return p, fmt.Errorf("Invalid protocol in port %q, expected tcp or udp", s)
+	}
+
+	from, to := ports, ports
+	if i := strings.Index(ports, "-"); i >= 0 {
+		from, to = ports[:i], ports[i+1:]
+	}
+	var err error
+	if p.From, err = parsePortNumber(from); err != nil {
+		return p, fmt.Errorf("Invalid port %q: %v", s, err)
+	}
+	if p.To, err = parsePortNumber(to); err != nil {
+		return p, fmt.Errorf("Invalid port %q: %v", s, err)
+	}
+	if p.From > p.To {
+		return p, fmt.Errorf("Invalid port range %q", s)
+	}
+	return p, nil
+}
+
+func parsePortNumber(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 || n > 65535 {
+		return 0, fmt.Errorf("%d is out of range", n)
+	}
+	return n, nil
+}
+
+// ValidateBackend checks that the backend is supported or empty.
+func ValidateBackend(backend string) error {
+	if backend == "" {
+		return nil
+	}
+	for _, b := range Backends {
+		if b == backend {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unsupported firewall %q, expected one of %s", backend, strings.Join(Backends, ", "))
+}
+
+// CNIs returns the network plugins with known ports.
+func CNIs() []string {
+	var names []string
+	for name := range cniPorts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CNIPorts returns the ports the CNI uses between the nodes, none for an
+// empty CNI.
+func CNIPorts(cni string) ([]Port, error) {
+	if cni == "" {
+		return nil, nil
+	}
+	p, ok := cniPorts[cni]
+	if !ok {
+		return nil, fmt.Errorf("Unknown CNI %q, expected one of %s", cni, strings.Join(CNIs(), ", "))
+	}
+	return p, nil
+}
+
+// Ports returns the ports opened on a node: SSH, the engine, the kubelet, the
+// node ports, the ports and tunnel protocols of the CNI and the extra ports.
+func Ports(sshPort, enginePort int, cni string, extra []string) ([]Port, error) {
+	ports := []Port{
+		{From: sshPort, To: sshPort, Protocol: "tcp"},
+		{From: enginePort, To: enginePort, Protocol: "tcp"},
+		{From: KubeletPort, To: KubeletPort, Protocol: "tcp"},
+	}
+	ports = append(ports, NodePorts...)
+
+	p, err := CNIPorts(cni)
+	if err != nil {
+		return nil, err
+	}
+	ports = append(ports, p...)
+	ports = append(ports, cniProtocols[cni]...)
+
+	for _, s := range extra {
+		p, err := ParsePort(s)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, p)
+	}
+	return ports, nil
+}
+
+// Script returns the commands replacing the firewall rules of the node with
+// rules only accepting the ports, the firewall services are enabled with the
+// init system of the node.
+func Script(backend string, ports []Port, service initsystem.InitSystem) (string, error) {
+	switch backend {
+	case BackendUFW:
+		return ufwScript(ports), nil
+	case BackendFirewalld:
+		return firewalldScript(ports, service), nil
+	case BackendNftables:
+		return nftablesScript(ports, service), nil
+	}
+	return "", ValidateBackend(backend)
+}
+
+func ufwScript(ports []Port) string {
+	cmds := []string{
+		"sudo ufw --force reset",
+		"sudo ufw default deny incoming",
+		"sudo ufw default allow outgoing",
+		// Pod traffic is routed through the node.
+		"sudo ufw default allow routed",
+	}
+	for _, p := range ports {
+		if p.Protocol == ProtocolIPIP {
+			// ufw has no rules for IPIP, its rules are added to the
+			// before rules restored by the reset.
+			cmds = append(cmds, fmt.Sprintf("sudo sed -i '/^COMMIT/i -A ufw-before-input -p %d -j ACCEPT' /etc/ufw/before.rules", ipipProtocolNumber))
+		} else if p.From == p.To {
+			cmds = append(cmds, fmt.Sprintf("sudo ufw allow %d/%s", p.From, p.Protocol))
+		} else {
+			cmds = append(cmds, fmt.Sprintf("sudo ufw allow %d:%d/%s", p.From, p.To, p.Protocol))
+		}
+	}
+	cmds = append(cmds, "sudo ufw --force enable")
+	return strings.Join(cmds, " && ")
+}
+
+// firewalldScript binds the network interfaces of the node to a zone only
+// accepting the ports. The interfaces the CNI adds later fall into the
+// default zone, which is trusted since it carries the pod traffic.
+func firewalldScript(ports []Port, service initsystem.InitSystem) string {
+	cmds := []string{
+		service.EnableCommand("firewalld"),
+		service.StartCommand("firewalld"),
+		// A fresh zone rejects everything which isn't added explicitly.
+		"(sudo firewall-cmd --permanent --delete-zone=" + zone + " || true)",
+		"sudo firewall-cmd --permanent --new-zone=" + zone,
+		"sudo firewall-cmd --permanent --zone=" + zone + " --set-target=DROP",
+		"sudo firewall-cmd --permanent --zone=" + zone + " --add-icmp-block-inversion",
+		"sudo firewall-cmd --permanent --zone=" + zone + " --add-masquerade",
+	}
+	for _, p := range ports {
+		if p.Protocol == ProtocolIPIP {
+			cmds = append(cmds, fmt.Sprintf("sudo firewall-cmd --permanent --zone=%s --add-protocol=%d", zone, ipipProtocolNumber))
+			continue
+		}
+		cmds = append(cmds, "sudo firewall-cmd --permanent --zone="+zone+" --add-port="+p.String())
+	}
+	cmds = append(cmds,
+		"sudo firewall-cmd --reload",
+		// The interfaces of the node are bound to the zone explicitly, the
+		// default zone doesn't apply to interfaces NetworkManager already
+		// bound to another zone like public.
+		"for i in $(ls /sys/class/net); do if [ -e /sys/class/net/$i/device ]; then sudo firewall-cmd --permanent --zone="+zone+" --change-interface=$i && sudo firewall-cmd --zone="+zone+" --change-interface=$i || exit 1; fi; done",
+		"sudo firewall-cmd --set-default-zone=trusted",
+	)
+	return strings.Join(cmds, " && ")
+}
+
+func nftablesScript(ports []Port, service initsystem.InitSystem) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "sudo tee %s >/dev/null <<'EOF'\n", NftablesConfigPath)
+	b.WriteString(NftablesRuleset(ports))
+	b.WriteString("EOF\n")
+	fmt.Fprintf(&b, "sudo nft -f %s && %s", NftablesConfigPath, service.EnableCommand("nftables"))
+	return b.String()
+}
+
+// NftablesRuleset returns a table dropping incoming traffic except to the
+// ports. The tables of the engine and kube-proxy are kept, the table is
+// declared before deleting it to replace it atomically.
+func NftablesRuleset(ports []Port) string {
+	var b bytes.Buffer
+	b.WriteString("table inet kube-machine\n")
+	b.WriteString("delete table inet kube-machine\n\n")
+	b.WriteString("table inet kube-machine {\n")
+	b.WriteString("\tchain input {\n")
+	b.WriteString("\t\ttype filter hook input priority 0; policy drop;\n")
+	b.WriteString("\t\tct state established,related accept\n")
+	b.WriteString("\t\tiif lo accept\n")
+	b.WriteString("\t\tip protocol icmp accept\n")
+	b.WriteString("\t\tip6 nexthdr ipv6-icmp accept\n")
+	for _, p := range ports {
+		if p.Protocol == ProtocolIPIP {
+			fmt.Fprintf(&b, "\t\tip protocol %d accept\n", ipipProtocolNumber)
+		} else if p.From == p.To {
+			fmt.Fprintf(&b, "\t\t%s dport %d accept\n", p.Protocol, p.From)
+		} else {
+			fmt.Fprintf(&b, "\t\t%s dport %d-%d accept\n", p.Protocol, p.From, p.To)
+		}
+	}
+	b.WriteString("\t}\n")
+	b.WriteString("}\n")
+	return b.String()
+}
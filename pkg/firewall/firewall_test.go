@@ -0,0 +1,124 @@
+package firewall
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kubermatic/kube-machine/pkg/initsystem"
+)
+
+func TestParsePort(t *testing.T) {
+	tests := []struct {
+		port     string
+		expected Port
+	}{
+		{"8080", Port{8080, 8080, "tcp"}},
+		{"53/udp", Port{53, 53, "udp"}},
+		{"9000-9100/tcp", Port{9000, 9100, "tcp"}},
+	}
+	for _, test := range tests {
+		p, err := ParsePort(test.port)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.port, err)
+			continue
+		}
+		if p != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.port, test.expected, p)
+		}
+	}
+
+	for _, port := range []string{"", "http", "0", "70000", "80/sctp", "100-90"} {
+		if _, err := ParsePort(port); err == nil {
+			t.Errorf("%s: expected an error", port)
+		}
+	}
+}
+
+func TestPorts(t *testing.T) {
+	ports, err := Ports(22, 2376, "flannel", []string{"80", "443"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s []string
+	for _, p := range ports {
+		s = append(s, p.String())
+	}
+	expected := []string{"22/tcp", "2376/tcp", "10250/tcp", "30000-32767/tcp", "30000-32767/udp", "8472/udp", "80/tcp", "443/tcp"}
+	if !reflect.DeepEqual(s, expected) {
+		t.Errorf("expected %v, got %v", expected, s)
+	}
+
+	ports, err = Ports(22, 2376, "calico", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := ports[len(ports)-1]; last.String() != "ipip" {
+		t.Errorf("expected the IPIP tunnels of calico to be accepted, got %v", ports)
+	}
+
+	if _, err := Ports(22, 2376, "unknown", nil); err == nil {
+		t.Error("expected an error for an unknown CNI")
+	}
+}
+
+func TestScript(t *testing.T) {
+	ports := []Port{{22, 22, "tcp"}, {30000, 32767, "udp"}}
+
+	service, err := initsystem.Get(initsystem.OpenRC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ufw, err := Script(BackendUFW, ports, service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"ufw default deny incoming", "ufw allow 22/tcp", "ufw allow 30000:32767/udp"} {
+		if !strings.Contains(ufw, s) {
+			t.Errorf("expected %q in %q", s, ufw)
+		}
+	}
+
+	firewalld, err := Script(BackendFirewalld, ports, service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(firewalld, "--add-port=30000-32767/udp") || !strings.HasPrefix(firewalld, "sudo rc-update add firewalld default && sudo rc-service firewalld start") {
+		t.Errorf("expected the node ports in %q started with OpenRC", firewalld)
+	}
+	for _, s := range []string{"--zone=kube-machine --change-interface=$i", "--set-default-zone=trusted"} {
+		if !strings.Contains(firewalld, s) {
+			t.Errorf("expected %q in %q", s, firewalld)
+		}
+	}
+
+	nftables, err := Script(BackendNftables, ports, service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"policy drop;", "tcp dport 22 accept", "udp dport 30000-32767 accept"} {
+		if !strings.Contains(nftables, s) {
+			t.Errorf("expected %q in %q", s, nftables)
+		}
+	}
+
+	ipip := []Port{{Protocol: ProtocolIPIP}}
+	for backend, rule := range map[string]string{
+		BackendUFW:       "-A ufw-before-input -p 4 -j ACCEPT",
+		BackendFirewalld: "--zone=kube-machine --add-protocol=4",
+		BackendNftables:  "ip protocol 4 accept",
+	} {
+		script, err := Script(backend, ipip, service)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(script, rule) {
+			t.Errorf("%s: expected %q in %q", backend, rule, script)
+		}
+	}
+
+	if _, err := Script("iptables", ports, service); err == nil {
+		t.Error("expected an error for an unsupported firewall")
+	}
+}
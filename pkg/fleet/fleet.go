@@ -0,0 +1,64 @@
+package fleet
+
+import (
+	"time"
+)
+
+// DefaultParallel is the default number of machines commands run on at a
+// time, it keeps the SSH connections and provider API calls bounded.
+const DefaultParallel = 10
+
+// Result is the outcome of a command on a machine.
+type Result struct {
+	Name     string
+	Output   string
+	Err      error
+	Duration time.Duration
+}
+
+// Run runs f for every machine with at most parallel calls at a time. done
+// is called for every result as it completes, the results are returned in
+// the order of the names.
+func Run(names []string, parallel int, f func(name string) (string, error), done func(Result)) []Result {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	type indexed struct {
+		i int
+		r Result
+	}
+	sem := make(chan struct{}, parallel)
+	resultChan := make(chan indexed)
+	for i, name := range names {
+		go func(i int, name string) {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			start := time.Now()
+			out, err := f(name)
+			resultChan <- indexed{i, Result{Name: name, Output: out, Err: err, Duration: time.Since(start)}}
+		}(i, name)
+	}
+
+	results := make([]Result, len(names))
+	for range names {
+		r := <-resultChan
+		results[r.i] = r.r
+		if done != nil {
+			done(r.r)
+		}
+	}
+	return results
+}
+
+// Failed returns the names of the machines the command failed on.
+func Failed(results []Result) []string {
+	var failed []string
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, r.Name)
+		}
+	}
+	return failed
+}
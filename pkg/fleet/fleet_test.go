@@ -0,0 +1,56 @@
+package fleet
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		running int
+		max     int
+	)
+	names := []string{"node-1", "node-2", "node-3", "node-4", "node-5"}
+	var done []string
+	results := Run(names, 2, func(name string) (string, error) {
+		mu.Lock()
+		running++
+		if running > max {
+			max = running
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+
+		if name == "node-3" {
+			return "", errors.New("exit status 1")
+		}
+		return "up " + name, nil
+	}, func(r Result) {
+		done = append(done, r.Name)
+	})
+
+	if max > 2 {
+		t.Errorf("expected at most 2 parallel calls, got %d", max)
+	}
+	if len(done) != len(names) {
+		t.Errorf("expected all results to be reported, got %v", done)
+	}
+	for i, r := range results {
+		if r.Name != names[i] {
+			t.Errorf("expected result %d for %s, got %s", i, names[i], r.Name)
+		}
+	}
+	if results[0].Output != "up node-1" {
+		t.Errorf("unexpected output %q", results[0].Output)
+	}
+	if failed := Failed(results); !reflect.DeepEqual(failed, []string{"node-3"}) {
+		t.Errorf("expected node-3 to fail, got %v", failed)
+	}
+}
@@ -0,0 +1,119 @@
+package hardening
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/kubermatic/kube-machine/pkg/nodepaths"
+	"github.com/kubermatic/kube-machine/pkg/remote"
+)
+
+// Check is a recommendation of the CIS benchmark tested on a node, the
+// test is a shell condition using the functions of checkFunctions.
+type Check struct {
+	ID    string
+	Title string
+	test  string
+}
+
+// Checks are the recommendations kube-machine audit cis scores.
+var Checks = []Check{
+	{"4.1.1", "kubelet unit file permissions are 644 or more restrictive", `stricter "$kubelet_unit" 644`},
+	{"4.1.2", "kubelet unit file is owned by root:root", `[ "$(owner "$kubelet_unit")" = root:root ]`},
+	{"4.1.5", "kubelet kubeconfig permissions are 644 or more restrictive", `f=$(kubelet_arg kubeconfig) && [ -n "$f" ] && stricter "$f" 644`},
+	{"4.1.6", "kubelet kubeconfig is owned by root:root", `f=$(kubelet_arg kubeconfig) && [ -n "$f" ] && [ "$(owner "$f")" = root:root ]`},
+	{"4.1.7", "client CA file permissions are 644 or more restrictive", `f=$(kubelet_arg client-ca-file) && [ -n "$f" ] && stricter "$f" 644`},
+	{"4.2.1", "anonymous-auth is false", `[ "$(kubelet_arg anonymous-auth)" = false ]`},
+	{"4.2.2", "authorization-mode is not AlwaysAllow", `m=$(kubelet_arg authorization-mode) && [ -n "$m" ] && [ "$m" != AlwaysAllow ]`},
+	{"4.2.3", "client-ca-file is set", `[ -n "$(kubelet_arg client-ca-file)" ]`},
+	{"4.2.4", "read-only-port is 0", `[ "$(kubelet_arg read-only-port)" = 0 ]`},
+	{"4.2.5", "streaming-connection-idle-timeout is not 0", `t=$(kubelet_arg streaming-connection-idle-timeout) && [ "$t" != 0 ] && [ "$t" != 0s ]`},
+	{"4.2.6", "protect-kernel-defaults is true", `[ "$(kubelet_arg protect-kernel-defaults)" = true ]`},
+	{"4.2.7", "make-iptables-util-chains is true", `[ "$(kubelet_arg make-iptables-util-chains)" != false ]`},
+	{"5.2.8", "sshd refuses root logins with passwords", `r=$(sshd_opt permitrootlogin) && [ "$r" = no -o "$r" = prohibit-password -o "$r" = without-password ]`},
+	{"5.2.10", "sshd refuses password authentication", `[ "$(sshd_opt passwordauthentication)" = no ]`},
+	{"5.2.9", "sshd refuses empty passwords", `[ "$(sshd_opt permitemptypasswords)" = no ]`},
+	{"5.2.6", "sshd X11 forwarding is disabled", `[ "$(sshd_opt x11forwarding)" = no ]`},
+	{"5.2.7", "sshd MaxAuthTries is 4 or less", `n=$(sshd_opt maxauthtries) && [ -n "$n" ] && [ "$n" -le 4 ]`},
+}
+
+// checkFunctions read the flags of the running kubelet, or of its unit at
+// $kubelet_unit if it doesn't run, the effective sshd settings and the
+// modes of files.
+const checkFunctions = `kubelet_args() { a=$(ps -o args= -C kubelet 2>/dev/null | head -n 1); [ -n "$a" ] && echo "$a" || cat "$kubelet_unit" 2>/dev/null; }
+kubelet_arg() { kubelet_args | tr ' \\' '\n\n' | sed -n "s/^--$1=//p" | head -n 1; }
+sshd_opt() { $(command -v sshd || echo /usr/sbin/sshd) -T 2>/dev/null | awk -v k="$1" '$1 == k { print $2; exit }'; }
+mode() { stat -c %a "$1" 2>/dev/null; }
+owner() { stat -c %U:%G "$1" 2>/dev/null; }
+stricter() { m=$(mode "$1") && [ -n "$m" ] && [ $(( 0$m & ~0$2 )) -eq 0 ]; }
+`
+
+// CheckScript returns the shell script running the checks as root on a
+// node with the paths, it prints a line of the ID and PASS or FAIL for each
+// check.
+func CheckScript(paths nodepaths.Paths) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "kubelet_unit=%s\n", remote.Quote(paths.WithDefaults().KubeletUnit))
+	b.WriteString(checkFunctions)
+	for _, c := range Checks {
+		fmt.Fprintf(&b, "if ( %s ) >/dev/null 2>&1; then echo '%s PASS'; else echo '%s FAIL'; fi\n", c.test, c.ID, c.ID)
+	}
+	return b.String()
+}
+
+// Result is the outcome of a check on a node.
+type Result struct {
+	Check
+	Passed bool
+}
+
+// Report are the results of the checks on a node.
+type Report struct {
+	Node    string
+	Results []Result
+}
+
+// ParseResults reads the output of CheckScript, checks missing in the
+// output failed.
+func ParseResults(node, out string) Report {
+	passed := map[string]bool{}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == "PASS" {
+			passed[fields[0]] = true
+		}
+	}
+	r := Report{Node: node}
+	for _, c := range Checks {
+		r.Results = append(r.Results, Result{Check: c, Passed: passed[c.ID]})
+	}
+	return r
+}
+
+// Score returns the percentage of passed checks.
+func (r Report) Score() int {
+	if len(r.Results) == 0 {
+		return 0
+	}
+	passed := 0
+	for _, result := range r.Results {
+		if result.Passed {
+			passed++
+		}
+	}
+	return passed * 100 / len(r.Results)
+}
+
+// Failed returns the failed checks.
+func (r Report) Failed() []Check {
+	var failed []Check
+	for _, result := range r.Results {
+		if !result.Passed {
+			failed = append(failed, result.Check)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,157 @@
+package hardening
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kubermatic/kube-machine/pkg/nodepaths"
+	"github.com/kubermatic/kube-machine/pkg/remote"
+	"github.com/kubermatic/kube-machine/pkg/sshdconfig"
+)
+
+// CIS is the profile aligned with the node section of the CIS Kubernetes
+// benchmark, the only profile so far.
+const CIS = "cis"
+
+// Profiles are the known hardening profiles.
+var Profiles = []string{CIS}
+
+const (
+	// SysctlPath holds the kernel settings the kubelet refuses to change
+	// with --protect-kernel-defaults.
+	SysctlPath = "/etc/sysctl.d/90-kube-machine-hardening.conf"
+
+	beginMarker = "# BEGIN kube-machine hardening"
+	endMarker   = "# END kube-machine hardening"
+)
+
+// Validate checks that the profile is known, empty disables hardening.
+func Validate(profile string) error {
+	if profile == "" {
+		return nil
+	}
+	for _, p := range Profiles {
+		if p == profile {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown hardening profile %q, expected one of %s", profile, strings.Join(Profiles, ", "))
+}
+
+// KubeletFlags returns the kubelet flags of the profile. Anonymous
+// authentication and the client CA are disabled and set by every kubelet
+// unit already.
+func KubeletFlags(profile string) []string {
+	if profile == "" {
+		return nil
+	}
+	return []string{
+		"--read-only-port=0",
+		"--protect-kernel-defaults=true",
+		"--authorization-mode=Webhook",
+		"--authentication-token-webhook=true",
+		"--make-iptables-util-chains=true",
+		"--streaming-connection-idle-timeout=5m",
+	}
+}
+
+// sysctls are the values the kubelet expects with --protect-kernel-defaults.
+var sysctls = map[string]string{
+	"vm.overcommit_memory":      "1",
+	"vm.panic_on_oom":           "0",
+	"kernel.panic":              "10",
+	"kernel.panic_on_oops":      "1",
+	"kernel.keys.root_maxkeys":  "1000000",
+	"kernel.keys.root_maxbytes": "25000000",
+}
+
+// Sysctls returns the content of SysctlPath for the profile, empty without
+// hardening.
+func Sysctls(profile string) string {
+	if profile == "" {
+		return ""
+	}
+	var keys []string
+	for k := range sysctls {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b bytes.Buffer
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s = %s\n", k, sysctls[k])
+	}
+	return b.String()
+}
+
+// SSHDConfig returns the sshd settings of the profile. Root logins stay
+// possible with keys for the drivers connecting as root and if the SSH user
+// is unknown, they are refused for the drivers with an unprivileged SSH
+// user.
+func SSHDConfig(sshUser string) string {
+	permitRoot := "prohibit-password"
+	if sshUser != "" && sshUser != "root" {
+		permitRoot = "no"
+	}
+	return strings.Join([]string{
+		beginMarker,
+		"PermitRootLogin " + permitRoot,
+		"PasswordAuthentication no",
+		"PermitEmptyPasswords no",
+		"ChallengeResponseAuthentication no",
+		"X11Forwarding no",
+		"MaxAuthTries 4",
+		"ClientAliveInterval 300",
+		"ClientAliveCountMax 3",
+		"LoginGraceTime 60",
+		endMarker,
+	}, "\n") + "\n"
+}
+
+type permission struct {
+	path string
+	mode string
+}
+
+// permissions returns the modes of the files of the node the profile
+// restricts, the kubelet unit and kubeconfig are at the node paths of the
+// machine. Missing files are skipped.
+func permissions(paths nodepaths.Paths) []permission {
+	return []permission{
+		{paths.KubeletUnit, "600"},
+		{paths.Kubeconfig, "600"},
+		{"/etc/ssl/etcd/root-ca.crt", "644"},
+		{"/var/lib/kubelet/pki/kubelet.key", "600"},
+		{"/var/lib/kubelet/pki/kubelet.crt", "644"},
+		{sshdconfig.Path, "600"},
+	}
+}
+
+// Script returns the shell script applying the profile on a node over SSH
+// as root: the kernel settings, the permissions of the certificates and
+// configurations and the sshd settings, which precede the settings of the
+// distribution as sshd uses the first value of each. The sshd settings only
+// replace the configuration once sshd accepts them. It is empty without
+// hardening.
+func Script(profile, sshUser string, paths nodepaths.Paths) (string, error) {
+	if profile == "" {
+		return "", nil
+	}
+	writeSysctls, err := remote.WriteFileCommand(SysctlPath, []byte(Sysctls(profile)), 0644)
+	if err != nil {
+		return "", err
+	}
+	commands := []string{
+		writeSysctls,
+		"sysctl -q -p " + SysctlPath,
+	}
+	for _, p := range permissions(paths.WithDefaults()) {
+		commands = append(commands, fmt.Sprintf("(! [ -e %[1]s ] || (chown root:root %[1]s && chmod %[2]s %[1]s))", remote.Quote(p.path), p.mode))
+	}
+	commands = append(commands, sshdconfig.EditScript(func(path string) string {
+		return fmt.Sprintf("sed -i %s %s && { printf '%%s' %s; cat %s; } > %s.new && mv -f %s.new %s",
+			remote.Quote("/^"+beginMarker+"$/,/^"+endMarker+"$/d"), path, remote.Quote(SSHDConfig(sshUser)), path, path, path, path)
+	}))
+	return strings.Join(commands, " && "), nil
+}
@@ -0,0 +1,77 @@
+package hardening
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/kubermatic/kube-machine/pkg/nodepaths"
+)
+
+func TestScript(t *testing.T) {
+	if script, err := Script("", "root", nodepaths.Paths{}); err != nil || script != "" || KubeletFlags("") != nil || Sysctls("") != "" {
+		t.Errorf("Expected no hardening without profile, got %q %v", script, err)
+	}
+	script, err := Script(CIS, "ubuntu", nodepaths.Paths{Kubeconfig: "/etc/kubernetes/kubelet.conf"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{SysctlPath, "chmod 600 '/etc/kubernetes/kubelet.conf'", "chmod 600 '/etc/systemd/system/kubelet.service'", "PermitRootLogin no", "-t -f"} {
+		if !strings.Contains(script, expected) {
+			t.Errorf("Expected %q in the script, got\n%s", expected, script)
+		}
+	}
+	if !strings.Contains(SSHDConfig("root"), "PermitRootLogin prohibit-password\n") {
+		t.Errorf("Expected drivers connecting as root to keep root logins with keys")
+	}
+	if err := Validate("stig"); err == nil {
+		t.Errorf("Expected an unknown profile to fail")
+	}
+}
+
+func TestCheckScript(t *testing.T) {
+	script := CheckScript(nodepaths.Paths{KubeletUnit: "/run/systemd/system/kubelet.service"})
+	if !strings.HasPrefix(script, "kubelet_unit='/run/systemd/system/kubelet.service'\n") {
+		t.Errorf("Expected the checks to read the unit of the machine, got\n%s", script)
+	}
+	if strings.Contains(script, "/etc/systemd/system") {
+		t.Errorf("Expected no hard-coded unit path, got\n%s", script)
+	}
+}
+
+func TestCheckFunctions(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell")
+	}
+	script := checkFunctions + `kubelet_args() { printf '%s\n' '/var/lib/kubelet/kubelet \' '  --read-only-port=0 \' '  --anonymous-auth=false'; }
+echo "$(kubelet_arg read-only-port) $(kubelet_arg anonymous-auth) [$(kubelet_arg authorization-mode)]"
+f=$(mktemp) && chmod 600 "$f" && stricter "$f" 644 && echo stricter
+chmod 664 "$f" && ! stricter "$f" 644 && echo looser
+rm -f "$f"
+`
+	out, err := exec.Command("sh", "-c", script).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if string(out) != "0 false []\nstricter\nlooser\n" {
+		t.Errorf("Unexpected output %q", out)
+	}
+}
+
+func TestParseResults(t *testing.T) {
+	var out []string
+	for i, c := range Checks {
+		if i%2 == 0 {
+			out = append(out, c.ID+" PASS")
+		} else {
+			out = append(out, c.ID+" FAIL")
+		}
+	}
+	r := ParseResults("node-1", strings.Join(out[1:], "\n"))
+	if len(r.Results) != len(Checks) || len(r.Failed()) != len(Checks)/2+1 {
+		t.Errorf("Expected the odd and the missing first check to fail, got %+v", r.Failed())
+	}
+	if score := r.Score(); score != (len(Checks)-len(r.Failed()))*100/len(Checks) {
+		t.Errorf("Unexpected score %d", score)
+	}
+}
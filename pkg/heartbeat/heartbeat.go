@@ -0,0 +1,150 @@
+// Package heartbeat defines the optional heartbeat agent of the nodes. It
+// reports the health of the node to a config map of the cluster with its own
+// credentials, so the fleet stays visible when the kubelet is broken.
+package heartbeat
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubermatic/kube-machine/pkg/initsystem"
+)
+
+const (
+	// Service is the name of the service of the agent.
+	Service = "kube-machine-heartbeat"
+	// UnitPath is where the agent unit is installed on systemd nodes.
+	UnitPath = "/etc/systemd/system/" + Service + ".service"
+	// Dir holds the script and the credentials of the agent.
+	Dir = "/etc/kube-machine/heartbeat"
+
+	DefaultInterval = 30 * time.Second
+
+	// The node is under pressure at this usage of its disks or memory.
+	DiskPressurePercent   = 90
+	MemoryPressurePercent = 90
+
+	// The keys of the config map of the node.
+	TimeKey           = "time"
+	DiskKey           = "diskUsedPercent"
+	MemoryKey         = "memoryUsedPercent"
+	KubeletKey        = "kubelet"
+	RebootRequiredKey = "rebootRequired"
+
+	// The states of the kubelet.
+	KubeletRunning = "running"
+	KubeletStopped = "stopped"
+)
+
+// ConfigMapName returns the name of the config map the agent of the node
+// reports to.
+func ConfigMapName(node string) string {
+	return Service + "-" + node
+}
+
+// Unit runs the agent, it is converted for other init systems.
+const Unit = `[Unit]
+Description=Kube Machine heartbeat agent
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Restart=always
+RestartSec=10
+ExecStart=/bin/sh ` + Dir + `/heartbeat.sh
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// Script returns the agent reporting in the interval. It reads the API
+// server and the config map from the env file of Env and only needs curl.
+// The usage is the one of the fullest of the root and kubelet file systems,
+// a reboot is pending if the package manager asks for one.
+func Script(interval time.Duration) string {
+	return `#!/bin/sh
+set -u
+. ` + Dir + `/env
+url="$SERVER/api/v1/namespaces/$NAMESPACE/configmaps/$CONFIGMAP"
+while true; do
+  disk=$(df -P / /var/lib/kubelet 2>/dev/null | awk 'NR > 1 { sub("%", "", $5); if ($5 + 0 > max) max = $5 + 0 } END { print max + 0 }')
+  memory=$(awk '/^MemTotal:/ { t = $2 } /^MemAvailable:/ { a = $2 } END { if (t > 0) print int(100 - a * 100 / t); else print 0 }' /proc/meminfo)
+  if ` + initsystem.RunningCommand("kubelet") + ` >/dev/null 2>&1; then kubelet=` + KubeletRunning + `; else kubelet=` + KubeletStopped + `; fi
+  reboot=false
+  if [ -f /var/run/reboot-required ] || { command -v needs-restarting >/dev/null && ! needs-restarting -r >/dev/null 2>&1; }; then reboot=true; fi
+  data="\"` + TimeKey + `\": \"$(date -u +%Y-%m-%dT%H:%M:%SZ)\", \"` + DiskKey + `\": \"$disk\", \"` + MemoryKey + `\": \"$memory\", \"` + KubeletKey + `\": \"$kubelet\", \"` + RebootRequiredKey + `\": \"$reboot\""
+  curl -sSf --max-time 10 --cacert ` + Dir + `/ca.crt -H "Authorization: Bearer $(cat ` + Dir + `/token)" \
+    -X PATCH -H "Content-Type: application/merge-patch+json" -d "{\"data\": {$data}}" "$url" >/dev/null ||
+    echo "Failed to report the heartbeat" >&2
+  sleep ` + strconv.Itoa(int(interval/time.Second)) + `
+done
+`
+}
+
+// Env returns the env file of the agent.
+func Env(server, namespace, node string) string {
+	return fmt.Sprintf("SERVER=%q\nNAMESPACE=%q\nCONFIGMAP=%q\n", server, namespace, ConfigMapName(node))
+}
+
+// Health is the last report of the agent of a node.
+type Health struct {
+	Time              time.Time
+	DiskUsedPercent   int
+	MemoryUsedPercent int
+	Kubelet           string
+	RebootRequired    bool
+}
+
+// Parse reads the report from the data of the config map, it returns nil if
+// the agent never reported.
+func Parse(data map[string]string) (*Health, error) {
+	if data[TimeKey] == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, data[TimeKey])
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse the heartbeat time %q: %v", data[TimeKey], err)
+	}
+	h := &Health{Time: t, Kubelet: data[KubeletKey], RebootRequired: data[RebootRequiredKey] == "true"}
+	if h.DiskUsedPercent, err = strconv.Atoi(data[DiskKey]); err != nil {
+		return nil, fmt.Errorf("Failed to parse the disk usage %q: %v", data[DiskKey], err)
+	}
+	if h.MemoryUsedPercent, err = strconv.Atoi(data[MemoryKey]); err != nil {
+		return nil, fmt.Errorf("Failed to parse the memory usage %q: %v", data[MemoryKey], err)
+	}
+	return h, nil
+}
+
+// Stale returns whether the agent missed its reports, the node is down or
+// cut off from the cluster.
+func (h *Health) Stale(now time.Time, interval time.Duration) bool {
+	return now.Sub(h.Time) > 3*interval
+}
+
+// Problems returns the problems the node reported.
+func (h *Health) Problems() []string {
+	var problems []string
+	if h.DiskUsedPercent >= DiskPressurePercent {
+		problems = append(problems, fmt.Sprintf("disk pressure (%d%% used)", h.DiskUsedPercent))
+	}
+	if h.MemoryUsedPercent >= MemoryPressurePercent {
+		problems = append(problems, fmt.Sprintf("memory pressure (%d%% used)", h.MemoryUsedPercent))
+	}
+	if h.Kubelet != KubeletRunning {
+		problems = append(problems, "kubelet "+h.Kubelet)
+	}
+	if h.RebootRequired {
+		problems = append(problems, "reboot required")
+	}
+	return problems
+}
+
+func (h *Health) String() string {
+	status := "healthy"
+	if problems := h.Problems(); len(problems) > 0 {
+		status = strings.Join(problems, ", ")
+	}
+	return fmt.Sprintf("%s, disk %d%%, memory %d%%, kubelet %s, reported %s", status, h.DiskUsedPercent, h.MemoryUsedPercent, h.Kubelet, h.Time.UTC().Format(time.RFC3339))
+}
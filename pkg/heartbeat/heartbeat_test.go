@@ -0,0 +1,63 @@
+package heartbeat
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	h, err := Parse(map[string]string{
+		TimeKey:           "2026-10-16T08:00:00Z",
+		DiskKey:           "93",
+		MemoryKey:         "40",
+		KubeletKey:        KubeletStopped,
+		RebootRequiredKey: "true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Health{
+		Time:              time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC),
+		DiskUsedPercent:   93,
+		MemoryUsedPercent: 40,
+		Kubelet:           KubeletStopped,
+		RebootRequired:    true,
+	}
+	if !reflect.DeepEqual(h, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, h)
+	}
+	if problems := h.Problems(); !reflect.DeepEqual(problems, []string{"disk pressure (93% used)", "kubelet stopped", "reboot required"}) {
+		t.Errorf("Unexpected problems %v", problems)
+	}
+	if !h.Stale(h.Time.Add(4*DefaultInterval), DefaultInterval) || h.Stale(h.Time.Add(DefaultInterval), DefaultInterval) {
+		t.Error("Expected the heartbeat to be stale after missing three reports")
+	}
+
+	if h, err := Parse(nil); h != nil || err != nil {
+		t.Errorf("Expected no heartbeat without reports, got %v, %v", h, err)
+	}
+	if _, err := Parse(map[string]string{TimeKey: "2026-10-16T08:00:00Z", DiskKey: "", MemoryKey: "40"}); err == nil {
+		t.Error("Expected an error for a report without disk usage")
+	}
+}
+
+func TestString(t *testing.T) {
+	h := &Health{Time: time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC), DiskUsedPercent: 20, MemoryUsedPercent: 30, Kubelet: KubeletRunning}
+	if s := h.String(); !strings.HasPrefix(s, "healthy, ") {
+		t.Errorf("Expected a healthy node, got %q", s)
+	}
+}
+
+func TestScript(t *testing.T) {
+	script := Script(time.Minute)
+	for _, s := range []string{". " + Dir + "/env", "sleep 60", "merge-patch+json", "/proc/meminfo"} {
+		if !strings.Contains(script, s) {
+			t.Errorf("Expected %q in the script", s)
+		}
+	}
+	if env := Env("https://10.0.0.1:6443", "kube-system", "worker-1"); !strings.Contains(env, `CONFIGMAP="kube-machine-heartbeat-worker-1"`) {
+		t.Errorf("Unexpected env %q", env)
+	}
+}
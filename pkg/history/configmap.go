@@ -0,0 +1,69 @@
+package history
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	kcorev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// ConfigMapPrefix prefixes the config maps in kube-system holding the
+	// revisions of the pools, one per pool.
+	ConfigMapPrefix = "kube-machine-history-"
+	revisionsKey    = "revisions"
+)
+
+// ConfigMapBackend keeps the revisions of the pools in config maps of the
+// cluster. They are updated with the resource version they were read with,
+// so all kube-machine processes of the cluster share the histories. The
+// clients of nodestore.NewClient refuse the updates in read-only mode.
+type ConfigMapBackend struct {
+	Client kubernetes.Interface
+}
+
+var _ Backend = ConfigMapBackend{}
+
+// Load reads the revisions of the pool, the version is the resource version
+// of its config map.
+func (b ConfigMapBackend) Load(key string) ([]byte, string, error) {
+	name := ConfigMapPrefix + key
+	configMap, err := b.Client.CoreV1().ConfigMaps(metav1.NamespaceSystem).Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to get config map %s: %v", name, err)
+	}
+	return []byte(configMap.Data[revisionsKey]), configMap.ResourceVersion, nil
+}
+
+// Save creates or updates the config map of the pool, it fails with
+// ErrConflict if it changed since it was read.
+func (b ConfigMapBackend) Save(key string, data []byte, version string) error {
+	name := ConfigMapPrefix + key
+	configMap := &kcorev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       metav1.NamespaceSystem,
+			ResourceVersion: version,
+		},
+		Data: map[string]string{revisionsKey: string(data)},
+	}
+	configMaps := b.Client.CoreV1().ConfigMaps(metav1.NamespaceSystem)
+	var err error
+	if version == "" {
+		_, err = configMaps.Create(configMap)
+	} else {
+		_, err = configMaps.Update(configMap)
+	}
+	if errors.IsAlreadyExists(err) || errors.IsConflict(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to save config map %s: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package history
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubermatic/kube-machine/pkg/spec"
+)
+
+// MaxRevisions is the number of revisions kept of each pool.
+var MaxRevisions = 10
+
+// recordAttempts is how often recording a revision is retried after
+// conflicting with another process.
+const recordAttempts = 10
+
+// ErrConflict is returned by backends whose history of a pool was changed
+// since it was loaded.
+var ErrConflict = errors.New("The history was changed concurrently")
+
+// Revision is the spec of the machines of a pool applied at a time.
+type Revision struct {
+	Revision int       `json:"revision"`
+	Time     time.Time `json:"time"`
+	User     string    `json:"user"`
+	// Cause is how the revision was applied, e.g. by a rollback.
+	Cause    string         `json:"cause,omitempty"`
+	Machines []spec.Machine `json:"machines"`
+}
+
+// Key returns the key of the history of the machine, which is its pool or
+// the machine itself if it isn't in a pool.
+func Key(m spec.Machine) string {
+	if m.Pool != "" {
+		return m.Pool
+	}
+	return m.Name
+}
+
+// Group returns the machines of an expanded spec by the key of their
+// history.
+func Group(machines []spec.Machine) map[string][]spec.Machine {
+	groups := map[string][]spec.Machine{}
+	for _, m := range machines {
+		groups[Key(m)] = append(groups[Key(m)], m)
+	}
+	return groups
+}
+
+// Backend keeps the revisions of each pool encoded as JSON. Save fails
+// with ErrConflict if the history changed since the version was loaded, the
+// version of a missing history is empty.
+type Backend interface {
+	Load(key string) (data []byte, version string, err error)
+	Save(key string, data []byte, version string) error
+}
+
+// Store keeps the histories in a backend, kube-machine keeps them in the
+// cluster so all operators and the controller share them.
+type Store struct {
+	Backend Backend
+}
+
+func validKey(key string) error {
+	if key == "" || strings.ContainsAny(key, `/\`) || strings.HasPrefix(key, ".") {
+		return fmt.Errorf("Invalid pool name %q", key)
+	}
+	return nil
+}
+
+// Revisions returns the revisions of the pool, the oldest first. Pools
+// never applied have none.
+func (s Store) Revisions(key string) ([]Revision, error) {
+	revisions, _, err := s.load(key)
+	return revisions, err
+}
+
+func (s Store) load(key string) ([]Revision, string, error) {
+	if err := validKey(key); err != nil {
+		return nil, "", err
+	}
+	data, version, err := s.Backend.Load(key)
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to read the history of %s: %v", key, err)
+	}
+	if len(data) == 0 {
+		return nil, version, nil
+	}
+	var revisions []Revision
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&revisions); err != nil {
+		return nil, "", fmt.Errorf("Failed to parse the history of %s: %v", key, err)
+	}
+	return revisions, version, nil
+}
+
+// Revision returns the revision of the pool.
+func (s Store) Revision(key string, n int) (*Revision, error) {
+	revisions, err := s.Revisions(key)
+	if err != nil {
+		return nil, err
+	}
+	for i := range revisions {
+		if revisions[i].Revision == n {
+			return &revisions[i], nil
+		}
+	}
+	if len(revisions) == 0 {
+		return nil, fmt.Errorf("%s has no revisions, it was never applied", key)
+	}
+	return nil, fmt.Errorf("%s has no revision %d, its revisions are %d to %d", key, n, revisions[0].Revision, revisions[len(revisions)-1].Revision)
+}
+
+// Record adds the machines of the pool as a new revision unless their
+// templates match the latest revision, e.g. as only the pool was scaled.
+// It returns the number of the new or matching revision. It starts over if
+// another process recorded a revision meanwhile.
+func (s Store) Record(key string, machines []spec.Machine, user, cause string, at time.Time) (int, bool, error) {
+	for attempt := 0; ; attempt++ {
+		n, recorded, err := s.record(key, machines, user, cause, at)
+		if err != ErrConflict || attempt == recordAttempts-1 {
+			return n, recorded, err
+		}
+	}
+}
+
+func (s Store) record(key string, machines []spec.Machine, user, cause string, at time.Time) (int, bool, error) {
+	revisions, version, err := s.load(key)
+	if err != nil {
+		return 0, false, err
+	}
+	next := 1
+	if len(revisions) > 0 {
+		latest := revisions[len(revisions)-1]
+		if SameTemplates(latest.Machines, machines) {
+			return latest.Revision, false, nil
+		}
+		next = latest.Revision + 1
+	}
+	revisions = append(revisions, Revision{
+		Revision: next,
+		Time:     at.UTC(),
+		User:     user,
+		Cause:    cause,
+		Machines: machines,
+	})
+	if len(revisions) > MaxRevisions {
+		revisions = revisions[len(revisions)-MaxRevisions:]
+	}
+
+	data, err := json.Marshal(revisions)
+	if err != nil {
+		return 0, false, err
+	}
+	if err := s.Backend.Save(key, data, version); err == ErrConflict {
+		return 0, false, err
+	} else if err != nil {
+		return 0, false, fmt.Errorf("Failed to save the history of %s: %v", key, err)
+	}
+	return next, true, nil
+}
+
+// templates returns the distinct drivers and options of the machines,
+// scaling a pool adds and removes machines of the same ones.
+func templates(machines []spec.Machine) []string {
+	seen := map[string]bool{}
+	var result []string
+	for _, m := range machines {
+		data, _ := json.Marshal(m.Values())
+		if !seen[string(data)] {
+			seen[string(data)] = true
+			result = append(result, string(data))
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// SameTemplates reports whether the machines only differ in their count.
+func SameTemplates(a, b []spec.Machine) bool {
+	ta, tb := templates(a), templates(b)
+	if len(ta) != len(tb) {
+		return false
+	}
+	for i := range ta {
+		if ta[i] != tb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Diff describes the changes of the machines from one revision to another,
+// the values of secrets are hidden.
+func Diff(from, to []spec.Machine) []string {
+	before := map[string]spec.Machine{}
+	for _, m := range from {
+		before[m.Name] = m
+	}
+	after := map[string]spec.Machine{}
+	for _, m := range to {
+		after[m.Name] = m
+	}
+
+	var lines []string
+	for _, m := range from {
+		if _, ok := after[m.Name]; !ok {
+			lines = append(lines, fmt.Sprintf("- %s", m.Name))
+		}
+	}
+	for _, m := range to {
+		previous, ok := before[m.Name]
+		if !ok {
+			lines = append(lines, fmt.Sprintf("+ %s", m.Name))
+			continue
+		}
+		a := previous.Fingerprint()
+		b := m.FingerprintLike(a)
+		for _, k := range spec.ChangedKeys(a, b) {
+			va, vb := a[k], b[k]
+			if spec.Sensitive(va) || spec.Sensitive(vb) {
+				va, vb = "(sensitive)", "(changed)"
+			}
+			lines = append(lines, fmt.Sprintf("~ %s %s: %q -> %q", m.Name, k, va, vb))
+		}
+	}
+	return lines
+}
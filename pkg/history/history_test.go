@@ -0,0 +1,123 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kubermatic/kube-machine/pkg/spec"
+)
+
+func pool(size string, count int) []spec.Machine {
+	var machines []spec.Machine
+	for i := 1; i <= count; i++ {
+		machines = append(machines, spec.Machine{
+			Name:    fmt.Sprintf("web-%d", i),
+			Driver:  "digitalocean",
+			Pool:    "web",
+			Options: map[string]interface{}{"digitalocean-size": size, "digitalocean-access-token": "s3cr3t"},
+		})
+	}
+	return machines
+}
+
+// memoryBackend keeps the histories like a config map, the version changes
+// with every save.
+type memoryBackend struct {
+	data    map[string][]byte
+	version int
+	// racing revisions are recorded by another process on load.
+	racing []Revision
+}
+
+func (b *memoryBackend) Load(key string) ([]byte, string, error) {
+	data, version := b.data[key], fmt.Sprint(b.version)
+	if len(b.racing) > 0 {
+		other, _ := json.Marshal(b.racing[:1])
+		b.racing = b.racing[1:]
+		b.data[key] = other
+		b.version++
+	}
+	return data, version, nil
+}
+
+func (b *memoryBackend) Save(key string, data []byte, version string) error {
+	if version != fmt.Sprint(b.version) {
+		return ErrConflict
+	}
+	b.data[key] = data
+	b.version++
+	return nil
+}
+
+func TestRecord(t *testing.T) {
+	defer func(max int) { MaxRevisions = max }(MaxRevisions)
+	MaxRevisions = 2
+
+	s := Store{Backend: &memoryBackend{data: map[string][]byte{}}}
+	at := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	if n, recorded, err := s.Record("web", pool("2gb", 2), "alice", "", at); err != nil || n != 1 || !recorded {
+		t.Fatalf("Expected revision 1, got %d %v %v", n, recorded, err)
+	}
+	if n, recorded, err := s.Record("web", pool("2gb", 3), "alice", "", at); err != nil || n != 1 || recorded {
+		t.Errorf("Expected scaling to keep revision 1, got %d %v %v", n, recorded, err)
+	}
+	s.Record("web", pool("4gb", 3), "alice", "", at)
+	if n, _, err := s.Record("web", pool("8gb", 3), "bob", "", at); err != nil || n != 3 {
+		t.Errorf("Expected revision 3, got %d %v", n, err)
+	}
+
+	revisions, err := s.Revisions("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].Revision != 2 || revisions[1].User != "bob" {
+		t.Errorf("Expected the last 2 revisions, got %+v", revisions)
+	}
+	if _, err := s.Revision("web", 1); err == nil {
+		t.Errorf("Expected revision 1 to be dropped")
+	}
+	r, err := s.Revision("web", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Machines[0].Options["digitalocean-access-token"] != "s3cr3t" {
+		t.Errorf("Expected the options to be kept for rollbacks, got %v", r.Machines[0].Options)
+	}
+	if _, err := s.Revisions("../web"); err == nil {
+		t.Errorf("Expected an invalid pool name to fail")
+	}
+}
+
+func TestRecordConflict(t *testing.T) {
+	at := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	backend := &memoryBackend{data: map[string][]byte{}, racing: []Revision{{Revision: 4, User: "bob", Machines: pool("2gb", 2)}}}
+	s := Store{Backend: backend}
+	n, recorded, err := s.Record("web", pool("4gb", 2), "alice", "", at)
+	if err != nil || n != 5 || !recorded {
+		t.Fatalf("Expected revision 5 after the concurrent revision, got %d %v %v", n, recorded, err)
+	}
+	revisions, err := s.Revisions("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].User != "bob" || revisions[1].User != "alice" {
+		t.Errorf("Expected the concurrent revision to be kept, got %+v", revisions)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	to := pool("4gb", 2)
+	to[0].Options["digitalocean-access-token"] = "rotated"
+	expected := []string{
+		"- web-3",
+		`~ web-1 digitalocean-access-token: "(sensitive)" -> "(changed)"`,
+		`~ web-1 digitalocean-size: "2gb" -> "4gb"`,
+		`~ web-2 digitalocean-size: "2gb" -> "4gb"`,
+	}
+	if lines := Diff(pool("2gb", 3), to); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected\n%q\ngot\n%q", expected, lines)
+	}
+}
@@ -0,0 +1,133 @@
+package images
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// GoldenPrefix marks image flag values referring to a golden image built by
+// kube-machine image build, e.g. "golden/worker".
+const GoldenPrefix = "golden/"
+
+// GoldenImage is an image snapshotted from a provisioned machine.
+type GoldenImage struct {
+	Name   string `json:"name"`
+	Driver string `json:"driver"`
+	// ID is the image in the format of the image create flag of the driver.
+	ID string `json:"id"`
+	// Region is set if the image can only be used in a single region.
+	Region  string    `json:"region,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// GoldenStore keeps the golden images as JSON files in a directory.
+type GoldenStore struct {
+	Dir string
+}
+
+func NewGoldenStore(baseDir string) *GoldenStore {
+	return &GoldenStore{Dir: filepath.Join(baseDir, "images")}
+}
+
+func (s *GoldenStore) path(name string) string {
+	return filepath.Join(s.Dir, name+".json")
+}
+
+func (s *GoldenStore) Save(img GoldenImage) error {
+	data, err := json.MarshalIndent(img, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return fmt.Errorf("Failed to create the image directory: %v", err)
+	}
+	return ioutil.WriteFile(s.path(img.Name), data, 0600)
+}
+
+func (s *GoldenStore) Load(name string) (GoldenImage, error) {
+	var img GoldenImage
+	data, err := ioutil.ReadFile(s.path(name))
+	if os.IsNotExist(err) {
+		return img, fmt.Errorf("Golden image %q does not exist", name)
+	}
+	if err != nil {
+		return img, err
+	}
+	if err := json.Unmarshal(data, &img); err != nil {
+		return img, fmt.Errorf("Failed to read golden image %q: %v", name, err)
+	}
+	return img, nil
+}
+
+// List returns the golden images sorted by name.
+func (s *GoldenStore) List() ([]GoldenImage, error) {
+	files, err := ioutil.ReadDir(s.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".json") {
+			names = append(names, strings.TrimSuffix(f.Name(), ".json"))
+		}
+	}
+	sort.Strings(names)
+
+	imgs := []GoldenImage{}
+	for _, name := range names {
+		img, err := s.Load(name)
+		if err != nil {
+			return nil, err
+		}
+		imgs = append(imgs, img)
+	}
+	return imgs, nil
+}
+
+func (s *GoldenStore) Remove(name string) error {
+	err := os.Remove(s.path(name))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("Golden image %q does not exist", name)
+	}
+	return err
+}
+
+// ResolveFlags replaces a golden image reference in the driver create flag
+// values by the ID of the image.
+func (s *GoldenStore) ResolveFlags(driver string, values map[string]interface{}) error {
+	flags, ok := DriverFlags[driver]
+	if !ok {
+		return nil
+	}
+	name, _ := values[flags.Image].(string)
+	if !strings.HasPrefix(name, GoldenPrefix) {
+		return nil
+	}
+
+	img, err := s.Load(strings.TrimPrefix(name, GoldenPrefix))
+	if err != nil {
+		return err
+	}
+	if img.Driver != driver {
+		return fmt.Errorf("Golden image %q was built with the %s driver, not %s", img.Name, img.Driver, driver)
+	}
+	if region, _ := values[flags.Region].(string); img.Region != "" && region != img.Region {
+		return fmt.Errorf("Golden image %q is only available in region %q", img.Name, img.Region)
+	}
+
+	log.Infof("Using golden image %s (%s)", img.Name, img.ID)
+	values[flags.Image] = img.ID
+	return nil
+}
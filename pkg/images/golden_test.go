@@ -0,0 +1,88 @@
+package images
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestGoldenStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := NewGoldenStore(dir)
+
+	if imgs, err := s.List(); err != nil || len(imgs) != 0 {
+		t.Fatalf("expected no images, got %v, %v", imgs, err)
+	}
+
+	created := time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, img := range []GoldenImage{
+		{Name: "worker", Driver: "amazonec2", ID: "ami-123", Region: "eu-west-1", Created: created},
+		{Name: "gpu", Driver: "google", ID: "project/global/images/gpu-1", Created: created},
+	} {
+		if err := s.Save(img); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	imgs, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != 2 || imgs[0].Name != "gpu" || imgs[1].ID != "ami-123" || !imgs[1].Created.Equal(created) {
+		t.Errorf("unexpected images %+v", imgs)
+	}
+
+	if err := s.Remove("gpu"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Load("gpu"); err == nil {
+		t.Error("expected an error loading a removed image")
+	}
+}
+
+func TestGoldenStoreResolveFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := NewGoldenStore(dir)
+	if err := s.Save(GoldenImage{Name: "worker", Driver: "amazonec2", ID: "ami-123", Region: "eu-west-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		description string
+		driver      string
+		values      map[string]interface{}
+		image       string
+		err         bool
+	}{
+		{"golden image", "amazonec2", map[string]interface{}{"amazonec2-ami": "golden/worker", "amazonec2-region": "eu-west-1"}, "ami-123", false},
+		{"other image", "amazonec2", map[string]interface{}{"amazonec2-ami": "ami-456"}, "ami-456", false},
+		{"other region", "amazonec2", map[string]interface{}{"amazonec2-ami": "golden/worker", "amazonec2-region": "us-east-1"}, "", true},
+		{"other driver", "google", map[string]interface{}{"google-machine-image": "golden/worker"}, "", true},
+		{"missing image", "amazonec2", map[string]interface{}{"amazonec2-ami": "golden/db"}, "", true},
+	}
+	for _, test := range tests {
+		err := s.ResolveFlags(test.driver, test.values)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.description)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.description, err)
+			continue
+		}
+		if image := test.values[DriverFlags[test.driver].Image]; image != test.image {
+			t.Errorf("%s: expected image %q, got %q", test.description, test.image, image)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package images
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const defaultTTL = 6 * time.Hour
+
+// Image is a portable operating system image name like "ubuntu-22.04" or
+// "flatcar-stable".
+type Image struct {
+	// Distribution is either "ubuntu" or "flatcar".
+	Distribution string
+	// Version is the release for ubuntu and the channel for flatcar.
+	Version string
+}
+
+func (i Image) String() string {
+	return i.Distribution + "-" + i.Version
+}
+
+// Parse returns the portable image for the given name. ok is false if the
+// name is not a portable image name but e.g. a provider specific image ID.
+func Parse(name string) (img Image, ok bool) {
+	parts := strings.SplitN(name, "-", 2)
+	if len(parts) != 2 {
+		return Image{}, false
+	}
+	img = Image{Distribution: parts[0], Version: parts[1]}
+
+	switch img.Distribution {
+	case "ubuntu":
+		return img, ubuntuReleases[img.Version]
+	case "flatcar":
+		switch img.Version {
+		case "stable", "beta", "alpha":
+			return img, true
+		}
+	}
+	return Image{}, false
+}
+
+// Source resolves portable images to image IDs of a single provider.
+//
+// Only the amazonec2 source looks the images up, in the feeds published
+// by Canonical and Flatcar. The others map the images to names the provider
+// resolves to its latest image itself (marketplace URNs with "latest",
+// image families, distribution slugs), so there is nothing to refresh for
+// them. Drivers without a source keep taking provider specific images only.
+type Source interface {
+	Resolve(region string, img Image) (string, error)
+}
+
+type cacheKey struct {
+	driver, region string
+	img            Image
+}
+
+type cacheEntry struct {
+	id      string
+	fetched time.Time
+}
+
+// Catalog resolves portable images to provider specific image IDs. Resolved
+// IDs are cached and refreshed from the provider once they are older than
+// TTL.
+type Catalog struct {
+	TTL time.Duration
+
+	mu      sync.Mutex
+	sources map[string]Source
+	cache   map[cacheKey]cacheEntry
+	now     func() time.Time
+}
+
+func NewCatalog(ttl time.Duration) *Catalog {
+	return &Catalog{
+		TTL:     ttl,
+		sources: map[string]Source{},
+		cache:   map[cacheKey]cacheEntry{},
+		now:     time.Now,
+	}
+}
+
+// Register sets the source used for machines of the given driver.
+func (c *Catalog) Register(driver string, s Source) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sources[driver] = s
+}
+
+// Resolve returns the image ID of the portable image for the driver and
+// region. If refreshing an expired entry fails the stale ID is returned.
+// The catalog isn't locked while the source is queried.
+func (c *Catalog) Resolve(driver, region string, img Image) (string, error) {
+	c.mu.Lock()
+	source, ok := c.sources[driver]
+	key := cacheKey{driver: driver, region: region, img: img}
+	cached, found := c.cache[key]
+	c.mu.Unlock()
+
+	if !ok {
+		return "", fmt.Errorf("No image catalog for driver %q", driver)
+	}
+	if found && c.now().Sub(cached.fetched) < c.TTL {
+		return cached.id, nil
+	}
+
+	id, err := source.Resolve(region, img)
+	if err != nil {
+		if found {
+			log.Warnf("Failed to refresh image %s for %s/%s, using %s: %v", img, driver, region, cached.id, err)
+			return cached.id, nil
+		}
+		return "", fmt.Errorf("Failed to resolve image %s for %s in region %q: %v", img, driver, region, err)
+	}
+
+	c.mu.Lock()
+	c.cache[key] = cacheEntry{id: id, fetched: c.now()}
+	c.mu.Unlock()
+	return id, nil
+}
+
+// Flags are the driver create flags holding the image and the region.
+type Flags struct {
+	Image  string
+	Region string
+}
+
+// DriverFlags lists the drivers supported by the default catalog.
+var DriverFlags = map[string]Flags{
+	"amazonec2":    {Image: "amazonec2-ami", Region: "amazonec2-region"},
+	"azure":        {Image: "azure-image", Region: "azure-location"},
+	"digitalocean": {Image: "digitalocean-image", Region: "digitalocean-region"},
+	"google":       {Image: "google-machine-image", Region: "google-zone"},
+}
+
+// ResolveFlags replaces a portable image name in the driver create flag
+// values by the ID of the image in the configured region.
+func (c *Catalog) ResolveFlags(driver string, values map[string]interface{}) error {
+	flags, ok := DriverFlags[driver]
+	if !ok {
+		return nil
+	}
+	name, _ := values[flags.Image].(string)
+	img, ok := Parse(name)
+	if !ok {
+		return nil
+	}
+	region, _ := values[flags.Region].(string)
+
+	id, err := c.Resolve(driver, region, img)
+	if err != nil {
+		return err
+	}
+	log.Infof("Resolved image %s to %s", img, id)
+	values[flags.Image] = id
+	return nil
+}
+
+// Default is the catalog with the sources of all supported drivers.
+var Default = NewCatalog(defaultTTL)
+
+func init() {
+	Default.Register("amazonec2", NewAmazonSource())
+	Default.Register("azure", azureSource{})
+	Default.Register("digitalocean", digitaloceanSource{})
+	Default.Register("google", googleSource{})
+}
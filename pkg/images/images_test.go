@@ -0,0 +1,169 @@
+package images
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		img  Image
+		ok   bool
+	}{
+		{"ubuntu-22.04", Image{"ubuntu", "22.04"}, true},
+		{"flatcar-stable", Image{"flatcar", "stable"}, true},
+		{"ubuntu-13.10", Image{}, false},
+		{"flatcar-lts", Image{}, false},
+		{"ami-0123456789", Image{}, false},
+		{"ubuntu-16-04-x64", Image{}, false},
+	}
+	for _, test := range tests {
+		img, ok := Parse(test.name)
+		if ok != test.ok || (ok && img != test.img) {
+			t.Errorf("Parse(%q) = %v, %v; want %v, %v", test.name, img, ok, test.img, test.ok)
+		}
+	}
+}
+
+type fakeSource struct {
+	calls int
+	err   error
+}
+
+func (f *fakeSource) Resolve(region string, img Image) (string, error) {
+	f.calls++
+	if f.err != nil {
+		return "", f.err
+	}
+	return fmt.Sprintf("%s-%s-%d", region, img, f.calls), nil
+}
+
+func TestCatalogResolve(t *testing.T) {
+	now := time.Now()
+	source := &fakeSource{}
+	c := NewCatalog(time.Hour)
+	c.now = func() time.Time { return now }
+	c.Register("fake", source)
+	img := Image{"ubuntu", "22.04"}
+
+	if _, err := c.Resolve("other", "r1", img); err == nil {
+		t.Error("expected an error for a driver without source")
+	}
+
+	id, err := c.Resolve("fake", "r1", img)
+	if err != nil || id != "r1-ubuntu-22.04-1" {
+		t.Fatalf("got %q, %v", id, err)
+	}
+	if id, _ = c.Resolve("fake", "r1", img); id != "r1-ubuntu-22.04-1" {
+		t.Errorf("expected cached id, got %q", id)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if id, _ = c.Resolve("fake", "r1", img); id != "r1-ubuntu-22.04-2" {
+		t.Errorf("expected refreshed id, got %q", id)
+	}
+
+	now = now.Add(2 * time.Hour)
+	source.err = errors.New("unavailable")
+	if id, err = c.Resolve("fake", "r1", img); err != nil || id != "r1-ubuntu-22.04-2" {
+		t.Errorf("expected stale id on refresh failure, got %q, %v", id, err)
+	}
+	if _, err = c.Resolve("fake", "r2", img); err == nil {
+		t.Error("expected an error for an uncached image")
+	}
+}
+
+type blockingSource struct {
+	started, release chan struct{}
+}
+
+func (b blockingSource) Resolve(region string, img Image) (string, error) {
+	close(b.started)
+	<-b.release
+	return "slow", nil
+}
+
+func TestCatalogResolveUnlocked(t *testing.T) {
+	slow := blockingSource{started: make(chan struct{}), release: make(chan struct{})}
+	c := NewCatalog(time.Hour)
+	c.Register("slow", slow)
+	c.Register("fake", &fakeSource{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if id, err := c.Resolve("slow", "r1", Image{"ubuntu", "22.04"}); err != nil || id != "slow" {
+			t.Errorf("got %q, %v", id, err)
+		}
+	}()
+	<-slow.started
+
+	// Another image resolves while the slow source is queried.
+	if _, err := c.Resolve("fake", "r1", Image{"ubuntu", "22.04"}); err != nil {
+		t.Error(err)
+	}
+	close(slow.release)
+	<-done
+}
+
+func TestResolveFlags(t *testing.T) {
+	c := NewCatalog(time.Hour)
+	c.Register("digitalocean", digitaloceanSource{})
+
+	values := map[string]interface{}{"digitalocean-image": "ubuntu-22.04", "digitalocean-region": "fra1"}
+	if err := c.ResolveFlags("digitalocean", values); err != nil {
+		t.Fatal(err)
+	}
+	if values["digitalocean-image"] != "ubuntu-22-04-x64" {
+		t.Errorf("got %v", values["digitalocean-image"])
+	}
+
+	values = map[string]interface{}{"digitalocean-image": "coreos-stable"}
+	if err := c.ResolveFlags("digitalocean", values); err != nil || values["digitalocean-image"] != "coreos-stable" {
+		t.Errorf("expected non portable image to be kept, got %v, %v", values["digitalocean-image"], err)
+	}
+}
+
+func TestAmazonSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ubuntu":
+			fmt.Fprint(w, `{"products": {"com.ubuntu.cloud:server:22.04:amd64": {"versions": {
+				"20230101": {"items": {"a": {"crsn": "eu-west-1", "id": "ami-old", "virt": "hvm", "root_store": "ssd"}}},
+				"20230201": {"items": {
+					"b": {"crsn": "eu-west-1", "id": "ami-instance", "virt": "hvm", "root_store": "instance"},
+					"c": {"crsn": "eu-west-1", "id": "ami-new", "virt": "hvm", "root_store": "ssd"},
+					"d": {"crsn": "us-east-1", "id": "ami-us", "virt": "hvm", "root_store": "ssd"}}}}}}}`)
+		case "/stable":
+			fmt.Fprint(w, `{"amis": [{"name": "eu-west-1", "hvm": "ami-flatcar"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	s := &amazonSource{client: server.Client(), ubuntuURL: server.URL + "/ubuntu", flatcarURL: server.URL + "/%s"}
+	tests := []struct {
+		region string
+		img    Image
+		id     string
+	}{
+		{"eu-west-1", Image{"ubuntu", "22.04"}, "ami-new"},
+		{"us-east-1", Image{"ubuntu", "22.04"}, "ami-us"},
+		{"eu-west-1", Image{"flatcar", "stable"}, "ami-flatcar"},
+		{"ap-south-1", Image{"ubuntu", "22.04"}, ""},
+		{"eu-west-1", Image{"ubuntu", "20.04"}, ""},
+		{"eu-west-1", Image{"flatcar", "beta"}, ""},
+	}
+	for _, test := range tests {
+		id, err := s.Resolve(test.region, test.img)
+		if id != test.id || (err == nil) != (test.id != "") {
+			t.Errorf("Resolve(%q, %v) = %q, %v; want %q", test.region, test.img, id, err, test.id)
+		}
+	}
+}
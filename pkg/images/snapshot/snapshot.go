@@ -0,0 +1,161 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/digitalocean/godo"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	raw "google.golang.org/api/compute/v1"
+)
+
+const pollInterval = 5 * time.Second
+
+// creator creates an image from the disk of a stopped machine given the
+// stored configuration of its driver. It returns the image in the format of
+// the image create flag of the driver and the region the image is limited
+// to, if any.
+type creator func(rawDriver []byte, name string) (id, region string, err error)
+
+// creators holds the drivers supporting snapshots. The cloud APIs are
+// called directly, as the plugin RPC shim only forwards the methods of
+// drivers.Driver.
+var creators = map[string]creator{
+	"amazonec2":    amazonImage,
+	"digitalocean": digitaloceanImage,
+	"google":       googleImage,
+}
+
+// Supported returns whether images can be created from machines of the
+// driver.
+func Supported(driver string) bool {
+	_, ok := creators[driver]
+	return ok
+}
+
+// Create creates an image named name from the disk of a stopped machine and
+// waits until it can be used.
+func Create(driver string, rawDriver []byte, name string) (id, region string, err error) {
+	create, ok := creators[driver]
+	if !ok {
+		return "", "", fmt.Errorf("%s driver does not support images", driver)
+	}
+	return create(rawDriver, name)
+}
+
+func amazonImage(rawDriver []byte, name string) (string, string, error) {
+	var d struct {
+		AccessKey, SecretKey, SessionToken string
+		Region, InstanceId, Endpoint       string
+		DisableSSL                         bool
+	}
+	if err := json.Unmarshal(rawDriver, &d); err != nil {
+		return "", "", err
+	}
+
+	config := aws.NewConfig().WithRegion(d.Region)
+	if d.AccessKey != "" {
+		config = config.WithCredentials(credentials.NewStaticCredentials(d.AccessKey, d.SecretKey, d.SessionToken))
+	}
+	if d.Endpoint != "" {
+		config = config.WithEndpoint(d.Endpoint).WithDisableSSL(d.DisableSSL)
+	}
+	client := ec2.New(session.New(config))
+
+	out, err := client.CreateImage(&ec2.CreateImageInput{
+		InstanceId:  aws.String(d.InstanceId),
+		Name:        aws.String(name),
+		Description: aws.String("kube-machine golden image"),
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to create AMI: %v", err)
+	}
+	if err := client.WaitUntilImageAvailable(&ec2.DescribeImagesInput{ImageIds: []*string{out.ImageId}}); err != nil {
+		return "", "", fmt.Errorf("Failed waiting for AMI %s: %v", *out.ImageId, err)
+	}
+	return *out.ImageId, d.Region, nil
+}
+
+func digitaloceanImage(rawDriver []byte, name string) (string, string, error) {
+	var d struct {
+		AccessToken string
+		DropletID   int
+		Region      string
+	}
+	if err := json.Unmarshal(rawDriver, &d); err != nil {
+		return "", "", err
+	}
+	client := godo.NewClient(oauth2.NewClient(oauth2.NoContext, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: d.AccessToken})))
+
+	action, _, err := client.DropletActions.Snapshot(d.DropletID, name)
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to snapshot droplet: %v", err)
+	}
+	for action.Status == godo.ActionInProgress {
+		time.Sleep(pollInterval)
+		if action, _, err = client.Actions.Get(action.ID); err != nil {
+			return "", "", fmt.Errorf("Failed waiting for the snapshot: %v", err)
+		}
+	}
+	if action.Status != godo.ActionCompleted {
+		return "", "", fmt.Errorf("Snapshot of droplet %d %s", d.DropletID, action.Status)
+	}
+
+	snapshots, _, err := client.Droplets.Snapshots(d.DropletID, &godo.ListOptions{PerPage: 200})
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to list snapshots: %v", err)
+	}
+	for _, s := range snapshots {
+		if s.Name == name {
+			// Snapshots are only available in the region of the droplet
+			// until they are transferred.
+			return strconv.Itoa(s.ID), d.Region, nil
+		}
+	}
+	return "", "", fmt.Errorf("Snapshot %s of droplet %d not found", name, d.DropletID)
+}
+
+func googleImage(rawDriver []byte, name string) (string, string, error) {
+	var d struct {
+		MachineName, Project, Zone string
+	}
+	if err := json.Unmarshal(rawDriver, &d); err != nil {
+		return "", "", err
+	}
+	client, err := google.DefaultClient(oauth2.NoContext, raw.ComputeScope)
+	if err != nil {
+		return "", "", err
+	}
+	service, err := raw.New(client)
+	if err != nil {
+		return "", "", err
+	}
+
+	op, err := service.Images.Insert(d.Project, &raw.Image{
+		Name:        name,
+		Description: "kube-machine golden image",
+		SourceDisk:  fmt.Sprintf("projects/%s/zones/%s/disks/%s-disk", d.Project, d.Zone, d.MachineName),
+	}).Do()
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to create image: %v", err)
+	}
+	for op.Status != "DONE" {
+		time.Sleep(pollInterval)
+		if op, err = service.GlobalOperations.Get(d.Project, op.Name).Do(); err != nil {
+			return "", "", fmt.Errorf("Failed waiting for image %s: %v", name, err)
+		}
+	}
+	if op.Error != nil && len(op.Error.Errors) > 0 {
+		return "", "", errors.New(op.Error.Errors[0].Message)
+	}
+	// Images are global, the driver prefixes the project URL.
+	return d.Project + "/global/images/" + name, "", nil
+}
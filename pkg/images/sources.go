@@ -0,0 +1,165 @@
+package images
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ubuntuReleases are the LTS releases published by all providers.
+var ubuntuReleases = map[string]bool{
+	"18.04": true,
+	"20.04": true,
+	"22.04": true,
+	"24.04": true,
+}
+
+var errFlatcarUnsupported = errors.New("flatcar images are not published for this provider")
+
+// amazonSource looks up AMIs in the image feeds published by Canonical and
+// the Flatcar project, which list the current image of every region.
+type amazonSource struct {
+	client *http.Client
+
+	ubuntuURL  string
+	flatcarURL string
+}
+
+func NewAmazonSource() Source {
+	return &amazonSource{
+		client:     &http.Client{Timeout: time.Minute},
+		ubuntuURL:  "https://cloud-images.ubuntu.com/releases/streams/v1/com.ubuntu.cloud:released:aws.json",
+		flatcarURL: "https://%s.release.flatcar-linux.net/amd64-usr/current/flatcar_production_ami_all.json",
+	}
+}
+
+type simpleStreams struct {
+	Products map[string]struct {
+		Versions map[string]struct {
+			Items map[string]struct {
+				Region    string `json:"crsn"`
+				ID        string `json:"id"`
+				Virt      string `json:"virt"`
+				RootStore string `json:"root_store"`
+			} `json:"items"`
+		} `json:"versions"`
+	} `json:"products"`
+}
+
+type flatcarAMIs struct {
+	AMIs []struct {
+		Region string `json:"name"`
+		HVM    string `json:"hvm"`
+	} `json:"amis"`
+}
+
+func (s *amazonSource) Resolve(region string, img Image) (string, error) {
+	if region == "" {
+		return "", fmt.Errorf("region is required to resolve an AMI")
+	}
+
+	switch img.Distribution {
+	case "ubuntu":
+		var streams simpleStreams
+		if err := s.get(s.ubuntuURL, &streams); err != nil {
+			return "", err
+		}
+		return ubuntuAMI(streams, region, img.Version)
+	case "flatcar":
+		var amis flatcarAMIs
+		if err := s.get(fmt.Sprintf(s.flatcarURL, img.Version), &amis); err != nil {
+			return "", err
+		}
+		for _, ami := range amis.AMIs {
+			if ami.Region == region && ami.HVM != "" {
+				return ami.HVM, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no AMI published for %s in %s", img, region)
+}
+
+// ubuntuAMI returns the AMI of the most recent EBS backed HVM image of the
+// release in the region.
+func ubuntuAMI(streams simpleStreams, region, release string) (string, error) {
+	product, ok := streams.Products["com.ubuntu.cloud:server:"+release+":amd64"]
+	if !ok {
+		return "", fmt.Errorf("no images published for ubuntu %s", release)
+	}
+
+	var latest, id string
+	for version, v := range product.Versions {
+		if version <= latest {
+			continue
+		}
+		for _, item := range v.Items {
+			if item.Region == region && item.Virt == "hvm" && item.RootStore != "instance" {
+				latest, id = version, item.ID
+				break
+			}
+		}
+	}
+	if id == "" {
+		return "", fmt.Errorf("no AMI published for ubuntu-%s in %s", release, region)
+	}
+	return id, nil
+}
+
+func (s *amazonSource) get(url string, v interface{}) error {
+	resp, err := s.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// azureSource maps images to marketplace URNs, "latest" is resolved by Azure
+// in every location.
+type azureSource struct{}
+
+var azureUbuntuURNs = map[string]string{
+	"18.04": "Canonical:UbuntuServer:18.04-LTS:latest",
+	"20.04": "Canonical:0001-com-ubuntu-server-focal:20_04-lts:latest",
+	"22.04": "Canonical:0001-com-ubuntu-server-jammy:22_04-lts:latest",
+	"24.04": "Canonical:ubuntu-24_04-lts:server:latest",
+}
+
+func (azureSource) Resolve(region string, img Image) (string, error) {
+	if img.Distribution == "flatcar" {
+		return "kinvolk:flatcar-container-linux-free:" + img.Version + ":latest", nil
+	}
+	return azureUbuntuURNs[img.Version], nil
+}
+
+// googleSource maps images to image families, GCE resolves a family to its
+// latest image when the disk is created.
+type googleSource struct{}
+
+func (googleSource) Resolve(zone string, img Image) (string, error) {
+	if img.Distribution == "flatcar" {
+		return "kinvolk-public/global/images/family/flatcar-" + img.Version, nil
+	}
+	family := "ubuntu-" + strings.Replace(img.Version, ".", "", 1) + "-lts"
+	if img.Version >= "24.04" {
+		family += "-amd64"
+	}
+	return "ubuntu-os-cloud/global/images/family/" + family, nil
+}
+
+// digitaloceanSource maps images to distribution image slugs, which are
+// available in every region.
+type digitaloceanSource struct{}
+
+func (digitaloceanSource) Resolve(region string, img Image) (string, error) {
+	if img.Distribution == "flatcar" {
+		return "", errFlatcarUnsupported
+	}
+	return "ubuntu-" + strings.Replace(img.Version, ".", "-", 1) + "-x64", nil
+}
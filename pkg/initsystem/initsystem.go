@@ -0,0 +1,284 @@
+// Package initsystem manages the services kube-machine installs on the
+// nodes with their init system. The services are defined as systemd units,
+// which are converted for OpenRC, e.g. on Alpine, and SysV init, e.g. on
+// embedded distributions.
+package initsystem
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// The supported init systems.
+const (
+	Systemd = "systemd"
+	OpenRC  = "openrc"
+	SysV    = "sysv"
+)
+
+// Names are the supported init systems.
+var Names = []string{Systemd, OpenRC, SysV}
+
+// DetectCommand prints the init system of the node.
+const DetectCommand = "if [ -d /run/systemd/system ]; then echo " + Systemd +
+	"; elif [ -x /sbin/openrc-run ] || [ -d /run/openrc ]; then echo " + OpenRC +
+	"; else echo " + SysV + "; fi"
+
+// initDir holds the service scripts of OpenRC and SysV init.
+const initDir = "/etc/init.d"
+
+// File is a service definition installed on the node.
+type File struct {
+	Path    string
+	Content []byte
+	Mode    os.FileMode
+}
+
+// InitSystem installs and controls services on a node. The commands run
+// over SSH as an unprivileged user with sudo.
+type InitSystem interface {
+	Name() string
+	// ServiceFile returns the definition of the service from its systemd
+	// unit, which is installed at unitPath with the mode on systemd nodes.
+	ServiceFile(name, unitPath, unit string, mode os.FileMode) (File, error)
+	// EnableCommand makes the installed service start at boot.
+	EnableCommand(name string) string
+	// DisableCommand stops the service and disables it if it is
+	// installed.
+	DisableCommand(name string) string
+	RestartCommand(name string) string
+	// StartCommand starts the service unless it is running.
+	StartCommand(name string) string
+	StopCommand(name string) string
+	// StatusCommand fails unless the service is running.
+	StatusCommand(name string) string
+	// DefinitionCommand prints the unit or script of the service.
+	DefinitionCommand(name string) string
+	// LogsCommand prints the logs of the service of the last since, the
+	// log files of OpenRC and SysV init are printed whole.
+	LogsCommand(name string, since time.Duration) string
+	// RebootCommand reboots the node.
+	RebootCommand() string
+}
+
+// Validate checks that the init system is supported, empty is detected.
+func Validate(name string) error {
+	if name == "" {
+		return nil
+	}
+	for _, n := range Names {
+		if n == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown init system %q, expected one of %s", name, strings.Join(Names, ", "))
+}
+
+// Detect returns the init system from the output of DetectCommand.
+func Detect(out string) (string, error) {
+	name := strings.TrimSpace(out)
+	if name == "" {
+		return "", fmt.Errorf("Failed to detect the init system")
+	}
+	return name, Validate(name)
+}
+
+// Get returns the init system of the name, machines without recorded init
+// system use systemd.
+func Get(name string) (InitSystem, error) {
+	switch name {
+	case "", Systemd:
+		return systemd{}, nil
+	case OpenRC:
+		return openrc{}, nil
+	case SysV:
+		return sysv{}, nil
+	}
+	return nil, Validate(name)
+}
+
+// RunningCommand fails unless the service is running, with the init system
+// detected on the node.
+func RunningCommand(name string) string {
+	return fmt.Sprintf("case $(%s) in %s) %s ;; %s) %s >/dev/null ;; *) %s >/dev/null ;; esac",
+		DetectCommand, Systemd, systemd{}.StatusCommand(name), OpenRC, openrc{}.StatusCommand(name), sysv{}.StatusCommand(name))
+}
+
+// scriptMode makes the readable service scripts executable.
+func scriptMode(mode os.FileMode) os.FileMode {
+	return mode | (mode&0444)>>2
+}
+
+type systemd struct{}
+
+func (systemd) Name() string {
+	return Systemd
+}
+
+func (systemd) ServiceFile(name, unitPath, unit string, mode os.FileMode) (File, error) {
+	return File{Path: unitPath, Content: []byte(unit), Mode: mode}, nil
+}
+
+func (systemd) EnableCommand(name string) string {
+	return "sudo systemctl daemon-reload && sudo systemctl enable " + name
+}
+
+func (systemd) DisableCommand(name string) string {
+	return fmt.Sprintf("if systemctl cat %[1]s >/dev/null 2>&1; then sudo systemctl disable %[1]s && sudo systemctl stop %[1]s; fi", name)
+}
+
+func (systemd) RestartCommand(name string) string {
+	return "sudo systemctl daemon-reload && sudo systemctl restart " + name
+}
+
+func (systemd) StartCommand(name string) string {
+	return "sudo systemctl start " + name
+}
+
+func (systemd) StopCommand(name string) string {
+	return "sudo systemctl stop " + name
+}
+
+func (systemd) StatusCommand(name string) string {
+	return "systemctl is-active --quiet " + name
+}
+
+func (systemd) DefinitionCommand(name string) string {
+	return "systemctl cat --no-pager " + name
+}
+
+func (systemd) LogsCommand(name string, since time.Duration) string {
+	return fmt.Sprintf("sudo journalctl -u %s --no-pager --since=-%ds", name, int(since.Seconds()))
+}
+
+func (systemd) RebootCommand() string {
+	return "sudo systemctl reboot"
+}
+
+type openrc struct{}
+
+func (openrc) Name() string {
+	return OpenRC
+}
+
+func (openrc) ServiceFile(name, unitPath, unit string, mode os.FileMode) (File, error) {
+	s, err := ParseUnit(path.Base(unitPath), unit)
+	if err != nil {
+		return File{}, err
+	}
+	script, err := s.openRCScript(name)
+	if err != nil {
+		return File{}, err
+	}
+	return File{Path: path.Join(initDir, name), Content: []byte(script), Mode: scriptMode(mode)}, nil
+}
+
+func (openrc) EnableCommand(name string) string {
+	return fmt.Sprintf("sudo rc-update add %s default", name)
+}
+
+func (openrc) DisableCommand(name string) string {
+	return fmt.Sprintf("if [ -f %[1]s/%[2]s ]; then sudo rc-service %[2]s stop && sudo rc-update del %[2]s default; fi", initDir, name)
+}
+
+func (openrc) RestartCommand(name string) string {
+	return fmt.Sprintf("sudo rc-service %s restart", name)
+}
+
+func (openrc) StartCommand(name string) string {
+	return fmt.Sprintf("sudo rc-service %s start", name)
+}
+
+func (openrc) StopCommand(name string) string {
+	return fmt.Sprintf("sudo rc-service %s stop", name)
+}
+
+func (openrc) StatusCommand(name string) string {
+	return fmt.Sprintf("sudo rc-service %s status", name)
+}
+
+func (openrc) DefinitionCommand(name string) string {
+	return scriptDefinitionCommand(name)
+}
+
+func (openrc) LogsCommand(name string, since time.Duration) string {
+	return scriptLogsCommand(name)
+}
+
+func (openrc) RebootCommand() string {
+	return "sudo reboot"
+}
+
+type sysv struct{}
+
+func (sysv) Name() string {
+	return SysV
+}
+
+func (sysv) ServiceFile(name, unitPath, unit string, mode os.FileMode) (File, error) {
+	s, err := ParseUnit(path.Base(unitPath), unit)
+	if err != nil {
+		return File{}, err
+	}
+	script, err := s.sysVScript(name)
+	if err != nil {
+		return File{}, err
+	}
+	return File{Path: path.Join(initDir, name), Content: []byte(script), Mode: scriptMode(mode)}, nil
+}
+
+// EnableCommand links the script into the runlevels with the tool of the
+// distribution, or directly without one.
+func (sysv) EnableCommand(name string) string {
+	return fmt.Sprintf("sudo sh -c 'if command -v update-rc.d >/dev/null; then update-rc.d %[1]s defaults; "+
+		"elif command -v chkconfig >/dev/null; then chkconfig --add %[1]s; "+
+		"else for l in 2 3 4 5; do mkdir -p /etc/rc$l.d && ln -sf ../init.d/%[1]s /etc/rc$l.d/S99%[1]s; done; fi'", name)
+}
+
+func (sysv) DisableCommand(name string) string {
+	return fmt.Sprintf("if [ -f %[1]s/%[2]s ]; then sudo %[1]s/%[2]s stop && "+
+		"sudo sh -c 'if command -v update-rc.d >/dev/null; then update-rc.d -f %[2]s remove; "+
+		"elif command -v chkconfig >/dev/null; then chkconfig --del %[2]s; "+
+		"else rm -f /etc/rc?.d/S99%[2]s; fi'; fi", initDir, name)
+}
+
+func (sysv) RestartCommand(name string) string {
+	return fmt.Sprintf("sudo %s/%s restart", initDir, name)
+}
+
+func (sysv) StartCommand(name string) string {
+	return fmt.Sprintf("sudo %s/%s start", initDir, name)
+}
+
+func (sysv) StopCommand(name string) string {
+	return fmt.Sprintf("sudo %s/%s stop", initDir, name)
+}
+
+func (sysv) StatusCommand(name string) string {
+	return fmt.Sprintf("sudo %s/%s status", initDir, name)
+}
+
+func (sysv) DefinitionCommand(name string) string {
+	return scriptDefinitionCommand(name)
+}
+
+func (sysv) LogsCommand(name string, since time.Duration) string {
+	return scriptLogsCommand(name)
+}
+
+func (sysv) RebootCommand() string {
+	return "sudo reboot"
+}
+
+func scriptDefinitionCommand(name string) string {
+	return fmt.Sprintf("cat %s/%s", initDir, name)
+}
+
+// scriptLogsCommand prints the log file the converted service scripts
+// write to.
+func scriptLogsCommand(name string) string {
+	return fmt.Sprintf("sudo cat /var/log/%s.log", name)
+}
@@ -0,0 +1,148 @@
+package initsystem
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const proxyUnit = `[Unit]
+Description=Kubernetes API server failover proxy
+Requires=docker.service
+After=docker.service network-online.target
+
+[Service]
+Restart=always
+RestartSec=5s
+Environment="PATH=/usr/local/sbin:/usr/bin:/opt/bin" LOG_LEVEL=info
+ExecStartPre=-/usr/bin/docker rm -f kube-apiserver-proxy
+ExecStartPre=/usr/bin/mkdir -p /var/lib/kubelet
+ExecStart=/opt/bin/kubelet \
+  --kubeconfig=/etc/kubeconfig \
+  --eviction-hard=memory.available<100Mi
+ExecStop=/usr/bin/docker stop kube-apiserver-proxy
+
+[Install]
+WantedBy=multi-user.target
+`
+
+func TestParseUnit(t *testing.T) {
+	s, err := ParseUnit("kube-apiserver-proxy.service", proxyUnit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Service{
+		Unit:         "kube-apiserver-proxy.service",
+		Description:  "Kubernetes API server failover proxy",
+		Needs:        []string{"docker"},
+		After:        []string{"docker"},
+		Environment:  []string{"PATH=/usr/local/sbin:/usr/bin:/opt/bin", "LOG_LEVEL=info"},
+		Pre:          []string{"docker rm -f kube-apiserver-proxy || true", "mkdir -p /var/lib/kubelet"},
+		Stop:         []string{"docker stop kube-apiserver-proxy"},
+		Command:      "/opt/bin/kubelet",
+		Args:         `--kubeconfig=/etc/kubeconfig '--eviction-hard=memory.available<100Mi'`,
+		RestartDelay: 5,
+	}
+	if !reflect.DeepEqual(s, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, s)
+	}
+
+	for _, unit := range []string{
+		"[Service]\nLoadCredentialEncrypted=kubeconfig:/etc/kubeconfig.cred\nExecStart=/opt/bin/kubelet\n",
+		"[Service]\nExecStart=/opt/bin/kubelet --node-labels=\"a=b\"\n",
+		"[Service]\nRestart=always\n",
+	} {
+		if _, err := ParseUnit("kubelet.service", unit); err == nil {
+			t.Errorf("Expected an error for %q", unit)
+		}
+	}
+}
+
+func TestServiceFile(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		path     string
+		contains []string
+	}{
+		{Systemd, "/etc/systemd/system/kube-apiserver-proxy.service", []string{"ExecStart=/opt/bin/kubelet"}},
+		{OpenRC, "/etc/init.d/kube-apiserver-proxy", []string{
+			"#!/sbin/openrc-run",
+			"command=/opt/bin/kubelet\n",
+			"command_args='--kubeconfig=/etc/kubeconfig '\\''--eviction-hard=memory.available<100Mi'\\'''\n",
+			"respawn_delay=5\n",
+			"\tneed net docker\n\tafter docker\n",
+			"start_pre() {\n\tdocker rm -f kube-apiserver-proxy || true\n",
+		}},
+		{SysV, "/etc/init.d/kube-apiserver-proxy", []string{
+			"# Provides:          kube-apiserver-proxy\n",
+			"\t\t\t/opt/bin/kubelet --kubeconfig=/etc/kubeconfig '--eviction-hard=memory.available<100Mi' &\n",
+			"\t\t\tsleep 5\n",
+			"export LOG_LEVEL=info\n",
+		}},
+	} {
+		i, err := Get(test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := i.ServiceFile("kube-apiserver-proxy", "/etc/systemd/system/kube-apiserver-proxy.service", proxyUnit, 0644)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if f.Path != test.path {
+			t.Errorf("%s: expected the path %s, got %s", test.name, test.path, f.Path)
+		}
+		if test.name != Systemd && f.Mode != 0755 {
+			t.Errorf("%s: expected an executable script, got mode %o", test.name, f.Mode)
+		}
+		for _, c := range test.contains {
+			if !strings.Contains(string(f.Content), c) {
+				t.Errorf("%s: expected %q in\n%s", test.name, c, f.Content)
+			}
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	for out, expected := range map[string]string{"systemd\n": Systemd, "openrc\n": OpenRC, "sysv": SysV} {
+		name, err := Detect(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != expected {
+			t.Errorf("Expected %s for %q, got %s", expected, out, name)
+		}
+	}
+	for _, out := range []string{"", "upstart\n"} {
+		if _, err := Detect(out); err == nil {
+			t.Errorf("Expected an error for %q", out)
+		}
+	}
+	if i, err := Get(""); err != nil || i.Name() != Systemd {
+		t.Errorf("Expected systemd for machines without init system, got %v, %v", i, err)
+	}
+	if _, err := Get("runit"); err == nil {
+		t.Error("Expected an error for an unknown init system")
+	}
+}
+
+func TestCommands(t *testing.T) {
+	for _, c := range []struct {
+		name, reboot, logs string
+	}{
+		{Systemd, "sudo systemctl reboot", "sudo journalctl -u kubelet --no-pager --since=-3600s"},
+		{OpenRC, "sudo reboot", "sudo cat /var/log/kubelet.log"},
+		{SysV, "sudo reboot", "sudo cat /var/log/kubelet.log"},
+	} {
+		i, err := Get(c.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cmd := i.RebootCommand(); cmd != c.reboot {
+			t.Errorf("Expected %q rebooting with %s, got %q", c.reboot, c.name, cmd)
+		}
+		if cmd := i.LogsCommand("kubelet", time.Hour); cmd != c.logs {
+			t.Errorf("Expected %q reading the logs with %s, got %q", c.logs, c.name, cmd)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package initsystem
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/kubermatic/kube-machine/pkg/remote"
+)
+
+// Service is a systemd unit converted for another init system.
+type Service struct {
+	// Unit is the name of the unit the service was converted from.
+	Unit        string
+	Description string
+	// Needs are the services the service requires, After the ones it
+	// starts after.
+	Needs []string
+	After []string
+	// Environment are the assignments of the environment as shell words.
+	Environment []string
+	// Pre are the shell commands preparing the start, Stop the ones
+	// run after the service stopped.
+	Pre  []string
+	Stop []string
+	// Command and Args are the quoted path and arguments of the service.
+	Command      string
+	Args         string
+	RestartDelay int
+}
+
+// ignoredDirectives are left out of the conversion, the services are
+// always restarted and stopped with a signal.
+var ignoredDirectives = map[string]bool{
+	"Documentation": true, "Type": true, "Restart": true, "KillMode": true,
+	"TimeoutStartSec": true, "TimeoutStopSec": true,
+	"StartLimitInterval": true, "StartLimitIntervalSec": true, "StartLimitBurst": true,
+	"WantedBy": true, "RequiredBy": true, "Alias": true,
+}
+
+// systemBinDirs are the directories of the commands whose location differs
+// between distributions, e.g. mkdir on Alpine, they are found through the
+// PATH in the preparing commands.
+var systemBinDirs = map[string]bool{"/bin": true, "/sbin": true, "/usr/bin": true, "/usr/sbin": true}
+
+// ParseUnit converts a systemd service unit. Directives without counterpart
+// in the other init systems, e.g. credentials, fail the conversion.
+func ParseUnit(unitName, unit string) (*Service, error) {
+	s := &Service{Unit: unitName, Description: strings.TrimSuffix(unitName, ".service"), RestartDelay: 1}
+	var section string
+	for _, line := range unitLines(unit) {
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = line[1 : len(line)-1]
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("Failed to parse %q of %s", line, unitName)
+		}
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if ignoredDirectives[key] || section == "Install" {
+			continue
+		}
+		if err := s.set(section, key, value); err != nil {
+			return nil, fmt.Errorf("Failed to convert %s of %s: %v", key, unitName, err)
+		}
+	}
+	if s.Command == "" {
+		return nil, fmt.Errorf("%s has no ExecStart", unitName)
+	}
+	return s, nil
+}
+
+func (s *Service) set(section, key, value string) error {
+	switch section + "." + key {
+	case "Unit.Description":
+		s.Description = value
+	case "Unit.Requires", "Unit.Wants":
+		s.Needs = append(s.Needs, services(value)...)
+	case "Unit.After":
+		s.After = append(s.After, services(value)...)
+	case "Service.Environment":
+		assignments, err := environment(value)
+		if err != nil {
+			return err
+		}
+		s.Environment = append(s.Environment, assignments...)
+	case "Service.RestartSec":
+		delay, err := strconv.Atoi(strings.TrimSuffix(value, "s"))
+		if err != nil {
+			return err
+		}
+		s.RestartDelay = delay
+	case "Service.ExecStartPre", "Service.ExecStop":
+		command, err := shellCommand(value)
+		if err != nil {
+			return err
+		}
+		if key == "ExecStop" {
+			s.Stop = append(s.Stop, command)
+		} else {
+			s.Pre = append(s.Pre, command)
+		}
+	case "Service.ExecStart":
+		words, err := commandWords(value)
+		if err != nil {
+			return err
+		}
+		s.Command = word(words[0])
+		var args []string
+		for _, arg := range words[1:] {
+			args = append(args, word(arg))
+		}
+		s.Args = strings.Join(args, " ")
+	default:
+		return fmt.Errorf("the directive has no counterpart")
+	}
+	return nil
+}
+
+// unitLines returns the lines of the unit with the continued lines joined.
+func unitLines(unit string) []string {
+	var lines []string
+	var continued string
+	for _, line := range strings.Split(unit, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasSuffix(line, "\\") {
+			continued += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		lines = append(lines, continued+line)
+		continued = ""
+	}
+	return append(lines, strings.TrimSpace(continued))
+}
+
+// services returns the services of the units, targets have no counterpart.
+func services(value string) []string {
+	var names []string
+	for _, unit := range strings.Fields(value) {
+		if strings.HasSuffix(unit, ".service") {
+			names = append(names, strings.TrimSuffix(unit, ".service"))
+		}
+	}
+	return names
+}
+
+// environment returns the assignments of an Environment directive as shell
+// words, assignments may be double-quoted.
+func environment(value string) ([]string, error) {
+	var assignments []string
+	for value = strings.TrimSpace(value); value != ""; value = strings.TrimSpace(value) {
+		var assignment string
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote in %q", value)
+			}
+			assignment, value = value[1:end+1], value[end+2:]
+		} else if i := strings.IndexAny(value, " \t"); i >= 0 {
+			assignment, value = value[:i], value[i:]
+		} else {
+			assignment, value = value, ""
+		}
+		i := strings.Index(assignment, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid assignment %q", assignment)
+		}
+		assignments = append(assignments, assignment[:i]+"="+word(assignment[i+1:]))
+	}
+	return assignments, nil
+}
+
+// commandWords splits a command line of the unit, systemd quoting isn't
+// supported.
+func commandWords(value string) ([]string, error) {
+	words := strings.Fields(value)
+	if len(words) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	for _, w := range words {
+		if strings.ContainsAny(w, `"'`) {
+			return nil, fmt.Errorf("quoted arguments are not supported")
+		}
+	}
+	return words, nil
+}
+
+// shellCommand converts a preparing or stopping command, failures of
+// commands prefixed with - are ignored.
+func shellCommand(value string) (string, error) {
+	ignoreFailure := strings.HasPrefix(value, "-")
+	words, err := commandWords(strings.TrimPrefix(value, "-"))
+	if err != nil {
+		return "", err
+	}
+	if systemBinDirs[path.Dir(words[0])] {
+		words[0] = path.Base(words[0])
+	}
+	for i, w := range words {
+		words[i] = word(w)
+	}
+	command := strings.Join(words, " ")
+	if ignoreFailure {
+		command += " || true"
+	}
+	return command, nil
+}
+
+// word quotes the argument for the shell unless it is made of characters
+// the shell doesn't interpret.
+func word(arg string) string {
+	for _, r := range arg {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=,@%+", r)) {
+			return remote.Quote(arg)
+		}
+	}
+	return arg
+}
+
+var funcs = template.FuncMap{"quote": remote.Quote}
+
+var openRCTemplate = template.Must(template.New("openrc").Funcs(funcs).Parse(`#!/sbin/openrc-run
+# Converted by kube-machine from {{.Service.Unit}}.
+
+description={{quote .Service.Description}}
+supervisor=supervise-daemon
+command={{.Service.Command}}
+command_args={{quote .Service.Args}}
+respawn_delay={{.Service.RestartDelay}}
+respawn_max=0
+output_log=/var/log/{{.Name}}.log
+error_log=/var/log/{{.Name}}.log
+{{range .Service.Environment}}export {{.}}
+{{end}}
+depend() {
+	need net{{range .Service.Needs}} {{.}}{{end}}
+{{- if .Service.After}}
+	after{{range .Service.After}} {{.}}{{end}}
+{{- end}}
+}
+{{- if .Service.Pre}}
+
+start_pre() {
+{{- range .Service.Pre}}
+	{{.}}
+{{- end}}
+}
+{{- end}}
+{{- if .Service.Stop}}
+
+stop_post() {
+{{- range .Service.Stop}}
+	{{.}}
+{{- end}}
+}
+{{- end}}
+`))
+
+func (s *Service) openRCScript(name string) (string, error) {
+	var b bytes.Buffer
+	err := openRCTemplate.Execute(&b, struct {
+		Name    string
+		Service *Service
+	}{name, s})
+	return b.String(), err
+}
+
+// The SysV script restarts the service in a loop like Restart=always, the
+// pid files hold the loop and the current process of the service.
+var sysVTemplate = template.Must(template.New("sysv").Funcs(funcs).Parse(`#!/bin/sh
+### BEGIN INIT INFO
+# Provides:          {{.Name}}
+# Required-Start:    $network $remote_fs{{range .Service.Needs}} {{.}}{{end}}
+# Required-Stop:     $network $remote_fs{{range .Service.Needs}} {{.}}{{end}}
+# Default-Start:     2 3 4 5
+# Default-Stop:      0 1 6
+# Short-Description: {{.Service.Description}}
+### END INIT INFO
+# Converted by kube-machine from {{.Service.Unit}}.
+
+PIDFILE=/var/run/{{.Name}}.pid
+LOG=/var/log/{{.Name}}.log
+{{range .Service.Environment}}export {{.}}
+{{end}}
+running() {
+	[ -f "$PIDFILE" ] && kill -0 "$(cat "$PIDFILE")" 2>/dev/null
+}
+
+start() {
+	running && return 0
+{{- range .Service.Pre}}
+	{{.}}
+{{- end}}
+	(
+		while true; do
+			{{.Service.Command}} {{.Service.Args}} &
+			echo $! > "$PIDFILE.child"
+			wait $!
+			sleep {{.Service.RestartDelay}}
+		done
+	) >>"$LOG" 2>&1 &
+	echo $! > "$PIDFILE"
+}
+
+stop() {
+	[ -f "$PIDFILE" ] && kill "$(cat "$PIDFILE")" 2>/dev/null
+	[ -f "$PIDFILE.child" ] && kill "$(cat "$PIDFILE.child")" 2>/dev/null
+	rm -f "$PIDFILE" "$PIDFILE.child"
+{{- range .Service.Stop}}
+	{{.}}
+{{- end}}
+	return 0
+}
+
+case "$1" in
+start) start ;;
+stop) stop ;;
+restart) stop; start ;;
+status)
+	if running; then echo "{{.Name}} is running"; else echo "{{.Name}} is stopped"; exit 3; fi ;;
+*)
+	echo "Usage: $0 {start|stop|restart|status}"; exit 1 ;;
+esac
+`))
+
+func (s *Service) sysVScript(name string) (string, error) {
+	var b bytes.Buffer
+	err := sysVTemplate.Execute(&b, struct {
+		Name    string
+		Service *Service
+	}{name, s})
+	return b.String(), err
+}
@@ -0,0 +1,262 @@
+package install
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/ghodss/yaml"
+)
+
+const (
+	// Name is the name of the deployment, its service account and roles.
+	Name = "kube-machine"
+	// ReadOnlyName is the name of the read-only service account and role.
+	ReadOnlyName = Name + "-read-only"
+	// DefaultNamespace is the namespace kube-machine is deployed to.
+	DefaultNamespace = "kube-machine"
+	// DefaultImage is the image of the deployment.
+	DefaultImage = "kubermatic/kube-machine:latest"
+
+	storagePath = "/var/lib/kube-machine"
+	specDir     = "/etc/kube-machine"
+	specKey     = "spec.yaml"
+
+	// SystemNamespace holds the shared state of kube-machine in the
+	// cluster.
+	SystemNamespace = "kube-system"
+)
+
+// Options configure the in-cluster deployment. Machines are stored as nodes,
+// so there are no custom resources to install.
+type Options struct {
+	Namespace string
+	Image     string
+	// SpecConfigMap holds the machine spec the controller reconciles under
+	// the key spec.yaml.
+	SpecConfigMap string
+	// StorageClaim is the persistent volume claim keeping the certificates
+	// and SSH keys of the machines, without one they are lost with the pod.
+	StorageClaim string
+	// ApproveCSRs has the controller approve the certificate requests of
+	// the kubelets of the machines.
+	ApproveCSRs bool
+	// ReadOnly renders the read-only service account and role instead of
+	// the deployment, for kube-machine --read-only.
+	ReadOnly bool
+}
+
+// Rule grants verbs on resources of an API group.
+type Rule struct {
+	APIGroups     []string `json:"apiGroups"`
+	Resources     []string `json:"resources"`
+	ResourceNames []string `json:"resourceNames,omitempty"`
+	Verbs         []string `json:"verbs"`
+}
+
+// ClusterRules are the cluster wide permissions of the operator role of
+// kube-machine: it keeps the machines as nodes and their conditions, drains
+// them and approves the certificates of their kubelets.
+var ClusterRules = []Rule{
+	{APIGroups: []string{""}, Resources: []string{"nodes"}, Verbs: []string{"get", "list", "create", "update", "delete"}},
+	{APIGroups: []string{""}, Resources: []string{"nodes/status"}, Verbs: []string{"update"}},
+	{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"list"}},
+	{APIGroups: []string{""}, Resources: []string{"pods/eviction"}, Verbs: []string{"create"}},
+	{APIGroups: []string{"policy"}, Resources: []string{"poddisruptionbudgets"}, Verbs: []string{"list"}},
+	{APIGroups: []string{"certificates.k8s.io"}, Resources: []string{"certificatesigningrequests"}, Verbs: []string{"get", "list", "create"}},
+	{APIGroups: []string{"certificates.k8s.io"}, Resources: []string{"certificatesigningrequests/approval"}, Verbs: []string{"update"}},
+}
+
+// ReadOnlyClusterRules are the permissions of the read-only role, which lists
+// and inspects the machines but can't change them.
+var ReadOnlyClusterRules = []Rule{
+	{APIGroups: []string{""}, Resources: []string{"nodes"}, Verbs: []string{"get", "list", "watch"}},
+}
+
+// ReadOnlySystemNamespaceRules are the permissions of the read-only role in
+// kube-system: the config maps of the heartbeat agents shown by status and
+// the histories of the pools.
+var ReadOnlySystemNamespaceRules = []Rule{
+	{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get"}},
+}
+
+// DefaultNamespaceRules are the permissions in the default namespace: the
+// smoke test jobs and their pods and discovering the API servers.
+var DefaultNamespaceRules = []Rule{
+	{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"list"}},
+	{APIGroups: []string{"batch"}, Resources: []string{"jobs"}, Verbs: []string{"create", "delete"}},
+	{APIGroups: []string{""}, Resources: []string{"endpoints"}, ResourceNames: []string{"kubernetes"}, Verbs: []string{"get"}},
+}
+
+// NamespaceRules are the permissions in the namespace of kube-machine, the
+// secrets and config maps of the node templates.
+var NamespaceRules = []Rule{
+	{APIGroups: []string{""}, Resources: []string{"secrets", "configmaps"}, Verbs: []string{"get"}},
+}
+
+// SystemNamespaceRules are the permissions in kube-system: the allocations
+// of the static IPAM pools, the histories of the pools, the leases locking
+// the machines, the bootstrap secrets of the node agents and the config
+// maps of the heartbeat agents with their bootstrap tokens and roles.
+// Agents granted before bootstrap tokens were used have service accounts,
+// which are removed with their machines. The daemon sets of the CNI are
+// checked for the toleration of the taint of new nodes. The audit events
+// are recorded in kube-system by default.
+var SystemNamespaceRules = []Rule{
+	{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"create"}},
+	{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get", "create", "update", "patch", "delete"}},
+	{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get", "create", "update", "patch", "delete"}},
+	{APIGroups: []string{""}, Resources: []string{"serviceaccounts"}, Verbs: []string{"get", "create", "delete"}},
+	{APIGroups: []string{"rbac.authorization.k8s.io"}, Resources: []string{"roles", "rolebindings"}, Verbs: []string{"get", "create", "update", "delete"}},
+	{APIGroups: []string{"coordination.k8s.io"}, Resources: []string{"leases"}, Verbs: []string{"get", "create", "update", "delete"}},
+	{APIGroups: []string{"extensions"}, Resources: []string{"daemonsets"}, Verbs: []string{"list"}},
+}
+
+type object map[string]interface{}
+
+func metadata(name, namespace string) object {
+	m := object{"name": name, "labels": object{"app": Name}}
+	if namespace != "" {
+		m["namespace"] = namespace
+	}
+	return m
+}
+
+func role(kind, name, namespace string, rules []Rule) object {
+	return object{
+		"apiVersion": "rbac.authorization.k8s.io/v1beta1",
+		"kind":       kind,
+		"metadata":   metadata(name, namespace),
+		"rules":      rules,
+	}
+}
+
+func binding(kind, roleKind, name, namespace, serviceAccountNamespace string) object {
+	return object{
+		"apiVersion": "rbac.authorization.k8s.io/v1beta1",
+		"kind":       kind,
+		"metadata":   metadata(name, namespace),
+		"roleRef": object{
+			"apiGroup": "rbac.authorization.k8s.io",
+			"kind":     roleKind,
+			"name":     name,
+		},
+		"subjects": []object{{
+			"kind":      "ServiceAccount",
+			"name":      name,
+			"namespace": serviceAccountNamespace,
+		}},
+	}
+}
+
+func deployment(o Options) object {
+	storage := object{"name": "storage", "emptyDir": object{}}
+	if o.StorageClaim != "" {
+		storage = object{"name": "storage", "persistentVolumeClaim": object{"claimName": o.StorageClaim}}
+	}
+	args := []string{"--storage-path", storagePath, "controller"}
+	if o.ApproveCSRs {
+		args = append(args, "--approve-csrs")
+	}
+	return object{
+		"apiVersion": "extensions/v1beta1",
+		"kind":       "Deployment",
+		"metadata":   metadata(Name, o.Namespace),
+		"spec": object{
+			// Two controllers would scale the same pools.
+			"replicas": 1,
+			"strategy": object{"type": "Recreate"},
+			"template": object{
+				"metadata": object{"labels": object{"app": Name}},
+				"spec": object{
+					"serviceAccountName": Name,
+					"containers": []object{{
+						"name":  Name,
+						"image": o.Image,
+						"args":  append(args, path.Join(specDir, specKey)),
+						"volumeMounts": []object{
+							{"name": "storage", "mountPath": storagePath},
+							{"name": "spec", "mountPath": specDir, "readOnly": true},
+						},
+					}},
+					"volumes": []object{
+						storage,
+						{"name": "spec", "configMap": object{"name": o.SpecConfigMap}},
+					},
+				},
+			},
+		},
+	}
+}
+
+// Objects returns the manifests of the in-cluster deployment.
+func Objects(o Options) []interface{} {
+	if o.Namespace == "" {
+		o.Namespace = DefaultNamespace
+	}
+	if o.Image == "" {
+		o.Image = DefaultImage
+	}
+	if o.SpecConfigMap == "" {
+		o.SpecConfigMap = Name + "-spec"
+	}
+	namespace := object{"apiVersion": "v1", "kind": "Namespace", "metadata": metadata(o.Namespace, "")}
+	if o.ReadOnly {
+		// The token of the service account is used by dashboards and
+		// read-only kubeconfigs.
+		return []interface{}{
+			namespace,
+			object{"apiVersion": "v1", "kind": "ServiceAccount", "metadata": metadata(ReadOnlyName, o.Namespace)},
+			role("ClusterRole", ReadOnlyName, "", ReadOnlyClusterRules),
+			binding("ClusterRoleBinding", "ClusterRole", ReadOnlyName, "", o.Namespace),
+			role("Role", ReadOnlyName, SystemNamespace, ReadOnlySystemNamespaceRules),
+			binding("RoleBinding", "Role", ReadOnlyName, SystemNamespace, o.Namespace),
+		}
+	}
+	objects := []interface{}{
+		namespace,
+		object{"apiVersion": "v1", "kind": "ServiceAccount", "metadata": metadata(Name, o.Namespace)},
+		role("ClusterRole", Name, "", ClusterRules),
+		binding("ClusterRoleBinding", "ClusterRole", Name, "", o.Namespace),
+	}
+	// kube-machine deployed to default or kube-system gets one role with
+	// the rules of both.
+	var namespaces []string
+	rules := map[string][]Rule{}
+	for _, r := range []struct {
+		namespace string
+		rules     []Rule
+	}{
+		{"default", DefaultNamespaceRules},
+		{o.Namespace, NamespaceRules},
+		{SystemNamespace, SystemNamespaceRules},
+	} {
+		if _, ok := rules[r.namespace]; !ok {
+			namespaces = append(namespaces, r.namespace)
+		}
+		rules[r.namespace] = append(rules[r.namespace], r.rules...)
+	}
+	for _, namespace := range namespaces {
+		objects = append(objects, role("Role", Name, namespace, rules[namespace]), binding("RoleBinding", "Role", Name, namespace, o.Namespace))
+	}
+	return append(objects, deployment(o))
+}
+
+// Render writes the manifests as a multi-document YAML stream for kubectl
+// apply.
+func Render(w io.Writer, o Options) error {
+	var buf bytes.Buffer
+	for i, obj := range Objects(o) {
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return fmt.Errorf("Failed to render the manifests: %v", err)
+		}
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.Write(data)
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
@@ -0,0 +1,168 @@
+package install
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+)
+
+type manifest struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Rules []Rule `json:"rules"`
+}
+
+func render(t *testing.T, o Options) []manifest {
+	var buf bytes.Buffer
+	if err := Render(&buf, o); err != nil {
+		t.Fatal(err)
+	}
+	var manifests []manifest
+	for _, doc := range strings.Split(buf.String(), "---\n") {
+		var m manifest
+		if err := yaml.Unmarshal([]byte(doc), &m); err != nil {
+			t.Fatalf("%v in %s", err, doc)
+		}
+		manifests = append(manifests, m)
+	}
+	return manifests
+}
+
+func TestRender(t *testing.T) {
+	var kinds []string
+	for _, m := range render(t, Options{StorageClaim: "machines"}) {
+		kinds = append(kinds, m.Kind+"/"+m.Metadata.Namespace)
+		if m.Kind == "ClusterRole" && len(m.Rules) != len(ClusterRules) {
+			t.Errorf("expected %d cluster rules, got %d", len(ClusterRules), len(m.Rules))
+		}
+	}
+	expected := "Namespace/ ServiceAccount/kube-machine ClusterRole/ ClusterRoleBinding/ Role/default RoleBinding/default Role/kube-machine RoleBinding/kube-machine Role/kube-system RoleBinding/kube-system Deployment/kube-machine"
+	if s := strings.Join(kinds, " "); s != expected {
+		t.Errorf("expected %s, got %s", expected, s)
+	}
+}
+
+func TestRenderDefaultNamespace(t *testing.T) {
+	roles := 0
+	for _, m := range render(t, Options{Namespace: "default"}) {
+		if m.Kind != "Role" || m.Metadata.Namespace != "default" {
+			continue
+		}
+		roles++
+		if len(m.Rules) != len(DefaultNamespaceRules)+len(NamespaceRules) {
+			t.Errorf("expected the rules to be merged, got %v", m.Rules)
+		}
+	}
+	if roles != 1 {
+		t.Errorf("expected one role in default, got %d", roles)
+	}
+}
+
+func TestRenderSystemNamespace(t *testing.T) {
+	roles := 0
+	for _, m := range render(t, Options{Namespace: SystemNamespace}) {
+		if m.Kind != "Role" || m.Metadata.Namespace != SystemNamespace {
+			continue
+		}
+		roles++
+		if len(m.Rules) != len(NamespaceRules)+len(SystemNamespaceRules) {
+			t.Errorf("expected the rules to be merged, got %v", m.Rules)
+		}
+	}
+	if roles != 1 {
+		t.Errorf("expected one role in kube-system, got %d", roles)
+	}
+}
+
+func TestRenderReadOnly(t *testing.T) {
+	var kinds []string
+	for _, m := range render(t, Options{ReadOnly: true}) {
+		kinds = append(kinds, m.Kind+"/"+m.Metadata.Name)
+		for _, r := range m.Rules {
+			for _, verb := range r.Verbs {
+				if verb != "get" && verb != "list" && verb != "watch" {
+					t.Errorf("Expected the read-only role to only read, got %v", r)
+				}
+			}
+		}
+	}
+	expected := "Namespace/kube-machine ServiceAccount/kube-machine-read-only ClusterRole/kube-machine-read-only ClusterRoleBinding/kube-machine-read-only Role/kube-machine-read-only RoleBinding/kube-machine-read-only"
+	if s := strings.Join(kinds, " "); s != expected {
+		t.Errorf("expected %s, got %s", expected, s)
+	}
+}
+
+// TestReadOnlyRules checks the read-only role grants what the read-only
+// commands read.
+func TestReadOnlyRules(t *testing.T) {
+	for _, r := range []struct {
+		command  string
+		rules    []Rule
+		resource string
+		verb     string
+	}{
+		{"ls", ReadOnlyClusterRules, "nodes", "list"},
+		{"inspect", ReadOnlyClusterRules, "nodes", "get"},
+		{"claims", ReadOnlyClusterRules, "nodes", "list"},
+		{"status", ReadOnlySystemNamespaceRules, "configmaps", "get"},
+		{"history", ReadOnlySystemNamespaceRules, "configmaps", "get"},
+	} {
+		if !allows(r.rules, "", r.resource, r.verb) {
+			t.Errorf("expected %s to be allowed to %s %s", r.command, r.verb, r.resource)
+		}
+	}
+}
+
+// allows returns whether the rules grant the verb on the resource.
+func allows(rules []Rule, group, resource, verb string) bool {
+	for _, r := range rules {
+		if !contains(r.APIGroups, group) || !contains(r.Resources, resource) || len(r.ResourceNames) > 0 {
+			continue
+		}
+		if contains(r.Verbs, verb) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSystemNamespaceRules(t *testing.T) {
+	for _, test := range []struct {
+		group, resource string
+		verbs           []string
+	}{
+		{"", "secrets", []string{"get", "create", "update", "patch", "delete"}},
+		{"", "serviceaccounts", []string{"get", "create", "delete"}},
+		{"", "configmaps", []string{"get", "create", "update", "patch", "delete"}},
+		{"rbac.authorization.k8s.io", "roles", []string{"create", "delete"}},
+		{"rbac.authorization.k8s.io", "rolebindings", []string{"get", "create", "update", "delete"}},
+		{"coordination.k8s.io", "leases", []string{"get", "create", "update", "delete"}},
+	} {
+		for _, verb := range test.verbs {
+			if !allows(SystemNamespaceRules, test.group, test.resource, verb) {
+				t.Errorf("expected the kube-system role to allow %s of %s", verb, test.resource)
+			}
+		}
+	}
+}
+
+func TestClusterRulesNodeConditions(t *testing.T) {
+	// The controller writes the node conditions with UpdateStatus.
+	if !allows(ClusterRules, "", "nodes/status", "update") {
+		t.Error("expected the cluster role to allow updating the node status")
+	}
+}
@@ -0,0 +1,62 @@
+package ipam
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// HTTP allocates the addresses with an external IPAM. It posts
+// {"machine": "<name>"} to <URL>/allocate, which responds with the lease,
+// and to <URL>/release.
+type HTTP struct {
+	URL    string
+	Client *http.Client
+}
+
+var defaultClient = &http.Client{Timeout: 30 * time.Second}
+
+func (h *HTTP) Allocate(machine string) (*Lease, error) {
+	lease := &Lease{}
+	if err := h.post("allocate", machine, lease); err != nil {
+		return nil, err
+	}
+	if err := lease.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid lease of %s from the IPAM: %v", machine, err)
+	}
+	return lease, nil
+}
+
+func (h *HTTP) Release(machine string) error {
+	return h.post("release", machine, nil)
+}
+
+func (h *HTTP) post(operation, machine string, result interface{}) error {
+	body, err := json.Marshal(map[string]string{"machine": machine})
+	if err != nil {
+		return err
+	}
+	client := h.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Post(h.URL+"/"+operation, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Failed to %s the address of %s: %v", operation, machine, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Failed to %s the address of %s: IPAM responded with %s: %s", operation, machine, resp.Status, bytes.TrimSpace(msg))
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("Failed to decode the lease of %s: %v", machine, err)
+	}
+	return nil
+}
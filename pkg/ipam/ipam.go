@@ -0,0 +1,109 @@
+package ipam
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"text/template"
+)
+
+// NetplanPath is the netplan configuration of the static address.
+const NetplanPath = "/etc/netplan/60-kube-machine.yaml"
+
+// Lease is the static address of a machine.
+type Lease struct {
+	// Address is in CIDR notation, e.g. 10.0.0.5/24.
+	Address string   `json:"address"`
+	Gateway string   `json:"gateway,omitempty"`
+	DNS     []string `json:"dns,omitempty"`
+}
+
+// IP returns the address without prefix length.
+func (l Lease) IP() string {
+	return strings.Split(l.Address, "/")[0]
+}
+
+// Validate checks the addresses of the lease.
+func (l Lease) Validate() error {
+	if _, _, err := net.ParseCIDR(l.Address); err != nil {
+		return fmt.Errorf("Invalid address %q, expected CIDR notation", l.Address)
+	}
+	for _, ip := range append([]string{l.Gateway}, l.DNS...) {
+		if ip != "" && net.ParseIP(ip) == nil {
+			return fmt.Errorf("Invalid IP %q", ip)
+		}
+	}
+	return nil
+}
+
+// Allocator hands out the static addresses of the machines. Allocating an
+// address for a machine which has one returns it again.
+type Allocator interface {
+	Allocate(machine string) (*Lease, error)
+	Release(machine string) error
+}
+
+// New returns the allocator of the configuration, either "static:<file>"
+// with a pool whose allocations are kept in the store or the URL of an
+// external IPAM.
+func New(config string, store Store) (Allocator, error) {
+	switch {
+	case strings.HasPrefix(config, "static:"):
+		return NewStatic(strings.TrimPrefix(config, "static:"), store)
+	case strings.HasPrefix(config, "http://") || strings.HasPrefix(config, "https://"):
+		return &HTTP{URL: strings.TrimSuffix(config, "/")}, nil
+	}
+	return nil, fmt.Errorf("Invalid IPAM %q, expected static:<file> or an http(s) URL", config)
+}
+
+var script = template.Must(template.New("network").Parse(`set -e
+iface=$(ip -o route show default | awk '{print $5; exit}')
+if [ -z "$iface" ]; then
+  iface=$(ip -o addr show | awk '$3 == "inet" && $2 != "lo" {print $2; exit}')
+fi
+if [ -z "$iface" ]; then
+  echo "No network interface found" >&2
+  exit 1
+fi
+if [ -d /etc/netplan ]; then
+  cat > {{.NetplanPath}} <<EOF
+network:
+  version: 2
+  ethernets:
+    $iface:
+      dhcp4: false
+      addresses: [{{.Address}}]
+{{- if .Gateway}}
+      gateway4: {{.Gateway}}
+{{- end}}
+{{- if .DNS}}
+      nameservers:
+        addresses: [{{.DNSList}}]
+{{- end}}
+EOF
+  chmod 600 {{.NetplanPath}}
+  netplan apply
+elif command -v nmcli >/dev/null; then
+  conn=$(nmcli -g GENERAL.CONNECTION device show "$iface")
+  nmcli connection modify "$conn" ipv4.method manual ipv4.addresses {{.Address}}{{if .Gateway}} ipv4.gateway {{.Gateway}}{{end}}{{if .DNS}} ipv4.dns "{{.DNSSpaced}}"{{end}}
+  nmcli connection up "$conn"
+else
+  echo "Neither netplan nor NetworkManager found" >&2
+  exit 1
+fi
+`))
+
+// Script returns the commands configuring the static address on the
+// interface of the default route with netplan or NetworkManager.
+func Script(l Lease) (string, error) {
+	if err := l.Validate(); err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	err := script.Execute(&b, struct {
+		Lease
+		NetplanPath, DNSList, DNSSpaced string
+	}{l, NetplanPath, strings.Join(l.DNS, ", "), strings.Join(l.DNS, " ")})
+	return b.String(), err
+}
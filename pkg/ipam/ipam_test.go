@@ -0,0 +1,163 @@
+package ipam
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStatic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := newStatic(Pool{CIDR: "10.0.0.0/29", Gateway: "10.0.0.1", DNS: []string{"10.0.0.2"}}, FileStore{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var addresses []string
+	for _, machine := range []string{"node-1", "node-2", "node-1", "node-3", "node-4", "node-5"} {
+		lease, err := s.Allocate(machine)
+		if err != nil {
+			t.Fatalf("%s: %v", machine, err)
+		}
+		addresses = append(addresses, lease.Address)
+	}
+	expected := []string{"10.0.0.2/29", "10.0.0.3/29", "10.0.0.2/29", "10.0.0.4/29", "10.0.0.5/29", "10.0.0.6/29"}
+	if !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("expected %v, got %v", expected, addresses)
+	}
+	if _, err := s.Allocate("node-6"); err == nil {
+		t.Error("expected an error for an exhausted pool")
+	}
+
+	if err := s.Release("node-2"); err != nil {
+		t.Fatal(err)
+	}
+	lease, err := s.Allocate("node-6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lease, &Lease{Address: "10.0.0.3/29", Gateway: "10.0.0.1", DNS: []string{"10.0.0.2"}}) {
+		t.Errorf("unexpected lease %+v", lease)
+	}
+
+	ranged, err := newStatic(Pool{CIDR: "10.0.0.0/24", Range: "10.0.0.100-10.0.0.101"}, FileStore{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease, err := ranged.Allocate("node-7"); err != nil || lease.Address != "10.0.0.100/24" {
+		t.Errorf("unexpected lease %+v: %v", lease, err)
+	}
+	if _, err := newStatic(Pool{CIDR: "10.0.0.0/24", Range: "10.0.1.1-10.0.1.9"}, FileStore{Dir: dir}); err == nil {
+		t.Error("expected an error for a range outside of the subnet")
+	}
+}
+
+// racingStore is changed by another process after every load until it has
+// been loaded twice.
+type racingStore struct {
+	allocations map[string]string
+	version     int
+	loads       int
+}
+
+func (s *racingStore) Load() (map[string]string, string, error) {
+	s.loads++
+	allocations := map[string]string{}
+	for k, v := range s.allocations {
+		allocations[k] = v
+	}
+	version := fmt.Sprint(s.version)
+	if s.loads <= 2 {
+		s.allocations[fmt.Sprintf("other-%d", s.loads)] = fmt.Sprintf("10.0.0.%d", s.loads+1)
+		s.version++
+	}
+	return allocations, version, nil
+}
+
+func (s *racingStore) Save(allocations map[string]string, version string) error {
+	if version != fmt.Sprint(s.version) {
+		return ErrConflict
+	}
+	s.allocations = allocations
+	s.version++
+	return nil
+}
+
+func TestStaticConflict(t *testing.T) {
+	store := &racingStore{allocations: map[string]string{}}
+	s, err := newStatic(Pool{CIDR: "10.0.0.0/24", Gateway: "10.0.0.1"}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lease, err := s.Allocate("node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.Address != "10.0.0.4/24" || store.loads != 3 {
+		t.Errorf("expected the address after the concurrent allocations in the third attempt, got %s after %d loads", lease.Address, store.loads)
+	}
+	if len(store.allocations) != 3 {
+		t.Errorf("expected the concurrent allocations to be kept, got %v", store.allocations)
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		switch {
+		case r.URL.Path == "/allocate" && req["machine"] == "node-1":
+			w.Write([]byte(`{"address": "192.168.1.10/24", "gateway": "192.168.1.1"}`))
+		case r.URL.Path == "/allocate":
+			w.Write([]byte(`{"address": "192.168.1.10"}`))
+		case r.URL.Path == "/release":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "unknown", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	a, err := New(server.URL+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lease, err := a.Allocate("node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.IP() != "192.168.1.10" || lease.Gateway != "192.168.1.1" {
+		t.Errorf("unexpected lease %+v", lease)
+	}
+	if _, err := a.Allocate("node-2"); err == nil {
+		t.Error("expected an error for an invalid lease")
+	}
+	if err := a.Release("node-1"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestScript(t *testing.T) {
+	script, err := Script(Lease{Address: "10.0.0.5/24", Gateway: "10.0.0.1", DNS: []string{"10.0.0.2", "10.0.0.3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"addresses: [10.0.0.5/24]", "gateway4: 10.0.0.1", "addresses: [10.0.0.2, 10.0.0.3]", "ipv4.addresses 10.0.0.5/24 ipv4.gateway 10.0.0.1 ipv4.dns \"10.0.0.2 10.0.0.3\""} {
+		if !strings.Contains(script, s) {
+			t.Errorf("expected %q in %s", s, script)
+		}
+	}
+	if _, err := Script(Lease{Address: "10.0.0.5"}); err == nil {
+		t.Error("expected an error for an address without prefix length")
+	}
+}
@@ -0,0 +1,245 @@
+package ipam
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/kubermatic/kube-machine/pkg/lock"
+)
+
+// lockTimeout is how long allocations wait for other processes allocating.
+var lockTimeout = 30 * time.Second
+
+// saveMu serializes the allocations of this process, its goroutines hold the
+// lock of the state directory with the same holder.
+var saveMu sync.Mutex
+
+// updateAttempts is how often an allocation is retried after conflicting
+// with another process.
+const updateAttempts = 10
+
+// ErrConflict is returned by stores whose allocations were changed since
+// they were loaded.
+var ErrConflict = errors.New("The IPAM allocations were changed concurrently")
+
+// Store keeps the allocations of the static pools, the addresses by the
+// names of the machines. Save fails with ErrConflict if the allocations
+// changed since the version was loaded, the version of missing allocations
+// is empty.
+type Store interface {
+	Load() (allocations map[string]string, version string, err error)
+	Save(allocations map[string]string, version string) error
+}
+
+// Pool is the static pool of a configuration file.
+type Pool struct {
+	// CIDR is the subnet of the machines, e.g. 10.0.0.0/24.
+	CIDR string `json:"cidr"`
+	// Range limits the addresses handed out, e.g. 10.0.0.100-10.0.0.199.
+	// The whole subnet but its network, broadcast and gateway addresses is
+	// used if it is empty.
+	Range   string   `json:"range,omitempty"`
+	Gateway string   `json:"gateway,omitempty"`
+	DNS     []string `json:"dns,omitempty"`
+}
+
+// Static allocates the addresses of a pool in order, the allocations are
+// kept in the store.
+type Static struct {
+	Pool  Pool
+	Store Store
+
+	network  *net.IPNet
+	from, to net.IP
+}
+
+// NewStatic reads the pool of the configuration file.
+func NewStatic(path string, store Store) (*Static, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Pool
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("Failed to parse IPAM pool %s: %v", path, err)
+	}
+	return newStatic(p, store)
+}
+
+func newStatic(p Pool, store Store) (*Static, error) {
+	_, network, err := net.ParseCIDR(p.CIDR)
+	if err != nil || network.IP.To4() == nil {
+		return nil, fmt.Errorf("Invalid CIDR %q of the IPAM pool, expected an IPv4 subnet", p.CIDR)
+	}
+	s := &Static{Pool: p, Store: store, network: network}
+	if p.Range == "" {
+		s.from, s.to = next(network.IP), broadcast(network)
+	} else {
+		bounds := strings.SplitN(p.Range, "-", 2)
+		if len(bounds) == 2 {
+			s.from, s.to = net.ParseIP(strings.TrimSpace(bounds[0])).To4(), net.ParseIP(strings.TrimSpace(bounds[1])).To4()
+		}
+		if s.from == nil || s.to == nil || !network.Contains(s.from) || !network.Contains(s.to) {
+			return nil, fmt.Errorf("Invalid range %q of the IPAM pool, expected two addresses of %s separated by -", p.Range, p.CIDR)
+		}
+	}
+	return s, nil
+}
+
+// Allocate returns the address of the machine, it gets the first free
+// address of the range.
+func (s *Static) Allocate(machine string) (*Lease, error) {
+	var lease *Lease
+	err := s.update(func(allocations map[string]string) error {
+		prefix, _ := s.network.Mask.Size()
+		if ip, ok := allocations[machine]; ok {
+			lease = s.lease(ip, prefix)
+			return nil
+		}
+		used := map[string]bool{s.Pool.Gateway: true}
+		for _, ip := range allocations {
+			used[ip] = true
+		}
+		for ip := s.from; !after(ip, s.to); ip = next(ip) {
+			if ip.Equal(broadcast(s.network)) {
+				break
+			}
+			if !used[ip.String()] {
+				allocations[machine] = ip.String()
+				lease = s.lease(ip.String(), prefix)
+				return nil
+			}
+		}
+		return fmt.Errorf("No free address left in the IPAM pool %s", s.Pool.CIDR)
+	})
+	return lease, err
+}
+
+func (s *Static) lease(ip string, prefix int) *Lease {
+	return &Lease{Address: fmt.Sprintf("%s/%d", ip, prefix), Gateway: s.Pool.Gateway, DNS: s.Pool.DNS}
+}
+
+// Release frees the address of the machine.
+func (s *Static) Release(machine string) error {
+	return s.update(func(allocations map[string]string) error {
+		delete(allocations, machine)
+		return nil
+	})
+}
+
+// update changes the allocations, it starts over if another process changed
+// them meanwhile so no address is handed out twice.
+func (s *Static) update(f func(allocations map[string]string) error) error {
+	for attempt := 0; ; attempt++ {
+		allocations, version, err := s.Store.Load()
+		if err != nil {
+			return fmt.Errorf("Failed to read the IPAM allocations: %v", err)
+		}
+		if allocations == nil {
+			allocations = map[string]string{}
+		}
+		if err := f(allocations); err != nil {
+			return err
+		}
+		err = s.Store.Save(allocations, version)
+		if err != ErrConflict || attempt == updateAttempts-1 {
+			return err
+		}
+	}
+}
+
+// FileStore keeps the allocations in a file of the state directory, only
+// kube-machine processes sharing the directory see them.
+type FileStore struct {
+	Dir string
+}
+
+var _ Store = FileStore{}
+
+func (s FileStore) path() string {
+	return filepath.Join(s.Dir, "ipam.json")
+}
+
+// Load reads the allocations, the version is the hash of the file.
+func (s FileStore) Load() (map[string]string, string, error) {
+	data, err := ioutil.ReadFile(s.path())
+	if os.IsNotExist(err) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	allocations := map[string]string{}
+	if err := json.Unmarshal(data, &allocations); err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(data)
+	return allocations, hex.EncodeToString(sum[:]), nil
+}
+
+// Save writes the allocations while holding the lock of the state
+// directory.
+func (s FileStore) Save(allocations map[string]string, version string) error {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+	locker := lock.FileLocker{Dir: s.Dir}
+	holder := lock.Holder("ipam")
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		release, err := lock.Acquire(locker, "ipam", "allocate", holder, nil)
+		if err == nil {
+			defer release()
+			break
+		}
+		if _, ok := err.(lock.ErrLocked); !ok || time.Now().After(deadline) {
+			return fmt.Errorf("Failed to lock the IPAM allocations: %v", err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	if _, current, err := s.Load(); err != nil {
+		return err
+	} else if current != version {
+		return ErrConflict
+	}
+	data, err := json.MarshalIndent(allocations, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path(), data, 0600)
+}
+
+func next(ip net.IP) net.IP {
+	n := make(net.IP, len(ip))
+	copy(n, ip)
+	for i := len(n) - 1; i >= 0; i-- {
+		n[i]++
+		if n[i] != 0 {
+			break
+		}
+	}
+	return n
+}
+
+func after(a, b net.IP) bool {
+	return string(a.To16()) > string(b.To16())
+}
+
+func broadcast(network *net.IPNet) net.IP {
+	ip := make(net.IP, len(network.IP.To4()))
+	for i := range ip {
+		ip[i] = network.IP.To4()[i] | ^network.Mask[len(network.Mask)-len(ip)+i]
+	}
+	return ip
+}
@@ -0,0 +1,106 @@
+package ipchange
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// The types of the addresses kubelets report.
+const (
+	InternalIP = "InternalIP"
+	ExternalIP = "ExternalIP"
+)
+
+// Address is an address the kubelet reports for its node.
+type Address struct {
+	Type    string
+	Address string
+}
+
+// Detect compares the address stored in the driver config of a machine to
+// the addresses its kubelet reports. The address changed if the stored one
+// isn't reported anymore, the new one is the reported address of the same
+// family and scope, e.g. the new private address of a machine reached over
+// its private network.
+func Detect(stored string, reported []Address) (string, bool) {
+	ip := net.ParseIP(stored)
+	if ip == nil {
+		return "", false
+	}
+	for _, a := range reported {
+		if reportedIP := net.ParseIP(a.Address); reportedIP != nil && reportedIP.Equal(ip) {
+			return stored, false
+		}
+	}
+
+	types := []string{ExternalIP, InternalIP}
+	if private(ip) {
+		types = []string{InternalIP, ExternalIP}
+	}
+	for _, t := range types {
+		for _, a := range reported {
+			candidate := net.ParseIP(a.Address)
+			if a.Type != t || candidate == nil || (candidate.To4() == nil) != (ip.To4() == nil) {
+				continue
+			}
+			return a.Address, true
+		}
+	}
+	return "", false
+}
+
+var privateNets []*net.IPNet
+
+func init() {
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		privateNets = append(privateNets, n)
+	}
+}
+
+func private(ip net.IP) bool {
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetIPAddress returns the driver config with the stored address replaced,
+// the other fields are kept as they are.
+func SetIPAddress(rawDriver []byte, address string) ([]byte, error) {
+	config := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(rawDriver))
+	decoder.UseNumber()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("Failed to parse the driver config: %v", err)
+	}
+	if _, ok := config["IPAddress"]; !ok {
+		return nil, fmt.Errorf("The driver config stores no IP address")
+	}
+	config["IPAddress"] = address
+	return json.Marshal(config)
+}
+
+// ReplaceNodeIP returns the --node-ip of the kubelet with the previous
+// address replaced by the current one, a dual-stack --node-ip keeps its other
+// address. It reports whether the previous address was part of it.
+func ReplaceNodeIP(nodeIP, previous, current string) (string, bool) {
+	prev := net.ParseIP(previous)
+	if prev == nil {
+		return nodeIP, false
+	}
+	ips := strings.Split(nodeIP, ",")
+	replaced := false
+	for i, ip := range ips {
+		if parsed := net.ParseIP(strings.TrimSpace(ip)); parsed != nil && parsed.Equal(prev) {
+			ips[i] = current
+			replaced = true
+		}
+	}
+	return strings.Join(ips, ","), replaced
+}
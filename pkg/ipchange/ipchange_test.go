@@ -0,0 +1,74 @@
+package ipchange
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	reported := []Address{
+		{Type: InternalIP, Address: "10.0.0.7"},
+		{Type: ExternalIP, Address: "203.0.113.9"},
+		{Type: InternalIP, Address: "fd00::7"},
+	}
+	for _, test := range []struct {
+		stored   string
+		expected string
+		changed  bool
+	}{
+		{"10.0.0.7", "10.0.0.7", false},
+		{"203.0.113.9", "203.0.113.9", false},
+		{"10.0.0.3", "10.0.0.7", true},
+		{"198.51.100.1", "203.0.113.9", true},
+		{"fd00::3", "fd00::7", true},
+		{"", "", false},
+	} {
+		address, changed := Detect(test.stored, reported)
+		if address != test.expected || changed != test.changed {
+			t.Errorf("Expected %s to be %s (changed %v), got %s (changed %v)", test.stored, test.expected, test.changed, address, changed)
+		}
+	}
+
+	if address, changed := Detect("10.0.0.3", []Address{{Type: InternalIP, Address: "fd00::7"}}); changed {
+		t.Errorf("Expected no change without address of the family, got %s", address)
+	}
+}
+
+func TestSetIPAddress(t *testing.T) {
+	raw, err := SetIPAddress([]byte(`{"IPAddress": "10.0.0.3", "MachineName": "node-1", "DropletID": 12345678901}`), "10.0.0.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&config); err != nil {
+		t.Fatal(err)
+	}
+	if config["IPAddress"] != "10.0.0.7" || config["MachineName"] != "node-1" || config["DropletID"] != json.Number("12345678901") {
+		t.Errorf("Unexpected driver config %s", raw)
+	}
+
+	if _, err := SetIPAddress([]byte(`{"MachineName": "node-1"}`), "10.0.0.7"); err == nil {
+		t.Error("Expected an error for driver configs without address")
+	}
+}
+
+func TestReplaceNodeIP(t *testing.T) {
+	for _, test := range []struct {
+		nodeIP, previous, current, expected string
+		replaced                            bool
+	}{
+		{"10.0.0.5", "10.0.0.5", "10.0.0.9", "10.0.0.9", true},
+		{"10.0.0.5,fd00::5", "10.0.0.5", "10.0.0.9", "10.0.0.9,fd00::5", true},
+		{"10.0.0.5,fd00::5", "fd00:0::5", "fd00::9", "10.0.0.5,fd00::9", true},
+		{"192.168.1.5", "10.0.0.5", "10.0.0.9", "192.168.1.5", false},
+		{"10.0.0.5", "", "10.0.0.9", "10.0.0.5", false},
+	} {
+		nodeIP, replaced := ReplaceNodeIP(test.nodeIP, test.previous, test.current)
+		if nodeIP != test.expected || replaced != test.replaced {
+			t.Errorf("ReplaceNodeIP(%q, %q, %q) = %q, %v, expected %q, %v", test.nodeIP, test.previous, test.current, nodeIP, replaced, test.expected, test.replaced)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package ipfamily
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// The families define which addresses a node uses.
+const (
+	// IPv4 nodes use their IPv4 address only, the default.
+	IPv4 = "ipv4"
+	// IPv6 nodes have no IPv4 address in the cluster.
+	IPv6 = "ipv6"
+	// DualStack nodes register an IPv4 and an IPv6 address.
+	DualStack = "dual"
+)
+
+// SysctlPath enables IPv6 forwarding on IPv6 and dual-stack nodes.
+const SysctlPath = "/etc/sysctl.d/90-kube-machine-ipv6.conf"
+
+// AddressesCommand prints the global addresses of the node in CIDR notation.
+const AddressesCommand = "ip -o addr show scope global | awk '{print $4}'"
+
+var Families = []string{IPv4, IPv6, DualStack}
+
+// Validate checks that the family is known, empty is IPv4.
+func Validate(family string) error {
+	if family == "" {
+		return nil
+	}
+	for _, f := range Families {
+		if f == family {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown IP family %q, expected one of %s", family, strings.Join(Families, ", "))
+}
+
+// DualStackMinorVersion is the first minor version of the kubelet of Kubernetes
+// 1 supporting dual-stack nodes.
+const DualStackMinorVersion = 20
+
+// ValidateKubelet checks that the kubelet version supports the family, an
+// empty version is a default of the OS which supports all of them.
+func ValidateKubelet(family, version string) error {
+	if family != DualStack || version == "" {
+		return nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return fmt.Errorf("Invalid kubelet version %q", version)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return fmt.Errorf("Invalid kubelet version %q", version)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return fmt.Errorf("Invalid kubelet version %q", version)
+	}
+	if major == 1 && minor < DualStackMinorVersion {
+		return fmt.Errorf("Dual-stack nodes need kubelet 1.%d or newer, the kubelet is %s", DualStackMinorVersion, version)
+	}
+	return nil
+}
+
+// usable reports whether the address can reach other nodes, link local and
+// loopback addresses can't.
+func usable(ip net.IP) bool {
+	return ip != nil && ip.IsGlobalUnicast()
+}
+
+func first(addrs []string, v4 bool) string {
+	for _, a := range addrs {
+		ip := net.ParseIP(a)
+		if usable(ip) && (ip.To4() != nil) == v4 {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+// Select returns the address a machine is reached at over SSH: the first
+// IPv6 address on IPv6 nodes, the first IPv4 address otherwise. Dual-stack
+// nodes fall back to IPv6 without IPv4 address.
+func Select(addrs []string, family string) (string, error) {
+	var ip string
+	switch family {
+	case IPv6:
+		ip = first(addrs, false)
+	case DualStack:
+		if ip = first(addrs, true); ip == "" {
+			ip = first(addrs, false)
+		}
+	default:
+		ip = first(addrs, true)
+	}
+	if ip == "" {
+		return "", fmt.Errorf("No %s address in %s", familyName(family), strings.Join(addrs, ", "))
+	}
+	return ip, nil
+}
+
+// NodeIPs returns the addresses the kubelet registers the node with, the
+// IPv4 before the IPv6 address of dual-stack nodes.
+func NodeIPs(addrs []string, family string) ([]string, error) {
+	if family != DualStack {
+		ip, err := Select(addrs, family)
+		if err != nil {
+			return nil, err
+		}
+		return []string{ip}, nil
+	}
+	v4, v6 := first(addrs, true), first(addrs, false)
+	if v4 == "" || v6 == "" {
+		return nil, fmt.Errorf("Dual-stack nodes need an IPv4 and an IPv6 address, got %s", strings.Join(addrs, ", "))
+	}
+	return []string{v4, v6}, nil
+}
+
+// ParseNodeIP parses the value of the kubelet --node-ip flag, one address or
+// an IPv4 and an IPv6 address separated by a comma.
+func ParseNodeIP(s string) ([]string, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) > 2 {
+		return nil, fmt.Errorf("Invalid node IP %q, expected at most one address per family", s)
+	}
+	var ips []string
+	var families []bool
+	for _, p := range parts {
+		ip := net.ParseIP(strings.TrimSpace(p))
+		if ip == nil {
+			return nil, fmt.Errorf("Invalid node IP %q", p)
+		}
+		families = append(families, ip.To4() != nil)
+		ips = append(ips, ip.String())
+	}
+	if len(families) == 2 && families[0] == families[1] {
+		return nil, fmt.Errorf("Invalid node IP %q, expected at most one address per family", s)
+	}
+	return ips, nil
+}
+
+// ValidateNodeIP checks that the node IP is well-formed and matches the
+// family, only dual-stack nodes have two addresses.
+func ValidateNodeIP(s, family string) error {
+	ips, err := ParseNodeIP(s)
+	if err != nil {
+		return err
+	}
+	if family == DualStack {
+		if len(ips) != 2 {
+			return fmt.Errorf("Dual-stack nodes need an IPv4 and an IPv6 node IP, got %q", s)
+		}
+		return nil
+	}
+	if len(ips) != 1 || (net.ParseIP(ips[0]).To4() == nil) != (family == IPv6) {
+		return fmt.Errorf("Invalid node IP %q, expected one %s address", s, familyName(family))
+	}
+	return nil
+}
+
+// ParseAddresses parses the output of AddressesCommand.
+func ParseAddresses(out string) []string {
+	var addrs []string
+	for _, line := range strings.Fields(out) {
+		if ip, _, err := net.ParseCIDR(line); err == nil {
+			addrs = append(addrs, ip.String())
+		}
+	}
+	return addrs
+}
+
+// Sysctls returns the sysctl configuration of nodes of the family, IPv4
+// nodes need none.
+func Sysctls(family string) string {
+	if family != IPv6 && family != DualStack {
+		return ""
+	}
+	return "net.ipv6.conf.all.forwarding = 1\nnet.ipv6.conf.default.forwarding = 1\n"
+}
+
+// Bracket puts IPv6 addresses in brackets, as scp and URLs expect them.
+func Bracket(host string) string {
+	if strings.Contains(host, ":") && !strings.HasPrefix(host, "[") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+func familyName(family string) string {
+	if family == IPv6 {
+		return "IPv6"
+	}
+	if family == DualStack {
+		return "IPv4 or IPv6"
+	}
+	return "IPv4"
+}
@@ -0,0 +1,130 @@
+package ipfamily
+
+import (
+	"reflect"
+	"testing"
+)
+
+var addrs = []string{"fe80::1", "2001:db8::10", "10.0.0.5", "2001:db8::11"}
+
+func TestSelect(t *testing.T) {
+	tests := []struct {
+		addrs    []string
+		family   string
+		expected string
+	}{
+		{addrs, "", "10.0.0.5"},
+		{addrs, IPv4, "10.0.0.5"},
+		{addrs, IPv6, "2001:db8::10"},
+		{addrs, DualStack, "10.0.0.5"},
+		{[]string{"fe80::1", "2001:db8::10"}, DualStack, "2001:db8::10"},
+	}
+	for _, test := range tests {
+		ip, err := Select(test.addrs, test.family)
+		if err != nil || ip != test.expected {
+			t.Errorf("%v %q: expected %s, got %q, %v", test.addrs, test.family, test.expected, ip, err)
+		}
+	}
+
+	if _, err := Select([]string{"fe80::1", "2001:db8::10"}, IPv4); err == nil {
+		t.Error("expected an error without IPv4 address")
+	}
+	if _, err := Select([]string{"127.0.0.1", "fe80::1"}, IPv6); err == nil {
+		t.Error("expected an error without global IPv6 address")
+	}
+}
+
+func TestNodeIPs(t *testing.T) {
+	ips, err := NodeIPs(addrs, DualStack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"10.0.0.5", "2001:db8::10"}; !reflect.DeepEqual(ips, expected) {
+		t.Errorf("expected %v, got %v", expected, ips)
+	}
+	if ips, err := NodeIPs(addrs, IPv6); err != nil || !reflect.DeepEqual(ips, []string{"2001:db8::10"}) {
+		t.Errorf("unexpected IPv6 node IPs %v, %v", ips, err)
+	}
+	if _, err := NodeIPs([]string{"10.0.0.5"}, DualStack); err == nil {
+		t.Error("expected an error without IPv6 address")
+	}
+}
+
+func TestParseNodeIP(t *testing.T) {
+	ips, err := ParseNodeIP("10.0.0.5, 2001:DB8::10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"10.0.0.5", "2001:db8::10"}; !reflect.DeepEqual(ips, expected) {
+		t.Errorf("expected %v, got %v", expected, ips)
+	}
+	for _, s := range []string{"", "node", "10.0.0.5,10.0.0.6", "10.0.0.5,2001:db8::10,10.0.0.6"} {
+		if _, err := ParseNodeIP(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestValidateNodeIP(t *testing.T) {
+	valid := []struct{ ip, family string }{
+		{"10.0.0.5", ""},
+		{"2001:db8::10", IPv6},
+		{"10.0.0.5,2001:db8::10", DualStack},
+	}
+	for _, test := range valid {
+		if err := ValidateNodeIP(test.ip, test.family); err != nil {
+			t.Errorf("%s %q: unexpected error %v", test.ip, test.family, err)
+		}
+	}
+	invalid := []struct{ ip, family string }{
+		{"2001:db8::10", IPv4},
+		{"10.0.0.5", IPv6},
+		{"10.0.0.5,2001:db8::10", IPv4},
+		{"10.0.0.5", DualStack},
+	}
+	for _, test := range invalid {
+		if err := ValidateNodeIP(test.ip, test.family); err == nil {
+			t.Errorf("%s %q: expected an error", test.ip, test.family)
+		}
+	}
+}
+
+func TestParseAddresses(t *testing.T) {
+	out := "10.0.0.5/24\n2001:db8::10/64\ngarbage\n"
+	if a := ParseAddresses(out); !reflect.DeepEqual(a, []string{"10.0.0.5", "2001:db8::10"}) {
+		t.Errorf("unexpected addresses %v", a)
+	}
+}
+
+func TestBracket(t *testing.T) {
+	for host, expected := range map[string]string{
+		"10.0.0.5":       "10.0.0.5",
+		"node.example":   "node.example",
+		"2001:db8::10":   "[2001:db8::10]",
+		"[2001:db8::10]": "[2001:db8::10]",
+	} {
+		if b := Bracket(host); b != expected {
+			t.Errorf("%s: expected %s, got %s", host, expected, b)
+		}
+	}
+}
+
+func TestValidateKubelet(t *testing.T) {
+	for _, test := range []struct {
+		family, version string
+		valid           bool
+	}{
+		{IPv4, "v1.5.3", true},
+		{IPv6, "v1.5.3", true},
+		{DualStack, "v1.5.3", false},
+		{DualStack, "v1.19.9", false},
+		{DualStack, "v1.20.0", true},
+		{DualStack, "v1.30.2", true},
+		{DualStack, "", true},
+		{DualStack, "latest", false},
+	} {
+		if err := ValidateKubelet(test.family, test.version); (err == nil) != test.valid {
+			t.Errorf("%s %s: expected valid %v, got %v", test.family, test.version, test.valid, err)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package kubeconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	certificates "k8s.io/client-go/pkg/apis/certificates/v1beta1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	NodeUserPrefix = "system:node:"
+	NodesGroup     = "system:nodes"
+
+	clusterName = "kubernetes"
+)
+
+var (
+	// CertificateTimeout is how long to wait for the cluster to sign the
+	// certificate of a node.
+	CertificateTimeout = 2 * time.Minute
+	pollInterval       = 2 * time.Second
+)
+
+// Cluster is the API server endpoint and CA the kubelet connects to.
+type Cluster struct {
+	Server string
+	CAData []byte
+}
+
+// ClusterFromConfig returns the endpoint and CA of the cluster of a client
+// config.
+func ClusterFromConfig(config *rest.Config) (Cluster, error) {
+	cluster := Cluster{
+		Server: config.Host,
+		CAData: config.TLSClientConfig.CAData,
+	}
+	if len(cluster.CAData) == 0 && config.TLSClientConfig.CAFile != "" {
+		data, err := ioutil.ReadFile(config.TLSClientConfig.CAFile)
+		if err != nil {
+			return cluster, fmt.Errorf("Failed to read cluster CA: %v", err)
+		}
+		cluster.CAData = data
+	}
+	if cluster.Server == "" {
+		return cluster, errors.New("The cluster config has no API server")
+	}
+	if len(cluster.CAData) == 0 {
+		return cluster, errors.New("The cluster config has no CA, the node could not verify the API server")
+	}
+	return cluster, nil
+}
+
+// Generate returns a kubeconfig of the user for the cluster.
+func Generate(cluster Cluster, user string, auth *clientcmdapi.AuthInfo) ([]byte, error) {
+	config := clientcmdapi.NewConfig()
+	config.Clusters[clusterName] = &clientcmdapi.Cluster{
+		Server:                   cluster.Server,
+		CertificateAuthorityData: cluster.CAData,
+	}
+	config.AuthInfos[user] = auth
+	config.Contexts[user] = &clientcmdapi.Context{
+		Cluster:  clusterName,
+		AuthInfo: user,
+	}
+	config.CurrentContext = user
+	return clientcmd.Write(*config)
+}
+
+// ForNode returns a kubeconfig with the identity system:node:<name>. The
+// client certificate is requested and approved through the certificates API
+// of the cluster, so the credentials of kube-machine need to be allowed to
+// approve node certificates.
+func ForNode(client kubernetes.Interface, cluster Cluster, nodeName string) ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	request, err := certificateRequest(key, nodeName, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := signCertificate(client, nodeName, request, certificates.UsageClientAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	keyData, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	user := NodeUserPrefix + nodeName
+	return Generate(cluster, user, &clientcmdapi.AuthInfo{
+		ClientCertificateData: cert,
+		ClientKeyData:         pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyData}),
+	})
+}
+
+// ServingCertificate returns a serving certificate of the kubelet of the
+// node signed by the cluster CA for the addresses, which are IPs or DNS
+// names, and its PEM encoded key.
+func ServingCertificate(client kubernetes.Interface, nodeName string, addresses []string) (cert, key []byte, err error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	request, err := certificateRequest(privateKey, nodeName, addresses)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err = signCertificate(client, nodeName, request, certificates.UsageServerAuth)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyData, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyData}), nil
+}
+
+func certificateRequest(key *ecdsa.PrivateKey, nodeName string, addresses []string) ([]byte, error) {
+	template := &x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName:   NodeUserPrefix + nodeName,
+			Organization: []string{NodesGroup},
+		},
+	}
+	for _, address := range addresses {
+		if ip := net.ParseIP(address); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, address)
+		}
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+func signCertificate(client kubernetes.Interface, nodeName string, request []byte, usage certificates.KeyUsage) ([]byte, error) {
+	csrs := client.CertificatesV1beta1().CertificateSigningRequests()
+	csr, err := csrs.Create(&certificates.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "kube-machine-" + nodeName + "-",
+		},
+		Spec: certificates.CertificateSigningRequestSpec{
+			Request: request,
+			Usages: []certificates.KeyUsage{
+				certificates.UsageDigitalSignature,
+				certificates.UsageKeyEncipherment,
+				usage,
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to request node certificate: %v", err)
+	}
+
+	csr.Status.Conditions = append(csr.Status.Conditions, certificates.CertificateSigningRequestCondition{
+		Type:    certificates.CertificateApproved,
+		Reason:  "KubeMachineApprove",
+		Message: "Approved by kube-machine for the machine " + nodeName,
+	})
+	if _, err := csrs.UpdateApproval(csr); err != nil {
+		return nil, fmt.Errorf("Failed to approve node certificate request %s: %v", csr.Name, err)
+	}
+
+	log.Infof("Waiting for the cluster to sign the certificate of %s...", nodeName)
+	deadline := time.Now().Add(CertificateTimeout)
+	for {
+		signed, err := csrs.Get(csr.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if len(signed.Status.Certificate) > 0 {
+			return signed.Status.Certificate, nil
+		}
+		for _, c := range signed.Status.Conditions {
+			if c.Type == certificates.CertificateDenied {
+				return nil, fmt.Errorf("Node certificate request %s was denied: %s", csr.Name, c.Message)
+			}
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("Timed out waiting for the cluster to sign node certificate request %s, is the signer of the controller manager enabled?", csr.Name)
+		}
+		time.Sleep(pollInterval)
+	}
+}
@@ -0,0 +1,172 @@
+package kubeconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func TestGenerate(t *testing.T) {
+	cluster := Cluster{Server: "https://10.0.0.1:6443", CAData: []byte("ca")}
+	data, err := Generate(cluster, "system:node:node-1", &clientcmdapi.AuthInfo{Token: "abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := clientcmd.Load(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	context := config.Contexts[config.CurrentContext]
+	if context == nil || context.AuthInfo != "system:node:node-1" {
+		t.Fatalf("Unexpected context %+v", context)
+	}
+	if c := config.Clusters[context.Cluster]; c.Server != cluster.Server || string(c.CertificateAuthorityData) != "ca" {
+		t.Errorf("Unexpected cluster %+v", c)
+	}
+	if config.AuthInfos["system:node:node-1"].Token != "abc" {
+		t.Errorf("Unexpected user %+v", config.AuthInfos)
+	}
+}
+
+func TestClusterFromConfig(t *testing.T) {
+	if _, err := ClusterFromConfig(&rest.Config{Host: "https://10.0.0.1"}); err == nil {
+		t.Error("Expected an error for a cluster without CA")
+	}
+
+	config := &rest.Config{Host: "https://10.0.0.1"}
+	config.TLSClientConfig.CAData = []byte("ca")
+	cluster, err := ClusterFromConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster.Server != "https://10.0.0.1" || string(cluster.CAData) != "ca" {
+		t.Errorf("Unexpected cluster %+v", cluster)
+	}
+}
+
+func TestCertificateRequest(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := certificateRequest(key, "node-1", []string{"10.0.0.5", "node-1.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, _ := pem.Decode(data)
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Subject.CommonName != "system:node:node-1" || len(request.Subject.Organization) != 1 || request.Subject.Organization[0] != NodesGroup {
+		t.Errorf("Unexpected subject %+v", request.Subject)
+	}
+	if len(request.IPAddresses) != 1 || request.IPAddresses[0].String() != "10.0.0.5" || len(request.DNSNames) != 1 || request.DNSNames[0] != "node-1.example.com" {
+		t.Errorf("Unexpected addresses %v, %v", request.IPAddresses, request.DNSNames)
+	}
+}
+
+func TestNormalizeEndpoint(t *testing.T) {
+	tests := map[string]string{
+		"https://10.0.0.1:6443":  "https://10.0.0.1:6443",
+		"https://lb.example.com": "https://lb.example.com:443",
+		"lb.example.com:6443":    "https://lb.example.com:6443",
+		"10.0.0.1":               "https://10.0.0.1:443",
+		"[fd00::1]:6443":         "https://[fd00::1]:6443",
+		"http://10.0.0.1:8080":   "",
+		"https://10.0.0.1/api":   "",
+	}
+	for endpoint, expected := range tests {
+		normalized, err := NormalizeEndpoint(endpoint)
+		if expected == "" {
+			if err == nil {
+				t.Errorf("Expected an error for %q, got %q", endpoint, normalized)
+			}
+			continue
+		}
+		if err != nil || normalized != expected {
+			t.Errorf("Expected %q for %q, got %q (%v)", expected, endpoint, normalized, err)
+		}
+	}
+}
+
+func TestSourceWithFile(t *testing.T) {
+	data, err := Generate(Cluster{Server: "https://10.0.0.1:6443", CAData: []byte("ca")}, "kubelet", &clientcmdapi.AuthInfo{Token: "abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(data)
+	f.Close()
+
+	s := &Source{File: f.Name(), Endpoints: []string{"10.0.0.1:6443", "10.0.0.2:6443"}}
+	servers, err := s.APIServers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 2 || servers[1] != "https://10.0.0.2:6443" {
+		t.Errorf("Unexpected API servers %v", servers)
+	}
+
+	data, err = s.Kubeconfig("node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := clientcmd.Load(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server := config.Clusters[clusterName].Server; server != "https://"+LocalProxyAddress {
+		t.Errorf("Expected the kubeconfig to point to the local proxy, got %q", server)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	data, err := Generate(Cluster{Server: "https://10.0.0.1:6443", CAData: []byte("ca")}, "kubelet", &clientcmdapi.AuthInfo{Token: "abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(data); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	for _, invalid := range []string{
+		"not: [yaml",
+		"apiVersion: v1\nkind: Config\ncurrent-context: missing\n",
+		"apiVersion: v1\nkind: Config\ncurrent-context: a\ncontexts:\n- name: a\n  context:\n    cluster: c\n    user: u\nclusters:\n- name: c\n  cluster: {}\nusers:\n- name: u\n  user: {}\n",
+		"apiVersion: v1\nkind: Config\ncurrent-context: a\ncontexts:\n- name: a\n  context:\n    cluster: c\n    user: u\nclusters:\n- name: c\n  cluster:\n    server: https://10.0.0.1\n",
+	} {
+		if err := Validate([]byte(invalid)); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestToken(t *testing.T) {
+	data, err := Generate(Cluster{Server: "https://10.0.0.1:6443", CAData: []byte("ca")}, "kubelet", &clientcmdapi.AuthInfo{Token: "abcdef.0123456789abcdef"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster, token, err := Token(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster.Server != "https://10.0.0.1:6443" || string(cluster.CAData) != "ca" || token != "abcdef.0123456789abcdef" {
+		t.Errorf("Unexpected cluster %+v and token %q", cluster, token)
+	}
+}
@@ -0,0 +1,240 @@
+package kubeconfig
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kubermatic/kube-machine/pkg/nodestore"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// Discover uses the endpoints of the kubernetes service as API servers.
+	Discover = "discover"
+
+	// LocalProxyAddress is where nodes with several API servers run the
+	// proxy failing over between them.
+	LocalProxyAddress = "127.0.0.1:6443"
+)
+
+// Source provides the cluster access of the kubelets.
+type Source struct {
+	// File is shipped to all nodes instead of generating a kubeconfig with
+	// the identity of each node.
+	File string
+	// StoreKubeconfig is the kubeconfig of the cluster of the store.
+	StoreKubeconfig string
+	// Endpoints override the API server of the cluster. With several
+	// endpoints the kubelet connects through a local proxy which fails over
+	// between them.
+	Endpoints []string
+
+	once     sync.Once
+	resolved []string
+	err      error
+}
+
+// Kubeconfig returns the kubeconfig of the kubelet of the machine.
+func (s *Source) Kubeconfig(machineName string) ([]byte, error) {
+	endpoints, err := s.endpoints()
+	if err != nil {
+		return nil, err
+	}
+	server := ""
+	switch {
+	case len(endpoints) == 1:
+		server = endpoints[0]
+	case len(endpoints) > 1:
+		server = "https://" + LocalProxyAddress
+	}
+
+	if s.File != "" {
+		data, err := ioutil.ReadFile(s.File)
+		if err != nil || server == "" {
+			return data, err
+		}
+		return SetServer(data, server)
+	}
+
+	client, cluster, err := s.cluster()
+	if err != nil {
+		return nil, err
+	}
+	if server != "" {
+		cluster.Server = server
+	}
+	return ForNode(client, cluster, machineName)
+}
+
+// ServingCertificate returns a serving certificate of the kubelet of the
+// machine signed by the cluster of the store and its key.
+func (s *Source) ServingCertificate(machineName string, addresses []string) (cert, key []byte, err error) {
+	client, _, err := s.cluster()
+	if err != nil {
+		return nil, nil, err
+	}
+	return ServingCertificate(client, machineName, addresses)
+}
+
+// APIServers returns the endpoints the node fails over between, it is empty
+// if the kubeconfig points to the API server directly.
+func (s *Source) APIServers() ([]string, error) {
+	endpoints, err := s.endpoints()
+	if err != nil || len(endpoints) < 2 {
+		return nil, err
+	}
+	return endpoints, nil
+}
+
+// Check verifies the kubeconfig of the kubelets can be built without
+// creating credentials for a node: the file is valid, or the cluster of the
+// store is, and the endpoints resolve.
+func (s *Source) Check() error {
+	if s.File == "" {
+		if _, _, err := s.cluster(); err != nil {
+			return err
+		}
+		_, err := s.endpoints()
+		return err
+	}
+	data, err := s.Kubeconfig("")
+	if err != nil {
+		return err
+	}
+	return Validate(data)
+}
+
+func (s *Source) cluster() (kubernetes.Interface, Cluster, error) {
+	config, err := nodestore.RestConfig(s.StoreKubeconfig)
+	if err != nil {
+		return nil, Cluster{}, err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, Cluster{}, err
+	}
+	cluster, err := ClusterFromConfig(config)
+	return client, cluster, err
+}
+
+func (s *Source) endpoints() ([]string, error) {
+	s.once.Do(func() {
+		if len(s.Endpoints) == 1 && s.Endpoints[0] == Discover {
+			client, _, err := s.cluster()
+			if err != nil {
+				s.err = err
+				return
+			}
+			s.resolved, s.err = DiscoverAPIServers(client)
+			return
+		}
+
+		for _, e := range s.Endpoints {
+			endpoint, err := NormalizeEndpoint(e)
+			if err != nil {
+				s.err = err
+				return
+			}
+			s.resolved = append(s.resolved, endpoint)
+		}
+	})
+	return s.resolved, s.err
+}
+
+// DiscoverAPIServers returns the API servers backing the kubernetes service.
+func DiscoverAPIServers(client kubernetes.Interface) ([]string, error) {
+	endpoints, err := client.CoreV1().Endpoints("default").Get("kubernetes", metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to discover API servers: %v", err)
+	}
+
+	var servers []string
+	for _, subset := range endpoints.Subsets {
+		for _, port := range subset.Ports {
+			for _, address := range subset.Addresses {
+				servers = append(servers, "https://"+net.JoinHostPort(address.IP, strconv.Itoa(int(port.Port))))
+			}
+		}
+	}
+	if len(servers) == 0 {
+		return nil, errors.New("Failed to discover API servers: the kubernetes service has no endpoints")
+	}
+	return servers, nil
+}
+
+// NormalizeEndpoint returns the API server endpoint as https URL with a port.
+func NormalizeEndpoint(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		u, err = url.Parse("https://" + endpoint)
+	}
+	if err != nil || u.Host == "" || u.Scheme != "https" || (u.Path != "" && u.Path != "/") {
+		return "", fmt.Errorf("Invalid API server endpoint %q, expected https://<host>[:<port>]", endpoint)
+	}
+	host := u.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "443")
+	}
+	return "https://" + host, nil
+}
+
+// SetServer points all clusters of the kubeconfig to the server.
+func SetServer(data []byte, server string) ([]byte, error) {
+	config, err := clientcmd.Load(data)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse kubeconfig: %v", err)
+	}
+	for _, cluster := range config.Clusters {
+		cluster.Server = server
+	}
+	return clientcmd.Write(*config)
+}
+
+// Validate checks that the current context of the kubeconfig names a
+// cluster with a server and a user.
+func Validate(data []byte) error {
+	config, err := clientcmd.Load(data)
+	if err != nil {
+		return fmt.Errorf("Failed to parse kubeconfig: %v", err)
+	}
+	context, ok := config.Contexts[config.CurrentContext]
+	if !ok {
+		return fmt.Errorf("Invalid kubeconfig: the current context %q does not exist", config.CurrentContext)
+	}
+	cluster, ok := config.Clusters[context.Cluster]
+	if !ok || cluster.Server == "" {
+		return fmt.Errorf("Invalid kubeconfig: the cluster %q has no server", context.Cluster)
+	}
+	if _, ok := config.AuthInfos[context.AuthInfo]; !ok {
+		return fmt.Errorf("Invalid kubeconfig: the user %q does not exist", context.AuthInfo)
+	}
+	return nil
+}
+
+// Token returns the cluster and the bearer token of the current context of
+// the kubeconfig, the token is empty for other credentials.
+func Token(data []byte) (Cluster, string, error) {
+	if err := Validate(data); err != nil {
+		return Cluster{}, "", err
+	}
+	config, err := clientcmd.Load(data)
+	if err != nil {
+		return Cluster{}, "", fmt.Errorf("Failed to parse kubeconfig: %v", err)
+	}
+	context := config.Contexts[config.CurrentContext]
+	cluster := Cluster{Server: config.Clusters[context.Cluster].Server, CAData: config.Clusters[context.Cluster].CertificateAuthorityData}
+	if len(cluster.CAData) == 0 && config.Clusters[context.Cluster].CertificateAuthority != "" {
+		if cluster.CAData, err = ioutil.ReadFile(config.Clusters[context.Cluster].CertificateAuthority); err != nil {
+			return cluster, "", fmt.Errorf("Failed to read cluster CA: %v", err)
+		}
+	}
+	return cluster, config.AuthInfos[context.AuthInfo].Token, nil
+}
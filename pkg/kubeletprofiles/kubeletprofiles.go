@@ -0,0 +1,221 @@
+package kubeletprofiles
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ghodss/yaml"
+)
+
+// Profile is a named set of kubelet settings, so the nodes of a pool share
+// them instead of being tuned one by one. Unset fields keep the defaults of
+// the kubelet and of the reservation of kube-machine.
+type Profile struct {
+	MaxPods int `json:"maxPods,omitempty"`
+	// PodsPerCPU scales the pods with the CPUs of the machine, the smaller
+	// of it and MaxPods applies.
+	PodsPerCPU int `json:"podsPerCPU,omitempty"`
+	// EvictionHard replaces the eviction thresholds derived from the
+	// capacity of the machine, e.g. memory.available: 100Mi.
+	EvictionHard            map[string]string `json:"evictionHard,omitempty"`
+	EvictionSoft            map[string]string `json:"evictionSoft,omitempty"`
+	EvictionSoftGracePeriod map[string]string `json:"evictionSoftGracePeriod,omitempty"`
+	// ImageGCHighThreshold and ImageGCLowThreshold are the disk usage in
+	// percent at which images are garbage collected and down to which.
+	ImageGCHighThreshold int   `json:"imageGCHighThreshold,omitempty"`
+	ImageGCLowThreshold  int   `json:"imageGCLowThreshold,omitempty"`
+	SerializeImagePulls  *bool `json:"serializeImagePulls,omitempty"`
+}
+
+// File holds custom profiles and the profile of each pool.
+type File struct {
+	Profiles map[string]Profile `json:"profiles"`
+	// Pools names the profile of the machines of each pool.
+	Pools map[string]string `json:"pools,omitempty"`
+}
+
+var (
+	yes = true
+	no  = false
+
+	// Builtin are the profiles available without a profiles file.
+	Builtin = map[string]Profile{
+		// high-density packs many small pods on large machines.
+		"high-density": {
+			MaxPods:              250,
+			PodsPerCPU:           20,
+			ImageGCHighThreshold: 80,
+			ImageGCLowThreshold:  70,
+			SerializeImagePulls:  &no,
+		},
+		// burst starts many pods at once, e.g. of batch jobs, and evicts
+		// softly before the hard thresholds are reached.
+		"burst": {
+			MaxPods:                 110,
+			EvictionSoft:            map[string]string{"memory.available": "10%"},
+			EvictionSoftGracePeriod: map[string]string{"memory.available": "1m30s"},
+			SerializeImagePulls:     &no,
+		},
+		// edge-low-memory keeps small machines responsive.
+		"edge-low-memory": {
+			MaxPods:              30,
+			EvictionHard:         map[string]string{"memory.available": "100Mi", "nodefs.available": "5%", "imagefs.available": "10%"},
+			ImageGCHighThreshold: 70,
+			ImageGCLowThreshold:  50,
+			SerializeImagePulls:  &yes,
+		},
+	}
+
+	signals = map[string]bool{
+		"memory.available":   true,
+		"nodefs.available":   true,
+		"nodefs.inodesFree":  true,
+		"imagefs.available":  true,
+		"imagefs.inodesFree": true,
+	}
+
+	mu      sync.Mutex
+	current = &File{Profiles: Builtin}
+)
+
+// Configure adds the profiles of the YAML or JSON file to the built-in
+// profiles, overriding them for the same name.
+func Configure(path string) error {
+	f := &File{Profiles: map[string]Profile{}}
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := yaml.Unmarshal(data, f); err != nil {
+			return fmt.Errorf("Failed to parse kubelet profiles %s: %v", path, err)
+		}
+	}
+	custom := f.Profiles
+	f.Profiles = map[string]Profile{}
+	for name, p := range Builtin {
+		f.Profiles[name] = p
+	}
+	for name, p := range custom {
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("Invalid kubelet profile %s: %v", name, err)
+		}
+		f.Profiles[name] = p
+	}
+	for pool, name := range f.Pools {
+		if _, ok := f.Profiles[name]; !ok {
+			return fmt.Errorf("Unknown kubelet profile %s of pool %s", name, pool)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	current = f
+	return nil
+}
+
+// Names returns the names of the profiles.
+func Names() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	var names []string
+	for name := range current.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns the profile of the name, the empty profile if the name is
+// empty.
+func Get(name string) (Profile, error) {
+	if name == "" {
+		return Profile{}, nil
+	}
+	mu.Lock()
+	p, ok := current.Profiles[name]
+	mu.Unlock()
+	if !ok {
+		return p, fmt.Errorf("Unknown kubelet profile %q, expected one of %s", name, strings.Join(Names(), ", "))
+	}
+	return p, nil
+}
+
+// Select returns the name of the profile of a machine, the given one or the
+// one of its pool. It is empty if there is none.
+func Select(profile, pool string) string {
+	if profile != "" || pool == "" {
+		return profile
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return current.Pools[pool]
+}
+
+// Validate checks the eviction signals and the image GC thresholds.
+func (p Profile) Validate() error {
+	if p.MaxPods < 0 || p.PodsPerCPU < 0 {
+		return fmt.Errorf("maxPods and podsPerCPU must not be negative")
+	}
+	for _, thresholds := range []map[string]string{p.EvictionHard, p.EvictionSoft, p.EvictionSoftGracePeriod} {
+		for signal := range thresholds {
+			if !signals[signal] {
+				return fmt.Errorf("unknown eviction signal %q", signal)
+			}
+		}
+	}
+	for signal := range p.EvictionSoft {
+		if _, ok := p.EvictionSoftGracePeriod[signal]; !ok {
+			return fmt.Errorf("soft eviction threshold %s has no grace period", signal)
+		}
+	}
+	high, low := p.ImageGCHighThreshold, p.ImageGCLowThreshold
+	if high < 0 || high > 100 || low < 0 || low > 100 || (high > 0 && low >= high) {
+		return fmt.Errorf("image GC thresholds must be percentages with the low below the high one")
+	}
+	return nil
+}
+
+// KubeletFlags returns the flags of the profile for a machine with the CPUs,
+// 0 if they are unknown.
+func (p Profile) KubeletFlags(cpus int) []string {
+	var flags []string
+	maxPods := p.MaxPods
+	if p.PodsPerCPU > 0 && cpus > 0 && (maxPods == 0 || p.PodsPerCPU*cpus < maxPods) {
+		maxPods = p.PodsPerCPU * cpus
+	}
+	if maxPods > 0 {
+		flags = append(flags, "--max-pods="+strconv.Itoa(maxPods))
+	}
+	if len(p.EvictionHard) > 0 {
+		flags = append(flags, "--eviction-hard="+thresholds(p.EvictionHard, "<"))
+	}
+	if len(p.EvictionSoft) > 0 {
+		flags = append(flags,
+			"--eviction-soft="+thresholds(p.EvictionSoft, "<"),
+			"--eviction-soft-grace-period="+thresholds(p.EvictionSoftGracePeriod, "="))
+	}
+	if p.ImageGCHighThreshold > 0 {
+		flags = append(flags, "--image-gc-high-threshold="+strconv.Itoa(p.ImageGCHighThreshold))
+	}
+	if p.ImageGCLowThreshold > 0 {
+		flags = append(flags, "--image-gc-low-threshold="+strconv.Itoa(p.ImageGCLowThreshold))
+	}
+	if p.SerializeImagePulls != nil {
+		flags = append(flags, "--serialize-image-pulls="+strconv.FormatBool(*p.SerializeImagePulls))
+	}
+	return flags
+}
+
+func thresholds(values map[string]string, operator string) string {
+	var pairs []string
+	for signal, value := range values {
+		pairs = append(pairs, signal+operator+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
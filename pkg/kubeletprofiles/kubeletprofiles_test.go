@@ -0,0 +1,94 @@
+package kubeletprofiles
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestKubeletFlags(t *testing.T) {
+	for _, test := range []struct {
+		profile  string
+		cpus     int
+		expected []string
+	}{
+		{"high-density", 4, []string{"--max-pods=80", "--image-gc-high-threshold=80", "--image-gc-low-threshold=70", "--serialize-image-pulls=false"}},
+		{"high-density", 32, []string{"--max-pods=250", "--image-gc-high-threshold=80", "--image-gc-low-threshold=70", "--serialize-image-pulls=false"}},
+		{"high-density", 0, []string{"--max-pods=250", "--image-gc-high-threshold=80", "--image-gc-low-threshold=70", "--serialize-image-pulls=false"}},
+		{"burst", 2, []string{"--max-pods=110", "--eviction-soft=memory.available<10%", "--eviction-soft-grace-period=memory.available=1m30s", "--serialize-image-pulls=false"}},
+		{"edge-low-memory", 1, []string{
+			"--max-pods=30",
+			"--eviction-hard=imagefs.available<10%,memory.available<100Mi,nodefs.available<5%",
+			"--image-gc-high-threshold=70", "--image-gc-low-threshold=50", "--serialize-image-pulls=true",
+		}},
+		{"", 4, nil},
+	} {
+		p, err := Get(test.profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if flags := p.KubeletFlags(test.cpus); !reflect.DeepEqual(flags, test.expected) {
+			t.Errorf("%s with %d CPUs: expected %v, got %v", test.profile, test.cpus, test.expected, flags)
+		}
+	}
+	if _, err := Get("unknown"); err == nil {
+		t.Error("Expected unknown profile to be refused")
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeletprofiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer Configure("")
+
+	path := filepath.Join(dir, "profiles.yaml")
+	write := func(data string) {
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`
+profiles:
+  gpu:
+    maxPods: 40
+    evictionHard: {memory.available: 1Gi}
+  burst:
+    maxPods: 200
+pools:
+  gpu-workers: gpu
+  batch: burst
+`)
+	if err := Configure(path); err != nil {
+		t.Fatal(err)
+	}
+	if name := Select("", "gpu-workers"); name != "gpu" {
+		t.Errorf("Expected the profile of the pool, got %q", name)
+	}
+	if name := Select("edge-low-memory", "gpu-workers"); name != "edge-low-memory" {
+		t.Errorf("Expected the given profile to win, got %q", name)
+	}
+	if p, err := Get("burst"); err != nil || p.MaxPods != 200 {
+		t.Errorf("Expected the custom burst profile, got %+v, %v", p, err)
+	}
+	if _, err := Get("high-density"); err != nil {
+		t.Errorf("Expected the built-in profiles to be kept: %v", err)
+	}
+
+	for _, invalid := range []string{
+		"profiles:\n  p: {evictionHard: {memory.free: 1Gi}}\n",
+		"profiles:\n  p: {evictionSoft: {memory.available: 10%}}\n",
+		"profiles:\n  p: {imageGCHighThreshold: 70, imageGCLowThreshold: 80}\n",
+		"pools:\n  workers: unknown\n",
+	} {
+		write(invalid)
+		if err := Configure(path); err == nil {
+			t.Errorf("Expected %q to be refused", invalid)
+		}
+	}
+}
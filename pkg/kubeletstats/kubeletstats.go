@@ -0,0 +1,148 @@
+// Package kubeletstats reads the restart statistics of the kubelet unit
+// from systemd, so crash-looping kubelets are noticed before their node
+// flaps NotReady.
+package kubeletstats
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Command prints the unit properties Parse reads and the UTC offset of the
+// node, as systemctl shows the timestamps in the zone of the node with an
+// abbreviation Go can't resolve. NRestarts needs systemd 235 or newer, it
+// is missing on older nodes.
+const Command = "systemctl show kubelet --property=ActiveState,SubState,NRestarts,Result,ExecMainCode,ExecMainStatus,ActiveEnterTimestamp,ExecMainExitTimestamp && date +UTCOffset=%z"
+
+// The kubelet is crash-looping with this many restarts since it was last
+// active for longer than the window.
+const (
+	CrashLoopRestarts = 3
+	CrashLoopWindow   = 10 * time.Minute
+)
+
+// timestampLayout is the layout of the timestamps of systemctl show, which
+// are followed by the abbreviation of the zone.
+const timestampLayout = "Mon 2006-01-02 15:04:05"
+
+// Stats are the restart statistics of the kubelet unit.
+type Stats struct {
+	ActiveState string
+	SubState    string
+	// Restarts counts the automatic restarts of the unit since it was
+	// started manually, it is -1 if systemd doesn't count them.
+	Restarts int
+	// Result is the result of the last exit, e.g. success, exit-code,
+	// signal or core-dump.
+	Result string
+	// ExitCode and ExitStatus are the child code, e.g. exited or killed,
+	// and the status or signal of the last exit of the kubelet.
+	ExitCode    string
+	ExitStatus  int
+	ActiveSince time.Time
+	LastExit    time.Time
+}
+
+// Parse reads the output of Command.
+func Parse(out string) (Stats, error) {
+	properties := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if i := strings.Index(line, "="); i > 0 {
+			properties[line[:i]] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	if properties["ActiveState"] == "" {
+		return Stats{}, fmt.Errorf("Failed to read the kubelet unit state from %q", out)
+	}
+
+	s := Stats{
+		ActiveState: properties["ActiveState"],
+		SubState:    properties["SubState"],
+		Restarts:    -1,
+		Result:      properties["Result"],
+		ExitCode:    properties["ExecMainCode"],
+	}
+	if v, ok := properties["NRestarts"]; ok {
+		restarts, err := strconv.Atoi(v)
+		if err != nil {
+			return Stats{}, fmt.Errorf("Failed to parse the kubelet restarts %q: %v", v, err)
+		}
+		s.Restarts = restarts
+	}
+	if v := properties["ExecMainStatus"]; v != "" {
+		status, err := strconv.Atoi(v)
+		if err != nil {
+			return Stats{}, fmt.Errorf("Failed to parse the kubelet exit status %q: %v", v, err)
+		}
+		s.ExitStatus = status
+	}
+	zone := time.UTC
+	if v := properties["UTCOffset"]; v != "" {
+		offset, err := time.Parse("-0700", v)
+		if err != nil {
+			return Stats{}, fmt.Errorf("Failed to parse the UTC offset %q: %v", v, err)
+		}
+		_, seconds := offset.Zone()
+		zone = time.FixedZone(v, seconds)
+	}
+	// Timestamps are empty if the event didn't happen yet.
+	s.ActiveSince = parseTimestamp(properties["ActiveEnterTimestamp"], zone)
+	s.LastExit = parseTimestamp(properties["ExecMainExitTimestamp"], zone)
+	return s, nil
+}
+
+// parseTimestamp parses a timestamp of systemctl show in the zone, UTC
+// timestamps are always in UTC. It returns the zero time for empty or
+// invalid timestamps.
+func parseTimestamp(v string, zone *time.Location) time.Time {
+	fields := strings.Fields(v)
+	if len(fields) != 4 {
+		return time.Time{}
+	}
+	if fields[3] == "UTC" {
+		zone = time.UTC
+	}
+	t, _ := time.ParseInLocation(timestampLayout, strings.Join(fields[:3], " "), zone)
+	return t
+}
+
+// LastExitReason describes why the kubelet last exited, it is empty if it
+// didn't exit yet.
+func (s Stats) LastExitReason() string {
+	if s.LastExit.IsZero() {
+		return ""
+	}
+	switch s.ExitCode {
+	case "killed", "dumped":
+		return fmt.Sprintf("%s by signal %d (%s)", s.ExitCode, s.ExitStatus, s.Result)
+	case "exited":
+		return fmt.Sprintf("exited with status %d (%s)", s.ExitStatus, s.Result)
+	}
+	return s.Result
+}
+
+// CrashLooping returns whether systemd is restarting the kubelet or it
+// restarted repeatedly without staying active for the crash loop window.
+func (s Stats) CrashLooping(now time.Time) bool {
+	if s.SubState == "auto-restart" {
+		return true
+	}
+	return s.Restarts >= CrashLoopRestarts && !s.ActiveSince.IsZero() && now.Sub(s.ActiveSince) < CrashLoopWindow
+}
+
+func (s Stats) String() string {
+	restarts := "unknown restarts"
+	if s.Restarts >= 0 {
+		restarts = fmt.Sprintf("%d restarts", s.Restarts)
+	}
+	str := fmt.Sprintf("%s (%s), %s", s.ActiveState, s.SubState, restarts)
+	if !s.ActiveSince.IsZero() && s.ActiveState == "active" {
+		str += ", active since " + s.ActiveSince.UTC().Format(time.RFC3339)
+	}
+	if reason := s.LastExitReason(); reason != "" {
+		str += fmt.Sprintf(", last exit %s: %s", s.LastExit.UTC().Format(time.RFC3339), reason)
+	}
+	return str
+}
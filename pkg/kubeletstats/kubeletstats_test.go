@@ -0,0 +1,64 @@
+package kubeletstats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	out := `ActiveState=active
+SubState=running
+NRestarts=4
+Result=success
+ExecMainCode=killed
+ExecMainStatus=9
+ActiveEnterTimestamp=Fri 2026-10-16 10:05:00 UTC
+ExecMainExitTimestamp=Fri 2026-10-16 10:04:50 UTC
+`
+	s, err := Parse(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Restarts != 4 || s.ExitStatus != 9 || s.ActiveSince.IsZero() {
+		t.Errorf("Unexpected stats %+v", s)
+	}
+	if reason := s.LastExitReason(); reason != "killed by signal 9 (success)" {
+		t.Errorf("Unexpected exit reason %q", reason)
+	}
+	if !s.CrashLooping(s.ActiveSince.Add(time.Minute)) {
+		t.Error("Expected a crash loop shortly after the last restart")
+	}
+	if s.CrashLooping(s.ActiveSince.Add(time.Hour)) {
+		t.Error("Expected no crash loop after an hour active")
+	}
+	if str := s.String(); str != "active (running), 4 restarts, active since 2026-10-16T10:05:00Z, last exit 2026-10-16T10:04:50Z: killed by signal 9 (success)" {
+		t.Errorf("Unexpected description %q", str)
+	}
+}
+
+func TestParseZone(t *testing.T) {
+	s, err := Parse("ActiveState=active\nSubState=running\nActiveEnterTimestamp=Fri 2026-10-16 12:05:00 CEST\nExecMainExitTimestamp=\nUTCOffset=+0200\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := time.Date(2026, 10, 16, 10, 5, 0, 0, time.UTC); !s.ActiveSince.Equal(expected) || !s.LastExit.IsZero() {
+		t.Errorf("Expected active since %s, got %+v", expected, s)
+	}
+	if _, err := Parse("ActiveState=active\nUTCOffset=CEST\n"); err == nil {
+		t.Error("Expected an error for an invalid offset")
+	}
+}
+
+func TestParseOldSystemd(t *testing.T) {
+	s, err := Parse("ActiveState=activating\nSubState=auto-restart\nResult=exit-code\nExecMainCode=exited\nExecMainStatus=255\nActiveEnterTimestamp=\nExecMainExitTimestamp=Fri 2026-10-16 10:04:50 UTC\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Restarts != -1 || !s.CrashLooping(time.Now()) || s.LastExitReason() != "exited with status 255 (exit-code)" {
+		t.Errorf("Unexpected stats %+v", s)
+	}
+
+	if _, err := Parse("Failed to connect to bus"); err == nil {
+		t.Error("Expected an error without unit state")
+	}
+}
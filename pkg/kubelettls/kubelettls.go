@@ -0,0 +1,172 @@
+package kubelettls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPort is the port the kubelet serves its API on.
+	DefaultPort = 10250
+
+	FindingUnreachable   = "unreachable"
+	FindingUntrusted     = "untrusted"
+	FindingNameMismatch  = "name-mismatch"
+	FindingExpired       = "expired"
+	FindingExpiresSoon   = "expires-soon"
+	FindingAnonymousAuth = "anonymous-auth"
+)
+
+// Timeout limits connecting to a kubelet and each of its requests.
+var Timeout = 10 * time.Second
+
+// Finding is a problem of the TLS setup of a kubelet.
+type Finding struct {
+	Kind   string `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func (f Finding) String() string {
+	if f.Detail == "" {
+		return f.Kind
+	}
+	return f.Kind + ": " + f.Detail
+}
+
+// Result is the verification of the kubelet of a node.
+type Result struct {
+	Node     string    `json:"node"`
+	Address  string    `json:"address"`
+	NotAfter time.Time `json:"notAfter,omitempty"`
+	Findings []Finding `json:"findings,omitempty"`
+}
+
+// NeedsRemediation returns whether the kubelet was reached and its TLS
+// setup has problems new serving certificates fix.
+func (r Result) NeedsRemediation() bool {
+	for _, f := range r.Findings {
+		if f.Kind == FindingUnreachable {
+			return false
+		}
+	}
+	return len(r.Findings) > 0
+}
+
+// ParseCA returns the pool of the PEM encoded CA certificates.
+func ParseCA(data []byte) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("Failed to parse the cluster CA: no PEM encoded certificates")
+	}
+	return pool, nil
+}
+
+// Verify connects to the kubelet at the address, checks its serving
+// certificate chain against the CA and whether it serves anonymous
+// requests. Certificates expiring within the warning period are reported.
+func Verify(node, address string, ca *x509.CertPool, now time.Time, warning time.Duration) Result {
+	r := Result{Node: node, Address: address}
+	dialer := &net.Dialer{Timeout: Timeout}
+	// The chain is verified below so expired and untrusted certificates
+	// are reported instead of failing the connection.
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		r.Findings = append(r.Findings, Finding{Kind: FindingUnreachable, Detail: err.Error()})
+		return r
+	}
+	chain := conn.ConnectionState().PeerCertificates
+	conn.Close()
+	if len(chain) == 0 {
+		r.Findings = append(r.Findings, Finding{Kind: FindingUntrusted, Detail: "no serving certificate"})
+		return r
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	r.NotAfter = chain[0].NotAfter
+	r.Findings = append(r.Findings, CheckChain(chain, ca, host, now, warning)...)
+
+	anonymous, err := servesAnonymous(address)
+	if err != nil {
+		r.Findings = append(r.Findings, Finding{Kind: FindingUnreachable, Detail: err.Error()})
+	} else if anonymous {
+		r.Findings = append(r.Findings, Finding{Kind: FindingAnonymousAuth, Detail: "the kubelet serves anonymous requests"})
+	}
+	return r
+}
+
+// CheckChain checks the serving certificate chain of a kubelet, the first
+// certificate is the one of the kubelet.
+func CheckChain(chain []*x509.Certificate, ca *x509.CertPool, host string, now time.Time, warning time.Duration) []Finding {
+	var findings []Finding
+	leaf := chain[0]
+	verifyTime := now
+	switch {
+	case now.After(leaf.NotAfter):
+		findings = append(findings, Finding{Kind: FindingExpired, Detail: "expired on " + leaf.NotAfter.Format("2006-01-02")})
+		// The trust of the chain is checked at a time it was valid.
+		verifyTime = leaf.NotAfter.Add(-time.Second)
+	case now.Add(warning).After(leaf.NotAfter):
+		findings = append(findings, Finding{Kind: FindingExpiresSoon, Detail: "expires on " + leaf.NotAfter.Format("2006-01-02")})
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         ca,
+		Intermediates: intermediates,
+		CurrentTime:   verifyTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		detail := err.Error()
+		if leaf.Issuer.CommonName == leaf.Subject.CommonName {
+			detail = "self-signed certificate " + leaf.Subject.CommonName
+		}
+		findings = append(findings, Finding{Kind: FindingUntrusted, Detail: detail})
+	}
+	if err := leaf.VerifyHostname(host); err != nil {
+		findings = append(findings, Finding{Kind: FindingNameMismatch, Detail: fmt.Sprintf("not valid for %s", host)})
+	}
+	return findings
+}
+
+// servesAnonymous returns whether the kubelet authenticates requests
+// without credentials as anonymous. A forbidden response is an
+// authenticated anonymous request which was not authorized.
+func servesAnonymous(address string) (bool, error) {
+	client := &http.Client{
+		Timeout: Timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	resp, err := client.Get("https://" + address + "/pods")
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusForbidden, nil
+}
+
+// Describe returns the findings of a result for humans.
+func Describe(r Result) string {
+	if len(r.Findings) == 0 {
+		return "ok"
+	}
+	var findings []string
+	for _, f := range r.Findings {
+		findings = append(findings, f.String())
+	}
+	return strings.Join(findings, ", ")
+}
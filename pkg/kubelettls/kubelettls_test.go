@@ -0,0 +1,118 @@
+package kubelettls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func newCertificate(t *testing.T, name string, notAfter time.Time, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(now.UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func kinds(findings []Finding) []string {
+	var kinds []string
+	for _, f := range findings {
+		kinds = append(kinds, f.Kind)
+	}
+	return kinds
+}
+
+func TestCheckChain(t *testing.T) {
+	ca, caKey := newCertificate(t, "cluster-ca", now.AddDate(10, 0, 0), nil, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	valid, _ := newCertificate(t, "node-1", now.AddDate(1, 0, 0), ca, caKey)
+	expiring, _ := newCertificate(t, "node-1", now.AddDate(0, 0, 10), ca, caKey)
+	expired, _ := newCertificate(t, "node-1", now.Add(-time.Minute), ca, caKey)
+	selfSigned, _ := newCertificate(t, "node-1@1488369600", now.AddDate(1, 0, 0), nil, nil)
+
+	for _, test := range []struct {
+		cert     *x509.Certificate
+		host     string
+		expected []string
+	}{
+		{valid, "127.0.0.1", nil},
+		{valid, "10.0.0.1", []string{FindingNameMismatch}},
+		{expiring, "127.0.0.1", []string{FindingExpiresSoon}},
+		{expired, "127.0.0.1", []string{FindingExpired}},
+		{selfSigned, "127.0.0.1", []string{FindingUntrusted}},
+	} {
+		findings := CheckChain([]*x509.Certificate{test.cert}, pool, test.host, now, 30*24*time.Hour)
+		if !reflect.DeepEqual(kinds(findings), test.expected) {
+			t.Errorf("%s for %s: expected %v, got %+v", test.cert.Subject.CommonName, test.host, test.expected, findings)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ca, caKey := newCertificate(t, "cluster-ca", now.AddDate(10, 0, 0), nil, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	cert, key := newCertificate(t, "node-1", now.AddDate(1, 0, 0), ca, caKey)
+
+	status := http.StatusUnauthorized
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}}}
+	server.StartTLS()
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "https://")
+
+	r := Verify("node-1", address, pool, now, 0)
+	if len(r.Findings) != 0 || r.NeedsRemediation() || !r.NotAfter.Equal(cert.NotAfter) {
+		t.Errorf("Expected the kubelet to pass, got %+v", r)
+	}
+
+	status = http.StatusForbidden
+	r = Verify("node-1", address, pool, now, 0)
+	if !reflect.DeepEqual(kinds(r.Findings), []string{FindingAnonymousAuth}) || !r.NeedsRemediation() {
+		t.Errorf("Expected anonymous auth to be reported, got %+v", r)
+	}
+
+	server.Close()
+	r = Verify("node-1", address, pool, now, 0)
+	if !reflect.DeepEqual(kinds(r.Findings), []string{FindingUnreachable}) || r.NeedsRemediation() {
+		t.Errorf("Expected an unreachable kubelet not to be remediated, got %+v", r)
+	}
+}
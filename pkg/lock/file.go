@@ -0,0 +1,129 @@
+package lock
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileLocker keeps the locks as files in a directory. The lock files are
+// only read and changed while holding an exclusive file lock on them, so
+// concurrent processes can't both take over a stale lock.
+type FileLocker struct {
+	Dir string
+}
+
+func (l FileLocker) path(machine string) string {
+	return filepath.Join(l.Dir, machine+".lock")
+}
+
+func (l FileLocker) TryLock(machine string, r Record, now time.Time) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	f, unlock, err := l.open(machine)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	existing, locked, err := read(f)
+	if err != nil {
+		return err
+	}
+	if locked && existing.Holder != r.Holder && !existing.Stale(now) {
+		return ErrLocked{Machine: machine, Record: existing}
+	}
+
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(data, 0); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func (l FileLocker) Unlock(machine, holder string) error {
+	if _, err := os.Stat(l.path(machine)); os.IsNotExist(err) {
+		return nil
+	}
+	f, unlock, err := l.open(machine)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	existing, locked, err := read(f)
+	if err != nil {
+		return err
+	}
+	if locked && existing.Holder != holder {
+		return nil
+	}
+	err = os.Remove(l.path(machine))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// open opens the lock file of the machine and takes the file lock on it.
+// Unlock removes lock files, so opening is retried if the file was removed
+// before the file lock was taken.
+func (l FileLocker) open(machine string) (*os.File, func(), error) {
+	if err := os.MkdirAll(l.Dir, 0700); err != nil {
+		return nil, nil, err
+	}
+	for {
+		f, err := os.OpenFile(l.path(machine), os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, nil, err
+		}
+		unlock, err := flock(f)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		release := func() {
+			unlock()
+			f.Close()
+		}
+
+		opened, err := f.Stat()
+		if err != nil {
+			release()
+			return nil, nil, err
+		}
+		current, err := os.Stat(l.path(machine))
+		if err == nil && os.SameFile(opened, current) {
+			return f, release, nil
+		}
+		release()
+		if err != nil && !os.IsNotExist(err) {
+			return nil, nil, err
+		}
+	}
+}
+
+// read returns the record of the lock file, an empty file isn't locked. A
+// record which can't be parsed was left by a holder which died writing it,
+// so it isn't locked either.
+func read(f *os.File) (Record, bool, error) {
+	r := Record{}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return r, false, err
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil || len(data) == 0 {
+		return r, false, err
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return Record{}, false, nil
+	}
+	return r, true, nil
+}
@@ -0,0 +1,12 @@
+package lock
+
+import (
+	"errors"
+	"os"
+)
+
+// flock isn't supported on Windows, the machines are locked through the node
+// store there.
+func flock(f *os.File) (func(), error) {
+	return nil, errors.New("Failed to lock: the file store doesn't support locks on Windows")
+}
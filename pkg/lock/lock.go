@@ -0,0 +1,131 @@
+package lock
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// DefaultTTL is the time after the last renewal a lock is considered stale,
+// e.g. because the holding process was killed.
+const DefaultTTL = 5 * time.Minute
+
+// renewInterval is how often the locks are renewed, replaced in tests.
+var renewInterval = DefaultTTL / 3
+
+// Record describes who holds the lock of a machine for which operation.
+type Record struct {
+	Holder     string    `json:"holder"`
+	Operation  string    `json:"operation"`
+	Acquired   time.Time `json:"acquired"`
+	Renewed    time.Time `json:"renewed"`
+	TTLSeconds int       `json:"ttlSeconds"`
+}
+
+// Stale reports whether the holder stopped renewing the lock.
+func (r Record) Stale(now time.Time) bool {
+	return now.After(r.Renewed.Add(time.Duration(r.TTLSeconds) * time.Second))
+}
+
+// ErrLocked is returned if another holder has the lock of the machine.
+type ErrLocked struct {
+	Machine string
+	Record  Record
+}
+
+func (e ErrLocked) Error() string {
+	return fmt.Sprintf("Machine %s is locked by %s running %s since %s", e.Machine, e.Record.Holder, e.Record.Operation, e.Record.Acquired.Format("2006-01-02 15:04:05 MST"))
+}
+
+// Locker stores advisory locks of the machines.
+type Locker interface {
+	// TryLock stores the record as lock of the machine. It fails with
+	// ErrLocked if another holder has a lock which isn't stale, a lock of
+	// the same holder is renewed.
+	TryLock(machine string, r Record, now time.Time) error
+	// Unlock removes the lock of the machine if the holder has it.
+	Unlock(machine, holder string) error
+}
+
+// Holder identifies this process as holder of locks.
+func Holder(user string) string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s@%s/%d", user, host, os.Getpid())
+}
+
+// Acquire locks the machine for the operation and renews the lock until the
+// returned release function is called. If renewing fails the lock isn't
+// renewed anymore and lost is called, if not nil, as another holder takes
+// the lock once it is stale: the operation has to be aborted.
+func Acquire(l Locker, machine, operation, holder string, lost func(error)) (func(), error) {
+	now := time.Now().UTC()
+	r := Record{
+		Holder:     holder,
+		Operation:  operation,
+		Acquired:   now,
+		Renewed:    now,
+		TTLSeconds: int(DefaultTTL / time.Second),
+	}
+	if err := l.TryLock(machine, r, now); err != nil {
+		return nil, err
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(renewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case t := <-ticker.C:
+				r.Renewed = t.UTC()
+				if err := l.TryLock(machine, r, r.Renewed); err != nil {
+					log.Errorf("Failed to renew the lock of %s, aborting: %v", machine, err)
+					if lost != nil {
+						lost(err)
+					}
+					return
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			<-done
+			if err := l.Unlock(machine, holder); err != nil {
+				log.Warnf("Failed to release the lock of %s: %v", machine, err)
+			}
+		})
+	}, nil
+}
+
+// AcquireAll locks all machines or none, lost is called like for Acquire.
+func AcquireAll(l Locker, machines []string, operation, holder string, lost func(error)) (func(), error) {
+	var releases []func()
+	releaseAll := func() {
+		for _, release := range releases {
+			release()
+		}
+	}
+	for _, machine := range machines {
+		release, err := Acquire(l, machine, operation, holder, lost)
+		if err != nil {
+			releaseAll()
+			return nil, err
+		}
+		releases = append(releases, release)
+	}
+	return releaseAll, nil
+}
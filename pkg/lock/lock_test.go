@@ -0,0 +1,161 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFileLocker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	l := FileLocker{Dir: dir}
+
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	alice := Record{Holder: "alice", Operation: "upgrade", Acquired: now, Renewed: now, TTLSeconds: 60}
+	bob := Record{Holder: "bob", Operation: "rm", Acquired: now, Renewed: now, TTLSeconds: 60}
+
+	if err := l.TryLock("node-1", alice, now); err != nil {
+		t.Fatal(err)
+	}
+	err = l.TryLock("node-1", bob, now.Add(30*time.Second))
+	if locked, ok := err.(ErrLocked); !ok || locked.Record.Holder != "alice" {
+		t.Fatalf("expected the lock of alice, got %v", err)
+	}
+	if err := l.TryLock("node-2", bob, now); err != nil {
+		t.Errorf("expected the locks to be per machine, got %v", err)
+	}
+
+	alice.Renewed = now.Add(50 * time.Second)
+	if err := l.TryLock("node-1", alice, alice.Renewed); err != nil {
+		t.Fatalf("expected the holder to renew the lock, got %v", err)
+	}
+	if err := l.TryLock("node-1", bob, now.Add(100*time.Second)); err == nil {
+		t.Fatal("expected the renewed lock not to be stale")
+	}
+	bob.Renewed = now.Add(111 * time.Second)
+	if err := l.TryLock("node-1", bob, bob.Renewed); err != nil {
+		t.Fatalf("expected a stale lock to be taken over, got %v", err)
+	}
+
+	if err := l.Unlock("node-1", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.TryLock("node-1", alice, now.Add(120*time.Second)); err == nil {
+		t.Fatal("expected unlocking by another holder to keep the lock")
+	}
+	if err := l.Unlock("node-1", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.TryLock("node-1", alice, now.Add(120*time.Second)); err != nil {
+		t.Fatalf("expected the released lock to be free, got %v", err)
+	}
+}
+
+func TestFileLockerTakeOverOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	l := FileLocker{Dir: dir}
+
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := l.TryLock("node-1", Record{Holder: "dead", Renewed: now, TTLSeconds: 60}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	later := now.Add(time.Hour)
+	results := make(chan error)
+	for i := 0; i < 10; i++ {
+		go func(i int) {
+			results <- l.TryLock("node-1", Record{Holder: fmt.Sprintf("holder-%d", i), Renewed: later, TTLSeconds: 60}, later)
+		}(i)
+	}
+	taken := 0
+	for i := 0; i < 10; i++ {
+		if err := <-results; err == nil {
+			taken++
+		} else if _, ok := err.(ErrLocked); !ok {
+			t.Error(err)
+		}
+	}
+	if taken != 1 {
+		t.Errorf("expected the stale lock to be taken over once, got %d", taken)
+	}
+}
+
+func TestAcquireAll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	l := FileLocker{Dir: dir}
+
+	releaseBob, err := Acquire(l, "node-2", "rm", "bob", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AcquireAll(l, []string{"node-1", "node-2"}, "upgrade", "alice", nil); err == nil {
+		t.Fatal("expected node-2 to be locked")
+	}
+	if _, err := os.Stat(l.path("node-1")); !os.IsNotExist(err) {
+		t.Error("expected the lock of node-1 to be released")
+	}
+
+	releaseBob()
+	release, err := AcquireAll(l, []string{"node-1", "node-2"}, "upgrade", "alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	release()
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("expected no lock files after the release, got %d", len(files))
+	}
+}
+
+// failingLocker fails renewing the locks.
+type failingLocker struct {
+	locked bool
+}
+
+func (l *failingLocker) TryLock(machine string, r Record, now time.Time) error {
+	if l.locked {
+		return errors.New("node not found")
+	}
+	l.locked = true
+	return nil
+}
+
+func (l *failingLocker) Unlock(machine, holder string) error {
+	return nil
+}
+
+func TestAcquireLost(t *testing.T) {
+	defer func(interval time.Duration) { renewInterval = interval }(renewInterval)
+	renewInterval = time.Millisecond
+
+	lost := make(chan error, 1)
+	release, err := Acquire(&failingLocker{}, "node-1", "upgrade", "alice", func(err error) { lost <- err })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	select {
+	case err := <-lost:
+		if err.Error() != "node not found" {
+			t.Errorf("expected the renewal error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the failed renewal to be reported")
+	}
+}
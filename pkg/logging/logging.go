@@ -0,0 +1,165 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// output is shared by a JSONLogger and all loggers derived from it with
+// WithFields.
+type output struct {
+	mu        sync.Mutex
+	outWriter io.Writer
+	errWriter io.Writer
+	debug     bool
+	history   *log.HistoryRecorder
+}
+
+// JSONLogger writes one JSON object per line, carrying the level, the
+// message and the fields of the logger.
+type JSONLogger struct {
+	out    *output
+	fields log.Fields
+	now    func() time.Time
+}
+
+func NewJSONLogger() *JSONLogger {
+	return &JSONLogger{
+		out: &output{
+			outWriter: os.Stdout,
+			errWriter: os.Stderr,
+			history:   log.NewHistoryRecorder(),
+		},
+		fields: log.Fields{},
+		now:    time.Now,
+	}
+}
+
+// WithFields returns a logger adding the fields to the ones of l.
+func (l *JSONLogger) WithFields(fields log.Fields) log.MachineLogger {
+	merged := log.Fields{}
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &JSONLogger{out: l.out, fields: merged, now: l.now}
+}
+
+func (l *JSONLogger) write(w io.Writer, level, msg string) {
+	l.out.history.Record(msg)
+
+	entry := map[string]interface{}{}
+	for k, v := range l.fields {
+		entry[k] = v
+	}
+	entry["time"] = l.now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level
+	entry["msg"] = msg
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		data = []byte(fmt.Sprintf(`{"level":"error","msg":%q}`, "Failed to encode log entry: "+err.Error()))
+	}
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	w.Write(append(data, '\n'))
+}
+
+func (l *JSONLogger) SetDebug(debug bool) {
+	l.out.debug = debug
+}
+
+func (l *JSONLogger) SetOutWriter(out io.Writer) {
+	l.out.outWriter = out
+}
+
+func (l *JSONLogger) SetErrWriter(err io.Writer) {
+	l.out.errWriter = err
+}
+
+func (l *JSONLogger) Debug(args ...interface{}) {
+	l.debug(fmt.Sprint(args...))
+}
+
+func (l *JSONLogger) Debugf(fmtString string, args ...interface{}) {
+	l.debug(fmt.Sprintf(fmtString, args...))
+}
+
+// debug records debug lines in the history for crash reports even if they
+// are not written.
+func (l *JSONLogger) debug(msg string) {
+	if !l.out.debug {
+		l.out.history.Record(msg)
+		return
+	}
+	l.write(l.out.errWriter, "debug", msg)
+}
+
+func (l *JSONLogger) Error(args ...interface{}) {
+	l.write(l.out.errWriter, "error", fmt.Sprint(args...))
+}
+
+func (l *JSONLogger) Errorf(fmtString string, args ...interface{}) {
+	l.write(l.out.errWriter, "error", fmt.Sprintf(fmtString, args...))
+}
+
+func (l *JSONLogger) Info(args ...interface{}) {
+	l.write(l.out.outWriter, "info", fmt.Sprint(args...))
+}
+
+func (l *JSONLogger) Infof(fmtString string, args ...interface{}) {
+	l.write(l.out.outWriter, "info", fmt.Sprintf(fmtString, args...))
+}
+
+func (l *JSONLogger) Warn(args ...interface{}) {
+	l.write(l.out.outWriter, "warning", fmt.Sprint(args...))
+}
+
+func (l *JSONLogger) Warnf(fmtString string, args ...interface{}) {
+	l.write(l.out.outWriter, "warning", fmt.Sprintf(fmtString, args...))
+}
+
+func (l *JSONLogger) History() []string {
+	return l.out.history.History()
+}
+
+// NewOperationID returns a random ID correlating the log lines of one
+// operation.
+func NewOperationID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Configure sets up the logger of the given format. The commands tag the
+// lines of each operation with its own operation ID.
+func Configure(format string, debug bool) error {
+	switch format {
+	case "", FormatText:
+		return nil
+	case FormatJSON:
+		l := NewJSONLogger()
+		l.SetDebug(debug)
+		log.SetLogger(l)
+		return nil
+	}
+	return fmt.Errorf("Unknown log format %q, expected %q or %q", format, FormatText, FormatJSON)
+}
@@ -0,0 +1,64 @@
+package logging
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+func TestJSONLogger(t *testing.T) {
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+	l := NewJSONLogger()
+	l.now = func() time.Time { return time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC) }
+	l.SetOutWriter(out)
+	l.SetErrWriter(errOut)
+
+	machine := l.WithFields(log.Fields{"machine": "node-1", "operation": "abc"})
+	machine.Infof("Creating %s", "disk")
+	machine.(log.FieldLogger).WithFields(log.Fields{"step": "kubelet"}).Error("failed")
+	machine.Debug("hidden")
+
+	expectedOut := `{"level":"info","machine":"node-1","msg":"Creating disk","operation":"abc","time":"2017-03-01T12:00:00Z"}` + "\n"
+	if out.String() != expectedOut {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expectedOut, out.String())
+	}
+	expectedErr := `{"level":"error","machine":"node-1","msg":"failed","operation":"abc","step":"kubelet","time":"2017-03-01T12:00:00Z"}` + "\n"
+	if errOut.String() != expectedErr {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expectedErr, errOut.String())
+	}
+	if history := strings.Join(l.History(), "|"); history != "Creating disk|failed|hidden" {
+		t.Errorf("Unexpected history %q", history)
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	if err := Configure("xml", false); err == nil {
+		t.Fatal("Expected an error for an unknown format")
+	}
+	if err := Configure(FormatText, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPushFields(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := NewJSONLogger()
+	l.now = func() time.Time { return time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC) }
+	l.SetOutWriter(out)
+	log.SetLogger(l)
+	defer log.SetLogger(log.NewFmtMachineLogger())
+
+	restore := log.PushFields(log.Fields{"operation": "abc"})
+	log.Info("first")
+	restore()
+	log.Info("second")
+
+	expected := `{"level":"info","msg":"first","operation":"abc","time":"2017-03-01T12:00:00Z"}` + "\n" +
+		`{"level":"info","msg":"second","time":"2017-03-01T12:00:00Z"}` + "\n"
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
@@ -0,0 +1,83 @@
+package logrotate
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultMaxSize  = "50m"
+	DefaultMaxFiles = 5
+
+	// JournaldConfigPath is the drop-in overriding the journald limits.
+	JournaldConfigPath = "/etc/systemd/journald.conf.d/kube-machine.conf"
+	DaemonConfigPath   = "/etc/docker/daemon.json"
+)
+
+var sizePattern = regexp.MustCompile(`^[1-9][0-9]*[kKmMgG]?$`)
+
+// ValidateSize checks a size understood by the engine and journald, e.g.
+// "50m" or "1G".
+func ValidateSize(size string) error {
+	if !sizePattern.MatchString(size) {
+		return fmt.Errorf("Invalid size %q, expected a number with an optional k, m or g suffix", size)
+	}
+	return nil
+}
+
+// EngineFlags returns the engine flags with the log options rotating the
+// container logs. Log options set in the flags already take precedence.
+func EngineFlags(flags []string, maxSize string, maxFiles int) []string {
+	result := append([]string{}, flags...)
+	if maxSize != "" && !hasLogOpt(flags, "max-size") {
+		result = append(result, "log-opt max-size="+maxSize)
+	}
+	if maxFiles > 0 && !hasLogOpt(flags, "max-file") {
+		result = append(result, fmt.Sprintf("log-opt max-file=%d", maxFiles))
+	}
+	return result
+}
+
+func hasLogOpt(flags []string, name string) bool {
+	for _, f := range flags {
+		f = strings.TrimLeft(f, "-")
+		if !strings.HasPrefix(f, "log-opt") {
+			continue
+		}
+		opt := strings.TrimLeft(strings.TrimPrefix(f, "log-opt"), " =")
+		if strings.HasPrefix(opt, name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// JournaldConfig returns the journald drop-in limiting the disk space of
+// the journal.
+func JournaldConfig(maxUse string) string {
+	return fmt.Sprintf("[Journal]\nSystemMaxUse=%s\n", strings.ToUpper(maxUse))
+}
+
+// DaemonConfig returns the daemon.json of engines not configured by the
+// provisioner, e.g. on nodes bootstrapped from user-data. The options are
+// added to the config.
+func DaemonConfig(maxSize string, maxFiles int, options map[string]interface{}) ([]byte, error) {
+	opts := map[string]string{}
+	if maxSize != "" {
+		opts["max-size"] = maxSize
+	}
+	if maxFiles > 0 {
+		opts["max-file"] = strconv.Itoa(maxFiles)
+	}
+	config := map[string]interface{}{
+		"log-driver": "json-file",
+		"log-opts":   opts,
+	}
+	for k, v := range options {
+		config[k] = v
+	}
+	return json.MarshalIndent(config, "", "  ")
+}
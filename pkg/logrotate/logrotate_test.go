@@ -0,0 +1,67 @@
+package logrotate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEngineFlags(t *testing.T) {
+	tests := []struct {
+		description string
+		flags       []string
+		expected    []string
+	}{
+		{"no flags", nil, []string{"log-opt max-size=50m", "log-opt max-file=5"}},
+		{"other flags", []string{"log-level=debug"}, []string{"log-level=debug", "log-opt max-size=50m", "log-opt max-file=5"}},
+		{"size set", []string{"log-opt=max-size=10m"}, []string{"log-opt=max-size=10m", "log-opt max-file=5"}},
+		{"files set", []string{"log-opt max-file=2"}, []string{"log-opt max-file=2", "log-opt max-size=50m"}},
+	}
+	for _, test := range tests {
+		if flags := EngineFlags(test.flags, DefaultMaxSize, DefaultMaxFiles); !reflect.DeepEqual(flags, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.description, test.expected, flags)
+		}
+	}
+
+	if flags := EngineFlags([]string{"debug"}, "", 0); !reflect.DeepEqual(flags, []string{"debug"}) {
+		t.Errorf("expected no log options without limits, got %v", flags)
+	}
+}
+
+func TestValidateSize(t *testing.T) {
+	for _, size := range []string{"50m", "1G", "1024"} {
+		if err := ValidateSize(size); err != nil {
+			t.Errorf("%s: unexpected error %v", size, err)
+		}
+	}
+	for _, size := range []string{"", "0m", "1.5G", "10mb", "-1"} {
+		if err := ValidateSize(size); err == nil {
+			t.Errorf("%s: expected an error", size)
+		}
+	}
+}
+
+func TestJournaldConfig(t *testing.T) {
+	if c := JournaldConfig("512m"); c != "[Journal]\nSystemMaxUse=512M\n" {
+		t.Errorf("unexpected config %q", c)
+	}
+}
+
+func TestDaemonConfig(t *testing.T) {
+	data, err := DaemonConfig("10m", 3, map[string]interface{}{"exec-opts": []string{"native.cgroupdriver=systemd"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{
+  "exec-opts": [
+    "native.cgroupdriver=systemd"
+  ],
+  "log-driver": "json-file",
+  "log-opts": {
+    "max-file": "3",
+    "max-size": "10m"
+  }
+}`
+	if string(data) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, data)
+	}
+}
@@ -0,0 +1,337 @@
+// Package machine is the Go API of kube-machine. It runs the operations of
+// the CLI, so platform controllers can embed kube-machine instead of running
+// its binary.
+//
+// The exported identifiers of this package follow semantic versioning with
+// Version: within a major version they are only added, never removed or
+// changed incompatibly. The other packages of kube-machine may change with
+// any release.
+package machine
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/docker/machine/commands"
+	"github.com/docker/machine/libmachine"
+	"github.com/docker/machine/libmachine/state"
+	"github.com/kubermatic/kube-machine/pkg/deadline"
+)
+
+// Version is the semantic version of the Go API.
+const Version = "1.1.0"
+
+var errNoMachines = errors.New("No machines given")
+
+// run runs the command implementations of the CLI, replaced in tests.
+var run = commands.Run
+
+// API is the machine store and the drivers the operations work with.
+type API struct {
+	api libmachine.API
+}
+
+// NewAPI returns the API of the machines in the storage path, like
+// --storage-path, stored in the cluster of the configuration. The options
+// of the store like "dual-write-file-store" and "read-only" apply.
+func NewAPI(storagePath string, config Config) *API {
+	return &API{api: commands.NewClient(storagePath, config.options())}
+}
+
+// Close stops the driver plugins of the machines loaded by the API.
+func (a *API) Close() error {
+	return a.api.Close()
+}
+
+// Config holds the global options of the operations. Its fields map onto
+// the global flags of kube-machine, fields with their zero value keep the
+// default of the flag.
+type Config struct {
+	// Kubeconfig is the kubeconfig of the cluster, like --kubeconfig.
+	Kubeconfig string `flag:"kubeconfig"`
+	// ReadOnly refuses all changes of the machines and the cluster, like
+	// --read-only.
+	ReadOnly bool `flag:"read-only"`
+	// DualWriteFileStore saves the machines to the file store as well, like
+	// --dual-write-file-store.
+	DualWriteFileStore bool `flag:"dual-write-file-store"`
+	// IncludeControlPlane treats the nodes of the control plane as machines,
+	// like --include-control-plane.
+	IncludeControlPlane bool `flag:"include-control-plane"`
+	// NativeSSH uses the SSH client of Go instead of the ssh binary, like
+	// --native-ssh.
+	NativeSSH bool `flag:"native-ssh"`
+	// SSHCAKey is the private key of the SSH CA signing the certificates of
+	// the SSH sessions, like --ssh-ca-key.
+	SSHCAKey string `flag:"ssh-ca-key"`
+	// GithubAPIToken authenticates the requests to the Github API, like
+	// --github-api-token.
+	GithubAPIToken string `flag:"github-api-token"`
+	// DriverProfiles is the file of the driver credential profiles, like
+	// --driver-profiles.
+	DriverProfiles string `flag:"driver-profiles"`
+	// IPAM leases the static addresses of the machines, like --ipam.
+	IPAM string `flag:"ipam"`
+	// DNSConfig is the file of the zone the machines are registered in,
+	// like --dns-config.
+	DNSConfig string `flag:"dns-config"`
+	// AuditSinks record the machine mutations, like --audit-sink.
+	AuditSinks []string `flag:"audit-sink"`
+	// PricingFile overrides the prices of the cost estimates, like
+	// --pricing-file.
+	PricingFile string `flag:"pricing-file"`
+	// KubeletProfiles is the file of the custom kubelet profiles, like
+	// --kubelet-profiles.
+	KubeletProfiles string `flag:"kubelet-profiles"`
+	// APILog is the directory the cloud API calls and SSH commands are
+	// logged to, like --api-log.
+	APILog string `flag:"api-log"`
+	// Notifications is the file of the notifiers of machine events, like
+	// --notifications.
+	Notifications string `flag:"notifications"`
+	// ArtifactCache pushes the node binaries from the storage path, like
+	// --artifact-cache.
+	ArtifactCache bool `flag:"artifact-cache"`
+	// ArtifactChecksums is the file the node binaries are verified against,
+	// like --artifact-checksums.
+	ArtifactChecksums string `flag:"artifact-checksums"`
+	// RequestTimeout limits a cluster request or a driver call not waiting
+	// for the machine, like --request-timeout.
+	RequestTimeout time.Duration `flag:"request-timeout"`
+	// StepTimeout limits a provisioning step, like --step-timeout.
+	StepTimeout time.Duration `flag:"step-timeout"`
+	// BootLogLines are the lines of the boot log attached to the operation
+	// log of a machine never reachable over SSH, like --boot-log-lines.
+	BootLogLines int `flag:"boot-log-lines"`
+	// Options are the global options without a field by flag name, e.g.
+	// {"tls-ca-signer": "vault"}. The fields take precedence.
+	Options map[string]interface{}
+}
+
+func (c Config) options() map[string]interface{} {
+	options := map[string]interface{}{}
+	for k, v := range c.Options {
+		options[k] = v
+	}
+	flagValues(c, options)
+	return options
+}
+
+// Machine is a machine of the store.
+type Machine struct {
+	Name   string
+	Driver string
+	// State is the state of the VM, it is empty if Err is set.
+	State string
+	Err   error
+}
+
+// CreateOptions are the options of Create. Its fields map onto the flags
+// of kube-machine create, fields with their zero value keep the default of
+// the flag.
+type CreateOptions struct {
+	Name   string
+	Driver string
+	// DriverProfile sets the credentials of the driver from a profile of
+	// Config.DriverProfiles, like --driver-profile.
+	DriverProfile string `flag:"driver-profile"`
+	// Transport is how the machine is provisioned: ssh, cluster or talos,
+	// like --transport.
+	Transport string `flag:"transport"`
+	// EngineInstallURL installs the container engine, like
+	// --engine-install-url.
+	EngineInstallURL string `flag:"engine-install-url"`
+	// EngineOptions are flags of the engine as flag=value, like
+	// --engine-opt.
+	EngineOptions []string `flag:"engine-opt"`
+	// EngineEnv are environment variables of the engine, like --engine-env.
+	EngineEnv []string `flag:"engine-env"`
+	// EngineRegistryMirrors are the registry mirrors of the engine, like
+	// --engine-registry-mirror.
+	EngineRegistryMirrors []string `flag:"engine-registry-mirror"`
+	// RegistryAuth are the pull credentials of registries as
+	// registry=username:password-file, like --registry-auth.
+	RegistryAuth []string `flag:"registry-auth"`
+	// CgroupDriver is the cgroup driver of the engine and the kubelet, like
+	// --cgroup-driver.
+	CgroupDriver string `flag:"cgroup-driver"`
+	// KubeletKubeconfig is the kubeconfig of the kubelet, like
+	// --kubelet-kubeconfig.
+	KubeletKubeconfig string `flag:"kubelet-kubeconfig"`
+	// KubeletCredentials is where the kubelet credentials are kept on the
+	// node, like --kubelet-credentials.
+	KubeletCredentials string `flag:"kubelet-credentials"`
+	// KubeletProfile is the kubelet settings profile, like
+	// --kubelet-profile.
+	KubeletProfile string `flag:"kubelet-profile"`
+	// NodePaths is the profile of the kubelet paths on the node, like
+	// --node-paths.
+	NodePaths string `flag:"node-paths"`
+	// CNI is the profile of the network plugin of the cluster, like --cni.
+	CNI string `flag:"cni"`
+	// IPFamily are the addresses the node registers, like --ip-family.
+	IPFamily string `flag:"ip-family"`
+	// Hardening is the security hardening profile, like --hardening.
+	Hardening string `flag:"hardening"`
+	// Firewall is the firewall of the node, like --firewall.
+	Firewall string `flag:"firewall"`
+	// FirewallPorts are additional ports opened by the firewall, like
+	// --firewall-port.
+	FirewallPorts []string `flag:"firewall-port"`
+	// CloudFirewall is the cluster name of the firewall at the cloud
+	// provider, like --cloud-firewall.
+	CloudFirewall string `flag:"cloud-firewall"`
+	// ArtifactMirrors serve the node binaries, like --artifact-mirror.
+	ArtifactMirrors []string `flag:"artifact-mirror"`
+	// Heartbeat installs the heartbeat agent, like --heartbeat.
+	Heartbeat bool `flag:"heartbeat"`
+	// StaticIP leases a static address from Config.IPAM, like --static-ip.
+	StaticIP bool `flag:"static-ip"`
+	// RegisterDNS registers the machine in the zone of Config.DNSConfig,
+	// like --register-dns.
+	RegisterDNS bool `flag:"register-dns"`
+	// Protect protects the machine from being deleted, like --protect.
+	Protect bool `flag:"protect"`
+	// CleanupOnFailure removes the machine if the creation fails, like
+	// --cleanup-on-failure.
+	CleanupOnFailure bool `flag:"cleanup-on-failure"`
+	// SkipPreflight skips the checks before the creation, like
+	// --skip-preflight.
+	SkipPreflight bool `flag:"skip-preflight"`
+	// InitTimeout is how long the node may take to pass its verification,
+	// like --init-timeout.
+	InitTimeout time.Duration `flag:"init-timeout"`
+	// SmokeTest runs a pod on the node after provisioning, like
+	// --smoke-test.
+	SmokeTest bool `flag:"smoke-test"`
+	// Flags are the flags without a field by name, including the ones of
+	// the driver, e.g. {"amazonec2-instance-type": "m4.large"}. The fields
+	// take precedence.
+	Flags map[string]interface{}
+}
+
+// ProvisionOptions are the options of Provision.
+type ProvisionOptions struct {
+	Machines []string
+	// OnlyDrifted provisions only the machines whose provisioned artifacts
+	// drifted, like --only-drifted.
+	OnlyDrifted bool
+}
+
+// UpgradeOptions are the options of Upgrade.
+type UpgradeOptions struct {
+	Machines []string
+}
+
+// DeleteOptions are the options of Delete.
+type DeleteOptions struct {
+	Machines []string
+	// Force removes the machines from the store even if their VMs can't be
+	// removed, like --force.
+	Force bool
+	// Unprotect deletes protected machines, like --unprotect.
+	Unprotect bool
+}
+
+// Create creates, provisions and registers a machine as a node.
+func Create(ctx context.Context, api *API, config Config, opts CreateOptions) (*Machine, error) {
+	if opts.Name == "" || opts.Driver == "" {
+		return nil, errors.New("The name and driver of the machine are required")
+	}
+	flags := map[string]interface{}{}
+	for k, v := range opts.Flags {
+		flags[k] = v
+	}
+	flagValues(opts, flags)
+	flags["driver"] = opts.Driver
+	if err := run(ctx, api.api, config.options(), commands.CreateCommand, []string{opts.Name}, flags); err != nil {
+		return nil, err
+	}
+	m := load(ctx, api, opts.Name)
+	return &m, nil
+}
+
+// Provision provisions the machines again.
+func Provision(ctx context.Context, api *API, config Config, opts ProvisionOptions) error {
+	if len(opts.Machines) == 0 && !opts.OnlyDrifted {
+		return errNoMachines
+	}
+	return run(ctx, api.api, config.options(), commands.ProvisionCommand, opts.Machines, map[string]interface{}{"only-drifted": opts.OnlyDrifted})
+}
+
+// Upgrade upgrades the Kubernetes components of the machines.
+func Upgrade(ctx context.Context, api *API, config Config, opts UpgradeOptions) error {
+	if len(opts.Machines) == 0 {
+		return errNoMachines
+	}
+	return run(ctx, api.api, config.options(), commands.UpgradeCommand, opts.Machines, nil)
+}
+
+// Delete drains and removes the machines and their nodes.
+func Delete(ctx context.Context, api *API, config Config, opts DeleteOptions) error {
+	if len(opts.Machines) == 0 {
+		return errNoMachines
+	}
+	return run(ctx, api.api, config.options(), commands.RmCommand, opts.Machines, map[string]interface{}{
+		"y":         true,
+		"force":     opts.Force,
+		"unprotect": opts.Unprotect,
+	})
+}
+
+// List returns the machines of the store with the states of their VMs.
+func List(ctx context.Context, api *API) ([]Machine, error) {
+	names, err := api.api.List()
+	if err != nil {
+		return nil, err
+	}
+	machines := []Machine{}
+	for _, name := range names {
+		machines = append(machines, load(ctx, api, name))
+	}
+	return machines, nil
+}
+
+// flagValues adds the fields of the options struct with a flag tag to the
+// flags. Fields with their zero value are left out, so the flags keep their
+// defaults, durations are passed in seconds like on the command line.
+func flagValues(opts interface{}, flags map[string]interface{}) {
+	v := reflect.ValueOf(opts)
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Tag.Get("flag")
+		field := v.Field(i)
+		zero := reflect.Zero(field.Type()).Interface()
+		if name == "" || reflect.DeepEqual(field.Interface(), zero) || (field.Kind() == reflect.Slice && field.Len() == 0) {
+			continue
+		}
+		if d, ok := field.Interface().(time.Duration); ok {
+			flags[name] = int(d / time.Second)
+			continue
+		}
+		flags[name] = field.Interface()
+	}
+}
+
+func load(ctx context.Context, api *API, name string) Machine {
+	m := Machine{Name: name}
+	h, err := api.api.Load(name)
+	if err != nil {
+		m.Err = err
+		return m
+	}
+	m.Driver = h.DriverName
+	var s state.State
+	err = deadline.Run(ctx, "state of "+name, func() error {
+		var err error
+		s, err = h.Driver.GetState()
+		return err
+	})
+	if err != nil {
+		m.Err = err
+		return m
+	}
+	m.State = s.String()
+	return m
+}
@@ -0,0 +1,114 @@
+package machine
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/machine/commands"
+	"github.com/docker/machine/drivers/fakedriver"
+	"github.com/docker/machine/libmachine"
+	"github.com/docker/machine/libmachine/host"
+	"github.com/docker/machine/libmachine/libmachinetest"
+	"github.com/docker/machine/libmachine/state"
+)
+
+type invocation struct {
+	options map[string]interface{}
+	command string
+	args    []string
+	flags   map[string]interface{}
+}
+
+func stubRun() (*[]invocation, func()) {
+	invocations := &[]invocation{}
+	original := run
+	run = func(ctx context.Context, api libmachine.API, options map[string]interface{}, command commands.Command, args []string, flags map[string]interface{}) error {
+		*invocations = append(*invocations, invocation{options, command.Name(), args, flags})
+		return nil
+	}
+	return invocations, func() { run = original }
+}
+
+func TestOperations(t *testing.T) {
+	invocations, restore := stubRun()
+	defer restore()
+	api := &API{api: &libmachinetest.FakeAPI{
+		Hosts: []*host.Host{{Name: "node-1", DriverName: "fakedriver", Driver: &fakedriver.Driver{MockState: state.Running}}},
+	}}
+	config := Config{
+		Kubeconfig:  "/etc/kube-machine/kubeconfig",
+		StepTimeout: 10 * time.Minute,
+		AuditSinks:  []string{"events"},
+		Options:     map[string]interface{}{"tls-ca-signer": "vault", "step-timeout": 60},
+	}
+	ctx := context.Background()
+
+	m, err := Create(ctx, api, config, CreateOptions{
+		Name:          "node-1",
+		Driver:        "fakedriver",
+		CNI:           "calico",
+		FirewallPorts: []string{"9100"},
+		Protect:       true,
+		InitTimeout:   5 * time.Minute,
+		Flags:         map[string]interface{}{"fakedriver-size": "large", "cni": "flannel"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "node-1" || m.State != "Running" || m.Err != nil {
+		t.Errorf("unexpected machine %+v", m)
+	}
+	if err := Provision(ctx, api, config, ProvisionOptions{Machines: []string{"node-1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Delete(ctx, api, config, DeleteOptions{Machines: []string{"node-1"}, Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Upgrade(ctx, api, config, UpgradeOptions{}); err != errNoMachines {
+		t.Errorf("expected %v, got %v", errNoMachines, err)
+	}
+
+	options := map[string]interface{}{
+		"kubeconfig":    "/etc/kube-machine/kubeconfig",
+		"step-timeout":  600,
+		"audit-sink":    []string{"events"},
+		"tls-ca-signer": "vault",
+	}
+	expected := []invocation{
+		{options, "create", []string{"node-1"}, map[string]interface{}{
+			"driver":          "fakedriver",
+			"cni":             "calico",
+			"firewall-port":   []string{"9100"},
+			"protect":         true,
+			"init-timeout":    300,
+			"fakedriver-size": "large",
+		}},
+		{options, "provision", []string{"node-1"}, map[string]interface{}{"only-drifted": false}},
+		{options, "rm", []string{"node-1"}, map[string]interface{}{"y": true, "force": true, "unprotect": false}},
+	}
+	if !reflect.DeepEqual(*invocations, expected) {
+		t.Errorf("expected %v, got %v", expected, *invocations)
+	}
+}
+
+func TestList(t *testing.T) {
+	api := &API{api: &libmachinetest.FakeAPI{
+		Hosts: []*host.Host{
+			{Name: "node-1", DriverName: "fakedriver", Driver: &fakedriver.Driver{MockState: state.Running}},
+			{Name: "node-2", DriverName: "fakedriver", Driver: &fakedriver.Driver{MockState: state.Stopped}},
+		},
+	}}
+	machines, err := List(context.Background(), api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Machine{
+		{Name: "node-1", Driver: "fakedriver", State: "Running"},
+		{Name: "node-2", Driver: "fakedriver", State: "Stopped"},
+	}
+	if !reflect.DeepEqual(machines, expected) {
+		t.Errorf("expected %v, got %v", expected, machines)
+	}
+}
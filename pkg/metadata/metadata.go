@@ -0,0 +1,189 @@
+package metadata
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Record is the user metadata of a machine together with what was last
+// propagated from it, so keys which are no longer propagated are removed
+// again.
+type Record struct {
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Labels and Annotations are the keys propagated to the node.
+	Labels      []string `json:"labels,omitempty"`
+	Annotations []string `json:"annotations,omitempty"`
+	// Tags are the tags propagated to the cloud provider. The values are
+	// kept as well, the tags are not read back from the provider.
+	Tags map[string]string `json:"tags,omitempty"`
+	// Propagation is the configuration the metadata was last propagated
+	// with, it applies while no other one is given.
+	Propagation *Propagation `json:"propagation,omitempty"`
+}
+
+// Propagation selects the metadata propagated to the node labels and
+// annotations and to the tags at the cloud provider. A pattern is either a
+// key or a prefix followed by *.
+type Propagation struct {
+	Labels      []string `json:"labels,omitempty"`
+	Annotations []string `json:"annotations,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// Empty reports whether nothing is propagated.
+func (p Propagation) Empty() bool {
+	return len(p.Labels) == 0 && len(p.Annotations) == 0 && len(p.Tags) == 0
+}
+
+// Patterns splits comma-separated patterns.
+func Patterns(s string) []string {
+	var patterns []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// Parse parses key=value arguments setting metadata and key- arguments
+// removing it.
+func Parse(args []string) (map[string]string, []string, error) {
+	set := map[string]string{}
+	var remove []string
+	for _, arg := range args {
+		if strings.HasSuffix(arg, "-") && !strings.Contains(arg, "=") {
+			key := strings.TrimSuffix(arg, "-")
+			if err := validateKey(key); err != nil {
+				return nil, nil, err
+			}
+			remove = append(remove, key)
+			continue
+		}
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return nil, nil, fmt.Errorf("Invalid metadata %q, expected key=value or key-", arg)
+		}
+		if err := validateKey(parts[0]); err != nil {
+			return nil, nil, err
+		}
+		set[parts[0]] = parts[1]
+	}
+	return set, remove, nil
+}
+
+// Apply returns the metadata with the keys set and removed.
+func Apply(metadata, set map[string]string, remove []string) map[string]string {
+	result := map[string]string{}
+	for k, v := range metadata {
+		result[k] = v
+	}
+	for _, k := range remove {
+		delete(result, k)
+	}
+	for k, v := range set {
+		result[k] = v
+	}
+	return result
+}
+
+// Select returns the metadata matching one of the patterns.
+func Select(metadata map[string]string, patterns []string) map[string]string {
+	selected := map[string]string{}
+	for k, v := range metadata {
+		for _, p := range patterns {
+			if k == p || strings.HasSuffix(p, "*") && strings.HasPrefix(k, strings.TrimSuffix(p, "*")) {
+				selected[k] = v
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// Sync sets the desired entries in m and removes the previously propagated
+// keys which are no longer desired. It returns whether m changed and the
+// propagated keys.
+func Sync(m map[string]string, desired map[string]string, previous []string) (bool, []string) {
+	changed := false
+	for _, k := range previous {
+		if _, ok := desired[k]; ok {
+			continue
+		}
+		if _, ok := m[k]; ok {
+			delete(m, k)
+			changed = true
+		}
+	}
+	keys := []string{}
+	for k, v := range desired {
+		if current, ok := m[k]; !ok || current != v {
+			m[k] = v
+			changed = true
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return changed, keys
+}
+
+// The syntax of label keys and values, see
+// https://kubernetes.io/docs/concepts/overview/working-with-objects/labels/#syntax-and-character-set
+var (
+	labelName   = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?)?$`)
+	labelPrefix = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+)
+
+// Validate checks that the metadata propagated to labels and annotations
+// has valid keys, and that the label values are valid. Keys of Kubernetes
+// and of kube-machine itself can't be propagated.
+func Validate(metadata map[string]string, p Propagation) error {
+	for k, v := range Select(metadata, append(append([]string{}, p.Labels...), p.Annotations...)) {
+		if err := validateQualifiedName(k); err != nil {
+			return err
+		}
+		if reserved(k) {
+			return fmt.Errorf("Key %s is reserved and can't be propagated", k)
+		}
+		if _, ok := Select(map[string]string{k: v}, p.Labels)[k]; ok && !labelName.MatchString(v) {
+			return fmt.Errorf("Invalid label value %q of %s: at most 63 alphanumeric characters, '-', '_' or '.'", v, k)
+		}
+	}
+	return nil
+}
+
+func reserved(key string) bool {
+	if strings.HasPrefix(key, "kube-machine") {
+		return true
+	}
+	i := strings.LastIndex(key, "/")
+	if i < 0 {
+		return false
+	}
+	prefix := "." + key[:i]
+	return strings.HasSuffix(prefix, ".kubernetes.io") || strings.HasSuffix(prefix, ".k8s.io")
+}
+
+func validateKey(key string) error {
+	if key == "" || strings.ContainsAny(key, " \t\n=") {
+		return fmt.Errorf("Invalid metadata key %q", key)
+	}
+	return nil
+}
+
+func validateQualifiedName(key string) error {
+	name := key
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		prefix := key[:i]
+		name = key[i+1:]
+		if len(prefix) > 253 || !labelPrefix.MatchString(prefix) {
+			return fmt.Errorf("Invalid prefix of key %q, expected a DNS subdomain", key)
+		}
+	}
+	if name == "" || !labelName.MatchString(name) {
+		return fmt.Errorf("Invalid name of key %q: at most 63 alphanumeric characters, '-', '_' or '.'", key)
+	}
+	return nil
+}
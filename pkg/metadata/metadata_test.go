@@ -0,0 +1,72 @@
+package metadata
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	set, remove, err := Parse([]string{"team=payments", "example.com/owner=alice=bob", "cost-center-", "empty="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"team": "payments", "example.com/owner": "alice=bob", "empty": ""}
+	if !reflect.DeepEqual(set, expected) || !reflect.DeepEqual(remove, []string{"cost-center"}) {
+		t.Errorf("unexpected %v %v", set, remove)
+	}
+
+	for _, invalid := range []string{"team", "=payments", "-", "my team=payments"} {
+		if _, _, err := Parse([]string{invalid}); err == nil {
+			t.Errorf("%q: expected an error", invalid)
+		}
+	}
+
+	metadata := Apply(map[string]string{"team": "search", "cost-center": "42"}, set, remove)
+	if !reflect.DeepEqual(metadata, map[string]string{"team": "payments", "example.com/owner": "alice=bob", "empty": ""}) {
+		t.Errorf("unexpected metadata %v", metadata)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	metadata := map[string]string{"team": "payments", "example.com/owner": "alice", "example.com/cost": "42", "teams": "x"}
+	selected := Select(metadata, []string{"team", "example.com/*"})
+	expected := map[string]string{"team": "payments", "example.com/owner": "alice", "example.com/cost": "42"}
+	if !reflect.DeepEqual(selected, expected) {
+		t.Errorf("expected %v, got %v", expected, selected)
+	}
+
+	if patterns := Patterns(" team, example.com/*,,"); !reflect.DeepEqual(patterns, []string{"team", "example.com/*"}) {
+		t.Errorf("unexpected patterns %v", patterns)
+	}
+}
+
+func TestSync(t *testing.T) {
+	labels := map[string]string{"kubernetes.io/hostname": "node-1", "team": "search", "owner": "alice"}
+	changed, keys := Sync(labels, map[string]string{"team": "payments", "env": "prod"}, []string{"team", "owner"})
+	expected := map[string]string{"kubernetes.io/hostname": "node-1", "team": "payments", "env": "prod"}
+	if !changed || !reflect.DeepEqual(labels, expected) || !reflect.DeepEqual(keys, []string{"env", "team"}) {
+		t.Errorf("unexpected sync %v: %v %v", changed, labels, keys)
+	}
+
+	if changed, _ := Sync(labels, map[string]string{"team": "payments", "env": "prod"}, keys); changed {
+		t.Error("expected no change")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	p := Propagation{Labels: []string{"team", "kube-machine", "example.com/*"}, Annotations: []string{"note", "node.alpha.kubernetes.io/*"}}
+	if err := Validate(map[string]string{"team": "payments", "example.com/owner": "alice", "note": "free text, anything", "other key": "x"}, p); err != nil {
+		t.Error(err)
+	}
+	for _, invalid := range []map[string]string{
+		{"team": "payments and search"},
+		{"example.com/": "x"},
+		{"example.com/-owner": "x"},
+		{"kube-machine": "false"},
+		{"node.alpha.kubernetes.io/kube-machine": "x"},
+	} {
+		if err := Validate(invalid, p); err == nil {
+			t.Errorf("%v: expected an error", invalid)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+)
+
+var (
+	// Default is the registry of all kube-machine metrics.
+	Default = &Registry{}
+
+	MachineCreations = NewCounterVec(Default, "kube_machine_machine_creations_total",
+		"Number of machine creations.", "driver", "result")
+	MachineDeletions = NewCounterVec(Default, "kube_machine_machine_deletions_total",
+		"Number of machine deletions.", "driver", "result")
+	ProvisioningStepDuration = NewHistogramVec(Default, "kube_machine_provisioning_step_duration_seconds",
+		"Duration of the steps provisioning a machine.",
+		[]float64{1, 5, 15, 30, 60, 120, 300, 600}, "driver", "step", "result")
+	SSHRetries = NewCounterVec(Default, "kube_machine_ssh_retries_total",
+		"Number of failed SSH connection attempts while waiting for a machine.", "driver")
+	CloudAPIErrors = NewCounterVec(Default, "kube_machine_cloud_api_errors_total",
+		"Number of errors returned by driver operations calling the cloud provider.", "driver", "operation")
+	StoreOperationDuration = NewHistogramVec(Default, "kube_machine_store_operation_duration_seconds",
+		"Latency of machine store operations.",
+		[]float64{.01, .05, .1, .25, .5, 1, 2.5, 5}, "operation", "result")
+	MachineAdvisories = NewGaugeVec(Default, "kube_machine_machine_advisories",
+		"Number of end-of-life and CVE advisories affecting the machine.", "machine", "kind")
+	MachineReplacementRequired = NewGaugeVec(Default, "kube_machine_machine_replacement_required",
+		"Whether the machine should be replaced because of advisories affecting it.", "machine")
+	MachinePaused = NewGaugeVec(Default, "kube_machine_machine_paused",
+		"Whether the controller is paused for the machine, itself or with its pool.", "machine", "pool")
+	PoolPaused = NewGaugeVec(Default, "kube_machine_pool_paused",
+		"Whether the controller is paused for the pool, which is then not scaled.", "pool")
+	KubeletRestarts = NewGaugeVec(Default, "kube_machine_kubelet_restarts",
+		"Number of automatic restarts of the kubelet of the machine since it was started.", "machine")
+	KubeletCrashLooping = NewGaugeVec(Default, "kube_machine_kubelet_crash_looping",
+		"Whether the kubelet of the machine is restarting repeatedly.", "machine")
+	HeartbeatAge = NewGaugeVec(Default, "kube_machine_heartbeat_age_seconds",
+		"Seconds since the heartbeat agent of the machine last reported.", "machine")
+	HeartbeatProblems = NewGaugeVec(Default, "kube_machine_heartbeat_problems",
+		"Number of problems the heartbeat agent of the machine last reported.", "machine")
+)
+
+// Result returns the result label of an operation.
+func Result(err error) string {
+	if err != nil {
+		return ResultError
+	}
+	return ResultSuccess
+}
+
+// Serve exposes the metrics on /metrics of the given address in the
+// background.
+func Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Default)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Errorf("Failed to serve metrics on %s: %v", addr, err)
+		}
+	}()
+}
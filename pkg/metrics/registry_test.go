@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	r := &Registry{}
+	c := NewCounterVec(r, "test_total", "A counter.", "driver", "result")
+	h := NewHistogramVec(r, "test_seconds", "A histogram.", []float64{1, 10}, "step")
+
+	c.Inc("libvirt", ResultSuccess)
+	c.Inc("libvirt", ResultSuccess)
+	c.Inc("google", ResultError)
+	h.Observe(0.5, "ssh")
+	h.Observe(5, "ssh")
+
+	buf := &bytes.Buffer{}
+	if _, err := r.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP test_total A counter.
+# TYPE test_total counter
+test_total{driver="google",result="error"} 1
+test_total{driver="libvirt",result="success"} 2
+# HELP test_seconds A histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{step="ssh",le="1"} 1
+test_seconds_bucket{step="ssh",le="10"} 2
+test_seconds_bucket{step="ssh",le="+Inf"} 2
+test_seconds_sum{step="ssh"} 5.5
+test_seconds_count{step="ssh"} 2
+`
+	if buf.String() != expected {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestGaugeVec(t *testing.T) {
+	r := &Registry{}
+	g := NewGaugeVec(r, "test_advisories", "A gauge.", "machine")
+	g.Set(3, "node-1")
+	g.Set(1, "node-1")
+	g.Set(2, "node-2")
+
+	buf := &bytes.Buffer{}
+	r.WriteTo(buf)
+	expected := `# HELP test_advisories A gauge.
+# TYPE test_advisories gauge
+test_advisories{machine="node-1"} 1
+test_advisories{machine="node-2"} 2
+`
+	if buf.String() != expected {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	g.Reset()
+	buf.Reset()
+	r.WriteTo(buf)
+	if buf.String() != "# HELP test_advisories A gauge.\n# TYPE test_advisories gauge\n" {
+		t.Errorf("Expected no series after the reset, got:\n%s", buf.String())
+	}
+}
+
+func TestPush(t *testing.T) {
+	var method, path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		method, path, body = r.Method, r.URL.Path, string(data)
+	}))
+	defer server.Close()
+
+	r := &Registry{}
+	NewCounterVec(r, "test_total", "A counter.").Inc()
+
+	if err := r.Push(server.URL+"/", "kube-machine"); err != nil {
+		t.Fatal(err)
+	}
+	if method != http.MethodPut || path != "/metrics/job/kube-machine" || !strings.Contains(body, "test_total 1") {
+		t.Fatalf("Unexpected push %s %s:\n%s", method, path, body)
+	}
+}
@@ -0,0 +1,173 @@
+package naming
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+const (
+	// StrategyRandom appends a random suffix to the prefix, e.g. worker-x7k2q.
+	StrategyRandom = "random"
+	// StrategySequential appends the lowest free index to the prefix, e.g.
+	// worker-3.
+	StrategySequential = "sequential"
+	// StrategyPetname generates names like brave-otter, after the prefix if
+	// one is given.
+	StrategyPetname = "petname"
+
+	// MaxLength is the maximum length of a node name.
+	MaxLength = 253
+
+	maxLabelLength = 63
+	randomLength   = 5
+	maxAttempts    = 20
+	maxSequence    = 10000
+)
+
+var Strategies = []string{StrategyRandom, StrategySequential, StrategyPetname}
+
+// randomAlphabet lacks vowels and ambiguous characters, so random suffixes
+// don't form words, like the generated names of Kubernetes.
+const randomAlphabet = "bcdfghjklmnpqrstvwxz2456789"
+
+var labelPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+var (
+	adjectives = []string{
+		"able", "bold", "brave", "bright", "calm", "clever", "cool", "crisp", "eager", "fair",
+		"fast", "fine", "gentle", "glad", "happy", "keen", "kind", "lively", "loyal", "lucky",
+		"merry", "mighty", "neat", "noble", "proud", "quick", "quiet", "rapid", "sharp", "shy",
+		"smart", "steady", "sunny", "swift", "tidy", "warm", "wise", "witty", "young", "zesty",
+	}
+	animals = []string{
+		"badger", "beaver", "bison", "cobra", "crane", "dingo", "eagle", "falcon", "ferret", "gecko",
+		"heron", "hippo", "ibex", "jackal", "koala", "lemur", "lynx", "marten", "moose", "newt",
+		"ocelot", "orca", "otter", "owl", "panda", "puffin", "quail", "raven", "salmon", "seal",
+		"shrew", "sloth", "stork", "tapir", "tiger", "toucan", "viper", "walrus", "wombat", "yak",
+	}
+)
+
+// Taken reports whether a name is already used.
+type Taken func(name string) (bool, error)
+
+// randomIndex returns a random number in [0, n).
+var randomIndex = func(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(i.Int64()), nil
+}
+
+// Validate checks that the name is a valid node name, which is a RFC 1123
+// subdomain whose labels are valid host names.
+func Validate(name string) error {
+	if name == "" {
+		return errors.New("The name is empty")
+	}
+	if len(name) > MaxLength {
+		return fmt.Errorf("The name %q is longer than %d characters", name, MaxLength)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) > maxLabelLength {
+			return fmt.Errorf("The name %q has a part longer than %d characters", name, maxLabelLength)
+		}
+		if !labelPattern.MatchString(label) {
+			return fmt.Errorf("The name %q is invalid, it may only contain lowercase letters, digits, '-' and '.' and must start and end with a letter or digit", name)
+		}
+	}
+	return nil
+}
+
+// ValidateStrategy checks that the strategy is known.
+func ValidateStrategy(strategy string) error {
+	for _, s := range Strategies {
+		if s == strategy {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown naming strategy %q, expected one of %s", strategy, strings.Join(Strategies, ", "))
+}
+
+// Generate returns a valid name of the strategy which isn't taken. The
+// prefix is required by the random and sequential strategies.
+func Generate(strategy, prefix string, taken Taken) (string, error) {
+	if err := ValidateStrategy(strategy); err != nil {
+		return "", err
+	}
+	if prefix == "" && strategy != StrategyPetname {
+		return "", fmt.Errorf("The %s naming strategy requires a prefix", strategy)
+	}
+
+	if strategy == StrategySequential {
+		for i := 1; i <= maxSequence; i++ {
+			name, err := checked(fmt.Sprintf("%s-%d", prefix, i), taken)
+			if name != "" || err != nil {
+				return name, err
+			}
+		}
+		return "", fmt.Errorf("No free name found for %s-1 to %s-%d", prefix, prefix, maxSequence)
+	}
+
+	for i := 0; i < maxAttempts; i++ {
+		var name string
+		var err error
+		if strategy == StrategyRandom {
+			name, err = randomName(prefix)
+		} else {
+			name, err = petname(prefix)
+		}
+		if err != nil {
+			return "", err
+		}
+		if name, err = checked(name, taken); name != "" || err != nil {
+			return name, err
+		}
+	}
+	return "", fmt.Errorf("No free %s name found in %d attempts", strategy, maxAttempts)
+}
+
+// checked returns the name if it is valid and free, and nothing if it is
+// taken.
+func checked(name string, taken Taken) (string, error) {
+	if err := Validate(name); err != nil {
+		return "", err
+	}
+	used, err := taken(name)
+	if err != nil || used {
+		return "", err
+	}
+	return name, nil
+}
+
+func randomName(prefix string) (string, error) {
+	suffix := make([]byte, randomLength)
+	for i := range suffix {
+		n, err := randomIndex(len(randomAlphabet))
+		if err != nil {
+			return "", err
+		}
+		suffix[i] = randomAlphabet[n]
+	}
+	return prefix + "-" + string(suffix), nil
+}
+
+func petname(prefix string) (string, error) {
+	a, err := randomIndex(len(adjectives))
+	if err != nil {
+		return "", err
+	}
+	b, err := randomIndex(len(animals))
+	if err != nil {
+		return "", err
+	}
+	name := adjectives[a] + "-" + animals[b]
+	if prefix != "" {
+		name = prefix + "-" + name
+	}
+	return name, nil
+}
@@ -0,0 +1,94 @@
+package naming
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	for _, name := range []string{"node-1", "a", "worker.eu-west-1", "1node"} {
+		if err := Validate(name); err != nil {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "Node-1", "node_1", "-node", "node-", "node..1", strings.Repeat("a", 64), strings.Repeat("a.", 127) + "a"} {
+		if err := Validate(name); err == nil {
+			t.Errorf("%q: expected an error", name)
+		}
+	}
+}
+
+func takenNames(names ...string) Taken {
+	return func(name string) (bool, error) {
+		for _, n := range names {
+			if n == name {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+func TestGenerateSequential(t *testing.T) {
+	name, err := Generate(StrategySequential, "worker", takenNames("worker-1", "worker-2", "worker-4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "worker-3" {
+		t.Errorf("expected worker-3, got %s", name)
+	}
+}
+
+func TestGenerateRandom(t *testing.T) {
+	defer func(f func(int) (int, error)) { randomIndex = f }(randomIndex)
+	calls := 0
+	randomIndex = func(n int) (int, error) {
+		calls++
+		// The first name is all b, the second all c.
+		return (calls - 1) / randomLength, nil
+	}
+
+	name, err := Generate(StrategyRandom, "worker", takenNames("worker-bbbbb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "worker-ccccc" {
+		t.Errorf("expected worker-ccccc, got %s", name)
+	}
+}
+
+func TestGeneratePetname(t *testing.T) {
+	name, err := Generate(StrategyPetname, "", takenNames())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parts := strings.Split(name, "-"); len(parts) != 2 {
+		t.Errorf("expected an adjective and an animal, got %s", name)
+	}
+
+	name, err = Generate(StrategyPetname, "edge", takenNames())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(name, "edge-") {
+		t.Errorf("expected the prefix in %s", name)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	if _, err := Generate("uuid", "worker", takenNames()); err == nil {
+		t.Error("expected an error for an unknown strategy")
+	}
+	if _, err := Generate(StrategyRandom, "", takenNames()); err == nil {
+		t.Error("expected an error for a missing prefix")
+	}
+	if _, err := Generate(StrategySequential, "Worker", takenNames()); err == nil {
+		t.Error("expected an error for an invalid prefix")
+	}
+
+	failing := func(name string) (bool, error) { return false, errors.New("connection refused") }
+	if _, err := Generate(StrategySequential, "worker", failing); err == nil {
+		t.Error("expected the error of the collision check")
+	}
+}
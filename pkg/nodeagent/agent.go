@@ -0,0 +1,124 @@
+package nodeagent
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/kubermatic/kube-machine/pkg/agent"
+	"github.com/kubermatic/kube-machine/pkg/bootstrap"
+	"github.com/kubermatic/kube-machine/pkg/kubeconfig"
+)
+
+const (
+	agentDir    = "/etc/kube-machine/agent"
+	agentState  = "/var/lib/kube-machine/agent"
+	agentUnit   = "kube-machine-agent.service"
+	agentTimer  = "kube-machine-agent.timer"
+	agentBinary = "/usr/local/bin/kube-machine-agent"
+)
+
+// agentScript polls the bootstrap secret of the node and runs every new
+// generation of the script once, the result is reported as annotations of
+// the secret. It only needs curl, so it runs before anything is installed.
+const agentScript = `#!/bin/sh
+set -u
+. ` + agentDir + `/env
+url="$SERVER/api/v1/namespaces/` + Namespace + `/secrets/$SECRET"
+api() {
+  curl -sSf --cacert ` + agentDir + `/ca.crt -H "Authorization: Bearer $(cat ` + agentDir + `/token)" "$@"
+}
+field() {
+  tr -d '\n' | grep -o "\"$1\": *\"[^\"]*\"" | head -n 1 | sed 's/.*"\([^"]*\)"$/\1/'
+}
+secret=$(api "$url") || exit 0
+generation=$(echo "$secret" | field ` + GenerationAnnotationKey + `)
+[ -n "$generation" ] || exit 0
+mkdir -p ` + agentState + `
+[ "$generation" = "$(cat ` + agentState + `/generation 2>/dev/null)" ] && exit 0
+echo "$generation" > ` + agentState + `/generation
+echo "$secret" | field ` + ScriptKey + ` | base64 -d > ` + agentState + `/bootstrap.sh
+if sh -e ` + agentState + `/bootstrap.sh > ` + agentState + `/bootstrap.log 2>&1; then
+  patch="{\"` + AppliedAnnotationKey + `\": \"$generation\", \"` + ErrorAnnotationKey + `\": null}"
+else
+  output=$(tail -n 5 ` + agentState + `/bootstrap.log | tr -d '"\\' | tr '\n' ' ')
+  patch="{\"` + ErrorAnnotationKey + `\": \"$generation: $output\"}"
+fi
+api -X PATCH -H "Content-Type: application/merge-patch+json" -d "{\"metadata\": {\"annotations\": $patch}}" "$url" > /dev/null
+`
+
+const agentService = `[Unit]
+Description=Kube Machine node agent
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=/bin/sh ` + agentDir + `/agent.sh
+`
+
+const agentTimerUnit = `[Unit]
+Description=Kube Machine node agent
+
+[Timer]
+OnBootSec=10s
+OnUnitInactiveSec=30s
+
+[Install]
+WantedBy=timers.target
+`
+
+// Agent returns the bootstrap installing the agent of the node, it is passed
+// to the machine as user-data or installed on it by other means. The token is
+// the one of Grant.
+func Agent(cluster kubeconfig.Cluster, token, node string) *bootstrap.Config {
+	env := fmt.Sprintf("SERVER=%q\nSECRET=%q\n", cluster.Server, SecretName(node))
+	return &bootstrap.Config{
+		Files: []bootstrap.File{
+			{Path: agentDir + "/env", Mode: 0600, Content: []byte(env)},
+			{Path: agentDir + "/ca.crt", Mode: 0644, Content: cluster.CAData},
+			{Path: agentDir + "/token", Mode: 0600, Content: []byte(token)},
+			{Path: agentDir + "/agent.sh", Mode: 0700, Content: []byte(agentScript)},
+		},
+		Units: []bootstrap.Unit{
+			{Name: agentUnit, Content: agentService},
+			{Name: agentTimer, Content: agentTimerUnit, Enable: true},
+		},
+	}
+}
+
+const binaryService = `[Unit]
+Description=Kube Machine node agent
+Wants=network-online.target
+After=network-online.target
+ConditionPathExists=` + agentBinary + `
+
+[Service]
+ExecStart=` + agentBinary + ` --config ` + agent.DefaultConfigPath + `
+Restart=on-failure
+RestartSec=10s
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// Binary returns the bootstrap running the kube-machine-agent binary with the
+// configuration. The binary is downloaded from binaryURL if it is set,
+// otherwise it must be part of the image.
+func Binary(config agent.Config, binaryURL string) (*bootstrap.Config, error) {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	b := &bootstrap.Config{
+		Files: []bootstrap.File{
+			{Path: agent.DefaultConfigPath, Mode: 0600, Content: data},
+		},
+		Units: []bootstrap.Unit{
+			{Name: agentUnit, Content: binaryService, Enable: true},
+		},
+	}
+	if binaryURL != "" {
+		b.Commands = append(b.Commands, fmt.Sprintf("curl -sSLf --retry 5 -o %s %q && chmod 0755 %s", agentBinary, binaryURL, agentBinary))
+	}
+	return b, nil
+}
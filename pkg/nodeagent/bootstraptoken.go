@@ -0,0 +1,135 @@
+package nodeagent
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	kcorev1 "k8s.io/client-go/pkg/api/v1"
+	rbac "k8s.io/client-go/pkg/apis/rbac/v1beta1"
+)
+
+// Bootstrap tokens authenticate as the user system:bootstrap:<token id> and
+// expire, the API servers need --enable-bootstrap-token-auth.
+const (
+	bootstrapTokenType       = "bootstrap.kubernetes.io/token"
+	bootstrapTokenNamePrefix = "bootstrap-token-"
+	bootstrapUserPrefix      = "system:bootstrap:"
+	tokenChars               = "abcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// newBootstrapToken returns a random token of the form <id>.<secret>.
+func newBootstrapToken() (string, error) {
+	random := make([]byte, 22)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	for i, b := range random {
+		random[i] = tokenChars[int(b)%len(tokenChars)]
+	}
+	return string(random[:6]) + "." + string(random[6:]), nil
+}
+
+func splitBootstrapToken(token string) (string, string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 || len(parts[0]) != 6 || len(parts[1]) != 16 {
+		return "", "", fmt.Errorf("Invalid bootstrap token, expected <6 characters>.<16 characters>")
+	}
+	return parts[0], parts[1], nil
+}
+
+// bootstrapTokenSecret returns the secret of the token, which expires at the
+// time.
+func bootstrapTokenSecret(token, description string, expires time.Time) (*kcorev1.Secret, error) {
+	id, secret, err := splitBootstrapToken(token)
+	if err != nil {
+		return nil, err
+	}
+	return &kcorev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: bootstrapTokenNamePrefix + id, Namespace: Namespace},
+		Type:       bootstrapTokenType,
+		Data: map[string][]byte{
+			"description":                    []byte(description),
+			"token-id":                       []byte(id),
+			"token-secret":                   []byte(secret),
+			"expiration":                     []byte(expires.UTC().Format(time.RFC3339)),
+			"usage-bootstrap-authentication": []byte("true"),
+		},
+	}, nil
+}
+
+// bootstrapTokenUser returns the user the token authenticates as.
+func bootstrapTokenUser(token string) (rbac.Subject, error) {
+	id, _, err := splitBootstrapToken(token)
+	return rbac.Subject{Kind: "User", APIGroup: "rbac.authorization.k8s.io", Name: bootstrapUserPrefix + id}, err
+}
+
+// applyBootstrapToken creates the secret of the token or renews its
+// expiration, the token is valid for the TTL from now on.
+func applyBootstrapToken(client kubernetes.Interface, token, description string, ttl time.Duration) error {
+	secret, err := bootstrapTokenSecret(token, description, time.Now().Add(ttl))
+	if err != nil {
+		return err
+	}
+	secrets := client.CoreV1().Secrets(Namespace)
+	_, err = secrets.Create(secret)
+	if errors.IsAlreadyExists(err) {
+		var existing *kcorev1.Secret
+		if existing, err = secrets.Get(secret.Name, metav1.GetOptions{}); err == nil {
+			existing.Data = secret.Data
+			_, err = secrets.Update(existing)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to write the bootstrap token %s: %v", secret.Name, err)
+	}
+	return nil
+}
+
+// removeBootstrapToken removes the secret of the token, so it is invalid
+// right away.
+func removeBootstrapToken(client kubernetes.Interface, token string) error {
+	id, _, err := splitBootstrapToken(token)
+	if err != nil {
+		return err
+	}
+	err = client.CoreV1().Secrets(Namespace).Delete(bootstrapTokenNamePrefix+id, nil)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// bindRole creates the role of the rule and binds it to the subject. The
+// subject of an existing binding is replaced.
+func bindRole(client kubernetes.Interface, name string, rule rbac.PolicyRule, subject rbac.Subject) error {
+	_, err := client.RbacV1beta1().Roles(Namespace).Create(&rbac.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Rules:      []rbac.PolicyRule{rule},
+	})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("Failed to create role %s: %v", name, err)
+	}
+	bindings := client.RbacV1beta1().RoleBindings(Namespace)
+	binding := &rbac.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		RoleRef:    rbac.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "Role", Name: name},
+		Subjects:   []rbac.Subject{subject},
+	}
+	_, err = bindings.Create(binding)
+	if errors.IsAlreadyExists(err) {
+		var existing *rbac.RoleBinding
+		if existing, err = bindings.Get(name, metav1.GetOptions{}); err == nil && !(len(existing.Subjects) == 1 && existing.Subjects[0] == subject) {
+			existing.Subjects = binding.Subjects
+			_, err = bindings.Update(existing)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to bind role %s: %v", name, err)
+	}
+	return nil
+}
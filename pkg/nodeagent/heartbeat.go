@@ -0,0 +1,175 @@
+package nodeagent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/kubermatic/kube-machine/pkg/heartbeat"
+	"github.com/kubermatic/kube-machine/pkg/kubeconfig"
+	"github.com/kubermatic/kube-machine/pkg/nodestore"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	kcorev1 "k8s.io/client-go/pkg/api/v1"
+	rbac "k8s.io/client-go/pkg/apis/rbac/v1beta1"
+)
+
+func heartbeatAccountName(node string) string {
+	return heartbeat.ConfigMapName(node)
+}
+
+// HeartbeatTokenAnnotationKey records the bootstrap token of the heartbeat
+// agent on its config map, the token itself is only kept in its secret.
+const HeartbeatTokenAnnotationKey = "kube-machine.kubermatic.io/heartbeat-token"
+
+// HeartbeatTokenTTL is how long the token of a heartbeat agent is valid
+// without being renewed by RenewHeartbeat.
+const HeartbeatTokenTTL = 24 * time.Hour
+
+// GrantHeartbeat creates the config map the heartbeat agent of the node
+// reports to and a bootstrap token, which may only patch the config map,
+// and returns the token. The token of an earlier grant is removed, the new
+// one expires after HeartbeatTokenTTL unless it is renewed.
+func GrantHeartbeat(client kubernetes.Interface, node string) (string, error) {
+	name := heartbeat.ConfigMapName(node)
+	configMaps := client.CoreV1().ConfigMaps(Namespace)
+	configMap, err := configMaps.Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		configMap, err = configMaps.Create(&kcorev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+	if err != nil {
+		return "", fmt.Errorf("Failed to create config map %s: %v", name, err)
+	}
+
+	token, err := newBootstrapToken()
+	if err != nil {
+		return "", err
+	}
+	if err := applyBootstrapToken(client, token, "kube-machine heartbeat agent of "+node, HeartbeatTokenTTL); err != nil {
+		return "", err
+	}
+	user, err := bootstrapTokenUser(token)
+	if err != nil {
+		return "", err
+	}
+	err = bindRole(client, heartbeatAccountName(node), rbac.PolicyRule{
+		APIGroups:     []string{""},
+		Resources:     []string{"configmaps"},
+		ResourceNames: []string{name},
+		Verbs:         []string{"get", "patch"},
+	}, user)
+	if err != nil {
+		return "", err
+	}
+
+	previous := configMap.Annotations[HeartbeatTokenAnnotationKey]
+	if configMap.Annotations == nil {
+		configMap.Annotations = map[string]string{}
+	}
+	id, _, _ := splitBootstrapToken(token)
+	configMap.Annotations[HeartbeatTokenAnnotationKey] = id
+	if _, err := configMaps.Update(configMap); err != nil {
+		return "", fmt.Errorf("Failed to update config map %s: %v", name, err)
+	}
+	if previous != "" && previous != id {
+		if err := client.CoreV1().Secrets(Namespace).Delete(bootstrapTokenNamePrefix+previous, nil); err != nil && !errors.IsNotFound(err) {
+			log.Warnf("Failed to remove the previous heartbeat token of %s: %v", node, err)
+		}
+	}
+	return token, nil
+}
+
+// RenewHeartbeat extends the token of the heartbeat agent of the node by
+// HeartbeatTokenTTL once half of it passed. A token which already expired
+// is only replaced by granting the agent again.
+func RenewHeartbeat(client kubernetes.Interface, node string) error {
+	configMap, err := client.CoreV1().ConfigMaps(Namespace).Get(heartbeat.ConfigMapName(node), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to get the heartbeat of %s: %v", node, err)
+	}
+	id := configMap.Annotations[HeartbeatTokenAnnotationKey]
+	if id == "" {
+		// Agents granted before bootstrap tokens were used have a
+		// service account.
+		return nil
+	}
+	secrets := client.CoreV1().Secrets(Namespace)
+	secret, err := secrets.Get(bootstrapTokenNamePrefix+id, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return fmt.Errorf("The heartbeat token of %s is gone, provision the machine to grant a new one", node)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to get the heartbeat token of %s: %v", node, err)
+	}
+	now := time.Now()
+	if expires, err := time.Parse(time.RFC3339, string(secret.Data["expiration"])); err == nil {
+		if now.After(expires) {
+			return fmt.Errorf("The heartbeat token of %s expired at %s, provision the machine to grant a new one", node, expires)
+		}
+		if expires.Sub(now) > HeartbeatTokenTTL/2 {
+			return nil
+		}
+	}
+	secret.Data["expiration"] = []byte(now.Add(HeartbeatTokenTTL).UTC().Format(time.RFC3339))
+	if _, err := secrets.Update(secret); err != nil {
+		return fmt.Errorf("Failed to renew the heartbeat token of %s: %v", node, err)
+	}
+	return nil
+}
+
+// RevokeHeartbeat removes the token, the role and the config map of the
+// heartbeat agent of the node.
+func RevokeHeartbeat(client kubernetes.Interface, node string) error {
+	return revokeAccount(client, heartbeatAccountName(node), "heartbeat agent of "+node, removal{
+		"bootstrap token", func() error {
+			configMap, err := client.CoreV1().ConfigMaps(Namespace).Get(heartbeat.ConfigMapName(node), metav1.GetOptions{})
+			if err != nil || configMap.Annotations[HeartbeatTokenAnnotationKey] == "" {
+				return err
+			}
+			return client.CoreV1().Secrets(Namespace).Delete(bootstrapTokenNamePrefix+configMap.Annotations[HeartbeatTokenAnnotationKey], nil)
+		},
+	}, removal{
+		"config map", func() error { return client.CoreV1().ConfigMaps(Namespace).Delete(heartbeat.ConfigMapName(node), nil) },
+	})
+}
+
+// Heartbeat returns the last report of the heartbeat agent of the node, nil
+// if the node has no agent or it never reported.
+func Heartbeat(client kubernetes.Interface, node string) (*heartbeat.Health, error) {
+	configMap, err := client.CoreV1().ConfigMaps(Namespace).Get(heartbeat.ConfigMapName(node), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get the heartbeat of %s: %v", node, err)
+	}
+	return heartbeat.Parse(configMap.Data)
+}
+
+// Heartbeats grants the heartbeat agents access to the cluster of the store.
+type Heartbeats struct {
+	Kubeconfig string
+}
+
+// GrantHeartbeat returns the cluster the heartbeat agent of the machine
+// reports to and its token.
+func (h Heartbeats) GrantHeartbeat(machineName string) (kubeconfig.Cluster, string, error) {
+	config, err := nodestore.RestConfig(h.Kubeconfig)
+	if err != nil {
+		return kubeconfig.Cluster{}, "", err
+	}
+	cluster, err := kubeconfig.ClusterFromConfig(config)
+	if err != nil {
+		return kubeconfig.Cluster{}, "", err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return kubeconfig.Cluster{}, "", err
+	}
+	token, err := GrantHeartbeat(client, machineName)
+	return cluster, token, err
+}
@@ -0,0 +1,234 @@
+package nodeagent
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/kubermatic/kube-machine/pkg/agent"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	kcorev1 "k8s.io/client-go/pkg/api/v1"
+	rbac "k8s.io/client-go/pkg/apis/rbac/v1beta1"
+)
+
+// The protocol is shared with the agent binary in pkg/agent.
+const (
+	Namespace               = agent.Namespace
+	ScriptKey               = agent.ScriptKey
+	GenerationAnnotationKey = agent.GenerationAnnotationKey
+	AppliedAnnotationKey    = agent.AppliedAnnotationKey
+	ErrorAnnotationKey      = agent.ErrorAnnotationKey
+	// AgentTokenKey holds the bootstrap token of the agent in the bootstrap
+	// secret, so granting again renews the token the machine has.
+	AgentTokenKey = "agent-token"
+
+	// TokenAnnotationKey is the SHA-256 of the one-time token of an agent
+	// fetching its bootstrap from the kube-machine API.
+	TokenAnnotationKey = "kube-machine.kubermatic.io/agent-token"
+	// TokenExpiresAnnotationKey is the RFC 3339 time the one-time token
+	// expires at.
+	TokenExpiresAnnotationKey = "kube-machine.kubermatic.io/agent-token-expires"
+	// SessionAnnotationKey is the SHA-256 of the session token the one-time
+	// token was exchanged for.
+	SessionAnnotationKey = "kube-machine.kubermatic.io/agent-session"
+	// TokenTTL is how long a one-time token is valid, the machine has to boot
+	// and start its agent within it.
+	TokenTTL = time.Hour
+
+	DefaultTimeout = 15 * time.Minute
+
+	pollInterval = 10 * time.Second
+	tokenTimeout = time.Minute
+)
+
+// SecretName returns the name of the bootstrap secret of the node.
+func SecretName(node string) string {
+	return agent.SecretName(node)
+}
+
+func accountName(node string) string {
+	return "kube-machine-agent-" + node
+}
+
+// Grant gives the agent of the node a bootstrap token, which may only read
+// and annotate the bootstrap secret of the node, and returns it. The token
+// expires after TokenTTL, granting again when the machine is provisioned
+// renews the same token.
+func Grant(client kubernetes.Interface, node string) (string, error) {
+	secrets := client.CoreV1().Secrets(Namespace)
+	secret, err := secrets.Get(SecretName(node), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		secret, err = &kcorev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: SecretName(node)}}, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("Failed to get the bootstrap secret of %s: %v", node, err)
+	}
+	token := string(secret.Data[AgentTokenKey])
+	if _, _, err := splitBootstrapToken(token); err != nil {
+		if token, err = newBootstrapToken(); err != nil {
+			return "", err
+		}
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		secret.Data[AgentTokenKey] = []byte(token)
+		if secret.ResourceVersion == "" {
+			_, err = secrets.Create(secret)
+		} else {
+			_, err = secrets.Update(secret)
+		}
+		if err != nil {
+			return "", fmt.Errorf("Failed to write the bootstrap secret of %s: %v", node, err)
+		}
+	}
+
+	if err := applyBootstrapToken(client, token, "kube-machine agent of "+node, TokenTTL); err != nil {
+		return "", err
+	}
+	user, err := bootstrapTokenUser(token)
+	if err != nil {
+		return "", err
+	}
+	err = bindRole(client, accountName(node), rbac.PolicyRule{
+		APIGroups:     []string{""},
+		Resources:     []string{"secrets"},
+		ResourceNames: []string{SecretName(node)},
+		Verbs:         []string{"get", "patch"},
+	}, user)
+	return token, err
+}
+
+// grantAccount creates the service account with a role of the rule and
+// returns its token.
+func grantAccount(client kubernetes.Interface, name string, rule rbac.PolicyRule) (string, error) {
+	accounts := client.CoreV1().ServiceAccounts(Namespace)
+	if _, err := accounts.Create(&kcorev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: name}}); err != nil && !errors.IsAlreadyExists(err) {
+		return "", fmt.Errorf("Failed to create service account %s: %v", name, err)
+	}
+	if err := bindRole(client, name, rule, rbac.Subject{Kind: "ServiceAccount", Name: name, Namespace: Namespace}); err != nil {
+		return "", err
+	}
+
+	// The token controller creates the token secret of the account.
+	deadline := time.Now().Add(tokenTimeout)
+	for {
+		account, err := accounts.Get(name, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("Failed to get service account %s: %v", name, err)
+		}
+		for _, ref := range account.Secrets {
+			secret, err := client.CoreV1().Secrets(Namespace).Get(ref.Name, metav1.GetOptions{})
+			if err == nil && secret.Type == kcorev1.SecretTypeServiceAccountToken && len(secret.Data["token"]) > 0 {
+				return string(secret.Data["token"]), nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("Service account %s got no token", name)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// Revoke removes the bootstrap token and the role of the agent and the
+// bootstrap secret of the node. Agents granted before bootstrap tokens were
+// used have a service account, which is removed as well.
+func Revoke(client kubernetes.Interface, node string) error {
+	return revokeAccount(client, accountName(node), "agent of "+node, removal{
+		"bootstrap token", func() error {
+			secret, err := client.CoreV1().Secrets(Namespace).Get(SecretName(node), metav1.GetOptions{})
+			if err != nil || len(secret.Data[AgentTokenKey]) == 0 {
+				return err
+			}
+			return removeBootstrapToken(client, string(secret.Data[AgentTokenKey]))
+		},
+	}, removal{
+		"bootstrap secret", func() error { return client.CoreV1().Secrets(Namespace).Delete(SecretName(node), nil) },
+	})
+}
+
+type removal struct {
+	what   string
+	remove func() error
+}
+
+// revokeAccount removes the service account of grantAccount and the extra
+// objects of its owner.
+func revokeAccount(client kubernetes.Interface, name, owner string, extra ...removal) error {
+	removals := append([]removal{
+		{"role binding", func() error { return client.RbacV1beta1().RoleBindings(Namespace).Delete(name, nil) }},
+		{"role", func() error { return client.RbacV1beta1().Roles(Namespace).Delete(name, nil) }},
+		{"service account", func() error { return client.CoreV1().ServiceAccounts(Namespace).Delete(name, nil) }},
+	}, extra...)
+	for _, r := range removals {
+		if err := r.remove(); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("Failed to remove the %s of the %s: %v", r.what, owner, err)
+		}
+	}
+	return nil
+}
+
+// Publish writes the bootstrap script of the node with a new generation,
+// which it returns.
+func Publish(client kubernetes.Interface, node string, script []byte) (string, error) {
+	generation := strconv.FormatInt(time.Now().UnixNano(), 10)
+	secrets := client.CoreV1().Secrets(Namespace)
+	secret, err := secrets.Get(SecretName(node), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		secret, err = &kcorev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: SecretName(node)}}, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("Failed to get the bootstrap secret of %s: %v", node, err)
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[GenerationAnnotationKey] = generation
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[ScriptKey] = script
+	if secret.ResourceVersion == "" {
+		_, err = secrets.Create(secret)
+	} else {
+		_, err = secrets.Update(secret)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Failed to write the bootstrap secret of %s: %v", node, err)
+	}
+	return generation, nil
+}
+
+// Status returns whether the agent ran the generation of the secret, and
+// the error of its run if it failed.
+func Status(secret *kcorev1.Secret, generation string) (bool, error) {
+	if secret.Annotations[AppliedAnnotationKey] == generation {
+		return true, nil
+	}
+	failure := secret.Annotations[ErrorAnnotationKey]
+	if strings.HasPrefix(failure, generation+": ") {
+		return true, fmt.Errorf("Bootstrap failed on the node: %s", strings.TrimPrefix(failure, generation+": "))
+	}
+	return false, nil
+}
+
+// Wait waits until the agent of the node ran the generation.
+func Wait(client kubernetes.Interface, node, generation string, timeout time.Duration) error {
+	log.Infof("Waiting for the agent of %s to run the bootstrap...", node)
+	deadline := time.Now().Add(timeout)
+	for {
+		secret, err := client.CoreV1().Secrets(Namespace).Get(SecretName(node), metav1.GetOptions{})
+		if err != nil {
+			log.Debugf("Failed to get the bootstrap secret of %s: %v", node, err)
+		} else if done, err := Status(secret, generation); done {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("The agent of %s did not run the bootstrap within %s", node, timeout)
+		}
+		time.Sleep(pollInterval)
+	}
+}
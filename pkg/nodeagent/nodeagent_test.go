@@ -0,0 +1,92 @@
+package nodeagent
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubermatic/kube-machine/pkg/agent"
+	"github.com/kubermatic/kube-machine/pkg/kubeconfig"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kcorev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+func TestStatus(t *testing.T) {
+	secret := &kcorev1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		GenerationAnnotationKey: "2",
+		AppliedAnnotationKey:    "1",
+		ErrorAnnotationKey:      "2: kubelet.service not found",
+	}}}
+	if done, err := Status(secret, "1"); !done || err != nil {
+		t.Errorf("Expected generation 1 to be applied, got %v, %v", done, err)
+	}
+	if done, err := Status(secret, "2"); !done || err == nil || !strings.Contains(err.Error(), "kubelet.service not found") {
+		t.Errorf("Expected generation 2 to have failed, got %v, %v", done, err)
+	}
+	if done, err := Status(secret, "3"); done || err != nil {
+		t.Errorf("Expected generation 3 to be pending, got %v, %v", done, err)
+	}
+}
+
+func TestAgent(t *testing.T) {
+	config := Agent(kubeconfig.Cluster{Server: "https://10.0.0.1:6443", CAData: []byte("CA")}, "secret-token", "node-1")
+	files := map[string]string{}
+	for _, f := range config.Files {
+		files[f.Path] = string(f.Content)
+	}
+	if env := files[agentDir+"/env"]; env != "SERVER=\"https://10.0.0.1:6443\"\nSECRET=\"kube-machine-bootstrap-node-1\"\n" {
+		t.Errorf("Unexpected environment %q", env)
+	}
+	if files[agentDir+"/token"] != "secret-token" || files[agentDir+"/ca.crt"] != "CA" {
+		t.Errorf("Unexpected credentials %v", files)
+	}
+	if len(config.Units) != 2 || config.Units[0].Enable || !config.Units[1].Enable {
+		t.Errorf("Expected only the timer to be enabled, got %+v", config.Units)
+	}
+}
+
+func TestBinary(t *testing.T) {
+	config, err := Binary(agent.Config{Mode: agent.ModeAPI, Server: "https://km.example.com", Token: "once", Machine: "node-1"}, "https://example.com/kube-machine-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Files) != 1 || config.Files[0].Path != agent.DefaultConfigPath || config.Files[0].Mode != 0600 {
+		t.Fatalf("Unexpected files %+v", config.Files)
+	}
+	var written agent.Config
+	if err := json.Unmarshal(config.Files[0].Content, &written); err != nil || written.Token != "once" || written.Mode != agent.ModeAPI {
+		t.Errorf("Unexpected configuration %s: %v", config.Files[0].Content, err)
+	}
+	if len(config.Commands) != 1 || !strings.Contains(config.Commands[0], `"https://example.com/kube-machine-agent"`) {
+		t.Errorf("Expected the binary to be downloaded, got %q", config.Commands)
+	}
+	if len(config.Units) != 1 || !config.Units[0].Enable {
+		t.Errorf("Expected the agent service to be enabled, got %+v", config.Units)
+	}
+}
+
+func TestBootstrapToken(t *testing.T) {
+	token, err := newBootstrapToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, secret, err := splitBootstrapToken(token)
+	if err != nil {
+		t.Fatalf("%s: %v", token, err)
+	}
+	expires := time.Date(2017, 5, 1, 12, 0, 0, 0, time.UTC)
+	s, err := bootstrapTokenSecret(token, "agent", expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "bootstrap-token-"+id || s.Type != "bootstrap.kubernetes.io/token" || string(s.Data["token-secret"]) != secret || string(s.Data["expiration"]) != "2017-05-01T12:00:00Z" {
+		t.Errorf("Unexpected secret of the bootstrap token %+v", s)
+	}
+	if user, _ := bootstrapTokenUser(token); user.Kind != "User" || user.Name != "system:bootstrap:"+id {
+		t.Errorf("Unexpected user of the bootstrap token %+v", user)
+	}
+	if _, _, err := splitBootstrapToken("abcdef"); err == nil {
+		t.Error("Expected an error for a token without secret")
+	}
+}
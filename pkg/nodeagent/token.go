@@ -0,0 +1,143 @@
+package nodeagent
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kubermatic/kube-machine/pkg/agent"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	kcorev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+// ErrUnauthorized is returned for a token which is neither the session token
+// nor the unexpired one-time token of the node.
+var ErrUnauthorized = errors.New("Invalid or missing agent token")
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueToken returns a new one-time token of the agent of the node for the
+// kube-machine API, only its hash is stored on the bootstrap secret. The token
+// expires after TokenTTL and is invalidated by its first use, which issues
+// the session token of the agent.
+func IssueToken(client kubernetes.Interface, node string) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	secrets := client.CoreV1().Secrets(Namespace)
+	secret, err := secrets.Get(SecretName(node), metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		secret, err = &kcorev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: SecretName(node)}}, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("Failed to get the bootstrap secret of %s: %v", node, err)
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[TokenAnnotationKey] = tokenHash(token)
+	secret.Annotations[TokenExpiresAnnotationKey] = time.Now().Add(TokenTTL).UTC().Format(time.RFC3339)
+	delete(secret.Annotations, SessionAnnotationKey)
+	if secret.ResourceVersion == "" {
+		_, err = secrets.Create(secret)
+	} else {
+		_, err = secrets.Update(secret)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Failed to write the bootstrap secret of %s: %v", node, err)
+	}
+	return token, nil
+}
+
+func newToken() (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(random), nil
+}
+
+func matches(hash, token string) bool {
+	return hash != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(tokenHash(token))) == 1
+}
+
+// Authorize returns the bootstrap secret of the node if the token is the
+// session token of its agent or its unexpired one-time token. The one-time
+// token is exchanged for a new session token on its first use, which is
+// returned as well.
+func Authorize(client kubernetes.Interface, node, token string) (*kcorev1.Secret, string, error) {
+	secrets := client.CoreV1().Secrets(Namespace)
+	secret, err := secrets.Get(SecretName(node), metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil, "", ErrUnauthorized
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to get the bootstrap secret of %s: %v", node, err)
+	}
+	if matches(secret.Annotations[SessionAnnotationKey], token) {
+		return secret, "", nil
+	}
+	if !matches(secret.Annotations[TokenAnnotationKey], token) {
+		return nil, "", ErrUnauthorized
+	}
+	expires, err := time.Parse(time.RFC3339, secret.Annotations[TokenExpiresAnnotationKey])
+	if err != nil || time.Now().After(expires) {
+		return nil, "", ErrUnauthorized
+	}
+
+	session, err := newToken()
+	if err != nil {
+		return nil, "", err
+	}
+	delete(secret.Annotations, TokenAnnotationKey)
+	delete(secret.Annotations, TokenExpiresAnnotationKey)
+	secret.Annotations[SessionAnnotationKey] = tokenHash(session)
+	// The update fails with a conflict if the token was used concurrently,
+	// so it is only exchanged once.
+	secret, err = secrets.Update(secret)
+	if kerrors.IsConflict(err) {
+		return nil, "", ErrUnauthorized
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to exchange the agent token of %s: %v", node, err)
+	}
+	return secret, session, nil
+}
+
+// Bootstrap returns the current bootstrap of the secret, or nil if none was
+// published yet.
+func Bootstrap(secret *kcorev1.Secret) *agent.Bootstrap {
+	generation := secret.Annotations[GenerationAnnotationKey]
+	if generation == "" {
+		return nil
+	}
+	return &agent.Bootstrap{Generation: generation, Script: secret.Data[ScriptKey]}
+}
+
+// Report records the status reported by the agent of the node on its
+// bootstrap secret.
+func Report(client kubernetes.Interface, node string, status agent.Status) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": agent.Annotations(status)},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := client.CoreV1().Secrets(Namespace).Patch(SecretName(node), types.MergePatchType, patch); err != nil {
+		return fmt.Errorf("Failed to report the bootstrap of %s: %v", node, err)
+	}
+	return nil
+}
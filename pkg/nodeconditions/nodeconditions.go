@@ -0,0 +1,93 @@
+package nodeconditions
+
+import (
+	"time"
+
+	"github.com/kubermatic/kube-machine/pkg/drift"
+	"github.com/kubermatic/kube-machine/pkg/kubelettls"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kcorev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+// The node conditions the controller maintains.
+const (
+	// Provisioned is false for failed machines.
+	Provisioned kcorev1.NodeConditionType = "KubeMachineProvisioned"
+	// ConfigDrift is true for machines provisioned with other artifacts
+	// than provisioning renders now.
+	ConfigDrift kcorev1.NodeConditionType = "KubeMachineConfigDrift"
+	// CertExpiring is true for kubelets whose serving certificate expired
+	// or expires within the warning period.
+	CertExpiring kcorev1.NodeConditionType = "KubeMachineCertExpiring"
+)
+
+// ProvisionedCondition returns the Provisioned condition of a machine with
+// the failure reason, empty if it didn't fail, and its provisioned state.
+func ProvisionedCondition(failure, state string) kcorev1.NodeCondition {
+	switch {
+	case failure != "":
+		return condition(Provisioned, kcorev1.ConditionFalse, "MachineFailed", failure)
+	case state == "":
+		return condition(Provisioned, kcorev1.ConditionUnknown, "NoProvisionedState", "kube-machine recorded no provisioned state of the machine")
+	}
+	return condition(Provisioned, kcorev1.ConditionTrue, "Provisioned", "The machine was provisioned by kube-machine")
+}
+
+// ConfigDriftCondition returns the ConfigDrift condition of a machine in
+// the drift.State.
+func ConfigDriftCondition(state string) kcorev1.NodeCondition {
+	switch state {
+	case drift.StateDrifted:
+		return condition(ConfigDrift, kcorev1.ConditionTrue, "Drifted", "The machine was provisioned with other artifacts than provisioning renders now")
+	case drift.StateInSync:
+		return condition(ConfigDrift, kcorev1.ConditionFalse, "InSync", "The machine was provisioned with the current artifacts")
+	}
+	return condition(ConfigDrift, kcorev1.ConditionUnknown, "Unknown", "The provisioned state of the machine is unknown")
+}
+
+// CertExpiringCondition returns the CertExpiring condition of the result of
+// the TLS verification of the kubelet.
+func CertExpiringCondition(r kubelettls.Result) kcorev1.NodeCondition {
+	for _, f := range r.Findings {
+		switch f.Kind {
+		case kubelettls.FindingExpired:
+			return condition(CertExpiring, kcorev1.ConditionTrue, "CertificateExpired", "The kubelet serving certificate "+f.Detail)
+		case kubelettls.FindingExpiresSoon:
+			return condition(CertExpiring, kcorev1.ConditionTrue, "CertificateExpiresSoon", "The kubelet serving certificate "+f.Detail)
+		}
+	}
+	if r.NotAfter.IsZero() {
+		return condition(CertExpiring, kcorev1.ConditionUnknown, "CertificateUnknown", kubelettls.Describe(r))
+	}
+	return condition(CertExpiring, kcorev1.ConditionFalse, "CertificateValid", "The kubelet serving certificate expires on "+r.NotAfter.Format("2006-01-02"))
+}
+
+func condition(t kcorev1.NodeConditionType, status kcorev1.ConditionStatus, reason, message string) kcorev1.NodeCondition {
+	return kcorev1.NodeCondition{Type: t, Status: status, Reason: reason, Message: message}
+}
+
+// Set returns the conditions with the condition added or replaced and
+// whether they changed. The transition time is kept while the status stays
+// the same, the heartbeat only moves on changes so unchanged conditions
+// don't cause writes on every run.
+func Set(conditions []kcorev1.NodeCondition, c kcorev1.NodeCondition, now time.Time) ([]kcorev1.NodeCondition, bool) {
+	for i, existing := range conditions {
+		if existing.Type != c.Type {
+			continue
+		}
+		if existing.Status == c.Status && existing.Reason == c.Reason && existing.Message == c.Message {
+			return conditions, false
+		}
+		c.LastHeartbeatTime = metav1.NewTime(now)
+		c.LastTransitionTime = existing.LastTransitionTime
+		if existing.Status != c.Status {
+			c.LastTransitionTime = metav1.NewTime(now)
+		}
+		result := append([]kcorev1.NodeCondition{}, conditions...)
+		result[i] = c
+		return result, true
+	}
+	c.LastHeartbeatTime = metav1.NewTime(now)
+	c.LastTransitionTime = metav1.NewTime(now)
+	return append(conditions, c), true
+}
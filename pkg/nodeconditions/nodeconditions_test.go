@@ -0,0 +1,64 @@
+package nodeconditions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kubermatic/kube-machine/pkg/drift"
+	"github.com/kubermatic/kube-machine/pkg/kubelettls"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kcorev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+func TestConditions(t *testing.T) {
+	for _, test := range []struct {
+		condition kcorev1.NodeCondition
+		status    kcorev1.ConditionStatus
+		reason    string
+	}{
+		{ProvisionedCondition("", "abc"), kcorev1.ConditionTrue, "Provisioned"},
+		{ProvisionedCondition("VM deleted at the provider", "abc"), kcorev1.ConditionFalse, "MachineFailed"},
+		{ProvisionedCondition("", ""), kcorev1.ConditionUnknown, "NoProvisionedState"},
+		{ConfigDriftCondition(drift.StateDrifted), kcorev1.ConditionTrue, "Drifted"},
+		{ConfigDriftCondition(drift.StateInSync), kcorev1.ConditionFalse, "InSync"},
+		{ConfigDriftCondition(drift.StateUnknown), kcorev1.ConditionUnknown, "Unknown"},
+		{CertExpiringCondition(kubelettls.Result{NotAfter: time.Now(), Findings: []kubelettls.Finding{{Kind: kubelettls.FindingExpiresSoon, Detail: "expires on 2017-06-01"}}}), kcorev1.ConditionTrue, "CertificateExpiresSoon"},
+		{CertExpiringCondition(kubelettls.Result{NotAfter: time.Now(), Findings: []kubelettls.Finding{{Kind: kubelettls.FindingAnonymousAuth}}}), kcorev1.ConditionFalse, "CertificateValid"},
+		{CertExpiringCondition(kubelettls.Result{Findings: []kubelettls.Finding{{Kind: kubelettls.FindingUnreachable, Detail: "connection refused"}}}), kcorev1.ConditionUnknown, "CertificateUnknown"},
+	} {
+		if test.condition.Status != test.status || test.condition.Reason != test.reason {
+			t.Errorf("Expected %s %s, got %s %s", test.status, test.reason, test.condition.Status, test.condition.Reason)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	then := time.Date(2017, 5, 1, 0, 0, 0, 0, time.UTC)
+	now := then.Add(time.Hour)
+	ready := kcorev1.NodeCondition{Type: kcorev1.NodeReady, Status: kcorev1.ConditionTrue}
+
+	conditions, changed := Set([]kcorev1.NodeCondition{ready}, ConfigDriftCondition(drift.StateInSync), then)
+	if !changed || len(conditions) != 2 || !conditions[1].LastTransitionTime.Time.Equal(then) {
+		t.Fatalf("Expected the condition to be added, got %v", conditions)
+	}
+
+	if _, changed := Set(conditions, ConfigDriftCondition(drift.StateInSync), now); changed {
+		t.Error("Expected an unchanged condition not to change the conditions")
+	}
+
+	conditions, changed = Set(conditions, ConfigDriftCondition(drift.StateDrifted), now)
+	if !changed || len(conditions) != 2 || conditions[1].Status != kcorev1.ConditionTrue || !conditions[1].LastTransitionTime.Time.Equal(now) {
+		t.Fatalf("Expected the condition to transition, got %v", conditions)
+	}
+	if conditions[0] != ready {
+		t.Errorf("Expected other conditions to be kept, got %v", conditions[0])
+	}
+
+	expired := kubelettls.Result{Findings: []kubelettls.Finding{{Kind: kubelettls.FindingExpired, Detail: "expired on 2017-06-01"}}}
+	soon := kubelettls.Result{Findings: []kubelettls.Finding{{Kind: kubelettls.FindingExpiresSoon, Detail: "expires on 2017-06-01"}}}
+	conditions, _ = Set(conditions, CertExpiringCondition(soon), then)
+	conditions, changed = Set(conditions, CertExpiringCondition(expired), now)
+	if !changed || conditions[2].LastTransitionTime != metav1.NewTime(then) || conditions[2].LastHeartbeatTime != metav1.NewTime(now) {
+		t.Errorf("Expected the transition time to be kept while the status stays the same, got %v", conditions[2])
+	}
+}
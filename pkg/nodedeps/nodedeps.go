@@ -0,0 +1,175 @@
+package nodedeps
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/kubermatic/kube-machine/pkg/artifacts"
+	"github.com/kubermatic/kube-machine/pkg/remote"
+)
+
+// StaticBinaryDir is where static builds are installed, it is in the PATH of
+// the kubelet.
+const StaticBinaryDir = "/opt/bin"
+
+// Binaries are the commands the kubelet and kube-proxy need on the node.
+var Binaries = []string{"socat", "conntrack", "ebtables", "iptables", "ethtool"}
+
+// packages provide the binaries on the distributions with a package manager.
+var packages = []struct {
+	ids     string
+	install string
+}{
+	{"ubuntu|debian", "DEBIAN_FRONTEND=noninteractive apt-get -qq update && DEBIAN_FRONTEND=noninteractive apt-get -qq -y install socat conntrack ebtables iptables ethtool"},
+	{"centos|rhel|fedora", "yum -y -q install socat conntrack-tools ebtables iptables ethtool"},
+	{"sles|opensuse-leap|opensuse-tumbleweed", "zypper -q -n install socat conntrack-tools ebtables iptables ethtool"},
+	{"alpine", "apk add --no-cache socat conntrack-tools ebtables iptables ethtool"},
+}
+
+// muslLoader matches the dynamic loader of musl distributions like Alpine.
+const muslLoader = "/lib/ld-musl-*.so.1"
+
+// glibcCompat are the Alpine packages running binaries linked against glibc
+// on musl, gcompat replaced libc6-compat in Alpine 3.13.
+var glibcCompat = []string{"gcompat", "libc6-compat"}
+
+// Socat is the static build of socat installed on distributions without
+// package manager unless the machine configures its own, kubectl
+// port-forward needs it. It is pushed from the artifact cache like the
+// kubelet or downloaded from the artifact mirrors or its origin, verified
+// against the sum pinned in the checksums file.
+var Socat = artifacts.Artifact{
+	URL:  "https://github.com/andrew-d/static-binaries/raw/master/binaries/linux/x86_64/socat",
+	Path: StaticBinaryDir + "/socat",
+}
+
+// SocatMissingScript returns a shell script printing yes if the node has
+// no package manager and lacks socat, so the default build is installed.
+func SocatMissingScript() string {
+	var ids []string
+	for _, p := range packages {
+		ids = append(ids, p.ids)
+	}
+	return fmt.Sprintf(". /etc/os-release; case \"$ID\" in %s) ;; *) PATH=\"$PATH:%s\" command -v socat >/dev/null || echo yes ;; esac",
+		strings.Join(ids, "|"), StaticBinaryDir)
+}
+
+// StaticBuild is a binary installed on distributions without package
+// manager, like Container Linux, if the distribution doesn't ship it.
+type StaticBuild struct {
+	Name   string
+	URL    string
+	SHA256 string
+}
+
+func (b StaticBuild) String() string {
+	return fmt.Sprintf("%s=%s#sha256=%s", b.Name, b.URL, b.SHA256)
+}
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+// ParseStaticBuild parses a static build of the form
+// name=url#sha256=checksum.
+func ParseStaticBuild(s string) (StaticBuild, error) {
+	i := strings.Index(s, "=")
+	j := strings.LastIndex(s, "#sha256=")
+	if i < 0 || j < i {
+		return StaticBuild{}, fmt.Errorf("Invalid static build %q, expected name=url#sha256=checksum", s)
+	}
+	b := StaticBuild{Name: s[:i], URL: s[i+1 : j], SHA256: strings.ToLower(s[j+len("#sha256="):])}
+	if !namePattern.MatchString(b.Name) {
+		return b, fmt.Errorf("Invalid name of the static build %q", s)
+	}
+	if !strings.HasPrefix(b.URL, "https://") && !strings.HasPrefix(b.URL, "http://") {
+		return b, fmt.Errorf("Invalid URL of the static build %q, expected an http or https URL", s)
+	}
+	if sum, err := hex.DecodeString(b.SHA256); err != nil || len(sum) != 32 {
+		return b, fmt.Errorf("Invalid checksum of the static build %q, expected a hex encoded SHA-256", s)
+	}
+	return b, nil
+}
+
+// ParseStaticBuilds parses all static builds of a machine.
+func ParseStaticBuilds(specs []string) ([]StaticBuild, error) {
+	var builds []StaticBuild
+	for _, s := range specs {
+		b, err := ParseStaticBuild(s)
+		if err != nil {
+			return nil, err
+		}
+		builds = append(builds, b)
+	}
+	return builds, nil
+}
+
+// Script returns a shell script run as root installing the missing
+// binaries, with the package manager of the distribution or from the static
+// builds, whose checksums are verified. Socat is installed from its default
+// build through the mirrors if no static build of it is configured. It
+// fails if a binary is still missing.
+func Script(builds []StaticBuild, mirrors []string) string {
+	return script(builds, mirrors, "/etc/os-release", StaticBinaryDir)
+}
+
+func script(builds []StaticBuild, mirrors []string, osRelease, dir string) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "export PATH=\"$PATH:%s\"\n", dir)
+	fmt.Fprintf(&b, "missing() { for b in %s; do command -v $b >/dev/null || echo $b; done; }\n", strings.Join(Binaries, " "))
+	b.WriteString("[ -z \"$(missing)\" ] && exit 0\n")
+	fmt.Fprintf(&b, ". %s\n", remote.Quote(osRelease))
+	b.WriteString("case \"$ID\" in\n")
+	for _, p := range packages {
+		fmt.Fprintf(&b, "%s) %s ;;\n", p.ids, p.install)
+	}
+	b.WriteString("*)\n")
+	fmt.Fprintf(&b, "  mkdir -p %s\n", remote.Quote(dir))
+	socat := true
+	for _, build := range builds {
+		if build.Name == "socat" {
+			socat = false
+		}
+		dst := dir + "/" + build.Name
+		tmp := dst + ".download"
+		fmt.Fprintf(&b, "  if ! command -v %s >/dev/null; then curl -fsSL --retry 3 -o %s %s && echo %s | sha256sum -c - >/dev/null && chmod +x %s && mv %s %s || { rm -f %s; echo 'Failed to install %s' >&2; exit 1; }; fi\n",
+			build.Name, remote.Quote(tmp), remote.Quote(build.URL), remote.Quote(build.SHA256+"  "+tmp),
+			remote.Quote(tmp), remote.Quote(tmp), remote.Quote(dst), remote.Quote(tmp), build.Name)
+	}
+	if socat {
+		a := Socat
+		a.Path = dir + "/socat"
+		install := artifacts.InstallScript([]artifacts.Artifact{a}, mirrors)
+		fmt.Fprintf(&b, "  if ! command -v socat >/dev/null; then %s || { echo 'Failed to install socat' >&2; exit 1; }; fi\n", install)
+	}
+	b.WriteString("  ;;\n")
+	b.WriteString("esac\n")
+	b.WriteString("m=$(missing); [ -z \"$m\" ] || { echo \"Missing\" $m \"on $ID, configure static builds for them\" >&2; exit 1; }\n")
+	return b.String()
+}
+
+// MuslScript returns a shell script run as root which installs the glibc
+// compatibility layer on musl nodes if one of the binaries is linked against
+// glibc. Static builds run on musl as is, glibc and other nodes are left
+// alone.
+func MuslScript(binaries []string) string {
+	return muslScript(binaries, muslLoader)
+}
+
+func muslScript(binaries []string, loader string) string {
+	var quoted []string
+	for _, binary := range binaries {
+		quoted = append(quoted, remote.Quote(binary))
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "ls %s >/dev/null 2>&1 || exit 0\n", loader)
+	fmt.Fprintf(&b, "for f in %s; do\n", strings.Join(quoted, " "))
+	b.WriteString("  [ -f \"$f\" ] && grep -q ld-linux \"$f\" || continue\n")
+	for _, p := range glibcCompat {
+		fmt.Fprintf(&b, "  apk add --no-cache %s >/dev/null 2>&1 && exit 0\n", p)
+	}
+	b.WriteString("  echo \"$f is linked against glibc, install a static build or the glibc compatibility layer\" >&2; exit 1\n")
+	b.WriteString("done\n")
+	return b.String()
+}
@@ -0,0 +1,148 @@
+package nodedeps
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseStaticBuild(t *testing.T) {
+	sum := strings.Repeat("ab", 32)
+	b, err := ParseStaticBuild("socat=https://example.com/socat?v=1#sha256=" + strings.ToUpper(sum))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := StaticBuild{Name: "socat", URL: "https://example.com/socat?v=1", SHA256: sum}
+	if b != expected {
+		t.Errorf("expected %+v, got %+v", expected, b)
+	}
+
+	for _, s := range []string{
+		"socat",
+		"socat=https://example.com/socat",
+		"=https://example.com/socat#sha256=" + sum,
+		"so cat=https://example.com/socat#sha256=" + sum,
+		"socat=file:///socat#sha256=" + sum,
+		"socat=https://example.com/socat#sha256=abcd",
+	} {
+		if _, err := ParseStaticBuild(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestScriptPackageManagers(t *testing.T) {
+	s := Script(nil, nil)
+	for _, expected := range []string{
+		"ubuntu|debian) DEBIAN_FRONTEND=noninteractive apt-get -qq update",
+		"centos|rhel|fedora) yum -y -q install socat conntrack-tools",
+		"alpine) apk add --no-cache socat conntrack-tools",
+		". '/etc/os-release'",
+	} {
+		if !strings.Contains(s, expected) {
+			t.Errorf("expected %q in %s", expected, s)
+		}
+	}
+}
+
+func TestScriptStaticBuilds(t *testing.T) {
+	for _, tool := range []string{"curl", "sha256sum"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is not installed", tool)
+		}
+	}
+	binary := []byte("#!/bin/sh\n")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(binary)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "nodedeps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	osRelease := filepath.Join(dir, "os-release")
+	if err := ioutil.WriteFile(osRelease, []byte("ID=flatcar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(dir, "bin")
+
+	sum := sha256.Sum256(binary)
+	var builds []StaticBuild
+	for _, name := range Binaries {
+		builds = append(builds, StaticBuild{Name: name, URL: server.URL + "/" + name, SHA256: hex.EncodeToString(sum[:])})
+	}
+
+	// A build with a wrong checksum is refused, unless the binary is
+	// installed already.
+	bad := append([]StaticBuild{}, builds...)
+	bad[0].SHA256 = strings.Repeat("0", 64)
+	if _, err := exec.LookPath(bad[0].Name); err != nil {
+		if out, err := exec.Command("/bin/sh", "-c", script(bad, nil, osRelease, bin)).CombinedOutput(); err == nil {
+			t.Errorf("expected the checksum mismatch to fail: %s", out)
+		}
+	}
+
+	if out, err := exec.Command("/bin/sh", "-c", script(builds, nil, osRelease, bin)).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if out, err := exec.Command("/bin/sh", "-c", script(nil, nil, osRelease, bin)).CombinedOutput(); err != nil {
+		t.Errorf("expected the installed binaries to be found: %v: %s", err, out)
+	}
+
+	// Without a static build of socat its default build is installed
+	// through the mirrors.
+	if _, err := exec.LookPath("socat"); err == nil {
+		t.Skip("socat is installed")
+	}
+	bin = filepath.Join(dir, "default")
+	if out, err := exec.Command("/bin/sh", "-c", script(builds[1:], []string{server.URL}, osRelease, bin)).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if _, err := os.Stat(filepath.Join(bin, "socat")); err != nil {
+		t.Errorf("expected the default socat to be installed: %v", err)
+	}
+}
+
+func TestMuslScript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodedeps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	static := filepath.Join(dir, "static")
+	glibc := filepath.Join(dir, "glibc")
+	loader := filepath.Join(dir, "ld-musl-x86_64.so.1")
+	for path, content := range map[string]string{static: "\x7fELF static", glibc: "\x7fELF /lib64/ld-linux-x86-64.so.2", loader: ""} {
+		if err := ioutil.WriteFile(path, []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run := func(binaries []string, loader string) error {
+		cmd := exec.Command("/bin/sh", "-c", muslScript(binaries, loader))
+		// apk is missing, installing the compatibility layer fails.
+		cmd.Env = []string{"PATH=" + dir + ":/usr/bin:/bin"}
+		return cmd.Run()
+	}
+	if err := run([]string{static, filepath.Join(dir, "missing")}, filepath.Join(dir, "ld-musl-*.so.1")); err != nil {
+		t.Errorf("expected static builds to run on musl: %v", err)
+	}
+	if err := run([]string{glibc}, filepath.Join(dir, "ld-glibc-*.so.1")); err != nil {
+		t.Errorf("expected glibc nodes to be left alone: %v", err)
+	}
+	if _, err := exec.LookPath("apk"); err == nil {
+		t.Skip("apk is installed")
+	}
+	if err := run([]string{glibc}, filepath.Join(dir, "ld-musl-*.so.1")); err == nil {
+		t.Error("expected a glibc binary on musl to need the compatibility layer")
+	}
+}
@@ -0,0 +1,136 @@
+package nodedns
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/kubermatic/kube-machine/pkg/driverprofiles"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+var cloudDNSEndpoint = "https://www.googleapis.com/dns/v1"
+
+const cloudDNSScope = "https://www.googleapis.com/auth/ndev.clouddns.readwrite"
+
+// CloudDNSConfig is the managed zone of the nodes at Google Cloud DNS. The
+// application default credentials are used without credentials.
+type CloudDNSConfig struct {
+	Project     string `json:"project"`
+	ManagedZone string `json:"managedZone"`
+	// Credentials is a reference to the JSON key of a service account.
+	Credentials string `json:"credentials,omitempty"`
+
+	client *http.Client
+}
+
+type cloudDNSRecordSet struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	TTL     int64    `json:"ttl"`
+	RRDatas []string `json:"rrdatas"`
+}
+
+func (c *CloudDNSConfig) httpClient() (*http.Client, error) {
+	if c.client != nil {
+		return c.client, nil
+	}
+	if c.Credentials == "" {
+		return google.DefaultClient(oauth2.NoContext, cloudDNSScope)
+	}
+	key, err := driverprofiles.Resolve(c.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	config, err := google.JWTConfigFromJSON([]byte(key), cloudDNSScope)
+	if err != nil {
+		return nil, err
+	}
+	return config.Client(oauth2.NoContext), nil
+}
+
+func (c *CloudDNSConfig) zoneURL() string {
+	return fmt.Sprintf("%s/projects/%s/managedZones/%s", cloudDNSEndpoint, url.QueryEscape(c.Project), url.QueryEscape(c.ManagedZone))
+}
+
+// recordSets returns the A and AAAA record sets of the name.
+func (c *CloudDNSConfig) recordSets(client *http.Client, fqdn string) ([]cloudDNSRecordSet, error) {
+	var list struct {
+		RRSets []cloudDNSRecordSet `json:"rrsets"`
+	}
+	if err := c.do(client, "GET", c.zoneURL()+"/rrsets?name="+url.QueryEscape(fqdn+"."), nil, &list); err != nil {
+		return nil, err
+	}
+	var sets []cloudDNSRecordSet
+	for _, set := range list.RRSets {
+		if set.Type == "A" || set.Type == "AAAA" {
+			sets = append(sets, set)
+		}
+	}
+	return sets, nil
+}
+
+func (c *CloudDNSConfig) Upsert(fqdn string, addresses []string, ttl int64) error {
+	client, err := c.httpClient()
+	if err != nil {
+		return err
+	}
+	existing, err := c.recordSets(client, fqdn)
+	if err != nil {
+		return err
+	}
+	var additions []cloudDNSRecordSet
+	for recordType, ips := range recordTypes(addresses) {
+		additions = append(additions, cloudDNSRecordSet{Name: fqdn + ".", Type: recordType, TTL: ttl, RRDatas: ips})
+	}
+	if len(additions) == 0 {
+		return fmt.Errorf("no IP addresses in %v", addresses)
+	}
+	change := map[string][]cloudDNSRecordSet{"additions": additions, "deletions": existing}
+	return c.do(client, "POST", c.zoneURL()+"/changes", change, nil)
+}
+
+func (c *CloudDNSConfig) Delete(fqdn string) error {
+	client, err := c.httpClient()
+	if err != nil {
+		return err
+	}
+	existing, err := c.recordSets(client, fqdn)
+	if err != nil || len(existing) == 0 {
+		return err
+	}
+	change := map[string][]cloudDNSRecordSet{"deletions": existing}
+	return c.do(client, "POST", c.zoneURL()+"/changes", change, nil)
+}
+
+func (c *CloudDNSConfig) do(client *http.Client, method, u string, body, result interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Cloud DNS responded with %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
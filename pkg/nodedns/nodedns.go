@@ -0,0 +1,130 @@
+package nodedns
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// DefaultTTL is the TTL of the records if the configuration sets none.
+const DefaultTTL = 300
+
+// Config is the DNS configuration file of the nodes. Secrets of the
+// providers are references resolved like the values of driver profiles,
+// e.g. env:NAME or vault:PATH#FIELD.
+type Config struct {
+	// Zone is the domain of the nodes, the machine names are qualified
+	// with it.
+	Zone     string          `json:"zone"`
+	TTL      int64           `json:"ttl,omitempty"`
+	Provider string          `json:"provider"`
+	Route53  *Route53Config  `json:"route53,omitempty"`
+	CloudDNS *CloudDNSConfig `json:"clouddns,omitempty"`
+	RFC2136  *RFC2136Config  `json:"rfc2136,omitempty"`
+}
+
+// Provider sets the address records of the nodes.
+type Provider interface {
+	// Upsert replaces the records of the name with the addresses.
+	Upsert(fqdn string, addresses []string, ttl int64) error
+	// Delete removes the A and AAAA records of the name.
+	Delete(fqdn string) error
+}
+
+// Load reads the configuration of a YAML or JSON file.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("Failed to parse DNS configuration %s: %v", path, err)
+	}
+	c.Zone = strings.Trim(c.Zone, ".")
+	if c.Zone == "" {
+		return nil, fmt.Errorf("DNS configuration %s has no zone", path)
+	}
+	if c.TTL == 0 {
+		c.TTL = DefaultTTL
+	}
+	if _, err := c.provider(); err != nil {
+		return nil, fmt.Errorf("Invalid DNS configuration %s: %v", path, err)
+	}
+	return c, nil
+}
+
+func (c *Config) provider() (Provider, error) {
+	switch c.Provider {
+	case "route53":
+		if c.Route53 == nil || c.Route53.HostedZoneID == "" {
+			return nil, fmt.Errorf("route53 needs the hostedZoneID")
+		}
+		return c.Route53, nil
+	case "clouddns":
+		if c.CloudDNS == nil || c.CloudDNS.Project == "" || c.CloudDNS.ManagedZone == "" {
+			return nil, fmt.Errorf("clouddns needs the project and the managedZone")
+		}
+		return c.CloudDNS, nil
+	case "rfc2136":
+		if c.RFC2136 == nil || c.RFC2136.Server == "" {
+			return nil, fmt.Errorf("rfc2136 needs the server")
+		}
+		return c.RFC2136, nil
+	}
+	return nil, fmt.Errorf("unknown provider %q, expected route53, clouddns or rfc2136", c.Provider)
+}
+
+// Qualify returns the name qualified with the zone, names in the zone are
+// kept.
+func (c *Config) Qualify(name string) string {
+	name = strings.TrimSuffix(name, ".")
+	if name == c.Zone || strings.HasSuffix(name, "."+c.Zone) {
+		return name
+	}
+	return name + "." + c.Zone
+}
+
+// Register sets the A and AAAA records of the node.
+func (c *Config) Register(fqdn string, addresses []string) error {
+	p, err := c.provider()
+	if err != nil {
+		return err
+	}
+	if err := p.Upsert(fqdn, addresses, c.TTL); err != nil {
+		return fmt.Errorf("Failed to register %s in DNS: %v", fqdn, err)
+	}
+	return nil
+}
+
+// Deregister removes the records of the node.
+func (c *Config) Deregister(fqdn string) error {
+	p, err := c.provider()
+	if err != nil {
+		return err
+	}
+	if err := p.Delete(fqdn); err != nil {
+		return fmt.Errorf("Failed to deregister %s from DNS: %v", fqdn, err)
+	}
+	return nil
+}
+
+// recordTypes groups the addresses by their record type, A or AAAA.
+func recordTypes(addresses []string) map[string][]string {
+	types := map[string][]string{}
+	for _, address := range addresses {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			continue
+		}
+		if ip.To4() != nil {
+			types["A"] = append(types["A"], ip.String())
+		} else {
+			types["AAAA"] = append(types["AAAA"], ip.String())
+		}
+	}
+	return types
+}
@@ -0,0 +1,117 @@
+package nodedns
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodedns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for config, valid := range map[string]bool{
+		"zone: nodes.example.com.\nprovider: rfc2136\nrfc2136:\n  server: 10.0.0.53\n": true,
+		"zone: nodes.example.com\nprovider: route53\nroute53:\n  hostedZoneID: Z1\n":   true,
+		"zone: nodes.example.com\nprovider: clouddns\nclouddns:\n  project: p\n":       false,
+		"provider: rfc2136\nrfc2136:\n  server: 10.0.0.53\n":                           false,
+		"zone: nodes.example.com\nprovider: bind\n":                                    false,
+	} {
+		path := filepath.Join(dir, "dns.yaml")
+		if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+		c, err := Load(path)
+		if valid != (err == nil) {
+			t.Errorf("%q: expected valid %v, got %v", config, valid, err)
+			continue
+		}
+		if c != nil && (c.Zone != "nodes.example.com" || c.TTL != DefaultTTL) {
+			t.Errorf("%q: unexpected configuration %+v", config, c)
+		}
+	}
+
+	c := &Config{Zone: "nodes.example.com"}
+	for name, expected := range map[string]string{
+		"node-1":                   "node-1.nodes.example.com",
+		"node-1.nodes.example.com": "node-1.nodes.example.com",
+		"node-1.example.com":       "node-1.example.com.nodes.example.com",
+	} {
+		if fqdn := c.Qualify(name); fqdn != expected {
+			t.Errorf("%s: expected %s, got %s", name, expected, fqdn)
+		}
+	}
+}
+
+func TestRFC2136(t *testing.T) {
+	var scripts []string
+	defer func(f func(string) error) { nsupdate = f }(nsupdate)
+	nsupdate = func(script string) error {
+		scripts = append(scripts, script)
+		return nil
+	}
+
+	os.Setenv("NODEDNS_TEST_SECRET", "c2VjcmV0")
+	defer os.Unsetenv("NODEDNS_TEST_SECRET")
+	c := &Config{Zone: "example.com", TTL: 60, Provider: "rfc2136", RFC2136: &RFC2136Config{
+		Server: "10.0.0.53:5353", KeyName: "kube-machine", KeySecret: "env:NODEDNS_TEST_SECRET",
+	}}
+	if err := c.Register("node-1.example.com", []string{"10.0.0.5", "fd00::5"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Deregister("node-1.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"server 10.0.0.53 5353\nkey hmac-sha256:kube-machine c2VjcmV0\nupdate delete node-1.example.com. A\nupdate delete node-1.example.com. AAAA\n" +
+			"update add node-1.example.com. 60 A 10.0.0.5\nupdate add node-1.example.com. 60 AAAA fd00::5\nsend\n",
+		"server 10.0.0.53 5353\nkey hmac-sha256:kube-machine c2VjcmV0\nupdate delete node-1.example.com. A\nupdate delete node-1.example.com. AAAA\nsend\n",
+	}
+	if !reflect.DeepEqual(scripts, expected) {
+		t.Errorf("expected %q, got %q", expected, scripts)
+	}
+}
+
+func TestCloudDNS(t *testing.T) {
+	var changes []map[string][]cloudDNSRecordSet
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/projects/p/managedZones/z/rrsets" && r.URL.Query().Get("name") == "node-1.example.com.":
+			w.Write([]byte(`{"rrsets": [{"name": "node-1.example.com.", "type": "A", "ttl": 300, "rrdatas": ["10.0.0.4"]}, {"name": "node-1.example.com.", "type": "TXT", "ttl": 300, "rrdatas": ["x"]}]}`))
+		case r.Method == "POST" && r.URL.Path == "/projects/p/managedZones/z/changes":
+			var change map[string][]cloudDNSRecordSet
+			json.NewDecoder(r.Body).Decode(&change)
+			changes = append(changes, change)
+			w.Write([]byte(`{}`))
+		default:
+			http.Error(w, "unexpected", http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	defer func(endpoint string) { cloudDNSEndpoint = endpoint }(cloudDNSEndpoint)
+	cloudDNSEndpoint = server.URL
+
+	c := &Config{Zone: "example.com", TTL: 60, Provider: "clouddns", CloudDNS: &CloudDNSConfig{Project: "p", ManagedZone: "z", client: http.DefaultClient}}
+	if err := c.Register("node-1.example.com", []string{"10.0.0.5"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Deregister("node-1.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	old := cloudDNSRecordSet{Name: "node-1.example.com.", Type: "A", TTL: 300, RRDatas: []string{"10.0.0.4"}}
+	expected := []map[string][]cloudDNSRecordSet{
+		{"additions": {{Name: "node-1.example.com.", Type: "A", TTL: 60, RRDatas: []string{"10.0.0.5"}}}, "deletions": {old}},
+		{"deletions": {old}},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %+v, got %+v", expected, changes)
+	}
+}
@@ -0,0 +1,98 @@
+package nodedns
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/kubermatic/kube-machine/pkg/driverprofiles"
+)
+
+// RFC2136Config is a DNS server accepting dynamic updates, e.g. BIND. The
+// updates are sent with nsupdate, signed with the TSIG key if there is one.
+type RFC2136Config struct {
+	// Server is the address of the server with an optional port.
+	Server       string `json:"server"`
+	KeyName      string `json:"keyName,omitempty"`
+	KeyAlgorithm string `json:"keyAlgorithm,omitempty"`
+	// KeySecret is a reference to the base64 TSIG secret.
+	KeySecret string `json:"keySecret,omitempty"`
+}
+
+// nsupdate runs the update script, the secret is passed on stdin to keep it
+// off the command line.
+var nsupdate = func(script string) error {
+	cmd := exec.Command("nsupdate")
+	cmd.Stdin = strings.NewReader(script)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("nsupdate failed (error: %v): %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func (r *RFC2136Config) script(commands []string) (string, error) {
+	var b bytes.Buffer
+	if host, port, err := net.SplitHostPort(r.Server); err == nil {
+		fmt.Fprintf(&b, "server %s %s\n", host, port)
+	} else {
+		fmt.Fprintf(&b, "server %s\n", r.Server)
+	}
+	if r.KeyName != "" {
+		secret, err := driverprofiles.Resolve(r.KeySecret)
+		if err != nil {
+			return "", err
+		}
+		algorithm := r.KeyAlgorithm
+		if algorithm == "" {
+			algorithm = "hmac-sha256"
+		}
+		fmt.Fprintf(&b, "key %s:%s %s\n", algorithm, r.KeyName, secret)
+	}
+	for _, command := range commands {
+		fmt.Fprintln(&b, command)
+	}
+	fmt.Fprintln(&b, "send")
+	return b.String(), nil
+}
+
+func deleteCommands(fqdn string) []string {
+	return []string{
+		fmt.Sprintf("update delete %s. A", fqdn),
+		fmt.Sprintf("update delete %s. AAAA", fqdn),
+	}
+}
+
+func (r *RFC2136Config) Upsert(fqdn string, addresses []string, ttl int64) error {
+	types := recordTypes(addresses)
+	if len(types) == 0 {
+		return fmt.Errorf("no IP addresses in %v", addresses)
+	}
+	var names []string
+	for recordType := range types {
+		names = append(names, recordType)
+	}
+	sort.Strings(names)
+	commands := deleteCommands(fqdn)
+	for _, recordType := range names {
+		for _, ip := range types[recordType] {
+			commands = append(commands, fmt.Sprintf("update add %s. %d %s %s", fqdn, ttl, recordType, ip))
+		}
+	}
+	script, err := r.script(commands)
+	if err != nil {
+		return err
+	}
+	return nsupdate(script)
+}
+
+func (r *RFC2136Config) Delete(fqdn string) error {
+	script, err := r.script(deleteCommands(fqdn))
+	if err != nil {
+		return err
+	}
+	return nsupdate(script)
+}
@@ -0,0 +1,98 @@
+package nodedns
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/kubermatic/kube-machine/pkg/driverprofiles"
+)
+
+// Route53Config is the hosted zone of the nodes. The default credentials of
+// the AWS SDK are used without access key.
+type Route53Config struct {
+	HostedZoneID string `json:"hostedZoneID"`
+	AccessKey    string `json:"accessKey,omitempty"`
+	SecretKey    string `json:"secretKey,omitempty"`
+}
+
+func (r *Route53Config) client() (*route53.Route53, error) {
+	config := aws.NewConfig()
+	if r.AccessKey != "" {
+		accessKey, err := driverprofiles.Resolve(r.AccessKey)
+		if err != nil {
+			return nil, err
+		}
+		secretKey, err := driverprofiles.Resolve(r.SecretKey)
+		if err != nil {
+			return nil, err
+		}
+		config = config.WithCredentials(credentials.NewStaticCredentials(accessKey, secretKey, ""))
+	}
+	return route53.New(session.New(config)), nil
+}
+
+func (r *Route53Config) Upsert(fqdn string, addresses []string, ttl int64) error {
+	client, err := r.client()
+	if err != nil {
+		return err
+	}
+	var changes []*route53.Change
+	for recordType, ips := range recordTypes(addresses) {
+		var records []*route53.ResourceRecord
+		for _, ip := range ips {
+			records = append(records, &route53.ResourceRecord{Value: aws.String(ip)})
+		}
+		changes = append(changes, &route53.Change{
+			Action: aws.String(route53.ChangeActionUpsert),
+			ResourceRecordSet: &route53.ResourceRecordSet{
+				Name:            aws.String(fqdn),
+				Type:            aws.String(recordType),
+				TTL:             aws.Int64(ttl),
+				ResourceRecords: records,
+			},
+		})
+	}
+	if len(changes) == 0 {
+		return fmt.Errorf("no IP addresses in %v", addresses)
+	}
+	_, err = client.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(r.HostedZoneID),
+		ChangeBatch:  &route53.ChangeBatch{Changes: changes},
+	})
+	return err
+}
+
+func (r *Route53Config) Delete(fqdn string) error {
+	// A deletion must match the record set, so it is looked up.
+	client, err := r.client()
+	if err != nil {
+		return err
+	}
+	out, err := client.ListResourceRecordSets(&route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(r.HostedZoneID),
+		StartRecordName: aws.String(fqdn),
+	})
+	if err != nil {
+		return err
+	}
+	var changes []*route53.Change
+	for _, set := range out.ResourceRecordSets {
+		if aws.StringValue(set.Name) != fqdn+"." {
+			continue
+		}
+		if t := aws.StringValue(set.Type); t == "A" || t == "AAAA" {
+			changes = append(changes, &route53.Change{Action: aws.String(route53.ChangeActionDelete), ResourceRecordSet: set})
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	_, err = client.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(r.HostedZoneID),
+		ChangeBatch:  &route53.ChangeBatch{Changes: changes},
+	})
+	return err
+}
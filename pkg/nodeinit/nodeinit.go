@@ -0,0 +1,166 @@
+package nodeinit
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/kubermatic/kube-machine/pkg/drain"
+	"github.com/kubermatic/kube-machine/pkg/initsystem"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	kcorev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	TaintKey       = "kube-machine.kubermatic.io/uninitialized"
+	DefaultTimeout = 5 * time.Minute
+
+	pollInterval = 10 * time.Second
+)
+
+// Taint keeps workloads off new nodes until they passed the verification.
+// Pods needed to complete the node, like the CNI daemon set, need to
+// tolerate it, see Toleration.
+var Taint = kcorev1.Taint{
+	Key:    TaintKey,
+	Effect: kcorev1.TaintEffectNoSchedule,
+}
+
+// Toleration lets the pods completing a node run on it before it is
+// initialized. The daemon set of the CNI needs it in its pod template,
+// otherwise the node never gets a network and never becomes ready:
+//
+//	tolerations:
+//	- key: kube-machine.kubermatic.io/uninitialized
+//	  operator: Exists
+//	  effect: NoSchedule
+var Toleration = kcorev1.Toleration{
+	Key:      TaintKey,
+	Operator: kcorev1.TolerationOpExists,
+	Effect:   kcorev1.TaintEffectNoSchedule,
+}
+
+// Tolerates returns whether one of the tolerations tolerates the taint.
+func Tolerates(tolerations []kcorev1.Toleration) bool {
+	for _, t := range tolerations {
+		if t.Effect != "" && t.Effect != Taint.Effect {
+			continue
+		}
+		if t.Key == "" && t.Operator == kcorev1.TolerationOpExists {
+			return true
+		}
+		if t.Key == TaintKey && (t.Operator == kcorev1.TolerationOpExists || t.Value == "") {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckCNITolerations checks that the daemon sets of the CNI in kube-system,
+// the ones whose name contains the name of the CNI, tolerate the taint.
+func CheckCNITolerations(client kubernetes.Interface, cni string) error {
+	if cni == "" {
+		return nil
+	}
+	list, err := client.ExtensionsV1beta1().DaemonSets(metav1.NamespaceSystem).List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("Failed to list the daemon sets: %v", err)
+	}
+	var missing []string
+	for _, ds := range list.Items {
+		if strings.Contains(ds.Name, cni) && !Tolerates(ds.Spec.Template.Spec.Tolerations) {
+			missing = append(missing, ds.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("The daemon sets %s don't tolerate the %s taint of new nodes, add the toleration {key: %s, operator: Exists, effect: NoSchedule} to their pods",
+			strings.Join(missing, ", "), TaintKey, TaintKey)
+	}
+	return nil
+}
+
+// Runner runs commands on the node over SSH.
+type Runner interface {
+	RunSSHCommand(command string) (string, error)
+}
+
+// Check is a verification of a provisioned node, it passes if its command
+// succeeds.
+type Check struct {
+	Name    string
+	Command string
+}
+
+// Checks verify that the container runtime and the kubelet are running and
+// the CNI is installed.
+var Checks = []Check{
+	{Name: "runtime", Command: "sudo docker info >/dev/null"},
+	{Name: "kubelet", Command: initsystem.RunningCommand("kubelet")},
+	{Name: "cni-config", Command: "ls /etc/cni/net.d/*.conf /etc/cni/net.d/*.conflist 2>/dev/null | grep -q ."},
+	{Name: "cni-plugins", Command: `test -n "$(ls -A /opt/cni/bin 2>/dev/null)"`},
+}
+
+// Verify runs all checks on the node and returns an error naming the failed
+// ones.
+func Verify(r Runner) error {
+	var failed []string
+	for _, check := range Checks {
+		if _, err := r.RunSSHCommand(check.Command); err != nil {
+			log.Debugf("Check %s failed: %v", check.Name, err)
+			failed = append(failed, check.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("Failed checks: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// Initialize waits until the node passes the verification and removes the
+// taint then.
+func Initialize(client kubernetes.Interface, r Runner, nodeName string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := Verify(r)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Node %s did not pass the verification: %v", nodeName, err)
+		}
+		log.Debugf("Node %s is not initialized yet: %v", nodeName, err)
+		time.Sleep(pollInterval)
+	}
+	return drain.SetTaint(client, nodeName, Taint, false)
+}
+
+// InitializeReady waits until the node is ready and removes the taint then,
+// for nodes which can't be verified over SSH. A ready node runs the runtime
+// and the kubelet and has a network plugin.
+func InitializeReady(client kubernetes.Interface, nodeName string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		node, err := client.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+		if err == nil && drain.Ready(node) {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Node %s did not become ready", nodeName)
+		}
+		log.Debugf("Node %s is not ready yet", nodeName)
+		time.Sleep(pollInterval)
+	}
+	return drain.SetTaint(client, nodeName, Taint, false)
+}
+
+// Uninitialized returns whether the node still carries the taint.
+func Uninitialized(node *kcorev1.Node) bool {
+	for _, t := range node.Spec.Taints {
+		if t.Key == TaintKey {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package nodeinit
+
+import (
+	"errors"
+	"testing"
+
+	kcorev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+type fakeRunner struct {
+	failing map[string]bool
+}
+
+func (r *fakeRunner) RunSSHCommand(command string) (string, error) {
+	if r.failing[command] {
+		return "", errors.New("exit status 1")
+	}
+	return "", nil
+}
+
+func TestVerify(t *testing.T) {
+	if err := Verify(&fakeRunner{}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	r := &fakeRunner{failing: map[string]bool{Checks[2].Command: true, Checks[3].Command: true}}
+	err := Verify(r)
+	if err == nil || err.Error() != "Failed checks: cni-config, cni-plugins" {
+		t.Errorf("expected the CNI checks to fail, got %v", err)
+	}
+}
+
+func TestTolerates(t *testing.T) {
+	tests := []struct {
+		toleration kcorev1.Toleration
+		tolerates  bool
+	}{
+		{Toleration, true},
+		{kcorev1.Toleration{Operator: kcorev1.TolerationOpExists}, true},
+		{kcorev1.Toleration{Key: TaintKey, Operator: kcorev1.TolerationOpEqual}, true},
+		{kcorev1.Toleration{Key: TaintKey, Operator: kcorev1.TolerationOpExists, Effect: kcorev1.TaintEffectNoExecute}, false},
+		{kcorev1.Toleration{Key: "other", Operator: kcorev1.TolerationOpExists}, false},
+	}
+	for _, test := range tests {
+		if Tolerates([]kcorev1.Toleration{test.toleration}) != test.tolerates {
+			t.Errorf("%+v: expected tolerates=%v", test.toleration, test.tolerates)
+		}
+	}
+	if Tolerates(nil) {
+		t.Error("expected no tolerations not to tolerate the taint")
+	}
+}
+
+func TestUninitialized(t *testing.T) {
+	node := &kcorev1.Node{}
+	if Uninitialized(node) {
+		t.Error("expected a node without taints to be initialized")
+	}
+	node.Spec.Taints = []kcorev1.Taint{{Key: "other", Effect: kcorev1.TaintEffectNoSchedule}, Taint}
+	if !Uninitialized(node) {
+		t.Error("expected the tainted node to be uninitialized")
+	}
+}
@@ -0,0 +1,118 @@
+// Package nodepaths defines where the kubelet kubeconfig and unit are kept
+// on the nodes. Distributions with a read-only or managed /etc need other
+// locations than the defaults.
+package nodepaths
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/kubermatic/kube-machine/pkg/remote"
+)
+
+// The profiles of the paths.
+const (
+	// ProfileDefault keeps the kubeconfig in /etc and the unit in the
+	// systemd unit directory of the administrator.
+	ProfileDefault = "default"
+	// ProfileKubernetes keeps the kubeconfig in /etc/kubernetes like
+	// kubeadm, e.g. for Fedora CoreOS and RHCOS.
+	ProfileKubernetes = "kubernetes"
+	// ProfileRuntime keeps both in the runtime directories in /run, e.g.
+	// for NixOS whose /etc is generated. The node needs to be provisioned
+	// again after a reboot.
+	ProfileRuntime = "runtime"
+)
+
+// Paths are the locations of the files of the kubelet on a node.
+type Paths struct {
+	Kubeconfig  string
+	KubeletUnit string
+}
+
+var profiles = map[string]Paths{
+	ProfileDefault:    {Kubeconfig: "/etc/kubeconfig", KubeletUnit: "/etc/systemd/system/kubelet.service"},
+	ProfileKubernetes: {Kubeconfig: "/etc/kubernetes/kubelet.conf", KubeletUnit: "/etc/systemd/system/kubelet.service"},
+	ProfileRuntime:    {Kubeconfig: "/run/kubernetes/kubelet.conf", KubeletUnit: "/run/systemd/system/kubelet.service"},
+}
+
+// Profiles are the known profiles.
+var Profiles = []string{ProfileDefault, ProfileKubernetes, ProfileRuntime}
+
+// osProfiles are the profiles of the distributions by the ID of their
+// os-release, the others use the default profile.
+var osProfiles = map[string]string{
+	"fedora-coreos": ProfileKubernetes,
+	"rhcos":         ProfileKubernetes,
+	"nixos":         ProfileRuntime,
+}
+
+// Default are the paths of machines created before they were configurable.
+var Default = profiles[ProfileDefault]
+
+// Validate checks that the profile is known, empty selects the profile by
+// the OS of the node.
+func Validate(profile string) error {
+	if profile == "" {
+		return nil
+	}
+	if _, ok := profiles[profile]; !ok {
+		return fmt.Errorf("Unknown node path profile %q, expected one of %s", profile, strings.Join(Profiles, ", "))
+	}
+	return nil
+}
+
+// ValidatePath checks that an overridden path is absolute and clean, a
+// kubelet unit path has to name the kubelet service.
+func ValidatePath(p string, unit bool) error {
+	if p == "" {
+		return nil
+	}
+	if err := remote.ValidatePath(p); err != nil {
+		return err
+	}
+	if unit && path.Base(p) != "kubelet.service" {
+		return fmt.Errorf("Expected the path of kubelet.service, got %q", p)
+	}
+	return nil
+}
+
+// ForOS returns the profile of the distribution with the os-release ID.
+func ForOS(osID string) string {
+	if profile, ok := osProfiles[osID]; ok {
+		return profile
+	}
+	return ProfileDefault
+}
+
+// Resolve returns the paths of the profile, or the profile of the OS if
+// empty, with the paths set in the overrides replacing its paths.
+func Resolve(profile, osID string, overrides Paths) (Paths, error) {
+	if profile == "" {
+		profile = ForOS(osID)
+	}
+	paths, ok := profiles[profile]
+	if !ok {
+		return Paths{}, Validate(profile)
+	}
+	if overrides.Kubeconfig != "" {
+		paths.Kubeconfig = overrides.Kubeconfig
+	}
+	if overrides.KubeletUnit != "" {
+		paths.KubeletUnit = overrides.KubeletUnit
+	}
+	return paths, nil
+}
+
+// WithDefaults returns the paths with the unset ones replaced by the
+// default paths.
+func (p Paths) WithDefaults() Paths {
+	if p.Kubeconfig == "" {
+		p.Kubeconfig = Default.Kubeconfig
+	}
+	if p.KubeletUnit == "" {
+		p.KubeletUnit = Default.KubeletUnit
+	}
+	return p
+}
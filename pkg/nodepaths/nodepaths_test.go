@@ -0,0 +1,45 @@
+package nodepaths
+
+import "testing"
+
+func TestResolve(t *testing.T) {
+	for _, test := range []struct {
+		profile, osID string
+		overrides     Paths
+		expected      Paths
+	}{
+		{"", "ubuntu", Paths{}, Default},
+		{"", "fedora-coreos", Paths{}, Paths{Kubeconfig: "/etc/kubernetes/kubelet.conf", KubeletUnit: "/etc/systemd/system/kubelet.service"}},
+		{ProfileDefault, "nixos", Paths{}, Default},
+		{"", "nixos", Paths{KubeletUnit: "/etc/systemd/system/kubelet.service"}, Paths{Kubeconfig: "/run/kubernetes/kubelet.conf", KubeletUnit: "/etc/systemd/system/kubelet.service"}},
+		{ProfileKubernetes, "", Paths{Kubeconfig: "/var/lib/kubelet/kubeconfig"}, Paths{Kubeconfig: "/var/lib/kubelet/kubeconfig", KubeletUnit: "/etc/systemd/system/kubelet.service"}},
+	} {
+		paths, err := Resolve(test.profile, test.osID, test.overrides)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if paths != test.expected {
+			t.Errorf("Expected %+v for %q on %q, got %+v", test.expected, test.profile, test.osID, paths)
+		}
+	}
+
+	if _, err := Resolve("immutable", "", Paths{}); err == nil {
+		t.Error("Expected an error for an unknown profile")
+	}
+	if paths := (Paths{KubeletUnit: "/run/systemd/system/kubelet.service"}).WithDefaults(); paths.Kubeconfig != Default.Kubeconfig || paths.KubeletUnit != "/run/systemd/system/kubelet.service" {
+		t.Errorf("Unexpected paths %+v", paths)
+	}
+}
+
+func TestValidatePath(t *testing.T) {
+	for p, unit := range map[string]bool{"": true, "/etc/kubernetes/kubelet.conf": false, "/run/systemd/system/kubelet.service": true} {
+		if err := ValidatePath(p, unit); err != nil {
+			t.Errorf("%q: unexpected error %v", p, err)
+		}
+	}
+	for p, unit := range map[string]bool{"etc/kubeconfig": false, "/etc/../kubeconfig": false, "/etc/systemd/system/kubelet-custom.service": true} {
+		if err := ValidatePath(p, unit); err == nil {
+			t.Errorf("%q: expected an error", p)
+		}
+	}
+}
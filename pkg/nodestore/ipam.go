@@ -0,0 +1,80 @@
+package nodestore
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/kubermatic/kube-machine/pkg/ipam"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	kcorev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// IPAMConfigMapName is the config map in kube-system holding the
+	// addresses of the static IPAM pools.
+	IPAMConfigMapName = "kube-machine-ipam"
+	ipamKey           = "allocations"
+)
+
+// IPAMStore keeps the allocations of the static IPAM pools in a config map
+// of the cluster. It is updated with the resource version it was read with,
+// so all kube-machine processes of the cluster share the pools.
+type IPAMStore struct {
+	Client kubernetes.Interface
+}
+
+var _ ipam.Store = IPAMStore{}
+
+// Load reads the allocations, the version is the resource version of the
+// config map.
+func (s IPAMStore) Load() (map[string]string, string, error) {
+	configMap, err := s.Client.CoreV1().ConfigMaps(metav1.NamespaceSystem).Get(IPAMConfigMapName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to get config map %s: %v", IPAMConfigMapName, err)
+	}
+	allocations := map[string]string{}
+	if data := configMap.Data[ipamKey]; data != "" {
+		if err := json.Unmarshal([]byte(data), &allocations); err != nil {
+			return nil, "", fmt.Errorf("Failed to parse config map %s: %v", IPAMConfigMapName, err)
+		}
+	}
+	return allocations, configMap.ResourceVersion, nil
+}
+
+// Save creates or updates the config map, it fails with ipam.ErrConflict if
+// it changed since it was read.
+func (s IPAMStore) Save(allocations map[string]string, version string) error {
+	if err := writable("save the IPAM allocations"); err != nil {
+		return err
+	}
+	data, err := json.Marshal(allocations)
+	if err != nil {
+		return err
+	}
+	configMap := &kcorev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            IPAMConfigMapName,
+			Namespace:       metav1.NamespaceSystem,
+			ResourceVersion: version,
+		},
+		Data: map[string]string{ipamKey: string(data)},
+	}
+	configMaps := s.Client.CoreV1().ConfigMaps(metav1.NamespaceSystem)
+	if version == "" {
+		_, err = configMaps.Create(configMap)
+	} else {
+		_, err = configMaps.Update(configMap)
+	}
+	if errors.IsAlreadyExists(err) || errors.IsConflict(err) {
+		return ipam.ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to save config map %s: %v", IPAMConfigMapName, err)
+	}
+	return nil
+}
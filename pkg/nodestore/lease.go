@@ -0,0 +1,165 @@
+package nodestore
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/kubermatic/kube-machine/pkg/lock"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// leasesPath is the path of the leases in kube-system, the client
+	// predates the coordination API so they are requested as JSON.
+	leasesPath = "/apis/coordination.k8s.io/v1/namespaces/" + metav1.NamespaceSystem + "/leases"
+	// LeasePrefix prefixes the names of the leases locking the machines.
+	LeasePrefix = "kube-machine-lock-"
+	// microTimeFormat is the format of the times of a lease.
+	microTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// lease is a coordination.k8s.io/v1 Lease.
+type lease struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   metav1.ObjectMeta `json:"metadata"`
+	Spec       leaseSpec         `json:"spec"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+}
+
+// record returns the lock of the lease, a lease without a parseable lock
+// isn't locked.
+func (l *lease) record() (lock.Record, bool) {
+	r := lock.Record{}
+	data, exists := l.Metadata.Annotations[LockAnnotationKey]
+	if !exists || json.Unmarshal([]byte(data), &r) != nil {
+		return r, false
+	}
+	return r, true
+}
+
+func (l *lease) set(r lock.Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if l.Metadata.Annotations == nil {
+		l.Metadata.Annotations = map[string]string{}
+	}
+	l.Metadata.Annotations[LockAnnotationKey] = string(data)
+	l.Spec = leaseSpec{
+		HolderIdentity:       r.Holder,
+		LeaseDurationSeconds: r.TTLSeconds,
+		AcquireTime:          r.Acquired.UTC().Format(microTimeFormat),
+		RenewTime:            r.Renewed.UTC().Format(microTimeFormat),
+	}
+	return nil
+}
+
+// TryLock stores the lock of the machine in its lease in kube-system. The
+// lease is created or updated with the resource version it was read with,
+// so only one holder wins a race.
+func (s NodeStore) TryLock(name string, r lock.Record, now time.Time) (err error) {
+	defer observe("lock", &err)()
+	if err := writable("lock " + name); err != nil {
+		return err
+	}
+
+	l, err := s.lease(name)
+	if errors.IsNotFound(err) {
+		l = &lease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   metav1.ObjectMeta{Name: LeasePrefix + name, Namespace: metav1.NamespaceSystem},
+		}
+		if err := l.set(r); err != nil {
+			return err
+		}
+		err = s.saveLease(l, true)
+		if errors.IsAlreadyExists(err) {
+			return s.lockedBy(name)
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if existing, locked := l.record(); locked && existing.Holder != r.Holder && !existing.Stale(now) {
+		return lock.ErrLocked{Machine: name, Record: existing}
+	}
+	if err := l.set(r); err != nil {
+		return err
+	}
+	err = s.saveLease(l, false)
+	if errors.IsConflict(err) {
+		return s.lockedBy(name)
+	}
+	return err
+}
+
+// Unlock removes the lease of the machine if the holder has its lock.
+func (s NodeStore) Unlock(name, holder string) (err error) {
+	defer observe("unlock", &err)()
+	if err := writable("unlock " + name); err != nil {
+		return err
+	}
+
+	l, err := s.lease(name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if existing, locked := l.record(); locked && existing.Holder != holder {
+		return nil
+	}
+	err = s.Client.CoreV1().RESTClient().Delete().AbsPath(leasesPath, l.Metadata.Name).Do().Error()
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (s NodeStore) lease(name string) (*lease, error) {
+	data, err := s.Client.CoreV1().RESTClient().Get().AbsPath(leasesPath, LeasePrefix+name).Do().Raw()
+	if err != nil {
+		return nil, err
+	}
+	l := &lease{}
+	if err := json.Unmarshal(data, l); err != nil {
+		return nil, fmt.Errorf("Failed to parse the lease of %s: %v", name, err)
+	}
+	return l, nil
+}
+
+func (s NodeStore) saveLease(l *lease, create bool) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	client := s.Client.CoreV1().RESTClient()
+	if create {
+		return client.Post().AbsPath(leasesPath).Body(data).Do().Error()
+	}
+	return client.Put().AbsPath(leasesPath, l.Metadata.Name).Body(data).Do().Error()
+}
+
+// lockedBy returns the lock of the holder which won a race for the lease.
+func (s NodeStore) lockedBy(name string) error {
+	l, err := s.lease(name)
+	if err != nil {
+		return err
+	}
+	existing, _ := l.record()
+	return lock.ErrLocked{Machine: name, Record: existing}
+}
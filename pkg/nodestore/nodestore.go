@@ -1,10 +1,14 @@
 package nodestore
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -19,17 +23,168 @@ import (
 	"github.com/docker/machine/libmachine/host"
 	"github.com/docker/machine/libmachine/log"
 	"github.com/docker/machine/libmachine/mcnerror"
+	"github.com/docker/machine/libmachine/persist"
+
+	"github.com/kubermatic/kube-machine/pkg/adopt"
+	"github.com/kubermatic/kube-machine/pkg/claims"
+	"github.com/kubermatic/kube-machine/pkg/deadline"
+	"github.com/kubermatic/kube-machine/pkg/metadata"
+	"github.com/kubermatic/kube-machine/pkg/metrics"
+	"github.com/kubermatic/kube-machine/pkg/nodeinit"
+	"github.com/kubermatic/kube-machine/pkg/oplog"
+	"github.com/kubermatic/kube-machine/pkg/patching"
+	"github.com/kubermatic/kube-machine/pkg/pause"
+	"github.com/kubermatic/kube-machine/pkg/protection"
+	"github.com/kubermatic/kube-machine/pkg/recording"
+	"github.com/kubermatic/kube-machine/pkg/resources"
+	"github.com/kubermatic/kube-machine/pkg/rollback"
+	"github.com/kubermatic/kube-machine/pkg/topology"
+	"github.com/kubermatic/kube-machine/pkg/tracing"
 )
 
 const (
 	KubeMachineAnnotationKey = "node.alpha.kubernetes.io/kube-machine"
 	KubeMachineLabel         = "kube-machine"
+	AppliedSpecAnnotationKey = "node.alpha.kubernetes.io/kube-machine-applied-spec"
+	PatchStatusAnnotationKey = "node.alpha.kubernetes.io/kube-machine-patch-status"
+	// CreateFailureAnnotationKey marks machines whose creation failed after
+	// resources were created at the provider.
+	CreateFailureAnnotationKey = "node.alpha.kubernetes.io/kube-machine-create-failure"
+	// AdoptedKubeletAnnotationKey holds the kubelet configuration of nodes
+	// which were not created by kube-machine.
+	AdoptedKubeletAnnotationKey = "node.alpha.kubernetes.io/kube-machine-adopted-kubelet"
+	// MaintenanceAnnotationKey marks machines taken out of service.
+	MaintenanceAnnotationKey = "node.alpha.kubernetes.io/kube-machine-maintenance"
+	// FailedAnnotationKey marks machines whose VM disappeared at the provider.
+	FailedAnnotationKey = "node.alpha.kubernetes.io/kube-machine-failed"
+	// OperationsAnnotationKey holds the log of the last operations of the
+	// machine.
+	OperationsAnnotationKey = "node.alpha.kubernetes.io/kube-machine-operations"
+	// ResourcesAnnotationKey holds the capacity of the machine detected
+	// during provisioning.
+	ResourcesAnnotationKey = "node.alpha.kubernetes.io/kube-machine-resources"
+	// SSHSessionsAnnotationKey holds who connected to the machine with
+	// kube-machine ssh and when.
+	SSHSessionsAnnotationKey = "node.alpha.kubernetes.io/kube-machine-ssh-sessions"
+	// MetadataAnnotationKey holds the user metadata of the machine and
+	// what was propagated from it.
+	MetadataAnnotationKey = "node.alpha.kubernetes.io/kube-machine-metadata"
+	// ProvisionedStateAnnotationKey holds the hash of the artifacts the
+	// machine was last provisioned with.
+	ProvisionedStateAnnotationKey = "node.alpha.kubernetes.io/kube-machine-provisioned-state"
+	// ProtectionAnnotationKey marks machines which must not be deleted.
+	ProtectionAnnotationKey = "node.alpha.kubernetes.io/kube-machine-protection"
+	// PausedAnnotationKey marks machines the controller must not act on.
+	PausedAnnotationKey = "node.alpha.kubernetes.io/kube-machine-paused"
+	// ClaimAnnotationKey records the claim owning the machine.
+	ClaimAnnotationKey = "node.alpha.kubernetes.io/kube-machine-claim"
+	// AddressAnnotationKey records the address the address-dependent
+	// configuration of the machine was provisioned for.
+	AddressAnnotationKey = "node.alpha.kubernetes.io/kube-machine-address"
+	// LockAnnotationKey holds the advisory lock of a mutating operation on
+	// the lease of the machine.
+	LockAnnotationKey = "node.alpha.kubernetes.io/kube-machine-lock"
+	// UpgradeRollbackAnnotationKey marks machines whose upgrade failed and
+	// was rolled back.
+	UpgradeRollbackAnnotationKey = "node.alpha.kubernetes.io/kube-machine-upgrade-rollback"
 )
 
 var (
 	defaultConfig = filepath.Join(os.Getenv("HOME"), ".kube", "config")
+
+	// ControlPlaneLabels mark the nodes of the control plane, which are no
+	// machines of kube-machine unless included explicitly.
+	ControlPlaneLabels = []string{"node-role.kubernetes.io/master", "node-role.kubernetes.io/control-plane"}
 )
 
+// controlPlane is the access of all stores to the nodes of the control
+// plane.
+var controlPlane = struct {
+	sync.RWMutex
+	include bool
+	remove  bool
+}{}
+
+// SetControlPlaneAccess makes the stores list the nodes of the control plane
+// as machines if include is set, and remove them if remove is set.
+func SetControlPlaneAccess(include, remove bool) {
+	controlPlane.Lock()
+	defer controlPlane.Unlock()
+	controlPlane.include = include || remove
+	controlPlane.remove = remove
+}
+
+// IsControlPlane returns whether the node is part of the control plane.
+func IsControlPlane(node *kcorev1.Node) bool {
+	for _, label := range ControlPlaneLabels {
+		if _, ok := node.Labels[label]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrControlPlane is returned when a node of the control plane would be
+// removed.
+type ErrControlPlane struct {
+	Name string
+}
+
+func (e ErrControlPlane) Error() string {
+	return fmt.Sprintf("%s is a node of the control plane, use --i-know-what-i-am-doing to remove it", e.Name)
+}
+
+// CheckRemove returns ErrControlPlane if the node is part of the control plane
+// and removing it was not allowed.
+func CheckRemove(node *kcorev1.Node) error {
+	controlPlane.RLock()
+	defer controlPlane.RUnlock()
+	if !controlPlane.remove && IsControlPlane(node) {
+		return ErrControlPlane{Name: node.Name}
+	}
+	return nil
+}
+
+// readOnly refuses the mutations of all stores and of the clients of
+// NewClient.
+var readOnly = struct {
+	sync.RWMutex
+	enabled bool
+}{}
+
+// SetReadOnly makes the stores refuse to change the machines and the clients
+// refuse all requests but reads, e.g. for dashboards and service accounts
+// which may only list the machines.
+func SetReadOnly(enabled bool) {
+	readOnly.Lock()
+	defer readOnly.Unlock()
+	readOnly.enabled = enabled
+}
+
+// ReadOnly returns whether the stores are read-only.
+func ReadOnly() bool {
+	readOnly.RLock()
+	defer readOnly.RUnlock()
+	return readOnly.enabled
+}
+
+// ErrReadOnly is returned for mutations in read-only mode.
+type ErrReadOnly struct {
+	Operation string
+}
+
+func (e ErrReadOnly) Error() string {
+	return fmt.Sprintf("The store is read-only, refusing to %s", e.Operation)
+}
+
+// writable returns ErrReadOnly for the operation in read-only mode.
+func writable(operation string) error {
+	if ReadOnly() {
+		return ErrReadOnly{Operation: operation}
+	}
+	return nil
+}
+
 type NodeStore struct {
 	Path             string
 	CaCertPath       string
@@ -38,29 +193,91 @@ type NodeStore struct {
 	nodes            map[string]*kcorev1.Node
 }
 
-func NewNodeStore(path, caCertPath, caPrivateKeyPath string, kubeconfig string) *NodeStore {
-	var (
-		err    error
-		config *rest.Config
-	)
+// RestConfig returns the client config of the kubeconfig. Without a
+// kubeconfig the default one or the in-cluster config is used.
+func RestConfig(kubeconfig string) (*rest.Config, error) {
 	if _, err := os.Stat(defaultConfig); kubeconfig == "" && os.IsNotExist(err) {
-		config, err = rest.InClusterConfig()
+		config, err := rest.InClusterConfig()
 		if err != nil {
-			panic(err.Error())
+			return nil, fmt.Errorf("Failed to load in-cluster config: %v", err)
 		}
-	} else {
-		if kubeconfig == "" {
-			kubeconfig = defaultConfig
-		}
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
-		if err != nil {
-			log.Errorf("Failed to load kubeconfig %q: %v", kubeconfig, err)
-			os.Exit(1)
+		config.WrapTransport = wrapTransport
+		return config, nil
+	}
+
+	if kubeconfig == "" {
+		kubeconfig = defaultConfig
+	}
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load kubeconfig %q: %v", kubeconfig, err)
+	}
+	config.WrapTransport = wrapTransport
+	return config, nil
+}
+
+// requestContext bounds the requests of all clients, the client-go version
+// in use doesn't take a context.
+var requestContext = struct {
+	sync.RWMutex
+	ctx     context.Context
+	timeout time.Duration
+}{}
+
+// SetRequestContext aborts the cluster requests once the context ends, each
+// request is additionally limited to the timeout if it is positive. It
+// applies to clients created before as well.
+func SetRequestContext(ctx context.Context, timeout time.Duration) {
+	requestContext.Lock()
+	defer requestContext.Unlock()
+	requestContext.ctx = ctx
+	requestContext.timeout = timeout
+}
+
+// RequestContext returns the context and timeout of SetRequestContext.
+func RequestContext() (context.Context, time.Duration) {
+	requestContext.RLock()
+	defer requestContext.RUnlock()
+	return requestContext.ctx, requestContext.timeout
+}
+
+func wrapTransport(rt http.RoundTripper) http.RoundTripper {
+	return contextTransport{rt}
+}
+
+type contextTransport struct {
+	rt http.RoundTripper
+}
+
+func (t contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		if err := writable(req.Method + " " + req.URL.Path); err != nil {
+			return nil, err
 		}
 	}
-	client, err := kubernetes.NewForConfig(config)
+	requestContext.RLock()
+	ctx, timeout := requestContext.ctx, requestContext.timeout
+	requestContext.RUnlock()
+	return deadline.Transport(ctx, timeout, t.rt).RoundTrip(req)
+}
+
+// NewClient returns a client for the cluster of the kubeconfig, see
+// RestConfig.
+func NewClient(kubeconfig string) (kubernetes.Interface, error) {
+	config, err := RestConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+func NewNodeStore(path, caCertPath, caPrivateKeyPath string, kubeconfig string) *NodeStore {
+	client, err := NewClient(kubeconfig)
 	if err != nil {
-		panic(err.Error())
+		log.Error(err)
+		os.Exit(1)
 	}
 
 	return &NodeStore{
@@ -71,11 +288,27 @@ func NewNodeStore(path, caCertPath, caPrivateKeyPath string, kubeconfig string)
 	}
 }
 
+// observe records the latency and a trace span of a store operation, it is
+// meant to be deferred with a pointer to the named error result.
+func observe(operation string, err *error) func() {
+	start := time.Now()
+	span := tracing.Start("kubernetes.nodes." + operation)
+	return func() {
+		metrics.StoreOperationDuration.ObserveSince(start, operation, metrics.Result(*err))
+		span.End(*err)
+	}
+}
+
 func (s NodeStore) GetMachinesDir() string {
 	return filepath.Join(s.Path, "machines")
 }
 
-func (s NodeStore) Save(host *host.Host) error {
+func (s NodeStore) Save(host *host.Host) (err error) {
+	defer observe("save", &err)()
+	if err := writable(fmt.Sprintf("save %s", host.Name)); err != nil {
+		return err
+	}
+
 	data, err := json.MarshalIndent(host, "", "    ")
 	if err != nil {
 		return err
@@ -89,9 +322,12 @@ func (s NodeStore) Save(host *host.Host) error {
 				Annotations: map[string]string{
 					KubeMachineAnnotationKey: string(data),
 				},
-				Labels: map[string]string{
-					KubeMachineLabel: "true",
-				},
+				Labels: topologyLabels(host),
+			},
+			// The taint is removed once the provisioned node passed
+			// its verification.
+			Spec: kcorev1.NodeSpec{
+				Taints: []kcorev1.Taint{nodeinit.Taint},
 			},
 			Status: kcorev1.NodeStatus{
 				Phase: kcorev1.NodePending,
@@ -110,6 +346,7 @@ func (s NodeStore) Save(host *host.Host) error {
 				*/
 			},
 		}
+		node.Labels[KubeMachineLabel] = "true"
 		_, err := s.Client.CoreV1().Nodes().Create(node)
 		if err != nil {
 			return err
@@ -126,6 +363,9 @@ func (s NodeStore) Save(host *host.Host) error {
 			node.Labels = map[string]string{}
 		}
 		node.Labels[KubeMachineLabel] = "true"
+		for k, v := range topologyLabels(host) {
+			node.Labels[k] = v
+		}
 
 		_, err = s.Client.CoreV1().Nodes().Update(node)
 		if err != nil {
@@ -142,10 +382,35 @@ func (s NodeStore) Save(host *host.Host) error {
 	return nil
 }
 
-func (s NodeStore) Remove(name string) error {
+// topologyLabels returns the standard failure domain labels of the machine
+// for the scheduler to spread workloads across zones.
+func topologyLabels(h *host.Host) map[string]string {
+	labels := map[string]string{}
+	rawDriver, err := json.Marshal(h.Driver)
+	if err != nil {
+		return labels
+	}
+	for k, v := range topology.Labels(h.DriverName, rawDriver) {
+		labels[k] = v
+	}
+	return labels
+}
+
+func (s NodeStore) Remove(name string) (err error) {
+	defer observe("remove", &err)()
+	if err := writable("remove " + name); err != nil {
+		return err
+	}
+
 	hostPath := filepath.Join(s.GetMachinesDir(), name)
 
-	err := s.Client.CoreV1().Nodes().Delete(name, &metav1.DeleteOptions{})
+	node, err := s.Client.CoreV1().Nodes().Get(name, metav1.GetOptions{})
+	if err == nil {
+		if err := CheckRemove(node); err != nil {
+			return err
+		}
+		err = s.Client.CoreV1().Nodes().Delete(name, &metav1.DeleteOptions{})
+	}
 	if err != nil && !errors.IsNotFound(err) {
 		return err
 	}
@@ -153,14 +418,22 @@ func (s NodeStore) Remove(name string) error {
 	return os.RemoveAll(hostPath)
 }
 
-func (s NodeStore) Nodes() (map[string]*kcorev1.Node, error) {
+func (s NodeStore) Nodes() (_ map[string]*kcorev1.Node, err error) {
 	if s.nodes == nil {
+		defer observe("list", &err)()
+
 		nodes, err := s.Client.CoreV1().Nodes().List(metav1.ListOptions{LabelSelector: KubeMachineLabel + "=true"})
 		if err != nil {
 			return nil, err
 		}
+		controlPlane.RLock()
+		include := controlPlane.include
+		controlPlane.RUnlock()
 		s.nodes = map[string]*kcorev1.Node{}
 		for i := range nodes.Items {
+			if !include && IsControlPlane(&nodes.Items[i]) {
+				continue
+			}
 			s.nodes[nodes.Items[i].Name] = &nodes.Items[i]
 		}
 	}
@@ -193,6 +466,16 @@ func (s NodeStore) Exists(name string) (bool, error) {
 	return found, nil
 }
 
+// NodeExists reports whether the cluster has a node of the name, also if it
+// isn't managed by kube-machine.
+func NodeExists(client kubernetes.Interface, name string) (bool, error) {
+	_, err := client.CoreV1().Nodes().Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 func (s NodeStore) loadConfig(node *kcorev1.Node, h *host.Host) error {
 	data, exists := node.Annotations[KubeMachineAnnotationKey]
 	if !exists {
@@ -222,20 +505,56 @@ func (s NodeStore) loadConfig(node *kcorev1.Node, h *host.Host) error {
 	return nil
 }
 
-func (s NodeStore) Load(name string) (*host.Host, error) {
+func (s NodeStore) Load(name string) (_ *host.Host, err error) {
+	defer observe("load", &err)()
+
+	nodes, err := s.Nodes()
+	if err != nil {
+		return nil, err
+	}
+	return s.loadNode(nodes, name)
+}
+
+// LoadAll loads the hosts with a single list of the nodes and parses their
+// configs in parallel.
+func (s NodeStore) LoadAll(names []string) ([]*host.Host, map[string]error) {
+	var err error
+	defer observe("load-all", &err)()
+
+	nodes, err := s.Nodes()
+	if err != nil {
+		errs := map[string]error{}
+		for _, name := range names {
+			errs[name] = err
+		}
+		return []*host.Host{}, errs
+	}
+	return persist.LoadParallel(names, func(name string) (*host.Host, error) {
+		return s.loadNode(nodes, name)
+	})
+}
+
+// ExistsAll returns which of the machines exist with a single list of the
+// nodes.
+func (s NodeStore) ExistsAll(names []string) (map[string]bool, error) {
 	nodes, err := s.Nodes()
 	if err != nil {
 		return nil, err
 	}
+	existing := map[string]bool{}
+	for _, name := range names {
+		_, existing[name] = nodes[name]
+	}
+	return existing, nil
+}
+
+func (s NodeStore) loadNode(nodes map[string]*kcorev1.Node, name string) (*host.Host, error) {
 	node, found := nodes[name]
 	if !found {
 		return nil, mcnerror.ErrHostDoesNotExist{
 			Name: name,
 		}
 	}
-	if err != nil {
-		return nil, err
-	}
 
 	host := &host.Host{
 		Name: name,
@@ -247,3 +566,458 @@ func (s NodeStore) Load(name string) (*host.Host, error) {
 
 	return host, nil
 }
+
+// AppliedSpec returns the fingerprint of the spec last applied to the
+// machine, it is nil if the machine was not created from a spec.
+func (s NodeStore) AppliedSpec(name string) (map[string]string, error) {
+	fingerprint := map[string]string{}
+	exists, err := s.annotation(name, AppliedSpecAnnotationKey, &fingerprint)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return fingerprint, nil
+}
+
+// SetAppliedSpec records the fingerprint of the spec applied to the machine.
+func (s NodeStore) SetAppliedSpec(name string, fingerprint map[string]string) error {
+	return s.setAnnotation(name, AppliedSpecAnnotationKey, fingerprint)
+}
+
+// PatchStatus returns the result of the last update check of the machine,
+// it is nil if the machine was never checked.
+func (s NodeStore) PatchStatus(name string) (*patching.Status, error) {
+	status := &patching.Status{}
+	exists, err := s.annotation(name, PatchStatusAnnotationKey, status)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return status, nil
+}
+
+// SetPatchStatus records the result of an update check of the machine.
+func (s NodeStore) SetPatchStatus(name string, status *patching.Status) error {
+	return s.setAnnotation(name, PatchStatusAnnotationKey, status)
+}
+
+// Resources returns the capacity of the machine detected during its last
+// provisioning, it is nil if it wasn't detected.
+func (s NodeStore) Resources(name string) (*resources.Resources, error) {
+	r := &resources.Resources{}
+	exists, err := s.annotation(name, ResourcesAnnotationKey, r)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SetResources records the detected capacity of the machine.
+func (s NodeStore) SetResources(name string, r *resources.Resources) error {
+	return s.setAnnotation(name, ResourcesAnnotationKey, r)
+}
+
+// ProvisionedState returns the hash of the artifacts the machine was last
+// provisioned with, it is empty if it wasn't recorded.
+func (s NodeStore) ProvisionedState(name string) (string, error) {
+	hash := ""
+	if _, err := s.annotation(name, ProvisionedStateAnnotationKey, &hash); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// SetProvisionedState records the hash of the artifacts the machine was
+// provisioned with.
+func (s NodeStore) SetProvisionedState(name, hash string) error {
+	return s.setAnnotation(name, ProvisionedStateAnnotationKey, hash)
+}
+
+// Address returns the address the kubelet serving certificate and the node
+// IP of the machine were configured for, it is empty if it wasn't recorded.
+func (s NodeStore) Address(name string) (string, error) {
+	address := ""
+	if _, err := s.annotation(name, AddressAnnotationKey, &address); err != nil {
+		return "", err
+	}
+	return address, nil
+}
+
+// SetAddress records the address the machine was configured for.
+func (s NodeStore) SetAddress(name, address string) error {
+	return s.setAnnotation(name, AddressAnnotationKey, address)
+}
+
+// CreateFailure describes why the creation of a machine failed.
+type CreateFailure struct {
+	Error string    `json:"error"`
+	Time  time.Time `json:"time"`
+}
+
+// CreateFailure returns the failure of the creation of the machine, it is
+// nil if the machine was created successfully.
+func (s NodeStore) CreateFailure(name string) (*CreateFailure, error) {
+	failure := &CreateFailure{}
+	exists, err := s.annotation(name, CreateFailureAnnotationKey, failure)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return failure, nil
+}
+
+// SetCreateFailure records that the creation of the machine failed.
+func (s NodeStore) SetCreateFailure(name string, failure *CreateFailure) error {
+	return s.setAnnotation(name, CreateFailureAnnotationKey, failure)
+}
+
+// AdoptedKubelet returns the kubelet configuration the machine was adopted
+// with, it is nil if the machine was created by kube-machine.
+func (s NodeStore) AdoptedKubelet(name string) (*adopt.Kubelet, error) {
+	kubelet := &adopt.Kubelet{}
+	exists, err := s.annotation(name, AdoptedKubeletAnnotationKey, kubelet)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return kubelet, nil
+}
+
+// SetAdoptedKubelet records the kubelet configuration of an adopted machine,
+// nil removes it once kube-machine set up the kubelet.
+func (s NodeStore) SetAdoptedKubelet(name string, kubelet *adopt.Kubelet) error {
+	if kubelet == nil {
+		return s.removeAnnotation(name, AdoptedKubeletAnnotationKey)
+	}
+	return s.setAnnotation(name, AdoptedKubeletAnnotationKey, kubelet)
+}
+
+// Maintenance describes why and by whom a machine was put into maintenance.
+type Maintenance struct {
+	Reason   string    `json:"reason,omitempty"`
+	Operator string    `json:"operator"`
+	Since    time.Time `json:"since"`
+}
+
+// Maintenance returns the maintenance of the machine, it is nil if the
+// machine is in service.
+func (s NodeStore) Maintenance(name string) (*Maintenance, error) {
+	m := &Maintenance{}
+	exists, err := s.annotation(name, MaintenanceAnnotationKey, m)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SetMaintenance records the maintenance of the machine, nil ends it.
+func (s NodeStore) SetMaintenance(name string, m *Maintenance) error {
+	if m == nil {
+		return s.removeAnnotation(name, MaintenanceAnnotationKey)
+	}
+	return s.setAnnotation(name, MaintenanceAnnotationKey, m)
+}
+
+// Maintenances returns the machines in maintenance.
+func (s NodeStore) Maintenances() (map[string]*Maintenance, error) {
+	nodes, err := s.Nodes()
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]*Maintenance{}
+	for name, node := range nodes {
+		data, exists := node.Annotations[MaintenanceAnnotationKey]
+		if !exists {
+			continue
+		}
+		m := &Maintenance{}
+		if err := json.Unmarshal([]byte(data), m); err != nil {
+			return nil, fmt.Errorf("Failed to parse annotation %s of %s: %v", MaintenanceAnnotationKey, name, err)
+		}
+		result[name] = m
+	}
+	return result, nil
+}
+
+// Protection returns the protection of the machine, it is nil if the
+// machine is not protected.
+func (s NodeStore) Protection(name string) (*protection.Protection, error) {
+	p := &protection.Protection{}
+	exists, err := s.annotation(name, ProtectionAnnotationKey, p)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return p, nil
+}
+
+// SetProtection records the protection of the machine, nil lifts it.
+func (s NodeStore) SetProtection(name string, p *protection.Protection) error {
+	if p == nil {
+		return s.removeAnnotation(name, ProtectionAnnotationKey)
+	}
+	return s.setAnnotation(name, ProtectionAnnotationKey, p)
+}
+
+// Paused returns the pause of the machine, it is nil if the machine is not
+// paused.
+func (s NodeStore) Paused(name string) (*pause.Pause, error) {
+	p := &pause.Pause{}
+	exists, err := s.annotation(name, PausedAnnotationKey, p)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return p, nil
+}
+
+// SetPaused records the pause of the machine, nil resumes it.
+func (s NodeStore) SetPaused(name string, p *pause.Pause) error {
+	if p == nil {
+		return s.removeAnnotation(name, PausedAnnotationKey)
+	}
+	return s.setAnnotation(name, PausedAnnotationKey, p)
+}
+
+// Pauses returns the paused machines.
+func (s NodeStore) Pauses() (map[string]*pause.Pause, error) {
+	nodes, err := s.Nodes()
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]*pause.Pause{}
+	for name, node := range nodes {
+		data, exists := node.Annotations[PausedAnnotationKey]
+		if !exists {
+			continue
+		}
+		p := &pause.Pause{}
+		if err := json.Unmarshal([]byte(data), p); err != nil {
+			return nil, fmt.Errorf("Failed to parse annotation %s of %s: %v", PausedAnnotationKey, name, err)
+		}
+		result[name] = p
+	}
+	return result, nil
+}
+
+// Claims returns the assignments of the claimed machines.
+func (s NodeStore) Claims() (map[string]*claims.Assignment, error) {
+	nodes, err := s.Nodes()
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]*claims.Assignment{}
+	for name, node := range nodes {
+		data, exists := node.Annotations[ClaimAnnotationKey]
+		if !exists {
+			continue
+		}
+		a := &claims.Assignment{}
+		if err := json.Unmarshal([]byte(data), a); err != nil {
+			return nil, fmt.Errorf("Failed to parse annotation %s of %s: %v", ClaimAnnotationKey, name, err)
+		}
+		result[name] = a
+	}
+	return result, nil
+}
+
+// Failed describes why a machine failed.
+type Failed struct {
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+}
+
+// Failed returns the failure of the machine, it is nil if the machine didn't
+// fail.
+func (s NodeStore) Failed(name string) (*Failed, error) {
+	f := &Failed{}
+	exists, err := s.annotation(name, FailedAnnotationKey, f)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return f, nil
+}
+
+// SetFailed marks the machine as failed.
+func (s NodeStore) SetFailed(name string, f *Failed) error {
+	return s.setAnnotation(name, FailedAnnotationKey, f)
+}
+
+// UpgradeRollback returns the rolled back upgrade of the machine, it is nil
+// unless the last upgrade failed.
+func (s NodeStore) UpgradeRollback(name string) (*rollback.Rollback, error) {
+	r := &rollback.Rollback{}
+	exists, err := s.annotation(name, UpgradeRollbackAnnotationKey, r)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SetUpgradeRollback marks the machine as UpgradeFailedRolledBack, nil
+// clears the mark after a successful upgrade.
+func (s NodeStore) SetUpgradeRollback(name string, r *rollback.Rollback) error {
+	if r == nil {
+		return s.removeAnnotation(name, UpgradeRollbackAnnotationKey)
+	}
+	return s.setAnnotation(name, UpgradeRollbackAnnotationKey, r)
+}
+
+// Operations returns the recorded create, provision and upgrade operations
+// of the machine, the oldest first.
+func (s NodeStore) Operations(name string) ([]oplog.Operation, error) {
+	var ops []oplog.Operation
+	if _, err := s.annotation(name, OperationsAnnotationKey, &ops); err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+// AddOperation records an operation of the machine, only the last
+// oplog.MaxOperations are kept.
+func (s NodeStore) AddOperation(name string, op oplog.Operation) error {
+	ops, err := s.Operations(name)
+	if err != nil {
+		return err
+	}
+	return s.setAnnotation(name, OperationsAnnotationKey, oplog.Append(ops, op))
+}
+
+// SSHSessions returns the recorded SSH sessions to the machine, the oldest
+// first.
+func (s NodeStore) SSHSessions(name string) ([]recording.Session, error) {
+	var sessions []recording.Session
+	if _, err := s.annotation(name, SSHSessionsAnnotationKey, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// AddSSHSession notes an SSH session to the machine, only the last
+// recording.MaxSessions are kept.
+func (s NodeStore) AddSSHSession(name string, session recording.Session) error {
+	sessions, err := s.SSHSessions(name)
+	if err != nil {
+		return err
+	}
+	return s.setAnnotation(name, SSHSessionsAnnotationKey, recording.Append(sessions, session))
+}
+
+// Metadata returns the user metadata of the machine, it is empty if none
+// was set.
+func (s NodeStore) Metadata(name string) (*metadata.Record, error) {
+	r := &metadata.Record{}
+	if _, err := s.annotation(name, MetadataAnnotationKey, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SetMetadata records the user metadata of the machine and propagates the
+// selected subset to the labels and annotations of its node. tags are the
+// tags propagated to the cloud provider.
+func (s NodeStore) SetMetadata(name string, md map[string]string, p metadata.Propagation, tags map[string]string) (err error) {
+	defer observe("annotate", &err)()
+	if err := writable("set the metadata of " + name); err != nil {
+		return err
+	}
+
+	node, err := s.Client.CoreV1().Nodes().Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	previous := metadata.Record{}
+	if data, exists := node.Annotations[MetadataAnnotationKey]; exists {
+		if err := json.Unmarshal([]byte(data), &previous); err != nil {
+			return fmt.Errorf("Failed to parse annotation %s of %s: %v", MetadataAnnotationKey, name, err)
+		}
+	}
+	if node.Labels == nil {
+		node.Labels = map[string]string{}
+	}
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+
+	labelsChanged, labels := metadata.Sync(node.Labels, metadata.Select(md, p.Labels), previous.Labels)
+	annotationsChanged, annotations := metadata.Sync(node.Annotations, metadata.Select(md, p.Annotations), previous.Annotations)
+	data, err := json.Marshal(metadata.Record{
+		Metadata:    md,
+		Labels:      labels,
+		Annotations: annotations,
+		Tags:        tags,
+		Propagation: &p,
+	})
+	if err != nil {
+		return err
+	}
+	if !labelsChanged && !annotationsChanged && node.Annotations[MetadataAnnotationKey] == string(data) {
+		return nil
+	}
+	node.Annotations[MetadataAnnotationKey] = string(data)
+
+	_, err = s.Client.CoreV1().Nodes().Update(node)
+	return err
+}
+
+// annotation parses the JSON annotation of the node of the machine into v.
+func (s NodeStore) annotation(name, key string, v interface{}) (bool, error) {
+	nodes, err := s.Nodes()
+	if err != nil {
+		return false, err
+	}
+	node, found := nodes[name]
+	if !found {
+		return false, mcnerror.ErrHostDoesNotExist{
+			Name: name,
+		}
+	}
+
+	data, exists := node.Annotations[key]
+	if !exists {
+		return false, nil
+	}
+	if err := json.Unmarshal([]byte(data), v); err != nil {
+		return false, fmt.Errorf("Failed to parse annotation %s of %s: %v", key, name, err)
+	}
+	return true, nil
+}
+
+// setAnnotation stores v as JSON annotation on the node of the machine.
+func (s NodeStore) setAnnotation(name, key string, v interface{}) (err error) {
+	defer observe("annotate", &err)()
+	if err := writable("annotate " + name); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	node, err := s.Client.CoreV1().Nodes().Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	node.Annotations[key] = string(data)
+
+	_, err = s.Client.CoreV1().Nodes().Update(node)
+	return err
+}
+
+// removeAnnotation removes the annotation from the node of the machine.
+func (s NodeStore) removeAnnotation(name, key string) (err error) {
+	defer observe("annotate", &err)()
+	if err := writable("annotate " + name); err != nil {
+		return err
+	}
+
+	node, err := s.Client.CoreV1().Nodes().Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if _, exists := node.Annotations[key]; !exists {
+		return nil
+	}
+	delete(node.Annotations, key)
+
+	_, err = s.Client.CoreV1().Nodes().Update(node)
+	return err
+}
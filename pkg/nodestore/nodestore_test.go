@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -13,6 +14,8 @@ import (
 	"github.com/docker/machine/drivers/none"
 	"github.com/docker/machine/libmachine/host"
 	"github.com/docker/machine/libmachine/hosttest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kcorev1 "k8s.io/client-go/pkg/api/v1"
 )
 
 func cleanup() {
@@ -247,3 +250,52 @@ func TestStoreLoad(t *testing.T) {
 		t.Fatalf("GetURL is not %q, got %q", expectedURL, actualURL)
 	}
 }
+
+func TestCheckRemove(t *testing.T) {
+	defer SetControlPlaneAccess(false, false)
+
+	master := &kcorev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "master-1", Labels: map[string]string{"node-role.kubernetes.io/master": ""}}}
+	worker := &kcorev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1", Labels: map[string]string{KubeMachineLabel: "true"}}}
+
+	SetControlPlaneAccess(true, false)
+	if err := CheckRemove(master); err != (ErrControlPlane{Name: "master-1"}) {
+		t.Errorf("Expected removing the control plane to be refused, got %v", err)
+	}
+	if err := CheckRemove(worker); err != nil {
+		t.Errorf("Expected removing a worker to be allowed, got %v", err)
+	}
+	SetControlPlaneAccess(false, true)
+	if err := CheckRemove(master); err != nil || !controlPlane.include {
+		t.Errorf("Expected removing the control plane to be allowed and to include it, got %v", err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	defer cleanup()
+	defer SetReadOnly(false)
+
+	store := getTestStore()
+	h, err := hosttest.GetDefaultTestHost()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	SetReadOnly(true)
+	if err := store.Save(h); err != (ErrReadOnly{Operation: "save " + h.Name}) {
+		t.Errorf("Expected saving to be refused, got %v", err)
+	}
+	if err := store.Remove(h.Name); err == nil {
+		t.Error("Expected removing to be refused")
+	}
+	if err := store.SetFailed(h.Name, nil); err == nil {
+		t.Error("Expected annotating to be refused")
+	}
+	if _, err := os.Stat(filepath.Join(store.GetMachinesDir(), h.Name)); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be written, got %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodPut, "https://cluster/api/v1/nodes/worker-1", nil)
+	if _, err := (contextTransport{http.DefaultTransport}).RoundTrip(req); err == nil {
+		t.Error("Expected the client to refuse updates")
+	}
+}
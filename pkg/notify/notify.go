@@ -0,0 +1,227 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/ghodss/yaml"
+)
+
+// The events notifications are sent for.
+const (
+	EventCreated      = "created"
+	EventFailed       = "failed"
+	EventDeleted      = "deleted"
+	EventAutoRepaired = "auto-repaired"
+)
+
+// Events are the known events.
+var Events = []string{EventCreated, EventFailed, EventDeleted, EventAutoRepaired}
+
+// DefaultTemplate is the message of notifiers without template.
+const DefaultTemplate = `kube-machine: {{.Machine}} {{.Event}}{{if .Reason}} ({{.Reason}}){{end}}{{if .Error}}: {{.Error}}{{end}}`
+
+// Event is a lifecycle event of a machine, the data of the templates.
+type Event struct {
+	Event   string    `json:"event"`
+	Machine string    `json:"machine"`
+	Time    time.Time `json:"time"`
+	User    string    `json:"user,omitempty"`
+	Driver  string    `json:"driver,omitempty"`
+	Pool    string    `json:"pool,omitempty"`
+	// Reason explains the event, e.g. why a machine was repaired.
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Suppressed is the number of notifications dropped by the rate limit
+	// since the last one sent.
+	Suppressed int `json:"suppressed,omitempty"`
+}
+
+// Config is the notifications file.
+type Config struct {
+	Notifiers []Notifier `json:"notifiers"`
+}
+
+// Notifier sends the notifications of its events to Slack, a webhook or by
+// mail. The URL and the SMTP password are references resolved like the
+// values of driver profiles, e.g. env:SLACK_WEBHOOK_URL.
+type Notifier struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Events the notifier is sent, all events if empty.
+	Events []string `json:"events,omitempty"`
+	// Template is a Go template of the message with the event as data.
+	Template string `json:"template,omitempty"`
+	// MaxPerHour limits the notifications of the notifier, unlimited if 0.
+	MaxPerHour int `json:"maxPerHour,omitempty"`
+
+	URL  string      `json:"url,omitempty"`
+	SMTP *SMTPConfig `json:"smtp,omitempty"`
+
+	template *template.Template
+	limiter  *limiter
+}
+
+var (
+	mu        sync.Mutex
+	notifiers []*Notifier
+	// Timeout limits the delivery of each notification.
+	Timeout = 10 * time.Second
+)
+
+// Configure loads the notifiers of the YAML or JSON file, none if the path
+// is empty.
+func Configure(path string) error {
+	var configured []*Notifier
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		c := &Config{}
+		if err := yaml.Unmarshal(data, c); err != nil {
+			return fmt.Errorf("Failed to parse notifications %s: %v", path, err)
+		}
+		for i := range c.Notifiers {
+			n := c.Notifiers[i]
+			if err := n.init(); err != nil {
+				return fmt.Errorf("Invalid notifier %s: %v", n.Name, err)
+			}
+			configured = append(configured, &n)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	notifiers = configured
+	return nil
+}
+
+func (n *Notifier) init() error {
+	switch n.Type {
+	case TypeSlack, TypeWebhook:
+		if n.URL == "" {
+			return fmt.Errorf("%s notifiers need a url", n.Type)
+		}
+	case TypeSMTP:
+		if n.SMTP == nil || n.SMTP.Server == "" || n.SMTP.From == "" || len(n.SMTP.To) == 0 {
+			return fmt.Errorf("smtp notifiers need the server, from and to of smtp")
+		}
+	default:
+		return fmt.Errorf("unknown type %q, expected %s, %s or %s", n.Type, TypeSlack, TypeWebhook, TypeSMTP)
+	}
+	for _, e := range n.Events {
+		if !known(e) {
+			return fmt.Errorf("unknown event %q, expected one of %v", e, Events)
+		}
+	}
+	text := n.Template
+	if text == "" {
+		text = DefaultTemplate
+	}
+	var err error
+	if n.template, err = template.New(n.Name).Option("missingkey=error").Parse(text); err != nil {
+		return fmt.Errorf("invalid template: %v", err)
+	}
+	if n.MaxPerHour < 0 {
+		return fmt.Errorf("maxPerHour must not be negative")
+	}
+	n.limiter = &limiter{max: n.MaxPerHour, window: time.Hour}
+	return nil
+}
+
+func known(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Notifier) wants(event string) bool {
+	if len(n.Events) == 0 {
+		return true
+	}
+	for _, e := range n.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Message renders the message of the event.
+func (n *Notifier) Message(e Event) (string, error) {
+	var buf bytes.Buffer
+	if err := n.template.Execute(&buf, e); err != nil {
+		return "", err
+	}
+	if e.Suppressed > 0 {
+		fmt.Fprintf(&buf, " (%d earlier notifications suppressed by the rate limit)", e.Suppressed)
+	}
+	return buf.String(), nil
+}
+
+// Notify sends the event to the notifiers of its type. Failing notifiers
+// are logged, they never fail the operation.
+func Notify(e Event) {
+	mu.Lock()
+	n := notifiers
+	mu.Unlock()
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	for _, notifier := range n {
+		if !notifier.wants(e.Event) {
+			continue
+		}
+		suppressed, ok := notifier.limiter.allow(e.Time)
+		if !ok {
+			log.Debugf("Rate limit of notifier %s reached, not notifying %s of %s", notifier.Name, e.Event, e.Machine)
+			continue
+		}
+		e.Suppressed = suppressed
+		if err := notifier.send(e); err != nil {
+			log.Warnf("Failed to notify %s of %s of %s: %v", notifier.Name, e.Event, e.Machine, err)
+		}
+	}
+}
+
+// limiter allows max notifications in a sliding window and counts the
+// ones suppressed since the last allowed one.
+type limiter struct {
+	max    int
+	window time.Duration
+
+	mu         sync.Mutex
+	sent       []time.Time
+	suppressed int
+}
+
+func (l *limiter) allow(now time.Time) (int, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.max == 0 {
+		return 0, true
+	}
+	recent := l.sent[:0]
+	for _, t := range l.sent {
+		if now.Sub(t) < l.window {
+			recent = append(recent, t)
+		}
+	}
+	l.sent = recent
+	if len(l.sent) >= l.max {
+		l.suppressed++
+		return 0, false
+	}
+	l.sent = append(l.sent, now)
+	suppressed := l.suppressed
+	l.suppressed = 0
+	return suppressed, true
+}
@@ -0,0 +1,110 @@
+package notify
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func configure(t *testing.T, config string) {
+	dir, err := ioutil.TempDir("", "notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "notifications.yaml")
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Configure(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	var slack []string
+	var webhook []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		if r.URL.Path == "/slack" {
+			slack = append(slack, body["text"].(string))
+		} else {
+			webhook = append(webhook, body)
+		}
+	}))
+	defer server.Close()
+	os.Setenv("NOTIFY_TEST_URL", server.URL+"/slack")
+	defer os.Unsetenv("NOTIFY_TEST_URL")
+
+	var mails []string
+	defer func(f func(string, smtp.Auth, string, []string, []byte) error) { sendMail = f }(sendMail)
+	sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		mails = append(mails, string(msg))
+		return nil
+	}
+
+	configure(t, `
+notifiers:
+- name: on-call
+  type: slack
+  url: env:NOTIFY_TEST_URL
+  events: [auto-repaired]
+  maxPerHour: 1
+- name: hook
+  type: webhook
+  url: `+server.URL+`/hook
+- name: mail
+  type: smtp
+  events: [failed]
+  template: '{{.Machine}} failed for {{.User}}: {{.Error}}'
+  smtp:
+    server: mail.example.com:25
+    from: kube-machine@example.com
+    to: [ops@example.com]
+`)
+	defer Configure("")
+
+	now := time.Now()
+	Notify(Event{Event: EventAutoRepaired, Machine: "node-1", Reason: "its VM disappeared", Time: now})
+	Notify(Event{Event: EventAutoRepaired, Machine: "node-2", Time: now.Add(time.Minute)})
+	Notify(Event{Event: EventAutoRepaired, Machine: "node-3", Time: now.Add(2 * time.Hour)})
+	Notify(Event{Event: EventFailed, Machine: "node-4", User: "alice", Error: "quota exceeded", Time: now})
+
+	expected := []string{
+		"kube-machine: node-1 auto-repaired (its VM disappeared)",
+		"kube-machine: node-3 auto-repaired (1 earlier notifications suppressed by the rate limit)",
+	}
+	if strings.Join(slack, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected the Slack messages\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(slack, "\n"))
+	}
+	if len(webhook) != 4 || webhook[3]["event"] != "failed" || webhook[3]["message"] != "kube-machine: node-4 failed: quota exceeded" {
+		t.Errorf("Unexpected webhook notifications %v", webhook)
+	}
+	if len(mails) != 1 || !strings.Contains(mails[0], "Subject: kube-machine: node-4 failed") || !strings.Contains(mails[0], "node-4 failed for alice: quota exceeded") {
+		t.Errorf("Unexpected mails %q", mails)
+	}
+}
+
+func TestConfigureInvalid(t *testing.T) {
+	for _, n := range []Notifier{
+		{Name: "a", Type: "pager", URL: "https://example.com"},
+		{Name: "b", Type: TypeSlack},
+		{Name: "c", Type: TypeWebhook, URL: "https://example.com", Events: []string{"rebooted"}},
+		{Name: "d", Type: TypeWebhook, URL: "https://example.com", Template: "{{.Machine"},
+		{Name: "e", Type: TypeSMTP, SMTP: &SMTPConfig{Server: "mail.example.com:25"}},
+	} {
+		if err := n.init(); err == nil {
+			t.Errorf("Expected notifier %s to be invalid", n.Name)
+		}
+	}
+}
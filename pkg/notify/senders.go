@@ -0,0 +1,93 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+
+	"github.com/kubermatic/kube-machine/pkg/driverprofiles"
+)
+
+// The types of notifiers.
+const (
+	TypeSlack   = "slack"
+	TypeWebhook = "webhook"
+	TypeSMTP    = "smtp"
+)
+
+// SMTPConfig is the mail server and the recipients of smtp notifiers.
+type SMTPConfig struct {
+	// Server is host:port of the mail server.
+	Server   string   `json:"server"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+}
+
+// sendMail is replaced in tests.
+var sendMail = smtp.SendMail
+
+func (n *Notifier) send(e Event) error {
+	message, err := n.Message(e)
+	if err != nil {
+		return err
+	}
+	switch n.Type {
+	case TypeSlack:
+		return n.post(map[string]string{"text": message})
+	case TypeWebhook:
+		return n.post(struct {
+			Event
+			Message string `json:"message"`
+		}{e, message})
+	case TypeSMTP:
+		return n.mail(e, message)
+	}
+	return nil
+}
+
+func (n *Notifier) post(payload interface{}) error {
+	url, err := driverprofiles.Resolve(n.URL)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: Timeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Notification webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (n *Notifier) mail(e Event, message string) error {
+	c := n.SMTP
+	var auth smtp.Auth
+	if c.Username != "" {
+		password, err := driverprofiles.Resolve(c.Password)
+		if err != nil {
+			return err
+		}
+		host, _, err := net.SplitHostPort(c.Server)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", c.Username, password, host)
+	}
+	subject := fmt.Sprintf("kube-machine: %s %s", e.Machine, e.Event)
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		c.From, strings.Join(c.To, ", "), subject, message)
+	return sendMail(c.Server, auth, c.From, c.To, []byte(body))
+}
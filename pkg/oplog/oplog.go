@@ -0,0 +1,202 @@
+package oplog
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubermatic/kube-machine/pkg/logging"
+)
+
+const (
+	// MaxOperations is the number of operations kept per machine.
+	MaxOperations = 20
+
+	maxErrorLength = 2000
+
+	// DefaultBootLogLines is the number of lines of the boot log attached to
+	// a failed operation.
+	DefaultBootLogLines = 200
+)
+
+// Step is the result of a provisioning step.
+type Step struct {
+	Name            string    `json:"name"`
+	Start           time.Time `json:"start"`
+	DurationSeconds float64   `json:"durationSeconds"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// Operation is one create, provision or upgrade attempt of a machine.
+type Operation struct {
+	ID              string    `json:"id"`
+	Type            string    `json:"type"`
+	User            string    `json:"user"`
+	Start           time.Time `json:"start"`
+	DurationSeconds float64   `json:"durationSeconds"`
+	Error           string    `json:"error,omitempty"`
+	Steps           []Step    `json:"steps,omitempty"`
+	// BootLog is the end of the serial console or boot log of a machine
+	// which never became reachable over SSH.
+	BootLog string `json:"bootLog,omitempty"`
+}
+
+// Store persists the operations of the machines.
+type Store interface {
+	AddOperation(machine string, op Operation) error
+}
+
+var now = time.Now
+
+// Log records the operations of the machines of one kube-machine operation,
+// e.g. a command or a request of serve. The methods of a nil Log do nothing.
+type Log struct {
+	mu           sync.Mutex
+	store        Store
+	bootLogLines int
+	active       map[string]*Operation
+}
+
+// New returns a log persisting the finished operations to the store, they
+// are dropped without store. bootLogLines is the number of lines of the boot
+// log attached to operations, 0 disables capturing boot logs.
+func New(s Store, bootLogLines int) *Log {
+	return &Log{store: s, bootLogLines: bootLogLines, active: map[string]*Operation{}}
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the log.
+func NewContext(ctx context.Context, l *Log) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the log of the context, it is nil if the context
+// doesn't carry one.
+func FromContext(ctx context.Context) *Log {
+	if ctx == nil {
+		return nil
+	}
+	l, _ := ctx.Value(contextKey{}).(*Log)
+	return l
+}
+
+// CapturesBootLog returns whether boot logs are attached to the operation of
+// the machine.
+func (l *Log) CapturesBootLog(machine string) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.active[machine]
+	return ok && l.bootLogLines > 0
+}
+
+// AttachBootLog adds the last lines of the boot log to the operation of the
+// machine, it is ignored if no operation is recorded.
+func (l *Log) AttachBootLog(machine, bootLog string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	op, ok := l.active[machine]
+	if !ok || l.bootLogLines <= 0 {
+		return
+	}
+	lines := strings.Split(strings.TrimRight(bootLog, "\r\n"), "\n")
+	if len(lines) > l.bootLogLines {
+		lines = lines[len(lines)-l.bootLogLines:]
+	}
+	op.BootLog = strings.Join(lines, "\n")
+}
+
+// Begin starts recording an operation of the machine, the steps recorded
+// until End belong to it.
+func (l *Log) Begin(machine, operation, user string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active[machine] = &Operation{
+		ID:    logging.NewOperationID(),
+		Type:  operation,
+		User:  user,
+		Start: now().UTC(),
+	}
+}
+
+// RecordStep adds a step to the operation of the machine, it is ignored if
+// no operation is recorded.
+func (l *Log) RecordStep(machine, step string, start time.Time, err error) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	op, ok := l.active[machine]
+	if !ok {
+		return
+	}
+	op.Steps = append(op.Steps, Step{
+		Name:            step,
+		Start:           start.UTC(),
+		DurationSeconds: now().Sub(start).Seconds(),
+		Error:           errorString(err),
+	})
+}
+
+// End finishes the operation of the machine and persists it. The returned
+// error is the one of the store.
+func (l *Log) End(machine string, err error) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	op, ok := l.active[machine]
+	delete(l.active, machine)
+	l.mu.Unlock()
+
+	if !ok || l.store == nil {
+		return nil
+	}
+	op.DurationSeconds = now().Sub(op.Start).Seconds()
+	op.Error = errorString(err)
+	return l.store.AddOperation(machine, *op)
+}
+
+// Append adds the operation to the operations of a machine, dropping the
+// oldest ones beyond MaxOperations.
+func Append(ops []Operation, op Operation) []Operation {
+	ops = append(ops, op)
+	if len(ops) > MaxOperations {
+		ops = ops[len(ops)-MaxOperations:]
+	}
+	return ops
+}
+
+// Find returns the operation of the ID.
+func Find(ops []Operation, id string) (Operation, bool) {
+	for _, op := range ops {
+		if op.ID == id {
+			return op, true
+		}
+	}
+	return Operation{}, false
+}
+
+// errorString keeps the operations small enough to be stored with the
+// machine, errors can contain the whole output of a command.
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	s := err.Error()
+	if len(s) > maxErrorLength {
+		s = s[:maxErrorLength] + "..."
+	}
+	return s
+}
@@ -0,0 +1,115 @@
+package oplog
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeStore map[string][]Operation
+
+func (s fakeStore) AddOperation(machine string, op Operation) error {
+	s[machine] = Append(s[machine], op)
+	return nil
+}
+
+func TestRecord(t *testing.T) {
+	defer func(f func() time.Time) { now = f }(now)
+	start := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return start }
+
+	s := fakeStore{}
+	l := New(s, DefaultBootLogLines)
+
+	l.RecordStep("node-1", "ignored", start, nil)
+	l.Begin("node-1", "provision", "alice")
+	now = func() time.Time { return start.Add(10 * time.Second) }
+	l.RecordStep("node-1", "engine", start, nil)
+	l.RecordStep("node-1", "kubelet", start.Add(5*time.Second), errors.New(strings.Repeat("x", 3000)))
+	if err := l.End("node-1", errors.New("kubelet failed")); err != nil {
+		t.Fatal(err)
+	}
+
+	ops := s["node-1"]
+	if len(ops) != 1 {
+		t.Fatalf("expected one operation, got %+v", ops)
+	}
+	op := ops[0]
+	if op.ID == "" || op.Type != "provision" || op.User != "alice" || op.Error != "kubelet failed" || op.DurationSeconds != 10 {
+		t.Errorf("unexpected operation %+v", op)
+	}
+	if len(op.Steps) != 2 || op.Steps[0].Name != "engine" || op.Steps[1].DurationSeconds != 5 {
+		t.Errorf("unexpected steps %+v", op.Steps)
+	}
+	if len(op.Steps[1].Error) != maxErrorLength+3 {
+		t.Errorf("expected the error to be truncated, got %d characters", len(op.Steps[1].Error))
+	}
+
+	if err := l.End("node-1", nil); err != nil || len(s["node-1"]) != 1 {
+		t.Error("expected ending an unknown operation to be ignored")
+	}
+}
+
+func TestAttachBootLog(t *testing.T) {
+	s := fakeStore{}
+	l := New(s, 2)
+
+	l.AttachBootLog("node-1", "ignored")
+	l.Begin("node-1", "create", "alice")
+	if !l.CapturesBootLog("node-1") || l.CapturesBootLog("node-2") {
+		t.Error("expected only the boot log of the active operation to be captured")
+	}
+	l.AttachBootLog("node-1", "BIOS\nkernel\ncloud-init failed\n")
+	if err := l.End("node-1", errors.New("SSH timed out")); err != nil {
+		t.Fatal(err)
+	}
+	if log := s["node-1"][0].BootLog; log != "kernel\ncloud-init failed" {
+		t.Errorf("expected the last lines of the boot log, got %q", log)
+	}
+}
+
+func TestOperationsAreSeparate(t *testing.T) {
+	first, second := fakeStore{}, fakeStore{}
+	ctx := NewContext(context.Background(), New(first, 0))
+	other := New(second, 0)
+
+	FromContext(ctx).Begin("node-1", "provision", "alice")
+	other.Begin("node-1", "upgrade", "bob")
+	FromContext(ctx).RecordStep("node-1", "kubelet", time.Now(), nil)
+	if err := FromContext(ctx).End("node-1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.End("node-1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(first["node-1"]) != 1 || first["node-1"][0].Type != "provision" || len(first["node-1"][0].Steps) != 1 {
+		t.Errorf("unexpected operations of the first log %+v", first["node-1"])
+	}
+	if len(second["node-1"]) != 1 || second["node-1"][0].Type != "upgrade" || len(second["node-1"][0].Steps) != 0 {
+		t.Errorf("unexpected operations of the second log %+v", second["node-1"])
+	}
+
+	var none *Log
+	none.Begin("node-1", "create", "alice")
+	if FromContext(context.Background()) != nil || none.End("node-1", nil) != nil {
+		t.Error("expected a context without log to record nothing")
+	}
+}
+
+func TestAppend(t *testing.T) {
+	var ops []Operation
+	for i := 0; i < MaxOperations+5; i++ {
+		ops = Append(ops, Operation{ID: string(rune('a' + i))})
+	}
+	if len(ops) != MaxOperations || ops[0].ID != "f" {
+		t.Errorf("expected the last %d operations, got %+v", MaxOperations, ops)
+	}
+	if op, ok := Find(ops, "g"); !ok || op.ID != "g" {
+		t.Errorf("expected to find operation g, got %+v", op)
+	}
+	if _, ok := Find(ops, "a"); ok {
+		t.Error("expected operation a to be dropped")
+	}
+}
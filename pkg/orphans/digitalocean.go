@@ -0,0 +1,110 @@
+package orphans
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/kubermatic/kube-machine/pkg/resourcetags"
+	"golang.org/x/oauth2"
+)
+
+// provider lists and deletes the VMs of an account, credentials are the
+// ones of a stored machine or given by the user.
+type provider struct {
+	list   func(credentials string) ([]VM, error)
+	delete func(credentials string, vm VM) error
+	// machine returns the machine of the stored driver configuration and
+	// its credentials.
+	machine func(name string, rawDriver []byte) (Machine, string, error)
+	// tagValue returns the value of a tag as the provider stores it.
+	tagValue func(key, value string) string
+}
+
+var providers = map[string]provider{
+	"digitalocean": {digitaloceanList, digitaloceanDelete, digitaloceanMachine, digitaloceanTagValue},
+}
+
+// List returns the VMs of the account.
+func List(driver, credentials string) ([]VM, error) {
+	return providers[driver].list(credentials)
+}
+
+// Delete deletes a VM.
+func Delete(driver, credentials string, vm VM) error {
+	return providers[driver].delete(credentials, vm)
+}
+
+// MachineOf returns the machine of the stored configuration of its driver
+// and the credentials of its account.
+func MachineOf(driver, name string, rawDriver []byte) (Machine, string, error) {
+	return providers[driver].machine(name, rawDriver)
+}
+
+// TagValue returns the value of a tag as the provider of the driver stores
+// it, e.g. the cluster to compare with the cluster of the VMs.
+func TagValue(driver, key, value string) string {
+	return providers[driver].tagValue(key, value)
+}
+
+func digitaloceanClient(token string) *godo.Client {
+	return godo.NewClient(oauth2.NewClient(oauth2.NoContext, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})))
+}
+
+func digitaloceanList(token string) ([]VM, error) {
+	client := digitaloceanClient(token)
+	var vms []VM
+	opt := &godo.ListOptions{PerPage: 200}
+	for {
+		droplets, resp, err := client.Droplets.List(opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range droplets {
+			created, _ := time.Parse(time.RFC3339, d.Created)
+			tags := resourcetags.FromDigitalOcean(d.Tags)
+			machine, _ := resourcetags.Machine(tags)
+			vms = append(vms, VM{ID: strconv.Itoa(d.ID), Name: d.Name, Machine: machine, Cluster: tags[resourcetags.ClusterKey], Created: created})
+		}
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			return vms, nil
+		}
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, err
+		}
+		opt.Page = page + 1
+	}
+}
+
+func digitaloceanDelete(token string, vm VM) error {
+	id, err := strconv.Atoi(vm.ID)
+	if err != nil {
+		return err
+	}
+	_, err = digitaloceanClient(token).Droplets.Delete(id)
+	return err
+}
+
+func digitaloceanMachine(name string, rawDriver []byte) (Machine, string, error) {
+	var d struct {
+		AccessToken string
+		DropletID   int
+	}
+	if err := json.Unmarshal(rawDriver, &d); err != nil {
+		return Machine{}, "", err
+	}
+	m := Machine{Name: name, Tag: digitaloceanTagValue(resourcetags.MachineKey, name)}
+	if d.DropletID != 0 {
+		m.VMID = strconv.Itoa(d.DropletID)
+	}
+	return m, d.AccessToken, nil
+}
+
+// digitaloceanTagValue is the value of a tag of a droplet, the characters
+// DigitalOcean doesn't allow are replaced.
+func digitaloceanTagValue(key, value string) string {
+	tag := resourcetags.DigitalOcean(key, value)
+	return tag[len(key)+1:]
+}
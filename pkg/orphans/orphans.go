@@ -0,0 +1,138 @@
+// Package orphans compares the VMs of a cloud account with the machines of
+// the store. VMs tagged with a machine which is not in the store are left
+// over by interrupted creates or failed removals, machines whose VM is gone
+// were deleted in the console of the provider.
+package orphans
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// VM is a VM of the cloud account.
+type VM struct {
+	ID   string
+	Name string
+	// Machine is the value of the machine tag, empty for VMs which were not
+	// created by kube-machine.
+	Machine string
+	// Cluster is the value of the cluster tag, VMs of other clusters are
+	// never orphans of the store.
+	Cluster string
+	Created time.Time
+}
+
+// Machine is a machine of the store created with the driver.
+type Machine struct {
+	Name string
+	// Tag is the value of the machine tag of its VM at the provider.
+	Tag string
+	// VMID is empty if the creation was interrupted before the VM existed.
+	VMID string
+}
+
+// Orphan is a tagged VM without machine.
+type Orphan struct {
+	VM
+	Reason string
+}
+
+// Report is the result of the comparison.
+type Report struct {
+	Orphaned []Orphan
+	// Missing are the machines whose VM doesn't exist.
+	Missing []Machine
+	// Young are tagged VMs without machine which may still be created.
+	Young []VM
+	// Ambiguous are VMs whose tag is the one of several machines, the tags
+	// of machine names differing in characters the provider replaces
+	// collide.
+	Ambiguous []VM
+}
+
+// Find compares the VMs of the cluster with the machines of the driver,
+// VMs tagged with another cluster are skipped and an empty cluster matches
+// all VMs. VMs are matched with their machine by ID first, then by tag.
+// Tagged VMs younger than minAge are not reported as orphaned, their
+// machine may not be saved yet.
+func Find(vms []VM, machines []Machine, cluster string, now time.Time, minAge time.Duration) Report {
+	byID := map[string]Machine{}
+	byTag := map[string][]Machine{}
+	for _, m := range machines {
+		if m.VMID != "" {
+			byID[m.VMID] = m
+		}
+		byTag[m.Tag] = append(byTag[m.Tag], m)
+	}
+	exists := map[string]bool{}
+	for _, vm := range vms {
+		exists[vm.ID] = true
+	}
+
+	var r Report
+	for _, vm := range vms {
+		if vm.Machine == "" || cluster != "" && vm.Cluster != cluster {
+			continue
+		}
+		if _, ok := byID[vm.ID]; ok {
+			continue
+		}
+		tagged := byTag[vm.Machine]
+		reason := ""
+		switch {
+		case len(tagged) == 0:
+			reason = "not in the store"
+		case len(tagged) > 1:
+			r.Ambiguous = append(r.Ambiguous, vm)
+			continue
+		case tagged[0].VMID != "":
+			reason = fmt.Sprintf("%s has the VM %s", tagged[0].Name, tagged[0].VMID)
+		default:
+			continue
+		}
+		if !vm.Created.IsZero() && now.Sub(vm.Created) < minAge {
+			r.Young = append(r.Young, vm)
+			continue
+		}
+		r.Orphaned = append(r.Orphaned, Orphan{VM: vm, Reason: reason})
+	}
+	for _, m := range machines {
+		if m.VMID != "" && !exists[m.VMID] {
+			r.Missing = append(r.Missing, m)
+		}
+	}
+	sort.Sort(byVM(r.Orphaned))
+	return r
+}
+
+type byVM []Orphan
+
+func (o byVM) Len() int      { return len(o) }
+func (o byVM) Swap(i, j int) { o[i], o[j] = o[j], o[i] }
+func (o byVM) Less(i, j int) bool {
+	return o[i].Machine < o[j].Machine || o[i].Machine == o[j].Machine && o[i].ID < o[j].ID
+}
+
+// aliases are the short names of the drivers.
+var aliases = map[string]string{
+	"do": "digitalocean",
+}
+
+// Driver returns the driver of a name or alias, it fails for drivers whose
+// VMs can't be listed.
+func Driver(name string) (string, error) {
+	if driver, ok := aliases[name]; ok {
+		name = driver
+	}
+	if _, ok := providers[name]; !ok {
+		var supported []string
+		for driver := range providers {
+			supported = append(supported, driver)
+		}
+		sort.Strings(supported)
+		return "", fmt.Errorf("Listing the VMs of the %s driver is not supported, supported are %s", name, strings.Join(supported, ", "))
+	}
+	return name, nil
+}
@@ -0,0 +1,93 @@
+package orphans
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestFind(t *testing.T) {
+	old := now.Add(-24 * time.Hour)
+	vms := []VM{
+		{ID: "1", Name: "worker-1", Machine: "worker-1", Created: old},
+		{ID: "2", Name: "worker-2", Machine: "worker-2", Created: old},
+		{ID: "3", Name: "worker-1", Machine: "worker-1", Created: old},
+		{ID: "4", Name: "worker-4", Machine: "worker-4", Created: now.Add(-time.Minute)},
+		{ID: "5", Name: "database", Created: old},
+		{ID: "6", Name: "worker-6", Machine: "worker-6", Created: old},
+	}
+	machines := []Machine{
+		{Name: "worker-1", Tag: "worker-1", VMID: "1"},
+		{Name: "worker-5", Tag: "worker-5", VMID: "7"},
+		{Name: "worker-6", Tag: "worker-6"},
+	}
+	r := Find(vms, machines, "", now, time.Hour)
+
+	expected := []Orphan{
+		{VM: vms[2], Reason: "worker-1 has the VM 1"},
+		{VM: vms[1], Reason: "not in the store"},
+	}
+	if !reflect.DeepEqual(r.Orphaned, expected) {
+		t.Errorf("Expected the orphans %v, got %v", expected, r.Orphaned)
+	}
+	if len(r.Missing) != 1 || r.Missing[0].Name != "worker-5" {
+		t.Errorf("Expected worker-5 to miss its VM, got %v", r.Missing)
+	}
+	if len(r.Young) != 1 || r.Young[0].ID != "4" {
+		t.Errorf("Expected the VM of worker-4 to be young, got %v", r.Young)
+	}
+}
+
+func TestFindAmbiguousTags(t *testing.T) {
+	old := now.Add(-24 * time.Hour)
+	vms := []VM{
+		{ID: "1", Name: "web.1", Machine: "web_1", Created: old},
+		{ID: "2", Name: "web_1", Machine: "web_1", Created: old},
+		{ID: "3", Name: "web.1", Machine: "web_1", Created: old},
+	}
+	machines := []Machine{
+		{Name: "web.1", Tag: "web_1", VMID: "1"},
+		{Name: "web_1", Tag: "web_1", VMID: "2"},
+	}
+	r := Find(vms, machines, "", now, time.Hour)
+	if len(r.Orphaned) != 0 {
+		t.Errorf("Expected no orphans of colliding tags, got %v", r.Orphaned)
+	}
+	if len(r.Ambiguous) != 1 || r.Ambiguous[0].ID != "3" {
+		t.Errorf("Expected VM 3 to be ambiguous, got %v", r.Ambiguous)
+	}
+}
+
+func TestFindCluster(t *testing.T) {
+	old := now.Add(-24 * time.Hour)
+	vms := []VM{
+		{ID: "1", Name: "worker-1", Machine: "worker-1", Cluster: "staging", Created: old},
+		{ID: "2", Name: "worker-2", Machine: "worker-2", Cluster: "prod", Created: old},
+		{ID: "3", Name: "worker-3", Machine: "worker-3", Created: old},
+	}
+	r := Find(vms, nil, "prod", now, time.Hour)
+	if len(r.Orphaned) != 1 || r.Orphaned[0].ID != "2" {
+		t.Errorf("Expected only the VM of the cluster to be orphaned, got %v", r.Orphaned)
+	}
+}
+
+func TestDriver(t *testing.T) {
+	if driver, err := Driver("do"); err != nil || driver != "digitalocean" {
+		t.Errorf("Expected do to be digitalocean, got %q (%v)", driver, err)
+	}
+	if _, err := Driver("virtualbox"); err == nil {
+		t.Error("Expected virtualbox to be unsupported")
+	}
+}
+
+func TestDigitalOceanMachine(t *testing.T) {
+	m, token, err := digitaloceanMachine("node.example", []byte(`{"AccessToken":"secret","DropletID":42}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != (Machine{Name: "node.example", Tag: "node_example", VMID: "42"}) || token != "secret" {
+		t.Errorf("Unexpected machine %+v and token %q", m, token)
+	}
+}
@@ -0,0 +1,147 @@
+package patching
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Status is the result of the last update check of a machine.
+type Status struct {
+	Pending        int       `json:"pending"`
+	RebootRequired bool      `json:"rebootRequired"`
+	Checked        time.Time `json:"checked"`
+}
+
+func (s *Status) String() string {
+	if s == nil {
+		return "-"
+	}
+	if s.RebootRequired {
+		return fmt.Sprintf("%d (reboot required)", s.Pending)
+	}
+	return strconv.Itoa(s.Pending)
+}
+
+// Runner runs commands on a machine, it is implemented by *host.Host.
+type Runner interface {
+	RunSSHCommand(command string) (string, error)
+}
+
+// UnholdCommand releases the holds the upgrade put on the docker packages of
+// apt distributions, which an interrupted upgrade leaves behind. Packages
+// held before, e.g. by the operator, stay held. It does nothing on other
+// distributions. It fails if a package can't be released and keeps the
+// record of the holds then.
+const UnholdCommand = "if [ -f " + heldPath + " ]; then failed=0; " +
+	"for p in $(cat " + heldPath + "); do sudo apt-mark unhold \"$p\" >/dev/null || failed=1; done; " +
+	"[ $failed -eq 0 ] && sudo rm -f " + heldPath + "; fi"
+
+// dockerPackages are the packages of the container runtime, of the docker
+// repositories and of the distributions.
+const dockerPackages = "docker-engine docker-ce docker-ce-cli docker-ce-rootless-extras containerd.io docker.io containerd runc"
+
+// heldPath in heldDir lists the packages the upgrade put on hold.
+const (
+	heldDir  = "/var/lib/kube-machine"
+	heldPath = heldDir + "/patching-held"
+)
+
+// holdScript holds each installed docker package which isn't held yet and
+// records it in heldPath, it fails if a package can't be held.
+const holdScript = "sudo mkdir -p " + heldDir + " && " +
+	"for p in $(dpkg-query -W -f='${db:Status-Abbrev}${Package}\\n' " + dockerPackages + " 2>/dev/null | sed -n 's/^ii *//p'); do " +
+	"if ! apt-mark showhold | grep -qxF \"$p\"; then " +
+	"sudo apt-mark hold \"$p\" >/dev/null && echo \"$p\" | sudo tee -a " + heldPath + " >/dev/null || exit 1; " +
+	"fi; done"
+
+// packageManager holds the commands of a distribution. The docker packages
+// are left alone, they are upgraded with kube-machine upgrade. apt holds them
+// only during the upgrade, so kube-machine upgrade can upgrade them.
+type packageManager struct {
+	// count prints the number of pending updates.
+	count string
+	// rebootRequired prints yes if an installed update requires a reboot.
+	rebootRequired string
+	upgrade        string
+}
+
+var (
+	apt = packageManager{
+		count:          "sudo apt-get -qq update && apt-get -s -o Debug::NoLocking=true upgrade | grep -c '^Inst' || true",
+		rebootRequired: "if [ -f /var/run/reboot-required ]; then echo yes; else echo no; fi",
+		upgrade: "(" + holdScript + ") || { " + UnholdCommand + "; exit 1; }; " +
+			"sudo DEBIAN_FRONTEND=noninteractive apt-get -qq -y -o Dpkg::Options::=--force-confdef -o Dpkg::Options::=--force-confold upgrade; " +
+			"status=$?; " + UnholdCommand + " || status=1; exit $status",
+	}
+	yum = packageManager{
+		count:          "sudo yum -q check-update --exclude='docker*' | grep -cE '^[[:alnum:]_.+-]+[[:space:]]' || true",
+		rebootRequired: "if command -v needs-restarting >/dev/null && ! sudo needs-restarting -r >/dev/null; then echo yes; else echo no; fi",
+		upgrade:        "sudo yum -y -q update --exclude='docker*'",
+	}
+
+	packageManagers = map[string]packageManager{
+		"ubuntu": apt,
+		"debian": apt,
+		"centos": yum,
+		"rhel":   yum,
+		"fedora": yum,
+	}
+)
+
+func detect(r Runner) (packageManager, error) {
+	out, err := r.RunSSHCommand(". /etc/os-release && echo $ID")
+	if err != nil {
+		return packageManager{}, fmt.Errorf("Failed to detect distribution: %v", err)
+	}
+	id := strings.TrimSpace(out)
+	if pm, ok := packageManagers[id]; ok {
+		return pm, nil
+	}
+	if id == "coreos" || id == "flatcar" {
+		return packageManager{}, fmt.Errorf("%s updates itself with update_engine", id)
+	}
+	return packageManager{}, fmt.Errorf("Patching %s is not supported", id)
+}
+
+// Check returns the pending updates of the machine.
+func Check(r Runner) (*Status, error) {
+	pm, err := detect(r)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := r.RunSSHCommand(pm.count)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to check for updates: %v: %s", err, out)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	pending, err := strconv.Atoi(strings.TrimSpace(lines[len(lines)-1]))
+	if err != nil {
+		return nil, fmt.Errorf("Unexpected output of the update check: %s", out)
+	}
+
+	out, err = r.RunSSHCommand(pm.rebootRequired)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to check whether a reboot is required: %v: %s", err, out)
+	}
+
+	return &Status{
+		Pending:        pending,
+		RebootRequired: strings.TrimSpace(out) == "yes",
+		Checked:        time.Now(),
+	}, nil
+}
+
+// Upgrade installs the pending updates of the machine.
+func Upgrade(r Runner) error {
+	pm, err := detect(r)
+	if err != nil {
+		return err
+	}
+	if out, err := r.RunSSHCommand(pm.upgrade); err != nil {
+		return fmt.Errorf("Failed to install updates: %v: %s", err, out)
+	}
+	return nil
+}
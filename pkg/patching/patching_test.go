@@ -0,0 +1,79 @@
+package patching
+
+import (
+	"strings"
+	"testing"
+)
+
+type fakeRunner struct {
+	outputs  map[string]string
+	commands []string
+}
+
+func (r *fakeRunner) RunSSHCommand(command string) (string, error) {
+	r.commands = append(r.commands, command)
+	for prefix, out := range r.outputs {
+		if strings.HasPrefix(command, prefix) {
+			return out, nil
+		}
+	}
+	return "", nil
+}
+
+func TestCheck(t *testing.T) {
+	r := &fakeRunner{outputs: map[string]string{
+		". /etc/os-release":                  "ubuntu\n",
+		"sudo apt-get -qq update":            "12\n",
+		"if [ -f /var/run/reboot-required ]": "yes\n",
+	}}
+
+	status, err := Check(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Pending != 12 || !status.RebootRequired || status.String() != "12 (reboot required)" {
+		t.Errorf("Unexpected status %+v", status)
+	}
+
+	var none *Status
+	if none.String() != "-" {
+		t.Errorf("Unexpected string of unknown status %q", none.String())
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	r := &fakeRunner{outputs: map[string]string{". /etc/os-release": "coreos\n"}}
+	if err := Upgrade(r); err == nil {
+		t.Error("Expected an error patching Container Linux")
+	}
+	if len(r.commands) != 1 {
+		t.Errorf("Expected no commands after detection, got %v", r.commands)
+	}
+}
+
+func TestUpgradeReleasesDockerHold(t *testing.T) {
+	r := &fakeRunner{outputs: map[string]string{". /etc/os-release": "ubuntu\n"}}
+	if err := Upgrade(r); err != nil {
+		t.Fatal(err)
+	}
+	upgrade := r.commands[len(r.commands)-1]
+	hold := strings.Index(upgrade, "apt-mark hold")
+	unhold := strings.Index(upgrade, "apt-mark unhold")
+	if hold < 0 || unhold < hold || !strings.HasSuffix(upgrade, "exit $status") {
+		t.Errorf("Expected the docker packages to be held only during the upgrade, got %q", upgrade)
+	}
+}
+
+func TestUnholdOnlyReleasesOwnHolds(t *testing.T) {
+	if strings.Contains(UnholdCommand, "docker-ce") || !strings.Contains(UnholdCommand, heldPath) {
+		t.Errorf("Expected only the recorded holds to be released, got %q", UnholdCommand)
+	}
+	r := &fakeRunner{outputs: map[string]string{". /etc/os-release": "ubuntu\n"}}
+	if err := Upgrade(r); err != nil {
+		t.Fatal(err)
+	}
+	upgrade := r.commands[len(r.commands)-1]
+	if strings.Contains(upgrade, "apt-mark hold "+dockerPackages) || strings.Contains(upgrade, "2>&1") {
+		t.Errorf("Expected the packages to be held one by one with their errors reported, got %q", upgrade)
+	}
+}
@@ -0,0 +1,73 @@
+package pause
+
+import (
+	"fmt"
+	"time"
+)
+
+// Pause keeps the controller from acting on a machine, e.g. during incident
+// response: it is neither repaired, scaled, initialized nor reconciled, but
+// still observed.
+type Pause struct {
+	// Pool is set if the machine was paused with its pool, which also stops
+	// the scaling of the pool.
+	Pool     string    `json:"pool,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	Operator string    `json:"operator"`
+	Since    time.Time `json:"since"`
+}
+
+func (p Pause) String() string {
+	s := fmt.Sprintf("paused by %s since %s", p.Operator, p.Since.Format(time.RFC3339))
+	if p.Pool != "" {
+		s = fmt.Sprintf("paused with pool %s by %s since %s", p.Pool, p.Operator, p.Since.Format(time.RFC3339))
+	}
+	if p.Reason != "" {
+		s += ": " + p.Reason
+	}
+	return s
+}
+
+// State are the pauses of the machines and of the pools.
+type State struct {
+	Machines map[string]*Pause
+	Pools    map[string]*Pause
+}
+
+// NewState returns the state of the pauses of the machines. A pool is
+// paused as long as a member is paused with it.
+func NewState(machines map[string]*Pause) State {
+	s := State{Machines: machines, Pools: map[string]*Pause{}}
+	if s.Machines == nil {
+		s.Machines = map[string]*Pause{}
+	}
+	for _, p := range s.Machines {
+		if p.Pool == "" {
+			continue
+		}
+		if known, ok := s.Pools[p.Pool]; !ok || p.Since.Before(known.Since) {
+			s.Pools[p.Pool] = p
+		}
+	}
+	return s
+}
+
+// Paused returns the pause of the machine or of its pool, nil if neither
+// is paused.
+func (s State) Paused(machine, pool string) *Pause {
+	if p := s.Machines[machine]; p != nil {
+		return p
+	}
+	if pool != "" {
+		return s.Pools[pool]
+	}
+	return nil
+}
+
+// PoolPaused returns the pause of the pool, nil if it is not paused.
+func (s State) PoolPaused(pool string) *Pause {
+	if pool == "" {
+		return nil
+	}
+	return s.Pools[pool]
+}
@@ -0,0 +1,34 @@
+package pause
+
+import (
+	"testing"
+	"time"
+)
+
+func TestState(t *testing.T) {
+	since := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := NewState(map[string]*Pause{
+		"web-1": {Pool: "web", Operator: "alice", Since: since.Add(time.Minute)},
+		"web-2": {Pool: "web", Operator: "alice", Since: since},
+		"db-1":  {Reason: "investigating disk errors", Operator: "bob", Since: since},
+	})
+
+	if p := s.PoolPaused("web"); p == nil || !p.Since.Equal(since) {
+		t.Errorf("Expected pool web to be paused since %s, got %v", since, p)
+	}
+	if p := s.Paused("web-3", "web"); p == nil || p.Pool != "web" {
+		t.Errorf("Expected web-3 to be paused with its pool, got %v", p)
+	}
+	if p := s.PoolPaused("db"); p != nil {
+		t.Errorf("Expected pool db not to be paused by its paused member, got %v", p)
+	}
+	if p := s.Paused("db-2", "db"); p != nil {
+		t.Errorf("Expected db-2 not to be paused, got %v", p)
+	}
+	if p := s.Paused("db-1", "db"); p == nil || p.String() != "paused by bob since 2017-03-01T12:00:00Z: investigating disk errors" {
+		t.Errorf("Unexpected pause of db-1 %v", p)
+	}
+	if p := NewState(nil).Paused("web-1", ""); p != nil {
+		t.Errorf("Expected no pauses, got %v", p)
+	}
+}
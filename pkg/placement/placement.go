@@ -0,0 +1,210 @@
+package placement
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubermatic/kube-machine/pkg/driverprofiles"
+)
+
+const (
+	ScorerStatic = "static"
+	ScorerPrice  = "price"
+	ScorerCarbon = "carbon"
+)
+
+// defaultFields are the fields of the value in the responses of the APIs.
+var defaultFields = map[string]string{
+	ScorerPrice:  "price",
+	ScorerCarbon: "carbonIntensity",
+}
+
+// Config selects the zone of each new machine of a pool by scoring the zones
+// of the pool, the zone with the lowest total score wins.
+type Config struct {
+	Scorers []ScorerConfig `json:"scorers"`
+}
+
+// ScorerConfig is a source of scores of the zones. Static scorers have fixed
+// values, price and carbon scorers query an API for the price of the machine
+// or the carbon intensity of the power in the zone.
+type ScorerConfig struct {
+	Type string `json:"type"`
+	// Weight of the normalized scores in the total score, 1 by default.
+	Weight float64            `json:"weight,omitempty"`
+	Values map[string]float64 `json:"values,omitempty"`
+	// URL is requested per zone, {zone} and {driver} are replaced.
+	URL string `json:"url,omitempty"`
+	// Field is the path of the value in the JSON response, e.g.
+	// data.carbonIntensity.
+	Field string `json:"field,omitempty"`
+	// Headers of the requests, the values are references resolved like the
+	// values of driver profiles, e.g. env:NAME.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	client *http.Client
+}
+
+// Decision is the placement of a new machine, it is part of the plan.
+type Decision struct {
+	Zone string `json:"zone"`
+	// Scores are the total scores of the zones.
+	Scores map[string]float64 `json:"scores,omitempty"`
+	// Error is why the zones could not be scored, the least used zone is
+	// chosen then.
+	Error string `json:"error,omitempty"`
+}
+
+func (d *Decision) String() string {
+	if d.Error != "" {
+		return fmt.Sprintf("placed in %s, the least used zone, as scoring failed: %s", d.Zone, d.Error)
+	}
+	var scores []string
+	for zone, score := range d.Scores {
+		scores = append(scores, fmt.Sprintf("%s=%.3g", zone, score))
+	}
+	sort.Strings(scores)
+	return fmt.Sprintf("placed in %s with the lowest score (%s)", d.Zone, strings.Join(scores, ", "))
+}
+
+// Validate checks the types and sources of the scorers.
+func (c *Config) Validate() error {
+	if len(c.Scorers) == 0 {
+		return fmt.Errorf("placement has no scorers")
+	}
+	for i, s := range c.Scorers {
+		switch s.Type {
+		case ScorerStatic:
+			if len(s.Values) == 0 {
+				return fmt.Errorf("static scorer %d has no values", i)
+			}
+		case ScorerPrice, ScorerCarbon:
+			if s.URL == "" {
+				return fmt.Errorf("%s scorer %d has no URL", s.Type, i)
+			}
+		default:
+			return fmt.Errorf("scorer %d has unknown type %q, expected %s, %s or %s", i, s.Type, ScorerStatic, ScorerPrice, ScorerCarbon)
+		}
+		if s.Weight < 0 {
+			return fmt.Errorf("scorer %d has a negative weight", i)
+		}
+	}
+	return nil
+}
+
+// Choose places a machine of the driver in one of the zones. Counts are the
+// machines of the pool in the zones, ties go to the less used zone and then
+// to the zone listed first.
+func (c *Config) Choose(driver string, zones []string, counts map[string]int) *Decision {
+	if len(zones) == 0 {
+		return nil
+	}
+	totals := map[string]float64{}
+	for _, s := range c.Scorers {
+		scores := map[string]float64{}
+		max := 0.0
+		for _, zone := range zones {
+			score, err := s.score(driver, zone)
+			if err != nil {
+				return &Decision{Zone: leastUsed(zones, counts), Error: err.Error()}
+			}
+			scores[zone] = score
+			max = math.Max(max, math.Abs(score))
+		}
+		weight := s.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		for zone, score := range scores {
+			if max > 0 {
+				score /= max
+			}
+			totals[zone] += weight * score
+		}
+	}
+
+	best := zones[0]
+	for _, zone := range zones[1:] {
+		if totals[zone] < totals[best] || (totals[zone] == totals[best] && counts[zone] < counts[best]) {
+			best = zone
+		}
+	}
+	return &Decision{Zone: best, Scores: totals}
+}
+
+func leastUsed(zones []string, counts map[string]int) string {
+	best := zones[0]
+	for _, zone := range zones[1:] {
+		if counts[zone] < counts[best] {
+			best = zone
+		}
+	}
+	return best
+}
+
+func (s ScorerConfig) score(driver, zone string) (float64, error) {
+	if s.Type == ScorerStatic {
+		score, ok := s.Values[zone]
+		if !ok {
+			return 0, fmt.Errorf("static scorer has no value for zone %s", zone)
+		}
+		return score, nil
+	}
+
+	u := strings.NewReplacer("{zone}", url.QueryEscape(zone), "{driver}", url.QueryEscape(driver)).Replace(s.URL)
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return 0, err
+	}
+	for name, ref := range s.Headers {
+		value, err := driverprofiles.Resolve(ref)
+		if err != nil {
+			return 0, fmt.Errorf("header %s: %v", name, err)
+		}
+		req.Header.Set(name, value)
+	}
+	client := s.client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%s scorer: %v", s.Type, err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("%s scorer: %v", s.Type, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s scorer: %s responded with %s", s.Type, u, resp.Status)
+	}
+
+	field := s.Field
+	if field == "" {
+		field = defaultFields[s.Type]
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return 0, fmt.Errorf("%s scorer: invalid response of %s: %v", s.Type, u, err)
+	}
+	for _, key := range strings.Split(field, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return 0, fmt.Errorf("%s scorer: no %s in the response of %s", s.Type, field, u)
+		}
+		value = object[key]
+	}
+	score, ok := value.(float64)
+	if !ok {
+		return 0, fmt.Errorf("%s scorer: %s in the response of %s is no number", s.Type, field, u)
+	}
+	return score, nil
+}
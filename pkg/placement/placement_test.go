@@ -0,0 +1,68 @@
+package placement
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestChoose(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("auth-token") != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Query().Get("zone") {
+		case "fra1":
+			w.Write([]byte(`{"data": {"carbonIntensity": 400}}`))
+		case "ams3":
+			w.Write([]byte(`{"data": {"carbonIntensity": 200}}`))
+		default:
+			http.Error(w, "unknown zone", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	carbon := ScorerConfig{Type: ScorerCarbon, URL: server.URL + "/?zone={zone}", Field: "data.carbonIntensity", Headers: map[string]string{"auth-token": "secret"}, client: http.DefaultClient}
+	price := ScorerConfig{Type: ScorerStatic, Weight: 4, Values: map[string]float64{"fra1": 0.1, "ams3": 0.12}}
+
+	c := &Config{Scorers: []ScorerConfig{carbon}}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	d := c.Choose("digitalocean", []string{"fra1", "ams3"}, nil)
+	if d.Zone != "ams3" || !reflect.DeepEqual(d.Scores, map[string]float64{"fra1": 1, "ams3": 0.5}) {
+		t.Errorf("Expected ams3 with the lower carbon intensity, got %+v", d)
+	}
+
+	// Price weighs four times as much: fra1 1+4*0.1/0.12, ams3 0.5+4.
+	c.Scorers = append(c.Scorers, price)
+	if d := c.Choose("digitalocean", []string{"fra1", "ams3"}, nil); d.Zone != "fra1" {
+		t.Errorf("Expected fra1 with the lower price, got %+v", d)
+	}
+
+	static := &Config{Scorers: []ScorerConfig{{Type: ScorerStatic, Values: map[string]float64{"fra1": 1, "ams3": 1}}}}
+	if d := static.Choose("digitalocean", []string{"fra1", "ams3"}, map[string]int{"fra1": 2, "ams3": 1}); d.Zone != "ams3" {
+		t.Errorf("Expected the tie to go to the less used zone, got %+v", d)
+	}
+
+	d = c.Choose("digitalocean", []string{"fra1", "nyc1"}, map[string]int{"fra1": 1})
+	if d.Zone != "nyc1" || d.Error == "" {
+		t.Errorf("Expected the least used zone after a failed scoring, got %+v", d)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, c := range []Config{
+		{},
+		{Scorers: []ScorerConfig{{Type: ScorerStatic}}},
+		{Scorers: []ScorerConfig{{Type: ScorerPrice}}},
+		{Scorers: []ScorerConfig{{Type: "random", URL: "http://example.com"}}},
+		{Scorers: []ScorerConfig{{Type: ScorerCarbon, URL: "http://example.com", Weight: -1}}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", c)
+		}
+	}
+}
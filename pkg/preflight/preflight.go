@@ -0,0 +1,75 @@
+package preflight
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kubermatic/kube-machine/pkg/artifacts"
+)
+
+// Check is a cheap check run before any cloud resource of a machine is
+// created, failing afterwards costs the time and money of the VM.
+type Check struct {
+	Name string
+	Run  func() error
+}
+
+// Run runs all checks, so every problem is reported at once, and returns an
+// error listing the failed ones.
+func Run(checks []Check) error {
+	var failed bytes.Buffer
+	for _, c := range checks {
+		if err := c.Run(); err != nil {
+			fmt.Fprintf(&failed, "\n  %s: %v", c.Name, err)
+		}
+	}
+	if failed.Len() == 0 {
+		return nil
+	}
+	return errors.New("Pre-flight checks failed:" + failed.String())
+}
+
+// ArtifactChecker checks that the artifacts the nodes install are available.
+type ArtifactChecker struct {
+	Client *http.Client
+}
+
+// DefaultArtifactChecker gives up on sources not answering within 30
+// seconds.
+var DefaultArtifactChecker = &ArtifactChecker{Client: &http.Client{Timeout: 30 * time.Second}}
+
+// Check checks that each artifact is available from at least one of its
+// URLs, the nodes fall back from the mirrors to the origin.
+func (c *ArtifactChecker) Check(list []artifacts.Artifact, mirrors []string) error {
+	for _, a := range list {
+		var errs []string
+		for _, u := range a.URLs(mirrors) {
+			err := c.head(u)
+			if err == nil {
+				errs = nil
+				break
+			}
+			errs = append(errs, err.Error())
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("No source of %s is available: %s", a.URL, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+func (c *ArtifactChecker) head(url string) error {
+	resp, err := c.Client.Head(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return nil
+}
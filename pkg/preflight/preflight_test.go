@@ -0,0 +1,53 @@
+package preflight
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kubermatic/kube-machine/pkg/artifacts"
+)
+
+func TestRun(t *testing.T) {
+	ran := 0
+	err := Run([]Check{
+		{Name: "a", Run: func() error { ran++; return nil }},
+		{Name: "b", Run: func() error { ran++; return errors.New("broken") }},
+		{Name: "c", Run: func() error { ran++; return errors.New("missing") }},
+	})
+	if ran != 3 {
+		t.Errorf("expected all checks to run, ran %d", ran)
+	}
+	if err == nil || err.Error() != "Pre-flight checks failed:\n  b: broken\n  c: missing" {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if err := Run([]Check{{Name: "a", Run: func() error { return nil }}}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestArtifactChecker(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "HEAD" || r.URL.Path != "/release/v1.5.3/kubelet" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer origin.Close()
+	mirror := httptest.NewServer(http.NotFoundHandler())
+	defer mirror.Close()
+
+	c := &ArtifactChecker{Client: http.DefaultClient}
+	kubelet := artifacts.Artifact{URL: origin.URL + "/release/v1.5.3/kubelet"}
+	if err := c.Check([]artifacts.Artifact{kubelet}, []string{mirror.URL}); err != nil {
+		t.Errorf("expected the origin to be used after the mirror failed: %v", err)
+	}
+
+	missing := artifacts.Artifact{URL: origin.URL + "/release/v9.9.9/kubelet"}
+	err := c.Check([]artifacts.Artifact{kubelet, missing}, []string{mirror.URL})
+	if err == nil || !strings.Contains(err.Error(), "v9.9.9") || !strings.Contains(err.Error(), "404") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
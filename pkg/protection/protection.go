@@ -0,0 +1,49 @@
+package protection
+
+import (
+	"fmt"
+	"time"
+)
+
+// Protection keeps a machine from being deleted, e.g. as it runs a database
+// on local persistent volumes.
+type Protection struct {
+	Reason   string    `json:"reason,omitempty"`
+	Operator string    `json:"operator"`
+	Since    time.Time `json:"since"`
+}
+
+// Store records the protection of machines.
+type Store interface {
+	// Protection returns the protection of the machine, it is nil if the
+	// machine is not protected.
+	Protection(name string) (*Protection, error)
+	// SetProtection protects the machine, nil lifts the protection.
+	SetProtection(name string, p *Protection) error
+}
+
+// ErrProtected is returned when a protected machine would be deleted.
+type ErrProtected struct {
+	Machine    string
+	Protection Protection
+}
+
+func (e ErrProtected) Error() string {
+	reason := ""
+	if e.Protection.Reason != "" {
+		reason = ": " + e.Protection.Reason
+	}
+	return fmt.Sprintf("%s is protected by %s since %s%s, use --unprotect to delete it", e.Machine, e.Protection.Operator, e.Protection.Since.Format(time.RFC3339), reason)
+}
+
+// Check returns ErrProtected if the machine is protected.
+func Check(s Store, name string) error {
+	p, err := s.Protection(name)
+	if err != nil {
+		return err
+	}
+	if p != nil {
+		return ErrProtected{Machine: name, Protection: *p}
+	}
+	return nil
+}
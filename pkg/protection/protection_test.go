@@ -0,0 +1,34 @@
+package protection
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeStore map[string]*Protection
+
+func (s fakeStore) Protection(name string) (*Protection, error) {
+	return s[name], nil
+}
+
+func (s fakeStore) SetProtection(name string, p *Protection) error {
+	s[name] = p
+	return nil
+}
+
+func TestCheck(t *testing.T) {
+	since := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := fakeStore{"db-1": {Reason: "local PV of postgres", Operator: "alice", Since: since}}
+
+	err := Check(s, "db-1")
+	if _, ok := err.(ErrProtected); !ok {
+		t.Fatalf("expected ErrProtected, got %v", err)
+	}
+	expected := "db-1 is protected by alice since 2017-03-01T12:00:00Z: local PV of postgres, use --unprotect to delete it"
+	if err.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, err.Error())
+	}
+	if err := Check(s, "web-1"); err != nil {
+		t.Errorf("expected web-1 not to be protected, got %v", err)
+	}
+}
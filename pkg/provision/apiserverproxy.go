@@ -0,0 +1,124 @@
+package detector
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"text/template"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/kubermatic/kube-machine/pkg/initsystem"
+	"github.com/kubermatic/kube-machine/pkg/kubeconfig"
+)
+
+const (
+	apiServerProxyConfigPath = "/etc/kube-machine/apiserver-proxy.cfg"
+	apiServerProxyUnitPath   = "/etc/systemd/system/kube-apiserver-proxy.service"
+	apiServerProxyUnit       = `[Unit]
+Description=Kubernetes API server failover proxy
+Requires=docker.service
+After=docker.service
+
+[Service]
+Restart=always
+RestartSec=5
+ExecStartPre=-/usr/bin/docker rm -f kube-apiserver-proxy
+ExecStart=/usr/bin/docker run --name kube-apiserver-proxy --net=host \
+  -v ` + apiServerProxyConfigPath + `:/usr/local/etc/haproxy/haproxy.cfg:ro \
+  haproxy:1.7-alpine
+ExecStop=/usr/bin/docker stop kube-apiserver-proxy
+
+[Install]
+WantedBy=multi-user.target
+`
+)
+
+// The proxy passes TLS through, so the kubelet still verifies the API
+// server, whose certificate needs to be valid for 127.0.0.1.
+var apiServerProxyTemplate = template.Must(template.New("proxy").Parse(`global
+  maxconn 1000
+
+defaults
+  mode tcp
+  timeout connect 5s
+  timeout client 1h
+  timeout server 1h
+
+frontend apiserver
+  bind {{ .Address }}
+  default_backend apiservers
+
+backend apiservers
+  option tcp-check
+  default-server inter 5s fall 3 rise 2
+{{ range $i, $server := .Servers -}}
+{{ "  " }}server apiserver-{{ $i }} {{ $server }} check
+{{ end -}}
+`))
+
+func apiServerProxyConfig(endpoints []string) (string, error) {
+	var servers []string
+	for _, endpoint := range endpoints {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" {
+			return "", fmt.Errorf("Invalid API server endpoint %q", endpoint)
+		}
+		// HAProxy doesn't take brackets around IPv6 addresses.
+		if host, port, err := net.SplitHostPort(u.Host); err == nil && strings.Contains(host, ":") {
+			servers = append(servers, "ipv6@"+host+":"+port)
+			continue
+		}
+		servers = append(servers, u.Host)
+	}
+
+	config := &bytes.Buffer{}
+	err := apiServerProxyTemplate.Execute(config, struct {
+		Address string
+		Servers []string
+	}{kubeconfig.LocalProxyAddress, servers})
+	return config.String(), err
+}
+
+// configureAPIServerProxy runs the proxy failing over between the API
+// servers of the cluster access, or disables it if the node connects to a
+// single API server.
+func (p *KubeletProvisionerWrapper) configureAPIServerProxy(access KubeletConfig, service initsystem.InitSystem) error {
+	endpoints, err := access.APIServers()
+	if err != nil {
+		return err
+	}
+
+	if len(endpoints) == 0 {
+		out, err := p.Provisioner.SSHCommand(service.DisableCommand("kube-apiserver-proxy"))
+		if err != nil {
+			return fmt.Errorf("Failed to disable API server proxy (error: %v): %v", err, out)
+		}
+		return nil
+	}
+
+	config, err := apiServerProxyConfig(endpoints)
+	if err != nil {
+		return err
+	}
+	log.Infof("Configuring the API server proxy for %d endpoints on the node...", len(endpoints))
+	if _, err := p.Provisioner.SSHCommand("sudo mkdir -p /etc/kube-machine"); err != nil {
+		return err
+	}
+	if err := p.scp([]byte(config), apiServerProxyConfigPath, 0644); err != nil {
+		return err
+	}
+	file, err := service.ServiceFile("kube-apiserver-proxy", apiServerProxyUnitPath, apiServerProxyUnit, 0644)
+	if err != nil {
+		return err
+	}
+	if err := p.scp(file.Content, file.Path, file.Mode); err != nil {
+		return err
+	}
+	out, err := p.Provisioner.SSHCommand(service.EnableCommand("kube-apiserver-proxy") + " && " + service.RestartCommand("kube-apiserver-proxy"))
+	if err != nil {
+		return fmt.Errorf("Failed to start API server proxy (error: %v): %v", err, out)
+	}
+	return nil
+}
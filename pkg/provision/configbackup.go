@@ -0,0 +1,107 @@
+package detector
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/docker/machine/libmachine/engine"
+	"github.com/docker/machine/libmachine/log"
+	"github.com/kubermatic/kube-machine/pkg/configbackup"
+	"github.com/kubermatic/kube-machine/pkg/heartbeat"
+	"github.com/kubermatic/kube-machine/pkg/initsystem"
+	"github.com/kubermatic/kube-machine/pkg/ipfamily"
+	"github.com/kubermatic/kube-machine/pkg/logrotate"
+	"github.com/kubermatic/kube-machine/pkg/registries"
+	"github.com/kubermatic/kube-machine/pkg/rollback"
+	"github.com/kubermatic/kube-machine/pkg/sshca"
+)
+
+const (
+	initScriptDir  = "/etc/init.d"
+	sshdConfigPath = "/etc/ssh/sshd_config"
+)
+
+// kubeletConfigFiles are the unit or init script and the kubeconfig of the
+// kubelet.
+func kubeletConfigFiles(engineOptions engine.Options) []string {
+	paths := NodePaths(engineOptions)
+	return []string{paths.KubeletUnit, initScriptDir + "/kubelet", paths.Kubeconfig}
+}
+
+// apiServerProxyFiles are the configuration and the unit or init script of
+// the API server proxy.
+func apiServerProxyFiles() []string {
+	return []string{apiServerProxyConfigPath, apiServerProxyUnitPath, initScriptDir + "/kube-apiserver-proxy"}
+}
+
+// provisionConfigFiles are the units, configurations and certificates
+// provisioning writes, the binaries are left out.
+func provisionConfigFiles(engineOptions engine.Options) []string {
+	files := append(kubeletConfigFiles(engineOptions), apiServerProxyFiles()...)
+	return append(files,
+		kubeletCertDir,
+		registries.KubeletDockerConfigPath,
+		logrotate.JournaldConfigPath,
+		ipfamily.SysctlPath,
+		sshca.TrustedCAPath,
+		sshdConfigPath,
+		heartbeat.Dir,
+		heartbeat.UnitPath,
+		initScriptDir+"/"+heartbeat.Service,
+	)
+}
+
+// backupConfig snapshots the files the operation is about to change on the
+// node, see configbackup.
+func (p *KubeletProvisionerWrapper) backupConfig(operation string, files []string) error {
+	return p.step("config-backup", func() error {
+		name := configbackup.Name(operation, time.Now())
+		log.Infof("Backing up the configuration of the node to %s/%s...", configbackup.Root, name)
+		if err := p.runAsRoot(configbackup.SnapshotScript(name, files)); err != nil {
+			return fmt.Errorf("Failed to back up the configuration of the node: %v", err)
+		}
+		return nil
+	})
+}
+
+// PruneCredentials removes the kubeconfig of the kubelet from the
+// configuration and upgrade backups on the node once it was rotated, so the
+// replaced credentials don't survive in them.
+func (p *KubeletProvisionerWrapper) PruneCredentials(engineOptions engine.Options) error {
+	return p.step("config-prune", func() error {
+		if err := p.runAsRoot(configbackup.PruneScript([]string{NodePaths(engineOptions).Kubeconfig}, rollback.BackupDir)); err != nil {
+			return fmt.Errorf("Failed to remove the replaced credentials from the configuration backups: %v", err)
+		}
+		return nil
+	})
+}
+
+// ConfigBackups returns the configuration backups on the node, the newest
+// first.
+func (p *KubeletProvisionerWrapper) ConfigBackups() ([]configbackup.Backup, error) {
+	out, err := p.Provisioner.SSHCommand("sudo " + configbackup.ListCommand)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list the configuration backups (error: %v): %v", err, out)
+	}
+	return configbackup.ParseList(out), nil
+}
+
+// RestoreConfig restores the named configuration backup and restarts the
+// kubelet and the API server proxy with it.
+func (p *KubeletProvisionerWrapper) RestoreConfig(engineOptions engine.Options, name string) error {
+	service, err := initsystem.Get(engineOptions.InitSystem)
+	if err != nil {
+		return err
+	}
+	if err := p.step("config-restore", func() error {
+		return p.runAsRoot(configbackup.RestoreNamedScript(name))
+	}); err != nil {
+		return err
+	}
+	return p.step("kubelet-restart", func() error {
+		if out, err := p.Provisioner.SSHCommand(service.StatusCommand("kube-apiserver-proxy") + " && " + service.RestartCommand("kube-apiserver-proxy")); err != nil {
+			log.Debugf("Not restarting the API server proxy (error: %v): %v", err, out)
+		}
+		return p.restartKubelet(service)
+	})
+}
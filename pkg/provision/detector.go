@@ -1,10 +1,19 @@
 package detector
 
 import (
-	"encoding/base64"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"text/template"
+	"time"
 
 	"bytes"
 	"github.com/docker/machine/libmachine/auth"
@@ -12,51 +21,387 @@ import (
 	"github.com/docker/machine/libmachine/engine"
 	"github.com/docker/machine/libmachine/log"
 	"github.com/docker/machine/libmachine/provision"
+	"github.com/docker/machine/libmachine/ssh"
 	"github.com/docker/machine/libmachine/swarm"
+	"github.com/kubermatic/kube-machine/pkg/artifacts"
+	"github.com/kubermatic/kube-machine/pkg/bootstrap"
+	"github.com/kubermatic/kube-machine/pkg/cgroups"
+	"github.com/kubermatic/kube-machine/pkg/cni"
+	"github.com/kubermatic/kube-machine/pkg/configbackup"
+	"github.com/kubermatic/kube-machine/pkg/credentials"
+	"github.com/kubermatic/kube-machine/pkg/deadline"
+	"github.com/kubermatic/kube-machine/pkg/deploy"
+	"github.com/kubermatic/kube-machine/pkg/firewall"
+	"github.com/kubermatic/kube-machine/pkg/hardening"
+	"github.com/kubermatic/kube-machine/pkg/heartbeat"
+	"github.com/kubermatic/kube-machine/pkg/initsystem"
+	"github.com/kubermatic/kube-machine/pkg/ipam"
+	"github.com/kubermatic/kube-machine/pkg/ipchange"
+	"github.com/kubermatic/kube-machine/pkg/ipfamily"
+	"github.com/kubermatic/kube-machine/pkg/kubeconfig"
+	"github.com/kubermatic/kube-machine/pkg/kubeletprofiles"
+	"github.com/kubermatic/kube-machine/pkg/logrotate"
+	"github.com/kubermatic/kube-machine/pkg/metrics"
+	"github.com/kubermatic/kube-machine/pkg/nodeagent"
+	"github.com/kubermatic/kube-machine/pkg/nodedeps"
+	"github.com/kubermatic/kube-machine/pkg/nodepaths"
+	"github.com/kubermatic/kube-machine/pkg/nodestore"
+	"github.com/kubermatic/kube-machine/pkg/oplog"
+	"github.com/kubermatic/kube-machine/pkg/registries"
+	"github.com/kubermatic/kube-machine/pkg/remote"
+	"github.com/kubermatic/kube-machine/pkg/resources"
+	"github.com/kubermatic/kube-machine/pkg/rollback"
+	"github.com/kubermatic/kube-machine/pkg/sshca"
+	"github.com/kubermatic/kube-machine/pkg/talos"
+	"github.com/kubermatic/kube-machine/pkg/templates"
+	"github.com/kubermatic/kube-machine/pkg/tracing"
+	"github.com/kubermatic/kube-machine/pkg/volumes"
 )
 
+// KubeletVersion is the version of the kubelet installed on the nodes.
+const KubeletVersion = "v1.5.3"
+
+// InstalledKubeletVersion returns the version of the kubelet of a machine,
+// it is empty for the default of Talos.
+func InstalledKubeletVersion(engineOptions engine.Options) string {
+	if engineOptions.Transport == engine.TransportTalos {
+		return engineOptions.TalosKubeletVersion
+	}
+	return KubeletVersion
+}
+
 const (
-	nodeKubeconfigPath = "/etc/kubeconfig"
-	kubeletUnitPath    = "/etc/systemd/system/kubelet.service"
-	kubeletUnitFile    = `[Unit]
+	kubeletUnitName = "kubelet.service"
+	kubeletPath     = "/var/lib/kubelet/kubelet"
+	kubeletURL      = "https://storage.googleapis.com/kubernetes-release/release/" + KubeletVersion + "/bin/linux/amd64/kubelet"
+
+	// kubeletCertDir holds the serving certificate of the kubelet, which
+	// generates a self-signed one unless it was shipped by
+	// ConfigureKubeletTLS.
+	kubeletCertDir = "/var/lib/kubelet/pki"
+)
+
+var kubeletUnitTemplate = template.Must(template.New("kubelet").Parse(`[Unit]
 Description=Kubernetes Kubelet
 
 [Service]
 Restart=always
 RestartSec=10
-Environment="PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin:/opt/bin"
+{{range .Directives}}{{.}}
+{{end}}Environment="PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin:/opt/bin"
 ExecStartPre=/usr/bin/mkdir -p /var/lib/kubelet /var/run/kubernetes
-ExecStartPre=/usr/bin/curl -L -o /var/lib/kubelet/kubelet https://storage.googleapis.com/kubernetes-release/release/v1.5.3/bin/linux/amd64/kubelet
-ExecStartPre=/usr/bin/chmod +x /var/lib/kubelet/kubelet
 ExecStartPre=/usr/bin/mkdir -p /opt/bin
-ExecStartPre=/usr/bin/curl -L -o /opt/bin/socat https://s3-eu-west-1.amazonaws.com/kubermatic/coreos/socat
-ExecStartPre=/usr/bin/chmod +x /opt/bin/socat
-ExecStart=/var/lib/kubelet/kubelet \
+ExecStart={{.KubeletPath}} \
   --address=0.0.0.0 \
   --anonymous-auth=false \
-  --kubeconfig=/etc/kubeconfig \
+  --kubeconfig={{.Kubeconfig}} \
+  --cgroup-driver={{.CgroupDriver}} \
   --require-kubeconfig \
   --cluster-dns=10.10.10.10 \
   --cluster-domain=cluster.local \
   --allow-privileged=true \
   --client-ca-file=/etc/ssl/etcd/root-ca.crt \
-  --hostname-override=207.154.215.45 \
-  --v=2 \
+  --cert-dir={{.CertDir}} \
+  --hostname-override={{.NodeName}} \
+{{if .NodeIP}}  --node-ip={{.NodeIP}} \
+{{end}}  --v=2 \
   --logtostderr=true \
-  --network-plugin=cni
+  --network-plugin=cni{{range .Flags}} \
+  {{.}}{{end}}
 [Install]
 WantedBy=multi-user.target
-`
-)
+`))
+
+// KubeletConfig provides the cluster access of the kubelets.
+type KubeletConfig interface {
+	// Kubeconfig returns the kubeconfig of the kubelet of the machine.
+	Kubeconfig(machineName string) ([]byte, error)
+	// APIServers returns the endpoints the node fails over between through
+	// a local proxy, it is empty if the kubeconfig points to the API server
+	// directly.
+	APIServers() ([]string, error)
+	// ServingCertificate returns a serving certificate of the kubelet of
+	// the machine for the addresses signed by the cluster CA and its key.
+	ServingCertificate(machineName string, addresses []string) (cert, key []byte, err error)
+}
+
+// kubeletConfig returns the cluster access of the kubelet of the machine,
+// the kubeconfig file and API servers stored with the machine replace the
+// ones of the kubeconfig source.
+func kubeletConfig(config KubeletConfig, engineOptions engine.Options) KubeletConfig {
+	source, ok := config.(*kubeconfig.Source)
+	if !ok || (engineOptions.KubeletKubeconfig == "" && len(engineOptions.KubeletAPIServers) == 0) {
+		return config
+	}
+	stored := &kubeconfig.Source{File: source.File, StoreKubeconfig: source.StoreKubeconfig, Endpoints: source.Endpoints}
+	if engineOptions.KubeletKubeconfig != "" {
+		stored.File = engineOptions.KubeletKubeconfig
+	}
+	if len(engineOptions.KubeletAPIServers) > 0 {
+		stored.Endpoints = engineOptions.KubeletAPIServers
+	}
+	return stored
+}
 
 type ExtendedKubeProvisionerDetector struct {
 	provision.Detector
-	KubeconfigPath string
+	KubeletConfig KubeletConfig
+	// Artifacts is set to push the node binaries from the cache instead of
+	// downloading them on every node.
+	Artifacts *artifacts.Cache
+	// Templates reads the secrets and config maps referenced by custom
+	// kubelet unit templates.
+	Templates templates.Lookup
+	// StepTimeout limits each provisioning step if it is positive, the steps
+	// are aborted once the context of the driver of the machine ends.
+	StepTimeout time.Duration
+	// Resources records the capacity of the machines detected during
+	// provisioning.
+	Resources ResourceStore
+	// SSHCA is the public key of the SSH CA sshd of the nodes trusts.
+	SSHCA string
+	// States records the hash of the artifacts the machines were
+	// provisioned with.
+	States StateStore
+	// Kubeconfig is the cluster machines with the cluster transport are
+	// provisioned through.
+	Kubeconfig string
+	// Heartbeats grants the heartbeat agents of the machines their access.
+	Heartbeats HeartbeatGranter
+	// UpgradeTimeout is how long an upgraded node may stay not ready before
+	// the upgrade is rolled back, rollback.DefaultTimeout if not positive.
+	// Rollbacks marks the machines whose upgrade was rolled back.
+	UpgradeTimeout time.Duration
+	Rollbacks      RollbackStore
+}
+
+// ResourceStore records the capacity of machines.
+type ResourceStore interface {
+	SetResources(name string, r *resources.Resources) error
+}
+
+// HeartbeatGranter creates the credentials of the heartbeat agent of a
+// machine and returns the cluster it reports to.
+type HeartbeatGranter interface {
+	GrantHeartbeat(machineName string) (kubeconfig.Cluster, string, error)
 }
 
 type KubeletProvisionerWrapper struct {
 	provision.Provisioner
-	KubeconfigPath string
+	KubeletConfig  KubeletConfig
+	Artifacts      *artifacts.Cache
+	Templates      templates.Lookup
+	Context        context.Context
+	StepTimeout    time.Duration
+	Resources      ResourceStore
+	SSHCA          string
+	States         StateStore
+	Heartbeats     HeartbeatGranter
+	Kubeconfig     string
+	UpgradeTimeout time.Duration
+	Rollbacks      RollbackStore
+}
+
+// nodeArtifacts are the binaries the kubelet unit runs, its dependencies are
+// installed by installDependencies.
+var nodeArtifacts = []artifacts.Artifact{
+	{URL: kubeletURL, Path: kubeletPath},
+}
+
+// NodeArtifacts returns the binaries the nodes download.
+func NodeArtifacts() []artifacts.Artifact {
+	return append([]artifacts.Artifact(nil), nodeArtifacts...)
+}
+
+// NodePaths returns where the machine keeps its kubelet kubeconfig and unit.
+func NodePaths(engineOptions engine.Options) nodepaths.Paths {
+	return nodepaths.Paths{Kubeconfig: engineOptions.KubeconfigPath, KubeletUnit: engineOptions.KubeletUnitPath}.WithDefaults()
+}
+
+// kubeletUnit returns the unit of the kubelet registering the node with the
+// name of the machine, reading its kubeconfig as the credential profile
+// defines, using the cgroup driver of the engine and the flags of the CNI
+// profile. The node IP is set if the engine options have one, the reserved
+// resources and eviction thresholds if the capacity of the machine is known,
+// the settings of the kubelet profile if it has one and the flags of the
+// hardening profile. The custom unit template of the machine replaces the
+// default one, its secret and config map references are read with the
+// lookup.
+func kubeletUnit(nodeName string, engineOptions engine.Options, capacity *resources.Resources, lookup templates.Lookup) (string, error) {
+	network, err := cni.Get(engineOptions.CNI)
+	if err != nil {
+		return "", err
+	}
+	settings, err := kubeletprofiles.Get(engineOptions.KubeletProfile)
+	if err != nil {
+		return "", err
+	}
+	flags := append([]string{}, network.KubeletFlags...)
+	cpus := 0
+	if capacity != nil {
+		flags = append(flags, resources.KubeletFlags(*capacity)...)
+		cpus = capacity.CPUs
+	}
+	// The flags of the profile come last so they override the eviction
+	// thresholds derived from the capacity.
+	flags = append(flags, settings.KubeletFlags(cpus)...)
+	flags = append(flags, hardening.KubeletFlags(engineOptions.Hardening)...)
+	profile := engineOptions.KubeletCredentials
+	kubeconfig := credentials.KubeletKubeconfigPath(profile, NodePaths(engineOptions).Kubeconfig)
+	data := struct {
+		NodeName, NodeIP, KubeletPath, Kubeconfig, CgroupDriver, CertDir string
+		Directives, Flags                                                []string
+	}{nodeName, engineOptions.NodeIP, kubeletPath, kubeconfig, cgroupDriver(engineOptions), kubeletCertDir, credentials.UnitDirectives(profile), flags}
+
+	if engineOptions.KubeletUnitTemplate != "" {
+		tmpl, err := templates.Parse("kubelet", engineOptions.KubeletUnitTemplate, lookup)
+		if err != nil {
+			return "", err
+		}
+		return templates.Execute(tmpl, data)
+	}
+	unit := &bytes.Buffer{}
+	err = kubeletUnitTemplate.Execute(unit, data)
+	return unit.String(), err
+}
+
+// cgroupDriver returns the cgroup driver of the engine, machines created
+// before it was configurable use the cgroupfs default of the engine.
+func cgroupDriver(engineOptions engine.Options) string {
+	if engineOptions.CgroupDriver == "" {
+		return cgroups.DriverCgroupfs
+	}
+	return engineOptions.CgroupDriver
+}
+
+// installBinaries fetches the kubelet from the mirrors or its
+// origin unless they are present already, e.g. on machines booted from a
+// golden image.
+func installBinaries(mirrors []string) string {
+	return remote.Quote(artifacts.InstallScript(nodeArtifacts, mirrors))
+}
+
+// installDependencies installs socat, conntrack and the other commands the
+// kubelet and kube-proxy need with the package manager of the node, or from
+// the static builds of the machine on distributions without one.
+func installDependencies(staticBinaries, mirrors []string) (string, error) {
+	builds, err := nodedeps.ParseStaticBuilds(staticBinaries)
+	if err != nil {
+		return "", err
+	}
+	return remote.Quote(nodedeps.Script(builds, mirrors)), nil
+}
+
+// generalizeCommand prepares a provisioned machine for being snapshotted
+// into a golden image: the binaries are fetched and the identity of the
+// machine and its node is removed along with the backups holding copies of
+// its credentials, the provisioning of machines booted from the image sets
+// them up again.
+func generalizeCommand(paths nodepaths.Paths, service initsystem.InitSystem) string {
+	return strings.Join([]string{
+		service.StopCommand("kubelet") + " || true",
+		"sudo mkdir -p /var/lib/kubelet /opt/bin",
+		"sudo sh -c " + installBinaries(nil),
+		credentials.RemoveCommand(paths.Kubeconfig),
+		"sudo rm -f " + apiServerProxyConfigPath + " /etc/docker/key.json",
+		"sudo rm -rf /var/lib/kubelet/pki /var/lib/cloud/instances " + configbackup.Root + " " + rollback.BackupDir,
+		"sudo truncate -s 0 /etc/machine-id",
+	}, " && ")
+}
+
+// Bootstrap returns the files and units the provisioner sets up on a node,
+// for nodes bootstrapped from user-data instead of SSH. The kubelet unit uses
+// the cgroup driver and unit template of the engine options, the kubeconfig
+// is always stored on disk at the kubeconfig path of the machine. The unit
+// is installed into the unit directory of the user-data format, which
+// requires systemd. Only a configured node IP is set, it can't be detected
+// before the node boots.
+func Bootstrap(nodeName string, kubeconfig []byte, apiServers []string, engineOptions engine.Options, lookup templates.Lookup) (*bootstrap.Config, error) {
+	if engineOptions.InitSystem != "" && engineOptions.InitSystem != initsystem.Systemd {
+		return nil, fmt.Errorf("Failed to bootstrap the node from user-data: it requires systemd, not %s", engineOptions.InitSystem)
+	}
+	engineOptions.KubeletCredentials = credentials.ProfileDisk
+	unit, err := kubeletUnit(nodeName, engineOptions, nil, lookup)
+	if err != nil {
+		return nil, err
+	}
+	dependencies, err := installDependencies(engineOptions.StaticBinaries, engineOptions.ArtifactMirrors)
+	if err != nil {
+		return nil, err
+	}
+	network, err := cni.Get(engineOptions.CNI)
+	if err != nil {
+		return nil, err
+	}
+	config := &bootstrap.Config{
+		Files: []bootstrap.File{
+			{Path: NodePaths(engineOptions).Kubeconfig, Mode: 0600, Content: kubeconfig},
+		},
+		Units: []bootstrap.Unit{
+			{Name: kubeletUnitName, Content: unit, Enable: true},
+		},
+		Commands: []string{
+			"sh -c " + remote.Quote(network.ApplyCommand()),
+			"sh -c " + dependencies,
+			"sh -c " + installBinaries(engineOptions.ArtifactMirrors),
+		},
+	}
+
+	for _, f := range network.Files() {
+		config.Files = append(config.Files, bootstrap.File{Path: f.Path, Mode: 0644, Content: []byte(f.Content)})
+	}
+	if sysctls := ipfamily.Sysctls(engineOptions.IPFamily); sysctls != "" {
+		config.Files = append(config.Files, bootstrap.File{Path: ipfamily.SysctlPath, Mode: 0644, Content: []byte(sysctls)})
+		config.Commands = append([]string{"sysctl -q -p " + ipfamily.SysctlPath}, config.Commands...)
+	}
+	hardeningScript, err := hardening.Script(engineOptions.Hardening, "", NodePaths(engineOptions))
+	if err != nil {
+		return nil, err
+	}
+	if hardeningScript != "" {
+		config.Commands = append([]string{"sh -c " + remote.Quote(hardeningScript)}, config.Commands...)
+	}
+
+	if len(apiServers) > 0 {
+		proxyConfig, err := apiServerProxyConfig(apiServers)
+		if err != nil {
+			return nil, err
+		}
+		config.Files = append(config.Files, bootstrap.File{Path: apiServerProxyConfigPath, Mode: 0644, Content: []byte(proxyConfig)})
+		config.Units = append(config.Units, bootstrap.Unit{Name: path.Base(apiServerProxyUnitPath), Content: apiServerProxyUnit, Enable: true})
+	}
+	return config, nil
+}
+
+// ProvisionTalos patches the machine config of the Talos machine so its
+// kubelet registers the node of the machine with the bootstrap token of the
+// kubelet kubeconfig.
+func (d *ExtendedKubeProvisionerDetector) ProvisionTalos(ctx context.Context, name, address string, engineOptions engine.Options, initial bool) error {
+	timeout := d.StepTimeout
+	if timeout <= 0 {
+		timeout = nodeagent.DefaultTimeout
+	}
+	return deadline.RunTimeout(ctx, timeout, "Provisioning the Talos machine", func() error {
+		data, err := kubeletConfig(d.KubeletConfig, engineOptions).Kubeconfig(name)
+		if err != nil {
+			return err
+		}
+		cluster, token, err := kubeconfig.Token(data)
+		if err != nil {
+			return err
+		}
+		node := talos.Node{
+			Name:           name,
+			KubeletVersion: engineOptions.TalosKubeletVersion,
+			Labels:         map[string]string{nodestore.KubeMachineLabel: "true"},
+		}
+		patch, err := talos.Patch(node, talos.Registration{Server: cluster.Server, CAData: cluster.CAData, Token: token})
+		if err != nil {
+			return err
+		}
+		client := talos.Client{BaseConfig: engineOptions.TalosBaseConfig, Talosconfig: engineOptions.Talosconfig}
+		return client.Apply(address, patch, initial)
+	})
 }
 
 func (d *ExtendedKubeProvisionerDetector) DetectProvisioner(driver drivers.Driver) (provision.Provisioner, error) {
@@ -65,54 +410,942 @@ func (d *ExtendedKubeProvisionerDetector) DetectProvisioner(driver drivers.Drive
 		return nil, err
 	}
 
-	return &KubeletProvisionerWrapper{p, d.KubeconfigPath}, nil
+	return &KubeletProvisionerWrapper{p, d.KubeletConfig, d.Artifacts, d.Templates, drivers.Context(driver), d.StepTimeout, d.Resources, d.SSHCA, d.States, d.Heartbeats, d.Kubeconfig, d.UpgradeTimeout, d.Rollbacks}, nil
+}
+
+// ProvisionThroughCluster writes the bootstrap of the machine in its secret
+// and waits for the node agent to run it. The engine is installed by the
+// bootstrap, the steps needing SSH are left out.
+func (d *ExtendedKubeProvisionerDetector) ProvisionThroughCluster(ctx context.Context, name string, engineOptions engine.Options) error {
+	timeout := d.StepTimeout
+	if timeout <= 0 {
+		timeout = nodeagent.DefaultTimeout
+	}
+	return deadline.RunTimeout(ctx, timeout, "Provisioning through the cluster", func() error {
+		access := kubeletConfig(d.KubeletConfig, engineOptions)
+		kubeconfig, err := access.Kubeconfig(name)
+		if err != nil {
+			return err
+		}
+		apiServers, err := access.APIServers()
+		if err != nil {
+			return err
+		}
+		config, err := Bootstrap(name, kubeconfig, apiServers, engineOptions, d.Templates)
+		if err != nil {
+			return err
+		}
+		var commands []string
+		if engineOptions.StaticAddress != "" {
+			script, err := ipam.Script(staticLease(engineOptions))
+			if err != nil {
+				return err
+			}
+			commands = append(commands, "sh -c "+remote.Quote(script))
+		}
+		if engineOptions.InstallURL != "" {
+			commands = append(commands, fmt.Sprintf("command -v docker >/dev/null || curl -sSL %s | sh", engineOptions.InstallURL))
+		}
+		config.Commands = append(commands, config.Commands...)
+		script, err := config.Script()
+		if err != nil {
+			return err
+		}
+
+		client, err := nodestore.NewClient(d.Kubeconfig)
+		if err != nil {
+			return err
+		}
+		// The token of the agent expired since the machine was created.
+		if _, err := nodeagent.Grant(client, name); err != nil {
+			return err
+		}
+		generation, err := nodeagent.Publish(client, name, script)
+		if err != nil {
+			return err
+		}
+		return nodeagent.Wait(client, name, generation, timeout)
+	})
 }
 
 func (p *KubeletProvisionerWrapper) Provision(swarmOptions swarm.Options, authOptions auth.Options, engineOptions engine.Options) error {
-	err := p.Provisioner.Provision(swarmOptions, authOptions, engineOptions)
+	if err := p.step("static-ip", func() error {
+		return p.configureStaticIP(engineOptions)
+	}); err != nil {
+		return err
+	}
+
+	if err := p.step("cgroups", func() error {
+		return p.checkCgroups(engineOptions)
+	}); err != nil {
+		return err
+	}
+
+	// The volumes are mounted before the engine is installed, a volume of
+	// /var/lib/docker or /var/lib/containerd would hide its data otherwise.
+	if err := p.step("volumes", p.mountVolumes); err != nil {
+		return err
+	}
+
+	err := p.step("engine", func() error {
+		return p.Provisioner.Provision(swarmOptions, authOptions, engineOptions)
+	})
+	if err != nil {
+		return err
+	}
+
+	// The engine provisioner installs sudo where it's missing.
+	if err := p.backupConfig("provision", provisionConfigFiles(engineOptions)); err != nil {
+		return err
+	}
+
+	if err := p.step("registries", func() error {
+		return p.configureRegistries(engineOptions)
+	}); err != nil {
+		return err
+	}
+
+	if err := p.step("ssh-ca", p.trustSSHCA); err != nil {
+		return err
+	}
+
+	if err := p.step("journald", func() error {
+		return p.configureJournald(engineOptions.JournaldMaxUse, engineOptions.InitSystem)
+	}); err != nil {
+		return err
+	}
+
+	if err := p.step("firewall", func() error {
+		return p.configureFirewall(engineOptions)
+	}); err != nil {
+		return err
+	}
+
+	if err := p.step("cni", func() error {
+		return p.configureCNI(engineOptions.CNI)
+	}); err != nil {
+		return err
+	}
+
+	access := kubeletConfig(p.KubeletConfig, engineOptions)
+	if err := p.step("kubeconfig", func() error {
+		return p.copyKubeconfig(access, engineOptions.KubeletCredentials, NodePaths(engineOptions).Kubeconfig)
+	}); err != nil {
+		return err
+	}
+
+	if err := p.step("apiserver-proxy", func() error {
+		service, err := initsystem.Get(engineOptions.InitSystem)
+		if err != nil {
+			return err
+		}
+		return p.configureAPIServerProxy(access, service)
+	}); err != nil {
+		return err
+	}
+
+	if err := p.step("dependencies", func() error {
+		return p.installDependencies(engineOptions.StaticBinaries, engineOptions.ArtifactMirrors)
+	}); err != nil {
+		return err
+	}
+
+	if err := p.step("artifacts", func() error {
+		return p.installArtifacts(engineOptions.ArtifactMirrors)
+	}); err != nil {
+		return err
+	}
+
+	if err := p.step("musl", p.installMuslCompat); err != nil {
+		return err
+	}
+
+	if err := p.step("ip-family", func() error {
+		if err := ipfamily.ValidateKubelet(engineOptions.IPFamily, InstalledKubeletVersion(engineOptions)); err != nil {
+			return err
+		}
+		return p.configureIPFamily(engineOptions.IPFamily)
+	}); err != nil {
+		return err
+	}
+
+	if err := p.step("hardening", func() error {
+		return p.harden(engineOptions.Hardening, NodePaths(engineOptions))
+	}); err != nil {
+		return err
+	}
+
+	var capacity *resources.Resources
+	if err := p.step("resources", func() error {
+		capacity = p.detectResources()
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := p.step("kubelet", func() error {
+		return p.installKubeletUnit(engineOptions, capacity)
+	}); err != nil {
+		return err
+	}
+
+	if err := p.step("heartbeat", func() error {
+		return p.installHeartbeat(engineOptions)
+	}); err != nil {
+		return err
+	}
+
+	p.recordState(engineOptions)
+	return nil
+}
+
+func (p *KubeletProvisionerWrapper) installKubeletUnit(engineOptions engine.Options, capacity *resources.Resources) error {
+	kubeletOptions := engineOptions
+	nodeIP, err := p.nodeIP(engineOptions)
+	if err != nil {
+		return err
+	}
+	kubeletOptions.NodeIP = nodeIP
+	unit, err := kubeletUnit(p.GetDriver().GetMachineName(), kubeletOptions, capacity, p.Templates)
+	if err != nil {
+		return err
+	}
+	service, err := initsystem.Get(engineOptions.InitSystem)
+	if err != nil {
+		return err
+	}
+	file, err := service.ServiceFile("kubelet", NodePaths(engineOptions).KubeletUnit, unit, 0600)
+	if err != nil {
+		return err
+	}
+	log.Infof("Copying %q to %q on the node...", "kubelet unit file", file.Path)
+	if err := p.scp(file.Content, file.Path, file.Mode); err != nil {
+		return err
+	}
+	out, err := p.Provisioner.SSHCommand(service.EnableCommand("kubelet"))
+	if err != nil {
+		return fmt.Errorf("Failed to enable kubelet (error: %v): %v", err, out)
+	}
+	return nil
+}
+
+// installHeartbeat installs the heartbeat agent with its own token, so it
+// keeps reporting when the kubelet or its credentials are broken.
+func (p *KubeletProvisionerWrapper) installHeartbeat(engineOptions engine.Options) error {
+	if !engineOptions.Heartbeat {
+		return nil
+	}
+	if p.Heartbeats == nil {
+		return fmt.Errorf("The heartbeat agent needs the cluster of the store")
+	}
+	name := p.GetDriver().GetMachineName()
+	cluster, token, err := p.Heartbeats.GrantHeartbeat(name)
+	if err != nil {
+		return err
+	}
+	service, err := initsystem.Get(engineOptions.InitSystem)
+	if err != nil {
+		return err
+	}
+	file, err := service.ServiceFile(heartbeat.Service, heartbeat.UnitPath, heartbeat.Unit, 0644)
+	if err != nil {
+		return err
+	}
+	if out, err := p.Provisioner.SSHCommand("sudo mkdir -p " + heartbeat.Dir); err != nil {
+		return fmt.Errorf("Failed to create %s (error: %v): %v", heartbeat.Dir, err, out)
+	}
+	for _, f := range []initsystem.File{
+		{Path: heartbeat.Dir + "/env", Content: []byte(heartbeat.Env(cluster.Server, nodeagent.Namespace, name)), Mode: 0600},
+		{Path: heartbeat.Dir + "/ca.crt", Content: cluster.CAData, Mode: 0644},
+		{Path: heartbeat.Dir + "/token", Content: []byte(token), Mode: 0600},
+		{Path: heartbeat.Dir + "/heartbeat.sh", Content: []byte(heartbeat.Script(heartbeat.DefaultInterval)), Mode: 0700},
+		file,
+	} {
+		log.Infof("Copying %q to the node...", f.Path)
+		if err := p.scp(f.Content, f.Path, f.Mode); err != nil {
+			return err
+		}
+	}
+	for _, command := range []string{service.EnableCommand(heartbeat.Service), service.RestartCommand(heartbeat.Service)} {
+		if out, err := p.Provisioner.SSHCommand(command); err != nil {
+			return fmt.Errorf("Failed to start the heartbeat agent (error: %v): %v", err, out)
+		}
+	}
+	return nil
+}
+
+// ConfigureKubeletTLS ships a serving certificate signed by the cluster CA
+// for the addresses of the node, installs the kubelet unit again, which
+// disables anonymous authentication, and restarts the kubelet.
+func (p *KubeletProvisionerWrapper) ConfigureKubeletTLS(engineOptions engine.Options) error {
+	if err := p.backupConfig("kubelet-tls", append(kubeletConfigFiles(engineOptions), kubeletCertDir)); err != nil {
+		return err
+	}
+	if err := p.step("kubelet-tls", func() error {
+		addresses := []string{p.GetDriver().GetMachineName()}
+		if ip, err := p.GetDriver().GetIP(); err == nil && ip != "" {
+			addresses = append(addresses, ip)
+		}
+		nodeIP, err := p.nodeIP(engineOptions)
+		if err != nil {
+			return err
+		}
+		for _, ip := range strings.Split(nodeIP, ",") {
+			if ip != "" {
+				addresses = append(addresses, ip)
+			}
+		}
+		cert, key, err := kubeletConfig(p.KubeletConfig, engineOptions).ServingCertificate(p.GetDriver().GetMachineName(), addresses)
+		if err != nil {
+			return err
+		}
+		if out, err := p.Provisioner.SSHCommand("sudo mkdir -p " + kubeletCertDir); err != nil {
+			return fmt.Errorf("Failed to prepare the kubelet certificate directory (error: %v): %v", err, out)
+		}
+		log.Infof("Copying the kubelet serving certificate to %q on the node...", kubeletCertDir)
+		if err := p.scp(cert, kubeletCertDir+"/kubelet.crt", 0644); err != nil {
+			return err
+		}
+		return p.scp(key, kubeletCertDir+"/kubelet.key", 0600)
+	}); err != nil {
+		return err
+	}
+	if err := p.step("kubelet", func() error {
+		return p.installKubeletUnit(engineOptions, p.detectResources())
+	}); err != nil {
+		return err
+	}
+	return p.step("kubelet-restart", func() error {
+		service, err := initsystem.Get(engineOptions.InitSystem)
+		if err != nil {
+			return err
+		}
+		out, err := p.Provisioner.SSHCommand(service.RestartCommand("kubelet"))
+		if err != nil {
+			return fmt.Errorf("Failed to restart kubelet (error: %v): %v", err, out)
+		}
+		return nil
+	})
+}
+
+// RestoreCredentials ships the kubeconfig of a node with the tmpfs credential
+// profile again once it is gone, e.g. after a reboot, and restarts the
+// kubelet. It returns whether the kubeconfig was restored.
+func (p *KubeletProvisionerWrapper) RestoreCredentials(engineOptions engine.Options) (bool, error) {
+	if engineOptions.KubeletCredentials != credentials.ProfileTmpfs {
+		return false, nil
+	}
+	if _, err := p.Provisioner.SSHCommand(credentials.PresentCommand); err == nil {
+		return false, nil
+	}
+	log.Infof("The kubelet kubeconfig of %s is gone, restoring it...", p.GetDriver().GetMachineName())
+	access := kubeletConfig(p.KubeletConfig, engineOptions)
+	if err := p.step("kubeconfig", func() error {
+		return p.copyKubeconfig(access, credentials.ProfileTmpfs, NodePaths(engineOptions).Kubeconfig)
+	}); err != nil {
+		return false, err
+	}
+	service, err := initsystem.Get(engineOptions.InitSystem)
+	if err != nil {
+		return false, err
+	}
+	return true, p.step("kubelet-restart", func() error {
+		out, err := p.Provisioner.SSHCommand(service.RestartCommand("kubelet"))
+		if err != nil {
+			return fmt.Errorf("Failed to restart kubelet (error: %v): %v", err, out)
+		}
+		return nil
+	})
+}
+
+// ReconfigureAPIServers ships a new kubeconfig and proxy configuration for
+// the current API server endpoints and restarts the kubelet.
+func (p *KubeletProvisionerWrapper) ReconfigureAPIServers(engineOptions engine.Options) error {
+	if err := p.backupConfig("rotate-api-server", append(kubeletConfigFiles(engineOptions), apiServerProxyFiles()...)); err != nil {
+		return err
+	}
+	access := kubeletConfig(p.KubeletConfig, engineOptions)
+	if err := p.step("kubeconfig", func() error {
+		profile, err := p.Provisioner.SSHCommand(credentials.ReadProfileCommand)
+		if err != nil {
+			return err
+		}
+		return p.copyKubeconfig(access, strings.TrimSpace(profile), NodePaths(engineOptions).Kubeconfig)
+	}); err != nil {
+		return err
+	}
+	service, err := initsystem.Get(engineOptions.InitSystem)
+	if err != nil {
+		return err
+	}
+	if err := p.step("apiserver-proxy", func() error {
+		return p.configureAPIServerProxy(access, service)
+	}); err != nil {
+		return err
+	}
+	return p.step("kubelet-restart", func() error {
+		out, err := p.Provisioner.SSHCommand(service.RestartCommand("kubelet"))
+		if err != nil {
+			return fmt.Errorf("Failed to restart kubelet (error: %v): %v", err, out)
+		}
+		return nil
+	})
+}
+
+// Generalize removes the identity of the machine so its disk can be used as
+// image for new machines.
+func (p *KubeletProvisionerWrapper) Generalize(engineOptions engine.Options) error {
+	service, err := initsystem.Get(engineOptions.InitSystem)
 	if err != nil {
 		return err
 	}
+	return p.step("generalize", func() error {
+		out, err := p.Provisioner.SSHCommand(generalizeCommand(NodePaths(engineOptions), service))
+		if err != nil {
+			return fmt.Errorf("Failed to generalize the machine (error: %v): %v", err, out)
+		}
+		return nil
+	})
+}
+
+// installMuslCompat lets the kubelet linked against glibc run on musl
+// nodes like Alpine.
+func (p *KubeletProvisionerWrapper) installMuslCompat() error {
+	var binaries []string
+	for _, a := range nodeArtifacts {
+		binaries = append(binaries, a.Path)
+	}
+	out, err := p.Provisioner.SSHCommand("sudo sh -c " + remote.Quote(nodedeps.MuslScript(binaries)))
+	if err != nil {
+		return fmt.Errorf("Failed to prepare the binaries for musl (error: %v): %v", err, out)
+	}
+	return nil
+}
+
+// installDependencies installs the commands the kubelet needs which are
+// missing on the node. The default socat is pushed from the cache to nodes
+// without package manager.
+func (p *KubeletProvisionerWrapper) installDependencies(staticBinaries, mirrors []string) error {
+	script, err := installDependencies(staticBinaries, mirrors)
+	if err != nil {
+		return err
+	}
+	if p.Artifacts != nil && !hasStaticBuild(staticBinaries, "socat") {
+		out, err := p.Provisioner.SSHCommand(nodedeps.SocatMissingScript())
+		if err != nil {
+			return fmt.Errorf("Failed to check for socat (error: %v): %v", err, out)
+		}
+		if strings.TrimSpace(out) == "yes" {
+			if err := p.pushArtifacts([]artifacts.Artifact{nodedeps.Socat}, mirrors); err != nil {
+				return err
+			}
+		}
+	}
+	out, err := p.Provisioner.SSHCommand("sudo sh -c " + script)
+	if err != nil {
+		return fmt.Errorf("Failed to install the kubelet dependencies (error: %v): %v", err, out)
+	}
+	return nil
+}
+
+// hasStaticBuild returns whether the static builds of the machine include
+// the binary.
+func hasStaticBuild(staticBinaries []string, name string) bool {
+	for _, s := range staticBinaries {
+		if strings.HasPrefix(s, name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// installArtifacts installs the binaries missing on the node once, pushing
+// them from the cache if there is one.
+func (p *KubeletProvisionerWrapper) installArtifacts(mirrors []string) error {
+	if err := p.pushArtifacts(nodeArtifacts, mirrors); err != nil {
+		return err
+	}
+	out, err := p.Provisioner.SSHCommand("sudo sh -c " + installBinaries(mirrors))
+	if err != nil {
+		return fmt.Errorf("Failed to install the node binaries (error: %v): %v", err, out)
+	}
+	return nil
+}
+
+// pushArtifacts copies the cached binaries missing on the node over SSH.
+func (p *KubeletProvisionerWrapper) pushArtifacts(binaries []artifacts.Artifact, mirrors []string) error {
+	if p.Artifacts == nil {
+		return nil
+	}
+	client, err := drivers.GetSSHClientFromDriver(p.GetDriver())
+	if err != nil {
+		return err
+	}
+	uploader, ok := client.(ssh.Uploader)
+	if !ok {
+		log.Warnf("The SSH client can't upload files, the node downloads the binaries itself")
+		return nil
+	}
+
+	for _, a := range binaries {
+		if _, err := remote.Run(p.Provisioner, "test", "-x", a.Path); err == nil {
+			continue
+		}
+
+		f, err := p.Artifacts.Open(a, mirrors)
+		if err != nil {
+			return err
+		}
+		log.Infof("Copying %s to %q on the node...", path.Base(a.URL), a.Path)
+		err = deploy.DeployFile(uploader, deploy.File{Path: a.Path, Mode: 0755}, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkCgroups refuses cgroup drivers the cgroup version of the node doesn't
+// support before anything is installed. OpenRC nodes get their cgroup
+// hierarchy mounted first.
+func (p *KubeletProvisionerWrapper) checkCgroups(engineOptions engine.Options) error {
+	if engineOptions.InitSystem == initsystem.OpenRC {
+		if out, err := p.Provisioner.SSHCommand(cgroups.OpenRCMountCommand); err != nil {
+			return fmt.Errorf("Failed to mount the cgroup hierarchy (error: %v): %v", err, out)
+		}
+	}
+	if out, err := p.Provisioner.SSHCommand(cgroups.MemoryControllerCommand); err == nil && strings.TrimSpace(out) == "0" {
+		log.Warnf("The memory cgroup controller of the node is disabled, boot it with cgroup_enable=memory for the kubelet to enforce memory limits")
+	}
+	out, err := p.Provisioner.SSHCommand(cgroups.DetectCommand)
+	if err != nil {
+		return fmt.Errorf("Failed to detect the cgroup version (error: %v): %v", err, out)
+	}
+	version, err := cgroups.ParseVersion(out)
+	if err != nil {
+		return err
+	}
+	log.Debugf("The node runs cgroup v%d", version)
+	if engineOptions.InitSystem != "" && engineOptions.InitSystem != initsystem.Systemd {
+		return cgroups.ValidateWithoutSystemd(cgroupDriver(engineOptions), engineOptions.InitSystem)
+	}
+	return cgroups.Validate(cgroupDriver(engineOptions), version)
+}
+
+// configureRegistries ships the pull credentials of the kubelet and the
+// containerd hosts of the mirrors and insecure registries, the engine flags
+// cover Docker. containerd reads the hosts if its registry config_path is
+// registries.ContainerdCertsDir. The files of a previous provisioning are
+// replaced, so changes are rolled out by provisioning again.
+func (p *KubeletProvisionerWrapper) configureRegistries(engineOptions engine.Options) error {
+	auths, err := registries.ParseAuths(engineOptions.RegistryAuth)
+	if err != nil {
+		return err
+	}
+	if len(auths) > 0 {
+		config, err := registries.DockerConfig(auths)
+		if err != nil {
+			return err
+		}
+		if _, err := p.Provisioner.SSHCommand("sudo mkdir -p " + path.Dir(registries.KubeletDockerConfigPath)); err != nil {
+			return err
+		}
+		log.Infof("Copying the credentials of %d registries to %q on the node...", len(auths), registries.KubeletDockerConfigPath)
+		if err := p.scp(config, registries.KubeletDockerConfigPath, 0600); err != nil {
+			return err
+		}
+	} else if _, err := p.Provisioner.SSHCommand("sudo rm -f " + registries.KubeletDockerConfigPath); err != nil {
+		return err
+	}
+
+	if out, err := p.Provisioner.SSHCommand(registries.RemoveManagedHostsCommand); err != nil {
+		return fmt.Errorf("Failed to remove the containerd hosts (error: %v): %v", err, out)
+	}
+	files := registries.HostsFiles(engineOptions.RegistryMirror, engineOptions.InsecureRegistry)
+	var paths []string
+	for hostsPath := range files {
+		paths = append(paths, hostsPath)
+	}
+	sort.Strings(paths)
+	for _, hostsPath := range paths {
+		if _, err := remote.Run(p.Provisioner, "sudo", "mkdir", "-p", path.Dir(hostsPath)); err != nil {
+			return err
+		}
+		if err := p.scp(files[hostsPath], hostsPath, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configureJournald limits the disk space of the journal, the distribution
+// default is kept without limit.
+func (p *KubeletProvisionerWrapper) configureJournald(maxUse, initSystem string) error {
+	if maxUse == "" {
+		return nil
+	}
+	if initSystem != "" && initSystem != initsystem.Systemd {
+		log.Warnf("The node runs %s, not journald, the journal limit %s is ignored", initSystem, maxUse)
+		return nil
+	}
+
+	if _, err := p.Provisioner.SSHCommand("sudo mkdir -p " + path.Dir(logrotate.JournaldConfigPath)); err != nil {
+		return err
+	}
+	log.Infof("Limiting the journal to %s...", maxUse)
+	if err := p.scp([]byte(logrotate.JournaldConfig(maxUse)), logrotate.JournaldConfigPath, 0644); err != nil {
+		return err
+	}
+	out, err := p.Provisioner.SSHCommand("sudo systemctl restart systemd-journald")
+	if err != nil {
+		return fmt.Errorf("Failed to restart journald (error: %v): %v", err, out)
+	}
+	return nil
+}
+
+// trustSSHCA makes sshd of the node accept the certificates of the SSH CA
+// and then revokes the key the machine was created with, once a session
+// with a certificate of the CA alone works.
+func (p *KubeletProvisionerWrapper) trustSSHCA() error {
+	if p.SSHCA == "" {
+		return nil
+	}
+	script, err := sshca.TrustScript(p.SSHCA)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Configuring sshd to trust the SSH CA...")
+	out, err := p.Provisioner.SSHCommand("sudo sh -c " + remote.Quote(script))
+	if err != nil {
+		return fmt.Errorf("Failed to configure sshd to trust the SSH CA (error: %v): %v", err, out)
+	}
+	return p.revokeStaticKey()
+}
+
+// revokeStaticKey removes the public key of the driver from the authorized
+// keys of the SSH user. The removal runs in a session authenticated by the
+// session key alone, so a node not accepting the certificates of the CA
+// keeps the key.
+func (p *KubeletProvisionerWrapper) revokeStaticKey() error {
+	d := p.GetDriver()
+	if drivers.SessionKey == nil || d.GetSSHKeyPath() == "" {
+		return nil
+	}
+	publicKey, err := ioutil.ReadFile(d.GetSSHKeyPath() + ".pub")
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	script, err := sshca.RevokeKeyScript(string(publicKey))
+	if err != nil {
+		return err
+	}
+	sessionKey, err := drivers.SessionKey(d)
+	if err != nil {
+		return err
+	}
+	address, err := d.GetSSHHostname()
+	if err != nil {
+		return err
+	}
+	port, err := d.GetSSHPort()
+	if err != nil {
+		return err
+	}
+	client, err := ssh.NewClientContext(drivers.Context(d), d.GetSSHUsername(), address, port, &ssh.Auth{Keys: []string{sessionKey}})
+	if err != nil {
+		return err
+	}
+
+	log.Info("Revoking the SSH key of the machine...")
+	out, err := client.Output("sh -c " + remote.Quote(script))
+	if err != nil {
+		return fmt.Errorf("Failed to revoke the SSH key of the machine with a certificate of the SSH CA (error: %v): %v", err, out)
+	}
+	return nil
+}
+
+// configureFirewall replaces the firewall rules of the node with rules only
+// accepting SSH, the engine, the kubelet, the node ports and the ports of the
+// CNI, which defaults to the CNI profile. The firewall is left alone if none
+// is configured.
+func (p *KubeletProvisionerWrapper) configureFirewall(engineOptions engine.Options) error {
+	if engineOptions.Firewall == "" {
+		return nil
+	}
+
+	d := p.GetDriver()
+	sshPort, err := d.GetSSHPort()
+	if err != nil {
+		return err
+	}
+	enginePort := engine.DefaultPort
+	if u, err := d.GetURL(); err == nil {
+		if parsed, err := url.Parse(u); err == nil {
+			if _, port, err := net.SplitHostPort(parsed.Host); err == nil {
+				if n, err := strconv.Atoi(port); err == nil {
+					enginePort = n
+				}
+			}
+		}
+	}
+	network := engineOptions.FirewallCNI
+	if network == "" {
+		network = engineOptions.CNI
+	}
+	ports, err := firewall.Ports(sshPort, enginePort, network, engineOptions.FirewallPorts)
+	if err != nil {
+		return err
+	}
+	service, err := initsystem.Get(engineOptions.InitSystem)
+	if err != nil {
+		return err
+	}
+	script, err := firewall.Script(engineOptions.Firewall, ports, service)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Configuring %s to accept %d port ranges and protocols...", engineOptions.Firewall, len(ports))
+	out, err := p.Provisioner.SSHCommand(script)
+	if err != nil {
+		return fmt.Errorf("Failed to configure %s (error: %v): %v", engineOptions.Firewall, err, out)
+	}
+	return nil
+}
+
+// staticIPLog is the output of configuring the static address in the
+// background.
+const staticIPLog = "/var/log/kube-machine-static-ip.log"
+
+func staticLease(engineOptions engine.Options) ipam.Lease {
+	return ipam.Lease{Address: engineOptions.StaticAddress, Gateway: engineOptions.StaticGateway, DNS: engineOptions.StaticDNS}
+}
+
+// configureStaticIP configures the address leased by the IPAM on the
+// interface of the default route with netplan or NetworkManager. Nodes
+// without static address keep their DHCP configuration. The DHCP address
+// the machine is reached at goes away, so the address is changed in the
+// background and the driver is pointed to the static address, which the
+// rest of the provisioning connects to.
+func (p *KubeletProvisionerWrapper) configureStaticIP(engineOptions engine.Options) error {
+	if engineOptions.StaticAddress == "" {
+		return nil
+	}
+	lease := staticLease(engineOptions)
+	script, err := ipam.Script(lease)
+	if err != nil {
+		return err
+	}
+
+	d := p.Provisioner.GetDriver()
+	if ip, err := d.GetIP(); err == nil && ip == lease.IP() {
+		log.Infof("Configuring static address %s...", engineOptions.StaticAddress)
+		out, err := p.Provisioner.SSHCommand("sudo sh -c " + remote.Quote(script))
+		if err != nil {
+			return fmt.Errorf("Failed to configure static address %s (error: %v): %v", engineOptions.StaticAddress, err, out)
+		}
+		return nil
+	}
+
+	raw, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	if raw, err = ipchange.SetIPAddress(raw, lease.IP()); err != nil {
+		return fmt.Errorf("Failed to configure static address %s, the %s driver doesn't store the address of the machine: %v", engineOptions.StaticAddress, d.DriverName(), err)
+	}
+
+	log.Infof("Moving the machine to static address %s...", engineOptions.StaticAddress)
+	background := fmt.Sprintf("nohup sh -c %s >%s 2>&1 </dev/null &", remote.Quote("sleep 2; "+script), staticIPLog)
+	if out, err := p.Provisioner.SSHCommand("sudo sh -c " + remote.Quote(background)); err != nil {
+		return fmt.Errorf("Failed to configure static address %s (error: %v): %v", engineOptions.StaticAddress, err, out)
+	}
+	if err := json.Unmarshal(raw, d); err != nil {
+		return fmt.Errorf("Failed to update the driver config: %v", err)
+	}
+	if err := drivers.WaitForSSH(d); err != nil {
+		return fmt.Errorf("The machine isn't reachable at its static address %s, see %s on the machine: %v", engineOptions.StaticAddress, staticIPLog, err)
+	}
+	return nil
+}
+
+// detectResources detects the capacity of the machine and records it in the
+// resource store if there is one. Machines whose capacity can't be detected
+// keep the kubelet defaults.
+func (p *KubeletProvisionerWrapper) detectResources() *resources.Resources {
+	out, err := p.Provisioner.SSHCommand(resources.DetectCommand)
+	if err != nil {
+		log.Warnf("Failed to detect the resources of the machine (error: %v): %v", err, out)
+		return nil
+	}
+	capacity, err := resources.Parse(out)
+	if err != nil {
+		log.Warnf("%v", err)
+		return nil
+	}
+	log.Infof("Detected %s", capacity)
+	if p.Resources != nil {
+		if err := p.Resources.SetResources(p.GetDriver().GetMachineName(), &capacity); err != nil {
+			log.Warnf("Failed to record the resources of the machine: %v", err)
+		}
+	}
+	return &capacity
+}
 
-	data, err := ioutil.ReadFile(p.KubeconfigPath)
+// harden applies the hardening profile before the kubelet is installed, as
+// it refuses to start with --protect-kernel-defaults if the kernel settings
+// differ.
+func (p *KubeletProvisionerWrapper) harden(profile string, paths nodepaths.Paths) error {
+	script, err := hardening.Script(profile, p.GetDriver().GetSSHUsername(), paths)
+	if err != nil || script == "" {
+		return err
+	}
+	log.Infof("Applying the %s hardening profile...", profile)
+	out, err := p.Provisioner.SSHCommand("sudo sh -c " + remote.Quote(script))
 	if err != nil {
+		return fmt.Errorf("Failed to apply the %s hardening profile (error: %v): %v", profile, err, out)
+	}
+	return nil
+}
+
+// configureIPFamily enables IPv6 forwarding on IPv6 and dual-stack nodes.
+func (p *KubeletProvisionerWrapper) configureIPFamily(family string) error {
+	sysctls := ipfamily.Sysctls(family)
+	if sysctls == "" {
+		return nil
+	}
+	if _, err := remote.Run(p.Provisioner, "sudo", "mkdir", "-p", path.Dir(ipfamily.SysctlPath)); err != nil {
+		return err
+	}
+	if err := p.scp([]byte(sysctls), ipfamily.SysctlPath, 0644); err != nil {
 		return err
 	}
+	if out, err := remote.Run(p.Provisioner, "sudo", "sysctl", "-q", "-p", ipfamily.SysctlPath); err != nil {
+		return fmt.Errorf("Failed to enable IPv6 forwarding (error: %v): %v", err, out)
+	}
+	return nil
+}
+
+// nodeIP returns the --node-ip of the kubelet: the configured one, or the
+// addresses of the node of its family on IPv6 and dual-stack nodes. The
+// kubelet picks the address of IPv4 nodes itself unless it is static.
+func (p *KubeletProvisionerWrapper) nodeIP(engineOptions engine.Options) (string, error) {
+	if engineOptions.NodeIP != "" {
+		return engineOptions.NodeIP, nil
+	}
+	if engineOptions.IPFamily != ipfamily.IPv6 && engineOptions.IPFamily != ipfamily.DualStack {
+		if engineOptions.StaticAddress != "" {
+			return staticLease(engineOptions).IP(), nil
+		}
+		return "", nil
+	}
+	out, err := p.Provisioner.SSHCommand(ipfamily.AddressesCommand)
+	if err != nil {
+		return "", fmt.Errorf("Failed to list the addresses of the node (error: %v): %v", err, out)
+	}
+	ips, err := ipfamily.NodeIPs(ipfamily.ParseAddresses(out), engineOptions.IPFamily)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(ips, ","), nil
+}
 
-	log.Infof("Copying %q to %q on the node...", p.KubeconfigPath, nodeKubeconfigPath)
-	err = p.scp(data, nodeKubeconfigPath, "0600")
+// configureCNI loads the kernel modules and sets the sysctls the network
+// plugin of the CNI profile needs, nodes without profile are left alone.
+func (p *KubeletProvisionerWrapper) configureCNI(name string) error {
+	if name == "" {
+		return nil
+	}
+	network, err := cni.Get(name)
+	if err != nil {
+		return err
+	}
+	script, err := network.Script()
 	if err != nil {
 		return err
 	}
 
-	log.Infof("Copying %q to %q on the node...", "kubelet unit file", kubeletUnitPath)
-	err = p.scp([]byte(kubeletUnitFile), kubeletUnitPath, "0600")
+	log.Infof("Preparing the node for %s...", name)
+	out, err := p.Provisioner.SSHCommand("sudo sh -c " + remote.Quote(script))
+	if err != nil {
+		return fmt.Errorf("Failed to prepare the node for %s (error: %v): %v", name, err, out)
+	}
+	return nil
+}
+
+// copyKubeconfig ships the kubelet kubeconfig of the cluster access and keeps
+// it on the node as the credential profile defines, on disk at diskPath.
+func (p *KubeletProvisionerWrapper) copyKubeconfig(access KubeletConfig, profile, diskPath string) error {
+	data, err := access.Kubeconfig(p.GetDriver().GetMachineName())
 	if err != nil {
 		return err
 	}
 
+	if out, err := p.Provisioner.SSHCommand(credentials.PrepareCommand(profile, diskPath)); err != nil {
+		return fmt.Errorf("Failed to prepare the kubeconfig directory (error: %v): %v", err, out)
+	}
+	uploadPath := credentials.UploadPath(profile, diskPath)
+	log.Infof("Copying the kubelet kubeconfig to %q on the node...", uploadPath)
+	if err := p.scp(data, uploadPath, 0600); err != nil {
+		return err
+	}
+	out, err := p.Provisioner.SSHCommand(credentials.InstallCommand(profile, diskPath))
+	if err != nil {
+		return fmt.Errorf("Failed to install the kubeconfig (error: %v): %v", err, out)
+	}
 	return nil
 }
 
-func (p *KubeletProvisionerWrapper) scp(data []byte, path string, chmod string) error {
-	data64 := base64.StdEncoding.EncodeToString(data)
+// step runs a provisioning step and records its duration. An aborted step
+// leaves its SSH commands running on the node.
+func (p *KubeletProvisionerWrapper) step(name string, f func() error) error {
+	logger := log.WithFields(log.Fields{"machine": p.GetDriver().GetMachineName(), "step": name})
+	logger.Debugf("Starting provisioning step %s", name)
 
-	ctx := struct {
-		Path, Data64, Chmod string
-	}{
-		Path:   nodeKubeconfigPath,
-		Data64: data64,
-		Chmod:  chmod,
+	_, span := tracing.StartContext(p.Context, "provision."+name, "machine", p.GetDriver().GetMachineName())
+	start := time.Now()
+	err := deadline.RunTimeout(p.Context, p.StepTimeout, "Provisioning step "+name, f)
+	span.End(err)
+	metrics.ProvisioningStepDuration.ObserveSince(start, p.GetDriver().DriverName(), name, metrics.Result(err))
+	oplog.FromContext(p.Context).RecordStep(p.GetDriver().GetMachineName(), name, start, err)
+
+	if err != nil {
+		logger.Debugf("Provisioning step %s failed after %v: %v", name, time.Since(start), err)
+	} else {
+		logger.Debugf("Provisioning step %s finished after %v", name, time.Since(start))
+	}
+	return err
+}
+
+// mountVolumes formats and mounts the additional volumes created by the
+// driver, e.g. dedicated disks for /var/lib/kubelet.
+func (p *KubeletProvisionerWrapper) mountVolumes() error {
+	vols, err := volumes.FromDriver(p.GetDriver())
+	if err != nil {
+		return err
+	}
+	if len(vols) == 0 {
+		return nil
 	}
-	cmd := &bytes.Buffer{}
-	cmdTmpl := template.New(`touch {{.Path}} && chmod {{.Chmod}} {{.Path}} && echo "{{.Data64}}" | base64 -d >> {{.Path}}`)
-	err := cmdTmpl.Execute(cmd, ctx)
+
+	cmd, err := volumes.MountCommand(vols)
 	if err != nil {
 		return err
 	}
-	out, err := p.Provisioner.SSHCommand(cmd.String())
+	for _, v := range vols {
+		log.Infof("Mounting %s to %s on the node...", v.Device, v.MountPath)
+	}
+	out, err := p.Provisioner.SSHCommand(cmd)
 	if err != nil {
-		return fmt.Errorf("Failed to run SSH command (error: %v): %v", err, out)
+		return fmt.Errorf("Failed to mount volumes (error: %v): %v", err, out)
 	}
 	return nil
 }
+
+func (p *KubeletProvisionerWrapper) scp(data []byte, path string, mode os.FileMode) error {
+	return remote.WriteFile(p.Provisioner, path, data, mode)
+}
@@ -0,0 +1,140 @@
+package detector
+
+import (
+	"github.com/docker/machine/libmachine/engine"
+	"github.com/docker/machine/libmachine/log"
+	"github.com/kubermatic/kube-machine/pkg/artifacts"
+	"github.com/kubermatic/kube-machine/pkg/cni"
+	"github.com/kubermatic/kube-machine/pkg/drift"
+	"github.com/kubermatic/kube-machine/pkg/firewall"
+	"github.com/kubermatic/kube-machine/pkg/hardening"
+	"github.com/kubermatic/kube-machine/pkg/initsystem"
+	"github.com/kubermatic/kube-machine/pkg/ipam"
+	"github.com/kubermatic/kube-machine/pkg/ipfamily"
+	"github.com/kubermatic/kube-machine/pkg/logrotate"
+	"github.com/kubermatic/kube-machine/pkg/registries"
+	"github.com/kubermatic/kube-machine/pkg/sshca"
+	"github.com/kubermatic/kube-machine/pkg/templates"
+)
+
+// StateStore records the hash of the artifacts machines were provisioned
+// with.
+type StateStore interface {
+	SetProvisionedState(name, hash string) error
+}
+
+// DesiredState returns the hash of the unit files, configurations, scripts
+// and versions provisioning renders for the machine from its engine options.
+// What is detected on the node, like its capacity and addresses, and the
+// cluster credentials are left out, so the hash only changes with the
+// options of the machine, its templates and kube-machine itself.
+func DesiredState(nodeName string, engineOptions engine.Options, sshCA string, lookup templates.Lookup) (string, error) {
+	rendered := map[string][]byte{
+		"kubelet-version":     []byte(KubeletVersion),
+		"kubelet-credentials": []byte(engineOptions.KubeletCredentials),
+		"artifacts":           []byte(artifacts.InstallScript(nodeArtifacts, engineOptions.ArtifactMirrors)),
+	}
+
+	unit, err := kubeletUnit(nodeName, engineOptions, nil, lookup)
+	if err != nil {
+		return "", err
+	}
+	rendered[kubeletUnitName] = []byte(unit)
+
+	dependencies, err := installDependencies(engineOptions.StaticBinaries, engineOptions.ArtifactMirrors)
+	if err != nil {
+		return "", err
+	}
+	rendered["dependencies"] = []byte(dependencies)
+
+	auths, err := registries.ParseAuths(engineOptions.RegistryAuth)
+	if err != nil {
+		return "", err
+	}
+	if len(auths) > 0 {
+		if rendered[registries.KubeletDockerConfigPath], err = registries.DockerConfig(auths); err != nil {
+			return "", err
+		}
+	}
+	for hostsPath, data := range registries.HostsFiles(engineOptions.RegistryMirror, engineOptions.InsecureRegistry) {
+		rendered[hostsPath] = data
+	}
+
+	if engineOptions.JournaldMaxUse != "" {
+		rendered[logrotate.JournaldConfigPath] = []byte(logrotate.JournaldConfig(engineOptions.JournaldMaxUse))
+	}
+	if sshCA != "" {
+		script, err := sshca.TrustScript(sshCA)
+		if err != nil {
+			return "", err
+		}
+		rendered["ssh-ca"] = []byte(script)
+	}
+	if engineOptions.Firewall != "" {
+		network := engineOptions.FirewallCNI
+		if network == "" {
+			network = engineOptions.CNI
+		}
+		// The SSH and engine ports of the node are detected from the
+		// driver, the defaults stand in for them.
+		ports, err := firewall.Ports(22, engine.DefaultPort, network, engineOptions.FirewallPorts)
+		if err != nil {
+			return "", err
+		}
+		service, err := initsystem.Get(engineOptions.InitSystem)
+		if err != nil {
+			return "", err
+		}
+		script, err := firewall.Script(engineOptions.Firewall, ports, service)
+		if err != nil {
+			return "", err
+		}
+		rendered["firewall"] = []byte(script)
+	}
+	if engineOptions.StaticAddress != "" {
+		script, err := ipam.Script(staticLease(engineOptions))
+		if err != nil {
+			return "", err
+		}
+		rendered["static-ip"] = []byte(script)
+	}
+	if engineOptions.CNI != "" {
+		network, err := cni.Get(engineOptions.CNI)
+		if err != nil {
+			return "", err
+		}
+		script, err := network.Script()
+		if err != nil {
+			return "", err
+		}
+		rendered["cni"] = []byte(script)
+	}
+	if sysctls := ipfamily.Sysctls(engineOptions.IPFamily); sysctls != "" {
+		rendered[ipfamily.SysctlPath] = []byte(sysctls)
+	}
+	if engineOptions.Hardening != "" {
+		script, err := hardening.Script(engineOptions.Hardening, "", NodePaths(engineOptions))
+		if err != nil {
+			return "", err
+		}
+		rendered["hardening"] = []byte(script)
+	}
+
+	return drift.Hash(rendered), nil
+}
+
+// recordState records the hash of the artifacts the machine was provisioned
+// with in the state store if there is one.
+func (p *KubeletProvisionerWrapper) recordState(engineOptions engine.Options) {
+	if p.States == nil {
+		return
+	}
+	name := p.GetDriver().GetMachineName()
+	hash, err := DesiredState(name, engineOptions, p.SSHCA, p.Templates)
+	if err == nil {
+		err = p.States.SetProvisionedState(name, hash)
+	}
+	if err != nil {
+		log.Warnf("Failed to record the provisioned state of the machine: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package detector
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/docker/machine/libmachine/engine"
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/provision/pkgaction"
+	"github.com/docker/machine/libmachine/provision/serviceaction"
+	"github.com/kubermatic/kube-machine/pkg/drain"
+	"github.com/kubermatic/kube-machine/pkg/initsystem"
+	"github.com/kubermatic/kube-machine/pkg/nodestore"
+	"github.com/kubermatic/kube-machine/pkg/patching"
+	"github.com/kubermatic/kube-machine/pkg/remote"
+	"github.com/kubermatic/kube-machine/pkg/rollback"
+)
+
+// RollbackStore marks machines whose upgrade was rolled back.
+type RollbackStore interface {
+	SetUpgradeRollback(name string, r *rollback.Rollback) error
+}
+
+// upgradeFiles are the files an upgrade replaces: the kubelet binary, its
+// unit or init script and kubeconfig and the files of Docker.
+func upgradeFiles(engineOptions engine.Options) []string {
+	files := append([]string{kubeletPath}, kubeletConfigFiles(engineOptions)...)
+	return append(files, rollback.DockerFiles...)
+}
+
+// Package releases the hold of an interrupted patch run on the docker
+// packages before they are upgraded.
+func (p *KubeletProvisionerWrapper) Package(name string, action pkgaction.PackageAction) error {
+	if action == pkgaction.Upgrade {
+		p.releaseDockerHold()
+	}
+	return p.Provisioner.Package(name, action)
+}
+
+func (p *KubeletProvisionerWrapper) releaseDockerHold() {
+	if out, err := p.Provisioner.SSHCommand(patching.UnholdCommand); err != nil {
+		log.Warnf("Failed to release the hold on the docker packages of %s (error: %v): %v", p.GetDriver().GetMachineName(), err, out)
+	}
+}
+
+// UpgradeKubelet upgrades Docker and installs the kubelet of this version of
+// kube-machine and its unit. If the node doesn't report ready within the
+// UpgradeTimeout, the previous Docker and kubelet are restored from the
+// backup taken before and the machine is marked rollback.Phase.
+func (p *KubeletProvisionerWrapper) UpgradeKubelet(engineOptions engine.Options) error {
+	name := p.GetDriver().GetMachineName()
+	service, err := initsystem.Get(engineOptions.InitSystem)
+	if err != nil {
+		return err
+	}
+	if err := p.backupConfig("upgrade", kubeletConfigFiles(engineOptions)); err != nil {
+		return err
+	}
+	files := upgradeFiles(engineOptions)
+	if err := p.step("kubelet-backup", func() error {
+		return p.runAsRoot(rollback.BackupScript(files))
+	}); err != nil {
+		return err
+	}
+
+	var heartbeat time.Time
+	upgradeErr := p.step("docker-upgrade", func() error {
+		log.Info("Upgrading docker...")
+		if err := p.Package("docker", pkgaction.Upgrade); err != nil {
+			return err
+		}
+		return p.Service("docker", serviceaction.Restart)
+	})
+	if upgradeErr == nil {
+		upgradeErr = p.step("kubelet-upgrade", func() error {
+			if out, err := p.Provisioner.SSHCommand("sudo rm -f " + kubeletPath); err != nil {
+				return fmt.Errorf("Failed to remove the previous kubelet (error: %v): %v", err, out)
+			}
+			if err := p.installArtifacts(engineOptions.ArtifactMirrors); err != nil {
+				return err
+			}
+			if err := p.installKubeletUnit(engineOptions, p.detectResources()); err != nil {
+				return err
+			}
+			var err error
+			heartbeat, err = p.restartKubeletHeartbeat(name, service)
+			return err
+		})
+	}
+	if upgradeErr == nil {
+		upgradeErr = p.step("kubelet-ready", func() error {
+			return p.waitReady(name, heartbeat)
+		})
+	}
+	if upgradeErr == nil {
+		if out, err := p.Provisioner.SSHCommand("sudo " + rollback.CleanScript()); err != nil {
+			log.Warnf("Failed to remove the upgrade backup of %s (error: %v): %v", name, err, out)
+		}
+		p.recordState(engineOptions)
+		p.markRollback(name, nil)
+		return nil
+	}
+
+	log.Warnf("The upgrade of %s failed, rolling back to the previous docker and kubelet: %v", name, upgradeErr)
+	if err := p.step("kubelet-rollback", func() error {
+		p.releaseDockerHold()
+		if err := p.runAsRoot(rollback.RestoreScript()); err != nil {
+			return err
+		}
+		if err := p.Service("docker", serviceaction.Restart); err != nil {
+			return fmt.Errorf("Failed to restart docker: %v", err)
+		}
+		var err error
+		heartbeat, err = p.restartKubeletHeartbeat(name, service)
+		return err
+	}); err != nil {
+		return fmt.Errorf("The upgrade failed (%v) and so did the rollback: %v", upgradeErr, err)
+	}
+	if err := p.step("kubelet-ready", func() error {
+		return p.waitReady(name, heartbeat)
+	}); err != nil {
+		return fmt.Errorf("The upgrade failed (%v), the node is not ready after the rollback either: %v", upgradeErr, err)
+	}
+	p.markRollback(name, &rollback.Rollback{Reason: upgradeErr.Error(), Since: time.Now()})
+	return &rollback.Error{Cause: upgradeErr}
+}
+
+func (p *KubeletProvisionerWrapper) runAsRoot(script string, err error) error {
+	if err != nil {
+		return err
+	}
+	if out, err := p.Provisioner.SSHCommand("sudo sh -c " + remote.Quote(script)); err != nil {
+		return fmt.Errorf("%v: %v", err, out)
+	}
+	return nil
+}
+
+func (p *KubeletProvisionerWrapper) restartKubelet(service initsystem.InitSystem) error {
+	out, err := p.Provisioner.SSHCommand(service.RestartCommand("kubelet"))
+	if err != nil {
+		return fmt.Errorf("Failed to restart kubelet (error: %v): %v", err, out)
+	}
+	return nil
+}
+
+// restartKubeletHeartbeat restarts the kubelet and returns the last
+// heartbeat of the node once the previous kubelet stopped, only later
+// heartbeats come from the restarted one.
+func (p *KubeletProvisionerWrapper) restartKubeletHeartbeat(name string, service initsystem.InitSystem) (time.Time, error) {
+	if err := p.restartKubelet(service); err != nil {
+		return time.Time{}, err
+	}
+	client, err := nodestore.NewClient(p.Kubeconfig)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return drain.Heartbeat(client, name)
+}
+
+// waitReady waits until the node reported ready with a heartbeat after the
+// given one of the previous kubelet.
+func (p *KubeletProvisionerWrapper) waitReady(name string, heartbeat time.Time) error {
+	timeout := p.UpgradeTimeout
+	if timeout <= 0 {
+		timeout = rollback.DefaultTimeout
+	}
+	client, err := nodestore.NewClient(p.Kubeconfig)
+	if err != nil {
+		return err
+	}
+	log.Infof("Waiting for node %s to report ready...", name)
+	return drain.WaitForHeartbeat(client, name, heartbeat, timeout)
+}
+
+func (p *KubeletProvisionerWrapper) markRollback(name string, r *rollback.Rollback) {
+	if p.Rollbacks == nil {
+		return
+	}
+	if err := p.Rollbacks.SetUpgradeRollback(name, r); err != nil {
+		log.Warnf("Failed to record the upgrade result of %s: %v", name, err)
+	}
+}
@@ -0,0 +1,82 @@
+package recording
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Destination keeps the recordings, either a directory or an HTTP(S) URL
+// prefix they are uploaded to with PUT, e.g. of an object store.
+type Destination struct {
+	Dir string
+	URL string
+}
+
+// ParseDestination parses a directory or an http(s) URL.
+func ParseDestination(s string) (Destination, error) {
+	if !strings.HasPrefix(s, "http://") && !strings.HasPrefix(s, "https://") {
+		if s == "" {
+			return Destination{}, fmt.Errorf("Invalid recording destination, expected a directory or an http(s) URL")
+		}
+		return Destination{Dir: s}, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return Destination{}, fmt.Errorf("Invalid recording destination %q, expected an http(s) URL without query", s)
+	}
+	return Destination{URL: strings.TrimSuffix(s, "/")}, nil
+}
+
+// Create creates the file the session is recorded to, uploaded recordings
+// are kept in a temporary file until the session ends.
+func (d Destination) Create(name string) (*os.File, error) {
+	if d.URL != "" {
+		return ioutil.TempFile("", "kube-machine-recording")
+	}
+	if err := os.MkdirAll(d.Dir, 0700); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(filepath.Join(d.Dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+}
+
+// Finish stores the closed recording and returns its location. Uploaded
+// recordings are removed locally, unless the upload fails.
+func (d Destination) Finish(path, name string) (string, error) {
+	if d.URL == "" {
+		return path, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	location := d.URL + "/" + url.QueryEscape(name)
+	req, err := http.NewRequest("PUT", location, f)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := (&http.Client{Timeout: 5 * time.Minute}).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Failed to upload the recording: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("Failed to upload the recording to %s: %s", location, resp.Status)
+	}
+	os.Remove(path)
+	return location, nil
+}
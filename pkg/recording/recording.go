@@ -0,0 +1,158 @@
+package recording
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// The formats of the recordings.
+const (
+	// FormatAsciicast records asciicast v2, which asciinema plays back.
+	FormatAsciicast = "asciicast"
+	// FormatTypescript records the raw output like script(1).
+	FormatTypescript = "typescript"
+)
+
+// MaxSessions is the number of SSH sessions kept per machine.
+const MaxSessions = 20
+
+// Session notes who connected to a machine over SSH and when.
+type Session struct {
+	User            string    `json:"user"`
+	Start           time.Time `json:"start"`
+	DurationSeconds float64   `json:"durationSeconds"`
+	Command         string    `json:"command,omitempty"`
+	// Recording is where the session was recorded to.
+	Recording string `json:"recording,omitempty"`
+}
+
+// Append adds the session, keeping the last MaxSessions.
+func Append(sessions []Session, s Session) []Session {
+	sessions = append(sessions, s)
+	if len(sessions) > MaxSessions {
+		sessions = sessions[len(sessions)-MaxSessions:]
+	}
+	return sessions
+}
+
+// ValidateFormat checks that the format is known.
+func ValidateFormat(format string) error {
+	if format != FormatAsciicast && format != FormatTypescript {
+		return fmt.Errorf("Unknown recording format %q, expected %s or %s", format, FormatAsciicast, FormatTypescript)
+	}
+	return nil
+}
+
+// FileName returns the name of the recording of a session to the machine.
+func FileName(machine, user string, start time.Time, format string) string {
+	ext := ".cast"
+	if format == FormatTypescript {
+		ext = ".typescript"
+	}
+	return fmt.Sprintf("%s-%s-%s%s", machine, user, start.UTC().Format("20060102T150405Z"), ext)
+}
+
+// Recorder writes the output of a session in a recording format.
+type Recorder struct {
+	w      io.Writer
+	format string
+	start  time.Time
+	now    func() time.Time
+
+	mu sync.Mutex
+	// pending holds an incomplete UTF-8 sequence at the end of the last
+	// write, asciicast events have to be valid UTF-8.
+	pending []byte
+}
+
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// New writes the header of the recording of a terminal of the size.
+func New(w io.Writer, format, title string, width, height int, start time.Time) (*Recorder, error) {
+	if err := ValidateFormat(format); err != nil {
+		return nil, err
+	}
+	r := &Recorder{w: w, format: format, start: start, now: time.Now}
+	if format == FormatTypescript {
+		_, err := fmt.Fprintf(w, "Script started on %s [%s]\n", start.Format(time.RFC3339), title)
+		return r, err
+	}
+	header, err := json.Marshal(asciicastHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: start.Unix(),
+		Title:     title,
+		Env:       map[string]string{"TERM": "xterm"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(append(header, '\n'))
+	return r, err
+}
+
+// Write records output of the session.
+func (r *Recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.format == FormatTypescript {
+		return r.w.Write(p)
+	}
+
+	data := append(r.pending, p...)
+	complete := len(data)
+	// Keep back a trailing incomplete rune for the next write.
+	for i := 1; i <= utf8.UTFMax && i <= len(data); i++ {
+		if utf8.RuneStart(data[len(data)-i]) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				complete = len(data) - i
+			}
+			break
+		}
+	}
+	r.pending = append([]byte(nil), data[complete:]...)
+	if err := r.event(data[:complete]); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (r *Recorder) event(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	elapsed := r.now().Sub(r.start).Seconds()
+	event, err := json.Marshal([]interface{}{elapsed, "o", string(data)})
+	if err != nil {
+		return err
+	}
+	_, err = r.w.Write(append(event, '\n'))
+	return err
+}
+
+// Close records the rest of the output and the end of the session.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.format == FormatTypescript {
+		_, err := fmt.Fprintf(r.w, "\nScript done on %s\n", r.now().Format(time.RFC3339))
+		return err
+	}
+	err := r.event(r.pending)
+	r.pending = nil
+	return err
+}
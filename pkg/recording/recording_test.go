@@ -0,0 +1,134 @@
+package recording
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAsciicast(t *testing.T) {
+	var buf bytes.Buffer
+	start := time.Unix(1488000000, 0)
+	r, err := New(&buf, FormatAsciicast, "node-1", 80, 24, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.now = func() time.Time { return start.Add(1500 * time.Millisecond) }
+
+	// The é is split between the writes, the incomplete € at the end is
+	// recorded as replacement characters.
+	r.Write([]byte("caf\xc3"))
+	r.Write([]byte("\xa9\r\n"))
+	r.Write([]byte("\xe2\x82"))
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"version":2,"width":80,"height":24,"timestamp":1488000000,"title":"node-1","env":{"TERM":"xterm"}}
+[1.5,"o","caf"]
+[1.5,"o","é\r\n"]
+[1.5,"o","��"]
+`
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}
+
+func TestTypescript(t *testing.T) {
+	var buf bytes.Buffer
+	start := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	r, err := New(&buf, FormatTypescript, "node-1", 80, 24, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.now = func() time.Time { return start.Add(time.Minute) }
+	r.Write([]byte("$ uptime\r\n"))
+	r.Close()
+
+	expected := "Script started on 2017-03-01T12:00:00Z [node-1]\n$ uptime\r\n\nScript done on 2017-03-01T12:01:00Z\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+
+	if _, err := New(&buf, "mp4", "node-1", 80, 24, start); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestAppend(t *testing.T) {
+	var sessions []Session
+	for i := 0; i < MaxSessions+5; i++ {
+		sessions = Append(sessions, Session{User: "alice", DurationSeconds: float64(i)})
+	}
+	if len(sessions) != MaxSessions || sessions[0].DurationSeconds != 5 {
+		t.Errorf("expected the last %d sessions, got %v", MaxSessions, sessions)
+	}
+}
+
+func TestDestination(t *testing.T) {
+	var uploaded []byte
+	var uploadPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		uploadPath = r.URL.Path
+		uploaded, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	d, err := ParseDestination(server.URL + "/recordings/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := d.Create("node-1.cast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("recorded")
+	f.Close()
+	location, err := d.Finish(f.Name(), "node-1.cast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location != server.URL+"/recordings/node-1.cast" || uploadPath != "/recordings/node-1.cast" || string(uploaded) != "recorded" {
+		t.Errorf("unexpected upload of %q to %s (%s)", uploaded, uploadPath, location)
+	}
+	if _, err := os.Stat(f.Name()); !os.IsNotExist(err) {
+		t.Error("expected the temporary recording to be removed")
+	}
+
+	dir, err := ioutil.TempDir("", "recordings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err = ParseDestination(filepath.Join(dir, "sessions"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err = d.Create("node-1.cast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if location, err := d.Finish(f.Name(), "node-1.cast"); err != nil || location != filepath.Join(dir, "sessions", "node-1.cast") {
+		t.Errorf("unexpected location %s (%v)", location, err)
+	}
+	if _, err := d.Create("node-1.cast"); err == nil {
+		t.Error("expected existing recordings not to be overwritten")
+	}
+
+	for _, invalid := range []string{"", "https://", "https://example.com/?a=b"} {
+		if _, err := ParseDestination(invalid); err == nil || !strings.Contains(err.Error(), "destination") {
+			t.Errorf("%q: expected an error, got %v", invalid, err)
+		}
+	}
+}
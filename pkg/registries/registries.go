@@ -0,0 +1,132 @@
+package registries
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+const (
+	// KubeletDockerConfigPath is the first location the kubelet looks up
+	// the pull credentials of images in.
+	KubeletDockerConfigPath = "/var/lib/kubelet/config.json"
+	// ContainerdCertsDir is the config_path of the containerd registry
+	// hosts.
+	ContainerdCertsDir = "/etc/containerd/certs.d"
+
+	managedHeader   = "# Managed by kube-machine"
+	dockerHubHost   = "docker.io"
+	dockerHubServer = "https://registry-1.docker.io"
+)
+
+// RemoveManagedHostsCommand removes the containerd hosts files written by
+// a previous provisioning, the files of other tools are kept.
+var RemoveManagedHostsCommand = fmt.Sprintf("sudo grep -l '^%s' %s/*/hosts.toml 2>/dev/null | xargs -r sudo rm -f", managedHeader, ContainerdCertsDir)
+
+// Auth is the pull credential of a registry. The password is read from a
+// file when provisioning, so rotated passwords are picked up on the next
+// provisioning and never stored with the machine.
+type Auth struct {
+	Registry     string
+	Username     string
+	PasswordFile string
+}
+
+func (a Auth) String() string {
+	return fmt.Sprintf("%s=%s:%s", a.Registry, a.Username, a.PasswordFile)
+}
+
+// ParseAuth parses an auth in the form registry=username:password-file.
+func ParseAuth(s string) (Auth, error) {
+	var a Auth
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return a, fmt.Errorf("Invalid registry auth %q, expected registry=username:password-file", s)
+	}
+	creds := strings.SplitN(kv[1], ":", 2)
+	if len(creds) != 2 {
+		return a, fmt.Errorf("Invalid registry auth %q, expected registry=username:password-file", s)
+	}
+	a = Auth{Registry: kv[0], Username: creds[0], PasswordFile: creds[1]}
+	if a.Registry == "" || a.Username == "" || a.PasswordFile == "" {
+		return a, fmt.Errorf("Invalid registry auth %q, expected registry=username:password-file", s)
+	}
+	return a, nil
+}
+
+// ParseAuths parses the auths of the engine options.
+func ParseAuths(auths []string) ([]Auth, error) {
+	var result []Auth
+	for _, s := range auths {
+		a, err := ParseAuth(s)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, a)
+	}
+	return result, nil
+}
+
+// DockerConfig returns the docker config.json with the credentials of the
+// registries, reading the password files.
+func DockerConfig(auths []Auth) ([]byte, error) {
+	type auth struct {
+		Auth string `json:"auth"`
+	}
+	config := struct {
+		Auths map[string]auth `json:"auths"`
+	}{map[string]auth{}}
+
+	for _, a := range auths {
+		password, err := ioutil.ReadFile(a.PasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read the password of registry %s: %v", a.Registry, err)
+		}
+		creds := a.Username + ":" + strings.TrimRight(string(password), "\r\n")
+		config.Auths[a.Registry] = auth{base64.StdEncoding.EncodeToString([]byte(creds))}
+	}
+	return json.MarshalIndent(config, "", "  ")
+}
+
+// HostsFiles returns the containerd hosts files of the mirrors of Docker
+// Hub and the insecure registries by path. Insecure CIDRs of the Docker
+// engine have no containerd equivalent and are skipped.
+func HostsFiles(mirrors, insecure []string) map[string][]byte {
+	files := map[string][]byte{}
+	if len(mirrors) > 0 {
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "%s\nserver = %q\n", managedHeader, dockerHubServer)
+		for _, m := range mirrors {
+			fmt.Fprintf(&b, "\n[host.%q]\n  capabilities = [\"pull\", \"resolve\"]\n", withScheme(m))
+		}
+		files[hostsPath(dockerHubHost)] = b.Bytes()
+	}
+
+	for _, r := range insecure {
+		host := strings.TrimPrefix(strings.TrimPrefix(r, "https://"), "http://")
+		if strings.Contains(host, "/") {
+			continue
+		}
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "%s\nserver = %q\n", managedHeader, "https://"+host)
+		fmt.Fprintf(&b, "\n[host.%q]\n  capabilities = [\"pull\", \"resolve\", \"push\"]\n  skip_verify = true\n", "https://"+host)
+		fmt.Fprintf(&b, "\n[host.%q]\n  capabilities = [\"pull\", \"resolve\", \"push\"]\n", "http://"+host)
+		files[hostsPath(host)] = b.Bytes()
+	}
+	return files
+}
+
+func hostsPath(host string) string {
+	return path.Join(ContainerdCertsDir, host, "hosts.toml")
+}
+
+func withScheme(url string) string {
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		return url
+	}
+	return "https://" + url
+}
@@ -0,0 +1,80 @@
+package registries
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseAuth(t *testing.T) {
+	a, err := ParseAuth("registry.example.com:5000=puller:/etc/kube-machine/registry-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Auth{Registry: "registry.example.com:5000", Username: "puller", PasswordFile: "/etc/kube-machine/registry-password"}
+	if a != expected {
+		t.Errorf("expected %+v, got %+v", expected, a)
+	}
+	if a.String() != "registry.example.com:5000=puller:/etc/kube-machine/registry-password" {
+		t.Errorf("unexpected string %s", a)
+	}
+
+	for _, s := range []string{"", "registry", "registry=puller", "=puller:/file", "registry=:/file"} {
+		if _, err := ParseAuth(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestDockerConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	passwordFile := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(passwordFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := DockerConfig([]Auth{{Registry: "quay.io", Username: "bot", PasswordFile: passwordFile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	creds, _ := base64.StdEncoding.DecodeString(config.Auths["quay.io"].Auth)
+	if string(creds) != "bot:s3cret" {
+		t.Errorf("unexpected credentials %q", creds)
+	}
+
+	if _, err := DockerConfig([]Auth{{Registry: "quay.io", Username: "bot", PasswordFile: filepath.Join(dir, "missing")}}); err == nil {
+		t.Error("expected an error for a missing password file")
+	}
+}
+
+func TestHostsFiles(t *testing.T) {
+	files := HostsFiles([]string{"mirror.example.com"}, []string{"registry.local:5000", "10.0.0.0/8"})
+	if len(files) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(files))
+	}
+
+	hub := string(files["/etc/containerd/certs.d/docker.io/hosts.toml"])
+	if !strings.Contains(hub, `[host."https://mirror.example.com"]`) || !strings.HasPrefix(hub, managedHeader) {
+		t.Errorf("unexpected docker.io hosts file:\n%s", hub)
+	}
+	local := string(files["/etc/containerd/certs.d/registry.local:5000/hosts.toml"])
+	if !strings.Contains(local, "skip_verify = true") {
+		t.Errorf("unexpected insecure hosts file:\n%s", local)
+	}
+}
@@ -0,0 +1,86 @@
+package remote
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Runner runs shell commands on a machine, like the provisioners do over
+// SSH.
+type Runner interface {
+	SSHCommand(command string) (string, error)
+}
+
+var errNUL = errors.New("Arguments must not contain NUL bytes")
+
+// Quote returns the argument as a single word of a POSIX shell, any bytes
+// are preserved.
+func Quote(arg string) string {
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
+
+// Command returns the shell command running argv without interpreting any
+// of the arguments.
+func Command(argv ...string) (string, error) {
+	if len(argv) == 0 {
+		return "", errors.New("Expected a command")
+	}
+	words := make([]string, len(argv))
+	for i, arg := range argv {
+		if strings.IndexByte(arg, 0) >= 0 {
+			return "", errNUL
+		}
+		words[i] = Quote(arg)
+	}
+	return strings.Join(words, " "), nil
+}
+
+// Run runs argv on the machine.
+func Run(r Runner, argv ...string) (string, error) {
+	cmd, err := Command(argv...)
+	if err != nil {
+		return "", err
+	}
+	return r.SSHCommand(cmd)
+}
+
+// ValidatePath checks that the path is absolute and clean, so it can't
+// escape the directory it is expected in.
+func ValidatePath(p string) error {
+	if strings.IndexByte(p, 0) >= 0 {
+		return errNUL
+	}
+	if !path.IsAbs(p) || path.Clean(p) != p {
+		return fmt.Errorf("Path %q must be absolute and clean", p)
+	}
+	return nil
+}
+
+// WriteFileCommand returns the shell command writing the data with the
+// mode to the path. The data is passed base64 encoded, so it can contain
+// any bytes.
+func WriteFileCommand(p string, data []byte, mode os.FileMode) (string, error) {
+	if err := ValidatePath(p); err != nil {
+		return "", err
+	}
+	file := Quote(p)
+	return fmt.Sprintf("touch %s && chmod %s %s && echo %s | base64 -d > %s",
+		file, Quote(fmt.Sprintf("%04o", mode.Perm())), file, Quote(base64.StdEncoding.EncodeToString(data)), file), nil
+}
+
+// WriteFile writes the data with the mode to the path on the machine.
+func WriteFile(r Runner, p string, data []byte, mode os.FileMode) error {
+	cmd, err := WriteFileCommand(p, data, mode)
+	if err != nil {
+		return err
+	}
+	out, err := r.SSHCommand(cmd)
+	if err != nil {
+		return fmt.Errorf("Failed to write %s (error: %v): %v", p, err, out)
+	}
+	return nil
+}
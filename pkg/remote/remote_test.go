@@ -0,0 +1,144 @@
+package remote
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+// shell runs commands with the local shell, as a machine would.
+type shell struct{}
+
+func (shell) SSHCommand(command string) (string, error) {
+	out, err := exec.Command("/bin/sh", "-c", command).CombinedOutput()
+	return string(out), err
+}
+
+func requireShell(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no shell")
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := map[string]string{
+		"":           "''",
+		"kubelet":    "'kubelet'",
+		"it's":       `'it'\''s'`,
+		"$(reboot)":  "'$(reboot)'",
+		"a b\nc":     "'a b\nc'",
+		`"\'; rm -f`: `'"\'\''; rm -f'`,
+	}
+	for arg, expected := range tests {
+		if q := Quote(arg); q != expected {
+			t.Errorf("%q: expected %s, got %s", arg, expected, q)
+		}
+	}
+}
+
+func TestCommand(t *testing.T) {
+	if _, err := Command(); err == nil {
+		t.Error("expected an error without arguments")
+	}
+	if _, err := Command("echo", "a\x00b"); err == nil {
+		t.Error("expected an error for a NUL byte")
+	}
+}
+
+func TestValidatePath(t *testing.T) {
+	for _, p := range []string{"/etc/kubeconfig", "/etc/docker/certs.d/registry:5000/hosts.toml"} {
+		if err := ValidatePath(p); err != nil {
+			t.Errorf("%s: unexpected error %v", p, err)
+		}
+	}
+	for _, p := range []string{"", "etc/kubeconfig", "/etc/../root/.ssh/authorized_keys", "/etc//kubeconfig", "/etc/\x00"} {
+		if err := ValidatePath(p); err == nil {
+			t.Errorf("%q: expected an error", p)
+		}
+	}
+}
+
+// withoutNUL maps NUL bytes to another byte, arguments can't contain them.
+func withoutNUL(b []byte) string {
+	return string(bytes.Replace(b, []byte{0}, []byte{1}, -1))
+}
+
+func TestRunFuzz(t *testing.T) {
+	requireShell(t)
+	// The arguments are printed NUL separated, so any other byte sequence
+	// must survive the shell.
+	property := func(args [][]byte) bool {
+		argv := []string{"printf", `%s\000`}
+		var expected bytes.Buffer
+		for _, arg := range args {
+			argv = append(argv, withoutNUL(arg))
+			expected.WriteString(withoutNUL(arg))
+			expected.WriteByte(0)
+		}
+		if len(args) == 0 {
+			argv = append(argv, "")
+			expected.WriteByte(0)
+		}
+		out, err := Run(shell{}, argv...)
+		if err != nil {
+			t.Logf("%q: %v", argv, err)
+			return false
+		}
+		return out == expected.String()
+	}
+	config := &quick.Config{MaxCount: 500, Rand: rand.New(rand.NewSource(1))}
+	if err := quick.Check(property, config); err != nil {
+		t.Error(err)
+	}
+
+	for _, arg := range []string{"'", `'\''`, "$HOME", "`id`", "a;b", "a|b", "\n", "*", "~", "-n", "\\", "\xff\xfe"} {
+		if out, err := Run(shell{}, "printf", "%s", arg); err != nil || out != arg {
+			t.Errorf("%q: got %q, %v", arg, out, err)
+		}
+	}
+}
+
+func TestWriteFileFuzz(t *testing.T) {
+	requireShell(t)
+	dir, err := ioutil.TempDir("", "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	n := 0
+	property := func(name, data []byte) bool {
+		n++
+		// Names may contain anything but slashes and NUL bytes.
+		base := strings.Replace(withoutNUL(name), "/", "_", -1)
+		if base == "" || base == "." || base == ".." {
+			base = "file"
+		}
+		p := filepath.Join(dir, string(rune('a'+n%26))+base)
+		if err := WriteFile(shell{}, p, data, 0600); err != nil {
+			t.Logf("%q: %v", p, err)
+			return false
+		}
+		written, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Logf("%q: %v", p, err)
+			return false
+		}
+		info, err := os.Stat(p)
+		return err == nil && info.Mode().Perm() == 0600 && bytes.Equal(written, data)
+	}
+	config := &quick.Config{MaxCount: 200, Rand: rand.New(rand.NewSource(1))}
+	if err := quick.Check(property, config); err != nil {
+		t.Error(err)
+	}
+
+	if err := WriteFile(shell{}, dir+"/../escape", nil, 0644); err == nil {
+		t.Error("expected an error for an unclean path")
+	}
+}
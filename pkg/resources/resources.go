@@ -0,0 +1,105 @@
+package resources
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DetectCommand prints the CPU count, the memory in KiB and the size of the
+// disk holding /var/lib in KiB, one per line.
+const DetectCommand = "nproc && awk '/^MemTotal:/ {print $2}' /proc/meminfo && df -Pk /var/lib | awk 'NR==2 {print $2}'"
+
+// Resources are the capacity of a machine detected during provisioning.
+type Resources struct {
+	CPUs      int   `json:"cpus"`
+	MemoryMiB int64 `json:"memoryMiB"`
+	DiskGiB   int64 `json:"diskGiB"`
+}
+
+func (r Resources) String() string {
+	return fmt.Sprintf("%d CPU, %.1f GiB RAM, %d GiB disk", r.CPUs, float64(r.MemoryMiB)/1024, r.DiskGiB)
+}
+
+// Parse parses the output of DetectCommand.
+func Parse(out string) (Resources, error) {
+	fields := strings.Fields(out)
+	if len(fields) != 3 {
+		return Resources{}, fmt.Errorf("Failed to parse the resources of the machine from %q", out)
+	}
+	var values [3]int64
+	for i, f := range fields {
+		v, err := strconv.ParseInt(f, 10, 64)
+		if err != nil || v <= 0 {
+			return Resources{}, fmt.Errorf("Failed to parse the resources of the machine from %q", out)
+		}
+		values[i] = v
+	}
+	return Resources{
+		CPUs:      int(values[0]),
+		MemoryMiB: values[1] / 1024,
+		DiskGiB:   values[2] / (1024 * 1024),
+	}, nil
+}
+
+// Reservation is what the kubelet keeps from pods for the system and
+// Kubernetes daemons.
+type Reservation struct {
+	CPUMillis int64
+	MemoryMiB int64
+	// EvictionMemoryMiB is the available memory below which pods are
+	// evicted.
+	EvictionMemoryMiB int64
+}
+
+// band is a share of the capacity up to a bound, in per mille.
+type band struct {
+	upTo     int64
+	perMille int64
+}
+
+// The bands grow the reservation slower than the capacity, larger machines
+// keep a smaller share for the daemons.
+var (
+	cpuBands    = []band{{1000, 60}, {2000, 10}, {4000, 5}, {1 << 62, 2}}
+	memoryBands = []band{{4 * 1024, 250}, {8 * 1024, 200}, {16 * 1024, 100}, {128 * 1024, 60}, {1 << 62, 20}}
+)
+
+func reserve(capacity int64, bands []band) int64 {
+	var reserved, lower int64
+	for _, b := range bands {
+		if capacity <= lower {
+			break
+		}
+		upper := b.upTo
+		if capacity < upper {
+			upper = capacity
+		}
+		reserved += (upper - lower) * b.perMille / 1000
+		lower = b.upTo
+	}
+	return reserved
+}
+
+// Reserved returns the default reservation of a machine.
+func Reserved(r Resources) Reservation {
+	eviction := r.MemoryMiB / 100
+	if eviction < 100 {
+		eviction = 100
+	}
+	return Reservation{
+		CPUMillis:         reserve(int64(r.CPUs)*1000, cpuBands),
+		MemoryMiB:         reserve(r.MemoryMiB, memoryBands),
+		EvictionMemoryMiB: eviction,
+	}
+}
+
+// KubeletFlags returns the reservation and eviction flags of the kubelet of
+// a machine.
+func KubeletFlags(r Resources) []string {
+	reserved := Reserved(r)
+	return []string{
+		fmt.Sprintf("--kube-reserved=cpu=%dm,memory=%dMi", reserved.CPUMillis, reserved.MemoryMiB),
+		fmt.Sprintf("--eviction-hard=memory.available<%dMi,nodefs.available<10%%,nodefs.inodesFree<5%%", reserved.EvictionMemoryMiB),
+	}
+}
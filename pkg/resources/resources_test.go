@@ -0,0 +1,52 @@
+package resources
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	r, err := Parse("4\n16316412\n101569200\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (Resources{CPUs: 4, MemoryMiB: 15933, DiskGiB: 96}); r != expected {
+		t.Errorf("expected %+v, got %+v", expected, r)
+	}
+	if s := r.String(); s != "4 CPU, 15.6 GiB RAM, 96 GiB disk" {
+		t.Errorf("unexpected string %q", s)
+	}
+
+	for _, out := range []string{"", "4\n16316412\n", "four\n1\n1\n", "0\n1\n1\n"} {
+		if _, err := Parse(out); err == nil {
+			t.Errorf("%q: expected an error", out)
+		}
+	}
+}
+
+func TestReserved(t *testing.T) {
+	tests := []struct {
+		resources Resources
+		expected  Reservation
+	}{
+		{Resources{CPUs: 1, MemoryMiB: 2048}, Reservation{CPUMillis: 60, MemoryMiB: 512, EvictionMemoryMiB: 100}},
+		{Resources{CPUs: 4, MemoryMiB: 16 * 1024}, Reservation{CPUMillis: 80, MemoryMiB: 1024 + 819 + 819, EvictionMemoryMiB: 163}},
+		{Resources{CPUs: 64, MemoryMiB: 256 * 1024}, Reservation{CPUMillis: 200, MemoryMiB: 1024 + 819 + 819 + 6881 + 2621, EvictionMemoryMiB: 2621}},
+	}
+	for _, test := range tests {
+		if r := Reserved(test.resources); r != test.expected {
+			t.Errorf("%+v: expected %+v, got %+v", test.resources, test.expected, r)
+		}
+	}
+}
+
+func TestKubeletFlags(t *testing.T) {
+	flags := KubeletFlags(Resources{CPUs: 1, MemoryMiB: 2048, DiskGiB: 20})
+	expected := []string{
+		"--kube-reserved=cpu=60m,memory=512Mi",
+		"--eviction-hard=memory.available<100Mi,nodefs.available<10%,nodefs.inodesFree<5%",
+	}
+	if !reflect.DeepEqual(flags, expected) {
+		t.Errorf("expected %v, got %v", expected, flags)
+	}
+}
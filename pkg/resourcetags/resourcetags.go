@@ -0,0 +1,205 @@
+// Package resourcetags attributes the cloud resources of the machines. Every
+// resource a driver creates for a machine is tagged with the machine, its
+// pool and the configured tags like the cluster, owner and cost center, so
+// the resources of deleted machines can be found and the cloud bill can be
+// broken down.
+package resourcetags
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The tags set on every resource of a machine.
+const (
+	MachineKey = "kube-machine-machine"
+	PoolKey    = "kube-machine-pool"
+)
+
+// The well-known keys of the configured tags.
+const (
+	ClusterKey    = "cluster"
+	OwnerKey      = "owner"
+	CostCenterKey = "cost-center"
+)
+
+// The keys are valid at all supported providers, DigitalOcean tags are
+// key:value with at most 255 characters.
+var (
+	keyPattern   = regexp.MustCompile(`^[A-Za-z0-9_-]{1,63}$`)
+	valuePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{0,127}$`)
+)
+
+// Parse parses comma-separated key=value tags.
+func Parse(s string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid tag %q, expected key=value", kv)
+		}
+		if err := validate(parts[0], parts[1]); err != nil {
+			return nil, err
+		}
+		if parts[0] == MachineKey || parts[0] == PoolKey {
+			return nil, fmt.Errorf("Tag %s is set by kube-machine", parts[0])
+		}
+		tags[parts[0]] = parts[1]
+	}
+	return tags, nil
+}
+
+func validate(key, value string) error {
+	if !keyPattern.MatchString(key) {
+		return fmt.Errorf("Invalid tag key %q, expected up to 63 letters, digits, _ and -", key)
+	}
+	if !valuePattern.MatchString(value) {
+		return fmt.Errorf("Invalid value %q of tag %s, expected up to 127 letters, digits, _, . and -", value, key)
+	}
+	return nil
+}
+
+// ForMachine returns the tags of the resources of a machine, the configured
+// tags plus the machine and its pool.
+func ForMachine(configured map[string]string, machine, pool string) (map[string]string, error) {
+	tags := map[string]string{MachineKey: machine}
+	if pool != "" {
+		tags[PoolKey] = pool
+	}
+	for k, v := range configured {
+		tags[k] = v
+	}
+	for k, v := range tags {
+		if err := validate(k, v); err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+// Format returns the tags as sorted key=value pairs, the form they are
+// stored in with the machine.
+func Format(tags map[string]string) []string {
+	var pairs []string
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// FromPairs returns the tags of key=value pairs, invalid pairs are skipped.
+func FromPairs(pairs []string) map[string]string {
+	tags := map[string]string{}
+	for _, p := range pairs {
+		if parts := strings.SplitN(p, "=", 2); len(parts) == 2 {
+			tags[parts[0]] = parts[1]
+		}
+	}
+	return tags
+}
+
+// Machine returns the machine a resource was created for, false if it was
+// not created by kube-machine.
+func Machine(tags map[string]string) (string, bool) {
+	machine, ok := tags[MachineKey]
+	return machine, ok && machine != ""
+}
+
+// invalidDigitalOceanCharacters are not allowed in DigitalOcean tags.
+var invalidDigitalOceanCharacters = regexp.MustCompile(`[^A-Za-z0-9_:-]`)
+
+// DigitalOcean returns the DigitalOcean tag of a tag, DigitalOcean tags are
+// plain names. Characters DigitalOcean doesn't allow, like the dots of
+// machine names, are replaced by _.
+func DigitalOcean(key, value string) string {
+	return invalidDigitalOceanCharacters.ReplaceAllString(key+":"+value, "_")
+}
+
+// FromDigitalOcean returns the tags of the names of DigitalOcean tags, names
+// which are no key:value pair are skipped. The values are the ones of
+// DigitalOcean, see DigitalOcean.
+func FromDigitalOcean(names []string) map[string]string {
+	tags := map[string]string{}
+	for _, n := range names {
+		if parts := strings.SplitN(n, ":", 2); len(parts) == 2 {
+			tags[parts[0]] = parts[1]
+		}
+	}
+	return tags
+}
+
+// invalidGoogleCharacters are not allowed in GCE network tags.
+var invalidGoogleCharacters = regexp.MustCompile(`[^a-z0-9-]`)
+
+// Google returns the GCE network tag of a tag, GCE network tags are plain
+// lowercase names of at most 63 characters starting with a letter. Other
+// characters are replaced by -, so the tags only attribute the instances.
+func Google(key, value string) string {
+	name := invalidGoogleCharacters.ReplaceAllString(strings.ToLower(key+"-"+value), "-")
+	if name[0] < 'a' || name[0] > 'z' {
+		name = "t" + name
+	}
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return strings.TrimRight(name, "-")
+}
+
+// driverFlags are the create flags of the drivers tagging the resources
+// while creating them, with the format of their tags.
+var driverFlags = map[string]struct {
+	name   string
+	format func(tags map[string]string) []string
+}{
+	// amazonec2 takes key,value pairs.
+	"amazonec2": {"amazonec2-tags", func(tags map[string]string) []string {
+		var pairs []string
+		for _, kv := range Format(tags) {
+			pairs = append(pairs, strings.Replace(kv, "=", ",", 1))
+		}
+		return pairs
+	}},
+	"digitalocean": {"digitalocean-tags", func(tags map[string]string) []string {
+		var names []string
+		for _, kv := range Format(tags) {
+			parts := strings.SplitN(kv, "=", 2)
+			names = append(names, DigitalOcean(parts[0], parts[1]))
+		}
+		return names
+	}},
+	"google": {"google-tags", func(tags map[string]string) []string {
+		var names []string
+		for _, kv := range Format(tags) {
+			parts := strings.SplitN(kv, "=", 2)
+			names = append(names, Google(parts[0], parts[1]))
+		}
+		return names
+	}},
+}
+
+// SetDriverFlags adds the tags to the tag flag of the driver in the values
+// of its create flags, keeping the tags given by the user. It returns false
+// if the driver has no tag flag.
+func SetDriverFlags(driver string, values map[string]interface{}, tags map[string]string) bool {
+	flag, ok := driverFlags[driver]
+	if !ok {
+		return false
+	}
+	existing, ok := values[flag.name]
+	if !ok {
+		return false
+	}
+	all := flag.format(tags)
+	if s, _ := existing.(string); s != "" {
+		all = append([]string{s}, all...)
+	}
+	values[flag.name] = strings.Join(all, ",")
+	return true
+}
@@ -0,0 +1,95 @@
+package resourcetags
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tags, err := Parse("cluster=prod, owner=team-a,cost-center=4711,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{ClusterKey: "prod", OwnerKey: "team-a", CostCenterKey: "4711"}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("Expected %v, got %v", expected, tags)
+	}
+
+	for _, invalid := range []string{"cluster", "cluster=a b", "a:b=c", "kube-machine-machine=x", "owner=a/b"} {
+		if _, err := Parse(invalid); err == nil {
+			t.Errorf("Expected %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestForMachine(t *testing.T) {
+	tags, err := ForMachine(map[string]string{ClusterKey: "prod"}, "worker-1", "workers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(Format(tags), []string{"cluster=prod", "kube-machine-machine=worker-1", "kube-machine-pool=workers"}) {
+		t.Errorf("Unexpected tags %v", Format(tags))
+	}
+	if !reflect.DeepEqual(FromPairs(Format(tags)), tags) {
+		t.Errorf("Expected the formatted tags to parse again")
+	}
+	if machine, ok := Machine(tags); !ok || machine != "worker-1" {
+		t.Errorf("Expected the machine worker-1, got %q", machine)
+	}
+
+	tags, err = ForMachine(nil, "worker-2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tags[PoolKey]; ok {
+		t.Errorf("Expected no pool tag, got %v", tags)
+	}
+	if _, err := ForMachine(nil, "Worker 2", ""); err == nil {
+		t.Error("Expected an invalid machine name to fail")
+	}
+}
+
+func TestDigitalOcean(t *testing.T) {
+	names := []string{DigitalOcean(MachineKey, "worker-1"), DigitalOcean(CostCenterKey, "4711"), "legacy"}
+	tags := FromDigitalOcean(names)
+	if !reflect.DeepEqual(tags, map[string]string{MachineKey: "worker-1", CostCenterKey: "4711"}) {
+		t.Errorf("Unexpected tags %v", tags)
+	}
+	if tag := DigitalOcean(MachineKey, "node.example"); tag != "kube-machine-machine:node_example" {
+		t.Errorf("Expected the dot to be replaced, got %s", tag)
+	}
+	if _, ok := Machine(FromDigitalOcean([]string{"legacy"})); ok {
+		t.Error("Expected a droplet without machine tag not to belong to a machine")
+	}
+}
+
+func TestSetDriverFlags(t *testing.T) {
+	tags := map[string]string{MachineKey: "node.example", OwnerKey: "team-a"}
+	values := map[string]interface{}{"amazonec2-tags": "env,prod", "digitalocean-tags": ""}
+	if !SetDriverFlags("amazonec2", values, tags) {
+		t.Fatal("Expected amazonec2 to take tags")
+	}
+	if values["amazonec2-tags"] != "env,prod,kube-machine-machine,node.example,owner,team-a" {
+		t.Errorf("Unexpected amazonec2 tags %q", values["amazonec2-tags"])
+	}
+	if !SetDriverFlags("digitalocean", values, tags) {
+		t.Fatal("Expected digitalocean to take tags")
+	}
+	if values["digitalocean-tags"] != "kube-machine-machine:node_example,owner:team-a" {
+		t.Errorf("Unexpected digitalocean tags %q", values["digitalocean-tags"])
+	}
+	values["google-tags"] = "http-server"
+	if !SetDriverFlags("google", values, tags) {
+		t.Fatal("Expected google to take tags")
+	}
+	if values["google-tags"] != "http-server,kube-machine-machine-node-example,owner-team-a" {
+		t.Errorf("Unexpected google tags %q", values["google-tags"])
+	}
+	if tag := Google("_Team", strings.Repeat("a", 70)); len(tag) != 63 || !strings.HasPrefix(tag, "t-team-a") {
+		t.Errorf("Expected a lowercase tag starting with a letter, got %s", tag)
+	}
+	if SetDriverFlags("digitalocean", map[string]interface{}{}, tags) || SetDriverFlags("virtualbox", values, tags) {
+		t.Error("Expected drivers without tag flag to be skipped")
+	}
+}
@@ -0,0 +1,81 @@
+// Package rollback restores the kubelet and Docker of a node after a failed
+// in-place upgrade. The files the upgrade replaces are backed up on the node
+// before with configbackup.
+package rollback
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kubermatic/kube-machine/pkg/configbackup"
+)
+
+const (
+	// BackupDir holds the backup of the last upgrade on the node.
+	BackupDir = "/var/lib/kube-machine/upgrade-backup"
+	// Phase marks machines whose upgrade failed and was rolled back.
+	Phase = "UpgradeFailedRolledBack"
+
+	// DefaultTimeout is how long an upgraded node may stay not ready.
+	DefaultTimeout = 5 * time.Minute
+)
+
+// DockerFiles are the files of the docker packages an upgrade replaces: the
+// binaries and the units of the distributions. Restoring them doesn't
+// downgrade the packages, the package manager keeps the upgraded version.
+var DockerFiles = []string{
+	"/usr/bin/docker",
+	"/usr/bin/dockerd",
+	"/usr/bin/docker-init",
+	"/usr/bin/docker-proxy",
+	"/usr/bin/docker-containerd",
+	"/usr/bin/docker-containerd-ctr",
+	"/usr/bin/docker-containerd-shim",
+	"/usr/bin/docker-runc",
+	"/usr/bin/containerd",
+	"/usr/bin/containerd-shim",
+	"/usr/bin/ctr",
+	"/usr/bin/runc",
+	"/lib/systemd/system/docker.service",
+	"/lib/systemd/system/docker.socket",
+	"/usr/lib/systemd/system/docker.service",
+	"/usr/lib/systemd/system/docker.socket",
+}
+
+// Rollback records a failed upgrade which was rolled back, the operator
+// needs to follow up on the machine.
+type Rollback struct {
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+}
+
+func (r *Rollback) String() string {
+	return fmt.Sprintf("%s since %s: %s", Phase, r.Since.Format(time.RFC3339), r.Reason)
+}
+
+// Error is returned by upgrades which failed and were rolled back.
+type Error struct {
+	Cause error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("The upgrade failed and was rolled back: %v", e.Cause)
+}
+
+// BackupScript returns the script backing up the files, replacing the
+// previous backup. It runs as root.
+func BackupScript(paths []string) (string, error) {
+	return configbackup.BackupScript(BackupDir, paths)
+}
+
+// RestoreScript returns the script restoring the files from the backup. It
+// fails if there is no backup and runs as root.
+func RestoreScript() (string, error) {
+	return configbackup.RestoreScript(BackupDir)
+}
+
+// CleanScript returns the script removing the backup after a successful
+// upgrade.
+func CleanScript() string {
+	return "rm -rf " + BackupDir
+}
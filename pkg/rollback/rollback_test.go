@@ -0,0 +1,21 @@
+package rollback
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRollback(t *testing.T) {
+	r := &Rollback{Reason: "Node worker-1 did not report ready again", Since: time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)}
+	if s := r.String(); !strings.HasPrefix(s, Phase+" since 2026-10-16T08:00:00Z") {
+		t.Errorf("Unexpected rollback %q", s)
+	}
+	if err := (&Error{Cause: errors.New("not ready")}); !strings.Contains(err.Error(), "rolled back: not ready") {
+		t.Errorf("Unexpected error %q", err)
+	}
+	if _, err := BackupScript([]string{"relative/kubelet"}); err == nil {
+		t.Error("Expected an error for a relative path")
+	}
+}
@@ -0,0 +1,97 @@
+package signer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// CFSSL signs with the sign endpoint of a remote cfssl.
+type CFSSL struct {
+	URL     string
+	Profile string
+	Client  *http.Client
+}
+
+// Sign has cfssl sign the request, the profile of cfssl decides the usages
+// and the validity.
+func (c *CFSSL) Sign(r Request) ([]byte, error) {
+	request := map[string]interface{}{
+		"certificate_request": string(r.CSR),
+		"profile":             c.Profile,
+	}
+	if !r.Client {
+		request["hosts"] = r.Hosts
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(c.URL, "/")+"/api/v1/cfssl/sign", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var result struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+		Result struct {
+			Certificate string `json:"certificate"`
+		} `json:"result"`
+	}
+	if err := doJSON(httpClient(c.Client), req, &result); err != nil && len(result.Errors) == 0 {
+		return nil, fmt.Errorf("cfssl failed to sign the certificate: %v", err)
+	}
+	if !result.Success {
+		var messages []string
+		for _, e := range result.Errors {
+			messages = append(messages, e.Message)
+		}
+		return nil, fmt.Errorf("cfssl failed to sign the certificate: %s", strings.Join(messages, ", "))
+	}
+	return bundle(result.Result.Certificate, nil)
+}
+
+// doJSON sends the request and decodes the JSON response, which is also
+// decoded for errors to read the error messages of the APIs.
+func doJSON(client *http.Client, req *http.Request, v interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	decodeErr := json.Unmarshal(data, v)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return decodeErr
+}
+
+// bundle returns the certificate followed by the chain, checking that they
+// are certificates.
+func bundle(certificate string, chain []string) ([]byte, error) {
+	var out bytes.Buffer
+	for _, c := range append([]string{certificate}, chain...) {
+		c = strings.TrimSpace(c)
+		if !strings.HasPrefix(c, "-----BEGIN CERTIFICATE-----") {
+			return nil, fmt.Errorf("The signer returned no PEM encoded certificate")
+		}
+		out.WriteString(c + "\n")
+	}
+	return out.Bytes(), nil
+}
+
+func isIP(host string) bool {
+	return net.ParseIP(host) != nil
+}
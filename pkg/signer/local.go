@@ -0,0 +1,92 @@
+package signer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"time"
+)
+
+// Validity is how long the certificates of the local signer are valid, like
+// the ones of libmachine.
+const Validity = 1080 * 24 * time.Hour
+
+// Local signs with a CA on disk, which may be an intermediate CA. Its
+// certificate and the Chain are appended to the certificates then, so they
+// verify against the root.
+type Local struct {
+	CACert, CAKey string
+	Chain         string
+}
+
+// Sign signs the request with the CA.
+func (l *Local) Sign(r Request) ([]byte, error) {
+	csr, err := ParseRequest(r.CSR)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := tls.LoadX509KeyPair(l.CACert, l.CAKey)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	// Some time in the past accounts for clock skew of the machines.
+	notBefore := time.Now().Add(-5 * time.Minute)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: csr.Subject.Organization, CommonName: r.CommonName},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(Validity),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageKeyAgreement,
+		BasicConstraintsValid: true,
+	}
+	if r.Client {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		if r.Server {
+			template.ExtKeyUsage = append(template.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+		}
+		for _, h := range r.Hosts {
+			if ip := net.ParseIP(h); ip != nil {
+				template.IPAddresses = append(template.IPAddresses, ip)
+			} else if h != "" {
+				template.DNSNames = append(template.DNSNames, h)
+			}
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, csr.PublicKey, ca.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	pem.Encode(&out, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	// A self-signed CA is the root the machines trust, an intermediate
+	// is sent along.
+	if caCert.CheckSignatureFrom(caCert) != nil {
+		pem.Encode(&out, &pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})
+	}
+	if l.Chain != "" {
+		chain, err := ReadChain(l.Chain)
+		if err != nil {
+			return nil, err
+		}
+		out.Write(chain)
+	}
+	return out.Bytes(), nil
+}
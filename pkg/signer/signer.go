@@ -0,0 +1,290 @@
+// Package signer signs the certificates of the machines and of the
+// kube-machine client with the CA of the organization instead of the CA
+// kube-machine generates: with a CA or an intermediate CA on disk, Vault PKI
+// or a remote cfssl. The private keys never leave kube-machine, only
+// certificate requests are sent to the signers.
+package signer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"github.com/docker/machine/libmachine/auth"
+	"github.com/docker/machine/libmachine/cert"
+)
+
+// The kinds of signers.
+const (
+	KindLocal = "local"
+	KindVault = "vault"
+	KindCFSSL = "cfssl"
+	// KindKubernetes is refused: the Docker daemons would trust every
+	// client certificate of the cluster CA, like the ones of the kubelets.
+	KindKubernetes = "kubernetes"
+)
+
+// Kinds are the supported kinds of signers.
+var Kinds = []string{KindLocal, KindVault, KindCFSSL}
+
+// Request is a certificate request of a machine or of the client.
+type Request struct {
+	// CSR is the PEM encoded certificate request.
+	CSR        []byte
+	CommonName string
+	// Hosts are the DNS names and IPs of a server certificate.
+	Hosts []string
+	// Client requests a client instead of a server certificate.
+	Client bool
+	// Server requests a server certificate which may also authenticate
+	// as client, like the one of a swarm master.
+	Server bool
+}
+
+// Signer signs certificate requests. It returns the PEM encoded certificate
+// followed by the intermediate CAs up to the root.
+type Signer interface {
+	Sign(r Request) ([]byte, error)
+}
+
+// Config selects and configures the signer.
+type Config struct {
+	Kind string
+	// URL is the address of Vault or of the cfssl API.
+	URL string
+	// Role is the path of the signing role of Vault below its PKI mount,
+	// e.g. pki_int/sign/nodes, or the profile of cfssl.
+	Role string
+	// Token authenticates with Vault.
+	Token string
+	// Chain holds intermediate CAs appended to the certificates of the
+	// local signer, whose CA may be an intermediate itself.
+	Chain string
+}
+
+// Validate checks that the signer is configured completely.
+func (c Config) Validate() error {
+	switch c.Kind {
+	case "", KindLocal:
+		if c.URL != "" || c.Role != "" {
+			return fmt.Errorf("The %s signer takes no URL or role", kindOrLocal(c.Kind))
+		}
+	case KindKubernetes:
+		return fmt.Errorf("The kubernetes signer is not supported: the Docker daemons would trust every certificate of the cluster CA, use an intermediate CA dedicated to Docker with the local, vault or cfssl signer")
+	case KindVault:
+		if c.URL == "" || c.Role == "" || c.Token == "" {
+			return fmt.Errorf("The vault signer needs a URL, the role path like pki_int/sign/nodes and a token")
+		}
+	case KindCFSSL:
+		if c.URL == "" {
+			return fmt.Errorf("The cfssl signer needs the URL of the cfssl API")
+		}
+	default:
+		return fmt.Errorf("Unknown signer %q, expected one of %s", c.Kind, strings.Join(Kinds, ", "))
+	}
+	if c.Chain != "" && c.Kind != "" && c.Kind != KindLocal {
+		return fmt.Errorf("Only the local signer appends a CA chain, the %s signer returns its own", c.Kind)
+	}
+	return nil
+}
+
+func kindOrLocal(kind string) string {
+	if kind == "" {
+		return KindLocal
+	}
+	return kind
+}
+
+// New returns the signer of the config. The local signer signs with the CA,
+// or with the CA of the certificate options if it is empty.
+func New(c Config, caCert, caKey string) (Signer, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	switch c.Kind {
+	case KindVault:
+		return &Vault{Address: c.URL, Role: c.Role, Token: c.Token}, nil
+	case KindCFSSL:
+		return &CFSSL{URL: c.URL, Profile: c.Role}, nil
+	}
+	return &Local{CACert: caCert, CAKey: caKey, Chain: c.Chain}, nil
+}
+
+// Options returns the options of the config stored with the machines. The
+// token is left out, it is passed on each run.
+func (c Config) Options() *auth.SignerOptions {
+	return &auth.SignerOptions{Kind: kindOrLocal(c.Kind), URL: c.URL, Role: c.Role, Chain: c.Chain}
+}
+
+// Generator generates the certificates of libmachine with the signer. It
+// can't create a CA, the CA certificate of the auth options has to be an
+// intermediate CA of a remote signer, which the machines and the client
+// trust. The TLS configs are read like by cert.X509CertGenerator.
+type Generator struct {
+	// Signer signs the certificates without stored signer options, the
+	// one of the client and of the machines created before they were
+	// stored. Without signer the certificates are created like by
+	// cert.X509CertGenerator.
+	Signer Signer
+	// Token authenticates with the signers of the stored options.
+	Token string
+	cert.X509CertGenerator
+}
+
+var _ cert.Generator = &Generator{}
+
+// GenerateCACertificate creates the root CA of the local signer, the CAs of
+// the other signers are not created by kube-machine.
+func (g *Generator) GenerateCACertificate(certFile, keyFile, org string, bits int) error {
+	if _, ok := g.Signer.(*Local); ok || g.Signer == nil {
+		return g.X509CertGenerator.GenerateCACertificate(certFile, keyFile, org, bits)
+	}
+	return fmt.Errorf("The CA certificate %s is missing, pass the intermediate CA of the signer with --tls-ca-cert", certFile)
+}
+
+// GenerateCert creates a key and has the certificate of the request signed
+// by the signer stored with the machine or else by the one of the generator.
+func (g *Generator) GenerateCert(opts *cert.Options) error {
+	s := g.Signer
+	if o := opts.Signer; o != nil {
+		s = nil
+		if o.Kind != KindLocal || o.Chain != "" {
+			var err error
+			if s, err = New(Config{Kind: o.Kind, URL: o.URL, Role: o.Role, Token: g.Token, Chain: o.Chain}, "", ""); err != nil {
+				return fmt.Errorf("Failed to create the signer of the machine: %v", err)
+			}
+		}
+	}
+	if s == nil {
+		return g.X509CertGenerator.GenerateCert(opts)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, opts.Bits)
+	if err != nil {
+		return err
+	}
+	r := Request{Client: len(opts.Hosts) == 1 && opts.Hosts[0] == "", Server: opts.SwarmMaster}
+	template := &x509.CertificateRequest{Subject: pkix.Name{Organization: []string{opts.Org}}}
+	if !r.Client {
+		r.Hosts = opts.Hosts
+		for _, h := range opts.Hosts {
+			if ip := net.ParseIP(h); ip != nil {
+				template.IPAddresses = append(template.IPAddresses, ip)
+			} else if h != "" {
+				template.DNSNames = append(template.DNSNames, h)
+			}
+		}
+		if len(opts.Hosts) > 0 {
+			r.CommonName = opts.Hosts[0]
+		}
+	} else {
+		r.CommonName = opts.Org
+	}
+	template.Subject.CommonName = r.CommonName
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return err
+	}
+	r.CSR = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+
+	if l, ok := s.(*Local); ok && l.CACert == "" {
+		s = &Local{CACert: opts.CAFile, CAKey: opts.CAKeyFile, Chain: l.Chain}
+	}
+	chain, err := s.Sign(r)
+	if err != nil {
+		return fmt.Errorf("Failed to sign the certificate: %v", err)
+	}
+	if _, ok := s.(*Local); !ok {
+		if err := VerifyIssuer(chain, opts.CAFile); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(opts.CertFile, chain, 0644); err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return ioutil.WriteFile(opts.KeyFile, keyPEM, 0600)
+}
+
+// VerifyIssuer checks that the first certificate of the chain is issued by
+// the CA, which has to be an intermediate CA. The Docker daemons trust every
+// certificate of the CA, the CA of a remote signer must only sign for Docker
+// then, never the root of the organization.
+func VerifyIssuer(chain []byte, caFile string) error {
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return fmt.Errorf("%s holds no certificate", caFile)
+	}
+	ca, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("Failed to parse the CA %s: %v", caFile, err)
+	}
+	if bytes.Equal(ca.RawIssuer, ca.RawSubject) {
+		return fmt.Errorf("The CA %s is a root CA, pass an intermediate CA dedicated to Docker with --tls-ca-cert", caFile)
+	}
+	block, _ = pem.Decode(chain)
+	if block == nil {
+		return fmt.Errorf("The signer returned no certificate")
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("Failed to parse the signed certificate: %v", err)
+	}
+	if err := leaf.CheckSignatureFrom(ca); err != nil {
+		return fmt.Errorf("The certificate isn't issued by the CA %s, the signer has to sign with it directly: %v", caFile, err)
+	}
+	return nil
+}
+
+// ParseRequest parses and verifies the signature of a PEM encoded
+// certificate request.
+func ParseRequest(data []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, fmt.Errorf("Expected a PEM encoded certificate request")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return csr, csr.CheckSignature()
+}
+
+// ReadChain reads PEM encoded certificates, e.g. the intermediate CAs.
+func ReadChain(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rest := data
+	var out bytes.Buffer
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("%s holds a %s, expected certificates", path, block.Type)
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return nil, fmt.Errorf("Failed to parse a certificate of %s: %v", path, err)
+		}
+		pem.Encode(&out, block)
+	}
+	if out.Len() == 0 {
+		return nil, fmt.Errorf("%s holds no certificates", path)
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,291 @@
+package signer
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/machine/libmachine/auth"
+	"github.com/docker/machine/libmachine/cert"
+)
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newCA(t *testing.T, name string, parent *x509.Certificate, parentKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, key
+}
+
+func parseChain(t *testing.T, data []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, c)
+	}
+}
+
+func TestGeneratorIntermediate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root, rootKey := newCA(t, "root", nil, nil)
+	intermediate, intermediateKey := newCA(t, "intermediate", root, rootKey)
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", intermediate.Raw)
+	writePEM(t, filepath.Join(dir, "ca-key.pem"), "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(intermediateKey))
+
+	g := &Generator{Signer: &Local{}}
+	opts := &cert.Options{
+		Hosts:     []string{"10.0.0.1", "node.example.com"},
+		CertFile:  filepath.Join(dir, "server.pem"),
+		KeyFile:   filepath.Join(dir, "server-key.pem"),
+		CAFile:    filepath.Join(dir, "ca.pem"),
+		CAKeyFile: filepath.Join(dir, "ca-key.pem"),
+		Org:       "kube-machine",
+		Bits:      1024,
+	}
+	if err := g.GenerateCert(opts); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(opts.CertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := parseChain(t, data)
+	if len(chain) != 2 || chain[1].Subject.CommonName != "intermediate" {
+		t.Fatalf("Expected the certificate followed by the intermediate, got %d certificates", len(chain))
+	}
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	roots.AddCert(root)
+	intermediates.AddCert(chain[1])
+	if _, err := chain[0].Verify(x509.VerifyOptions{DNSName: "node.example.com", Roots: roots, Intermediates: intermediates}); err != nil {
+		t.Fatalf("The certificate doesn't verify against the root: %v", err)
+	}
+	if len(chain[0].IPAddresses) != 1 || chain[0].IPAddresses[0].String() != "10.0.0.1" {
+		t.Errorf("Expected the IP SAN 10.0.0.1, got %v", chain[0].IPAddresses)
+	}
+	if _, err := os.Stat(opts.KeyFile); err != nil {
+		t.Error(err)
+	}
+
+	g.Signer = &CFSSL{URL: "http://cfssl"}
+	if err := g.GenerateCACertificate(filepath.Join(dir, "missing.pem"), filepath.Join(dir, "missing-key.pem"), "org", 1024); err == nil {
+		t.Error("Expected the generator to refuse creating a CA for a remote signer")
+	}
+}
+
+func TestGeneratorStoredSigner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root, rootKey := newCA(t, "root", nil, nil)
+	intermediate, intermediateKey := newCA(t, "intermediate", root, rootKey)
+	writePEM(t, filepath.Join(dir, "root.pem"), "CERTIFICATE", root.Raw)
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", intermediate.Raw)
+	writePEM(t, filepath.Join(dir, "ca-key.pem"), "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(intermediateKey))
+
+	signed := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			CSR   string   `json:"certificate_request"`
+			Hosts []string `json:"hosts"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		local := &Local{CACert: filepath.Join(dir, "ca.pem"), CAKey: filepath.Join(dir, "ca-key.pem")}
+		out, err := local.Sign(Request{CSR: []byte(request.CSR), CommonName: request.Hosts[0], Hosts: request.Hosts})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		signed++
+		result, _ := json.Marshal(map[string]interface{}{"success": true, "result": map[string]string{"certificate": string(out)}})
+		w.Write(result)
+	}))
+	defer server.Close()
+
+	// The flags select no signer, the one stored with the machine is used.
+	g := &Generator{Signer: &Local{}}
+	opts := &cert.Options{
+		Hosts:    []string{"10.0.0.1"},
+		CertFile: filepath.Join(dir, "server.pem"),
+		KeyFile:  filepath.Join(dir, "server-key.pem"),
+		CAFile:   filepath.Join(dir, "ca.pem"),
+		Org:      "kube-machine",
+		Bits:     1024,
+		Signer:   Config{Kind: KindCFSSL, URL: server.URL}.Options(),
+	}
+	if err := g.GenerateCert(opts); err != nil {
+		t.Fatal(err)
+	}
+	if signed != 1 {
+		t.Errorf("Expected cfssl to sign the certificate, signed %d", signed)
+	}
+
+	opts.CAFile = filepath.Join(dir, "root.pem")
+	if err := g.GenerateCert(opts); err == nil || !strings.Contains(err.Error(), "root CA") {
+		t.Errorf("Expected the root CA to be refused, got %v", err)
+	}
+
+	opts.CAFile = filepath.Join(dir, "ca.pem")
+	opts.Signer = &auth.SignerOptions{Kind: KindVault, URL: server.URL, Role: "pki_int/sign/nodes"}
+	if err := g.GenerateCert(opts); err == nil || !strings.Contains(err.Error(), "token") {
+		t.Errorf("Expected the stored vault signer to need a token, got %v", err)
+	}
+}
+
+func TestLocalClientChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root, rootKey := newCA(t, "root", nil, nil)
+	other, _ := newCA(t, "other", nil, nil)
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", root.Raw)
+	writePEM(t, filepath.Join(dir, "ca-key.pem"), "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rootKey))
+	writePEM(t, filepath.Join(dir, "chain.pem"), "CERTIFICATE", other.Raw)
+
+	g := &Generator{Signer: &Local{CACert: filepath.Join(dir, "ca.pem"), CAKey: filepath.Join(dir, "ca-key.pem"), Chain: filepath.Join(dir, "chain.pem")}}
+	opts := &cert.Options{Hosts: []string{""}, CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem"), Org: "client", Bits: 1024}
+	if err := g.GenerateCert(opts); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(opts.CertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := parseChain(t, data)
+	if len(chain) != 2 || chain[1].Subject.CommonName != "other" {
+		t.Fatalf("Expected the certificate followed by the chain only, got %d certificates", len(chain))
+	}
+	if len(chain[0].ExtKeyUsage) != 1 || chain[0].ExtKeyUsage[0] != x509.ExtKeyUsageClientAuth {
+		t.Errorf("Expected a client certificate, got %v", chain[0].ExtKeyUsage)
+	}
+}
+
+func TestVault(t *testing.T) {
+	var request map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/pki_int/sign/nodes" || r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		w.Write([]byte(`{"data":{"certificate":"-----BEGIN CERTIFICATE-----\nleaf\n-----END CERTIFICATE-----","ca_chain":["-----BEGIN CERTIFICATE-----\nintermediate\n-----END CERTIFICATE-----"]}}`))
+	}))
+	defer server.Close()
+
+	v := &Vault{Address: server.URL, Role: "pki_int/sign/nodes", Token: "token"}
+	out, err := v.Sign(Request{CSR: []byte("csr"), CommonName: "node", Hosts: []string{"node", "10.0.0.1", "node.example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(out), "BEGIN CERTIFICATE") != 2 {
+		t.Errorf("Expected the certificate and the chain, got %s", out)
+	}
+	if request["common_name"] != "node" || request["alt_names"] != "node.example.com" || request["ip_sans"] != "10.0.0.1" {
+		t.Errorf("Unexpected request %v", request)
+	}
+
+	v.Token = "wrong"
+	if _, err := v.Sign(Request{CSR: []byte("csr")}); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("Expected the error of Vault, got %v", err)
+	}
+}
+
+func TestCFSSL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Profile string `json:"profile"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		if r.URL.Path != "/api/v1/cfssl/sign" || request.Profile != "server" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"success":false,"errors":[{"code":5200,"message":"Invalid profile"}]}`))
+			return
+		}
+		w.Write([]byte(`{"success":true,"result":{"certificate":"-----BEGIN CERTIFICATE-----\nleaf\n-----END CERTIFICATE-----\n"}}`))
+	}))
+	defer server.Close()
+
+	c := &CFSSL{URL: server.URL, Profile: "server"}
+	if _, err := c.Sign(Request{CSR: []byte("csr")}); err != nil {
+		t.Fatal(err)
+	}
+	c.Profile = "missing"
+	if _, err := c.Sign(Request{CSR: []byte("csr")}); err == nil || !strings.Contains(err.Error(), "Invalid profile") {
+		t.Errorf("Expected the error of cfssl, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, test := range []struct {
+		config Config
+		valid  bool
+	}{
+		{Config{}, true},
+		{Config{Chain: "chain.pem"}, true},
+		{Config{Kind: KindKubernetes}, false},
+		{Config{Kind: KindLocal, URL: "https://vault"}, false},
+		{Config{Kind: KindVault, URL: "https://vault", Role: "pki/sign/nodes", Token: "t"}, true},
+		{Config{Kind: KindVault, URL: "https://vault"}, false},
+		{Config{Kind: KindCFSSL, URL: "https://cfssl"}, true},
+		{Config{Kind: KindCFSSL}, false},
+		{Config{Kind: "acme"}, false},
+	} {
+		if err := test.config.Validate(); (err == nil) != test.valid {
+			t.Errorf("Validate(%+v) = %v, expected valid %v", test.config, err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package signer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Vault signs with the sign endpoint of a role of Vault PKI.
+type Vault struct {
+	Address string
+	// Role is the path of the sign endpoint below /v1, e.g.
+	// pki_int/sign/nodes.
+	Role   string
+	Token  string
+	Client *http.Client
+}
+
+func httpClient(c *http.Client) *http.Client {
+	if c != nil {
+		return c
+	}
+	return &http.Client{Timeout: 30 * time.Second}
+}
+
+// Sign has Vault sign the request, it returns the certificate and the CA
+// chain of Vault.
+func (v *Vault) Sign(r Request) ([]byte, error) {
+	var dns, ips []string
+	for _, h := range r.Hosts {
+		if h == "" || h == r.CommonName {
+			continue
+		}
+		if isIP(h) {
+			ips = append(ips, h)
+		} else {
+			dns = append(dns, h)
+		}
+	}
+	body, err := json.Marshal(map[string]string{
+		"csr":         string(r.CSR),
+		"common_name": r.CommonName,
+		"alt_names":   strings.Join(dns, ","),
+		"ip_sans":     strings.Join(ips, ","),
+		"format":      "pem",
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(v.Address, "/")+"/v1/"+strings.Trim(v.Role, "/"), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	var result struct {
+		Errors []string `json:"errors"`
+		Data   struct {
+			Certificate string   `json:"certificate"`
+			IssuingCA   string   `json:"issuing_ca"`
+			CAChain     []string `json:"ca_chain"`
+		} `json:"data"`
+	}
+	if err := doJSON(httpClient(v.Client), req, &result); err != nil {
+		return nil, fmt.Errorf("Vault failed to sign the certificate: %v", err)
+	}
+	if len(result.Errors) > 0 {
+		return nil, fmt.Errorf("Vault failed to sign the certificate: %s", strings.Join(result.Errors, ", "))
+	}
+	chain := result.Data.CAChain
+	if len(chain) == 0 && result.Data.IssuingCA != "" {
+		chain = []string{result.Data.IssuingCA}
+	}
+	return bundle(result.Data.Certificate, chain)
+}
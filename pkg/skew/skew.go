@@ -0,0 +1,96 @@
+package skew
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// MaxMinorSkew is the number of minor versions kubelets may be older than
+// the API server.
+const MaxMinorSkew = 2
+
+const (
+	StateSupported   = "supported"
+	StateTooOld      = "too old"
+	StateTooNew      = "newer than API server"
+	StateUnknown     = "unknown"
+	stateUnparseable = "unparseable"
+)
+
+var versionPattern = regexp.MustCompile(`^v?(\d+)\.(\d+)(\.\d+)?([-+].*)?$`)
+
+// Version is the major and minor version of a Kubernetes component.
+type Version struct {
+	Major int
+	Minor int
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("v%d.%d", v.Major, v.Minor)
+}
+
+// ParseVersion parses a version like v1.5.3 or 1.6.0-beta.1+gke.0.
+func ParseVersion(s string) (Version, error) {
+	m := versionPattern.FindStringSubmatch(s)
+	if m == nil {
+		return Version{}, fmt.Errorf("Invalid Kubernetes version %q", s)
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	return Version{major, minor}, nil
+}
+
+// Check returns an error if the kubelet version isn't supported by the API
+// server: kubelets may be up to MaxMinorSkew minor versions older than the
+// API server, but not newer.
+func Check(apiServer, kubelet string) error {
+	server, err := ParseVersion(apiServer)
+	if err != nil {
+		return err
+	}
+	k, err := ParseVersion(kubelet)
+	if err != nil {
+		return err
+	}
+
+	switch state(server, k) {
+	case StateTooOld:
+		return fmt.Errorf("Kubelet %s is more than %d minor versions older than the API server %s", kubelet, MaxMinorSkew, apiServer)
+	case StateTooNew:
+		return fmt.Errorf("Kubelet %s is newer than the API server %s", kubelet, apiServer)
+	}
+	return nil
+}
+
+// State describes the skew of the kubelet version to the API server
+// version, it is unknown if either version is.
+func State(apiServer, kubelet string) string {
+	if apiServer == "" || kubelet == "" {
+		return StateUnknown
+	}
+	server, err := ParseVersion(apiServer)
+	if err != nil {
+		return stateUnparseable
+	}
+	k, err := ParseVersion(kubelet)
+	if err != nil {
+		return stateUnparseable
+	}
+	return state(server, k)
+}
+
+func state(server, kubelet Version) string {
+	switch {
+	case kubelet.Major != server.Major:
+		if kubelet.Major < server.Major {
+			return StateTooOld
+		}
+		return StateTooNew
+	case kubelet.Minor > server.Minor:
+		return StateTooNew
+	case server.Minor-kubelet.Minor > MaxMinorSkew:
+		return StateTooOld
+	}
+	return StateSupported
+}
@@ -0,0 +1,51 @@
+package skew
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	for s, expected := range map[string]Version{
+		"v1.5.3":             {1, 5},
+		"1.6.0-beta.1+gke.0": {1, 6},
+		"v1.27.4-gke.100":    {1, 27},
+		"v1.10":              {1, 10},
+	} {
+		v, err := ParseVersion(s)
+		if err != nil || v != expected {
+			t.Errorf("%s: expected %v, got %v, %v", s, expected, v, err)
+		}
+	}
+	for _, s := range []string{"", "latest", "v1"} {
+		if _, err := ParseVersion(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestState(t *testing.T) {
+	tests := []struct {
+		apiServer, kubelet, expected string
+	}{
+		{"v1.7.0", "v1.5.3", StateSupported},
+		{"v1.5.3", "v1.5.3", StateSupported},
+		{"v1.8.1", "v1.5.3", StateTooOld},
+		{"v1.27.4", "v1.5.3", StateTooOld},
+		{"v1.5.3", "v1.6.0", StateTooNew},
+		{"v2.0.0", "v1.9.0", StateTooOld},
+		{"", "v1.5.3", StateUnknown},
+		{"v1.7.0", "latest", stateUnparseable},
+	}
+	for _, test := range tests {
+		if s := State(test.apiServer, test.kubelet); s != test.expected {
+			t.Errorf("API server %s, kubelet %s: expected %s, got %s", test.apiServer, test.kubelet, test.expected, s)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	if err := Check("v1.7.2", "v1.5.3"); err != nil {
+		t.Error(err)
+	}
+	if err := Check("v1.27.4", "v1.5.3"); err == nil {
+		t.Error("expected an error for a kubelet outside the skew")
+	}
+}
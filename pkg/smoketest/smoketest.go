@@ -0,0 +1,217 @@
+package smoketest
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	kcorev1 "k8s.io/client-go/pkg/api/v1"
+	batch "k8s.io/client-go/pkg/apis/batch/v1"
+)
+
+const (
+	DefaultImage    = "busybox:1.26"
+	DefaultHostPort = 31999
+	DefaultDNSName  = "kubernetes.default.svc.cluster.local"
+	DefaultTimeout  = 5 * time.Minute
+
+	namespace = "default"
+	label     = "kube-machine-smoke-test"
+	// jobNameLabel is set by the job controller on the pods of a job.
+	jobNameLabel = "job-name"
+
+	pollInterval = 5 * time.Second
+
+	// hostPortConflict is the reason of the kubelet rejecting a pod whose
+	// host port is already bound on the node.
+	hostPortConflict = "PodFitsHostPorts"
+)
+
+// Options configures the smoke test pod.
+type Options struct {
+	Image    string
+	HostPort int32
+	DNSName  string
+	Timeout  time.Duration
+}
+
+// Run verifies that the node is ready and can run workloads. It runs a job
+// on the node whose pod pulls its image, binds a host port and resolves a
+// cluster DNS name. The job and its pods are deleted afterwards.
+func Run(client kubernetes.Interface, nodeName string, opts Options) error {
+	opts = withDefaults(opts)
+	deadline := time.Now().Add(opts.Timeout)
+
+	log.Infof("Waiting for node %s to become ready...", nodeName)
+	err := poll(deadline, func() (bool, error) {
+		node, err := client.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		return nodeReady(node), nil
+	})
+	if err != nil {
+		return fmt.Errorf("Node %s did not become ready: %v", nodeName, err)
+	}
+
+	job, err := client.BatchV1().Jobs(namespace).Create(newJob(nodeName, opts))
+	if err != nil {
+		return fmt.Errorf("Failed to create smoke test job: %v", err)
+	}
+	defer func() {
+		// The pods of the job are deleted by the garbage collector.
+		propagation := metav1.DeletePropagationBackground
+		if err := client.BatchV1().Jobs(namespace).Delete(job.Name, &metav1.DeleteOptions{PropagationPolicy: &propagation}); err != nil {
+			log.Warnf("Failed to delete smoke test job %s: %v", job.Name, err)
+		}
+	}()
+
+	log.Infof("Running smoke test job %s on node %s...", job.Name, nodeName)
+	err = poll(deadline, func() (bool, error) {
+		pods, err := client.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: jobNameLabel + "=" + job.Name})
+		if err != nil {
+			return false, nil
+		}
+		return podsDone(pods.Items)
+	})
+	if err != nil {
+		return fmt.Errorf("Smoke test on node %s failed: %v", nodeName, err)
+	}
+	return nil
+}
+
+func withDefaults(opts Options) Options {
+	if opts.Image == "" {
+		opts.Image = DefaultImage
+	}
+	if opts.HostPort == 0 {
+		opts.HostPort = DefaultHostPort
+	}
+	if opts.DNSName == "" {
+		opts.DNSName = DefaultDNSName
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	return opts
+}
+
+func poll(deadline time.Time, f func() (bool, error)) error {
+	for {
+		done, err := f()
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("timed out")
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+func newJob(nodeName string, opts Options) *batch.Job {
+	one := int32(1)
+	// The pods of the job aren't retried past the timeout of the smoke test.
+	activeDeadline := int64(opts.Timeout / time.Second)
+	return &batch.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: label + "-",
+			Namespace:    namespace,
+			Labels: map[string]string{
+				label: nodeName,
+			},
+		},
+		Spec: batch.JobSpec{
+			Parallelism:           &one,
+			Completions:           &one,
+			ActiveDeadlineSeconds: &activeDeadline,
+			Template: kcorev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						label: nodeName,
+					},
+				},
+				Spec: newPodSpec(nodeName, opts),
+			},
+		},
+	}
+}
+
+func newPodSpec(nodeName string, opts Options) kcorev1.PodSpec {
+	return kcorev1.PodSpec{
+		NodeName:      nodeName,
+		RestartPolicy: kcorev1.RestartPolicyNever,
+		Containers: []kcorev1.Container{
+			{
+				Name:            "smoke-test",
+				Image:           opts.Image,
+				ImagePullPolicy: kcorev1.PullAlways,
+				Command:         []string{"nslookup", opts.DNSName},
+				Ports: []kcorev1.ContainerPort{
+					{
+						ContainerPort: opts.HostPort,
+						HostPort:      opts.HostPort,
+						Protocol:      kcorev1.ProtocolTCP,
+					},
+				},
+			},
+		},
+	}
+}
+
+func nodeReady(node *kcorev1.Node) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == kcorev1.NodeReady {
+			return c.Status == kcorev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// podsDone reports whether a pod of the smoke test job succeeded. The job
+// would replace a failed pod, but its failure is reported right away since
+// the replacement runs on the same node.
+func podsDone(pods []kcorev1.Pod) (bool, error) {
+	for i := range pods {
+		done, err := podDone(&pods[i])
+		if done || err != nil {
+			return done, err
+		}
+	}
+	return false, nil
+}
+
+// podDone reports whether the smoke test pod finished and fails early on
+// errors the pod won't recover from.
+func podDone(pod *kcorev1.Pod) (bool, error) {
+	switch pod.Status.Phase {
+	case kcorev1.PodSucceeded:
+		return true, nil
+	case kcorev1.PodFailed:
+		for _, s := range pod.Status.ContainerStatuses {
+			if t := s.State.Terminated; t != nil {
+				return false, fmt.Errorf("container exited with %d: %s %s", t.ExitCode, t.Reason, t.Message)
+			}
+		}
+		if pod.Status.Reason == hostPortConflict {
+			return false, fmt.Errorf("host port is in use: %s", pod.Status.Message)
+		}
+		return false, fmt.Errorf("pod failed: %s %s", pod.Status.Reason, pod.Status.Message)
+	}
+
+	for _, s := range pod.Status.ContainerStatuses {
+		if w := s.State.Waiting; w != nil {
+			switch w.Reason {
+			case "ErrImagePull", "ImagePullBackOff", "InvalidImageName":
+				return false, fmt.Errorf("failed to pull image %s: %s", s.Image, w.Message)
+			}
+		}
+	}
+	return false, nil
+}
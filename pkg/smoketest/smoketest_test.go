@@ -0,0 +1,130 @@
+package smoketest
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	kcorev1 "k8s.io/client-go/pkg/api/v1"
+)
+
+func TestNodeReady(t *testing.T) {
+	node := &kcorev1.Node{}
+	if nodeReady(node) {
+		t.Error("Expected node without conditions not to be ready")
+	}
+
+	node.Status.Conditions = []kcorev1.NodeCondition{
+		{Type: kcorev1.NodeOutOfDisk, Status: kcorev1.ConditionFalse},
+		{Type: kcorev1.NodeReady, Status: kcorev1.ConditionTrue},
+	}
+	if !nodeReady(node) {
+		t.Error("Expected node to be ready")
+	}
+}
+
+func TestPodDone(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  kcorev1.PodStatus
+		done    bool
+		wantErr bool
+	}{
+		{
+			name:   "pending",
+			status: kcorev1.PodStatus{Phase: kcorev1.PodPending},
+		},
+		{
+			name:   "succeeded",
+			status: kcorev1.PodStatus{Phase: kcorev1.PodSucceeded},
+			done:   true,
+		},
+		{
+			name: "dns lookup failed",
+			status: kcorev1.PodStatus{
+				Phase: kcorev1.PodFailed,
+				ContainerStatuses: []kcorev1.ContainerStatus{
+					{State: kcorev1.ContainerState{Terminated: &kcorev1.ContainerStateTerminated{ExitCode: 1, Reason: "Error"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "image pull failed",
+			status: kcorev1.PodStatus{
+				Phase: kcorev1.PodPending,
+				ContainerStatuses: []kcorev1.ContainerStatus{
+					{Image: "busybox:1.26", State: kcorev1.ContainerState{Waiting: &kcorev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "container creating",
+			status: kcorev1.PodStatus{
+				Phase: kcorev1.PodPending,
+				ContainerStatuses: []kcorev1.ContainerStatus{
+					{State: kcorev1.ContainerState{Waiting: &kcorev1.ContainerStateWaiting{Reason: "ContainerCreating"}}},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		done, err := podDone(&kcorev1.Pod{Status: test.status})
+		if done != test.done || (err != nil) != test.wantErr {
+			t.Errorf("%s: expected done=%v and error=%v, got %v and %v", test.name, test.done, test.wantErr, done, err)
+		}
+	}
+}
+
+func TestNewJob(t *testing.T) {
+	job := newJob("node-1", Options{Image: DefaultImage, HostPort: DefaultHostPort, DNSName: DefaultDNSName, Timeout: DefaultTimeout})
+	spec := job.Spec.Template.Spec
+	if spec.NodeName != "node-1" {
+		t.Errorf("Expected pod to be bound to node-1, got %q", spec.NodeName)
+	}
+	if spec.RestartPolicy != kcorev1.RestartPolicyNever {
+		t.Errorf("Expected the pod not to be restarted, got %q", spec.RestartPolicy)
+	}
+	if *job.Spec.Completions != 1 || *job.Spec.ActiveDeadlineSeconds != int64(DefaultTimeout/time.Second) {
+		t.Errorf("Expected a single completion within the timeout, got %+v", job.Spec)
+	}
+	if port := spec.Containers[0].Ports[0]; port.HostPort != DefaultHostPort {
+		t.Errorf("Expected host port %d, got %d", DefaultHostPort, port.HostPort)
+	}
+}
+
+func TestPodsDone(t *testing.T) {
+	pending := kcorev1.Pod{Status: kcorev1.PodStatus{Phase: kcorev1.PodPending}}
+	succeeded := kcorev1.Pod{Status: kcorev1.PodStatus{Phase: kcorev1.PodSucceeded}}
+	failed := kcorev1.Pod{Status: kcorev1.PodStatus{Phase: kcorev1.PodFailed}}
+
+	if done, err := podsDone(nil); done || err != nil {
+		t.Errorf("Expected a job without pods to be running, got %v and %v", done, err)
+	}
+	if done, err := podsDone([]kcorev1.Pod{pending, succeeded}); !done || err != nil {
+		t.Errorf("Expected a job with a succeeded pod to be done, got %v and %v", done, err)
+	}
+	if _, err := podsDone([]kcorev1.Pod{failed, pending}); err == nil {
+		t.Error("Expected the failed pod to fail the smoke test")
+	}
+}
+
+func TestHostPort(t *testing.T) {
+	opts := withDefaults(Options{})
+	if opts.HostPort != DefaultHostPort {
+		t.Errorf("Expected the default host port %d, got %d", DefaultHostPort, opts.HostPort)
+	}
+
+	spec := newPodSpec("node-1", withDefaults(Options{HostPort: 30080}))
+	port := spec.Containers[0].Ports[0]
+	if port.HostPort != 30080 || port.ContainerPort != 30080 || port.Protocol != kcorev1.ProtocolTCP {
+		t.Errorf("Expected the pod to bind TCP host port 30080, got %+v", port)
+	}
+
+	_, err := podDone(&kcorev1.Pod{Status: kcorev1.PodStatus{Phase: kcorev1.PodFailed, Reason: "PodFitsHostPorts"}})
+	if err == nil || !strings.Contains(err.Error(), "host port is in use") {
+		t.Errorf("Expected the host port conflict to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,366 @@
+package spec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/kubermatic/kube-machine/pkg/placement"
+	"github.com/kubermatic/kube-machine/pkg/topology"
+)
+
+type Action string
+
+const (
+	ActionCreate  Action = "create"
+	ActionUpdate  Action = "update"
+	ActionReplace Action = "replace"
+	ActionDelete  Action = "delete"
+
+	stateStopped = "Stopped"
+	stateError   = "Error"
+)
+
+// Current is the state of an existing machine.
+type Current struct {
+	Name   string
+	Driver string
+	State  string
+	// Applied is the fingerprint recorded by the last apply, it is nil for
+	// machines created outside of a spec.
+	Applied map[string]string
+}
+
+// Change is a planned operation on a machine. Updates record the
+// fingerprint of the machine, start it if Start is set and apply the
+// changed in place Options.
+type Change struct {
+	Action  Action   `json:"action"`
+	Machine string   `json:"machine"`
+	Start   bool     `json:"start,omitempty"`
+	Options []string `json:"options,omitempty"`
+	Reasons []string `json:"reasons"`
+}
+
+// Plan are the changes required to reach the spec.
+type Plan struct {
+	Spec    Spec     `json:"spec"`
+	Changes []Change `json:"changes"`
+}
+
+// Place chooses the zones of the machines with a placement. Existing
+// machines keep the zone they were applied with, new machines are placed
+// one after another by their placement, which sees the machines placed
+// before. Machines with a zone, e.g. of a saved plan, are left alone.
+func Place(desired *Spec, current []Current) {
+	byName := map[string]Current{}
+	for _, c := range current {
+		byName[c.Name] = c
+	}
+
+	counts := map[*placement.Config]map[string]int{}
+	var pending []int
+	for i, m := range desired.Machines {
+		if m.Placement == nil {
+			continue
+		}
+		zoneOption, _ := topology.ZoneOption(m.Driver)
+		if counts[m.Placement] == nil {
+			counts[m.Placement] = map[string]int{}
+		}
+		if desired.Machines[i].Options == nil {
+			desired.Machines[i].Options = map[string]interface{}{}
+			m.Options = desired.Machines[i].Options
+		}
+		if zone, ok := m.Options[zoneOption].(string); ok && zone != "" {
+			counts[m.Placement][zone]++
+			continue
+		}
+		if zone := byName[m.Name].Applied[zoneOption]; contains(m.Zones, zone) {
+			m.Options[zoneOption] = zone
+			counts[m.Placement][zone]++
+			continue
+		}
+		pending = append(pending, i)
+	}
+
+	for _, i := range pending {
+		m := &desired.Machines[i]
+		zoneOption, _ := topology.ZoneOption(m.Driver)
+		m.Placed = m.Placement.Choose(m.Driver, m.Zones, counts[m.Placement])
+		m.Options[zoneOption] = m.Placed.Zone
+		counts[m.Placement][m.Placed.Zone]++
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff plans the changes from the current machines to the spec. Only
+// machines applied from a spec are deleted if they are missing in the spec.
+func Diff(desired *Spec, current []Current) *Plan {
+	plan := &Plan{Spec: *desired, Changes: []Change{}}
+
+	byName := map[string]Current{}
+	for _, c := range current {
+		byName[c.Name] = c
+	}
+
+	for _, m := range desired.Machines {
+		c, exists := byName[m.Name]
+		if !exists {
+			plan.add(ActionCreate, m.Name, placed(m, "machine does not exist")...)
+			continue
+		}
+
+		replace, reasons, inPlace := optionChanges(m, c)
+		if len(replace) > 0 {
+			plan.add(ActionReplace, m.Name, placed(m, replace...)...)
+			continue
+		}
+
+		if c.Applied == nil {
+			reasons = append(reasons, "machine was created outside of the spec and will be adopted")
+		}
+		if c.State == stateStopped {
+			reasons = append(reasons, "machine is Stopped and will be started")
+		}
+		if len(reasons) > 0 {
+			plan.add(ActionUpdate, m.Name, reasons...)
+			change := &plan.Changes[len(plan.Changes)-1]
+			change.Start = c.State == stateStopped
+			change.Options = inPlace
+		}
+	}
+
+	desiredNames := map[string]bool{}
+	for _, m := range desired.Machines {
+		desiredNames[m.Name] = true
+	}
+	var deleted []string
+	for _, c := range current {
+		if c.Applied != nil && !desiredNames[c.Name] {
+			deleted = append(deleted, c.Name)
+		}
+	}
+	sort.Strings(deleted)
+	for _, name := range deleted {
+		plan.add(ActionDelete, name, "machine is no longer in the spec")
+	}
+
+	return plan
+}
+
+// placed adds the placement decision of the machine to the reasons.
+func placed(m Machine, reasons ...string) []string {
+	if m.Placed != nil {
+		reasons = append(reasons, m.Placed.String())
+	}
+	return reasons
+}
+
+// KeepProtected keeps the protected machines the plan deletes from a pool
+// and shrinks the pool by other machines instead: a machine of the pool the
+// plan creates isn't created, or the last unprotected machine of the pool in
+// the spec is deleted. pools holds the pools of the current machines, which
+// are protected if they are in protected.
+func (p *Plan) KeepProtected(pools map[string]string, protected map[string]bool) {
+	kept := map[string][]string{}
+	changes := []Change{}
+	for _, c := range p.Changes {
+		if pool := pools[c.Machine]; c.Action == ActionDelete && pool != "" && protected[c.Machine] {
+			kept[pool] = append(kept[pool], c.Machine)
+			continue
+		}
+		changes = append(changes, c)
+	}
+
+	// The machines created last are skipped first.
+	for i := len(changes) - 1; i >= 0; i-- {
+		m, _ := p.Machine(changes[i].Machine)
+		if changes[i].Action != ActionCreate || len(kept[m.Pool]) == 0 {
+			continue
+		}
+		kept[m.Pool] = kept[m.Pool][1:]
+		changes = append(changes[:i], changes[i+1:]...)
+	}
+
+	for i := len(p.Spec.Machines) - 1; i >= 0; i-- {
+		m := p.Spec.Machines[i]
+		_, exists := pools[m.Name]
+		if len(kept[m.Pool]) == 0 || !exists || protected[m.Name] {
+			continue
+		}
+		reason := fmt.Sprintf("pool %s shrinks by it instead of the protected %s", m.Pool, kept[m.Pool][0])
+		kept[m.Pool] = kept[m.Pool][1:]
+		// The delete replaces the other changes of the machine.
+		for j := range changes {
+			if changes[j].Machine == m.Name {
+				changes = append(changes[:j], changes[j+1:]...)
+				break
+			}
+		}
+		changes = append(changes, Change{Action: ActionDelete, Machine: m.Name, Reasons: []string{reason}})
+	}
+	p.Changes = changes
+}
+
+func (p *Plan) add(action Action, machine string, reasons ...string) {
+	p.Changes = append(p.Changes, Change{Action: action, Machine: machine, Reasons: reasons})
+}
+
+// InPlaceOptions are the options changed without replacing the machine.
+// protect is applied with the protection of the machine, the others only
+// affect how the machine is created. Changes of all other options require
+// a replacement, like the driver options the machine was created with.
+var InPlaceOptions = map[string]bool{
+	"protect":            true,
+	"cleanup-on-failure": true,
+	"dry-run":            true,
+	"skip-preflight":     true,
+	"init-timeout":       true,
+	"smoke-test":         true,
+	"smoke-test-image":   true,
+	"smoke-test-timeout": true,
+}
+
+// optionChanges returns the reasons for replacing the machine and for
+// updating it in place with the changed in place options.
+func optionChanges(m Machine, c Current) (replace, update, inPlace []string) {
+	if c.State == stateError {
+		return []string{"machine is in state Error, requires replacement"}, nil, nil
+	}
+	if c.Applied == nil {
+		if c.Driver != "" && c.Driver != m.Driver {
+			return []string{fmt.Sprintf("driver change from %s to %s requires replacement", c.Driver, m.Driver)}, nil, nil
+		}
+		return nil, nil, nil
+	}
+
+	desired := m.FingerprintLike(c.Applied)
+	for _, k := range ChangedKeys(c.Applied, desired) {
+		from, to := c.Applied[k], desired[k]
+		if k == "driver" {
+			replace = append(replace, fmt.Sprintf("driver change from %s to %s requires replacement", from, to))
+			continue
+		}
+		if Sensitive(from) || Sensitive(to) {
+			from, to = "(sensitive)", "(sensitive)"
+		}
+		if InPlaceOptions[k] {
+			update = append(update, fmt.Sprintf("%s change from %q to %q is applied in place", k, from, to))
+			inPlace = append(inPlace, k)
+			continue
+		}
+		replace = append(replace, fmt.Sprintf("%s change from %q to %q requires replacement", k, from, to))
+	}
+	return replace, update, inPlace
+}
+
+// ChangedKeys returns the sorted keys whose values differ between the
+// fingerprints.
+func ChangedKeys(from, to map[string]string) []string {
+	keys := map[string]bool{}
+	for k := range from {
+		keys[k] = true
+	}
+	for k := range to {
+		keys[k] = true
+	}
+	var changed []string
+	for k := range keys {
+		if from[k] != to[k] {
+			changed = append(changed, k)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// Sensitive reports whether the fingerprint value is the hash of a secret.
+func Sensitive(value string) bool {
+	return strings.HasPrefix(value, saltedPrefix) || strings.HasPrefix(value, sensitivePrefix)
+}
+
+// Empty reports whether the machines already match the spec.
+func (p *Plan) Empty() bool {
+	return len(p.Changes) == 0
+}
+
+// SameChanges reports whether both plans make the same changes.
+func (p *Plan) SameChanges(other *Plan) bool {
+	return reflect.DeepEqual(p.Changes, other.Changes)
+}
+
+var actionSymbols = map[Action]string{
+	ActionCreate:  "+",
+	ActionUpdate:  "~",
+	ActionReplace: "-/+",
+	ActionDelete:  "-",
+}
+
+// Print writes the changes with their reasons in a human readable form.
+func (p *Plan) Print(w io.Writer) {
+	if p.Empty() {
+		fmt.Fprintln(w, "No changes, the machines match the spec.")
+		return
+	}
+
+	counts := map[Action]int{}
+	for _, c := range p.Changes {
+		counts[c.Action]++
+		fmt.Fprintf(w, "%3s %s %s\n", actionSymbols[c.Action], c.Action, c.Machine)
+		for _, reason := range c.Reasons {
+			fmt.Fprintf(w, "      %s\n", reason)
+		}
+	}
+	fmt.Fprintf(w, "\nPlan: %d to create, %d to update, %d to replace, %d to delete.\n",
+		counts[ActionCreate], counts[ActionUpdate], counts[ActionReplace], counts[ActionDelete])
+}
+
+// Save writes the plan to a file only readable by the user, it contains the
+// options of the spec including secrets.
+func (p *Plan) Save(path string) error {
+	data, err := json.MarshalIndent(p, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// LoadPlan reads a plan written by Save.
+func LoadPlan(path string) (*Plan, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Plan{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(p); err != nil {
+		return nil, fmt.Errorf("Failed to parse plan %s: %v", path, err)
+	}
+	return p, nil
+}
+
+// Machine returns the desired state of the machine of the plan.
+func (p *Plan) Machine(name string) (Machine, bool) {
+	for _, m := range p.Spec.Machines {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return Machine{}, false
+}
@@ -0,0 +1,495 @@
+package spec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// SchemaID identifies the published schema of specs.
+const SchemaID = "https://github.com/kubermatic/kube-machine/spec.schema.json"
+
+// Schema returns the JSON schema of specs. The options of machines and
+// templates are restricted to the names if given, otherwise any option is
+// valid as they depend on the driver.
+func Schema(options []string) map[string]interface{} {
+	s := typeSchema(reflect.TypeOf(Spec{}))
+	s["$schema"] = "http://json-schema.org/draft-07/schema#"
+	s["$id"] = SchemaID
+	s["title"] = "kube-machine spec"
+	if options != nil {
+		names := append([]string{}, options...)
+		sort.Strings(names)
+		properties := s["properties"].(map[string]interface{})
+		for _, list := range []string{"machines", "templates"} {
+			item := properties[list].(map[string]interface{})["items"].(map[string]interface{})
+			option := item["properties"].(map[string]interface{})["options"].(map[string]interface{})
+			option["propertyNames"] = map[string]interface{}{"enum": names}
+		}
+	}
+	return s
+}
+
+// typeSchema returns the schema of the JSON encoding of the type. Fields
+// without omitempty are required and unknown fields are refused, so typos
+// fail instead of being ignored.
+func typeSchema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem())
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := strings.Split(f.Tag.Get("json"), ",")
+			if f.PkgPath != "" || tag[0] == "-" {
+				continue
+			}
+			name := tag[0]
+			if name == "" {
+				name = f.Name
+			}
+			properties[name] = typeSchema(f.Type)
+			if !contains(tag[1:], "omitempty") {
+				required = append(required, name)
+			}
+		}
+		s := map[string]interface{}{"type": "object", "properties": properties, "additionalProperties": false}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	return map[string]interface{}{}
+}
+
+// SchemaError is a violation of the schema at a position of the file.
+type SchemaError struct {
+	Path    string `json:"path"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
+
+func (e SchemaError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+	}
+	return fmt.Sprintf("%d:%d: %s: %s", e.Line, e.Column, e.Path, e.Message)
+}
+
+// SchemaErrors are all violations of the schema of a file.
+type SchemaErrors []SchemaError
+
+func (e SchemaErrors) Error() string {
+	return e.format("")
+}
+
+func (e SchemaErrors) Len() int      { return len(e) }
+func (e SchemaErrors) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e SchemaErrors) Less(i, j int) bool {
+	return e[i].Line < e[j].Line || (e[i].Line == e[j].Line && e[i].Column < e[j].Column)
+}
+
+// format returns the errors a line each, prefixed with the file so editors
+// jump to them.
+func (e SchemaErrors) format(file string) string {
+	var lines []string
+	for _, err := range e {
+		if file != "" {
+			lines = append(lines, file+":"+err.Error())
+		} else {
+			lines = append(lines, err.Error())
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ValidateSchema validates the YAML or JSON spec against the schema with
+// the options, returning SchemaErrors with the positions of the violations.
+func ValidateSchema(data []byte, options []string) error {
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return err
+	}
+	v := &validator{}
+	v.validate(Schema(options), doc, nil)
+	if len(v.errors) == 0 {
+		return nil
+	}
+	var errs SchemaErrors
+	for _, e := range v.errors {
+		line, column := locate(string(data), e.path)
+		errs = append(errs, SchemaError{Path: formatPath(e.path), Line: line, Column: column, Message: e.message})
+	}
+	sort.Stable(errs)
+	return errs
+}
+
+// Drivers returns the drivers named by the machines and templates of the
+// YAML or JSON spec, before templates are applied.
+func Drivers(data []byte) ([]string, error) {
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	var drivers []string
+	root, _ := doc.(map[string]interface{})
+	for _, list := range []string{"machines", "templates"} {
+		items, _ := root[list].([]interface{})
+		for _, item := range items {
+			m, _ := item.(map[string]interface{})
+			if driver, ok := m["driver"].(string); ok && driver != "" && !contains(drivers, driver) {
+				drivers = append(drivers, driver)
+			}
+		}
+	}
+	sort.Strings(drivers)
+	return drivers, nil
+}
+
+func decodeJSON(data []byte) (interface{}, error) {
+	data, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+type pathError struct {
+	path    []interface{}
+	message string
+}
+
+// validator checks documents against the keywords of the schemas
+// typeSchema generates.
+type validator struct {
+	errors []pathError
+}
+
+func (v *validator) fail(path []interface{}, format string, args ...interface{}) {
+	v.errors = append(v.errors, pathError{append([]interface{}{}, path...), fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) validate(schema map[string]interface{}, value interface{}, path []interface{}) {
+	// Null is decoded as the zero value like an absent field.
+	if value == nil {
+		return
+	}
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			v.fail(path, "expected an object, got %s", describe(value))
+			return
+		}
+		v.validateObject(schema, object, path)
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			v.fail(path, "expected a list, got %s", describe(value))
+			return
+		}
+		itemSchema, _ := schema["items"].(map[string]interface{})
+		for i, item := range items {
+			v.validate(itemSchema, item, append(path, i))
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			v.fail(path, "expected a string, got %s", describe(value))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			v.fail(path, "expected true or false, got %s", describe(value))
+		}
+	case "integer":
+		n, ok := value.(json.Number)
+		if _, err := n.Int64(); !ok || err != nil {
+			v.fail(path, "expected an integer, got %s", describe(value))
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			v.fail(path, "expected a number, got %s", describe(value))
+		}
+	}
+}
+
+func (v *validator) validateObject(schema map[string]interface{}, object map[string]interface{}, path []interface{}) {
+	properties, _ := schema["properties"].(map[string]interface{})
+	required, _ := schema["required"].([]string)
+	for _, name := range required {
+		if _, ok := object[name]; !ok {
+			v.fail(path, "missing field %s", name)
+		}
+	}
+
+	var names []string
+	if enum, ok := schema["propertyNames"].(map[string]interface{}); ok {
+		names, _ = enum["enum"].([]string)
+	}
+	keys := make([]string, 0, len(object))
+	for k := range object {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if names != nil && !contains(names, k) {
+			v.fail(append(path, k), "unknown option%s", suggest(k, names))
+			continue
+		}
+		if property, ok := properties[k].(map[string]interface{}); ok {
+			v.validate(property, object[k], append(path, k))
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				known := make([]string, 0, len(properties))
+				for name := range properties {
+					known = append(known, name)
+				}
+				v.fail(append(path, k), "unknown field%s", suggest(k, known))
+			}
+		case map[string]interface{}:
+			v.validate(additional, object[k], append(path, k))
+		}
+	}
+}
+
+func describe(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "a list"
+	case string:
+		return strconv.Quote(value)
+	}
+	return fmt.Sprint(value)
+}
+
+// suggest returns a hint at the closest of the names if the name is likely
+// a typo of it.
+func suggest(name string, names []string) string {
+	best, distance := "", len(name)/3+1
+	for _, candidate := range names {
+		if d := editDistance(strings.ToLower(name), strings.ToLower(candidate)); d < distance || (d == distance && best != "" && candidate < best) {
+			best, distance = candidate, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %s?", best)
+}
+
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minimum(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+func minimum(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+func formatPath(path []interface{}) string {
+	var b bytes.Buffer
+	for _, p := range path {
+		switch p := p.(type) {
+		case int:
+			fmt.Fprintf(&b, "[%d]", p)
+		default:
+			if b.Len() > 0 {
+				b.WriteByte('.')
+			}
+			fmt.Fprint(&b, p)
+		}
+	}
+	return b.String()
+}
+
+// yamlLine is a line of a block style YAML document. The key starts at
+// keyColumn, items have the column of their dash, -1 otherwise.
+type yamlLine struct {
+	blank     bool
+	indent    int
+	dash      int
+	key       string
+	keyColumn int
+}
+
+var keyPattern = regexp.MustCompile(`^("[^"]*"|'[^']*'|[^\s:#'"-][^:#]*?)\s*:(\s|$)`)
+
+func parseLines(text string) []yamlLine {
+	var lines []yamlLine
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		l := yamlLine{indent: len(line) - len(trimmed), dash: -1}
+		if strings.TrimSpace(trimmed) == "" || strings.HasPrefix(trimmed, "#") {
+			l.blank = true
+			lines = append(lines, l)
+			continue
+		}
+		content := l.indent
+		if trimmed == "-" || strings.HasPrefix(trimmed, "- ") {
+			l.dash = l.indent
+			rest := strings.TrimLeft(trimmed[1:], " ")
+			content += len(trimmed) - len(rest)
+			trimmed = rest
+		}
+		l.keyColumn = content
+		if m := keyPattern.FindStringSubmatch(trimmed); m != nil {
+			l.key = strings.Trim(m[1], `"'`)
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+// locate returns the line and column of the path in a block style YAML
+// document. Paths into flow style and JSON documents are located as far as
+// their keys are found.
+func locate(text string, path []interface{}) (int, int) {
+	lines := parseLines(text)
+	line, column := 1, 1
+	start, end := 0, len(lines)
+	for _, p := range path {
+		i := -1
+		switch p := p.(type) {
+		case string:
+			if i = findKey(lines, start, end, p); i >= 0 {
+				column = lines[i].keyColumn + 1
+				start, end = i+1, keyEnd(lines, i, end)
+			}
+		case int:
+			if i = findItem(lines, start, end, p); i >= 0 {
+				column = lines[i].dash + 1
+				start, end = i, itemEnd(lines, i, end)
+			}
+		}
+		if i < 0 {
+			break
+		}
+		line = i + 1
+	}
+	return line, column
+}
+
+// first returns the first line of the block which is not blank.
+func first(lines []yamlLine, start, end int) int {
+	for i := start; i < end; i++ {
+		if !lines[i].blank {
+			return i
+		}
+	}
+	return -1
+}
+
+// findKey returns the line of the key among the keys of the mapping of the
+// block, which are in the column of its first key.
+func findKey(lines []yamlLine, start, end int, key string) int {
+	f := -1
+	for i := start; i < end && f < 0; i++ {
+		if !lines[i].blank && lines[i].key != "" {
+			f = i
+		}
+	}
+	if f < 0 {
+		return -1
+	}
+	for i := f; i < end; i++ {
+		if !lines[i].blank && lines[i].keyColumn == lines[f].keyColumn && lines[i].key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// findItem returns the line of the item with the index of the sequence of
+// the block, whose dashes are in the column of its first dash.
+func findItem(lines []yamlLine, start, end int, index int) int {
+	f := first(lines, start, end)
+	if f < 0 || lines[f].dash < 0 {
+		return -1
+	}
+	for i := f; i < end; i++ {
+		if !lines[i].blank && lines[i].dash == lines[f].dash {
+			if index == 0 {
+				return i
+			}
+			index--
+		}
+	}
+	return -1
+}
+
+// keyEnd returns the end of the value of the key on the line, sequences
+// may be in the column of the key.
+func keyEnd(lines []yamlLine, i, end int) int {
+	for j := i + 1; j < end; j++ {
+		l := lines[j]
+		if !l.blank && (l.indent < lines[i].keyColumn || (l.indent == lines[i].keyColumn && l.dash < 0)) {
+			return j
+		}
+	}
+	return end
+}
+
+// itemEnd returns the end of the item on the line.
+func itemEnd(lines []yamlLine, i, end int) int {
+	for j := i + 1; j < end; j++ {
+		if !lines[j].blank && lines[j].indent <= lines[i].dash {
+			return j
+		}
+	}
+	return end
+}
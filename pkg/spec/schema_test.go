@@ -0,0 +1,136 @@
+package spec
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateSchema(t *testing.T) {
+	data := []byte(`
+templates:
+- name: small
+  driver: digitalocean
+  options:
+    digitalocean-size: 2gb
+
+machines:
+- name: node-1
+  extends: small
+- name: node-{{.index}}
+  extends: small
+  count: many
+  kubletVersion: 1.6.2
+  registries:
+    mirrors: https://mirror.example.com
+    auth:
+    - registry: registry.example.com
+      username: robot
+  options:
+    digitalocean-sise: 4gb
+`)
+
+	err := ValidateSchema(data, []string{"digitalocean-size", "engine-label"})
+	errs, ok := err.(SchemaErrors)
+	if !ok {
+		t.Fatalf("Expected schema errors, got %v", err)
+	}
+	expected := SchemaErrors{
+		{Path: "machines[1].count", Line: 13, Column: 3, Message: `expected an integer, got "many"`},
+		{Path: "machines[1].kubletVersion", Line: 14, Column: 3, Message: "unknown field"},
+		{Path: "machines[1].registries.mirrors", Line: 16, Column: 5, Message: `expected a list, got "https://mirror.example.com"`},
+		{Path: "machines[1].registries.auth[0]", Line: 18, Column: 5, Message: "missing field passwordFile"},
+		{Path: "machines[1].options.digitalocean-sise", Line: 21, Column: 5, Message: "unknown option, did you mean digitalocean-size?"},
+	}
+	if !reflect.DeepEqual(errs, expected) {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, errs)
+	}
+
+	if err := ValidateSchema([]byte(testSpec), nil); err != nil {
+		t.Errorf("Expected any options to be valid without option names: %v", err)
+	}
+	if _, err := Parse([]byte("machines:\n- name: node-1\n  drvier: libvirt\n")); err == nil || !strings.Contains(err.Error(), "3:3: machines[0].drvier: unknown field, did you mean driver?") {
+		t.Errorf("Expected Parse to validate the schema, got %v", err)
+	}
+}
+
+func TestLocateJSON(t *testing.T) {
+	data := []byte(`{
+  "machines": [
+    {
+      "name": "node-1",
+      "driver": "libvirt",
+      "zone": "a"
+    }
+  ]
+}`)
+	err := ValidateSchema(data, nil)
+	errs, ok := err.(SchemaErrors)
+	if !ok || len(errs) != 1 {
+		t.Fatalf("Expected a schema error, got %v", err)
+	}
+	// Items of JSON arrays are not located, the error is reported at the
+	// closest parent found.
+	if errs[0].Line != 2 || errs[0].Path != "machines[0].zone" {
+		t.Errorf("Unexpected error %+v", errs[0])
+	}
+}
+
+func TestLoadChecked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spec.yaml")
+	if err := ioutil.WriteFile(path, []byte(testSpec), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var drivers []string
+	_, err = LoadChecked(path, func(d []string) ([]string, error) {
+		drivers = d
+		return []string{"digitalocean-size", "digitalocean-access-token", "digitalocean-volume"}, nil
+	})
+	if !reflect.DeepEqual(drivers, []string{"digitalocean", "libvirt"}) {
+		t.Errorf("Unexpected drivers %v", drivers)
+	}
+	if err == nil || !strings.Contains(err.Error(), path+":13:5: machines[1].options.libvirt-memory: unknown option") {
+		t.Errorf("Expected the unknown option with its position, got %v", err)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	data, err := json.Marshal(Schema(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Required   []string `json:"required"`
+		Properties struct {
+			Machines struct {
+				Items struct {
+					Required             []string               `json:"required"`
+					Properties           map[string]interface{} `json:"properties"`
+					AdditionalProperties bool                   `json:"additionalProperties"`
+				} `json:"items"`
+			} `json:"machines"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+	machine := schema.Properties.Machines.Items
+	if !reflect.DeepEqual(schema.Required, []string{"machines"}) || !reflect.DeepEqual(machine.Required, []string{"name"}) || machine.AdditionalProperties {
+		t.Errorf("Unexpected schema %s", data)
+	}
+	for _, field := range []string{"extends", "count", "pool", "zones", "placement", "registries"} {
+		if _, ok := machine.Properties[field]; !ok {
+			t.Errorf("Expected field %s in the schema of machines", field)
+		}
+	}
+}
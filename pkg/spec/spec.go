@@ -0,0 +1,264 @@
+package spec
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/kubermatic/kube-machine/pkg/audit"
+	"github.com/kubermatic/kube-machine/pkg/claims"
+	"github.com/kubermatic/kube-machine/pkg/placement"
+)
+
+// Spec is the declarative description of the machines of a cluster.
+type Spec struct {
+	// Variables are available in the templates and machines.
+	Variables map[string]interface{} `json:"variables,omitempty"`
+	Templates []Template             `json:"templates,omitempty"`
+	Machines  []Machine              `json:"machines"`
+	// Claims assign machines of shared pools to teams.
+	Claims []claims.Claim `json:"claims,omitempty"`
+}
+
+// Machine is the desired state of a machine, the options are the flags of
+// kube-machine create without the leading dashes.
+type Machine struct {
+	Name    string                 `json:"name"`
+	Driver  string                 `json:"driver,omitempty"`
+	Options map[string]interface{} `json:"options,omitempty"`
+
+	// Extends names the template the machine inherits the driver and
+	// options from.
+	Extends   string                 `json:"extends,omitempty"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+	// Count expands the machine into count machines with the variable
+	// index running from 1 to count. The name needs to contain the index.
+	Count int `json:"count,omitempty"`
+	// Pool names the node pool of the machines, they get the engine label
+	// pool=<pool>. The controller scales pools according to their scaling.
+	Pool    string    `json:"pool,omitempty"`
+	Scaling []Scaling `json:"scaling,omitempty"`
+	// Zones spreads the machines round-robin by index across the zones of
+	// the driver. Removing the machines with the highest index on scale
+	// down keeps them balanced, changing the zones replaces the machines
+	// which move to another zone.
+	Zones []string `json:"zones,omitempty"`
+	// Placement scores the zones instead of spreading the machines, each
+	// new machine lands in the zone with the best score and existing
+	// machines keep their zone. Placed records the decision in the plan.
+	Placement *placement.Config   `json:"placement,omitempty"`
+	Placed    *placement.Decision `json:"placed,omitempty"`
+	// Registries configures the image registries of the machines, it
+	// replaces the registries of the template.
+	Registries *Registries `json:"registries,omitempty"`
+}
+
+// Registries are the registry mirrors, insecure registries and pull
+// credentials of the engine, they are passed on as the engine-registry-mirror,
+// engine-insecure-registry and registry-auth options.
+type Registries struct {
+	Mirrors  []string       `json:"mirrors,omitempty"`
+	Insecure []string       `json:"insecure,omitempty"`
+	Auth     []RegistryAuth `json:"auth,omitempty"`
+}
+
+// RegistryAuth is the pull credential of a registry. The password is read
+// from the file on the machine running kube-machine, so it isn't part of
+// the spec.
+type RegistryAuth struct {
+	Registry     string `json:"registry"`
+	Username     string `json:"username"`
+	PasswordFile string `json:"passwordFile"`
+}
+
+// Scaling sets the count of a pool from the time the schedule fires until
+// another scaling of the pool fires.
+type Scaling struct {
+	// Schedule is a cron expression in the local time of the controller.
+	Schedule string `json:"schedule"`
+	Count    int    `json:"count"`
+}
+
+// OptionNames returns the valid options of machines using the drivers.
+type OptionNames func(drivers []string) ([]string, error)
+
+// Load reads and validates the spec of a YAML or JSON file.
+func Load(path string) (*Spec, error) {
+	return LoadChecked(path, nil)
+}
+
+// LoadChecked is Load refusing the options of machines and templates which
+// are not among the option names of the drivers of the spec.
+func LoadChecked(path string, optionNames OptionNames) (*Spec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var options []string
+	if optionNames != nil {
+		drivers, err := Drivers(data)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse spec %s: %v", path, err)
+		}
+		if options, err = optionNames(drivers); err != nil {
+			return nil, err
+		}
+	}
+	s, err := parse(data, options)
+	if errs, ok := err.(SchemaErrors); ok {
+		return nil, fmt.Errorf("Invalid spec %s:\n%s", path, errs.format(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse spec %s: %v", path, err)
+	}
+	return s, nil
+}
+
+// Parse parses and validates a YAML or JSON spec. Numbers are kept as
+// json.Number so they can be passed on as the flag type of the driver.
+func Parse(data []byte) (*Spec, error) {
+	return parse(data, nil)
+}
+
+func parse(data []byte, options []string) (*Spec, error) {
+	if err := ValidateSchema(data, options); err != nil {
+		return nil, err
+	}
+	data, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spec{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(s); err != nil {
+		return nil, err
+	}
+	if err := s.expand(); err != nil {
+		return nil, err
+	}
+	return s, s.Validate()
+}
+
+// Validate checks that all machines have a unique name and a driver and
+// that the claims are valid and claim existing pools.
+func (s *Spec) Validate() error {
+	names := map[string]bool{}
+	for i, m := range s.Machines {
+		if m.Name == "" {
+			return fmt.Errorf("Machine %d has no name", i)
+		}
+		if m.Driver == "" {
+			return fmt.Errorf("Machine %s has no driver", m.Name)
+		}
+		if names[m.Name] {
+			return fmt.Errorf("Machine %s is specified more than once", m.Name)
+		}
+		names[m.Name] = true
+	}
+	if err := claims.Validate(s.Claims); err != nil {
+		return err
+	}
+	pools := map[string]bool{}
+	for _, m := range s.Machines {
+		pools[m.Pool] = true
+	}
+	for _, c := range s.Claims {
+		if !pools[c.Pool] {
+			return fmt.Errorf("Claim %s: pool %s has no machines", c.Name, c.Pool)
+		}
+	}
+	return nil
+}
+
+// Fingerprint returns the driver and options of the machine in the form
+// recorded on applied machines. Secrets are replaced by a hash salted per
+// value, so changes can be detected without storing them or letting the
+// recorded hashes be matched against guessed values.
+func (m Machine) Fingerprint() map[string]string {
+	return m.FingerprintLike(nil)
+}
+
+// FingerprintLike returns the fingerprint of the machine with the secrets
+// hashed with the salts of the previous fingerprint, so it can be compared
+// to it. Secrets missing in the previous fingerprint get a new salt.
+func (m Machine) FingerprintLike(previous map[string]string) map[string]string {
+	fingerprint := map[string]string{"driver": m.Driver}
+	for k, v := range m.Options {
+		value := formatValue(v)
+		if audit.IsSecret(k, value) {
+			value = hashSecret(value, previous[k])
+		}
+		fingerprint[k] = value
+	}
+	return fingerprint
+}
+
+// Values returns the driver and options of the machine with the secrets in
+// the clear, to compare machines in memory. It must not be stored.
+func (m Machine) Values() map[string]string {
+	values := map[string]string{"driver": m.Driver}
+	for k, v := range m.Options {
+		values[k] = formatValue(v)
+	}
+	return values
+}
+
+const (
+	// sensitivePrefix marks the unsalted hashes recorded by earlier
+	// versions, they are still compared.
+	sensitivePrefix = "sha256:"
+	saltedPrefix    = "hmac-sha256:"
+	saltLength      = 16
+)
+
+// hashSecret returns the salted hash of the secret, with the salt of the
+// previous hash if it is one.
+func hashSecret(value, previous string) string {
+	if strings.HasPrefix(previous, sensitivePrefix) {
+		sum := sha256.Sum256([]byte(value))
+		return sensitivePrefix + hex.EncodeToString(sum[:])
+	}
+
+	var salt []byte
+	if parts := strings.SplitN(strings.TrimPrefix(previous, saltedPrefix), ":", 2); strings.HasPrefix(previous, saltedPrefix) && len(parts) == 2 {
+		salt, _ = hex.DecodeString(parts[0])
+	}
+	if len(salt) == 0 {
+		salt = newSalt()
+	}
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(value))
+	return saltedPrefix + hex.EncodeToString(salt) + ":" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newSalt() []byte {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return []byte(fmt.Sprintf("%x", time.Now().UnixNano()))
+	}
+	return salt
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			values = append(values, fmt.Sprint(item))
+		}
+		return strings.Join(values, ",")
+	}
+	return fmt.Sprint(v)
+}
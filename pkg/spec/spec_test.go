@@ -0,0 +1,451 @@
+package spec
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSpec = `
+machines:
+- name: node-1
+  driver: digitalocean
+  options:
+    digitalocean-size: 2gb
+    digitalocean-access-token: abc
+    digitalocean-volume:
+    - size=50,mount=/var/lib/kubelet
+- name: node-2
+  driver: libvirt
+  options:
+    libvirt-memory: 2048
+`
+
+func TestParse(t *testing.T) {
+	s, err := Parse([]byte(testSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Machines) != 2 || s.Machines[1].Driver != "libvirt" {
+		t.Fatalf("Unexpected spec %+v", s)
+	}
+	if memory := s.Machines[1].Options["libvirt-memory"]; formatValue(memory) != "2048" {
+		t.Errorf("Unexpected memory %#v", memory)
+	}
+
+	fingerprint := s.Machines[0].Fingerprint()
+	if fingerprint["driver"] != "digitalocean" || fingerprint["digitalocean-volume"] != "size=50,mount=/var/lib/kubelet" {
+		t.Errorf("Unexpected fingerprint %v", fingerprint)
+	}
+	token := fingerprint["digitalocean-access-token"]
+	if !strings.HasPrefix(token, saltedPrefix) {
+		t.Errorf("Expected the token to be hashed, got %q", token)
+	}
+	if other := s.Machines[0].Fingerprint()["digitalocean-access-token"]; other == token {
+		t.Errorf("Expected the hashes to be salted, got %q twice", token)
+	}
+	if like := s.Machines[0].FingerprintLike(fingerprint)["digitalocean-access-token"]; like != token {
+		t.Errorf("Expected the hash %q with the salt of the previous fingerprint, got %q", token, like)
+	}
+	legacy := map[string]string{"digitalocean-access-token": sensitivePrefix + "old"}
+	if like := s.Machines[0].FingerprintLike(legacy)["digitalocean-access-token"]; !strings.HasPrefix(like, sensitivePrefix) {
+		t.Errorf("Expected an unsalted hash to compare with an unsalted one, got %q", like)
+	}
+}
+
+const testTemplateSpec = `
+variables:
+  region: fra1
+templates:
+- name: base
+  driver: digitalocean
+  options:
+    digitalocean-region: "{{ .region }}"
+    digitalocean-image: ubuntu-16-04-x64
+    digitalocean-size: 2gb
+- name: worker
+  extends: base
+  options:
+    digitalocean-tags:
+    - "pool={{ .pool }}"
+    - "node={{ .index }}"
+machines:
+- name: "{{ .pool }}-{{ .index }}"
+  extends: worker
+  count: 2
+  variables:
+    pool: blue
+  options:
+    digitalocean-size: 4gb
+- name: master
+  extends: base
+`
+
+func TestParseTemplates(t *testing.T) {
+	s, err := Parse([]byte(testTemplateSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Templates) != 0 || len(s.Machines) != 3 {
+		t.Fatalf("Unexpected spec %+v", s)
+	}
+
+	expected := Machine{
+		Name:   "blue-2",
+		Driver: "digitalocean",
+		Options: map[string]interface{}{
+			"digitalocean-region": "fra1",
+			"digitalocean-image":  "ubuntu-16-04-x64",
+			"digitalocean-size":   "4gb",
+			"digitalocean-tags":   []interface{}{"pool=blue", "node=2"},
+		},
+	}
+	if !reflect.DeepEqual(s.Machines[1], expected) {
+		t.Errorf("Expected %+v, got %+v", expected, s.Machines[1])
+	}
+	if s.Machines[0].Name != "blue-1" || s.Machines[2].Name != "master" || s.Machines[2].Options["digitalocean-size"] != "2gb" {
+		t.Errorf("Unexpected machines %+v", s.Machines)
+	}
+}
+
+const testScalingSpec = `
+machines:
+- name: "dev-{{ .index }}"
+  driver: digitalocean
+  pool: dev
+  count: 3
+  options:
+    engine-label: zone=a
+  scaling:
+  - schedule: "0 20 * * 1-5"
+    count: 0
+  - schedule: "0 8 * * 1-5"
+    count: 3
+`
+
+func TestParseScaling(t *testing.T) {
+	defer func() { now = time.Now }()
+
+	for _, test := range []struct {
+		time  string
+		count int
+	}{
+		{"2017-03-06T07:59:00Z", 0},
+		{"2017-03-06T08:00:00Z", 3},
+		{"2017-03-06T19:59:00Z", 3},
+		{"2017-03-04T12:00:00Z", 0},
+	} {
+		at, _ := time.Parse(time.RFC3339, test.time)
+		now = func() time.Time { return at }
+
+		s, err := Parse([]byte(testScalingSpec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(s.Machines) != test.count {
+			t.Errorf("Expected %d machines at %s, got %d", test.count, test.time, len(s.Machines))
+			continue
+		}
+		for _, m := range s.Machines {
+			labels := m.Options["engine-label"]
+			if m.Pool != "dev" || !reflect.DeepEqual(labels, []interface{}{"zone=a", "pool=dev"}) {
+				t.Errorf("Unexpected pool %q and labels %v", m.Pool, labels)
+			}
+		}
+	}
+}
+
+func TestParseClaims(t *testing.T) {
+	s, err := Parse([]byte(`
+machines:
+- name: shared-{{.index}}
+  driver: libvirt
+  pool: shared
+  count: 2
+- name: other-{{.index}}
+  driver: libvirt
+  pool: other
+  count: 2
+claims:
+- name: team-a
+  pool: shared
+  count: 2
+  labels:
+    team: a
+- name: team-b
+  pool: shared
+  count: 1
+  taints: ["team=b:NoSchedule"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, m := range s.Machines {
+		names = append(names, m.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"shared-1", "shared-2", "shared-3", "other-1", "other-2"}) {
+		t.Errorf("Expected the shared pool to grow to the claimed machines, got %v", names)
+	}
+	if len(s.Claims) != 2 || s.Claims[0].Labels["team"] != "a" {
+		t.Errorf("Unexpected claims %+v", s.Claims)
+	}
+}
+
+func TestParseZones(t *testing.T) {
+	s, err := Parse([]byte(`
+machines:
+- name: "worker-{{ .index }}-{{ .zone }}"
+  driver: google
+  count: 4
+  zones: [us-central1-a, us-central1-b, us-central1-c]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var zones []interface{}
+	for _, m := range s.Machines {
+		zones = append(zones, m.Options["google-zone"])
+	}
+	expected := []interface{}{"us-central1-a", "us-central1-b", "us-central1-c", "us-central1-a"}
+	if !reflect.DeepEqual(zones, expected) {
+		t.Errorf("Expected zones %v, got %v", expected, zones)
+	}
+	if s.Machines[1].Name != "worker-2-us-central1-b" {
+		t.Errorf("Unexpected name %s", s.Machines[1].Name)
+	}
+}
+
+func TestPlace(t *testing.T) {
+	s, err := Parse([]byte(`
+machines:
+- name: "worker-{{ .index }}"
+  driver: google
+  count: 3
+  zones: [us-central1-a, us-central1-b]
+  placement:
+    scorers:
+    - type: static
+      values: {us-central1-a: 2, us-central1-b: 1}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := []Current{{Name: "worker-1", Driver: "google", State: "Running", Applied: map[string]string{"google-zone": "us-central1-a"}}}
+	Place(s, current)
+
+	var zones []interface{}
+	for _, m := range s.Machines {
+		zones = append(zones, m.Options["google-zone"])
+	}
+	expected := []interface{}{"us-central1-a", "us-central1-b", "us-central1-b"}
+	if !reflect.DeepEqual(zones, expected) {
+		t.Errorf("Expected zones %v, got %v", expected, zones)
+	}
+	if s.Machines[0].Placed != nil || s.Machines[1].Placed == nil {
+		t.Errorf("Expected only new machines to be placed, got %+v, %+v", s.Machines[0].Placed, s.Machines[1].Placed)
+	}
+
+	current[0].Applied = s.Machines[0].Fingerprint()
+	plan := Diff(s, current)
+	if len(plan.Changes) != 2 || len(plan.Changes[0].Reasons) != 2 {
+		t.Fatalf("Unexpected changes %+v", plan.Changes)
+	}
+	if reason := plan.Changes[0].Reasons[1]; reason != "placed in us-central1-b with the lowest score (us-central1-a=1, us-central1-b=0.5)" {
+		t.Errorf("Unexpected placement reason %q", reason)
+	}
+}
+
+func TestParseRegistries(t *testing.T) {
+	s, err := Parse([]byte(`
+templates:
+- name: base
+  driver: libvirt
+  registries:
+    mirrors: [mirror.example.com]
+    auth:
+    - {registry: quay.io, username: bot, passwordFile: /etc/kube-machine/quay}
+machines:
+- name: node-1
+  extends: base
+  options:
+    engine-registry-mirror: cache.local
+- name: node-2
+  extends: base
+  registries:
+    insecure: [registry.local:5000]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node1 := s.Machines[0].Options
+	if expected := []interface{}{"cache.local", "mirror.example.com"}; !reflect.DeepEqual(node1["engine-registry-mirror"], expected) {
+		t.Errorf("Expected mirrors %v, got %v", expected, node1["engine-registry-mirror"])
+	}
+	if expected := []interface{}{"quay.io=bot:/etc/kube-machine/quay"}; !reflect.DeepEqual(node1["registry-auth"], expected) {
+		t.Errorf("Expected auth %v, got %v", expected, node1["registry-auth"])
+	}
+
+	node2 := s.Machines[1].Options
+	if _, ok := node2["engine-registry-mirror"]; ok {
+		t.Errorf("Expected the registries of the machine to replace the template, got %v", node2)
+	}
+	if expected := []interface{}{"registry.local:5000"}; !reflect.DeepEqual(node2["engine-insecure-registry"], expected) {
+		t.Errorf("Expected insecure registries %v, got %v", expected, node2["engine-insecure-registry"])
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, data := range []string{
+		"machines: []",
+		"machines:\n- driver: libvirt",
+		"machines:\n- name: node-1",
+		"machines:\n- {name: node-1, driver: libvirt}\n- {name: node-1, driver: libvirt}",
+		"machines:\n- {name: node-1, extends: base}",
+		"templates:\n- {name: a, extends: b}\n- {name: b, extends: a}\nmachines:\n- {name: node-1, extends: a}",
+		"machines:\n- {name: node-1, driver: libvirt, count: 2}",
+		"machines:\n- {name: \"{{ .pool }}\", driver: libvirt}",
+		"machines:\n- {name: node-1, driver: libvirt, scaling: [{schedule: \"0 8 * * *\", count: 1}]}",
+		"machines:\n- {name: node-1, driver: digitalocean, zones: [fra1]}",
+		"machines:\n- {name: node-1, driver: libvirt, registries: {auth: [{registry: quay.io}]}}",
+		"machines:\n- {name: node-1, driver: libvirt, pool: a, scaling: [{schedule: \"0 8 * * *\", count: 2}]}",
+		"machines:\n- {name: \"n{{ .index }}\", driver: libvirt, pool: a, scaling: [{schedule: \"0 25 * * *\", count: 2}]}",
+		"machines:\n- {name: \"n{{ .index }}\", driver: libvirt, pool: a}\nclaims:\n- {name: team-a, pool: b, count: 1}",
+		"machines:\n- {name: \"n{{ .index }}\", driver: libvirt, pool: a}\nclaims:\n- {name: team-a, pool: a, count: 1, taints: [team]}",
+	} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("Expected an error for %q", data)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	desired, err := Parse([]byte(testSpec + `
+- name: node-3
+  driver: libvirt
+- name: node-4
+  driver: libvirt
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed := desired.Machines[0].Fingerprint()
+	changed["digitalocean-size"] = "1gb"
+	changed["digitalocean-access-token"] = sensitivePrefix + "old"
+	current := []Current{
+		{Name: "node-1", Driver: "digitalocean", State: "Running", Applied: changed},
+		{Name: "node-2", Driver: "libvirt", State: "Stopped", Applied: desired.Machines[1].Fingerprint()},
+		{Name: "node-3", Driver: "libvirt", State: "Running"},
+		{Name: "node-5", Driver: "libvirt", State: "Running", Applied: map[string]string{"driver": "libvirt"}},
+		{Name: "unmanaged", Driver: "libvirt", State: "Running"},
+	}
+
+	plan := Diff(desired, current)
+	expected := []Change{
+		{Action: ActionReplace, Machine: "node-1", Reasons: []string{
+			`digitalocean-access-token change from "(sensitive)" to "(sensitive)" requires replacement`,
+			`digitalocean-size change from "1gb" to "2gb" requires replacement`,
+		}},
+		{Action: ActionUpdate, Machine: "node-2", Start: true, Reasons: []string{"machine is Stopped and will be started"}},
+		{Action: ActionUpdate, Machine: "node-3", Reasons: []string{"machine was created outside of the spec and will be adopted"}},
+		{Action: ActionCreate, Machine: "node-4", Reasons: []string{"machine does not exist"}},
+		{Action: ActionDelete, Machine: "node-5", Reasons: []string{"machine is no longer in the spec"}},
+	}
+	if !reflect.DeepEqual(plan.Changes, expected) {
+		t.Errorf("Expected:\n%+v\ngot:\n%+v", expected, plan.Changes)
+	}
+
+	out := &bytes.Buffer{}
+	plan.Print(out)
+	if !strings.Contains(out.String(), "-/+ replace node-1") || !strings.Contains(out.String(), "Plan: 1 to create, 2 to update, 1 to replace, 1 to delete.") {
+		t.Errorf("Unexpected output:\n%s", out.String())
+	}
+
+	inPlace := desired.Machines[0].Fingerprint()
+	inPlace["protect"] = "true"
+	plan = Diff(desired, []Current{{Name: "node-1", Driver: "digitalocean", State: "Running", Applied: inPlace}})
+	expected = []Change{
+		{Action: ActionUpdate, Machine: "node-1", Options: []string{"protect"}, Reasons: []string{`protect change from "true" to "" is applied in place`}},
+	}
+	if !reflect.DeepEqual(plan.Changes[:1], expected) {
+		t.Errorf("Expected:\n%+v\ngot:\n%+v", expected, plan.Changes)
+	}
+
+	current = []Current{{Name: "unmanaged", Driver: "libvirt", State: "Running"}}
+	for _, m := range desired.Machines {
+		current = append(current, Current{Name: m.Name, Driver: m.Driver, State: "Running", Applied: m.Fingerprint()})
+	}
+	if plan := Diff(desired, current); !plan.Empty() {
+		t.Errorf("Expected no changes, got %+v", plan.Changes)
+	}
+}
+
+func TestKeepProtected(t *testing.T) {
+	desired := Spec{Machines: []Machine{{Name: "web-1", Pool: "web"}, {Name: "web-2", Pool: "web"}, {Name: "db-1", Pool: "db"}}}
+	pools := map[string]string{"web-1": "web", "web-2": "web", "web-3": "web", "web-4": "web", "db-1": "db", "db-2": "db"}
+	protected := map[string]bool{"web-3": true, "db-2": true}
+
+	plan := &Plan{Spec: desired, Changes: []Change{
+		{Action: ActionUpdate, Machine: "web-2", Reasons: []string{"machine is Stopped and will be started"}},
+		{Action: ActionDelete, Machine: "web-3", Reasons: []string{"machine is no longer in the spec"}},
+		{Action: ActionDelete, Machine: "web-4", Reasons: []string{"machine is no longer in the spec"}},
+	}}
+	plan.KeepProtected(pools, protected)
+	expected := []Change{
+		{Action: ActionDelete, Machine: "web-4", Reasons: []string{"machine is no longer in the spec"}},
+		{Action: ActionDelete, Machine: "web-2", Reasons: []string{"pool web shrinks by it instead of the protected web-3"}},
+	}
+	if !reflect.DeepEqual(plan.Changes, expected) {
+		t.Errorf("Expected:\n%+v\ngot:\n%+v", expected, plan.Changes)
+	}
+
+	// The protected machine makes up for the one the plan would create.
+	delete(pools, "db-1")
+	plan = &Plan{Spec: desired, Changes: []Change{
+		{Action: ActionCreate, Machine: "db-1", Reasons: []string{"machine does not exist"}},
+		{Action: ActionDelete, Machine: "db-2", Reasons: []string{"machine is no longer in the spec"}},
+	}}
+	if plan.KeepProtected(pools, protected); !plan.Empty() {
+		t.Errorf("Expected no changes, got %+v", plan.Changes)
+	}
+}
+
+func TestSaveAndLoadPlan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "plan.json")
+
+	desired, err := Parse([]byte(testSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := Diff(desired, nil)
+	if err := plan.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadPlan(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.SameChanges(plan) {
+		t.Errorf("Expected %+v, got %+v", plan.Changes, loaded.Changes)
+	}
+	if !Diff(&loaded.Spec, nil).SameChanges(plan) {
+		t.Error("Expected the loaded spec to plan the same changes")
+	}
+	if m, ok := loaded.Machine("node-2"); !ok || formatValue(m.Options["libvirt-memory"]) != "2048" {
+		t.Errorf("Unexpected machine %+v", m)
+	}
+}
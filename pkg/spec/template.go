@@ -0,0 +1,362 @@
+package spec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/kubermatic/kube-machine/pkg/claims"
+	"github.com/kubermatic/kube-machine/pkg/cron"
+	"github.com/kubermatic/kube-machine/pkg/placement"
+	"github.com/kubermatic/kube-machine/pkg/topology"
+)
+
+// Template is a partial machine which machines and other templates extend.
+// Options of the extending machine override the ones of the template.
+type Template struct {
+	Name       string                 `json:"name"`
+	Extends    string                 `json:"extends,omitempty"`
+	Driver     string                 `json:"driver,omitempty"`
+	Options    map[string]interface{} `json:"options,omitempty"`
+	Variables  map[string]interface{} `json:"variables,omitempty"`
+	Zones      []string               `json:"zones,omitempty"`
+	Placement  *placement.Config      `json:"placement,omitempty"`
+	Registries *Registries            `json:"registries,omitempty"`
+}
+
+// expand replaces the machines with the machines they describe after
+// applying their templates and substituting the variables. Expanded specs
+// have no templates, so plans contain the resulting machines only.
+func (s *Spec) expand() error {
+	if len(s.Machines) == 0 {
+		return errors.New("The spec contains no machines")
+	}
+
+	templates := map[string]Template{}
+	for i, t := range s.Templates {
+		if t.Name == "" {
+			return fmt.Errorf("Template %d has no name", i)
+		}
+		if _, ok := templates[t.Name]; ok {
+			return fmt.Errorf("Template %s is specified more than once", t.Name)
+		}
+		templates[t.Name] = t
+	}
+
+	extra, err := s.claimedExtra()
+	if err != nil {
+		return err
+	}
+
+	machines := []Machine{}
+	for i, m := range s.Machines {
+		base, err := resolveTemplate(templates, m.Extends, nil)
+		if err != nil {
+			return fmt.Errorf("Machine %d: %v", i, err)
+		}
+
+		variables := merge(s.Variables, base.Variables)
+		variables = merge(variables, m.Variables)
+		unexpanded := Machine{
+			Name:    m.Name,
+			Driver:  base.Driver,
+			Options: merge(base.Options, m.Options),
+		}
+		if m.Driver != "" {
+			unexpanded.Driver = m.Driver
+		}
+
+		count, err := m.scaledCount(now())
+		if err != nil {
+			return fmt.Errorf("Machine %s: %v", m.Name, err)
+		}
+		count += extra[i]
+		if m.Pool != "" {
+			unexpanded.Pool = m.Pool
+			unexpanded.Options["engine-label"] = withLabel(unexpanded.Options["engine-label"], "pool="+m.Pool)
+		}
+
+		registries := m.Registries
+		if registries == nil {
+			registries = base.Registries
+		}
+		if registries != nil {
+			if err := registries.apply(unexpanded.Options); err != nil {
+				return fmt.Errorf("Machine %s: %v", m.Name, err)
+			}
+		}
+
+		zones := m.Zones
+		if len(zones) == 0 {
+			zones = base.Zones
+		}
+		zoneOption, ok := topology.ZoneOption(unexpanded.Driver)
+		if len(zones) > 0 && !ok {
+			return fmt.Errorf("Machine %s: driver %s doesn't support zones", m.Name, unexpanded.Driver)
+		}
+		placementConfig := m.Placement
+		if placementConfig == nil {
+			placementConfig = base.Placement
+		}
+		if placementConfig != nil {
+			if len(zones) < 2 {
+				return fmt.Errorf("Machine %s: placement requires at least two zones", m.Name)
+			}
+			if err := placementConfig.Validate(); err != nil {
+				return fmt.Errorf("Machine %s: %v", m.Name, err)
+			}
+		}
+
+		for index := 1; index <= count; index++ {
+			variables["index"] = index
+			// The zones of placed machines are chosen when planning.
+			if len(zones) > 0 && placementConfig == nil {
+				zone := zones[(index-1)%len(zones)]
+				variables["zone"] = zone
+				unexpanded.Options[zoneOption] = zone
+			}
+			expanded, err := unexpanded.substitute(variables)
+			if err != nil {
+				return fmt.Errorf("Machine %s: %v", m.Name, err)
+			}
+			if placementConfig != nil {
+				expanded.Zones, expanded.Placement = zones, placementConfig
+			}
+			machines = append(machines, expanded)
+		}
+	}
+
+	s.Variables = nil
+	s.Templates = nil
+	s.Machines = machines
+	return nil
+}
+
+// claimedExtra returns the machines to add to the entries of the spec so
+// the pools have at least the machines claimed of them. They are added to
+// the last entry of each pool.
+func (s *Spec) claimedExtra() (map[int]int, error) {
+	counts := map[string]int{}
+	last := map[string]int{}
+	for i, m := range s.Machines {
+		if m.Pool == "" {
+			continue
+		}
+		count, err := m.scaledCount(now())
+		if err != nil {
+			return nil, fmt.Errorf("Machine %s: %v", m.Name, err)
+		}
+		counts[m.Pool] += count
+		last[m.Pool] = i
+	}
+	extra := map[int]int{}
+	for pool, claimed := range claims.Demand(s.Claims) {
+		i, ok := last[pool]
+		if ok && claimed > counts[pool] {
+			extra[i] = claimed - counts[pool]
+		}
+	}
+	return extra, nil
+}
+
+// scalingLookback limits how far back the schedules of scalings are
+// evaluated, so scalings need to fire at least once a year.
+const scalingLookback = 366 * 24 * time.Hour
+
+// now is replaced by tests.
+var now = time.Now
+
+// scaledCount returns the count of the machine at the given time, which is
+// the count of the scaling that fired last or the count of the machine if
+// none fired.
+func (m Machine) scaledCount(at time.Time) (int, error) {
+	count := m.Count
+	if count == 0 {
+		count = 1
+	}
+	if count < 0 {
+		return 0, errors.New("negative count")
+	}
+	if len(m.Scaling) > 0 && m.Pool == "" {
+		return 0, errors.New("scaling requires a pool")
+	}
+
+	maxCount := count
+	var last time.Time
+	for _, scaling := range m.Scaling {
+		if scaling.Count < 0 {
+			return 0, fmt.Errorf("negative count of scaling %q", scaling.Schedule)
+		}
+		if scaling.Count > maxCount {
+			maxCount = scaling.Count
+		}
+
+		schedule, err := cron.Parse(scaling.Schedule)
+		if err != nil {
+			return 0, err
+		}
+		if fired, ok := schedule.Prev(at, scalingLookback); ok && fired.After(last) {
+			last, count = fired, scaling.Count
+		}
+	}
+
+	if maxCount > 1 && !strings.Contains(m.Name, ".index") {
+		return 0, errors.New("the machine has a count but its name doesn't contain {{ .index }}")
+	}
+	return count, nil
+}
+
+// withLabel adds the label to the engine-label option unless it is set.
+func withLabel(option interface{}, label string) []interface{} {
+	var labels []interface{}
+	switch option := option.(type) {
+	case []interface{}:
+		labels = append(labels, option...)
+	case string:
+		labels = append(labels, option)
+	}
+
+	key := strings.SplitN(label, "=", 2)[0] + "="
+	for _, l := range labels {
+		if s, ok := l.(string); ok && strings.HasPrefix(s, key) {
+			return labels
+		}
+	}
+	return append(labels, label)
+}
+
+// apply adds the registries to the engine options.
+func (r *Registries) apply(options map[string]interface{}) error {
+	for _, m := range r.Mirrors {
+		options["engine-registry-mirror"] = withValue(options["engine-registry-mirror"], m)
+	}
+	for _, i := range r.Insecure {
+		options["engine-insecure-registry"] = withValue(options["engine-insecure-registry"], i)
+	}
+	for _, a := range r.Auth {
+		if a.Registry == "" || a.Username == "" || a.PasswordFile == "" {
+			return errors.New("registry auth requires registry, username and passwordFile")
+		}
+		options["registry-auth"] = withValue(options["registry-auth"], fmt.Sprintf("%s=%s:%s", a.Registry, a.Username, a.PasswordFile))
+	}
+	return nil
+}
+
+func withValue(option interface{}, value string) []interface{} {
+	var values []interface{}
+	switch option := option.(type) {
+	case []interface{}:
+		values = append(values, option...)
+	case string:
+		values = append(values, option)
+	}
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+// resolveTemplate returns the template with the templates it extends
+// applied.
+func resolveTemplate(templates map[string]Template, name string, seen []string) (Template, error) {
+	if name == "" {
+		return Template{}, nil
+	}
+	for _, n := range seen {
+		if n == name {
+			return Template{}, fmt.Errorf("Templates extend each other: %s", strings.Join(append(seen, name), " -> "))
+		}
+	}
+	t, ok := templates[name]
+	if !ok {
+		return Template{}, fmt.Errorf("Unknown template %s", name)
+	}
+
+	base, err := resolveTemplate(templates, t.Extends, append(seen, name))
+	if err != nil {
+		return Template{}, err
+	}
+	if t.Driver != "" {
+		base.Driver = t.Driver
+	}
+	base.Options = merge(base.Options, t.Options)
+	base.Variables = merge(base.Variables, t.Variables)
+	if len(t.Zones) > 0 {
+		base.Zones = t.Zones
+	}
+	if t.Registries != nil {
+		base.Registries = t.Registries
+	}
+	return base, nil
+}
+
+func merge(base, overrides map[string]interface{}) map[string]interface{} {
+	merged := map[string]interface{}{}
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		merged[k] = v
+	}
+	return merged
+}
+
+// substitute returns the machine with the variables substituted in the name,
+// driver and string options.
+func (m Machine) substitute(variables map[string]interface{}) (Machine, error) {
+	name, err := substitute(m.Name, variables)
+	if err != nil {
+		return Machine{}, err
+	}
+	driver, err := substitute(m.Driver, variables)
+	if err != nil {
+		return Machine{}, err
+	}
+
+	expanded := Machine{Name: name, Driver: driver, Pool: m.Pool}
+	if len(m.Options) > 0 {
+		expanded.Options = map[string]interface{}{}
+	}
+	for k, v := range m.Options {
+		switch v := v.(type) {
+		case string:
+			if expanded.Options[k], err = substitute(v, variables); err != nil {
+				return Machine{}, fmt.Errorf("option %s: %v", k, err)
+			}
+		case []interface{}:
+			values := make([]interface{}, len(v))
+			for i, item := range v {
+				values[i] = item
+				if s, ok := item.(string); ok {
+					if values[i], err = substitute(s, variables); err != nil {
+						return Machine{}, fmt.Errorf("option %s: %v", k, err)
+					}
+				}
+			}
+			expanded.Options[k] = values
+		default:
+			expanded.Options[k] = v
+		}
+	}
+	return expanded, nil
+}
+
+func substitute(s string, variables map[string]interface{}) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	t, err := template.New("").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return "", err
+	}
+	out := &bytes.Buffer{}
+	if err := t.Execute(out, variables); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
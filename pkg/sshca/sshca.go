@@ -0,0 +1,182 @@
+package sshca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubermatic/kube-machine/pkg/remote"
+	"github.com/kubermatic/kube-machine/pkg/sshdconfig"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// TrustedCAPath is where nodes keep the public key of the CA.
+	TrustedCAPath = "/etc/ssh/kube-machine-ca.pub"
+	// DefaultValidity is how long session certificates are valid.
+	DefaultValidity = time.Hour
+
+	// clockSkew backdates certificates, so nodes with a clock slightly
+	// behind accept them.
+	clockSkew = time.Minute
+)
+
+// CA signs the SSH certificates of the sessions to the machines.
+type CA struct {
+	signer ssh.Signer
+}
+
+// Load reads the private key of the CA.
+func Load(path string) (*CA, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the SSH CA key: %v", err)
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse the SSH CA key %s: %v", path, err)
+	}
+	return &CA{signer: signer}, nil
+}
+
+// PublicKey returns the public key of the CA in authorized_keys format.
+func (ca *CA) PublicKey() string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(ca.signer.PublicKey())))
+}
+
+// Sign issues a user certificate of the key for the principals, valid from
+// now for the validity.
+func (ca *CA) Sign(pub ssh.PublicKey, keyID string, principals []string, validity time.Duration, now time.Time) (*ssh.Certificate, error) {
+	cert := &ssh.Certificate{
+		Key:             pub,
+		CertType:        ssh.UserCert,
+		KeyId:           keyID,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(now.Add(-clockSkew).Unix()),
+		ValidBefore:     uint64(now.Add(validity).Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: map[string]string{
+				"permit-pty":             "",
+				"permit-port-forwarding": "",
+			},
+		},
+	}
+	if err := cert.SignCert(rand.Reader, ca.signer); err != nil {
+		return nil, fmt.Errorf("Failed to sign the SSH certificate: %v", err)
+	}
+	return cert, nil
+}
+
+// Sessions hands out short-lived keys with certificates of the CA, one per
+// SSH user. The keys only exist in a temporary directory for the lifetime of
+// the command.
+type Sessions struct {
+	CA       *CA
+	Validity time.Duration
+	// KeyID identifies the operator in the logs of sshd.
+	KeyID string
+
+	mu      sync.Mutex
+	dir     string
+	expires map[string]time.Time
+	now     func() time.Time
+}
+
+// NewSessions creates the temporary directory of the session keys.
+func NewSessions(ca *CA, validity time.Duration, keyID string) (*Sessions, error) {
+	dir, err := ioutil.TempDir("", "kube-machine-ssh")
+	if err != nil {
+		return nil, err
+	}
+	return &Sessions{
+		CA:       ca,
+		Validity: validity,
+		KeyID:    keyID,
+		dir:      dir,
+		expires:  map[string]time.Time{},
+		now:      time.Now,
+	}, nil
+}
+
+// Key returns the path of the private key of the user, its certificate is
+// next to it as <key>-cert.pub where ssh looks for it. The key is replaced
+// once half of its validity passed. issued reports whether the call signed a
+// certificate or failed signing one.
+func (s *Sessions) Key(user string) (path string, issued bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path = filepath.Join(s.dir, user)
+	now := s.now()
+	if expires, ok := s.expires[user]; ok && now.Add(s.Validity/2).Before(expires) {
+		return path, false, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", true, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", true, err
+	}
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", true, err
+	}
+	cert, err := s.CA.Sign(pub, s.KeyID, []string{user}, s.Validity, now)
+	if err != nil {
+		return "", true, err
+	}
+
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return "", true, err
+	}
+	if err := ioutil.WriteFile(path+"-cert.pub", ssh.MarshalAuthorizedKey(cert), 0600); err != nil {
+		return "", true, err
+	}
+	s.expires[user] = now.Add(s.Validity)
+	return path, true, nil
+}
+
+// Close removes the session keys.
+func (s *Sessions) Close() error {
+	return os.RemoveAll(s.dir)
+}
+
+// TrustScript returns the shell script run as root which makes sshd accept
+// certificates of the CA. The directive is put first in sshd_config, so it
+// doesn't end up in a Match block, and only replaces the configuration once
+// sshd accepts it.
+func TrustScript(caPublicKey string) (string, error) {
+	write, err := remote.WriteFileCommand(TrustedCAPath, []byte(caPublicKey+"\n"), 0644)
+	if err != nil {
+		return "", err
+	}
+	directive := "TrustedUserCAKeys " + TrustedCAPath
+	return write + " && " + sshdconfig.EditScript(func(path string) string {
+		return fmt.Sprintf("(grep -qxF %s %s || sed -i %s %s)", remote.Quote(directive), path, remote.Quote("1i "+directive), path)
+	}), nil
+}
+
+// RevokeKeyScript returns the shell script run as the SSH user which removes
+// the public key from its authorized keys, once sessions with certificates
+// of the CA work. The key is matched by its base64 blob.
+func RevokeKeyScript(authorizedKey string) (string, error) {
+	fields := strings.Fields(authorizedKey)
+	if len(fields) < 2 {
+		return "", fmt.Errorf("Invalid public key %q", authorizedKey)
+	}
+	keys := "$HOME/.ssh/authorized_keys"
+	return fmt.Sprintf("! [ -f %[1]s ] || { grep -vF %[2]s %[1]s > %[1]s.kube-machine; chmod 600 %[1]s.kube-machine && mv -f %[1]s.kube-machine %[1]s; }",
+		keys, remote.Quote(fields[1])), nil
+}
@@ -0,0 +1,163 @@
+package sshca
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newCA(t *testing.T, dir string) *CA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "ca")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ca, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+func TestSessionsKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sshca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newCA(t, dir)
+
+	s, err := NewSessions(ca, time.Hour, "kube-machine alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	path, issued, err := s.Key("ubuntu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !issued {
+		t.Error("expected a certificate to be issued")
+	}
+	data, err := ioutil.ReadFile(path + "-cert.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		t.Fatalf("expected a certificate, got %T", pub)
+	}
+	if !bytes.Equal(cert.SignatureKey.Marshal(), ca.signer.PublicKey().Marshal()) {
+		t.Error("expected the certificate to be signed by the CA")
+	}
+	if cert.KeyId != "kube-machine alice" || len(cert.ValidPrincipals) != 1 || cert.ValidPrincipals[0] != "ubuntu" {
+		t.Errorf("unexpected certificate %+v", cert)
+	}
+	if expires := time.Unix(int64(cert.ValidBefore), 0); !expires.Equal(now.Add(time.Hour)) {
+		t.Errorf("expected the certificate to expire in an hour, got %s", expires)
+	}
+	if after := time.Unix(int64(cert.ValidAfter), 0); !after.Before(now) {
+		t.Errorf("expected the certificate to be valid now, valid after %s", after)
+	}
+	if _, err := ssh.ParsePrivateKey(mustRead(t, path)); err != nil {
+		t.Errorf("expected a valid private key: %v", err)
+	}
+
+	now = now.Add(20 * time.Minute)
+	if _, issued, err := s.Key("ubuntu"); err != nil || issued {
+		t.Fatalf("expected the certificate to be reused, issued %v: %v", issued, err)
+	}
+	if !bytes.Equal(mustRead(t, path+"-cert.pub"), data) {
+		t.Error("expected the certificate to be reused")
+	}
+	now = now.Add(20 * time.Minute)
+	if _, issued, err := s.Key("ubuntu"); err != nil || !issued {
+		t.Fatalf("expected the certificate to be renewed, issued %v: %v", issued, err)
+	}
+	if bytes.Equal(mustRead(t, path+"-cert.pub"), data) {
+		t.Error("expected the certificate to be renewed")
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the session keys to be removed, got %v", err)
+	}
+}
+
+func TestTrustScript(t *testing.T) {
+	script, err := TrustScript("ecdsa-sha2-nistp256 AAAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(script, TrustedCAPath) || !strings.Contains(script, "TrustedUserCAKeys") || !strings.Contains(script, "-t -f") {
+		t.Errorf("unexpected script %s", script)
+	}
+}
+
+func TestRevokeKeyScript(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell")
+	}
+	home, err := ioutil.TempDir("", "sshca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	if err := os.Mkdir(filepath.Join(home, ".ssh"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	keys := filepath.Join(home, ".ssh", "authorized_keys")
+	if err := ioutil.WriteFile(keys, []byte("ssh-rsa AAAAstatic machine\nssh-ed25519 AAAAother user\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	script, err := RevokeKeyScript("ssh-rsa AAAAstatic\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("/bin/sh", "-c", script)
+	cmd.Env = []string{"HOME=" + home}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if keys := string(mustRead(t, keys)); keys != "ssh-ed25519 AAAAother user\n" {
+		t.Errorf("expected only the static key to be removed, got %q", keys)
+	}
+	if _, err := RevokeKeyScript(""); err == nil {
+		t.Errorf("expected an empty key to fail")
+	}
+}
+
+func mustRead(t *testing.T, path string) []byte {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,33 @@
+// Package sshdconfig edits the sshd configuration of the nodes without
+// locking out SSH: an edit only replaces the configuration once sshd accepts
+// it.
+package sshdconfig
+
+import "fmt"
+
+const (
+	// Path is the sshd configuration of the nodes.
+	Path = "/etc/ssh/sshd_config"
+	// BackupPath keeps the configuration before the last edit.
+	BackupPath = Path + ".kube-machine-backup"
+
+	sshd   = "$(command -v sshd || echo /usr/sbin/sshd)"
+	reload = "(systemctl reload sshd || systemctl reload ssh || service ssh reload)"
+)
+
+// EditScript returns the shell script run as root applying the edit to a
+// copy of the configuration, the edit gets the path of the copy. The copy
+// is validated with sshd -t and atomically replaces the configuration,
+// which is backed up to BackupPath before. The backup is restored if sshd
+// fails to reload.
+func EditScript(edit func(path string) string) string {
+	return editScript(Path, sshd, reload, edit)
+}
+
+func editScript(path, sshd, reload string, edit func(path string) string) string {
+	tmp := path + ".kube-machine"
+	backup := path + ".kube-machine-backup"
+	return fmt.Sprintf("(cp -p %[1]s %[2]s && %[3]s && %[4]s -t -f %[2]s && cp -p %[1]s %[5]s && mv -f %[2]s %[1]s || { rm -f %[2]s; false; }) && "+
+		"(%[6]s || { cp -p %[5]s %[1]s; %[6]s; false; })",
+		path, tmp, edit(tmp), sshd, backup, reload)
+}
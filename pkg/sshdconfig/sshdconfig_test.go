@@ -0,0 +1,63 @@
+package sshdconfig
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestEditScript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sshdconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sshd_config")
+	if err := ioutil.WriteFile(path, []byte("PermitRootLogin yes\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// The fake sshd refuses configurations containing Invalid.
+	sshd := filepath.Join(dir, "sshd")
+	if err := ioutil.WriteFile(sshd, []byte("#!/bin/sh\n! grep -q Invalid \"$3\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	appendLine := func(line string) func(string) string {
+		return func(tmp string) string {
+			return fmt.Sprintf("echo %s >> %s", line, tmp)
+		}
+	}
+	run := func(edit func(string) string, reload string) error {
+		script := editScript(path, sshd, reload, edit)
+		return exec.Command("/bin/sh", "-c", script).Run()
+	}
+
+	if err := run(appendLine("Invalid"), "true"); err == nil {
+		t.Error("expected the invalid configuration to be refused")
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "PermitRootLogin yes\n" {
+		t.Errorf("expected the configuration to be kept, got %q", data)
+	}
+	if _, err := os.Stat(path + ".kube-machine"); !os.IsNotExist(err) {
+		t.Errorf("expected the edited copy to be removed, got %v", err)
+	}
+
+	if err := run(appendLine("PasswordAuthentication no"), "true"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "PermitRootLogin yes\nPasswordAuthentication no\n" {
+		t.Errorf("expected the edit to be applied, got %q", data)
+	}
+	if data, _ := ioutil.ReadFile(path + ".kube-machine-backup"); string(data) != "PermitRootLogin yes\n" {
+		t.Errorf("expected the previous configuration to be backed up, got %q", data)
+	}
+
+	if err := run(appendLine("MaxAuthTries 4"), "false"); err == nil {
+		t.Error("expected the failed reload to fail the edit")
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "PermitRootLogin yes\nPasswordAuthentication no\n" {
+		t.Errorf("expected the backup to be restored, got %q", data)
+	}
+}
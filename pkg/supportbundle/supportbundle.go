@@ -0,0 +1,146 @@
+package supportbundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/kubermatic/kube-machine/pkg/apilog"
+	"github.com/kubermatic/kube-machine/pkg/credentials"
+	"github.com/kubermatic/kube-machine/pkg/initsystem"
+)
+
+// DefaultSince is how far back the journals are collected by default.
+const DefaultSince = 24 * time.Hour
+
+// Runner runs a command on the node and returns its output also if it fails,
+// it is implemented by the SSH clients.
+type Runner interface {
+	Output(command string) (string, error)
+}
+
+// Item is a file of the bundle and the command collecting it.
+type Item struct {
+	Name    string
+	Command string
+	// Redact removes secrets from the output before it is bundled.
+	Redact func([]byte) []byte
+}
+
+// File is a collected file of the bundle.
+type File struct {
+	Name string
+	Data []byte
+}
+
+var units = []string{"kubelet", "docker", "containerd", "kube-apiserver-proxy"}
+
+// Items returns what is collected from a node, the logs of the services of
+// the last since read with the init system of the node. kubeconfigPath is
+// where the machine keeps its kubeconfig on disk.
+func Items(since time.Duration, kubeconfigPath string, service initsystem.InitSystem) []Item {
+	var items []Item
+	for _, unit := range units {
+		items = append(items,
+			Item{Name: "journal/" + unit + ".log", Command: service.LogsCommand(unit, since), Redact: RedactText},
+			Item{Name: "units/" + unit + ".service", Command: service.DefinitionCommand(unit), Redact: RedactText},
+		)
+	}
+	return append(items,
+		Item{
+			Name: "kubeconfig",
+			// Encrypted kubeconfigs are left out, they are only readable
+			// by the kubelet.
+			Command: fmt.Sprintf("sudo cat %s %s 2>/dev/null", kubeconfigPath, credentials.TmpfsKubeconfigPath),
+			Redact:  RedactKubeconfig,
+		},
+		Item{Name: "system/os-release", Command: "cat /etc/os-release"},
+		Item{Name: "system/uname", Command: "uname -a"},
+		Item{Name: "system/uptime", Command: "uptime"},
+		Item{Name: "system/memory", Command: "free -m"},
+		Item{Name: "network/addresses", Command: "ip addr"},
+		Item{Name: "network/routes", Command: "ip route && ip -6 route"},
+		Item{Name: "network/sockets", Command: "sudo ss -tulpn"},
+		Item{Name: "network/iptables", Command: "sudo iptables-save && sudo ip6tables-save"},
+		Item{Name: "network/nftables", Command: "sudo nft list ruleset"},
+		Item{Name: "network/resolv.conf", Command: "cat /etc/resolv.conf"},
+		Item{Name: "disk/usage", Command: "df -h && df -i"},
+		Item{Name: "disk/devices", Command: "lsblk"},
+		Item{Name: "disk/directories", Command: "sudo du -sh /var/lib/docker /var/lib/containerd /var/lib/kubelet /var/log 2>/dev/null"},
+	)
+}
+
+var secretPattern = regexp.MustCompile(`(?m)^(\s*-?\s*(?:client-key-data|client-key|token|password|id-token|refresh-token|access-token|secret)\s*:\s*)\S.*$`)
+
+// RedactKubeconfig replaces the private keys, tokens and passwords of a
+// kubeconfig, the certificates and endpoints are kept.
+func RedactKubeconfig(data []byte) []byte {
+	return secretPattern.ReplaceAll(data, []byte("${1}<redacted>"))
+}
+
+// RedactText replaces the tokens, passwords and keys of flags, environment
+// variables and logged requests, e.g. in the units and journals of the
+// kubelet.
+func RedactText(data []byte) []byte {
+	return []byte(apilog.Redact(string(data)))
+}
+
+// Collect runs the commands of the items on the node. A failing command
+// doesn't abort the collection, its error is appended to its output.
+func Collect(r Runner, items []Item) []File {
+	var files []File
+	for _, item := range items {
+		out, err := r.Output(item.Command)
+		data := []byte(out)
+		if item.Redact != nil {
+			data = item.Redact(data)
+		}
+		if err != nil {
+			data = append(data, fmt.Sprintf("\n# %s failed: %v\n", item.Command, err)...)
+		}
+		files = append(files, File{Name: item.Name, Data: data})
+	}
+	return files
+}
+
+// DirName returns the directory of the files in the bundle of a machine
+// collected at t.
+func DirName(machine string, t time.Time) string {
+	return fmt.Sprintf("%s-%s", machine, t.UTC().Format("20060102-150405"))
+}
+
+// FileName returns the name of a bundle of a machine collected at t. It has
+// a random suffix, so bundles collected within a second don't collide.
+func FileName(machine string, t time.Time) string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%s.tar.gz", DirName(machine, t), hex.EncodeToString(suffix))
+}
+
+// Write writes the files as gzipped tarball into a directory of the name.
+func Write(w io.Writer, dir string, files []File, modTime time.Time) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    dir + "/" + f.Name,
+			Mode:    0600,
+			Size:    int64(len(f.Data)),
+			ModTime: modTime,
+		}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(f.Data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,149 @@
+package supportbundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubermatic/kube-machine/pkg/initsystem"
+)
+
+type fakeRunner map[string]string
+
+func (r fakeRunner) Output(command string) (string, error) {
+	out, ok := r[command]
+	if !ok {
+		return "command not found", errors.New("exit status 127")
+	}
+	return out, nil
+}
+
+func TestRedactKubeconfig(t *testing.T) {
+	kubeconfig := `users:
+- name: kubelet
+  user:
+    client-certificate-data: Y2VydA==
+    client-key-data: a2V5
+- name: admin
+  user:
+    token: abc.def
+    password: secret
+`
+	redacted := string(RedactKubeconfig([]byte(kubeconfig)))
+	for _, secret := range []string{"a2V5", "abc.def", "secret\n"} {
+		if strings.Contains(redacted, secret) {
+			t.Errorf("expected %q to be redacted in %s", secret, redacted)
+		}
+	}
+	for _, kept := range []string{"client-certificate-data: Y2VydA==", "client-key-data: <redacted>", "token: <redacted>"} {
+		if !strings.Contains(redacted, kept) {
+			t.Errorf("expected %q in %s", kept, redacted)
+		}
+	}
+}
+
+func TestCollect(t *testing.T) {
+	r := fakeRunner{
+		"uname -a":       "Linux node 4.9.0",
+		"cat kubeconfig": "token: abc\n",
+	}
+	files := Collect(r, []Item{
+		{Name: "system/uname", Command: "uname -a"},
+		{Name: "kubeconfig", Command: "cat kubeconfig", Redact: RedactKubeconfig},
+		{Name: "missing", Command: "missing"},
+	})
+	if len(files) != 3 {
+		t.Fatalf("expected 3 files, got %d", len(files))
+	}
+	if string(files[0].Data) != "Linux node 4.9.0" {
+		t.Errorf("unexpected uname %q", files[0].Data)
+	}
+	if string(files[1].Data) != "token: <redacted>\n" {
+		t.Errorf("expected the token to be redacted, got %q", files[1].Data)
+	}
+	if !strings.Contains(string(files[2].Data), "missing failed: exit status 127") {
+		t.Errorf("expected the error in %q", files[2].Data)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	var b bytes.Buffer
+	files := []File{{Name: "journal/kubelet.log", Data: []byte("started")}, {Name: "disk/usage", Data: nil}}
+	if err := Write(&b, "node-1", files, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	gz, err := gzip.NewReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	var names []string
+	for {
+		h, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, h.Name)
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Name == "node-1/journal/kubelet.log" && string(data) != "started" {
+			t.Errorf("unexpected content %q", data)
+		}
+	}
+	if strings.Join(names, " ") != "node-1/journal/kubelet.log node-1/disk/usage" {
+		t.Errorf("unexpected files %v", names)
+	}
+}
+
+func TestItems(t *testing.T) {
+	service, err := initsystem.Get(initsystem.Systemd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kubelet string
+	for _, item := range Items(time.Hour, "/etc/kubeconfig", service) {
+		if item.Name == "journal/kubelet.log" {
+			kubelet = item.Command
+		}
+	}
+	if kubelet != "sudo journalctl -u kubelet --no-pager --since=-3600s" {
+		t.Errorf("unexpected journal command %q", kubelet)
+	}
+}
+
+func TestRedactText(t *testing.T) {
+	unit := `[Service]
+Environment="KUBELET_TOKEN=abc123"
+ExecStart=/usr/bin/kubelet --kubeconfig=/etc/kubernetes/kubelet.conf --token=s3cr3t --node-labels=role=worker
+`
+	redacted := string(RedactText([]byte(unit)))
+	for _, secret := range []string{"abc123", "s3cr3t"} {
+		if strings.Contains(redacted, secret) {
+			t.Errorf("expected %q to be redacted in %s", secret, redacted)
+		}
+	}
+	for _, kept := range []string{"--kubeconfig=/etc/kubernetes/kubelet.conf", "--node-labels=role=worker"} {
+		if !strings.Contains(redacted, kept) {
+			t.Errorf("expected %q in %s", kept, redacted)
+		}
+	}
+}
+
+func TestFileName(t *testing.T) {
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	a, b := FileName("node-1", now), FileName("node-1", now)
+	if a == b {
+		t.Errorf("expected bundles of the same second to have distinct names, got %s twice", a)
+	}
+	if !strings.HasPrefix(a, DirName("node-1", now)+"-") || !strings.HasSuffix(a, ".tar.gz") {
+		t.Errorf("unexpected name %s", a)
+	}
+}
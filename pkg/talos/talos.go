@@ -0,0 +1,154 @@
+// Package talos provisions Talos nodes, which have no SSH, by patching their
+// machine config with talosctl. The machine config makes the kubelet
+// register the node with the name of the machine, so the machine is tracked
+// in the store like the machines provisioned over SSH.
+package talos
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// KubeletImage is the repository of the kubelet images of Talos.
+const KubeletImage = "ghcr.io/siderolabs/kubelet"
+
+var (
+	tokenPattern   = regexp.MustCompile(`^[a-z0-9]{6}\.[a-z0-9]{16}$`)
+	versionPattern = regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+$`)
+)
+
+// Registration is how the kubelet of the node joins the cluster: Talos
+// nodes register with a bootstrap token and the cluster CA.
+type Registration struct {
+	Server string
+	CAData []byte
+	Token  string
+}
+
+// Validate checks that the node can register with the registration.
+func (r Registration) Validate() error {
+	if r.Server == "" {
+		return fmt.Errorf("The kubelet kubeconfig has no API server")
+	}
+	if len(r.CAData) == 0 {
+		return fmt.Errorf("The kubelet kubeconfig has no CA, the node could not verify the API server")
+	}
+	if !tokenPattern.MatchString(r.Token) {
+		return fmt.Errorf("Talos nodes register with a bootstrap token, the kubelet kubeconfig has none")
+	}
+	return nil
+}
+
+// Node is what kube-machine sets in the machine config of a node.
+type Node struct {
+	Name string
+	// KubeletVersion selects the kubelet image, Talos runs its default
+	// kubelet if empty.
+	KubeletVersion string
+	Labels         map[string]string
+}
+
+// ValidateVersion checks that the kubelet version names a release.
+func ValidateVersion(version string) error {
+	if version != "" && !versionPattern.MatchString(version) {
+		return fmt.Errorf("Invalid kubelet version %q, expected e.g. v1.30.2", version)
+	}
+	return nil
+}
+
+// Patch returns the strategic merge patch of the machine config of the
+// node. It is JSON, which talosctl reads as YAML.
+func Patch(node Node, registration Registration) ([]byte, error) {
+	if err := registration.Validate(); err != nil {
+		return nil, err
+	}
+	if err := ValidateVersion(node.KubeletVersion); err != nil {
+		return nil, err
+	}
+	machine := map[string]interface{}{
+		"network": map[string]interface{}{"hostname": node.Name},
+	}
+	if node.KubeletVersion != "" {
+		machine["kubelet"] = map[string]interface{}{"image": KubeletImage + ":" + node.KubeletVersion}
+	}
+	if len(node.Labels) > 0 {
+		machine["nodeLabels"] = node.Labels
+	}
+	return json.MarshalIndent(map[string]interface{}{
+		"machine": machine,
+		"cluster": map[string]interface{}{
+			"controlPlane": map[string]interface{}{"endpoint": registration.Server},
+			"ca":           map[string]interface{}{"crt": base64.StdEncoding.EncodeToString(registration.CAData)},
+			"token":        registration.Token,
+		},
+	}, "", "  ")
+}
+
+// Client applies machine configs with talosctl. The configs are contents
+// rather than paths, so any kube-machine with the store can provision the
+// nodes.
+type Client struct {
+	// BaseConfig is the machine config of talosctl gen config the patches
+	// of new nodes are applied to, e.g. the content of worker.yaml.
+	BaseConfig string
+	// Talosconfig is the client config authenticating talosctl with the
+	// Talos API of configured nodes, talosctl uses its default if empty.
+	Talosconfig string
+}
+
+// talosctl runs talosctl, it is replaced in tests.
+var talosctl = func(args ...string) error {
+	log.Debugf("Running talosctl %s", strings.Join(args, " "))
+	out, err := exec.Command("talosctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("talosctl %s failed (error: %v): %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Apply configures the node at the address with the patch. New nodes boot
+// into maintenance mode and get the patched base config, configured nodes
+// are patched through their authenticated Talos API.
+func (c Client) Apply(address string, patch []byte, initial bool) error {
+	dir, err := ioutil.TempDir("", "kube-machine-talos")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	patchPath := dir + "/patch.yaml"
+	if err := ioutil.WriteFile(patchPath, patch, 0600); err != nil {
+		return err
+	}
+
+	if !initial {
+		args := []string{"patch", "machineconfig", "--nodes", address, "--patch", "@" + patchPath}
+		if c.Talosconfig != "" {
+			talosconfigPath := dir + "/talosconfig"
+			if err := ioutil.WriteFile(talosconfigPath, []byte(c.Talosconfig), 0600); err != nil {
+				return err
+			}
+			args = append(args, "--talosconfig", talosconfigPath)
+		}
+		return talosctl(args...)
+	}
+	if c.BaseConfig == "" {
+		return fmt.Errorf("New Talos nodes need a base machine config")
+	}
+	basePath := dir + "/base.yaml"
+	if err := ioutil.WriteFile(basePath, []byte(c.BaseConfig), 0600); err != nil {
+		return err
+	}
+	configPath := dir + "/config.yaml"
+	if err := talosctl("machineconfig", "patch", basePath, "--patch", "@"+patchPath, "--output", configPath); err != nil {
+		return err
+	}
+	return talosctl("apply-config", "--insecure", "--nodes", address, "--file", configPath)
+}
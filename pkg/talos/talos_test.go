@@ -0,0 +1,109 @@
+package talos
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var registration = Registration{Server: "https://10.0.0.1:6443", CAData: []byte("ca"), Token: "abcdef.0123456789abcdef"}
+
+func TestPatch(t *testing.T) {
+	data, err := Patch(Node{Name: "worker-1", KubeletVersion: "v1.30.2", Labels: map[string]string{"pool": "edge"}}, registration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var patch struct {
+		Machine struct {
+			Network    struct{ Hostname string }
+			Kubelet    struct{ Image string }
+			NodeLabels map[string]string
+		}
+		Cluster struct {
+			ControlPlane struct{ Endpoint string }
+			CA           struct{ Crt string }
+			Token        string
+		}
+	}
+	if err := json.Unmarshal(data, &patch); err != nil {
+		t.Fatal(err)
+	}
+	if patch.Machine.Network.Hostname != "worker-1" || patch.Machine.Kubelet.Image != KubeletImage+":v1.30.2" || patch.Machine.NodeLabels["pool"] != "edge" {
+		t.Errorf("Unexpected machine patch %+v", patch.Machine)
+	}
+	if patch.Cluster.ControlPlane.Endpoint != registration.Server || patch.Cluster.CA.Crt != "Y2E=" || patch.Cluster.Token != registration.Token {
+		t.Errorf("Unexpected cluster patch %+v", patch.Cluster)
+	}
+
+	data, err = Patch(Node{Name: "worker-1"}, registration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "kubelet") || strings.Contains(string(data), "nodeLabels") {
+		t.Errorf("Expected the Talos default kubelet without labels, got %s", data)
+	}
+
+	for _, r := range []Registration{
+		{Server: registration.Server, CAData: registration.CAData},
+		{Server: registration.Server, CAData: registration.CAData, Token: "client-token"},
+		{Server: registration.Server, Token: registration.Token},
+	} {
+		if _, err := Patch(Node{Name: "worker-1"}, r); err == nil {
+			t.Errorf("Expected an error for %+v", r)
+		}
+	}
+	if _, err := Patch(Node{Name: "worker-1", KubeletVersion: "1.30"}, registration); err == nil {
+		t.Error("Expected an error for an invalid kubelet version")
+	}
+}
+
+func TestApply(t *testing.T) {
+	var calls [][]string
+	defer func(f func(...string) error) { talosctl = f }(talosctl)
+	// The configs are written to files for talosctl.
+	contents := map[string]string{}
+	talosctl = func(args ...string) error {
+		for _, arg := range args {
+			path := strings.TrimPrefix(arg, "@")
+			if !filepath.IsAbs(path) {
+				continue
+			}
+			// The output of talosctl doesn't exist.
+			if data, err := ioutil.ReadFile(path); err == nil {
+				contents[filepath.Base(path)] = string(data)
+			}
+		}
+		calls = append(calls, args)
+		return nil
+	}
+
+	c := Client{BaseConfig: "base config", Talosconfig: "client config"}
+	if err := c.Apply("10.0.0.2", []byte("patch"), true); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 2 || !reflect.DeepEqual(calls[0][:2], []string{"machineconfig", "patch"}) || !reflect.DeepEqual(calls[1][:4], []string{"apply-config", "--insecure", "--nodes", "10.0.0.2"}) {
+		t.Errorf("Unexpected talosctl calls for a new node %v", calls)
+	}
+	if expected := map[string]string{"base.yaml": "base config", "patch.yaml": "patch"}; !reflect.DeepEqual(contents, expected) {
+		t.Errorf("Expected the files %v, got %v", expected, contents)
+	}
+
+	calls = nil
+	contents = map[string]string{}
+	if err := c.Apply("10.0.0.2", []byte("patch"), false); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 1 || calls[0][0] != "patch" || calls[0][len(calls[0])-2] != "--talosconfig" {
+		t.Errorf("Unexpected talosctl calls for a configured node %v", calls)
+	}
+	if expected := map[string]string{"talosconfig": "client config", "patch.yaml": "patch"}; !reflect.DeepEqual(contents, expected) {
+		t.Errorf("Expected the files %v, got %v", expected, contents)
+	}
+
+	if err := (Client{}).Apply("10.0.0.2", []byte("patch"), true); err == nil {
+		t.Error("Expected an error for a new node without base config")
+	}
+}
@@ -0,0 +1,60 @@
+package cluster
+
+import (
+	"fmt"
+
+	"github.com/kubermatic/kube-machine/pkg/nodestore"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Lookup reads secrets and config maps from the cluster of the kubeconfig,
+// the client is only created once a template references a value.
+type Lookup struct {
+	Kubeconfig string
+
+	client kubernetes.Interface
+}
+
+func (l *Lookup) Secret(namespace, name, key string) (string, error) {
+	client, err := l.getClient()
+	if err != nil {
+		return "", err
+	}
+	secret, err := client.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("Failed to get secret %s/%s: %v", namespace, name, err)
+	}
+	value, ok := secret.Data[key]
+	if !ok {
+		return "", fmt.Errorf("Secret %s/%s has no key %s", namespace, name, key)
+	}
+	return string(value), nil
+}
+
+func (l *Lookup) ConfigMap(namespace, name, key string) (string, error) {
+	client, err := l.getClient()
+	if err != nil {
+		return "", err
+	}
+	cm, err := client.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("Failed to get config map %s/%s: %v", namespace, name, err)
+	}
+	value, ok := cm.Data[key]
+	if !ok {
+		return "", fmt.Errorf("Config map %s/%s has no key %s", namespace, name, key)
+	}
+	return value, nil
+}
+
+func (l *Lookup) getClient() (kubernetes.Interface, error) {
+	if l.client == nil {
+		client, err := nodestore.NewClient(l.Kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		l.client = client
+	}
+	return l.client, nil
+}
@@ -0,0 +1,213 @@
+package templates
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/kubermatic/kube-machine/pkg/remote"
+)
+
+// Lookup reads the cluster values referenced by templates.
+type Lookup interface {
+	Secret(namespace, name, key string) (string, error)
+	ConfigMap(namespace, name, key string) (string, error)
+}
+
+// sources are the functions returning cluster values, which must be escaped
+// by one of the escapers before they are written. b64dec is a source as well,
+// it would turn an encoded value back into raw bytes.
+var (
+	sources  = map[string]bool{"secret": true, "configMap": true, "b64dec": true}
+	escapers = map[string]bool{"quote": true, "systemdQuote": true, "b64enc": true, "sha256sum": true}
+)
+
+var (
+	errNoLookup  = errors.New("No cluster to read from")
+	errMultiLine = errors.New("A quoted value spans lines, which would add lines to the unit, use systemdQuote or b64enc")
+)
+
+// Funcs returns the functions available in the templates:
+//
+//	indent N S      indents all lines of S by N spaces
+//	b64enc S        base64 encodes S
+//	b64dec S        base64 decodes S, the result has to be escaped
+//	sha256sum S     returns the hex encoded SHA-256 of S
+//	quote S         quotes S as a single shell word, S must be a single line
+//	systemdQuote S  quotes S as a single argument of a unit command line
+//	secret NS N K   returns the key K of the secret N in namespace NS
+//	configMap NS N K returns the key K of the config map N in namespace NS
+func Funcs(lookup Lookup) template.FuncMap {
+	return template.FuncMap{
+		"indent":       indent,
+		"b64enc":       b64enc,
+		"b64dec":       b64dec,
+		"sha256sum":    sha256sum,
+		"quote":        quote,
+		"systemdQuote": systemdQuote,
+		"secret": func(namespace, name, key string) (string, error) {
+			if lookup == nil {
+				return "", errNoLookup
+			}
+			return lookup.Secret(namespace, name, key)
+		},
+		"configMap": func(namespace, name, key string) (string, error) {
+			if lookup == nil {
+				return "", errNoLookup
+			}
+			return lookup.ConfigMap(namespace, name, key)
+		},
+	}
+}
+
+// Parse parses a template with the functions of the lookup. It fails if a
+// secret or config map value is used without escaping, so values can't
+// inject directives or commands.
+func Parse(name, text string, lookup Lookup) (*template.Template, error) {
+	t, err := template.New(name).Option("missingkey=error").Funcs(Funcs(lookup)).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree == nil {
+			continue
+		}
+		if err := checkNode(tmpl.Tree.Root); err != nil {
+			return nil, fmt.Errorf("template %s: %v", name, err)
+		}
+	}
+	return t, nil
+}
+
+// Execute returns the output of the template.
+func Execute(t *template.Template, data interface{}) (string, error) {
+	out := &bytes.Buffer{}
+	if err := t.Execute(out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+}
+
+func b64enc(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func b64dec(s string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	return string(data), err
+}
+
+// quote quotes for a shell command of a unit. A newline would end the
+// command line of the unit even within quotes, so values spanning lines are
+// refused.
+func quote(s string) (string, error) {
+	if strings.ContainsAny(s, "\r\n") {
+		return "", errMultiLine
+	}
+	return remote.Quote(s), nil
+}
+
+// systemdQuoter escapes the characters systemd interprets in the command
+// lines of units: quotes and backslashes, environment variables and
+// specifiers. Line breaks are written as C escapes.
+var systemdQuoter = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"$", "$$",
+	"%", "%%",
+)
+
+// systemdQuote quotes s as a single argument of the command line of a unit.
+func systemdQuote(s string) string {
+	return `"` + systemdQuoter.Replace(s) + `"`
+}
+
+func sha256sum(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkNode rejects pipelines whose output is an unescaped cluster value,
+// also in conditions and variable declarations, whose values can be output
+// later.
+func checkNode(node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkNode(child); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		return checkPipe(n.Pipe)
+	case *parse.IfNode:
+		return checkBranch(&n.BranchNode)
+	case *parse.RangeNode:
+		return checkBranch(&n.BranchNode)
+	case *parse.WithNode:
+		return checkBranch(&n.BranchNode)
+	case *parse.TemplateNode:
+		return checkPipe(n.Pipe)
+	}
+	return nil
+}
+
+func checkBranch(n *parse.BranchNode) error {
+	if err := checkPipe(n.Pipe); err != nil {
+		return err
+	}
+	if err := checkNode(n.List); err != nil {
+		return err
+	}
+	return checkNode(n.ElseList)
+}
+
+func checkPipe(pipe *parse.PipeNode) error {
+	if pipe != nil && tainted(pipe) {
+		return fmt.Errorf("the secret, configMap or b64dec value in {{%s}} must be escaped with quote, systemdQuote, b64enc or sha256sum", pipe)
+	}
+	return nil
+}
+
+// tainted reports whether the output of the pipeline contains an unescaped
+// cluster value. The value of each command is passed on to the next one.
+func tainted(pipe *parse.PipeNode) bool {
+	t := false
+	for _, cmd := range pipe.Cmds {
+		fn := ""
+		if len(cmd.Args) > 0 {
+			if id, ok := cmd.Args[0].(*parse.IdentifierNode); ok {
+				fn = id.Ident
+			}
+		}
+		switch {
+		case sources[fn]:
+			t = true
+		case escapers[fn]:
+			t = false
+		default:
+			for _, arg := range cmd.Args {
+				if p, ok := arg.(*parse.PipeNode); ok && tainted(p) {
+					t = true
+				}
+			}
+		}
+	}
+	return t
+}
@@ -0,0 +1,97 @@
+package templates
+
+import (
+	"fmt"
+	"testing"
+)
+
+type fakeLookup map[string]string
+
+func (l fakeLookup) Secret(namespace, name, key string) (string, error) {
+	return l.value("secret", namespace, name, key)
+}
+
+func (l fakeLookup) ConfigMap(namespace, name, key string) (string, error) {
+	return l.value("configmap", namespace, name, key)
+}
+
+func (l fakeLookup) value(kind, namespace, name, key string) (string, error) {
+	v, ok := l[kind+"/"+namespace+"/"+name+"/"+key]
+	if !ok {
+		return "", fmt.Errorf("%s %s/%s has no key %s", kind, namespace, name, key)
+	}
+	return v, nil
+}
+
+func TestExecute(t *testing.T) {
+	lookup := fakeLookup{
+		"secret/kube-system/bootstrap-token-abcdef/token-secret": "0123456789abcdef",
+		"configmap/kube-system/kubelet/extra-args":               "--max-pods=50'; rm -rf /",
+		"configmap/kube-system/kubelet/multi-line":               "--v=2\nExecStartPre=/bin/rm -rf /var/lib/$HOME%n\\",
+	}
+	tests := []struct {
+		template, expected string
+	}{
+		{`--token={{ secret "kube-system" "bootstrap-token-abcdef" "token-secret" | quote }}`, `--token='0123456789abcdef'`},
+		{`{{ configMap "kube-system" "kubelet" "extra-args" | quote }}`, `'--max-pods=50'\''; rm -rf /'`},
+		{`{{ b64enc (secret "kube-system" "bootstrap-token-abcdef" "token-secret") }}`, "MDEyMzQ1Njc4OWFiY2RlZg=="},
+		{`{{ secret "kube-system" "bootstrap-token-abcdef" "token-secret" | sha256sum | printf "%.8s" }}`, "9f9f5111"},
+		{`{{ indent 2 .Name }}`, "  line 1\n  line 2"},
+		{`{{ b64dec "aGVsbG8=" | quote }}`, "'hello'"},
+		{`{{ configMap "kube-system" "kubelet" "multi-line" | systemdQuote }}`, `"--v=2\nExecStartPre=/bin/rm -rf /var/lib/$$HOME%%n\\"`},
+	}
+	for _, test := range tests {
+		tmpl, err := Parse("test", test.template, lookup)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.template, err)
+			continue
+		}
+		out, err := Execute(tmpl, map[string]string{"Name": "line 1\nline 2"})
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.template, err)
+			continue
+		}
+		if out != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.template, test.expected, out)
+		}
+	}
+}
+
+func TestParseRejectsUnescapedValues(t *testing.T) {
+	for _, text := range []string{
+		`{{ secret "ns" "name" "key" }}`,
+		`{{ configMap "ns" "name" "key" | indent 2 }}`,
+		`{{ quote "x" | secret "ns" "name" }}`,
+		`{{ printf "%s" (secret "ns" "name" "key") }}`,
+		`{{ $token := secret "ns" "name" "key" }}{{ $token }}`,
+		`{{ if true }}{{ secret "ns" "name" "key" }}{{ end }}`,
+		`{{ define "t" }}{{ secret "ns" "name" "key" }}{{ end }}`,
+		`{{ secret "ns" "name" "key" | b64enc | b64dec }}`,
+		`{{ b64dec "aGVsbG8=" }}`,
+	} {
+		if _, err := Parse("test", text, nil); err == nil {
+			t.Errorf("%s: expected an error", text)
+		}
+	}
+}
+
+func TestQuoteRejectsLines(t *testing.T) {
+	lookup := fakeLookup{"configmap/kube-system/kubelet/extra-args": "--v=2\nExecStartPre=/bin/sh -c 'rm -rf /'"}
+	tmpl, err := Parse("test", `ExecStart=/usr/bin/kubelet {{ configMap "kube-system" "kubelet" "extra-args" | quote }}`, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Execute(tmpl, nil); err == nil {
+		t.Error("expected quoting a value spanning lines to fail")
+	}
+}
+
+func TestSecretWithoutLookup(t *testing.T) {
+	tmpl, err := Parse("test", `{{ secret "ns" "name" "key" | quote }}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Execute(tmpl, nil); err == nil {
+		t.Error("expected an error without lookup")
+	}
+}
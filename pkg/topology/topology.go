@@ -0,0 +1,78 @@
+package topology
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// The standard labels of the failure domain of a node.
+const (
+	ZoneLabel   = "failure-domain.beta.kubernetes.io/zone"
+	RegionLabel = "failure-domain.beta.kubernetes.io/region"
+)
+
+type driverTopology struct {
+	// zoneOption is the create flag of the zone, it is empty for drivers
+	// without zones.
+	zoneOption string
+	// zoneField and regionField are the fields in the stored driver
+	// configuration.
+	zoneField, regionField string
+}
+
+var drivers = map[string]driverTopology{
+	"amazonec2":    {"amazonec2-zone", "Zone", "Region"},
+	"azure":        {"azure-availability-zone", "Zone", "Location"},
+	"digitalocean": {"", "", "Region"},
+	"exoscale":     {"exoscale-availability-zone", "AvailabilityZone", ""},
+	"google":       {"google-zone", "Zone", ""},
+	"openstack":    {"openstack-availability-zone", "AvailabilityZone", "Region"},
+}
+
+// ZoneOption returns the create flag selecting the zone of machines of the
+// driver. ok is false if the driver doesn't support zones.
+func ZoneOption(driver string) (option string, ok bool) {
+	option = drivers[driver].zoneOption
+	return option, option != ""
+}
+
+// Labels returns the topology labels of a machine from the JSON
+// configuration of its driver.
+func Labels(driver string, rawDriver []byte) map[string]string {
+	t, ok := drivers[driver]
+	if !ok {
+		return nil
+	}
+	config := map[string]interface{}{}
+	if err := json.Unmarshal(rawDriver, &config); err != nil {
+		return nil
+	}
+	zone, _ := config[t.zoneField].(string)
+	region, _ := config[t.regionField].(string)
+
+	switch driver {
+	case "amazonec2":
+		// The zone is configured as letter of the region.
+		if zone != "" {
+			zone = region + zone
+		}
+	case "azure":
+		if zone != "" {
+			zone = region + "-" + zone
+		}
+	case "google":
+		// Zones are named <region>-<letter>.
+		if i := strings.LastIndex(zone, "-"); i > 0 {
+			region = zone[:i]
+		}
+	}
+
+	labels := map[string]string{}
+	if zone != "" {
+		labels[ZoneLabel] = zone
+	}
+	if region != "" {
+		labels[RegionLabel] = region
+	}
+	return labels
+}
@@ -0,0 +1,33 @@
+package topology
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLabels(t *testing.T) {
+	tests := []struct {
+		driver string
+		config string
+		labels map[string]string
+	}{
+		{"amazonec2", `{"Region":"eu-central-1","Zone":"b"}`, map[string]string{ZoneLabel: "eu-central-1b", RegionLabel: "eu-central-1"}},
+		{"google", `{"Zone":"us-central1-f"}`, map[string]string{ZoneLabel: "us-central1-f", RegionLabel: "us-central1"}},
+		{"azure", `{"Location":"westeurope","Zone":""}`, map[string]string{RegionLabel: "westeurope"}},
+		{"digitalocean", `{"Region":"fra1"}`, map[string]string{RegionLabel: "fra1"}},
+		{"virtualbox", `{}`, nil},
+	}
+
+	for _, test := range tests {
+		if labels := Labels(test.driver, []byte(test.config)); !reflect.DeepEqual(labels, test.labels) {
+			t.Errorf("Expected labels %v for %s, got %v", test.labels, test.driver, labels)
+		}
+	}
+
+	if _, ok := ZoneOption("digitalocean"); ok {
+		t.Error("Expected digitalocean not to support zones")
+	}
+	if option, ok := ZoneOption("google"); !ok || option != "google-zone" {
+		t.Errorf("Unexpected zone option %q", option)
+	}
+}
@@ -0,0 +1,261 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The finished spans are exported in batches of up to batchSize spans once a
+// batch is full or exportInterval passed, at most maxQueueSize spans wait
+// for their export, further ones are dropped.
+const (
+	batchSize    = 512
+	maxQueueSize = 2048
+)
+
+var exportInterval = 5 * time.Second
+
+// Span is a timed operation of a trace. All methods are safe to call on a nil
+// span, which is returned while tracing is disabled.
+type Span struct {
+	tracer   *Tracer
+	traceID  string
+	spanID   string
+	parentID string
+	name     string
+	start    time.Time
+	end      time.Time
+
+	mu    sync.Mutex
+	attrs map[string]string
+	err   error
+}
+
+// Tracer collects the spans of one kube-machine invocation, which form a
+// single trace below the root span.
+type Tracer struct {
+	mu      sync.Mutex
+	root    *Span
+	spans   []*Span
+	dropped int
+	err     error
+	now     func() time.Time
+
+	endpoint string
+	full     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+var (
+	mu      sync.Mutex
+	current *Tracer
+)
+
+func newID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Enable starts a new trace with a root span of the given name, its spans
+// are exported to the OTLP endpoint while they finish.
+func Enable(rootName, endpoint string) *Span {
+	t := &Tracer{
+		now:      time.Now,
+		endpoint: endpoint,
+		full:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	t.root = t.newSpan(rootName, newID(16), "")
+	go t.run()
+
+	mu.Lock()
+	defer mu.Unlock()
+	current = t
+	return t.root
+}
+
+// run exports the finished spans once a batch is full or the export
+// interval passed, until the tracer is stopped.
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.full:
+		case <-ticker.C:
+		case <-t.stop:
+			return
+		}
+		t.export()
+	}
+}
+
+// export exports the finished spans in batches, the spans of a failed
+// export are dropped. The first error is kept for Flush.
+func (t *Tracer) export() {
+	spans := t.Finished()
+	for len(spans) > 0 {
+		n := len(spans)
+		if n > batchSize {
+			n = batchSize
+		}
+		if err := export(t.endpoint, spans[:n]); err != nil {
+			t.mu.Lock()
+			if t.err == nil {
+				t.err = err
+			}
+			t.mu.Unlock()
+		}
+		spans = spans[n:]
+	}
+}
+
+func (t *Tracer) newSpan(name, traceID, parentID string) *Span {
+	return &Span{
+		tracer:   t,
+		traceID:  traceID,
+		spanID:   newID(8),
+		parentID: parentID,
+		name:     name,
+		start:    t.now(),
+		attrs:    map[string]string{},
+	}
+}
+
+// Start starts a span below the root span. It returns nil if tracing is not
+// enabled.
+func Start(name string, attrs ...string) *Span {
+	mu.Lock()
+	t := current
+	mu.Unlock()
+	if t == nil {
+		return nil
+	}
+	return t.root.Child(name, attrs...)
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the span, the spans started with
+// StartContext from it are its children.
+func NewContext(ctx context.Context, s *Span) context.Context {
+	if s == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+// FromContext returns the span of the context, it is nil if the context
+// doesn't carry one.
+func FromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(contextKey{}).(*Span)
+	return s
+}
+
+// StartContext starts a span below the span of the context, or below the
+// root span if the context doesn't carry one, and returns a context
+// carrying the new span.
+func StartContext(ctx context.Context, name string, attrs ...string) (context.Context, *Span) {
+	var span *Span
+	if parent := FromContext(ctx); parent != nil {
+		span = parent.Child(name, attrs...)
+	} else {
+		span = Start(name, attrs...)
+	}
+	return NewContext(ctx, span), span
+}
+
+// Child starts a span below s, attrs are key/value pairs.
+func (s *Span) Child(name string, attrs ...string) *Span {
+	if s == nil {
+		return nil
+	}
+	child := s.tracer.newSpan(name, s.traceID, s.spanID)
+	child.SetAttributes(attrs...)
+	return child
+}
+
+// SetAttributes sets the key/value pairs as attributes of the span.
+func (s *Span) SetAttributes(attrs ...string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i+1 < len(attrs); i += 2 {
+		s.attrs[attrs[i]] = attrs[i+1]
+	}
+}
+
+// End finishes the span, a non-nil err marks the span as failed.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.end = s.tracer.now()
+	s.err = err
+	s.mu.Unlock()
+
+	t := s.tracer
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.spans) >= maxQueueSize {
+		t.dropped++
+		return
+	}
+	t.spans = append(t.spans, s)
+	if len(t.spans) >= batchSize {
+		select {
+		case t.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Finished returns the spans ended so far and removes them from the tracer.
+func (t *Tracer) Finished() []*Span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	spans := t.spans
+	t.spans = nil
+	return spans
+}
+
+// Flush stops the export of the current trace and exports its remaining
+// finished spans. It returns the first error exporting the spans of the
+// trace.
+func Flush() error {
+	mu.Lock()
+	t := current
+	current = nil
+	mu.Unlock()
+	if t == nil {
+		return nil
+	}
+
+	close(t.stop)
+	<-t.done
+	t.export()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return t.err
+	}
+	if t.dropped > 0 {
+		return fmt.Errorf("Dropped %d spans exceeding the export queue", t.dropped)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStartWithoutTracing(t *testing.T) {
+	mu.Lock()
+	current = nil
+	mu.Unlock()
+
+	span := Start("driver.Create")
+	if span != nil {
+		t.Fatal("Expected no span while tracing is disabled")
+	}
+	// Must not panic.
+	span.Child("ssh").End(nil)
+	if err := Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	var req otlpRequest
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	root := Enable("kube-machine create", server.URL)
+	span := Start("driver.Create", "driver", "libvirt", "machine", "node-1")
+	span.Child("ssh").End(errors.New("connection refused"))
+	span.End(nil)
+	root.End(nil)
+
+	if err := Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/v1/traces" {
+		t.Errorf("Unexpected path %q", path)
+	}
+
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 3 {
+		t.Fatalf("Expected 3 spans, got %d", len(spans))
+	}
+	ssh, create, kubeMachine := spans[0], spans[1], spans[2]
+	if ssh.ParentSpanID != create.SpanID || create.ParentSpanID != kubeMachine.SpanID || kubeMachine.ParentSpanID != "" {
+		t.Errorf("Unexpected span hierarchy: %+v", spans)
+	}
+	if len(create.TraceID) != 32 || ssh.TraceID != create.TraceID {
+		t.Errorf("Expected all spans to share the trace ID: %+v", spans)
+	}
+	if ssh.Status.Code != statusError || ssh.Status.Message != "connection refused" {
+		t.Errorf("Expected failed ssh span, got %+v", ssh.Status)
+	}
+	if len(create.Attributes) != 2 || create.Attributes[0].Key != "driver" || create.Attributes[0].Value.StringValue != "libvirt" {
+		t.Errorf("Unexpected attributes %+v", create.Attributes)
+	}
+
+	if err := Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStartContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	root := Enable("kube-machine serve", server.URL)
+	defer Flush()
+
+	ctx, request := StartContext(context.Background(), "api POST /v1/machines")
+	if request.parentID != root.spanID {
+		t.Errorf("Expected a span below the root span without a span in the context")
+	}
+	if FromContext(ctx) != request {
+		t.Errorf("Expected the context to carry the span")
+	}
+	_, create := StartContext(ctx, "driver.Create")
+	if create.parentID != request.spanID || create.traceID != root.traceID {
+		t.Errorf("Expected a span below the span of the context")
+	}
+}
+
+func TestExportBatches(t *testing.T) {
+	exported := make(chan int, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		exported <- len(req.ResourceSpans[0].ScopeSpans[0].Spans)
+	}))
+	defer server.Close()
+
+	root := Enable("kube-machine create", server.URL)
+	for i := 0; i < batchSize; i++ {
+		root.Child("ssh").End(nil)
+	}
+	select {
+	case n := <-exported:
+		if n != batchSize {
+			t.Errorf("Expected a batch of %d spans, got %d", batchSize, n)
+		}
+	case <-time.After(exportInterval / 2):
+		t.Fatal("Expected a full batch to be exported before the trace is flushed")
+	}
+
+	root.End(nil)
+	if err := Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := <-exported; n != 1 {
+		t.Errorf("Expected the remaining span to be exported by Flush, got %d", n)
+	}
+}
+
+func TestExportInterval(t *testing.T) {
+	interval := exportInterval
+	exportInterval = 10 * time.Millisecond
+	defer func() { exportInterval = interval }()
+
+	exported := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		exported <- struct{}{}
+	}))
+	defer server.Close()
+
+	root := Enable("kube-machine create", server.URL)
+	defer Flush()
+	Start("driver.Create").End(nil)
+	select {
+	case <-exported:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the finished span to be exported after the export interval")
+	}
+	root.End(nil)
+}
@@ -0,0 +1,50 @@
+package vanished
+
+import "github.com/docker/machine/libmachine/drivers"
+
+// DefaultThreshold is the number of consecutive checks a VM has to be missing
+// in before it is considered gone, so a single inconsistent read of the
+// provider API doesn't replace a machine.
+const DefaultThreshold = 3
+
+// Exists reports whether the VM of the driver exists. Only drivers telling
+// a deleted VM from failing requests of the provider API, e.g. a wrong
+// endpoint or expired credentials, report missing VMs. The existence is
+// unknown for the other drivers.
+func Exists(d drivers.Driver) (bool, error) {
+	r, ok := d.(drivers.VMReporter)
+	if !ok {
+		return false, drivers.ErrVMExistsNotSupported
+	}
+	return r.VMExists()
+}
+
+// Tracker counts the consecutive checks the VMs of the machines were missing
+// in.
+type Tracker struct {
+	threshold int
+	misses    map[string]int
+}
+
+func NewTracker(threshold int) *Tracker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &Tracker{threshold: threshold, misses: map[string]int{}}
+}
+
+// Observe records a check of the machine and returns true once its VM was
+// missing in threshold consecutive checks.
+func (t *Tracker) Observe(name string, exists bool) bool {
+	if exists {
+		delete(t.misses, name)
+		return false
+	}
+	t.misses[name]++
+	return t.misses[name] >= t.threshold
+}
+
+// Forget drops the checks of a machine, e.g. after it was replaced.
+func (t *Tracker) Forget(name string) {
+	delete(t.misses, name)
+}
@@ -0,0 +1,64 @@
+package vanished
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/docker/machine/drivers/fakedriver"
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/state"
+)
+
+type failingDriver struct {
+	*fakedriver.Driver
+	err error
+}
+
+func (d *failingDriver) GetState() (state.State, error) {
+	return state.None, d.err
+}
+
+type reportingDriver struct {
+	*fakedriver.Driver
+}
+
+func (d *reportingDriver) VMExists() (bool, error) {
+	return false, nil
+}
+
+func TestExists(t *testing.T) {
+	if exists, err := Exists(&reportingDriver{&fakedriver.Driver{MockState: state.Running}}); exists || err != nil {
+		t.Errorf("expected the reported existence, got %v, %v", exists, err)
+	}
+
+	for _, msg := range []string{
+		"InvalidInstanceID.NotFound: The instance ID 'i-1234' does not exist",
+		"GET https://api.example.com/v2/droplets/1: 404 page not found",
+		"dial tcp: i/o timeout",
+	} {
+		if _, err := Exists(&failingDriver{&fakedriver.Driver{}, errors.New(msg)}); err == nil {
+			t.Errorf("%s: expected the existence to be unknown without a report of the driver", msg)
+		}
+	}
+	if _, err := Exists(&fakedriver.Driver{MockState: state.Stopped}); err != drivers.ErrVMExistsNotSupported {
+		t.Errorf("expected the existence to be unknown, got %v", err)
+	}
+}
+
+func TestTracker(t *testing.T) {
+	tracker := NewTracker(2)
+	if tracker.Observe("node-1", false) {
+		t.Error("expected the first miss not to confirm the VM is gone")
+	}
+	tracker.Observe("node-1", true)
+	if tracker.Observe("node-1", false) {
+		t.Error("expected an existing VM to reset the misses")
+	}
+	if !tracker.Observe("node-1", false) {
+		t.Error("expected the VM to be gone after two consecutive misses")
+	}
+	tracker.Forget("node-1")
+	if tracker.Observe("node-1", false) {
+		t.Error("expected a forgotten machine to start over")
+	}
+}
@@ -0,0 +1,158 @@
+package volumes
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/kubermatic/kube-machine/pkg/remote"
+)
+
+const defaultFSType = "ext4"
+
+// Volume is an additional block device which is created together with the
+// machine, mounted by the provisioner and deleted together with the machine.
+type Volume struct {
+	// Name identifies the volume at the provider, it is set by the driver.
+	Name      string
+	SizeGB    int
+	MountPath string
+	FSType    string
+	// Device is the path of the block device on the machine, it is set by
+	// the driver.
+	Device string
+}
+
+// Parse parses a volume option of the form size=<GB>,mount=<path>[,fs=<type>].
+func Parse(spec string) (Volume, error) {
+	v := Volume{FSType: defaultFSType}
+	for _, kv := range strings.Split(spec, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return v, fmt.Errorf("invalid volume option %q, expected key=value", kv)
+		}
+		switch parts[0] {
+		case "size":
+			size, err := strconv.Atoi(strings.TrimSuffix(parts[1], "G"))
+			if err != nil || size <= 0 {
+				return v, fmt.Errorf("invalid volume size %q", parts[1])
+			}
+			v.SizeGB = size
+		case "mount":
+			v.MountPath = parts[1]
+		case "fs":
+			v.FSType = parts[1]
+		default:
+			return v, fmt.Errorf("unknown volume option %q", parts[0])
+		}
+	}
+
+	if v.SizeGB == 0 {
+		return v, fmt.Errorf("volume %q has no size", spec)
+	}
+	if !validMountPath(path.Clean(v.MountPath)) {
+		return v, fmt.Errorf("volume %q has no valid mount path", spec)
+	}
+	if !validFSType(v.FSType) {
+		return v, fmt.Errorf("unsupported volume filesystem %q", v.FSType)
+	}
+	v.MountPath = path.Clean(v.MountPath)
+	return v, nil
+}
+
+// validMountPath checks that the mount path is clean and can be written to
+// the fstab as is.
+func validMountPath(p string) bool {
+	return remote.ValidatePath(p) == nil && p != "/" && !strings.ContainsAny(p, " \t\n'\"\\$`")
+}
+
+func validFSType(fsType string) bool {
+	return fsType == "ext4" || fsType == "xfs"
+}
+
+// ParseAll parses all volume options of a machine.
+func ParseAll(specs []string) ([]Volume, error) {
+	var vols []Volume
+	mounts := map[string]bool{}
+	for _, spec := range specs {
+		v, err := Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		if mounts[v.MountPath] {
+			return nil, fmt.Errorf("multiple volumes are mounted to %s", v.MountPath)
+		}
+		mounts[v.MountPath] = true
+		vols = append(vols, v)
+	}
+	return vols, nil
+}
+
+type configGetter interface {
+	GetConfigRaw() ([]byte, error)
+}
+
+// FromDriver returns the volumes recorded in the configuration of the driver.
+// Drivers running as plugins are only reachable through their raw config.
+func FromDriver(d drivers.Driver) ([]Volume, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if g, ok := d.(configGetter); ok {
+		data, err = g.GetConfigRaw()
+	} else {
+		data, err = json.Marshal(d)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config := struct {
+		Volumes []Volume
+	}{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("Failed to read volumes from driver config: %v", err)
+	}
+	return config.Volumes, nil
+}
+
+var mountTemplate = template.Must(template.New("mount").Funcs(template.FuncMap{"quote": remote.Quote}).Parse(`set -e
+{{ range . -}}
+for i in $(seq 60); do [ -b {{ quote .Device }} ] && break; sleep 1; done
+blkid {{ quote .Device }} >/dev/null || mkfs.{{ .FSType }} {{ quote .Device }}
+mkdir -p {{ quote .MountPath }}
+uuid=$(blkid -s UUID -o value {{ quote .Device }})
+grep -q "^UUID=$uuid " /etc/fstab || echo "UUID=$uuid {{ .MountPath }} {{ .FSType }} defaults,nofail 0 2" >> /etc/fstab
+mountpoint -q {{ quote .MountPath }} || mount {{ quote .MountPath }}
+{{ end -}}
+`))
+
+// MountCommand returns a shell command formatting the volumes which don't
+// carry a filesystem yet and mounting all of them persistently. The volumes
+// are checked again, the driver config they are read from may be edited.
+func MountCommand(vols []Volume) (string, error) {
+	for _, v := range vols {
+		if v.Device == "" {
+			return "", fmt.Errorf("volume mounted to %s has no device", v.MountPath)
+		}
+		if err := remote.ValidatePath(v.Device); err != nil {
+			return "", fmt.Errorf("volume mounted to %s has an invalid device: %v", v.MountPath, err)
+		}
+		if !validMountPath(v.MountPath) || !validFSType(v.FSType) {
+			return "", fmt.Errorf("volume of %s has an invalid mount path or filesystem", v.Device)
+		}
+	}
+
+	script := &bytes.Buffer{}
+	if err := mountTemplate.Execute(script, vols); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("echo %s | base64 -d | sudo sh", base64.StdEncoding.EncodeToString(script.Bytes())), nil
+}
@@ -0,0 +1,102 @@
+package volumes
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/docker/machine/drivers/fakedriver"
+	"github.com/docker/machine/libmachine/drivers"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec string
+		vol  Volume
+		err  bool
+	}{
+		{spec: "size=50,mount=/var/lib/containerd", vol: Volume{SizeGB: 50, MountPath: "/var/lib/containerd", FSType: "ext4"}},
+		{spec: "mount=/var/lib/kubelet/,size=20G,fs=xfs", vol: Volume{SizeGB: 20, MountPath: "/var/lib/kubelet", FSType: "xfs"}},
+		{spec: "size=50", err: true},
+		{spec: "mount=/data", err: true},
+		{spec: "size=-1,mount=/data", err: true},
+		{spec: "size=10,mount=data", err: true},
+		{spec: "size=10,mount=/", err: true},
+		{spec: "size=10,mount=/da'ta", err: true},
+		{spec: "size=10,mount=/data,fs=ntfs", err: true},
+		{spec: "size=10,mount=/data,type=gp3", err: true},
+	}
+	for _, test := range tests {
+		vol, err := Parse(test.spec)
+		if (err != nil) != test.err {
+			t.Errorf("Parse(%q): unexpected error %v", test.spec, err)
+			continue
+		}
+		if !test.err && vol != test.vol {
+			t.Errorf("Parse(%q) = %+v, want %+v", test.spec, vol, test.vol)
+		}
+	}
+}
+
+func TestParseAllRejectsDuplicateMounts(t *testing.T) {
+	if _, err := ParseAll([]string{"size=10,mount=/data", "size=20,mount=/data/"}); err == nil {
+		t.Fatal("Expected an error for volumes with the same mount path")
+	}
+}
+
+type volumeDriver struct {
+	*fakedriver.Driver
+	Volumes []Volume
+}
+
+func TestFromDriver(t *testing.T) {
+	vols := []Volume{{Name: "vol-0", SizeGB: 10, MountPath: "/data", FSType: "ext4", Device: "/dev/vdb"}}
+	d := &volumeDriver{Driver: &fakedriver.Driver{BaseDriver: &drivers.BaseDriver{}}, Volumes: vols}
+
+	got, err := FromDriver(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != vols[0] {
+		t.Fatalf("Expected %+v, got %+v", vols, got)
+	}
+
+	got, err = FromDriver(d.Driver)
+	if err != nil || len(got) != 0 {
+		t.Fatalf("Expected no volumes, got %+v, %v", got, err)
+	}
+}
+
+func TestMountCommand(t *testing.T) {
+	if _, err := MountCommand([]Volume{{MountPath: "/data"}}); err == nil {
+		t.Fatal("Expected an error for a volume without device")
+	}
+	for _, v := range []Volume{
+		{MountPath: "/data", FSType: "ext4", Device: "/dev/../vdb"},
+		{MountPath: "/data\" /etc/passwd", FSType: "ext4", Device: "/dev/vdb"},
+		{MountPath: "/data", FSType: "ext4 && reboot", Device: "/dev/vdb"},
+	} {
+		if _, err := MountCommand([]Volume{v}); err == nil {
+			t.Errorf("Expected an error for %+v", v)
+		}
+	}
+
+	cmd, err := MountCommand([]Volume{{MountPath: "/var/lib/containerd", FSType: "xfs", Device: "/dev/vdb"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(cmd)
+	script, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"mkfs.xfs '/dev/vdb'",
+		"mkdir -p '/var/lib/containerd'",
+		"/var/lib/containerd xfs defaults,nofail 0 2",
+	} {
+		if !strings.Contains(string(script), expected) {
+			t.Errorf("Expected mount script to contain %q:\n%s", expected, script)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+// Package e2e tests kube-machine against a local cluster, it is built with
+// the e2e tag by make e2e.
+package e2e
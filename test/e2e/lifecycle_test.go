@@ -0,0 +1,80 @@
+//go:build e2e
+// +build e2e
+
+package e2e
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kubermatic/kube-machine/pkg/e2e"
+)
+
+// env returns the environment variable or the default.
+func env(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// TestLifecycle creates a kind or k3d cluster and tests the lifecycle of a
+// machine joining it. The machine is created with E2E_DRIVER, libvirt with
+// the image E2E_LIBVIRT_IMAGE by default, and the create flags
+// E2E_DRIVER_FLAGS, e.g. the SSH host of the generic driver. Machines reach
+// the cluster at E2E_HOST_ADDRESS, the gateway of the default libvirt
+// network by default.
+func TestLifecycle(t *testing.T) {
+	binary, err := filepath.Abs(env("KUBE_MACHINE_BINARY", "../../bin/kube-machine"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(binary); err != nil {
+		t.Fatalf("No kube-machine binary, run make build or set KUBE_MACHINE_BINARY: %v", err)
+	}
+	port, err := strconv.Atoi(env("E2E_API_SERVER_PORT", "6443"))
+	if err != nil {
+		t.Fatalf("Invalid E2E_API_SERVER_PORT: %v", err)
+	}
+
+	driver := env("E2E_DRIVER", "libvirt")
+	flags := strings.Fields(os.Getenv("E2E_DRIVER_FLAGS"))
+	if driver == "libvirt" && os.Getenv("E2E_LIBVIRT_IMAGE") != "" {
+		flags = append(flags, "--libvirt-image", os.Getenv("E2E_LIBVIRT_IMAGE"))
+	}
+
+	dir, err := ioutil.TempDir("", "kube-machine-e2e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cluster, err := e2e.CreateCluster(env("E2E_CLUSTER", e2e.ProviderKind), env("E2E_CLUSTER_NAME", "kube-machine-e2e"), env("E2E_HOST_ADDRESS", "192.168.122.1"), port, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if t.Failed() && os.Getenv("E2E_KEEP_CLUSTER") != "" {
+			t.Logf("Keeping cluster %s, kubeconfig %s", cluster.Name, cluster.Kubeconfig)
+			return
+		}
+		if err := cluster.Delete(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	km := &e2e.KubeMachine{
+		Binary:      binary,
+		StoragePath: filepath.Join(dir, "store"),
+		Kubeconfig:  cluster.Kubeconfig,
+	}
+	e2e.Lifecycle(t, cluster, km, e2e.Machine{
+		Name:   env("E2E_MACHINE", "e2e-node-1"),
+		Driver: driver,
+		Flags:  flags,
+	})
+}